@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/ical"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/gorilla/mux"
+)
+
+const calendarProdID = "-//MyEvents//Bookings//EN"
+
+type calendarLinkResponse struct {
+	URL    string `json:"url"`
+	Webcal string `json:"webcal"` //the same feed, for calendar apps that subscribe to webcal:// links
+}
+
+//feedToken is the secret that grants access to the calendar feed of a user. Calendar apps can't log
+//in, so the token is part of the feed URL. It is derived from the user ID, which means that nothing
+//has to be stored for it, and changing the feed secret invalidates every feed URL at once.
+func feedToken(secret []byte, userID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.ToLower(userID)))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+//calendarLinkHandler hands out the URL of the calendar feed of a user.
+func (bh *BookingHandler) calendarLinkHandler(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["userID"]
+	if _, err := hex.DecodeString(userID); err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", userID)
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	feed := fmt.Sprintf("%s/users/%s/bookings/calendar.ics?token=%s", r.Host, userID, feedToken(bh.calendarSecret, userID))
+
+	httpapi.JSON(w, http.StatusOK, &calendarLinkResponse{
+		URL:    scheme + "://" + feed,
+		Webcal: "webcal://" + feed,
+	})
+}
+
+//calendarFeedHandler serves the bookings of a user as a calendar feed. Every confirmed booking is an
+//entry of the feed. Bookings that are cancelled stay in the feed as cancelled entries, so that
+//calendar apps remove them instead of keeping them around.
+func (bh *BookingHandler) calendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["userID"]
+	token := r.URL.Query().Get("token")
+	if !hmac.Equal([]byte(token), []byte(feedToken(bh.calendarSecret, userID))) {
+		httpapi.Error(w, r, http.StatusForbidden, "invalid calendar token")
+		return
+	}
+	byteUserID, err := hex.DecodeString(userID)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", userID)
+		return
+	}
+	bookings, err := bh.database.FindBookingsByUserId(byteUserID)
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+
+	calendar := ical.Calendar{ProdID: calendarProdID, Name: "MyEvents bookings"}
+	events := map[string]persistence.Event{}
+	for _, bk := range bookings {
+		if bk.ConfirmedAt == 0 || (bk.Status != persistence.BookingConfirmed && bk.Status != persistence.BookingCancelled) {
+			continue
+		}
+		event, ok := events[bk.EventID]
+		if !ok {
+			eventID, err := hex.DecodeString(bk.EventID)
+			if err != nil {
+				continue
+			}
+			event, err = bh.database.FindEvent(eventID)
+			if err != nil {
+				continue
+			}
+			events[bk.EventID] = event
+		}
+		calendar.Events = append(calendar.Events, bookingCalendarEvent(bk, event))
+	}
+
+	w.Header().Set("Content-Type", "text/calendar;charset=utf-8")
+	w.Write(calendar.Encode())
+}
+
+//bookingCalendarEvent is the calendar entry of a booking. Cancelling the booking is a change of the
+//entry on top of the changes to the event, so it adds one to the sequence of the event.
+func bookingCalendarEvent(bk persistence.Booking, event persistence.Event) ical.Event {
+	description := fmt.Sprintf("%d seats", bk.Seats)
+	if bk.Seats == 1 {
+		description = "1 seat"
+	}
+	if bk.TicketType != "" {
+		description += ", " + bk.TicketType
+	}
+	if len(bk.SeatIDs) > 0 {
+		description += ": " + strings.Join(bk.SeatIDs, ", ")
+	}
+
+	entry := ical.Event{
+		UID:         hex.EncodeToString([]byte(bk.ID)) + "@bookings.myevents",
+		Sequence:    event.Sequence,
+		Summary:     event.Name,
+		Description: description,
+		Location:    event.Location.Venue(),
+		Start:       event.Start(),
+		End:         event.End(),
+		Cancelled:   event.CancelledAt > 0 || bk.Status == persistence.BookingCancelled,
+	}
+	if bk.Status == persistence.BookingCancelled {
+		entry.Sequence++
+	}
+	return entry
+}
@@ -0,0 +1,553 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/payments"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/tickets"
+)
+
+var (
+	ErrEventNotFound          = errors.New("event could not be loaded")
+	ErrBookingNotFound        = errors.New("booking could not be found")
+	ErrNotEnoughSeats         = errors.New("not enough seats left for this event")
+	ErrNotHeld                = errors.New("booking is not held or the hold has expired")
+	ErrNotCancellable         = errors.New("booking is neither held nor confirmed")
+	ErrCancelCutoff           = errors.New("booking can no longer be cancelled this close to the event")
+	ErrSeatsRequired          = errors.New("event has assigned seating, seats have to be picked")
+	ErrNoSeatMap              = errors.New("event has no assigned seating")
+	ErrInvalidSeats           = errors.New("seats have to exist in the hall and can only be picked once")
+	ErrTicketTypeRequired     = errors.New("event is sold through ticket types, a ticket type has to be picked")
+	ErrUnknownTicketType      = errors.New("event has no such ticket type")
+	ErrNotOnSale              = errors.New("ticket type is not on sale")
+	ErrTicketTypeSoldOut      = errors.New("not enough tickets of this type left")
+	ErrInvalidPromoCode       = errors.New("promo code does not exist for this event")
+	ErrPromoCodeExpired       = errors.New("promo code is not valid at this time")
+	ErrPromoCodeNotApplicable = errors.New("promo code does not apply to this ticket type")
+)
+
+//Manager takes bookings through their lifecycle: held -> confirmed -> cancelled, or held -> expired.
+//Every transition is persisted first and then announced to the other services with a contract, so
+//all state changes of a booking should go through the Manager.
+type Manager struct {
+	Database      persistence.DatabaseHandler
+	EventEmitter  msgqueue.EventEmitter
+	Payments      payments.PaymentProvider
+	TicketSigner  *tickets.Signer
+	Offers        Offerer       //books the seats offered to the waitlist; without it, nothing is offered
+	HoldDuration  time.Duration //how long a hold reserves its seats
+	OfferDuration time.Duration //how long a waitlist offer reserves its seats
+	CancelCutoff  time.Duration //how long before the event starts cancellations are refused
+
+	ctx context.Context //the request or delivery the transitions belong to, see WithContext
+}
+
+//WithContext returns a manager that stores and announces the transitions it makes as part of the
+//trace the context carries.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	bound := *m
+	bound.ctx = ctx
+	bound.Database = persistence.WithContext(ctx, m.Database)
+	bound.EventEmitter = msgqueue.WithContext(ctx, m.EventEmitter)
+	return &bound
+}
+
+//Hold reserves seats of an event for the given user. The booking is stored as held and has to be
+//confirmed before HoldDuration runs out, otherwise the sweeper releases the seats again.
+func (m *Manager) Hold(userID []byte, bk persistence.Booking) (persistence.Booking, error) {
+	event, err := m.findEvent(bk.EventID)
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+	if event.CancelledAt > 0 {
+		return persistence.Booking{}, ErrEventCancelled
+	}
+
+	//Events in halls with a seat map are booked by picking seats; the number of seats booked then
+	//simply follows from the seats picked.
+	if event.SeatMap != nil {
+		if len(bk.SeatIDs) == 0 {
+			return persistence.Booking{}, ErrSeatsRequired
+		}
+		if !validSeats(event.SeatMap, bk.SeatIDs) {
+			return persistence.Booking{}, ErrInvalidSeats
+		}
+		bk.Seats = len(bk.SeatIDs)
+	} else if len(bk.SeatIDs) > 0 {
+		return persistence.Booking{}, ErrNoSeatMap
+	}
+
+	//Seats that free up belong to the users on the waitlist first, so nobody can book past the
+	//people that are already waiting. Whether the seats fit into the capacity is up to hold.
+	if event.Capacity > 0 {
+		pending, err := m.hasPendingEntries(bk.EventID)
+		if err != nil {
+			return persistence.Booking{}, err
+		}
+		if pending {
+			return persistence.Booking{}, ErrNotEnoughSeats
+		}
+	}
+
+	return m.hold(userID, event, bk, m.HoldDuration)
+}
+
+func (m *Manager) hold(userID []byte, event persistence.Event, bk persistence.Booking, duration time.Duration) (persistence.Booking, error) {
+	now := time.Now()
+	bk, err := m.price(event, bk, now)
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+	booking := persistence.Booking{
+		UserID:      hex.EncodeToString(userID),
+		Date:        now.Unix(),
+		EventID:     bk.EventID,
+		Seats:       bk.Seats,
+		SeatIDs:     bk.SeatIDs,
+		TicketType:  bk.TicketType,
+		UnitPrice:   bk.UnitPrice,
+		PromoCode:   bk.PromoCode,
+		Discount:    bk.Discount,
+		Total:       bk.Total,
+		Currency:    bk.Currency,
+		Status:      persistence.BookingHeld,
+		HoldExpires: now.Add(duration).Unix(),
+		SagaID:      bk.SagaID,
+	}
+
+	//The seats are counted against the capacity of the event and the quota of the ticket type
+	//before anything else. The counts only go up while they stay within their limits, so two holds
+	//that come in at the same time can't both take the last seats.
+	if err := m.countSeats(event, booking); err != nil {
+		return persistence.Booking{}, err
+	}
+	//The use of a promo code is counted right away, and given back if the booking does not work
+	//out in the end.
+	if booking.PromoCode != "" {
+		err := m.Database.RedeemPromoCode([]byte(booking.EventID), booking.PromoCode, userID)
+		if err != nil {
+			m.uncountSeats(booking)
+			return persistence.Booking{}, err
+		}
+	}
+	//Assigned seats are reserved before the booking is stored. The reservation succeeds for all
+	//seats or for none of them, so two users can never end up holding the same seat.
+	if len(booking.SeatIDs) > 0 {
+		err := m.Database.ReserveSeats([]byte(booking.EventID), userID, booking.SeatIDs)
+		if err != nil {
+			m.uncountSeats(booking)
+			m.releasePromoCode(booking)
+			return persistence.Booking{}, err
+		}
+	}
+	id, err := m.Database.AddBookingForUser(userID, booking)
+	if err != nil {
+		m.releaseSeats(booking)
+		m.releasePromoCode(booking)
+		return persistence.Booking{}, err
+	}
+	booking.ID = string(id)
+
+	m.emit(&contracts.BookingHeldEvent{
+		ID:         hex.EncodeToString(id),
+		EventID:    booking.EventID,
+		UserID:     booking.UserID,
+		Seats:      booking.Seats,
+		SeatIDs:    booking.SeatIDs,
+		TicketType: booking.TicketType,
+		Total:      booking.Total,
+		Currency:   booking.Currency,
+		ExpiresAt:  booking.HoldExpires,
+	})
+	return booking, nil
+}
+
+//Confirm turns a hold into a confirmed booking, as long as the hold has not expired yet. Bookings
+//that cost money are confirmed by paying for them instead.
+func (m *Manager) Confirm(userID []byte, bookingID []byte) (persistence.Booking, error) {
+	bk, err := m.findBooking(userID, bookingID)
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+	if bk.Total > 0 {
+		return persistence.Booking{}, ErrPaymentRequired
+	}
+	return m.confirm(userID, bookingID, "")
+}
+
+func (m *Manager) confirm(userID []byte, bookingID []byte, paymentID string) (persistence.Booking, error) {
+	bk, err := m.findBooking(userID, bookingID)
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+
+	now := time.Now()
+	if bk.Status != persistence.BookingHeld || bk.HoldExpires <= now.Unix() {
+		return persistence.Booking{}, ErrNotHeld
+	}
+
+	bk.Status = persistence.BookingConfirmed
+	bk.ConfirmedAt = now.Unix()
+	bk.PaymentID = paymentID
+	err = m.Database.UpdateBookingForUser(userID, persistence.BookingHeld, bk)
+	if err == persistence.ErrBookingStatusChanged {
+		return persistence.Booking{}, ErrNotHeld
+	}
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+
+	m.emit(&contracts.BookingConfirmedEvent{
+		ID:          hex.EncodeToString(bookingID),
+		EventID:     bk.EventID,
+		UserID:      hex.EncodeToString(userID),
+		Seats:       bk.Seats,
+		ConfirmedAt: bk.ConfirmedAt,
+	})
+	//The users service keeps its own copy of every booking a user made. It only learns about a
+	//booking once it is confirmed, which is why we still emit the event.booked contract here.
+	m.emit(&contracts.EventBookedEvent{
+		ID:         hex.EncodeToString(bookingID),
+		EventID:    bk.EventID,
+		UserID:     hex.EncodeToString(userID),
+		Seats:      bk.Seats,
+		SeatIDs:    bk.SeatIDs,
+		TicketType: bk.TicketType,
+		UnitPrice:  bk.UnitPrice,
+		PromoCode:  bk.PromoCode,
+		Discount:   bk.Discount,
+		Total:      bk.Total,
+		Currency:   bk.Currency,
+		Date:       bk.Date,
+	})
+	m.settleWaitlistOffer(bk, persistence.WaitlistAccepted)
+	return bk, nil
+}
+
+//Cancel releases the seats of a held or confirmed booking and refunds what was paid for it. How
+//much is refunded depends on the cancellation policy of the event. Events without a policy refund
+//in full, but refuse cancellations once the event starts within CancelCutoff. If the refund fails,
+//the cancelled booking is returned together with the error.
+func (m *Manager) Cancel(userID []byte, bookingID []byte) (persistence.Booking, error) {
+	bk, err := m.findBooking(userID, bookingID)
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+	if !bk.Active() {
+		return persistence.Booking{}, ErrNotCancellable
+	}
+
+	now := time.Now()
+	refund := bk.Total - bk.Refunded()
+	//If the event cannot be loaded we do not know when it starts, and we rather let the user
+	//cancel than keep them stuck with a booking they don't want.
+	event, err := m.findEvent(bk.EventID)
+	if err == nil && event.StartDate > 0 {
+		beforeStart := time.Unix(event.StartDate, 0).Sub(now)
+		if (event.Policy == nil && beforeStart < m.CancelCutoff) || beforeStart <= 0 {
+			return persistence.Booking{}, ErrCancelCutoff
+		}
+		if event.Policy != nil {
+			refund = min64(refund, event.Policy.RefundFor(bk.Total, beforeStart))
+		}
+	}
+
+	status := bk.Status
+	bk.Status = persistence.BookingCancelled
+	bk.CancelledAt = now.Unix()
+	err = m.Database.UpdateBookingForUser(userID, status, bk)
+	if err == persistence.ErrBookingStatusChanged {
+		return persistence.Booking{}, ErrNotCancellable
+	}
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+
+	m.emit(&contracts.BookingCancelledEvent{
+		ID:          hex.EncodeToString(bookingID),
+		EventID:     bk.EventID,
+		UserID:      hex.EncodeToString(userID),
+		Seats:       bk.Seats,
+		CancelledAt: bk.CancelledAt,
+		SagaID:      bk.SagaID,
+	})
+	//The booking is cancelled either way, so its seats are given back even if the refund fails. A
+	//refund that was recorded but couldn't be made is retried by SweepPendingRefunds.
+	var refundErr error
+	if status == persistence.BookingConfirmed {
+		refundErr = m.refundBooking(bk, bk.PaymentID, refund, persistence.RefundCancelled)
+	} else {
+		m.releasePromoCode(bk)
+	}
+	m.releaseSeats(bk)
+	m.settleWaitlistOffer(bk, persistence.WaitlistLapsed)
+	m.offerFreedSeats(bk.EventID)
+	return bk, refundErr
+}
+
+//ExpireHolds releases all holds that ran out before now and returns the expired bookings. The
+//released seats are offered to the waitlists of the affected events.
+func (m *Manager) ExpireHolds(now time.Time) ([]persistence.Booking, error) {
+	expired, err := m.Database.ExpireBookingHolds(now.Unix())
+	events := map[string]bool{}
+	for _, bk := range expired {
+		m.emit(&contracts.BookingExpiredEvent{
+			ID:        hex.EncodeToString([]byte(bk.ID)),
+			EventID:   bk.EventID,
+			UserID:    bk.UserID,
+			Seats:     bk.Seats,
+			ExpiredAt: now.Unix(),
+			SagaID:    bk.SagaID,
+		})
+		m.releaseSeats(bk)
+		m.releasePromoCode(bk)
+		m.settleWaitlistOffer(bk, persistence.WaitlistLapsed)
+		events[bk.EventID] = true
+	}
+	for eventID := range events {
+		m.offerFreedSeats(eventID)
+	}
+	return expired, err
+}
+
+//SweepExpiredHolds runs ExpireHolds every interval. It never returns, so it should be started in
+//its own goroutine.
+func (m *Manager) SweepExpiredHolds(interval time.Duration) {
+	slog.Info("sweeping expired holds", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		expired, err := m.ExpireHolds(now)
+		if err != nil {
+			slog.Error("error while expiring holds", "error", err)
+		}
+		if len(expired) > 0 {
+			slog.Info("expired holds", "holds", len(expired))
+		}
+	}
+}
+
+//ReservedSeats returns the event together with the set of its seats that are currently reserved
+//by held or confirmed bookings.
+func (m *Manager) ReservedSeats(eventID string) (persistence.Event, map[string]bool, error) {
+	event, err := m.findEvent(eventID)
+	if err != nil {
+		return persistence.Event{}, nil, err
+	}
+	if event.SeatMap == nil {
+		return persistence.Event{}, nil, ErrNoSeatMap
+	}
+	reservations, err := m.Database.FindReservedSeats([]byte(eventID))
+	if err != nil {
+		return persistence.Event{}, nil, err
+	}
+	reserved := map[string]bool{}
+	for _, r := range reservations {
+		reserved[r.SeatID] = true
+	}
+	return event, reserved, nil
+}
+
+//freeSeats picks n seats of the event that are not reserved yet, in the order of the seat map.
+func (m *Manager) freeSeats(eventID string, seatMap *persistence.SeatMap, n int) ([]string, error) {
+	reservations, err := m.Database.FindReservedSeats([]byte(eventID))
+	if err != nil {
+		return nil, err
+	}
+	reserved := map[string]bool{}
+	for _, r := range reservations {
+		reserved[r.SeatID] = true
+	}
+	seats := []string{}
+	for _, seat := range seatMap.Seats() {
+		if len(seats) == n {
+			break
+		}
+		if !reserved[seat.ID] {
+			seats = append(seats, seat.ID)
+		}
+	}
+	if len(seats) < n {
+		return nil, ErrNotEnoughSeats
+	}
+	return seats, nil
+}
+
+//releaseSeats gives back the seats of a booking that no longer takes them: its assigned seats, and
+//its share of the seat counts.
+func (m *Manager) releaseSeats(bk persistence.Booking) {
+	m.uncountSeats(bk)
+	if len(bk.SeatIDs) == 0 {
+		return
+	}
+	if err := m.Database.ReleaseSeats([]byte(bk.EventID), bk.SeatIDs); err != nil {
+		slog.ErrorContext(m.ctx, "could not release seats of booking", "booking", hex.EncodeToString([]byte(bk.ID)), "error", err)
+	}
+}
+
+//countSeats adds the seats of a booking to the count of its event, which is limited by the
+//capacity of the event, and to the count of its ticket type, which is limited by the quota of the
+//type. Events and types without a limit are counted as well, so that releasing the seats again
+//never has to know whether they were counted.
+func (m *Manager) countSeats(event persistence.Event, bk persistence.Booking) error {
+	err := m.Database.ReserveSeatCount(bk.EventID, bk.Seats, event.Capacity)
+	if err == persistence.ErrCapacityExceeded {
+		return ErrNotEnoughSeats
+	}
+	if err != nil || bk.TicketType == "" {
+		return err
+	}
+	tt, _ := event.FindTicketType(bk.TicketType)
+	err = m.Database.ReserveSeatCount(ticketTypeKey(bk), bk.Seats, tt.Quota)
+	if err != nil {
+		m.Database.ReleaseSeatCount(bk.EventID, bk.Seats)
+	}
+	if err == persistence.ErrCapacityExceeded {
+		return ErrTicketTypeSoldOut
+	}
+	return err
+}
+
+//uncountSeats takes the seats of a booking off the counts countSeats added them to.
+func (m *Manager) uncountSeats(bk persistence.Booking) {
+	if err := m.Database.ReleaseSeatCount(bk.EventID, bk.Seats); err != nil {
+		slog.ErrorContext(m.ctx, "could not release seat count of booking", "booking", hex.EncodeToString([]byte(bk.ID)), "error", err)
+	}
+	if bk.TicketType == "" {
+		return
+	}
+	if err := m.Database.ReleaseSeatCount(ticketTypeKey(bk), bk.Seats); err != nil {
+		slog.ErrorContext(m.ctx, "could not release seat count of booking", "booking", hex.EncodeToString([]byte(bk.ID)), "type", bk.TicketType, "error", err)
+	}
+}
+
+func ticketTypeKey(bk persistence.Booking) string {
+	return bk.EventID + "#" + bk.TicketType
+}
+
+//releasePromoCode gives back the use of the promo code of a booking that did not work out.
+func (m *Manager) releasePromoCode(bk persistence.Booking) {
+	if bk.PromoCode == "" {
+		return
+	}
+	userID, _ := hex.DecodeString(bk.UserID)
+	if err := m.Database.ReleasePromoCode([]byte(bk.EventID), bk.PromoCode, userID); err != nil {
+		slog.ErrorContext(m.ctx, "could not release promo code", "event", bk.EventID, "code", bk.PromoCode, "error", err)
+	}
+}
+
+func validSeats(seatMap *persistence.SeatMap, seatIDs []string) bool {
+	seats := seatMap.SeatIDs()
+	picked := map[string]bool{}
+	for _, seatID := range seatIDs {
+		if picked[seatID] || !seats[seatID] {
+			return false
+		}
+		picked[seatID] = true
+	}
+	return true
+}
+
+func (m *Manager) findEvent(eventID string) (persistence.Event, error) {
+	id, err := hex.DecodeString(eventID)
+	if err != nil {
+		return persistence.Event{}, ErrEventNotFound
+	}
+	event, err := m.Database.FindEvent(id)
+	if err != nil {
+		return persistence.Event{}, ErrEventNotFound
+	}
+	return event, nil
+}
+
+func (m *Manager) findBooking(userID []byte, bookingID []byte) (persistence.Booking, error) {
+	bk, err := m.Database.FindBookingByBookingId(userID, bookingID)
+	if err != nil || bk.ID == "" {
+		return persistence.Booking{}, ErrBookingNotFound
+	}
+	return bk, nil
+}
+
+func (m *Manager) seatsTaken(eventID string) (int, error) {
+	bookings, err := m.Database.FindBookingsByEventId([]byte(eventID))
+	if err != nil {
+		return 0, err
+	}
+	taken := 0
+	for _, bk := range bookings {
+		if bk.Active() {
+			taken += bk.Seats
+		}
+	}
+	return taken, nil
+}
+
+//price fills in the unit price and the total of a booking. Events without ticket types are free.
+//For all other events a ticket type has to be picked, and it has to be on sale.
+func (m *Manager) price(event persistence.Event, bk persistence.Booking, now time.Time) (persistence.Booking, error) {
+	if len(event.TicketTypes) == 0 {
+		if bk.TicketType != "" {
+			return persistence.Booking{}, ErrUnknownTicketType
+		}
+		if bk.PromoCode != "" {
+			return persistence.Booking{}, ErrPromoCodeNotApplicable
+		}
+		bk.UnitPrice, bk.Discount, bk.Total, bk.Currency = 0, 0, 0, ""
+		return bk, nil
+	}
+
+	tt, err := findTicketType(event, bk.TicketType)
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+	if !tt.OnSale(now.Unix()) {
+		return persistence.Booking{}, ErrNotOnSale
+	}
+
+	bk.UnitPrice = tt.Price
+	bk.Total = tt.Price * int64(bk.Seats)
+	bk.Currency = tt.Currency
+	bk.Discount = 0
+	if bk.PromoCode != "" {
+		bk.PromoCode = persistence.NormalizePromoCode(bk.PromoCode)
+		pc, err := m.Database.FindPromoCode([]byte(bk.EventID), bk.PromoCode)
+		if err != nil {
+			return persistence.Booking{}, ErrInvalidPromoCode
+		}
+		if !pc.ValidAt(now.Unix()) {
+			return persistence.Booking{}, ErrPromoCodeExpired
+		}
+		if !pc.AppliesTo(tt) {
+			return persistence.Booking{}, ErrPromoCodeNotApplicable
+		}
+		bk.Discount = pc.DiscountOn(bk.Total)
+		bk.Total -= bk.Discount
+	}
+	return bk, nil
+}
+
+func findTicketType(event persistence.Event, name string) (persistence.TicketType, error) {
+	if name == "" {
+		return persistence.TicketType{}, ErrTicketTypeRequired
+	}
+	tt, ok := event.FindTicketType(name)
+	if !ok {
+		return persistence.TicketType{}, ErrUnknownTicketType
+	}
+	return tt, nil
+}
+
+//emit publishes a lifecycle contract. The transition itself is already persisted at this point,
+//so a failure to publish is logged instead of being reported back to the user.
+func (m *Manager) emit(event msgqueue.Event) {
+	if err := m.EventEmitter.Emit(event); err != nil {
+		slog.ErrorContext(m.ctx, "could not emit event", "event", event.EventName(), "error", err)
+	}
+}
@@ -0,0 +1,483 @@
+package lifecycle
+
+import (
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/payments"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/memlayer"
+	"github.com/doublen987/web_dev/MyEvents/lib/tickets"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type recordingEmitter struct {
+	mutex  sync.Mutex
+	events []msgqueue.Event
+}
+
+func (r *recordingEmitter) Emit(e msgqueue.Event) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+//count returns how often an event with the given name was emitted.
+func (r *recordingEmitter) count(name string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := 0
+	for _, e := range r.events {
+		if e.EventName() == name {
+			n++
+		}
+	}
+	return n
+}
+
+type testManager struct {
+	*Manager
+	db       *memlayer.MemoryLayer
+	emitter  *recordingEmitter
+	payments *payments.FakeProvider
+}
+
+func newTestManager() *testManager {
+	db := memlayer.NewMemoryLayer()
+	emitter := &recordingEmitter{}
+	provider := payments.NewFakeProvider("secret", "", 0)
+	signer, _ := tickets.GenerateSigner()
+	return &testManager{
+		Manager: &Manager{
+			Database:      db,
+			EventEmitter:  emitter,
+			Payments:      provider,
+			TicketSigner:  signer,
+			HoldDuration:  10 * time.Minute,
+			OfferDuration: 30 * time.Minute,
+			CancelCutoff:  time.Hour,
+		},
+		db:       db,
+		emitter:  emitter,
+		payments: provider,
+	}
+}
+
+//addEvent stores the event and returns its ID hex encoded, the way bookings refer to events.
+func (m *testManager) addEvent(t *testing.T, e persistence.Event) string {
+	id, err := m.db.AddEvent(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+func (m *testManager) addUser(t *testing.T) []byte {
+	id, err := m.db.AddUser(persistence.User{ID: string(bson.NewObjectId())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+//booking returns the booking as it is stored.
+func (m *testManager) booking(t *testing.T, userID []byte, bk persistence.Booking) persistence.Booking {
+	stored, err := m.db.FindBookingByBookingId(userID, []byte(bk.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+//slowDatabase takes a moment for every read and write of bookings, like a database across the
+//network does, which gives concurrent holds the chance to interleave.
+type slowDatabase struct {
+	*memlayer.MemoryLayer
+}
+
+func (s slowDatabase) FindBookingsByEventId(eventId []byte) ([]persistence.Booking, error) {
+	time.Sleep(time.Millisecond)
+	return s.MemoryLayer.FindBookingsByEventId(eventId)
+}
+
+func (s slowDatabase) AddBookingForUser(userId []byte, bk persistence.Booking) ([]byte, error) {
+	time.Sleep(time.Millisecond)
+	return s.MemoryLayer.AddBookingForUser(userId, bk)
+}
+
+var standard = persistence.TicketType{Name: "Standard", Price: 2000, Currency: "EUR"}
+
+//TestTransitions takes bookings through their lifecycle. Each step is applied to the booking in
+//turn, and leaves it in the given status.
+func TestTransitions(t *testing.T) {
+	type step struct {
+		action string
+		err    error
+		status string
+	}
+	cases := []struct {
+		name  string
+		price int64
+		steps []step
+	}{
+		{"free booking confirmed and cancelled", 0, []step{
+			{"confirm", nil, persistence.BookingConfirmed},
+			{"expire", nil, persistence.BookingConfirmed},
+			{"cancel", nil, persistence.BookingCancelled},
+			{"confirm", ErrNotHeld, persistence.BookingCancelled},
+		}},
+		{"paid booking confirmed and cancelled", 2000, []step{
+			{"confirm", ErrPaymentRequired, persistence.BookingHeld},
+			{"pay", nil, persistence.BookingConfirmed},
+			{"pay", ErrNotHeld, persistence.BookingConfirmed},
+			{"cancel", nil, persistence.BookingCancelled},
+			{"cancel", ErrNotCancellable, persistence.BookingCancelled},
+		}},
+		{"hold cancelled", 2000, []step{
+			{"cancel", nil, persistence.BookingCancelled},
+			{"pay", ErrNotHeld, persistence.BookingCancelled},
+		}},
+		{"hold expired", 2000, []step{
+			{"expire", nil, persistence.BookingExpired},
+			{"pay", ErrNotHeld, persistence.BookingExpired},
+			{"cancel", ErrNotCancellable, persistence.BookingExpired},
+		}},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		ticketType := persistence.TicketType{Name: standard.Name, Price: c.price, Currency: standard.Currency}
+		eventID := m.addEvent(t, persistence.Event{Capacity: 2, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{ticketType}})
+		userID := m.addUser(t)
+		bk, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: 2, TicketType: standard.Name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bk.Status != persistence.BookingHeld || bk.Total != 2*c.price {
+			t.Errorf("%s: expected a hold of %d, got %+v", c.name, 2*c.price, bk)
+		}
+		if _, err := m.Hold(m.addUser(t), persistence.Booking{EventID: eventID, Seats: 1, TicketType: standard.Name}); err != ErrNotEnoughSeats {
+			t.Errorf("%s: expected the event to be sold out, got %v", c.name, err)
+		}
+
+		for i, s := range c.steps {
+			var err error
+			switch s.action {
+			case "confirm":
+				_, err = m.Confirm(userID, []byte(bk.ID))
+			case "pay":
+				_, _, err = m.Pay(userID, []byte(bk.ID), "pm_card")
+			case "cancel":
+				_, err = m.Cancel(userID, []byte(bk.ID))
+			case "expire":
+				_, err = m.ExpireHolds(time.Now().Add(time.Hour))
+			}
+			if err != s.err {
+				t.Errorf("%s: step %d (%s): expected %v, got %v", c.name, i, s.action, s.err, err)
+			}
+			if stored := m.booking(t, userID, bk); stored.Status != s.status {
+				t.Errorf("%s: step %d (%s): expected the booking to be %s, got %s", c.name, i, s.action, s.status, stored.Status)
+			}
+		}
+
+		//Bookings that ended gave their seats back.
+		if _, err := m.Hold(m.addUser(t), persistence.Booking{EventID: eventID, Seats: 2, TicketType: standard.Name}); err != nil {
+			t.Errorf("%s: expected the seats to be free again, got %v", c.name, err)
+		}
+	}
+}
+
+func TestCancelCutoff(t *testing.T) {
+	cases := []struct {
+		name   string
+		before time.Duration
+		err    error
+	}{
+		{"before the cutoff", 2 * time.Hour, nil},
+		{"within the cutoff", 30 * time.Minute, ErrCancelCutoff},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		eventID := m.addEvent(t, persistence.Event{Capacity: 2, StartDate: time.Now().Add(c.before).Unix()})
+		userID := m.addUser(t)
+		bk, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Cancel(userID, []byte(bk.ID)); err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestPricing(t *testing.T) {
+	vip := persistence.TicketType{Name: "VIP", Price: 9000, Currency: "EUR", Quota: 4}
+	now := time.Now()
+	cases := []struct {
+		name        string
+		ticketTypes []persistence.TicketType
+		booking     persistence.Booking
+		err         error
+		total       int64
+	}{
+		{"standard tickets", []persistence.TicketType{standard, vip}, persistence.Booking{Seats: 3, TicketType: "Standard"}, nil, 6000},
+		{"vip tickets within the quota", []persistence.TicketType{standard, vip}, persistence.Booking{Seats: 2, TicketType: "VIP"}, nil, 18000},
+		{"vip tickets past the quota", []persistence.TicketType{standard, vip}, persistence.Booking{Seats: 3, TicketType: "VIP"}, ErrTicketTypeSoldOut, 0},
+		{"no ticket type picked", []persistence.TicketType{standard, vip}, persistence.Booking{Seats: 1}, ErrTicketTypeRequired, 0},
+		{"unknown ticket type", []persistence.TicketType{standard, vip}, persistence.Booking{Seats: 1, TicketType: "Student"}, ErrUnknownTicketType, 0},
+		{"ticket type of a free event", nil, persistence.Booking{Seats: 1, TicketType: "Standard"}, ErrUnknownTicketType, 0},
+		{"free event", nil, persistence.Booking{Seats: 1}, nil, 0},
+		{"sales not started", []persistence.TicketType{{Name: "Early", Price: 1000, Currency: "EUR", SalesStart: now.Add(time.Hour).Unix()}}, persistence.Booking{Seats: 1, TicketType: "Early"}, ErrNotOnSale, 0},
+		{"sales ended", []persistence.TicketType{{Name: "Early", Price: 1000, Currency: "EUR", SalesEnd: now.Add(-time.Hour).Unix()}}, persistence.Booking{Seats: 1, TicketType: "Early"}, ErrNotOnSale, 0},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		eventID := m.addEvent(t, persistence.Event{Capacity: 50, TicketTypes: c.ticketTypes})
+		//Two of the VIP tickets are sold already.
+		if _, found := (persistence.Event{TicketTypes: c.ticketTypes}).FindTicketType("VIP"); found {
+			if _, err := m.Hold(m.addUser(t), persistence.Booking{EventID: eventID, Seats: 2, TicketType: "VIP"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		bk := c.booking
+		bk.EventID = eventID
+		bk, err := m.Hold(m.addUser(t), bk)
+		if err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		if err == nil && bk.Total != c.total {
+			t.Errorf("%s: expected a total of %d, got %d", c.name, c.total, bk.Total)
+		}
+	}
+}
+
+//hall is a seat map with a single row of three seats: Stalls-A-1 to Stalls-A-3.
+var hall = &persistence.SeatMap{Sections: []persistence.Section{{Name: "Stalls", Rows: []persistence.Row{{Name: "A", Seats: []persistence.Seat{{Number: 1}, {Number: 2}, {Number: 3}}}}}}}
+
+//TestAssignedSeats picks seats of an event whose first seat is already held by someone else.
+func TestAssignedSeats(t *testing.T) {
+	cases := []struct {
+		name    string
+		seatMap *persistence.SeatMap
+		seats   []string
+		err     error
+	}{
+		{"free seats", hall, []string{"Stalls-A-2", "Stalls-A-3"}, nil},
+		{"one seat taken", hall, []string{"Stalls-A-2", "Stalls-A-1"}, persistence.ErrSeatTaken},
+		{"seat not in the hall", hall, []string{"Stalls-A-2", "Stalls-B-1"}, ErrInvalidSeats},
+		{"seat picked twice", hall, []string{"Stalls-A-2", "Stalls-A-2"}, ErrInvalidSeats},
+		{"no seats picked", hall, nil, ErrSeatsRequired},
+		{"event without seat map", nil, []string{"Stalls-A-2"}, ErrNoSeatMap},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		eventID := m.addEvent(t, persistence.Event{Capacity: 3, SeatMap: c.seatMap})
+		taken := []string{"Stalls-A-1"}
+		if c.seatMap == nil {
+			taken = nil
+		}
+		if _, err := m.Hold(m.addUser(t), persistence.Booking{EventID: eventID, Seats: 1, SeatIDs: taken}); err != nil {
+			t.Fatal(err)
+		}
+
+		bk, err := m.Hold(m.addUser(t), persistence.Booking{EventID: eventID, SeatIDs: c.seats})
+		if err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		if err == nil && bk.Seats != len(c.seats) {
+			t.Errorf("%s: expected %d seats to be booked, got %d", c.name, len(c.seats), bk.Seats)
+		}
+		if c.seatMap == nil {
+			continue
+		}
+
+		//Seats are reserved all or nothing: a failed hold leaves the free seats it picked free.
+		_, reserved, err := m.ReservedSeats(eventID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 1
+		if c.err == nil {
+			want += len(c.seats)
+		}
+		if len(reserved) != want || !reserved["Stalls-A-1"] {
+			t.Errorf("%s: expected %d reserved seats, got %v", c.name, want, reserved)
+		}
+	}
+}
+
+//TestPromoCodeLimits holds bookings with a code that can be used twice overall and once per user.
+func TestPromoCodeLimits(t *testing.T) {
+	m := newTestManager()
+	eventID := m.addEvent(t, persistence.Event{Capacity: 10, TicketTypes: []persistence.TicketType{standard}})
+	if err := m.db.AddPromoCode(persistence.PromoCode{EventID: eventID, Code: "SPRING", Kind: persistence.DiscountFixed, Amount: 500, Currency: "EUR", MaxUses: 2, MaxUsesPerUser: 1}); err != nil {
+		t.Fatal(err)
+	}
+	first, second := m.addUser(t), m.addUser(t)
+	cases := []struct {
+		name   string
+		userID []byte
+		code   string
+		err    error
+		uses   int
+	}{
+		{"first use", first, "spring", nil, 1},
+		{"same user again", first, "SPRING", persistence.ErrPromoCodeUserLimit, 1},
+		{"unknown code", first, "WINTER", ErrInvalidPromoCode, 1},
+		{"other user", second, "SPRING", nil, 2},
+		{"used up", m.addUser(t), "SPRING", persistence.ErrPromoCodeUsedUp, 2},
+	}
+	for _, c := range cases {
+		bk, err := m.Hold(c.userID, persistence.Booking{EventID: eventID, Seats: 1, TicketType: standard.Name, PromoCode: c.code})
+		if err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		if err == nil && (bk.Discount != 500 || bk.Total != 1500) {
+			t.Errorf("%s: expected a discount of 500, got %+v", c.name, bk)
+		}
+		pc, err := m.db.FindPromoCode([]byte(eventID), "SPRING")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pc.Uses != c.uses {
+			t.Errorf("%s: expected %d uses of the code, got %d", c.name, c.uses, pc.Uses)
+		}
+	}
+}
+
+//TestPromoCodeRelease ends bookings that used a promo code in different ways. Bookings that were
+//never paid give the use of the code back, paid ones keep it.
+func TestPromoCodeRelease(t *testing.T) {
+	cases := []struct {
+		name string
+		paid bool
+		end  func(m *testManager, userID []byte, bk persistence.Booking) error
+		uses int
+	}{
+		{"held and cancelled", false, func(m *testManager, userID []byte, bk persistence.Booking) error {
+			_, err := m.Cancel(userID, []byte(bk.ID))
+			return err
+		}, 0},
+		{"held and expired", false, func(m *testManager, userID []byte, bk persistence.Booking) error {
+			_, err := m.ExpireHolds(time.Now().Add(time.Hour))
+			return err
+		}, 0},
+		{"held when the event is cancelled", false, func(m *testManager, userID []byte, bk persistence.Booking) error {
+			_, err := m.CancelEvent(bk.EventID, time.Now().Unix())
+			return err
+		}, 0},
+		{"paid and cancelled", true, func(m *testManager, userID []byte, bk persistence.Booking) error {
+			_, err := m.Cancel(userID, []byte(bk.ID))
+			return err
+		}, 1},
+		{"paid when the event is cancelled", true, func(m *testManager, userID []byte, bk persistence.Booking) error {
+			_, err := m.CancelEvent(bk.EventID, time.Now().Unix())
+			return err
+		}, 1},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		eventID := m.addEvent(t, persistence.Event{Capacity: 10, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+		if err := m.db.AddPromoCode(persistence.PromoCode{EventID: eventID, Code: "SPRING", Kind: persistence.DiscountPercent, Percent: 10}); err != nil {
+			t.Fatal(err)
+		}
+		userID := m.addUser(t)
+		bk, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: 1, TicketType: standard.Name, PromoCode: "SPRING"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bk.Total != 1800 {
+			t.Errorf("%s: expected the discount to apply, got a total of %d", c.name, bk.Total)
+		}
+		if c.paid {
+			if bk, _, err = m.Pay(userID, []byte(bk.ID), "pm_card"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := c.end(m, userID, bk); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		pc, err := m.db.FindPromoCode([]byte(eventID), "SPRING")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pc.Uses != c.uses {
+			t.Errorf("%s: expected %d uses of the code, got %d", c.name, c.uses, pc.Uses)
+		}
+	}
+}
+
+func TestConcurrentHoldsDoNotOversell(t *testing.T) {
+	cases := []struct {
+		name    string
+		event   persistence.Event
+		booking persistence.Booking
+		want    int
+		err     error
+	}{
+		{
+			name:    "capacity of the event",
+			event:   persistence.Event{Capacity: 5},
+			booking: persistence.Booking{Seats: 1},
+			want:    5,
+			err:     ErrNotEnoughSeats,
+		},
+		{
+			name:    "quota of the ticket type",
+			event:   persistence.Event{Capacity: 50, TicketTypes: []persistence.TicketType{{Name: "VIP", Price: 9000, Currency: "EUR", Quota: 4}, standard}},
+			booking: persistence.Booking{Seats: 2, TicketType: "VIP"},
+			want:    2,
+			err:     ErrTicketTypeSoldOut,
+		},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		m.Database = slowDatabase{m.db}
+		eventID := m.addEvent(t, c.event)
+		users := [][]byte{}
+		for i := 0; i < 20; i++ {
+			users = append(users, m.addUser(t))
+		}
+
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		held := []persistence.Booking{}
+		for _, userID := range users {
+			wg.Add(1)
+			go func(userID []byte) {
+				defer wg.Done()
+				bk := c.booking
+				bk.EventID = eventID
+				bk, err := m.Hold(userID, bk)
+				if err != nil && err != c.err {
+					t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+				}
+				if err == nil {
+					mutex.Lock()
+					held = append(held, bk)
+					mutex.Unlock()
+				}
+			}(userID)
+		}
+		wg.Wait()
+		if len(held) != c.want {
+			t.Fatalf("%s: expected %d holds, got %d", c.name, c.want, len(held))
+		}
+
+		//Cancelling a hold gives its seats back to the counts.
+		userID, _ := hex.DecodeString(held[0].UserID)
+		if _, err := m.Cancel(userID, []byte(held[0].ID)); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		bk := c.booking
+		bk.EventID = eventID
+		if _, err := m.Hold(users[0], bk); err != nil {
+			t.Errorf("%s: expected the cancelled seats to be held again, got %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package lifecycle
+
+import (
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/payments"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+var (
+	ErrPaymentRequired = errors.New("booking has to be paid before it is confirmed")
+	ErrNothingToPay    = errors.New("booking is free and can be confirmed directly")
+	ErrPaymentDeclined = errors.New("payment was declined")
+	ErrUnknownPayment  = errors.New("payment does not belong to a booking")
+)
+
+//Pay collects the total of a held booking through the payment provider. The booking is confirmed
+//as soon as the payment succeeds. Payments that take a while to settle leave the booking held, and
+//SettlePayment confirms it once the provider reports the outcome.
+func (m *Manager) Pay(userID []byte, bookingID []byte, method string) (persistence.Booking, payments.Intent, error) {
+	bk, err := m.findBooking(userID, bookingID)
+	if err != nil {
+		return persistence.Booking{}, payments.Intent{}, err
+	}
+	if bk.Status != persistence.BookingHeld || bk.HoldExpires <= time.Now().Unix() {
+		return persistence.Booking{}, payments.Intent{}, ErrNotHeld
+	}
+	if bk.Total == 0 {
+		return persistence.Booking{}, payments.Intent{}, ErrNothingToPay
+	}
+
+	intent, err := m.Payments.CreateIntent(payments.IntentRequest{
+		Amount:   bk.Total,
+		Currency: bk.Currency,
+		Method:   method,
+		Metadata: map[string]string{
+			"userId":    hex.EncodeToString(userID),
+			"bookingId": hex.EncodeToString(bookingID),
+			"eventId":   bk.EventID,
+		},
+	})
+	if err != nil {
+		return persistence.Booking{}, payments.Intent{}, err
+	}
+	intent, err = m.Payments.Capture(intent.ID)
+	if err != nil {
+		return persistence.Booking{}, payments.Intent{}, err
+	}
+
+	if intent.Status == payments.StatusProcessing {
+		return bk, intent, nil
+	}
+	bk, err = m.SettlePayment(intent)
+	return bk, intent, err
+}
+
+//SettlePayment applies the outcome of a payment to the booking it paid for. A successful payment
+//confirms the booking. If the hold ran out or was cancelled before the money arrived, the payment
+//is refunded right away instead. Providers deliver the outcome at least once, so settling the same
+//payment again changes nothing.
+func (m *Manager) SettlePayment(intent payments.Intent) (persistence.Booking, error) {
+	userID, err := hex.DecodeString(intent.Metadata["userId"])
+	if err != nil {
+		return persistence.Booking{}, ErrUnknownPayment
+	}
+	bookingID, err := hex.DecodeString(intent.Metadata["bookingId"])
+	if err != nil {
+		return persistence.Booking{}, ErrUnknownPayment
+	}
+
+	now := time.Now()
+	switch intent.Status {
+	case payments.StatusSucceeded:
+		bk, err := m.findBooking(userID, bookingID)
+		if err != nil {
+			return persistence.Booking{}, err
+		}
+		if bk.PaymentID == intent.ID {
+			//The payment confirmed the booking already.
+			return bk, nil
+		}
+		if _, ok := bk.FindRefund(persistence.RefundID(bk.ID, intent.ID, persistence.RefundLatePayment)); ok {
+			//The payment came too late and its refund is recorded; it is retried if still pending.
+			return bk, m.lateRefund(bk, intent)
+		}
+
+		m.emit(&contracts.PaymentSucceededEvent{
+			ID:          intent.ID,
+			BookingID:   intent.Metadata["bookingId"],
+			EventID:     intent.Metadata["eventId"],
+			UserID:      intent.Metadata["userId"],
+			Amount:      intent.Amount,
+			Currency:    intent.Currency,
+			SucceededAt: now.Unix(),
+		})
+		confirmed, err := m.confirm(userID, bookingID, intent.ID)
+		if err != ErrNotHeld {
+			return confirmed, err
+		}
+		//The hold ended, or the booking changed since we loaded it. Unless it was this very payment
+		//that confirmed it in the meantime, the payment goes back.
+		bk, err = m.findBooking(userID, bookingID)
+		if err != nil {
+			return persistence.Booking{}, err
+		}
+		if bk.PaymentID == intent.ID {
+			return bk, nil
+		}
+		return bk, m.lateRefund(bk, intent)
+	case payments.StatusFailed:
+		m.emit(&contracts.PaymentFailedEvent{
+			ID:        intent.ID,
+			BookingID: intent.Metadata["bookingId"],
+			EventID:   intent.Metadata["eventId"],
+			UserID:    intent.Metadata["userId"],
+			Amount:    intent.Amount,
+			Currency:  intent.Currency,
+			Reason:    intent.FailureReason,
+			FailedAt:  now.Unix(),
+		})
+		return persistence.Booking{}, ErrPaymentDeclined
+	}
+	return m.findBooking(userID, bookingID)
+}
+
+//lateRefund refunds a payment that arrived after the hold of its booking ended, and returns
+//ErrNotHeld unless the refund failed.
+func (m *Manager) lateRefund(bk persistence.Booking, intent payments.Intent) error {
+	if err := m.refundBooking(bk, intent.ID, intent.Amount, persistence.RefundLatePayment); err != nil {
+		return err
+	}
+	return ErrNotHeld
+}
+
+//refundBooking pays back the given amount of a payment made for a booking. The refund is recorded
+//on the booking as pending before the payment provider is asked to make it, under an ID that is
+//also the key of the refund at the provider, so that a refund is never made twice: not when it is
+//asked for again, and not when it is retried after it was cut short. A refund that is already
+//recorded is only retried if it is still pending.
+func (m *Manager) refundBooking(bk persistence.Booking, paymentID string, amount int64, reason string) error {
+	if paymentID == "" {
+		return nil
+	}
+	id := persistence.RefundID(bk.ID, paymentID, reason)
+	if refund, ok := bk.FindRefund(id); ok {
+		if !refund.Pending() {
+			return nil
+		}
+		return m.issueRefund(bk, refund)
+	}
+	if amount <= 0 {
+		return nil
+	}
+
+	refund := persistence.Refund{
+		ID:        id,
+		PaymentID: paymentID,
+		Amount:    amount,
+		Currency:  bk.Currency,
+		Reason:    reason,
+		Status:    persistence.RefundPending,
+	}
+	userID, _ := hex.DecodeString(bk.UserID)
+	err := m.Database.AddRefundToBooking(userID, []byte(bk.ID), refund)
+	if err == persistence.ErrRefundExists {
+		//Recorded in the meantime, by a request that raced this one.
+		stored, err := m.findBooking(userID, []byte(bk.ID))
+		if err != nil {
+			return err
+		}
+		refund, _ = stored.FindRefund(id)
+		if !refund.Pending() {
+			return nil
+		}
+	} else if err != nil {
+		slog.ErrorContext(m.ctx, "could not record refund of payment", "payment", paymentID, "error", err)
+		return err
+	}
+	return m.issueRefund(bk, refund)
+}
+
+//issueRefund asks the payment provider to make a pending refund, and records the outcome. A refund
+//the provider refuses is recorded as failed; one that couldn't be made for any other reason stays
+//pending, for RetryPendingRefunds.
+func (m *Manager) issueRefund(bk persistence.Booking, refund persistence.Refund) error {
+	userID, _ := hex.DecodeString(bk.UserID)
+	_, err := m.Payments.Refund(refund.PaymentID, refund.Amount, refund.ID)
+	if err != nil {
+		slog.ErrorContext(m.ctx, "could not refund payment", "payment", refund.PaymentID, "error", err)
+		if err == payments.ErrNotRefundable || err == payments.ErrIntentNotFound {
+			refund.Status = persistence.RefundFailed
+			if err := m.Database.UpdateRefundOfBooking(userID, []byte(bk.ID), refund); err != nil && err != persistence.ErrRefundSettled {
+				slog.ErrorContext(m.ctx, "could not record failed refund of payment", "payment", refund.PaymentID, "error", err)
+			}
+		}
+		return err
+	}
+
+	refund.Status = persistence.RefundIssued
+	refund.RefundedAt = time.Now().Unix()
+	err = m.Database.UpdateRefundOfBooking(userID, []byte(bk.ID), refund)
+	if err == persistence.ErrRefundSettled {
+		//Somebody else issued it first, and announced it.
+		return nil
+	}
+	if err != nil {
+		slog.ErrorContext(m.ctx, "could not record refund of payment", "payment", refund.PaymentID, "error", err)
+		return err
+	}
+	m.emit(&contracts.PaymentRefundedEvent{
+		ID:         refund.PaymentID,
+		BookingID:  hex.EncodeToString([]byte(bk.ID)),
+		EventID:    bk.EventID,
+		UserID:     bk.UserID,
+		Amount:     refund.Amount,
+		Currency:   refund.Currency,
+		RefundedAt: refund.RefundedAt,
+	})
+	return nil
+}
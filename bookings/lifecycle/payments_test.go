@@ -0,0 +1,167 @@
+package lifecycle
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/payments"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+func TestPay(t *testing.T) {
+	cases := []struct {
+		name      string
+		method    string
+		err       error
+		status    string
+		announced string //the payment contract that is emitted
+	}{
+		{"payment succeeds", payments.FakeMethodSuccess, nil, persistence.BookingConfirmed, (&contracts.PaymentSucceededEvent{}).EventName()},
+		{"payment declined", payments.FakeMethodDecline, ErrPaymentDeclined, persistence.BookingHeld, (&contracts.PaymentFailedEvent{}).EventName()},
+		{"payment settles later", payments.FakeMethodDelayed, nil, persistence.BookingHeld, ""},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		eventID := m.addEvent(t, persistence.Event{Capacity: 10, TicketTypes: []persistence.TicketType{standard}})
+		userID := m.addUser(t)
+		bk, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: 2, TicketType: standard.Name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, intent, err := m.Pay(userID, []byte(bk.ID), c.method)
+		if err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		if intent.Amount != 4000 || intent.Currency != "EUR" {
+			t.Errorf("%s: expected the total of the booking to be collected, got %+v", c.name, intent)
+		}
+		stored := m.booking(t, userID, bk)
+		if stored.Status != c.status {
+			t.Errorf("%s: expected the booking to be %s, got %s", c.name, c.status, stored.Status)
+		}
+		if c.status == persistence.BookingConfirmed && stored.PaymentID != intent.ID {
+			t.Errorf("%s: expected the booking to refer to payment %s, got %+v", c.name, intent.ID, stored)
+		}
+		if c.announced != "" && m.emitter.count(c.announced) != 1 {
+			t.Errorf("%s: expected %s to be emitted once", c.name, c.announced)
+		}
+	}
+}
+
+func TestPayFreeBooking(t *testing.T) {
+	m := newTestManager()
+	eventID := m.addEvent(t, persistence.Event{Capacity: 10})
+	userID := m.addUser(t)
+	bk, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Pay(userID, []byte(bk.ID), payments.FakeMethodSuccess); err != ErrNothingToPay {
+		t.Errorf("expected a free booking to be refused, got %v", err)
+	}
+}
+
+//TestCancelRefundsPayment cancels a paid booking well before the event, which pays it back in full.
+func TestCancelRefundsPayment(t *testing.T) {
+	m := newTestManager()
+	eventID := m.addEvent(t, persistence.Event{Capacity: 10, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+	userID := m.addUser(t)
+	bk, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: 2, TicketType: standard.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bk, _, err = m.Pay(userID, []byte(bk.ID), payments.FakeMethodSuccess); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Cancel(userID, []byte(bk.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	refunded := int64(0)
+	for _, e := range m.emitter.events {
+		if refund, ok := e.(*contracts.PaymentRefundedEvent); ok && refund.ID == bk.PaymentID {
+			refunded += refund.Amount
+		}
+	}
+	if refunded != 4000 {
+		t.Errorf("expected 4000 to be refunded, got %d", refunded)
+	}
+}
+
+//TestSettlePaymentTwice delivers the outcome of a payment twice, like providers do now and then.
+func TestSettlePaymentTwice(t *testing.T) {
+	cases := []struct {
+		name     string
+		end      func(m *testManager, userID []byte, bk persistence.Booking) //what happens to the hold before the payment arrives
+		status   string
+		err      error
+		refunded int64
+	}{
+		{"payment in time", nil, persistence.BookingConfirmed, nil, 0},
+		{"hold cancelled", func(m *testManager, userID []byte, bk persistence.Booking) {
+			if _, err := m.Cancel(userID, []byte(bk.ID)); err != nil {
+				t.Fatal(err)
+			}
+		}, persistence.BookingCancelled, ErrNotHeld, 2000},
+		{"hold expired", func(m *testManager, userID []byte, bk persistence.Booking) {
+			if _, err := m.ExpireHolds(time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}, persistence.BookingExpired, ErrNotHeld, 2000},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		provider := &flakyProvider{PaymentProvider: m.payments, refunded: map[string]int64{}}
+		m.Payments = provider
+		eventID := m.addEvent(t, persistence.Event{Capacity: 10, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+		userID := m.addUser(t)
+		bk, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: 1, TicketType: standard.Name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		intent, err := m.payments.CreateIntent(payments.IntentRequest{
+			Amount:   bk.Total,
+			Currency: bk.Currency,
+			Metadata: map[string]string{
+				"userId":    hex.EncodeToString(userID),
+				"bookingId": hex.EncodeToString([]byte(bk.ID)),
+				"eventId":   eventID,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if intent, err = m.payments.Capture(intent.ID); err != nil {
+			t.Fatal(err)
+		}
+		if c.end != nil {
+			c.end(m, userID, bk)
+		}
+
+		for i := 0; i < 2; i++ {
+			settled, err := m.SettlePayment(intent)
+			if err != c.err {
+				t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+			}
+			if settled.Status != c.status {
+				t.Errorf("%s: expected the booking to be %s, got %+v", c.name, c.status, settled)
+			}
+		}
+		if provider.refunded[intent.ID] != c.refunded {
+			t.Errorf("%s: expected %d to be refunded, got %d", c.name, c.refunded, provider.refunded[intent.ID])
+		}
+		if n := m.emitter.count((&contracts.PaymentSucceededEvent{}).EventName()); n != 1 {
+			t.Errorf("%s: expected the payment to be announced once, got %d", c.name, n)
+		}
+		refunds := 0
+		if c.refunded > 0 {
+			refunds = 1
+		}
+		if n := m.emitter.count((&contracts.PaymentRefundedEvent{}).EventName()); n != refunds {
+			t.Errorf("%s: expected %d refunds to be announced, got %d", c.name, refunds, n)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package lifecycle
+
+import (
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+var ErrEventCancelled = errors.New("event has been cancelled")
+
+//CancelEvent marks the event as cancelled and refunds every one of its bookings. The refunds are
+//made by a refund job that stores its progress after every booking, so that ResumeRefundJobs can
+//finish it if the service goes down halfway through. Cancelling an event twice does not refund
+//anything twice.
+func (m *Manager) CancelEvent(eventID string, cancelledAt int64) (persistence.RefundJob, error) {
+	id, err := hex.DecodeString(eventID)
+	if err != nil {
+		return persistence.RefundJob{}, ErrEventNotFound
+	}
+	if err := m.Database.CancelEvent(id, cancelledAt); err != nil {
+		slog.ErrorContext(m.ctx, "could not mark event as cancelled", "event", eventID, "error", err)
+	}
+
+	job, err := m.Database.FindRefundJob([]byte(eventID))
+	if err == nil && job.Status == persistence.RefundJobFinished {
+		return job, nil
+	}
+	if err != nil {
+		job = persistence.RefundJob{
+			EventID:   eventID,
+			Status:    persistence.RefundJobRunning,
+			StartedAt: time.Now().Unix(),
+		}
+		if err := m.Database.SaveRefundJob(job); err != nil {
+			return job, err
+		}
+	}
+	return m.runRefundJob(job)
+}
+
+//RefundJob returns the progress of refunding a cancelled event.
+func (m *Manager) RefundJob(eventID string) (persistence.RefundJob, error) {
+	job, err := m.Database.FindRefundJob([]byte(eventID))
+	if err != nil {
+		return persistence.RefundJob{}, ErrEventNotFound
+	}
+	return job, nil
+}
+
+//ResumeRefundJobs finishes the refund jobs that are still running, which after a restart means they
+//were interrupted. Jobs with failed refunds also stay running, so those refunds are retried here.
+func (m *Manager) ResumeRefundJobs() {
+	jobs, err := m.Database.FindUnfinishedRefundJobs()
+	if err != nil {
+		slog.Error("could not load unfinished refund jobs", "error", err)
+		return
+	}
+	for _, job := range jobs {
+		slog.Info("resuming refunds of cancelled event", "event", job.EventID)
+		if _, err := m.runRefundJob(job); err != nil {
+			slog.Error("refunds of cancelled event are incomplete", "event", job.EventID, "error", err)
+		}
+	}
+}
+
+//runRefundJob goes through all bookings of the event. Every step is safe to repeat: bookings that
+//were cancelled before are not cancelled again, and only what has not been refunded yet is
+//refunded, which is what allows a job to simply start over when it is resumed.
+func (m *Manager) runRefundJob(job persistence.RefundJob) (persistence.RefundJob, error) {
+	bookings, err := m.Database.FindBookingsByEventId([]byte(job.EventID))
+	if err != nil {
+		return job, err
+	}
+	job.Bookings = len(bookings)
+	job.Processed = 0
+	job.Refunded = 0
+	job.Failed = []string{}
+
+	for _, bk := range bookings {
+		refunded, err := m.cancelForEvent(bk)
+		if err != nil {
+			slog.ErrorContext(m.ctx, "could not refund booking of cancelled event", "event", job.EventID, "booking", hex.EncodeToString([]byte(bk.ID)), "error", err)
+			job.Failed = append(job.Failed, hex.EncodeToString([]byte(bk.ID)))
+		}
+		job.Refunded += refunded
+		job.Processed++
+		if err := m.Database.SaveRefundJob(job); err != nil {
+			return job, err
+		}
+	}
+
+	if len(job.Failed) > 0 {
+		return job, errors.New("some bookings could not be refunded")
+	}
+	job.Status = persistence.RefundJobFinished
+	job.FinishedAt = time.Now().Unix()
+	return job, m.Database.SaveRefundJob(job)
+}
+
+//cancelForEvent cancels a single booking of a cancelled event and refunds whatever is left of its
+//payment, and returns the amount that was refunded for the cancellation of the event. That includes
+//a refund made by an earlier run of the job, so that every run counts all of them, but not a refund
+//that could not be made. Unlike Cancel it ignores the cancellation policy: when the organizer
+//cancels, everybody gets their money back.
+func (m *Manager) cancelForEvent(bk persistence.Booking) (int64, error) {
+	userID, err := hex.DecodeString(bk.UserID)
+	if err != nil {
+		return 0, err
+	}
+	if bk.Active() {
+		status := bk.Status
+		bk.Status = persistence.BookingCancelled
+		bk.CancelledAt = time.Now().Unix()
+		if err := m.Database.UpdateBookingForUser(userID, status, bk); err != nil {
+			return 0, err
+		}
+		m.emit(&contracts.BookingCancelledEvent{
+			ID:          hex.EncodeToString([]byte(bk.ID)),
+			EventID:     bk.EventID,
+			UserID:      bk.UserID,
+			Seats:       bk.Seats,
+			CancelledAt: bk.CancelledAt,
+			SagaID:      bk.SagaID,
+		})
+		if status == persistence.BookingHeld {
+			m.releasePromoCode(bk)
+		}
+		m.releaseSeats(bk)
+	}
+	if bk.PaymentID == "" {
+		return 0, nil
+	}
+	amount := bk.Total - bk.Refunded()
+	refund, recorded := bk.FindRefund(persistence.RefundID(bk.ID, bk.PaymentID, persistence.RefundEventCancelled))
+	if recorded && refund.Status == persistence.RefundFailed {
+		return 0, nil
+	}
+	if recorded {
+		amount = refund.Amount
+	}
+	if err := m.refundBooking(bk, bk.PaymentID, amount, persistence.RefundEventCancelled); err != nil {
+		return 0, err
+	}
+	return amount, nil
+}
+
+//RetryPendingRefunds asks the payment provider again for every refund that is still pending,
+//because the provider couldn't be reached or the service went down before the outcome was recorded.
+//The provider is handed the same key again, so a refund it already made is not made twice.
+func (m *Manager) RetryPendingRefunds() {
+	bookings, err := m.Database.FindBookingsWithPendingRefunds()
+	if err != nil {
+		slog.Error("could not load pending refunds", "error", err)
+		return
+	}
+	for _, bk := range bookings {
+		for _, refund := range bk.Refunds {
+			if !refund.Pending() {
+				continue
+			}
+			if err := m.issueRefund(bk, refund); err != nil {
+				slog.Error("refund is still pending", "booking", hex.EncodeToString([]byte(bk.ID)), "payment", refund.PaymentID, "error", err)
+			}
+		}
+	}
+}
+
+//SweepPendingRefunds retries pending refunds every interval.
+func (m *Manager) SweepPendingRefunds(interval time.Duration) {
+	slog.Info("sweeping pending refunds", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.RetryPendingRefunds()
+	}
+}
+
+func min64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
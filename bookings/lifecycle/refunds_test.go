@@ -0,0 +1,275 @@
+package lifecycle
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/payments"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/memlayer"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+//flakyProvider is a payment provider that can be taken down, and that remembers how much of every
+//intent was refunded according to the provider.
+type flakyProvider struct {
+	payments.PaymentProvider
+	down     bool
+	refunded map[string]int64
+}
+
+func (p *flakyProvider) Refund(intentID string, amount int64, key string) (payments.Intent, error) {
+	if p.down {
+		return payments.Intent{}, errUnavailable
+	}
+	intent, err := p.PaymentProvider.Refund(intentID, amount, key)
+	if err == nil {
+		p.refunded[intentID] = intent.Refunded
+	}
+	return intent, err
+}
+
+//failingRefunds is a database that can't record refunds.
+type failingRefunds struct {
+	*memlayer.MemoryLayer
+}
+
+func (failingRefunds) AddRefundToBooking(userId []byte, bookingId []byte, refund persistence.Refund) error {
+	return errUnavailable
+}
+
+//paidBooking holds a booking of the event and pays for it, and returns the confirmed booking.
+func (m *testManager) paidBooking(t *testing.T, userID []byte, eventID string, seats int) persistence.Booking {
+	bk, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: seats, TicketType: standard.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bk, _, err = m.Pay(userID, []byte(bk.ID), "pm_card")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bk.Status != persistence.BookingConfirmed {
+		t.Fatalf("expected the booking to be confirmed, got %+v", bk)
+	}
+	return bk
+}
+
+func TestEventCancellationRefundsOnce(t *testing.T) {
+	cases := []struct {
+		name     string
+		down     bool //the provider is down while the event is cancelled
+		failing  bool //the database can't record refunds
+		err      error
+		refunded int64 //by the provider, before the refunds were retried
+	}{
+		{name: "provider is up", refunded: 4000},
+		{name: "provider is down", down: true, err: errUnavailable},
+		{name: "refund can't be recorded", failing: true, err: errUnavailable},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		provider := &flakyProvider{PaymentProvider: m.payments, refunded: map[string]int64{}}
+		m.Payments = provider
+		eventID := m.addEvent(t, persistence.Event{Capacity: 10, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+		userID := m.addUser(t)
+		bk := m.paidBooking(t, userID, eventID, 2)
+
+		provider.down = c.down
+		if c.failing {
+			m.Database = failingRefunds{m.db}
+		}
+		if _, err := m.cancelForEvent(bk); err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		if provider.refunded[bk.PaymentID] != c.refunded {
+			t.Errorf("%s: expected %d to be refunded by the provider, got %d", c.name, c.refunded, provider.refunded[bk.PaymentID])
+		}
+		provider.down = false
+		m.Database = m.db
+
+		//Retries of the pending refunds and runs of the job after that refund in full, but only once.
+		m.RetryPendingRefunds()
+		for i := 0; i < 2; i++ {
+			if _, err := m.cancelForEvent(m.booking(t, userID, bk)); err != nil {
+				t.Errorf("%s: expected the job to be repeatable, got %v", c.name, err)
+			}
+		}
+		m.RetryPendingRefunds()
+		if provider.refunded[bk.PaymentID] != 4000 {
+			t.Errorf("%s: expected 4000 to be refunded by the provider, got %d", c.name, provider.refunded[bk.PaymentID])
+		}
+		stored := m.booking(t, userID, bk)
+		refund, _ := stored.FindRefund(persistence.RefundID(bk.ID, bk.PaymentID, persistence.RefundEventCancelled))
+		if refund.Status != persistence.RefundIssued || len(stored.Refunds) != 1 {
+			t.Errorf("%s: expected a single issued refund, got %+v", c.name, stored.Refunds)
+		}
+		if n := m.emitter.count((&contracts.PaymentRefundedEvent{}).EventName()); n != 1 {
+			t.Errorf("%s: expected the refund to be announced once, got %d", c.name, n)
+		}
+	}
+}
+
+//TestRefundPolicy cancels confirmed bookings at different times before the event starts.
+func TestRefundPolicy(t *testing.T) {
+	policy := &persistence.CancellationPolicy{Tiers: []persistence.RefundTier{{HoursBefore: 168, Percent: 100}, {HoursBefore: 24, Percent: 50}, {HoursBefore: 0, Percent: 0}}}
+	cases := []struct {
+		name   string
+		before time.Duration
+		policy *persistence.CancellationPolicy
+		err    error
+		refund int64
+	}{
+		{"full refund", 10 * 24 * time.Hour, policy, nil, 4000},
+		{"half refund", 48 * time.Hour, policy, nil, 2000},
+		{"no refund", 2 * time.Hour, policy, nil, 0},
+		{"without policy", 2 * time.Hour, nil, nil, 4000},
+		{"without policy within the cutoff", 30 * time.Minute, nil, ErrCancelCutoff, 0},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		eventID := m.addEvent(t, persistence.Event{Capacity: 10, StartDate: time.Now().Add(c.before).Unix(), TicketTypes: []persistence.TicketType{standard}, Policy: c.policy})
+		userID := m.addUser(t)
+		bk := m.paidBooking(t, userID, eventID, 2)
+
+		_, err := m.Cancel(userID, []byte(bk.ID))
+		if err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+			continue
+		}
+		stored := m.booking(t, userID, bk)
+		if stored.Refunded() != c.refund {
+			t.Errorf("%s: expected a refund of %d, got %d", c.name, c.refund, stored.Refunded())
+		}
+	}
+}
+
+//TestCancelEvent cancels an event with a paid and a held booking, and then cancels it once more.
+func TestCancelEvent(t *testing.T) {
+	m := newTestManager()
+	eventID := m.addEvent(t, persistence.Event{Capacity: 10, StartDate: time.Now().Add(time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+	userID := m.addUser(t)
+	paid := m.paidBooking(t, userID, eventID, 2)
+	held, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: 1, TicketType: standard.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		job, err := m.CancelEvent(eventID, time.Now().Unix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != persistence.RefundJobFinished || job.Bookings != 2 || job.Refunded != 4000 {
+			t.Errorf("expected a finished job that refunded 4000 for 2 bookings, got %+v", job)
+		}
+	}
+	for _, bk := range []persistence.Booking{paid, held} {
+		if stored := m.booking(t, userID, bk); stored.Status != persistence.BookingCancelled {
+			t.Errorf("expected the booking to be cancelled, got %+v", stored)
+		}
+	}
+	if refunded := m.booking(t, userID, paid).Refunded(); refunded != 4000 {
+		t.Errorf("expected the paid booking to be refunded once, got %d", refunded)
+	}
+	if _, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: 1, TicketType: standard.Name}); err != ErrEventCancelled {
+		t.Errorf("expected %v, got %v", ErrEventCancelled, err)
+	}
+}
+
+//TestRefundJobCountsIssuedRefunds cancels an event while the payment provider is down. The job
+//only counts refunds that were made, in the run that failed as well as in the runs that follow.
+func TestRefundJobCountsIssuedRefunds(t *testing.T) {
+	cases := []struct {
+		name  string
+		retry bool //the pending refunds are retried before the job is resumed
+	}{
+		{"resumed", false},
+		{"retried and resumed", true},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		provider := &flakyProvider{PaymentProvider: m.payments, refunded: map[string]int64{}}
+		m.Payments = provider
+		eventID := m.addEvent(t, persistence.Event{Capacity: 10, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+		userID := m.addUser(t)
+		m.paidBooking(t, userID, eventID, 2)
+		m.paidBooking(t, userID, eventID, 1)
+
+		provider.down = true
+		job, err := m.CancelEvent(eventID, time.Now().Unix())
+		if err == nil || job.Status != persistence.RefundJobRunning || job.Refunded != 0 || len(job.Failed) != 2 {
+			t.Errorf("%s: expected a running job that refunded nothing, got %+v and %v", c.name, job, err)
+		}
+
+		provider.down = false
+		if c.retry {
+			m.RetryPendingRefunds()
+		}
+		m.ResumeRefundJobs()
+		job, err = m.RefundJob(eventID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != persistence.RefundJobFinished || job.Refunded != 6000 || len(job.Failed) != 0 {
+			t.Errorf("%s: expected a finished job that refunded 6000, got %+v", c.name, job)
+		}
+	}
+}
+
+//TestRefundID makes sure refunds of different payments or for different reasons don't share a key.
+func TestRefundID(t *testing.T) {
+	id := persistence.RefundID("booking", "pi_1", persistence.RefundCancelled)
+	if id != hex.EncodeToString([]byte("booking"))+"/pi_1/"+persistence.RefundCancelled {
+		t.Errorf("unexpected refund ID %q", id)
+	}
+	if id == persistence.RefundID("booking", "pi_2", persistence.RefundCancelled) || id == persistence.RefundID("booking", "pi_1", persistence.RefundEventCancelled) {
+		t.Error("expected refunds of other payments and reasons to have other IDs")
+	}
+}
+
+func TestCancelReturnsRefundError(t *testing.T) {
+	cases := []struct {
+		name   string
+		down   bool
+		err    error
+		status string
+	}{
+		{"refund is made", false, nil, persistence.RefundIssued},
+		{"provider is down", true, errUnavailable, persistence.RefundPending},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		provider := &flakyProvider{PaymentProvider: m.payments, refunded: map[string]int64{}}
+		m.Payments = provider
+		eventID := m.addEvent(t, persistence.Event{Capacity: 2, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+		userID := m.addUser(t)
+		bk := m.paidBooking(t, userID, eventID, 2)
+
+		provider.down = c.down
+		cancelled, err := m.Cancel(userID, []byte(bk.ID))
+		if err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		if cancelled.Status != persistence.BookingCancelled {
+			t.Errorf("%s: expected the booking to be cancelled, got %+v", c.name, cancelled)
+		}
+		refund, _ := m.booking(t, userID, bk).FindRefund(persistence.RefundID(bk.ID, bk.PaymentID, persistence.RefundCancelled))
+		if refund.Status != c.status {
+			t.Errorf("%s: expected the refund to be %q, got %+v", c.name, c.status, refund)
+		}
+		//The seats are given back even when the refund failed.
+		if _, err := m.Hold(m.addUser(t), persistence.Booking{EventID: eventID, Seats: 2, TicketType: standard.Name}); err != nil {
+			t.Errorf("%s: expected the seats to be released, got %v", c.name, err)
+		}
+
+		provider.down = false
+		m.RetryPendingRefunds()
+		if provider.refunded[bk.PaymentID] != 4000 {
+			t.Errorf("%s: expected 4000 to be refunded after retrying, got %d", c.name, provider.refunded[bk.PaymentID])
+		}
+	}
+}
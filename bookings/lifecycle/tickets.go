@@ -0,0 +1,108 @@
+package lifecycle
+
+import (
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/tickets"
+)
+
+var (
+	ErrNotConfirmed  = errors.New("tickets are only issued for confirmed bookings")
+	ErrTicketInvalid = errors.New("ticket does not belong to a confirmed booking")
+)
+
+//IssuedTicket is a ticket together with the signed token that is printed on it.
+type IssuedTicket struct {
+	tickets.Ticket
+	Token string
+}
+
+//IssueTickets issues one ticket per seat of a confirmed booking. Tokens are deterministic, so
+//downloading the tickets again hands out the same tickets instead of new ones.
+func (m *Manager) IssueTickets(userID []byte, bookingID []byte) ([]IssuedTicket, error) {
+	bk, err := m.findBooking(userID, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	if bk.Status != persistence.BookingConfirmed {
+		return nil, ErrNotConfirmed
+	}
+
+	issued := []IssuedTicket{}
+	for i := 0; i < bk.Seats; i++ {
+		t := tickets.Ticket{
+			BookingID: hex.EncodeToString(bookingID),
+			EventID:   bk.EventID,
+			UserID:    hex.EncodeToString(userID),
+			Number:    i + 1,
+		}
+		if i < len(bk.SeatIDs) {
+			t.Seat = bk.SeatIDs[i]
+		}
+		token, err := m.TicketSigner.Sign(t)
+		if err != nil {
+			return nil, err
+		}
+		issued = append(issued, IssuedTicket{t, token})
+	}
+	return issued, nil
+}
+
+//CheckIn lets the holder of a ticket into the event. The signature is checked first, so forged
+//tickets never reach the database. A ticket that was already used is refused with
+//persistence.ErrTicketCheckedIn, together with the check-in that used it up.
+func (m *Manager) CheckIn(token string) (persistence.TicketCheckIn, error) {
+	t, err := tickets.Verify(m.TicketSigner.PublicKey(), token)
+	if err != nil {
+		return persistence.TicketCheckIn{}, err
+	}
+
+	//A genuine ticket can still have been cancelled since it was issued.
+	userID, err := hex.DecodeString(t.UserID)
+	if err != nil {
+		return persistence.TicketCheckIn{}, ErrTicketInvalid
+	}
+	bookingID, err := hex.DecodeString(t.BookingID)
+	if err != nil {
+		return persistence.TicketCheckIn{}, ErrTicketInvalid
+	}
+	bk, err := m.findBooking(userID, bookingID)
+	if err != nil || bk.Status != persistence.BookingConfirmed || bk.EventID != t.EventID || t.Number < 1 || t.Number > bk.Seats {
+		return persistence.TicketCheckIn{}, ErrTicketInvalid
+	}
+
+	checkIn := persistence.TicketCheckIn{
+		ID:          t.ID(),
+		EventID:     t.EventID,
+		BookingID:   t.BookingID,
+		UserID:      t.UserID,
+		Seat:        t.Seat,
+		CheckedInAt: time.Now().Unix(),
+	}
+	err = m.Database.CheckInTicket(checkIn)
+	if err == persistence.ErrTicketCheckedIn {
+		previous, findErr := m.Database.FindCheckIn([]byte(t.EventID), t.ID())
+		if findErr != nil {
+			slog.ErrorContext(m.ctx, "could not load check-in of ticket", "ticket", t.ID(), "error", findErr)
+		}
+		return previous, err
+	}
+	if err != nil {
+		return persistence.TicketCheckIn{}, err
+	}
+
+	m.emit(&contracts.TicketCheckedInEvent{
+		TicketID:    checkIn.ID,
+		BookingID:   checkIn.BookingID,
+		EventID:     checkIn.EventID,
+		UserID:      checkIn.UserID,
+		Seat:        checkIn.Seat,
+		CheckedInAt: checkIn.CheckedInAt,
+	})
+	return checkIn, nil
+}
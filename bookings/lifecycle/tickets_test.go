@@ -0,0 +1,93 @@
+package lifecycle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/tickets"
+)
+
+func TestIssueTickets(t *testing.T) {
+	m := newTestManager()
+	eventID := m.addEvent(t, persistence.Event{Capacity: 10, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+	userID := m.addUser(t)
+	held, err := m.Hold(userID, persistence.Booking{EventID: eventID, Seats: 1, TicketType: standard.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.IssueTickets(userID, []byte(held.ID)); err != ErrNotConfirmed {
+		t.Errorf("expected %v for a held booking, got %v", ErrNotConfirmed, err)
+	}
+
+	bk := m.paidBooking(t, userID, eventID, 2)
+	issued, err := m.IssueTickets(userID, []byte(bk.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issued) != 2 || issued[0].Number != 1 || issued[1].Number != 2 {
+		t.Fatalf("expected two numbered tickets, got %+v", issued)
+	}
+	again, err := m.IssueTickets(userID, []byte(bk.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].Token != issued[0].Token {
+		t.Error("expected the same tickets to be issued again")
+	}
+}
+
+func TestCheckIn(t *testing.T) {
+	m := newTestManager()
+	eventID := m.addEvent(t, persistence.Event{Capacity: 10, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+	userID := m.addUser(t)
+	bk := m.paidBooking(t, userID, eventID, 2)
+	issued, err := m.IssueTickets(userID, []byte(bk.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled := m.paidBooking(t, userID, eventID, 1)
+	revoked, err := m.IssueTickets(userID, []byte(cancelled.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Cancel(userID, []byte(cancelled.ID)); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := tickets.GenerateSigner()
+	forged, _ := other.Sign(issued[1].Ticket)
+
+	first, err := m.CheckIn(issued[0].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != issued[0].ID() || first.EventID != eventID {
+		t.Errorf("expected the check-in of the first ticket, got %+v", first)
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"second scan", issued[0].Token, persistence.ErrTicketCheckedIn},
+		{"other ticket of the booking", issued[1].Token, nil},
+		{"cancelled booking", revoked[0].Token, ErrTicketInvalid},
+		{"forged", forged, tickets.ErrInvalidSignature},
+		{"malformed", "not a ticket", tickets.ErrMalformedToken},
+	}
+	for _, c := range cases {
+		checkIn, err := m.CheckIn(c.token)
+		if err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		//A second scan tells the door staff when the ticket was used.
+		if err == persistence.ErrTicketCheckedIn && checkIn.CheckedInAt != first.CheckedInAt {
+			t.Errorf("%s: expected the first check-in, got %+v", c.name, checkIn)
+		}
+	}
+	if n := m.emitter.count((&contracts.TicketCheckedInEvent{}).EventName()); n != 2 {
+		t.Errorf("expected two check-ins to be announced, got %d", n)
+	}
+}
@@ -0,0 +1,312 @@
+package lifecycle
+
+import (
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+var (
+	ErrSeatsAvailable  = errors.New("event still has seats available, book them directly")
+	ErrExceedsCapacity = errors.New("event does not have that many seats")
+)
+
+//JoinWaitlist puts the user on the waitlist of a sold out event. Users are offered freed up seats
+//in the order in which they joined.
+func (m *Manager) JoinWaitlist(userID []byte, eventID string, seats int, ticketType string) (persistence.WaitlistEntry, error) {
+	event, err := m.findEvent(eventID)
+	if err != nil {
+		return persistence.WaitlistEntry{}, err
+	}
+	if event.CancelledAt > 0 {
+		return persistence.WaitlistEntry{}, ErrEventCancelled
+	}
+	if event.Capacity == 0 {
+		return persistence.WaitlistEntry{}, ErrSeatsAvailable
+	}
+	//An entry for more seats than the event has could never be offered, and would hold up everybody
+	//behind it.
+	if seats > event.Capacity {
+		return persistence.WaitlistEntry{}, ErrExceedsCapacity
+	}
+	//The offer made to the user is priced like any other booking, so we need to know which
+	//tickets the user is waiting for.
+	if len(event.TicketTypes) > 0 {
+		if _, err := findTicketType(event, ticketType); err != nil {
+			return persistence.WaitlistEntry{}, err
+		}
+	} else if ticketType != "" {
+		return persistence.WaitlistEntry{}, ErrUnknownTicketType
+	}
+
+	taken, err := m.seatsTaken(eventID)
+	if err != nil {
+		return persistence.WaitlistEntry{}, err
+	}
+	pending, err := m.hasPendingEntries(eventID)
+	if err != nil {
+		return persistence.WaitlistEntry{}, err
+	}
+	if !pending && taken+seats <= event.Capacity {
+		return persistence.WaitlistEntry{}, ErrSeatsAvailable
+	}
+
+	entry := persistence.WaitlistEntry{
+		EventID:    eventID,
+		UserID:     hex.EncodeToString(userID),
+		Seats:      seats,
+		TicketType: ticketType,
+		JoinedAt:   time.Now().UnixNano(),
+		Status:     persistence.WaitlistWaiting,
+	}
+	err = m.Database.AddWaitlistEntry(entry)
+	if err != nil {
+		return persistence.WaitlistEntry{}, err
+	}
+	return entry, nil
+}
+
+//LeaveWaitlist takes the user off the waitlist of an event. If the user currently holds an offer,
+//the offered seats are released and passed on to the next user in line.
+func (m *Manager) LeaveWaitlist(userID []byte, eventID string) error {
+	entry, _, err := m.WaitlistPosition(userID, eventID)
+	if err != nil {
+		return err
+	}
+
+	if entry.Status == persistence.WaitlistOffered {
+		bookingID, err := hex.DecodeString(entry.BookingID)
+		if err != nil {
+			return err
+		}
+		bk, err := m.findBooking(userID, bookingID)
+		if err == nil && bk.Status == persistence.BookingHeld {
+			bk.Status = persistence.BookingCancelled
+			bk.CancelledAt = time.Now().Unix()
+			err = m.Database.UpdateBookingForUser(userID, persistence.BookingHeld, bk)
+			if err == nil {
+				m.emit(&contracts.BookingCancelledEvent{
+					ID:          entry.BookingID,
+					EventID:     bk.EventID,
+					UserID:      entry.UserID,
+					Seats:       bk.Seats,
+					CancelledAt: bk.CancelledAt,
+					SagaID:      bk.SagaID,
+				})
+				m.releaseSeats(bk)
+				m.releasePromoCode(bk)
+			}
+		}
+	}
+
+	err = m.Database.RemoveWaitlistEntry([]byte(eventID), []byte(entry.UserID))
+	if err != nil {
+		return err
+	}
+	if entry.Status == persistence.WaitlistOffered {
+		m.offerFreedSeats(eventID)
+	}
+	return nil
+}
+
+//WaitlistPosition returns the waitlist entry of the user together with its position in line.
+//Position 1 is the next user to get an offer, and users that currently hold an offer still count
+//as being in line. Entries that were already accepted or have lapsed have position 0.
+func (m *Manager) WaitlistPosition(userID []byte, eventID string) (persistence.WaitlistEntry, int, error) {
+	entries, err := m.Database.FindWaitlistByEventId([]byte(eventID))
+	if err != nil {
+		return persistence.WaitlistEntry{}, 0, err
+	}
+
+	user := hex.EncodeToString(userID)
+	position := 0
+	for _, entry := range entries {
+		if entry.Pending() {
+			position++
+		}
+		if entry.UserID != user {
+			continue
+		}
+		if !entry.Pending() {
+			position = 0
+		}
+		return entry, position, nil
+	}
+	return persistence.WaitlistEntry{}, 0, persistence.ErrNotOnWaitlist
+}
+
+//An Offerer books the seats that are offered to a user on the waitlist. The events service owns the
+//capacity of an event, so offers have to reserve their seats with it like any other booking, which
+//the booking saga takes care of. Once the seats are reserved, the saga calls HoldOffer; if they
+//can't be, it calls WithdrawOffer.
+type Offerer interface {
+	StartOffer(userID []byte, offer persistence.Booking) (persistence.BookingSaga, error)
+}
+
+//offerFreedSeats offers the available seats of an event to the users on its waitlist. Offers are
+//regular holds that run for OfferDuration; an offer that is not confirmed in time expires like any
+//other hold, which brings us back here and rolls the seats on to the next user in line.
+func (m *Manager) offerFreedSeats(eventID string) {
+	if m.Offers == nil {
+		return
+	}
+	event, err := m.findEvent(eventID)
+	if err != nil || event.Capacity == 0 || event.CancelledAt > 0 {
+		return
+	}
+	entries, err := m.Database.FindWaitlistByEventId([]byte(eventID))
+	if err != nil {
+		slog.ErrorContext(m.ctx, "could not load waitlist", "event", eventID, "error", err)
+		return
+	}
+	taken, err := m.seatsTaken(eventID)
+	if err != nil {
+		slog.ErrorContext(m.ctx, "could not count booked seats", "event", eventID, "error", err)
+		return
+	}
+
+	//Offers whose seats are still being reserved aren't booked yet, but the seats are theirs.
+	available := event.Capacity - taken
+	for _, entry := range entries {
+		if entry.Status == persistence.WaitlistOffering {
+			available -= entry.Seats
+		}
+	}
+	for _, entry := range entries {
+		if entry.Status != persistence.WaitlistWaiting {
+			continue
+		}
+		//First come, first served: if the seats are not enough for the next user in line, we
+		//don't hand them to someone further back either.
+		if entry.Seats > available {
+			return
+		}
+
+		userID, err := hex.DecodeString(entry.UserID)
+		if err != nil {
+			slog.WarnContext(m.ctx, "malformed user id on waitlist", "event", eventID, "user", entry.UserID)
+			continue
+		}
+		offer := persistence.Booking{EventID: eventID, Seats: entry.Seats, TicketType: entry.TicketType}
+		//Users on the waitlist of an event with assigned seating get the first free seats.
+		if event.SeatMap != nil {
+			offer.SeatIDs, err = m.freeSeats(eventID, event.SeatMap, entry.Seats)
+			if err != nil {
+				return
+			}
+		}
+		//The entry learns the ID of its saga before the saga starts, so that HoldOffer knows the
+		//saga, however quickly the events service answers.
+		offer.SagaID, err = persistence.NewSagaID()
+		if err != nil {
+			return
+		}
+		entry.Status = persistence.WaitlistOffering
+		entry.SagaID = offer.SagaID
+		if err := m.Database.UpdateWaitlistEntry(entry); err != nil {
+			slog.ErrorContext(m.ctx, "could not update waitlist entry", "user", entry.UserID, "error", err)
+			return
+		}
+		saga, err := m.Offers.StartOffer(userID, offer)
+		if err != nil {
+			//A saga that was stored times out and withdraws the offer then.
+			slog.ErrorContext(m.ctx, "could not offer seats", "event", eventID, "user", entry.UserID, "error", err)
+			if saga.ID == "" {
+				m.WithdrawOffer(userID, eventID, offer.SagaID)
+			}
+			return
+		}
+		available -= entry.Seats
+	}
+}
+
+//HoldOffer holds the seats that the booking saga of an offer reserved for a user on the waitlist,
+//and offers the hold to the user. The offer runs for OfferDuration.
+func (m *Manager) HoldOffer(userID []byte, offer persistence.Booking) (persistence.Booking, error) {
+	entry, _, err := m.WaitlistPosition(userID, offer.EventID)
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+	//The user left the waitlist, or the offer was withdrawn and made again by another saga.
+	if entry.Status != persistence.WaitlistOffering || entry.SagaID != offer.SagaID {
+		return persistence.Booking{}, persistence.ErrNotOnWaitlist
+	}
+	event, err := m.findEvent(offer.EventID)
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+	if event.CancelledAt > 0 {
+		return persistence.Booking{}, ErrEventCancelled
+	}
+	bk, err := m.hold(userID, event, offer, m.OfferDuration)
+	if err != nil {
+		return persistence.Booking{}, err
+	}
+
+	entry.Status = persistence.WaitlistOffered
+	entry.BookingID = hex.EncodeToString([]byte(bk.ID))
+	entry.OfferExpires = bk.HoldExpires
+	if err := m.Database.UpdateWaitlistEntry(entry); err != nil {
+		slog.ErrorContext(m.ctx, "could not update waitlist entry", "user", entry.UserID, "error", err)
+	}
+	m.emit(&contracts.WaitlistOfferedEvent{
+		EventID:   offer.EventID,
+		UserID:    entry.UserID,
+		BookingID: entry.BookingID,
+		Seats:     entry.Seats,
+		ExpiresAt: entry.OfferExpires,
+	})
+	return bk, nil
+}
+
+//WithdrawOffer puts the user back in line when the booking saga of an offer failed, so the user
+//keeps their place on the waitlist for the next seats that free up.
+func (m *Manager) WithdrawOffer(userID []byte, eventID string, sagaID string) {
+	entry, _, err := m.WaitlistPosition(userID, eventID)
+	if err != nil || entry.Status != persistence.WaitlistOffering || entry.SagaID != sagaID {
+		return
+	}
+	entry.Status = persistence.WaitlistWaiting
+	entry.SagaID = ""
+	if err := m.Database.UpdateWaitlistEntry(entry); err != nil {
+		slog.ErrorContext(m.ctx, "could not update waitlist entry", "user", entry.UserID, "error", err)
+	}
+}
+
+//settleWaitlistOffer records what happened to a booking that might have been offered from the
+//waitlist. Bookings that were not offered from the waitlist are left alone.
+func (m *Manager) settleWaitlistOffer(bk persistence.Booking, status string) {
+	entries, err := m.Database.FindWaitlistByEventId([]byte(bk.EventID))
+	if err != nil {
+		slog.ErrorContext(m.ctx, "could not load waitlist", "event", bk.EventID, "error", err)
+		return
+	}
+	bookingID := hex.EncodeToString([]byte(bk.ID))
+	for _, entry := range entries {
+		if entry.Status != persistence.WaitlistOffered || entry.BookingID != bookingID {
+			continue
+		}
+		entry.Status = status
+		if err := m.Database.UpdateWaitlistEntry(entry); err != nil {
+			slog.ErrorContext(m.ctx, "could not update waitlist entry", "user", entry.UserID, "error", err)
+		}
+		return
+	}
+}
+
+func (m *Manager) hasPendingEntries(eventID string) (bool, error) {
+	entries, err := m.Database.FindWaitlistByEventId([]byte(eventID))
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.Pending() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
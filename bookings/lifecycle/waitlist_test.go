@@ -0,0 +1,197 @@
+package lifecycle
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+func TestJoinWaitlist(t *testing.T) {
+	cases := []struct {
+		name       string
+		capacity   int
+		held       int //seats that are already held when the user joins
+		seats      int
+		ticketType string
+		err        error
+	}{
+		{"sold out", 2, 2, 1, standard.Name, nil},
+		{"not enough seats left", 2, 1, 2, standard.Name, nil},
+		{"seats left", 2, 1, 1, standard.Name, ErrSeatsAvailable},
+		{"no seat limit", 0, 2, 1, standard.Name, ErrSeatsAvailable},
+		{"no ticket type picked", 2, 2, 1, "", ErrTicketTypeRequired},
+		{"unknown ticket type", 2, 2, 1, "VIP", ErrUnknownTicketType},
+		{"more seats than the event has", 2, 2, 3, standard.Name, ErrExceedsCapacity},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		eventID := m.addEvent(t, persistence.Event{Capacity: c.capacity, TicketTypes: []persistence.TicketType{standard}})
+		if _, err := m.Hold(m.addUser(t), persistence.Booking{EventID: eventID, Seats: c.held, TicketType: standard.Name}); err != nil {
+			t.Fatal(err)
+		}
+		userID := m.addUser(t)
+		entry, err := m.JoinWaitlist(userID, eventID, c.seats, c.ticketType)
+		if err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if entry.Status != persistence.WaitlistWaiting || entry.Seats != c.seats {
+			t.Errorf("%s: expected a waiting entry for %d seats, got %+v", c.name, c.seats, entry)
+		}
+		if _, err := m.JoinWaitlist(userID, eventID, c.seats, c.ticketType); err != persistence.ErrAlreadyOnWaitlist {
+			t.Errorf("%s: expected the user to be on the waitlist already, got %v", c.name, err)
+		}
+	}
+
+	m := newTestManager()
+	if _, err := m.JoinWaitlist(m.addUser(t), hex.EncodeToString([]byte("missing")), 1, standard.Name); err != ErrEventNotFound {
+		t.Errorf("expected an unknown event to be refused, got %v", err)
+	}
+	eventID := m.addEvent(t, persistence.Event{Capacity: 2, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+	if _, err := m.CancelEvent(eventID, time.Now().Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.JoinWaitlist(m.addUser(t), eventID, 1, standard.Name); err != ErrEventCancelled {
+		t.Errorf("expected a cancelled event to be refused, got %v", err)
+	}
+}
+
+//instantOffers stands in for the booking saga: the events service reserves the seats of every offer
+//right away.
+type instantOffers struct {
+	m *Manager
+}
+
+func (o instantOffers) StartOffer(userID []byte, offer persistence.Booking) (persistence.BookingSaga, error) {
+	_, err := o.m.HoldOffer(userID, offer)
+	return persistence.BookingSaga{ID: offer.SagaID}, err
+}
+
+//TestWaitlistRollOver sells out an event, lines up two users on its waitlist, and frees the seats
+//in different ways. The first user in line is offered the seats, and what happens to that offer
+//decides whether the second user gets the next one.
+func TestWaitlistRollOver(t *testing.T) {
+	cases := []struct {
+		name   string
+		end    func(m *testManager, first []byte, offer persistence.Booking) error //what the first user does with the offer
+		first  string
+		second string
+	}{
+		{"offer confirmed", func(m *testManager, first []byte, offer persistence.Booking) error {
+			_, _, err := m.Pay(first, []byte(offer.ID), "pm_card")
+			return err
+		}, persistence.WaitlistAccepted, persistence.WaitlistWaiting},
+		{"offer cancelled", func(m *testManager, first []byte, offer persistence.Booking) error {
+			_, err := m.Cancel(first, []byte(offer.ID))
+			return err
+		}, persistence.WaitlistLapsed, persistence.WaitlistOffered},
+		{"offer expired", func(m *testManager, first []byte, offer persistence.Booking) error {
+			_, err := m.ExpireHolds(time.Now().Add(time.Hour))
+			return err
+		}, persistence.WaitlistLapsed, persistence.WaitlistOffered},
+		{"first user left the waitlist", func(m *testManager, first []byte, offer persistence.Booking) error {
+			return m.LeaveWaitlist(first, offer.EventID)
+		}, "", persistence.WaitlistOffered},
+	}
+	for _, c := range cases {
+		m := newTestManager()
+		m.Offers = instantOffers{m.Manager}
+		eventID := m.addEvent(t, persistence.Event{Capacity: 2, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+		owner := m.addUser(t)
+		sold, err := m.Hold(owner, persistence.Booking{EventID: eventID, Seats: 2, TicketType: standard.Name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, second := m.addUser(t), m.addUser(t)
+		for _, userID := range [][]byte{first, second} {
+			if _, err := m.JoinWaitlist(userID, eventID, 2, standard.Name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := m.Hold(m.addUser(t), persistence.Booking{EventID: eventID, Seats: 1, TicketType: standard.Name}); err != ErrNotEnoughSeats {
+			t.Errorf("%s: expected the waitlist to come first, got %v", c.name, err)
+		}
+
+		//The owner gives up the seats, which go to the first user in line.
+		if _, err := m.Cancel(owner, []byte(sold.ID)); err != nil {
+			t.Fatal(err)
+		}
+		entry, position, err := m.WaitlistPosition(first, eventID)
+		if err != nil || entry.Status != persistence.WaitlistOffered || position != 1 {
+			t.Fatalf("%s: expected the first user to be offered the seats, got %+v at %d and %v", c.name, entry, position, err)
+		}
+		bookingID, _ := hex.DecodeString(entry.BookingID)
+		offer := m.booking(t, first, persistence.Booking{ID: string(bookingID)})
+		if offer.Status != persistence.BookingHeld || offer.HoldExpires != entry.OfferExpires || offer.Total != 2*standard.Price {
+			t.Errorf("%s: expected the offer to be held, got %+v", c.name, offer)
+		}
+
+		if err := c.end(m, first, offer); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		entry, _, err = m.WaitlistPosition(first, eventID)
+		if c.first == "" && err != persistence.ErrNotOnWaitlist {
+			t.Errorf("%s: expected the first user to be gone, got %+v", c.name, entry)
+		}
+		if c.first != "" && entry.Status != c.first {
+			t.Errorf("%s: expected the first entry to be %s, got %+v", c.name, c.first, entry)
+		}
+		entry, _, _ = m.WaitlistPosition(second, eventID)
+		if entry.Status != c.second {
+			t.Errorf("%s: expected the second entry to be %s, got %+v", c.name, c.second, entry)
+		}
+	}
+}
+
+//TestWithdrawnOffer fails the saga of an offer, which puts the user back in line.
+func TestWithdrawnOffer(t *testing.T) {
+	m := newTestManager()
+	started := []persistence.Booking{}
+	m.Offers = offerFunc(func(userID []byte, offer persistence.Booking) (persistence.BookingSaga, error) {
+		started = append(started, offer)
+		return persistence.BookingSaga{ID: offer.SagaID}, nil
+	})
+	eventID := m.addEvent(t, persistence.Event{Capacity: 2, TicketTypes: []persistence.TicketType{standard}})
+	owner, user := m.addUser(t), m.addUser(t)
+	sold, err := m.Hold(owner, persistence.Booking{EventID: eventID, Seats: 2, TicketType: standard.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.JoinWaitlist(user, eventID, 1, standard.Name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Cancel(owner, []byte(sold.ID)); err != nil {
+		t.Fatal(err)
+	}
+	entry, _, _ := m.WaitlistPosition(user, eventID)
+	if len(started) != 1 || entry.Status != persistence.WaitlistOffering || entry.SagaID != started[0].SagaID {
+		t.Fatalf("expected a saga to be started for the offer, got %+v and %+v", started, entry)
+	}
+
+	//A saga that is not the one of the entry can neither hold nor withdraw the offer.
+	stale := started[0]
+	stale.SagaID = "stale"
+	if _, err := m.HoldOffer(user, stale); err != persistence.ErrNotOnWaitlist {
+		t.Errorf("expected the offer of another saga to be refused, got %v", err)
+	}
+	m.WithdrawOffer(user, eventID, "stale")
+	if entry, _, _ := m.WaitlistPosition(user, eventID); entry.Status != persistence.WaitlistOffering {
+		t.Errorf("expected the offer to stay, got %+v", entry)
+	}
+
+	m.WithdrawOffer(user, eventID, started[0].SagaID)
+	entry, position, _ := m.WaitlistPosition(user, eventID)
+	if entry.Status != persistence.WaitlistWaiting || entry.SagaID != "" || position != 1 {
+		t.Errorf("expected the user to be back in line, got %+v at %d", entry, position)
+	}
+}
+
+type offerFunc func(userID []byte, offer persistence.Booking) (persistence.BookingSaga, error)
+
+func (f offerFunc) StartOffer(userID []byte, offer persistence.Booking) (persistence.BookingSaga, error) {
+	return f(userID, offer)
+}
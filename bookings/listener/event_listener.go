@@ -1,37 +1,73 @@
 package listener
 
 import (
-	"log"
+	"context"
+	"encoding/hex"
+	"log/slog"
 
+	"github.com/doublen987/web_dev/MyEvents/bookings/lifecycle"
+	"github.com/doublen987/web_dev/MyEvents/bookings/saga"
 	"github.com/doublen987/web_dev/MyEvents/contracts"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 )
 
 type EventProcessor struct {
 	EventListener msgqueue.EventListener
 	Database      persistence.DatabaseHandler
+	Bookings      *lifecycle.Manager
+	Sagas         *saga.Coordinator
+	Replay        bool //set while the projections are rebuilt from the event store, skips side effects like refunds
+
+	ctx context.Context //the delivery being handled, see withContext
 }
 
 //Here we listen for newly created events
 func (p *EventProcessor) ProcessEvents() error {
-	log.Println("Listening to events...")
-	received, errors, err := p.EventListener.Listen("event.created", "event.update", "user.created", "user.remove")
+	slog.Info("listening to events")
+	deliveries, errors, err := p.EventListener.ListenContext("event.created", "event.update", "event.cancelled", "promocode.created", "user.created", "user.remove",
+		"seats.reserved", "seats.rejected")
 	if err != nil {
 		return err
 	}
 	for {
 		select {
-		case evt := <-received:
-			//Received events will be passed to the handleEvent function
-			p.handleEvent(evt)
+		case delivery := <-deliveries:
+			//Received events will be passed to the HandleEvent function
+			p.process(delivery)
 		case err = <-errors:
-			log.Printf("received error while processing msg: %s", err)
+			slog.Error("could not receive event", "error", err)
 		}
 	}
 }
 
-func (p *EventProcessor) handleEvent(event msgqueue.Event) {
+//process handles a delivered event in a consumer span, so that the answer to a seat request ends up
+//in the trace of the booking that asked for it.
+func (p *EventProcessor) process(delivery msgqueue.Delivery) {
+	ctx, span := tracing.Start(delivery.Context, "process "+delivery.Event.EventName(), tracing.Consumer)
+	defer span.Finish()
+	p.withContext(ctx).HandleEvent(delivery.Event)
+}
+
+//withContext returns a processor whose database calls and booking transitions are part of the trace
+//the context carries.
+func (p *EventProcessor) withContext(ctx context.Context) *EventProcessor {
+	bound := *p
+	bound.ctx = ctx
+	bound.Database = persistence.WithContext(ctx, p.Database)
+	if p.Bookings != nil {
+		bound.Bookings = p.Bookings.WithContext(ctx)
+	}
+	if p.Sagas != nil {
+		bound.Sagas = p.Sagas.WithContext(ctx)
+	}
+	return &bound
+}
+
+//HandleEvent stores a received event in the projections of the service. It is also used to
+//rebuild the projections from the event store.
+func (p *EventProcessor) HandleEvent(event msgqueue.Event) {
 	//The function uses a type switch to determine the type of the incoming event. Then we store the events
 	//in the local database. In this example, we are using a shared library github.com/doublen987/MyEvents/lib/persistence
 	//for managing database access. This is for convenience only. In real microservice architectures,
@@ -39,27 +75,116 @@ func (p *EventProcessor) handleEvent(event msgqueue.Event) {
 	//built on completely different technology stacks.
 	switch e := event.(type) {
 	case *contracts.EventCreatedEvent:
-		log.Printf("event %s created: %s", e.ID, e)
+		slog.InfoContext(p.ctx, "event created", "event", e.ID)
+		//Contracts carry the ID hex encoded, the projection stores it the way FindEvent looks it up.
+		id, err := hex.DecodeString(e.ID)
+		if err != nil {
+			slog.WarnContext(p.ctx, "malformed event id", "event", e.ID)
+			return
+		}
 		p.Database.AddEvent(persistence.Event{
-			ID:        e.ID,
+			ID:        string(id),
 			Name:      e.Name,
-			Duration:  e.End.Hour(),
-			StartDate: int64(e.Start.Hour()),
-			EndDate:   int64(e.End.Hour()),
+			Duration:  int(e.End.Sub(e.Start).Minutes()),
+			StartDate: e.Start.Unix(),
+			EndDate:   e.End.Unix(),
+			//The projection only needs the location to show it in calendars.
+			Location:    persistence.Location{ID: e.LocationID, Name: e.Venue, TimeZone: e.TimeZone},
+			Capacity:    e.Capacity,
+			Hall:        e.Hall,
+			SeatMap:     e.SeatMap,
+			TicketTypes: e.TicketTypes,
+			SeriesID:    e.SeriesID,
+		})
+	case *contracts.EventUpdatedEvent:
+		slog.InfoContext(p.ctx, "event updated", "event", e.ID, "sequence", e.Sequence)
+		id, err := hex.DecodeString(e.ID)
+		if err != nil {
+			slog.WarnContext(p.ctx, "malformed event id", "event", e.ID)
+			return
+		}
+		event, err := p.Database.FindEvent(id)
+		if err != nil {
+			slog.ErrorContext(p.ctx, "could not load updated event", "event", e.ID, "error", err)
+			return
+		}
+		event.Name = e.Name
+		event.StartDate = e.Start.Unix()
+		event.EndDate = e.End.Unix()
+		event.Duration = int(e.End.Sub(e.Start).Minutes())
+		event.Sequence = e.Sequence
+		if err := p.Database.UpdateEvent(event); err != nil {
+			slog.ErrorContext(p.ctx, "could not update event", "event", e.ID, "error", err)
+		}
+	case *contracts.EventCancelledEvent:
+		slog.InfoContext(p.ctx, "event cancelled", "event", e.ID, "reason", e.Reason)
+		if p.Replay {
+			//The bookings of the event were refunded when the event was cancelled; a replay only
+			//needs to mark the event as cancelled.
+			id, err := hex.DecodeString(e.ID)
+			if err != nil {
+				slog.WarnContext(p.ctx, "malformed event id", "event", e.ID)
+				return
+			}
+			p.Database.CancelEvent(id, e.CancelledAt)
+			return
+		}
+		//Refunding every booking of an event can take a while, and the refund job keeps track of
+		//its own progress, so we don't hold up the other incoming events for it.
+		go func() {
+			job, err := p.Bookings.CancelEvent(e.ID, e.CancelledAt)
+			if err != nil {
+				slog.ErrorContext(p.ctx, "refunds of cancelled event are incomplete", "event", e.ID, "error", err)
+				return
+			}
+			slog.InfoContext(p.ctx, "refunded bookings of cancelled event", "event", e.ID, "bookings", job.Processed)
+		}()
+	case *contracts.PromoCodeCreatedEvent:
+		slog.InfoContext(p.ctx, "promo code created", "event", e.EventID, "code", e.Code)
+		p.Database.AddPromoCode(persistence.PromoCode{
+			EventID:        e.EventID,
+			Code:           e.Code,
+			Kind:           e.Kind,
+			Percent:        e.Percent,
+			Amount:         e.Amount,
+			Currency:       e.Currency,
+			MaxUses:        e.MaxUses,
+			MaxUsesPerUser: e.MaxUsesPerUser,
+			ValidFrom:      e.ValidFrom,
+			ValidUntil:     e.ValidUntil,
+			TicketTypes:    e.TicketTypes,
 		})
 	case *contracts.LocationCreatedEvent:
-		log.Printf("location %s created: %s", e.ID, e)
+		slog.InfoContext(p.ctx, "location created", "location", e.ID)
 		//p.Database.AddLocation(persistence.Location{ID: e.ID})
 	case *contracts.UserCreatedEvent:
-		log.Printf("user %s created: %s", e.ID, e)
+		slog.InfoContext(p.ctx, "user created", "user", e.ID)
+		id, err := hex.DecodeString(e.ID)
+		if err != nil {
+			slog.WarnContext(p.ctx, "malformed user id", "user", e.ID)
+			return
+		}
 		p.Database.AddUser(persistence.User{
-			ID:       e.ID,
+			ID:       string(id),
 			First:    e.First,
 			Last:     e.Last,
 			Age:      e.Age,
 			Bookings: []persistence.Booking{},
 		})
+	case *contracts.SeatsReservedEvent:
+		//The answers of the events service belong to sagas that are over by the time we replay.
+		if p.Replay {
+			return
+		}
+		slog.InfoContext(p.ctx, "seats reserved", "event", e.EventID, "saga", e.SagaID)
+		p.Sagas.SeatsReserved(e)
+	case *contracts.SeatsRejectedEvent:
+		if p.Replay {
+			return
+		}
+		slog.InfoContext(p.ctx, "seats rejected", "event", e.EventID, "saga", e.SagaID, "reason", e.Reason)
+		p.Sagas.SeatsRejected(e)
 	default:
-		log.Printf("unknown event: %t", e)
+		slog.WarnContext(p.ctx, "unknown event", "event", event.EventName())
 	}
 }
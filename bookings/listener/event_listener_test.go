@@ -0,0 +1,67 @@
+package listener
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/bookings/lifecycle"
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/memlayer"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type recordingEmitter struct {
+	events []msgqueue.Event
+}
+
+func (r *recordingEmitter) Emit(e msgqueue.Event) error {
+	r.events = append(r.events, e)
+	return nil
+}
+
+//TestEventCreatedCanBeHeld books an event the way the bookings service learns about it and its
+//users, through the event.created and user.created contracts, which carry the IDs hex encoded.
+func TestEventCreatedCanBeHeld(t *testing.T) {
+	db := memlayer.NewMemoryLayer()
+	p := &EventProcessor{
+		Database: db,
+		Bookings: &lifecycle.Manager{Database: db, EventEmitter: &recordingEmitter{}, HoldDuration: 10 * time.Minute},
+	}
+	eventID := bson.NewObjectId().Hex()
+	start := time.Now().Add(30 * 24 * time.Hour)
+	p.HandleEvent(&contracts.EventCreatedEvent{
+		ID:          eventID,
+		Name:        "Opera",
+		Start:       start,
+		End:         start.Add(3 * time.Hour),
+		Capacity:    3,
+		TicketTypes: []persistence.TicketType{{Name: "Standard", Price: 1500, Currency: "EUR"}},
+	})
+	//Users arrive through the user.created contract as well, with their ID hex encoded.
+	userID := []byte(bson.NewObjectId())
+	p.HandleEvent(&contracts.UserCreatedEvent{ID: hex.EncodeToString(userID), First: "Ada"})
+
+	cases := []struct {
+		name  string
+		seats int
+		err   error
+		total int64
+	}{
+		{"hold within capacity", 2, nil, 3000},
+		{"hold past capacity", 2, lifecycle.ErrNotEnoughSeats, 0},
+		{"hold of the last seat", 1, nil, 1500},
+	}
+	for _, c := range cases {
+		bk, err := p.Bookings.Hold(userID, persistence.Booking{EventID: eventID, Seats: c.seats, TicketType: "Standard"})
+		if err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+			continue
+		}
+		if err == nil && (bk.Status != persistence.BookingHeld || bk.Total != c.total) {
+			t.Errorf("%s: expected a hold of %d, got %+v", c.name, c.total, bk)
+		}
+	}
+}
@@ -1,237 +1,300 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/doublen987/web_dev/MyEvents/bookings/lifecycle"
 	"github.com/doublen987/web_dev/MyEvents/bookings/listener"
-	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/bookings/saga"
 	"github.com/doublen987/web_dev/MyEvents/lib/configuration"
+	"github.com/doublen987/web_dev/MyEvents/lib/eventstore"
+	"github.com/doublen987/web_dev/MyEvents/lib/health"
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
 	msgqueue_amqp "github.com/doublen987/web_dev/MyEvents/lib/msgqueue/amqp"
+	"github.com/doublen987/web_dev/MyEvents/lib/openapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/payments"
+	"github.com/doublen987/web_dev/MyEvents/lib/tickets"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 
-	//"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
 	//"github.com/doublen987/web_dev/MyEvents/contracts"
+	//"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence/dblayer"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 )
 
-type eventRef struct {
-	ID   string `json:"id"`
-	Name string `json:"name,omitempty"`
-}
-
-type createBookingRequest struct {
-	Seats int `json:"seats"`
-}
-
-type createBookingResponse struct {
-	ID    string   `json:"id"`
-	Event eventRef `json:"event"`
-}
-
 type BookingHandler struct {
-	database     persistence.DatabaseHandler
-	eventEmitter msgqueue.EventEmitter
-}
-
-type errorResponse struct {
-	Msg string `json:"msg"`
+	database       persistence.DatabaseHandler
+	eventEmitter   msgqueue.EventEmitter
+	bookings       *lifecycle.Manager
+	sagas          *saga.Coordinator
+	sagaWait       time.Duration //how long a booking request waits for its saga
+	calendarSecret []byte
 }
 
-func newBookingHandler(databaseHandler persistence.DatabaseHandler, eventEmitter msgqueue.EventEmitter) *BookingHandler {
+func newBookingHandler(databaseHandler persistence.DatabaseHandler, eventEmitter msgqueue.EventEmitter, bookingManager *lifecycle.Manager, sagas *saga.Coordinator, sagaWait time.Duration, calendarSecret []byte) *BookingHandler {
 	return &BookingHandler{
-		database:     databaseHandler,
-		eventEmitter: eventEmitter,
+		database:       databaseHandler,
+		eventEmitter:   eventEmitter,
+		bookings:       bookingManager,
+		sagas:          sagas,
+		sagaWait:       sagaWait,
+		calendarSecret: calendarSecret,
 	}
 }
 
+//withContext returns a handler whose database calls and emitted events are part of the trace
+//the context carries.
+func (bh *BookingHandler) withContext(ctx context.Context) *BookingHandler {
+	bound := *bh
+	bound.database = persistence.WithContext(ctx, bh.database)
+	bound.eventEmitter = msgqueue.WithContext(ctx, bh.eventEmitter)
+	bound.bookings = bh.bookings.WithContext(ctx)
+	bound.sagas = bh.sagas.WithContext(ctx)
+	return &bound
+}
+
 func (bh *BookingHandler) findBookingHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	criteria, ok := vars["SearchCriteria"]
-	if !ok {
-		w.WriteHeader(400)
-		fmt.Fprint(w, `{error: No search criteria found, you can either search by id via /id/4 to 
-			search by name via /name/coldplayconcert}`)
-		return
-	}
-	searchkey, ok := vars["search"]
-	if !ok {
-		w.WriteHeader(400)
-		fmt.Fprint(w, `{error: No search keys found, you can either search by id via /id/4 to
-			search by name via /name/coldplayconcert}`)
-		return
-	}
+	criteria := vars["SearchCriteria"]
+	searchkey := vars["search"]
 	var bookings []persistence.Booking
-	var err error
 	switch strings.ToLower(criteria) {
 	case "id":
+		userID, err := hex.DecodeString(vars["userID"])
+		if err != nil {
+			httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", vars["userID"])
+			return
+		}
 		id, err := hex.DecodeString(searchkey)
-		userID, err := hex.DecodeString(searchkey)
-		if err == nil {
-			fmt.Println(bh.database)
-			booking, findErr := bh.database.FindBookingByBookingId(userID, id)
-			fmt.Println(booking)
-			if findErr != nil {
-				fmt.Println(findErr)
-			}
-			fmt.Printf("id: %s\n", searchkey)
-			bookings = append(bookings, booking)
-		} else {
-			fmt.Println(err)
+		if err != nil {
+			httpapi.Errorf(w, r, http.StatusBadRequest, "malformed booking id %s", searchkey)
+			return
 		}
-		break
-	case "userId":
+		booking, err := bh.database.FindBookingByBookingId(userID, id)
+		if err != nil {
+			httpapi.Errorf(w, r, http.StatusNotFound, "no booking with id %s", searchkey)
+			return
+		}
+		bookings = append(bookings, booking)
+	case "userid":
 		userID, err := hex.DecodeString(searchkey)
-		if err == nil {
-			foundBookings, err := bh.database.FindBookingsByUserId(userID)
-			bookings = append(bookings, foundBookings...)
-			if err != nil {
-				fmt.Println(err)
-			}
-		} else {
-			fmt.Println(err)
+		if err != nil {
+			httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", searchkey)
+			return
 		}
-		break
-	}
-	if err != nil {
-		fmt.Fprintf(w, "{error %s}", err)
+		bookings, err = bh.database.FindBookingsByUserId(userID)
+		if err != nil {
+			httpapi.Errorf(w, r, http.StatusNotFound, "no user with id %s", searchkey)
+			return
+		}
+	default:
+		httpapi.Errorf(w, r, http.StatusBadRequest, "unknown search criteria %s, you can either search by id via /id/4 or by user via /userId/4", criteria)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json;charset=utf8")
-	json.NewEncoder(w).Encode(&bookings)
+	httpapi.JSON(w, http.StatusOK, &bookings)
 }
 
-func (bh *BookingHandler) bookEventByUserHandler(w http.ResponseWriter, r *http.Request) {
-	booking := persistence.Booking{}
+//bookingRequest is the body of a request to book seats of an event. Seats can be booked by number,
+//or picked from the seat map of the hall by their IDs.
+type bookingRequest struct {
+	EventID    string   `json:"eventId" validate:"required,format=hex"`
+	Seats      int      `json:"seats" validate:"min=1"`
+	SeatIDs    []string `json:"seatIds"`
+	TicketType string   `json:"ticketType"`
+	PromoCode  string   `json:"promoCode"`
+}
 
+func (bh *BookingHandler) bookEventByUserHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	userID := vars["userID"]
 
-	userID, ok := vars["userID"]
-	if !ok {
-		w.WriteHeader(400)
-		fmt.Fprint(w, `{error: No userID found}`)
+	request := bookingRequest{}
+	if err := httpapi.Decode(w, r, &request); err != nil {
+		httpapi.WriteError(w, r, err)
 		return
 	}
-
-	err := json.NewDecoder(r.Body).Decode(&booking)
-	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"error": "error occured while decoding booking data %s"}`, err)
-		return
+	booking := persistence.Booking{
+		EventID:    request.EventID,
+		Seats:      request.Seats,
+		SeatIDs:    request.SeatIDs,
+		TicketType: request.TicketType,
+		PromoCode:  request.PromoCode,
 	}
 
 	byteUserID, err := hex.DecodeString(userID)
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"error": "error occured while persisting booking for user %d: %s"}`, byteUserID, err)
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", userID)
+		return
 	}
 
-	id, err := bh.database.AddBookingForUser(byteUserID, booking)
-	fmt.Fprint(w, `{"id":%d}`, id)
-	if err != nil {
-		fmt.Println(err)
+	if len(booking.SeatIDs) > 0 {
+		booking.Seats = len(booking.SeatIDs)
 	}
-
 	if booking.Seats <= 0 {
-		w.WriteHeader(400)
-		fmt.Fprintf(w, "seat number must be positive (was %d)", booking.Seats)
+		httpapi.Error(w, r, http.StatusUnprocessableEntity, "seats or seat IDs are required")
 		return
 	}
 
-	msg := contracts.EventBookedEvent{
-		EventID: string(booking.EventID),
-		UserID:  string(booking.ID),
+	//The events service owns the capacity of the event, so the seats are reserved there first by a
+	//saga, which then holds the booking. The user has to confirm the hold through the confirm
+	//endpoint before it expires.
+	bookingSaga, err := bh.sagas.Start(byteUserID, booking)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "booking could not be started", "user", userID, "error", err)
+		httpapi.Error(w, r, http.StatusServiceUnavailable, "booking could not be started")
+		return
+	}
+	bookingSaga, err = bh.sagas.Wait(bookingSaga.ID, bh.sagaWait)
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
 	}
-	bh.eventEmitter.Emit(&msg)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(201)
 
-	json.NewEncoder(w).Encode(&booking)
+	switch bookingSaga.Status {
+	case persistence.SagaCompleted:
+		bookingID, _ := hex.DecodeString(bookingSaga.BookingID)
+		booking, err = bh.database.FindBookingByBookingId(byteUserID, bookingID)
+		if err != nil {
+			httpapi.InternalError(w, r, err)
+			return
+		}
+		httpapi.JSON(w, http.StatusCreated, &booking)
+	case persistence.SagaRejected, persistence.SagaCompensated:
+		httpapi.Error(w, r, http.StatusConflict, bookingSaga.Reason)
+	case persistence.SagaTimedOut:
+		httpapi.Error(w, r, http.StatusServiceUnavailable, bookingSaga.Reason)
+	default:
+		//The booking is still being made. The client follows its saga until it is done.
+		w.Header().Set("Location", fmt.Sprintf("/users/%s/bookings/sagas/%s", userID, bookingSaga.ID))
+		httpapi.JSON(w, http.StatusAccepted, &bookingSaga)
+	}
 }
 
-func (bh *BookingHandler) bookEventHandler(w http.ResponseWriter, r *http.Request) {
-	routeVars := mux.Vars(r)
-	eventID, ok := routeVars["eventID"]
-	if !ok {
-		w.WriteHeader(400)
-		fmt.Fprint(w, "missing route parameter 'eventID'")
+//sagaHandler shows how far the saga of a booking got. Once it completed, it points to the booking.
+func (bh *BookingHandler) sagaHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingSaga, err := bh.sagas.Find(vars["sagaID"])
+	if err != nil || bookingSaga.UserID != vars["userID"] {
+		httpapi.Error(w, r, http.StatusNotFound, saga.ErrSagaNotFound.Error())
 		return
 	}
+	httpapi.JSON(w, http.StatusOK, &bookingSaga)
+}
 
-	eventIDMongo, _ := hex.DecodeString(eventID)
-	event, err := bh.database.FindEvent(eventIDMongo)
+func (bh *BookingHandler) confirmBookingHandler(w http.ResponseWriter, r *http.Request) {
+	bh.transitionBookingHandler(w, r, bh.bookings.Confirm)
+}
+
+func (bh *BookingHandler) cancelBookingHandler(w http.ResponseWriter, r *http.Request) {
+	bh.transitionBookingHandler(w, r, bh.bookings.Cancel)
+}
+
+//transitionBookingHandler decodes the user and booking IDs from the route and applies one of the
+//lifecycle transitions of the booking manager to the booking.
+func (bh *BookingHandler) transitionBookingHandler(w http.ResponseWriter, r *http.Request, transition func([]byte, []byte) (persistence.Booking, error)) {
+	vars := mux.Vars(r)
+	userID, err := hex.DecodeString(vars["userID"])
 	if err != nil {
-		w.WriteHeader(404)
-		fmt.Fprintf(w, "event %s could not be loaded: %s", eventID, err)
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", vars["userID"])
 		return
 	}
-
-	bookingRequest := createBookingRequest{}
-	err = json.NewDecoder(r.Body).Decode(&bookingRequest)
+	bookingID, err := hex.DecodeString(vars["bookingID"])
 	if err != nil {
-		w.WriteHeader(400)
-		fmt.Fprintf(w, "could not decode JSON body: %s", err)
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed booking id %s", vars["bookingID"])
 		return
 	}
 
-	if bookingRequest.Seats <= 0 {
-		w.WriteHeader(400)
-		fmt.Fprintf(w, "seat number must be positive (was %d)", bookingRequest.Seats)
+	booking, err := transition(userID, bookingID)
+	if err != nil {
+		httpapi.WriteError(w, r, lifecycleProblem(err))
 		return
 	}
 
-	booking := persistence.Booking{
-		Date:    time.Now().Unix(),
-		EventID: event.ID,
-		Seats:   bookingRequest.Seats,
-	}
-
-	// msg := contracts.EventBookedEvent{
-	// 	EventID: event.ID.Hex(),
-	// 	UserID:  "someUserID",
-	// }
-	// h.eventEmitter.Emit(&msg)
-
-	bh.database.AddBookingForUser([]byte("someUserID"), booking)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(201)
-
-	json.NewEncoder(w).Encode(&booking)
+	httpapi.JSON(w, http.StatusOK, &booking)
 }
 
-func respondWithError(res http.ResponseWriter, msg string, code int) error {
-	response := errorResponse{msg}
-	jsonResponse, err := json.Marshal(&response)
-	if err != nil {
+//lifecycleProblem turns the errors of the booking manager that are the client's doing into problems.
+//Anything else is left as it is, and answered as an internal error.
+func lifecycleProblem(err error) error {
+	status := lifecycleErrorStatus(err)
+	if status == http.StatusInternalServerError {
 		return err
 	}
+	return httpapi.NewProblem(status, err.Error())
+}
 
-	res.WriteHeader(code)
-	res.Header().Set("Content-Type", "application/json;charset=utf8")
-
-	_, err = res.Write(jsonResponse)
-	if err != nil {
-		return err
+func lifecycleErrorStatus(err error) int {
+	switch err {
+	case lifecycle.ErrEventNotFound, lifecycle.ErrBookingNotFound:
+		return 404
+	case lifecycle.ErrNotEnoughSeats, lifecycle.ErrNotHeld, lifecycle.ErrNotCancellable, lifecycle.ErrCancelCutoff:
+		return 409
+	case lifecycle.ErrSeatsAvailable, persistence.ErrAlreadyOnWaitlist:
+		return 409
+	case persistence.ErrSeatTaken:
+		return 409
+	case lifecycle.ErrSeatsRequired, lifecycle.ErrNoSeatMap, lifecycle.ErrInvalidSeats:
+		return 400
+	case lifecycle.ErrExceedsCapacity:
+		return 400
+	case lifecycle.ErrTicketTypeRequired, lifecycle.ErrUnknownTicketType:
+		return 400
+	case lifecycle.ErrInvalidPromoCode, lifecycle.ErrPromoCodeNotApplicable:
+		return 400
+	case lifecycle.ErrPromoCodeExpired, persistence.ErrPromoCodeUsedUp, persistence.ErrPromoCodeUserLimit:
+		return 409
+	case lifecycle.ErrNotOnSale, lifecycle.ErrTicketTypeSoldOut:
+		return 409
+	case lifecycle.ErrPaymentRequired, lifecycle.ErrPaymentDeclined:
+		return 402
+	case lifecycle.ErrNothingToPay, lifecycle.ErrEventCancelled:
+		return 409
+	case lifecycle.ErrUnknownPayment:
+		return 400
+	case persistence.ErrNotOnWaitlist:
+		return 404
+	case lifecycle.ErrNotConfirmed, persistence.ErrTicketCheckedIn:
+		return 409
+	case tickets.ErrMalformedToken:
+		return 400
+	case tickets.ErrInvalidSignature, lifecycle.ErrTicketInvalid:
+		return 403
 	}
-
-	return nil
+	return 500
 }
 
-func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.DatabaseHandler, eventEmitter msgqueue.EventEmitter) (chan error, chan error) {
+//newRouter routes the requests to the service to the handler. Every route is described by apiSpec.
+func newRouter(handler *BookingHandler, checker *health.Checker) *mux.Router {
 	//With this we get a router object called r, to help  us define our routes and link them
 	//with actions to execute:
 	r := mux.NewRouter()
+	//Requests get an ID, continue the correlation and the trace of their caller, or start new ones,
+	//and are counted and timed.
+	r.Use(logging.Middleware, tracing.Middleware, metrics.Middleware)
+	//Unknown routes and methods get the same kind of error body as everything else.
+	r.NotFoundHandler = http.HandlerFunc(httpapi.NotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(httpapi.MethodNotAllowed)
+	//Prometheus scrapes the metrics of the service from here:
+	r.Methods("GET").Path("/metrics").Handler(metrics.Handler())
+	//The orchestrator asks whether the service is alive and ready to take requests here:
+	r.Methods("GET").Path("/healthz").HandlerFunc(checker.LivenessHandler)
+	r.Methods("GET").Path("/readyz").HandlerFunc(checker.ReadinessHandler)
+	//Clients look up the routes of the service, and the bodies they take and return, here:
+	r.Methods("GET").Path("/openapi.json").Handler(openapi.Handler(apiSpec()))
 	//A subrouter is basically an object that will in charge of any incoming HTTP request
 	//directed towards a relative URL that starts with /events. This code makes use of the
 	//router object we created earlier, then calls the PathPrefix method, which is used to
@@ -240,15 +303,56 @@ func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.D
 	//the /events prefix.
 	eventsrouter := r.PathPrefix("/users/{userID}/bookings").Subrouter()
 
-	handler := newBookingHandler(databaseHandler, eventEmitter)
+	//Every request is handled by a copy of the handler that is bound to the trace of the request.
+	route := func(handle func(*BookingHandler, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			handle(handler.withContext(r.Context()), w, r)
+		}
+	}
+	//The tickets of a confirmed booking have to be registered before the search route, which would
+	//otherwise take /{bookingID}/tickets for a search:
+	eventsrouter.Methods("GET").Path("/{bookingID}/tickets").HandlerFunc(route((*BookingHandler).ticketsHandler))
+	eventsrouter.Methods("GET").Path("/{bookingID}/tickets/{number:[0-9]+}.png").HandlerFunc(route((*BookingHandler).ticketQRHandler))
+	//The same goes for the sagas of bookings that are still being made:
+	eventsrouter.Methods("GET").Path("/sagas/{sagaID}").HandlerFunc(route((*BookingHandler).sagaHandler))
 	//Here we implement the search functionality by id(/events/id/3434) or name(/events/name/jazz_concert).
-	eventsrouter.Methods("GET").Path("/{SearchCriteria}/{search}").HandlerFunc(handler.findBookingHandler)
+	eventsrouter.Methods("GET").Path("/{SearchCriteria}/{search}").HandlerFunc(route((*BookingHandler).findBookingHandler))
 	//Here we implement the retrival of all events at once:
 	//eventsrouter.Methods("GET").Path("").HandlerFunc(handler.allBookingsHandler)
 	//Here we implement the creation of a new event (/events):
 	//eventsrouter.Methods("POST").Path("/{userID}").HandlerFunc(handler.newBookingHandler)
 
-	eventsrouter.Methods("POST").Path("/").HandlerFunc(handler.bookEventByUserHandler)
+	eventsrouter.Methods("POST").Path("/").HandlerFunc(route((*BookingHandler).bookEventByUserHandler))
+	//Users subscribe to their bookings in calendar apps through a feed URL with a secret token:
+	eventsrouter.Methods("GET").Path("/calendar").HandlerFunc(route((*BookingHandler).calendarLinkHandler))
+	eventsrouter.Methods("GET").Path("/calendar.ics").HandlerFunc(route((*BookingHandler).calendarFeedHandler))
+	//A held booking is either confirmed or cancelled by the user:
+	eventsrouter.Methods("POST").Path("/{bookingID}/confirm").HandlerFunc(route((*BookingHandler).confirmBookingHandler))
+	eventsrouter.Methods("POST").Path("/{bookingID}/cancel").HandlerFunc(route((*BookingHandler).cancelBookingHandler))
+	//Bookings that cost money are confirmed by paying for them:
+	eventsrouter.Methods("POST").Path("/{bookingID}/pay").HandlerFunc(route((*BookingHandler).payBookingHandler))
+	//The payment provider reports payments that settle later through this webhook:
+	r.Methods("POST").Path("/payments/webhook").HandlerFunc(route((*BookingHandler).paymentWebhookHandler))
+
+	//Users can join the waitlist of a sold out event, check their position in line, or leave it:
+	waitlistrouter := r.PathPrefix("/users/{userID}/waitlist").Subrouter()
+	waitlistrouter.Methods("POST").Path("/{eventID}").HandlerFunc(route((*BookingHandler).joinWaitlistHandler))
+	waitlistrouter.Methods("GET").Path("/{eventID}").HandlerFunc(route((*BookingHandler).waitlistPositionHandler))
+	waitlistrouter.Methods("DELETE").Path("/{eventID}").HandlerFunc(route((*BookingHandler).leaveWaitlistHandler))
+
+	//Here we implement the live seat availability of events with assigned seating:
+	r.Methods("GET").Path("/events/{eventID}/seats").HandlerFunc(route((*BookingHandler).seatAvailabilityHandler))
+	//Here we implement the progress of refunding a cancelled event:
+	r.Methods("GET").Path("/events/{eventID}/refunds").HandlerFunc(route((*BookingHandler).refundJobHandler))
+
+	//Door staff check tickets in, and verifiers that work offline fetch the key to check them with:
+	r.Methods("POST").Path("/checkin").HandlerFunc(route((*BookingHandler).checkInHandler))
+	r.Methods("GET").Path("/tickets/publickey").HandlerFunc(route((*BookingHandler).ticketPublicKeyHandler))
+	return r
+}
+
+func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.DatabaseHandler, eventEmitter msgqueue.EventEmitter, bookingManager *lifecycle.Manager, sagas *saga.Coordinator, sagaWait time.Duration, calendarSecret []byte, checker *health.Checker) (chan error, chan error) {
+	r := newRouter(newBookingHandler(databaseHandler, eventEmitter, bookingManager, sagas, sagaWait, calendarSecret), checker)
 
 	//We use go channels to handle error correcting
 	httpErrChan := make(chan error)
@@ -264,20 +368,62 @@ func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.D
 	//cannot be listening while the other is listening so we have to make separate goroutins for them.
 
 	server := handlers.CORS()(r)
-	go func() { httpIsErrChan <- http.ListenAndServeTLS(tlsendpoint, "cert.pem", "key.pem", server) }()
-	go func() { httpErrChan <- http.ListenAndServe(endpoint, server) }()
-
-	fmt.Printf("Listening to port: %s\n", endpoint)
+	httpServer := &http.Server{Addr: endpoint, Handler: server}
+	httpsServer := &http.Server{Addr: tlsendpoint, Handler: server}
+	go func() {
+		if err := httpsServer.ListenAndServeTLS("cert.pem", "key.pem"); err != http.ErrServerClosed {
+			httpIsErrChan <- err
+		}
+	}()
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			httpErrChan <- err
+		}
+	}()
+	//When the service is asked to stop, it finishes the requests in flight before it exits.
+	go checker.ShutdownOnSignal(httpServer, httpsServer)
 
 	return httpErrChan, httpIsErrChan
 }
 
+//newTicketSigner creates the signer of the tickets from the configured key. Without a key the
+//service still runs, but tickets issued before a restart can no longer be verified after it.
+func newTicketSigner(key string) (*tickets.Signer, error) {
+	if key == "" {
+		slog.Warn("no ticket signing key configured, generating one; tickets will not survive a restart")
+		return tickets.GenerateSigner()
+	}
+	seed, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("ticket signing key is not valid base64: %s", err)
+	}
+	return tickets.NewSigner(seed)
+}
+
+//newCalendarSecret returns the configured secret of the calendar feed URLs. Without one the service
+//still runs, but the feed URLs handed out before a restart stop working after it.
+func newCalendarSecret(secret string) ([]byte, error) {
+	if secret != "" {
+		return []byte(secret), nil
+	}
+	slog.Warn("no calendar feed secret configured, generating one; calendar feed URLs will not survive a restart")
+	generated := make([]byte, 32)
+	_, err := rand.Read(generated)
+	return generated, err
+}
+
 func main() {
 	confPath := flag.String("config", "./bookings-config.json", "path to config file")
 	flag.Parse()
 	config, err := configuration.ExtractConfiguration(*confPath)
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		slog.Warn("could not read the configuration", "error", err)
+	}
+	if err := logging.Init("bookings", config.LogFormat, config.LogLevel); err != nil {
+		logging.Fatal("could not set up logging", "error", err)
+	}
+	if err := tracing.Init("bookings", config.TracingExporter, config.TracingEndpoint); err != nil {
+		logging.Fatal("could not set up tracing", "error", err)
 	}
 
 	configMap := make(map[string]interface{})
@@ -285,9 +431,9 @@ func main() {
 	configMap["region"] = config.AWSRegion
 	dbhandler, err := dblayer.NewPersistenceLayer(config.Databasetype, configMap)
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		slog.Error("could not connect to the database", "error", err)
 	} else {
-		fmt.Println("Connected to the database")
+		slog.Info("connected to the database")
 	}
 
 	// conn, err := amqp.Dial(config.AMQPMessageBroker)
@@ -301,23 +447,92 @@ func main() {
 
 	eventListener, err := msgqueue_amqp.NewAMQPEventListener(conn2, "myevents", "bookings")
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		slog.Error("could not create the event listener", "error", err)
+	}
+	codec, err := msgqueue.NewCodec(config.MessageCodec)
+	if err != nil {
+		logging.Fatal("could not create the message codec", "error", err)
 	}
-	eventEmitter, err := msgqueue_amqp.NewAMQPEventEmitter(conn2, "myevents")
+	eventEmitter, err := msgqueue_amqp.NewAMQPEventEmitter(conn2, "myevents", codec)
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		slog.Error("could not create the event emitter", "error", err)
+	}
+	//Every contract we emit is recorded in the event store as well, so that projections can be rebuilt.
+	eventStore, err := eventstore.NewEventStore(config.Databasetype, map[string]interface{}{
+		"connection": config.EventStoreConnection,
+		"region":     config.AWSRegion,
+	})
+	if err != nil {
+		slog.Error("could not connect to the event store", "error", err)
+	} else {
+		eventEmitter = &eventstore.RecordingEmitter{Store: eventStore, Emitter: eventEmitter}
+	}
+
+	paymentProvider, err := payments.NewPaymentProvider(config.PaymentProvider, map[string]interface{}{
+		"webhook_secret": config.PaymentWebhookSecret,
+		"webhook_url":    config.PaymentWebhookURL,
+		"delay":          time.Duration(config.FakePaymentDelaySeconds) * time.Second,
+	})
+	if err != nil {
+		logging.Fatal("could not create the payment provider", "error", err)
 	}
 
-	processor := &listener.EventProcessor{eventListener, dbhandler}
-	go processor.ProcessEvents()
+	ticketSigner, err := newTicketSigner(config.TicketSigningKey)
+	if err != nil {
+		logging.Fatal("could not create the ticket signer", "error", err)
+	}
+
+	bookingManager := &lifecycle.Manager{
+		Database:      dbhandler,
+		EventEmitter:  eventEmitter,
+		Payments:      paymentProvider,
+		TicketSigner:  ticketSigner,
+		HoldDuration:  time.Duration(config.BookingHoldMinutes) * time.Minute,
+		OfferDuration: time.Duration(config.WaitlistOfferMinutes) * time.Minute,
+		CancelCutoff:  time.Duration(config.BookingCancelCutoff) * time.Hour,
+	}
+	sagas := &saga.Coordinator{
+		Database:     dbhandler,
+		EventEmitter: eventEmitter,
+		Bookings:     bookingManager,
+		Timeout:      time.Duration(config.BookingSagaTimeout) * time.Second,
+	}
+	//Seats offered to the waitlist are reserved with the events service like any other booking.
+	bookingManager.Offers = sagas
+
+	go bookingManager.SweepExpiredHolds(time.Duration(config.BookingSweepSeconds) * time.Second)
+	//Refund jobs of cancelled events that were interrupted by a restart pick up where they stopped.
+	go bookingManager.ResumeRefundJobs()
+	//Refunds that were recorded but not made, because the provider was down, are retried.
+	go bookingManager.SweepPendingRefunds(time.Duration(config.BookingSweepSeconds) * time.Second)
+	//Sagas that got stuck, also those of replicas that died, are given up once their deadline passed.
+	go sagas.SweepTimedOut(time.Duration(config.BookingSagaTimeout) * time.Second / 2)
+
+	//The orchestrator learns from these checks whether the service is ready to take requests.
+	checker := health.NewChecker()
+	checker.DrainDelay = time.Duration(config.ShutdownDrainSeconds) * time.Second
+	checker.AddReadiness("database", func() error { return persistence.Ping(dbhandler) })
+	checker.AddReadiness("broker", conn2.Check)
+
+	processor := &listener.EventProcessor{EventListener: eventListener, Database: dbhandler, Bookings: bookingManager, Sagas: sagas}
+	//Without its listener, the service would never learn about the events of the others.
+	checker.Go("listener", processor.ProcessEvents)
+
+	calendarSecret, err := newCalendarSecret(config.CalendarFeedSecret)
+	if err != nil {
+		logging.Fatal("could not create the calendar feed secret", "error", err)
+	}
 
-	httpErrChan, httpIsErrChan := ServeAPI(config.RestfulEndpoint, config.RestfulTLSEndpoint, dbhandler, eventEmitter)
-	fmt.Printf("Started listening for http connections on: %s\n", config.RestfulEndpoint)
+	httpErrChan, httpIsErrChan := ServeAPI(config.RestfulEndpoint, config.RestfulTLSEndpoint, dbhandler, eventEmitter, bookingManager, sagas, time.Duration(config.BookingSagaWait)*time.Second, calendarSecret, checker)
+	slog.Info("listening for http connections", "endpoint", config.RestfulEndpoint, "tls_endpoint", config.RestfulTLSEndpoint)
 
 	select {
 	case err := <-httpErrChan:
-		log.Fatal("HTTP Error: ", err)
+		logging.Fatal("http server failed", "error", err)
 	case err := <-httpIsErrChan:
-		log.Fatal("HTTPS Error: ", err)
+		logging.Fatal("https server failed", "error", err)
+	case <-checker.Stopped():
+		tracing.Flush()
+		slog.Info("stopped")
 	}
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/openapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//apiSpec describes the REST API of the bookings service.
+func apiSpec() *openapi.Document {
+	d := openapi.New("MyEvents bookings", "1.0.0")
+	d.AddStandard()
+
+	d.Add("POST", "/users/{userID}/bookings/", "createBooking", "Books seats of an event; bookings that take longer than a moment answer with their saga").
+		Tag("bookings").
+		Accepts(bookingRequest{}).
+		Returns(http.StatusCreated, persistence.Booking{}).
+		Returns(http.StatusAccepted, persistence.BookingSaga{}).
+		Fails(http.StatusConflict, http.StatusServiceUnavailable)
+	d.Add("GET", "/users/{userID}/bookings/sagas/{sagaID}", "findBookingSaga", "How far the saga of a booking got").
+		Tag("bookings").
+		Returns(http.StatusOK, persistence.BookingSaga{}).
+		Fails(http.StatusNotFound)
+	d.Add("GET", "/users/{userID}/bookings/{SearchCriteria}/{search}", "findBookings", "Finds a booking of a user by its id, or all of them with /userid/{userID}").
+		Tag("bookings").
+		Returns(http.StatusOK, []persistence.Booking{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound)
+	d.Add("POST", "/users/{userID}/bookings/{bookingID}/confirm", "confirmBooking", "Confirms a held booking").
+		Tag("bookings").
+		Returns(http.StatusOK, persistence.Booking{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict)
+	d.Add("POST", "/users/{userID}/bookings/{bookingID}/cancel", "cancelBooking", "Cancels a booking and refunds what its refund policy allows").
+		Tag("bookings").
+		Returns(http.StatusOK, persistence.Booking{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict)
+
+	d.Add("POST", "/users/{userID}/bookings/{bookingID}/pay", "payBooking", "Pays for a held booking; the payment may still be processing when this answers").
+		Tag("payments").
+		Accepts(payBookingRequest{}).
+		Returns(http.StatusOK, payBookingResponse{}).
+		Returns(http.StatusAccepted, payBookingResponse{}).
+		Fails(http.StatusPaymentRequired, http.StatusNotFound, http.StatusConflict)
+	d.Add("POST", "/payments/webhook", "paymentWebhook", "Where the payment provider reports payments that settle later; its body is signed by the provider").
+		Tag("payments").
+		Returns(http.StatusNoContent, nil).
+		Fails(http.StatusBadRequest)
+	d.Add("GET", "/events/{eventID}/refunds", "refundJob", "How far the refunds of a cancelled event have come").
+		Tag("payments").
+		Returns(http.StatusOK, persistence.RefundJob{}).
+		Fails(http.StatusNotFound)
+
+	d.Add("GET", "/users/{userID}/bookings/{bookingID}/tickets", "listTickets", "Lists the tickets of a confirmed booking").
+		Tag("tickets").
+		Returns(http.StatusOK, []ticketResponse{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict)
+	d.Add("GET", "/users/{userID}/bookings/{bookingID}/tickets/{number}.png", "ticketQRCode", "A ticket as a QR code").
+		Tag("tickets").
+		ReturnsFile(http.StatusOK, "image/png").
+		Fails(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict)
+	d.Add("POST", "/checkin", "checkIn", "Lets the holder of a ticket in; a ticket that was already used answers with its check-in").
+		Tag("tickets").
+		Accepts(checkInRequest{}).
+		Returns(http.StatusOK, persistence.TicketCheckIn{}).
+		Fails(http.StatusForbidden, http.StatusNotFound, http.StatusConflict)
+	d.Add("GET", "/tickets/publickey", "ticketPublicKey", "The key that verifiers check the signatures of tickets with").
+		Tag("tickets").
+		Returns(http.StatusOK, publicKeyResponse{})
+
+	d.Add("GET", "/users/{userID}/bookings/calendar", "calendarLink", "The URL of the calendar feed of a user").
+		Tag("calendar").
+		Returns(http.StatusOK, calendarLinkResponse{}).
+		Fails(http.StatusBadRequest)
+	d.Add("GET", "/users/{userID}/bookings/calendar.ics", "calendarFeed", "The bookings of a user as an iCalendar feed").
+		Tag("calendar").
+		Query("token", "the secret token of the feed URL").
+		ReturnsFile(http.StatusOK, "text/calendar").
+		Fails(http.StatusBadRequest, http.StatusForbidden)
+
+	d.Add("POST", "/users/{userID}/waitlist/{eventID}", "joinWaitlist", "Joins the waitlist of a sold out event").
+		Tag("waitlist").
+		Accepts(joinWaitlistRequest{}).
+		Returns(http.StatusCreated, waitlistPositionResponse{}).
+		Fails(http.StatusNotFound, http.StatusConflict)
+	d.Add("GET", "/users/{userID}/waitlist/{eventID}", "waitlistPosition", "The position of a user on the waitlist of an event").
+		Tag("waitlist").
+		Returns(http.StatusOK, waitlistPositionResponse{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound)
+	d.Add("DELETE", "/users/{userID}/waitlist/{eventID}", "leaveWaitlist", "Leaves the waitlist of an event").
+		Tag("waitlist").
+		Returns(http.StatusNoContent, nil).
+		Fails(http.StatusBadRequest, http.StatusNotFound)
+
+	d.Add("GET", "/events/{eventID}/seats", "seatAvailability", "Which seats of an event are still free").
+		Tag("seats").
+		Returns(http.StatusOK, seatAvailabilityResponse{}).
+		Fails(http.StatusNotFound)
+	return d
+}
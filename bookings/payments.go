@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/doublen987/web_dev/MyEvents/bookings/lifecycle"
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/payments"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/gorilla/mux"
+)
+
+type payBookingRequest struct {
+	Method string `json:"method" validate:"required"`
+}
+
+type payBookingResponse struct {
+	Booking persistence.Booking `json:"booking"`
+	Payment payments.Intent     `json:"payment"`
+}
+
+//payBookingHandler pays for a held booking. The response is 200 once the booking is paid and
+//confirmed, and 202 while the payment is still processing.
+func (bh *BookingHandler) payBookingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := hex.DecodeString(vars["userID"])
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", vars["userID"])
+		return
+	}
+	bookingID, err := hex.DecodeString(vars["bookingID"])
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed booking id %s", vars["bookingID"])
+		return
+	}
+	request := payBookingRequest{}
+	if err := httpapi.Decode(w, r, &request); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+
+	booking, intent, err := bh.bookings.Pay(userID, bookingID, request.Method)
+	if err != nil {
+		httpapi.WriteError(w, r, lifecycleProblem(err))
+		return
+	}
+
+	status := http.StatusOK
+	if intent.Status == payments.StatusProcessing {
+		status = http.StatusAccepted
+	}
+	httpapi.JSON(w, status, &payBookingResponse{booking, intent})
+}
+
+//paymentWebhookHandler receives the outcome of payments that settled after they were captured.
+//Calls with a valid signature are always acknowledged, so the provider does not keep retrying
+//outcomes we can't do anything about.
+func (bh *BookingHandler) paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	payload, err := httpapi.ReadBody(w, r)
+	if err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+	event, err := bh.bookings.Payments.VerifyWebhook(payload, r.Header.Get(payments.SignatureHeader))
+	if err != nil {
+		httpapi.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	_, err = bh.bookings.SettlePayment(event.Intent)
+	if err != nil && err != lifecycle.ErrPaymentDeclined {
+		slog.ErrorContext(r.Context(), "could not settle payment", "payment", event.Intent.ID, "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//refundJobHandler reports how far the refunds of a cancelled event have come.
+func (bh *BookingHandler) refundJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := bh.bookings.RefundJob(mux.Vars(r)["eventID"])
+	if err != nil {
+		httpapi.WriteError(w, r, lifecycleProblem(err))
+		return
+	}
+	httpapi.JSON(w, http.StatusOK, &job)
+}
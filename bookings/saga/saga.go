@@ -0,0 +1,267 @@
+//Package saga books events across the bookings and the events service. The events service owns the
+//capacity of an event and the bookings service owns the bookings, so a booking is made in steps:
+//
+//   - the saga is stored and the events service is asked to reserve the seats,
+//   - once it reserved them, the booking is held,
+//   - if it refused, or the booking could not be held, or the events service did not answer in time,
+//     the saga fails and seats that may have been reserved are given back.
+//
+//The state of every saga is stored, so a saga that was interrupted by a restart is either finished
+//by the answer of the events service or timed out by the sweeper of any replica.
+//
+//Seats that are offered to users on the waitlist are booked the same way, by sagas that hold the
+//booking as an offer. A failed offer puts the user back in line.
+package saga
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/bookings/lifecycle"
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+var ErrSagaNotFound = errors.New("booking saga could not be found")
+
+//pollInterval is how often Wait looks at the state of a saga. The answer of the events service may
+//be handled by another replica, so the database is the only place to learn about it.
+const pollInterval = 50 * time.Millisecond
+
+type Coordinator struct {
+	Database     persistence.DatabaseHandler
+	EventEmitter msgqueue.EventEmitter
+	Bookings     *lifecycle.Manager
+	Timeout      time.Duration //how long a saga may take before it is given up
+
+	ctx context.Context //the request or delivery the steps belong to, see WithContext
+}
+
+//WithContext returns a coordinator that takes its steps as part of the trace the context carries.
+func (c *Coordinator) WithContext(ctx context.Context) *Coordinator {
+	bound := *c
+	bound.ctx = ctx
+	bound.Database = persistence.WithContext(ctx, c.Database)
+	bound.EventEmitter = msgqueue.WithContext(ctx, c.EventEmitter)
+	if c.Bookings != nil {
+		bound.Bookings = c.Bookings.WithContext(ctx)
+	}
+	return &bound
+}
+
+//Start stores a saga that books seats of an event for the given user, and asks the events service
+//to reserve them.
+func (c *Coordinator) Start(userID []byte, bk persistence.Booking) (persistence.BookingSaga, error) {
+	id, err := persistence.NewSagaID()
+	if err != nil {
+		return persistence.BookingSaga{}, err
+	}
+	return c.start(id, userID, bk, false)
+}
+
+//StartOffer starts a saga that books the seats offered to a user on the waitlist. The waitlist
+//picks the ID of the saga, which is the SagaID of the offer.
+func (c *Coordinator) StartOffer(userID []byte, offer persistence.Booking) (persistence.BookingSaga, error) {
+	return c.start(offer.SagaID, userID, offer, true)
+}
+
+func (c *Coordinator) start(id string, userID []byte, bk persistence.Booking, offer bool) (persistence.BookingSaga, error) {
+	now := time.Now()
+	saga := persistence.BookingSaga{
+		ID:         id,
+		UserID:     hex.EncodeToString(userID),
+		EventID:    bk.EventID,
+		Seats:      bk.Seats,
+		SeatIDs:    bk.SeatIDs,
+		TicketType: bk.TicketType,
+		PromoCode:  bk.PromoCode,
+		Offer:      offer,
+		Status:     persistence.SagaReserving,
+		CreatedAt:  now.Unix(),
+		Deadline:   now.Add(c.Timeout).Unix(),
+	}
+	if err := c.Database.AddBookingSaga(saga); err != nil {
+		return persistence.BookingSaga{}, err
+	}
+	//If the request does not go out, the saga times out like it would if it got lost on the way.
+	err := c.EventEmitter.Emit(&contracts.SeatsRequestedEvent{
+		SagaID:  saga.ID,
+		EventID: saga.EventID,
+		UserID:  saga.UserID,
+		Seats:   saga.Seats,
+	})
+	return saga, err
+}
+
+//Find returns the saga with the given ID.
+func (c *Coordinator) Find(id string) (persistence.BookingSaga, error) {
+	saga, err := c.Database.FindBookingSaga(id)
+	if err != nil {
+		return persistence.BookingSaga{}, ErrSagaNotFound
+	}
+	return saga, nil
+}
+
+//Wait waits until the saga with the given ID is no longer pending, or until the timeout ran out,
+//and returns the saga as it is then.
+func (c *Coordinator) Wait(id string, timeout time.Duration) (persistence.BookingSaga, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		saga, err := c.Find(id)
+		if err != nil || !saga.Pending() || time.Now().After(deadline) {
+			return saga, err
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+//SeatsReserved holds the booking of a saga whose seats the events service reserved. If the booking
+//cannot be held, the seats are given back.
+func (c *Coordinator) SeatsReserved(e *contracts.SeatsReservedEvent) {
+	saga, err := c.Find(e.SagaID)
+	if err != nil {
+		slog.WarnContext(c.ctx, "seats were reserved for unknown saga", "saga", e.SagaID)
+		return
+	}
+	//The saga moves on to holding before the booking is held, so that an answer that is delivered
+	//twice holds only one booking. A saga that timed out in the meantime already gave the seats back.
+	saga.Status = persistence.SagaHolding
+	err = c.Database.UpdateBookingSaga(persistence.SagaReserving, saga)
+	if err == persistence.ErrSagaStatusChanged {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.ctx, "could not update saga", "saga", saga.ID, "error", err)
+		return
+	}
+
+	userID, _ := hex.DecodeString(saga.UserID)
+	booking := persistence.Booking{
+		EventID:    saga.EventID,
+		Seats:      saga.Seats,
+		SeatIDs:    saga.SeatIDs,
+		TicketType: saga.TicketType,
+		PromoCode:  saga.PromoCode,
+		SagaID:     saga.ID,
+	}
+	hold := c.Bookings.Hold
+	if saga.Offer {
+		hold = c.Bookings.HoldOffer
+	}
+	bk, err := hold(userID, booking)
+	if err != nil {
+		saga.Status = persistence.SagaCompensated
+		saga.Reason = err.Error()
+		err = c.Database.UpdateBookingSaga(persistence.SagaHolding, saga)
+		if err == persistence.ErrSagaStatusChanged {
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.ctx, "could not update saga", "saga", saga.ID, "error", err)
+		}
+		c.release(saga)
+		c.withdrawOffer(saga)
+		return
+	}
+
+	saga.Status = persistence.SagaCompleted
+	saga.BookingID = hex.EncodeToString([]byte(bk.ID))
+	err = c.Database.UpdateBookingSaga(persistence.SagaHolding, saga)
+	if err == persistence.ErrSagaStatusChanged {
+		//The saga timed out while the booking was held, and the user was told that booking failed.
+		//Cancelling the booking gives its seats back; should the cancellation be refused, the hold
+		//simply expires.
+		if _, err := c.Bookings.Cancel(userID, []byte(bk.ID)); err != nil {
+			slog.ErrorContext(c.ctx, "could not cancel booking of timed out saga", "saga", saga.ID, "booking", saga.BookingID, "error", err)
+		}
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.ctx, "could not complete saga", "saga", saga.ID, "error", err)
+	}
+}
+
+//SeatsRejected fails a saga whose seats the events service refused to reserve.
+func (c *Coordinator) SeatsRejected(e *contracts.SeatsRejectedEvent) {
+	saga, err := c.Find(e.SagaID)
+	if err != nil {
+		slog.WarnContext(c.ctx, "seats were rejected for unknown saga", "saga", e.SagaID)
+		return
+	}
+	saga.Status = persistence.SagaRejected
+	saga.Reason = e.Reason
+	err = c.Database.UpdateBookingSaga(persistence.SagaReserving, saga)
+	if err == persistence.ErrSagaStatusChanged {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.ctx, "could not update saga", "saga", saga.ID, "error", err)
+	}
+	c.withdrawOffer(saga)
+}
+
+//TimeOut gives up every saga whose deadline passed before now, gives back the seats they may have
+//reserved, and returns the sagas it gave up.
+func (c *Coordinator) TimeOut(now time.Time) ([]persistence.BookingSaga, error) {
+	sagas, err := c.Database.FindTimedOutBookingSagas(now.Unix())
+	timedOut := []persistence.BookingSaga{}
+	for _, saga := range sagas {
+		status := saga.Status
+		saga.Status = persistence.SagaTimedOut
+		saga.Reason = "the booking could not be made in time"
+		err := c.Database.UpdateBookingSaga(status, saga)
+		if err == persistence.ErrSagaStatusChanged {
+			continue
+		}
+		if err != nil {
+			return timedOut, err
+		}
+		c.release(saga)
+		c.withdrawOffer(saga)
+		timedOut = append(timedOut, saga)
+	}
+	return timedOut, err
+}
+
+//SweepTimedOut runs TimeOut every interval. It never returns, so it should be started in its own
+//goroutine.
+func (c *Coordinator) SweepTimedOut(interval time.Duration) {
+	slog.Info("sweeping timed out booking sagas", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		timedOut, err := c.TimeOut(now)
+		if err != nil {
+			slog.Error("error while timing out booking sagas", "error", err)
+		}
+		if len(timedOut) > 0 {
+			slog.Info("timed out booking sagas", "sagas", len(timedOut))
+		}
+	}
+}
+
+//release asks the events service to give back the seats of a saga.
+func (c *Coordinator) release(saga persistence.BookingSaga) {
+	err := c.EventEmitter.Emit(&contracts.SeatsReleaseRequestedEvent{
+		SagaID:  saga.ID,
+		EventID: saga.EventID,
+		Reason:  saga.Reason,
+	})
+	if err != nil {
+		slog.ErrorContext(c.ctx, "could not release the seats of saga", "saga", saga.ID, "error", err)
+	}
+}
+
+//withdrawOffer puts the user of a failed offer back on the waitlist. Sagas of other bookings are
+//left alone.
+func (c *Coordinator) withdrawOffer(saga persistence.BookingSaga) {
+	if !saga.Offer {
+		return
+	}
+	userID, _ := hex.DecodeString(saga.UserID)
+	c.Bookings.WithdrawOffer(userID, saga.EventID, saga.ID)
+}
@@ -0,0 +1,194 @@
+package saga
+
+import (
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/bookings/lifecycle"
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/memlayer"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type recordingEmitter struct {
+	mutex  sync.Mutex
+	events []msgqueue.Event
+}
+
+func (r *recordingEmitter) Emit(e msgqueue.Event) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+//released returns the sagas whose seats were asked to be given back.
+func (r *recordingEmitter) released() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sagas := []string{}
+	for _, e := range r.events {
+		if release, ok := e.(*contracts.SeatsReleaseRequestedEvent); ok {
+			sagas = append(sagas, release.SagaID)
+		}
+	}
+	return sagas
+}
+
+var standard = persistence.TicketType{Name: "Standard", Price: 2000, Currency: "EUR"}
+
+func newTestCoordinator(t *testing.T, capacity int) (*Coordinator, *memlayer.MemoryLayer, *recordingEmitter, string) {
+	db := memlayer.NewMemoryLayer()
+	emitter := &recordingEmitter{}
+	bookings := &lifecycle.Manager{
+		Database:      db,
+		EventEmitter:  emitter,
+		HoldDuration:  10 * time.Minute,
+		OfferDuration: 30 * time.Minute,
+	}
+	c := &Coordinator{Database: db, EventEmitter: emitter, Bookings: bookings, Timeout: time.Minute}
+	bookings.Offers = c
+	id, err := db.AddEvent(persistence.Event{Capacity: capacity, StartDate: time.Now().Add(48 * time.Hour).Unix(), TicketTypes: []persistence.TicketType{standard}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, db, emitter, hex.EncodeToString(id)
+}
+
+func addUser(t *testing.T, db *memlayer.MemoryLayer) []byte {
+	id, err := db.AddUser(persistence.User{ID: string(bson.NewObjectId())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+//TestSaga takes sagas through the answers the events service may give, or not give.
+func TestSaga(t *testing.T) {
+	reserved := func(c *Coordinator, saga persistence.BookingSaga) {
+		c.SeatsReserved(&contracts.SeatsReservedEvent{SagaID: saga.ID, EventID: saga.EventID, Seats: saga.Seats})
+	}
+	rejected := func(c *Coordinator, saga persistence.BookingSaga) {
+		c.SeatsRejected(&contracts.SeatsRejectedEvent{SagaID: saga.ID, EventID: saga.EventID, Reason: "sold out"})
+	}
+	timedOut := func(c *Coordinator, saga persistence.BookingSaga) {
+		if _, err := c.TimeOut(time.Now().Add(2 * c.Timeout)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		name     string
+		seats    int
+		answers  []func(c *Coordinator, saga persistence.BookingSaga)
+		status   string
+		held     bool
+		released bool
+	}{
+		{"seats reserved", 2, []func(*Coordinator, persistence.BookingSaga){reserved}, persistence.SagaCompleted, true, false},
+		{"seats reserved twice", 2, []func(*Coordinator, persistence.BookingSaga){reserved, reserved}, persistence.SagaCompleted, true, false},
+		{"seats rejected", 2, []func(*Coordinator, persistence.BookingSaga){rejected}, persistence.SagaRejected, false, false},
+		{"booking can't be held", 5, []func(*Coordinator, persistence.BookingSaga){reserved}, persistence.SagaCompensated, false, true},
+		{"no answer in time", 2, []func(*Coordinator, persistence.BookingSaga){timedOut}, persistence.SagaTimedOut, false, true},
+		{"answer after the timeout", 2, []func(*Coordinator, persistence.BookingSaga){timedOut, reserved}, persistence.SagaTimedOut, false, true},
+	}
+	for _, c := range cases {
+		coordinator, db, emitter, eventID := newTestCoordinator(t, 4)
+		userID := addUser(t, db)
+		saga, err := coordinator.Start(userID, persistence.Booking{EventID: eventID, Seats: c.seats, TicketType: standard.Name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saga.Status != persistence.SagaReserving {
+			t.Errorf("%s: expected the saga to be reserving, got %s", c.name, saga.Status)
+		}
+		for _, answer := range c.answers {
+			answer(coordinator, saga)
+		}
+
+		saga, err = coordinator.Find(saga.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saga.Status != c.status {
+			t.Errorf("%s: expected the saga to be %s, got %+v", c.name, c.status, saga)
+		}
+		bookings, err := db.FindBookingsByUserId(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held := 0
+		for _, bk := range bookings {
+			if bk.Status == persistence.BookingHeld && bk.SagaID == saga.ID && hex.EncodeToString([]byte(bk.ID)) == saga.BookingID {
+				held++
+			}
+		}
+		if (held == 1) != c.held || held > 1 {
+			t.Errorf("%s: expected a hold to be %v, got %d holds", c.name, c.held, held)
+		}
+		released := emitter.released()
+		if c.released != (len(released) > 0) || (c.released && released[0] != saga.ID) {
+			t.Errorf("%s: expected the seats to be released %v, got %v", c.name, c.released, released)
+		}
+	}
+}
+
+//TestOfferSaga frees up the seats of a sold out event, which starts a saga for the offer to the
+//first user on the waitlist.
+func TestOfferSaga(t *testing.T) {
+	cases := []struct {
+		name   string
+		answer func(c *Coordinator, saga persistence.BookingSaga)
+		status string
+		entry  string
+	}{
+		{"seats reserved", func(c *Coordinator, saga persistence.BookingSaga) {
+			c.SeatsReserved(&contracts.SeatsReservedEvent{SagaID: saga.ID, EventID: saga.EventID, Seats: saga.Seats})
+		}, persistence.SagaCompleted, persistence.WaitlistOffered},
+		{"seats rejected", func(c *Coordinator, saga persistence.BookingSaga) {
+			c.SeatsRejected(&contracts.SeatsRejectedEvent{SagaID: saga.ID, EventID: saga.EventID, Reason: "sold out"})
+		}, persistence.SagaRejected, persistence.WaitlistWaiting},
+		{"no answer in time", func(c *Coordinator, saga persistence.BookingSaga) {
+			c.TimeOut(time.Now().Add(2 * c.Timeout))
+		}, persistence.SagaTimedOut, persistence.WaitlistWaiting},
+	}
+	for _, c := range cases {
+		coordinator, db, _, eventID := newTestCoordinator(t, 2)
+		owner, waiting := addUser(t, db), addUser(t, db)
+		sold, err := coordinator.Bookings.Hold(owner, persistence.Booking{EventID: eventID, Seats: 2, TicketType: standard.Name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := coordinator.Bookings.JoinWaitlist(waiting, eventID, 2, standard.Name); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := coordinator.Bookings.Cancel(owner, []byte(sold.ID)); err != nil {
+			t.Fatal(err)
+		}
+
+		entry, _, err := coordinator.Bookings.WaitlistPosition(waiting, eventID)
+		if err != nil || entry.Status != persistence.WaitlistOffering {
+			t.Fatalf("%s: expected the seats to be offered, got %+v and %v", c.name, entry, err)
+		}
+		saga, err := coordinator.Find(entry.SagaID)
+		if err != nil || !saga.Offer || saga.Status != persistence.SagaReserving {
+			t.Fatalf("%s: expected a saga for the offer, got %+v and %v", c.name, saga, err)
+		}
+
+		c.answer(coordinator, saga)
+		saga, _ = coordinator.Find(saga.ID)
+		if saga.Status != c.status {
+			t.Errorf("%s: expected the saga to be %s, got %+v", c.name, c.status, saga)
+		}
+		entry, position, _ := coordinator.Bookings.WaitlistPosition(waiting, eventID)
+		if entry.Status != c.entry || position != 1 {
+			t.Errorf("%s: expected the entry to be %s and first in line, got %+v at %d", c.name, c.entry, entry, position)
+		}
+		if c.entry == persistence.WaitlistOffered && entry.BookingID != saga.BookingID {
+			t.Errorf("%s: expected the hold of the saga to be offered, got %+v", c.name, entry)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/gorilla/mux"
+)
+
+type seatAvailability struct {
+	persistence.SeatInfo
+	Available bool `json:"available"`
+}
+
+type seatAvailabilityResponse struct {
+	EventID   string             `json:"eventId"`
+	Hall      string             `json:"hall"`
+	Available int                `json:"available"`
+	Seats     []seatAvailability `json:"seats"`
+}
+
+//seatAvailabilityHandler lists every seat of the hall an event takes place in, and whether the
+//seat can still be booked.
+func (bh *BookingHandler) seatAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	eventID := mux.Vars(r)["eventID"]
+	event, reserved, err := bh.bookings.ReservedSeats(eventID)
+	if err != nil {
+		httpapi.WriteError(w, r, lifecycleProblem(err))
+		return
+	}
+
+	response := seatAvailabilityResponse{
+		EventID: eventID,
+		Hall:    event.Hall,
+		Seats:   []seatAvailability{},
+	}
+	for _, seat := range event.SeatMap.Seats() {
+		available := !reserved[seat.ID]
+		if available {
+			response.Available++
+		}
+		response.Seats = append(response.Seats, seatAvailability{seat, available})
+	}
+
+	httpapi.JSON(w, http.StatusOK, &response)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/bookings/lifecycle"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/memlayer"
+	"github.com/gorilla/mux"
+)
+
+type discardEmitter struct{}
+
+func (discardEmitter) Emit(msgqueue.Event) error { return nil }
+
+func TestSeatAvailability(t *testing.T) {
+	db := memlayer.NewMemoryLayer()
+	bookings := &lifecycle.Manager{Database: db, EventEmitter: discardEmitter{}, HoldDuration: 10 * time.Minute}
+	handler := newBookingHandler(db, discardEmitter{}, bookings, nil, 0, nil)
+	seatMap := &persistence.SeatMap{Sections: []persistence.Section{{Name: "Stalls", Rows: []persistence.Row{{Name: "A", Seats: []persistence.Seat{{Number: 1}, {Number: 2, Accessible: true}}}}}}}
+	seated, err := db.AddEvent(persistence.Event{Capacity: 2, Hall: "Main", SeatMap: seatMap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unseated, err := db.AddEvent(persistence.Event{Capacity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := db.AddUser(persistence.User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bookings.Hold(userID, persistence.Booking{EventID: hex.EncodeToString(seated), SeatIDs: []string{"Stalls-A-1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(eventID string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/events/"+eventID+"/seats", nil)
+		handler.seatAvailabilityHandler(recorder, mux.SetURLVars(r, map[string]string{"eventID": eventID}))
+		return recorder
+	}
+
+	cases := []struct {
+		name    string
+		eventID string
+		code    int
+	}{
+		{"event with seat map", hex.EncodeToString(seated), 200},
+		{"event without seat map", hex.EncodeToString(unseated), 400},
+		{"unknown event", hex.EncodeToString([]byte("missing")), 404},
+	}
+	for _, c := range cases {
+		if recorder := get(c.eventID); recorder.Code != c.code {
+			t.Errorf("%s: expected %d, got %d", c.name, c.code, recorder.Code)
+		}
+	}
+
+	response := seatAvailabilityResponse{}
+	if err := json.NewDecoder(get(hex.EncodeToString(seated)).Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Hall != "Main" || response.Available != 1 || len(response.Seats) != 2 {
+		t.Fatalf("expected one of the two seats of the hall to be available, got %+v", response)
+	}
+	if response.Seats[0].ID != "Stalls-A-1" || response.Seats[0].Available || !response.Seats[1].Available || !response.Seats[1].Accessible {
+		t.Errorf("expected the held seat to be taken and the accessible seat to be free, got %+v", response.Seats)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/doublen987/web_dev/MyEvents/bookings/lifecycle"
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/gorilla/mux"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+//qrScale is the size of a module of the ticket QR codes in pixels.
+const qrScale = 8
+
+type ticketResponse struct {
+	ID     string `json:"id"`
+	Number int    `json:"number"`
+	Seat   string `json:"seat,omitempty"`
+	Token  string `json:"token"`
+	QRCode string `json:"qrCode"`
+}
+
+type checkInRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+//checkedInProblem refuses a ticket that was already used, and says when it was.
+type checkedInProblem struct {
+	httpapi.Problem
+	CheckIn persistence.TicketCheckIn `json:"checkIn"`
+}
+
+type publicKeyResponse struct {
+	Algorithm string `json:"algorithm"`
+	Key       string `json:"key"`
+}
+
+//ticketsHandler lists the tickets of a confirmed booking, with a link to the QR code of each.
+func (bh *BookingHandler) ticketsHandler(w http.ResponseWriter, r *http.Request) {
+	issued, ok := bh.issueTickets(w, r)
+	if !ok {
+		return
+	}
+
+	response := []ticketResponse{}
+	for _, t := range issued {
+		response = append(response, ticketResponse{
+			ID:     t.ID(),
+			Number: t.Number,
+			Seat:   t.Seat,
+			Token:  t.Token,
+			QRCode: fmt.Sprintf("%s/%d.png", r.URL.Path, t.Number),
+		})
+	}
+
+	httpapi.JSON(w, http.StatusOK, &response)
+}
+
+//ticketQRHandler renders the token of one ticket of a booking as a QR code.
+func (bh *BookingHandler) ticketQRHandler(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(mux.Vars(r)["number"])
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed ticket number %s", mux.Vars(r)["number"])
+		return
+	}
+	issued, ok := bh.issueTickets(w, r)
+	if !ok {
+		return
+	}
+	if number < 1 || number > len(issued) {
+		httpapi.Errorf(w, r, http.StatusNotFound, "booking has no ticket %d", number)
+		return
+	}
+
+	//A negative size makes every module of the code qrScale pixels wide.
+	png, err := qrcode.Encode(issued[number-1].Token, qrcode.Medium, -qrScale)
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+func (bh *BookingHandler) issueTickets(w http.ResponseWriter, r *http.Request) ([]lifecycle.IssuedTicket, bool) {
+	vars := mux.Vars(r)
+	userID, err := hex.DecodeString(vars["userID"])
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", vars["userID"])
+		return nil, false
+	}
+	bookingID, err := hex.DecodeString(vars["bookingID"])
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed booking id %s", vars["bookingID"])
+		return nil, false
+	}
+	issued, err := bh.bookings.IssueTickets(userID, bookingID)
+	if err != nil {
+		httpapi.WriteError(w, r, lifecycleProblem(err))
+		return nil, false
+	}
+	return issued, true
+}
+
+//checkInHandler lets the holder of a ticket in. A ticket that was already used is refused with 409,
+//and the response says when it was used.
+func (bh *BookingHandler) checkInHandler(w http.ResponseWriter, r *http.Request) {
+	request := checkInRequest{}
+	if err := httpapi.Decode(w, r, &request); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+
+	checkIn, err := bh.bookings.CheckIn(request.Token)
+	if err == persistence.ErrTicketCheckedIn {
+		problem := httpapi.NewProblem(http.StatusConflict, err.Error()).Bind(r)
+		httpapi.WriteBody(w, problem.Status, &checkedInProblem{*problem, checkIn})
+		return
+	}
+	if err != nil {
+		httpapi.WriteError(w, r, lifecycleProblem(err))
+		return
+	}
+
+	httpapi.JSON(w, http.StatusOK, &checkIn)
+}
+
+//ticketPublicKeyHandler hands out the key that verifies ticket signatures, for scanners that check
+//tickets while they are offline.
+func (bh *BookingHandler) ticketPublicKeyHandler(w http.ResponseWriter, r *http.Request) {
+	response := publicKeyResponse{
+		Algorithm: "Ed25519",
+		Key:       base64.StdEncoding.EncodeToString(bh.bookings.TicketSigner.PublicKey()),
+	}
+	httpapi.JSON(w, http.StatusOK, &response)
+}
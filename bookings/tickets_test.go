@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/bookings/lifecycle"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/memlayer"
+	"github.com/doublen987/web_dev/MyEvents/lib/tickets"
+)
+
+func TestCheckInHandler(t *testing.T) {
+	db := memlayer.NewMemoryLayer()
+	signer, _ := tickets.GenerateSigner()
+	bookings := &lifecycle.Manager{Database: db, EventEmitter: discardEmitter{}, TicketSigner: signer, HoldDuration: 10 * time.Minute}
+	handler := newBookingHandler(db, discardEmitter{}, bookings, nil, 0, nil)
+	eventID, err := db.AddEvent(persistence.Event{Capacity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := db.AddUser(persistence.User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bk, err := bookings.Hold(userID, persistence.Booking{EventID: hex.EncodeToString(eventID), Seats: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bookings.Confirm(userID, []byte(bk.ID)); err != nil {
+		t.Fatal(err)
+	}
+	issued, err := bookings.IssueTickets(userID, []byte(bk.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := tickets.GenerateSigner()
+	forged, _ := other.Sign(issued[0].Ticket)
+
+	checkIn := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.checkInHandler(recorder, httptest.NewRequest("POST", "/checkin", strings.NewReader(body)))
+		return recorder
+	}
+	token := `{"token": "` + issued[0].Token + `"}`
+
+	first := checkIn(token)
+	if first.Code != 200 {
+		t.Fatalf("expected the ticket to be checked in, got %d: %s", first.Code, first.Body)
+	}
+	checkedIn := persistence.TicketCheckIn{}
+	if err := json.NewDecoder(first.Body).Decode(&checkedIn); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"second scan", token, 409},
+		{"forged", `{"token": "` + forged + `"}`, 403},
+		{"malformed token", `{"token": "not a ticket"}`, 400},
+		{"malformed body", `{"token":`, 400},
+	}
+	for _, c := range cases {
+		recorder := checkIn(c.body)
+		if recorder.Code != c.code {
+			t.Errorf("%s: expected %d, got %d", c.name, c.code, recorder.Code)
+		}
+		if c.code != 409 {
+			continue
+		}
+		//The refusal of a second scan says when the ticket was used.
+		previous := checkedInProblem{}
+		if err := json.NewDecoder(recorder.Body).Decode(&previous); err != nil {
+			t.Fatal(err)
+		}
+		if previous.CheckIn.ID != issued[0].ID() || previous.CheckIn.CheckedInAt != checkedIn.CheckedInAt || previous.Status != 409 {
+			t.Errorf("%s: expected the first check-in, got %+v", c.name, previous)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/gorilla/mux"
+)
+
+type joinWaitlistRequest struct {
+	Seats      int    `json:"seats" validate:"required,min=1"`
+	TicketType string `json:"ticketType"`
+}
+
+type waitlistPositionResponse struct {
+	EventID      string `json:"eventId"`
+	Status       string `json:"status"`
+	Seats        int    `json:"seats"`
+	TicketType   string `json:"ticketType,omitempty"`
+	Position     int    `json:"position"`
+	BookingID    string `json:"bookingId,omitempty"`
+	OfferExpires int64  `json:"offerExpires,omitempty"`
+}
+
+func newWaitlistPositionResponse(entry persistence.WaitlistEntry, position int) waitlistPositionResponse {
+	return waitlistPositionResponse{
+		EventID:      entry.EventID,
+		Status:       entry.Status,
+		Seats:        entry.Seats,
+		TicketType:   entry.TicketType,
+		Position:     position,
+		BookingID:    entry.BookingID,
+		OfferExpires: entry.OfferExpires,
+	}
+}
+
+func (bh *BookingHandler) joinWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := hex.DecodeString(vars["userID"])
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", vars["userID"])
+		return
+	}
+
+	request := joinWaitlistRequest{}
+	if err := httpapi.Decode(w, r, &request); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+
+	entry, err := bh.bookings.JoinWaitlist(userID, vars["eventID"], request.Seats, request.TicketType)
+	if err != nil {
+		httpapi.WriteError(w, r, lifecycleProblem(err))
+		return
+	}
+	_, position, err := bh.bookings.WaitlistPosition(userID, vars["eventID"])
+	if err != nil {
+		httpapi.WriteError(w, r, lifecycleProblem(err))
+		return
+	}
+
+	httpapi.JSON(w, http.StatusCreated, newWaitlistPositionResponse(entry, position))
+}
+
+func (bh *BookingHandler) leaveWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := hex.DecodeString(vars["userID"])
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", vars["userID"])
+		return
+	}
+
+	err = bh.bookings.LeaveWaitlist(userID, vars["eventID"])
+	if err != nil {
+		httpapi.WriteError(w, r, lifecycleProblem(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (bh *BookingHandler) waitlistPositionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := hex.DecodeString(vars["userID"])
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", vars["userID"])
+		return
+	}
+
+	entry, position, err := bh.bookings.WaitlistPosition(userID, vars["eventID"])
+	if err != nil {
+		httpapi.WriteError(w, r, lifecycleProblem(err))
+		return
+	}
+
+	httpapi.JSON(w, http.StatusOK, newWaitlistPositionResponse(entry, position))
+}
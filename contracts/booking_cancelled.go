@@ -0,0 +1,21 @@
+package contracts
+
+// BookingCancelledEvent is emitted whenever a user cancels a booking
+type BookingCancelledEvent struct {
+	ID          string `json:"id" protobuf:"1"`
+	EventID     string `json:"eventId" protobuf:"2"`
+	UserID      string `json:"userId" protobuf:"3"`
+	Seats       int    `json:"seats" protobuf:"4"`
+	CancelledAt int64  `json:"cancelledAt" protobuf:"5"`
+	SagaID      string `json:"sagaId,omitempty" protobuf:"6"` //set when the seats were reserved with the events service
+}
+
+// EventName returns the event's name
+func (c *BookingCancelledEvent) EventName() string {
+	return "booking.cancelled"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *BookingCancelledEvent) SchemaVersion() int {
+	return 1
+}
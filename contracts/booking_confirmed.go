@@ -0,0 +1,20 @@
+package contracts
+
+// BookingConfirmedEvent is emitted whenever a user confirms a held booking
+type BookingConfirmedEvent struct {
+	ID          string `json:"id" protobuf:"1"`
+	EventID     string `json:"eventId" protobuf:"2"`
+	UserID      string `json:"userId" protobuf:"3"`
+	Seats       int    `json:"seats" protobuf:"4"`
+	ConfirmedAt int64  `json:"confirmedAt" protobuf:"5"`
+}
+
+// EventName returns the event's name
+func (c *BookingConfirmedEvent) EventName() string {
+	return "booking.confirmed"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *BookingConfirmedEvent) SchemaVersion() int {
+	return 1
+}
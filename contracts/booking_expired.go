@@ -0,0 +1,21 @@
+package contracts
+
+// BookingExpiredEvent is emitted whenever an unconfirmed hold is released by the sweeper
+type BookingExpiredEvent struct {
+	ID        string `json:"id" protobuf:"1"`
+	EventID   string `json:"eventId" protobuf:"2"`
+	UserID    string `json:"userId" protobuf:"3"`
+	Seats     int    `json:"seats" protobuf:"4"`
+	ExpiredAt int64  `json:"expiredAt" protobuf:"5"`
+	SagaID    string `json:"sagaId,omitempty" protobuf:"6"` //set when the seats were reserved with the events service
+}
+
+// EventName returns the event's name
+func (c *BookingExpiredEvent) EventName() string {
+	return "booking.expired"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *BookingExpiredEvent) SchemaVersion() int {
+	return 1
+}
@@ -0,0 +1,24 @@
+package contracts
+
+// BookingHeldEvent is emitted whenever seats are held for a user
+type BookingHeldEvent struct {
+	ID         string   `json:"id" protobuf:"1"`
+	EventID    string   `json:"eventId" protobuf:"2"`
+	UserID     string   `json:"userId" protobuf:"3"`
+	Seats      int      `json:"seats" protobuf:"4"`
+	SeatIDs    []string `json:"seatIds,omitempty" protobuf:"5"`
+	TicketType string   `json:"ticketType,omitempty" protobuf:"6"`
+	Total      int64    `json:"total" protobuf:"7"` //in minor units of Currency
+	Currency   string   `json:"currency,omitempty" protobuf:"8"`
+	ExpiresAt  int64    `json:"expiresAt" protobuf:"9"`
+}
+
+// EventName returns the event's name
+func (c *BookingHeldEvent) EventName() string {
+	return "booking.held"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *BookingHeldEvent) SchemaVersion() int {
+	return 1
+}
@@ -0,0 +1,39 @@
+// Package contracts holds the events the services exchange through the message queue. Events are
+// encoded with JSON or Protocol Buffers; every field is numbered with a protobuf tag, and numbers
+// must never be reused. contracts.proto describes the events for consumers in other languages and
+// is generated from this package.
+//
+// Every event has a schema version, which is sent along with it. The JSON Schemas of all versions
+// are kept in the schemas directory, and schemacheck fails on changes that break consumers. Such a
+// change bumps the version of the event and registers an upcaster from the previous version in
+// msgqueue.ContractUpcasters, so that consumers keep reading events of the older version.
+package contracts
+
+//go:generate go run ./protogen
+
+// All returns an instance of every event, for the tools that need to know them all.
+func All() []interface{} {
+	return []interface{}{
+		&BookingCancelledEvent{},
+		&BookingConfirmedEvent{},
+		&BookingExpiredEvent{},
+		&BookingHeldEvent{},
+		&EventBookedEvent{},
+		&EventCancelledEvent{},
+		&EventCreatedEvent{},
+		&EventUpdatedEvent{},
+		&LocationCreatedEvent{},
+		&PaymentFailedEvent{},
+		&PaymentRefundedEvent{},
+		&PaymentSucceededEvent{},
+		&PromoCodeCreatedEvent{},
+		&ReminderDueEvent{},
+		&SeatsRejectedEvent{},
+		&SeatsReleaseRequestedEvent{},
+		&SeatsRequestedEvent{},
+		&SeatsReservedEvent{},
+		&TicketCheckedInEvent{},
+		&UserCreatedEvent{},
+		&WaitlistOfferedEvent{},
+	}
+}
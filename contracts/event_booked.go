@@ -2,14 +2,26 @@ package contracts
 
 // EventBookedEvent is emitted whenever an event is booked
 type EventBookedEvent struct {
-	ID      string `json:"id"`
-	EventID string `json:"eventId"`
-	UserID  string `json:"userId"`
-	Seats   int    `json:"seats"`
-	Date    int64  `json:"date"`
+	ID         string   `json:"id" protobuf:"1"`
+	EventID    string   `json:"eventId" protobuf:"2"`
+	UserID     string   `json:"userId" protobuf:"3"`
+	Seats      int      `json:"seats" protobuf:"4"`
+	SeatIDs    []string `json:"seatIds,omitempty" protobuf:"5"`
+	TicketType string   `json:"ticketType,omitempty" protobuf:"6"`
+	UnitPrice  int64    `json:"unitPrice" protobuf:"7"` //in minor units of Currency
+	PromoCode  string   `json:"promoCode,omitempty" protobuf:"8"`
+	Discount   int64    `json:"discount" protobuf:"9"`
+	Total      int64    `json:"total" protobuf:"10"`
+	Currency   string   `json:"currency,omitempty" protobuf:"11"`
+	Date       int64    `json:"date" protobuf:"12"`
 }
 
 // EventName returns the event's name
 func (c *EventBookedEvent) EventName() string {
 	return "event.booked"
 }
+
+// SchemaVersion returns the version of the event's schema
+func (c *EventBookedEvent) SchemaVersion() int {
+	return 1
+}
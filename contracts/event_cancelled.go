@@ -0,0 +1,18 @@
+package contracts
+
+// EventCancelledEvent is emitted whenever an organizer cancels an event
+type EventCancelledEvent struct {
+	ID          string `json:"id" protobuf:"1"`
+	Reason      string `json:"reason,omitempty" protobuf:"2"`
+	CancelledAt int64  `json:"cancelledAt" protobuf:"3"`
+}
+
+// EventName returns the event's name
+func (c *EventCancelledEvent) EventName() string {
+	return "event.cancelled"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *EventCancelledEvent) SchemaVersion() int {
+	return 1
+}
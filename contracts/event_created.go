@@ -1,19 +1,34 @@
 package contracts
 
-import "time"
+import (
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
 
 type EventCreatedEvent struct {
-	ID         string    `json:"id"`
-	Name       string    `json:"name"`
-	LocationID string    `json:"location_id"`
-	Start      time.Time `json:"start_time"`
-	End        time.Time `json:"end_time"`
+	ID          string                   `json:"id" protobuf:"1"`
+	Name        string                   `json:"name" protobuf:"2"`
+	LocationID  string                   `json:"location_id" protobuf:"3"`
+	Venue       string                   `json:"venue,omitempty" protobuf:"4"` //name and address of the location
+	Start       time.Time                `json:"start_time" protobuf:"5"`
+	End         time.Time                `json:"end_time" protobuf:"6"`
+	TimeZone    string                   `json:"time_zone,omitempty" protobuf:"7"` //IANA time zone of the venue
+	Capacity    int                      `json:"capacity" protobuf:"8"`
+	Hall        string                   `json:"hall,omitempty" protobuf:"9"`
+	SeatMap     *persistence.SeatMap     `json:"seat_map,omitempty" protobuf:"10"`
+	TicketTypes []persistence.TicketType `json:"ticket_types,omitempty" protobuf:"11"`
+	SeriesID    string                   `json:"series_id,omitempty" protobuf:"12"` //set on the occurrences of a recurring event
 }
 
 func (e *EventCreatedEvent) EventName() string {
 	return "event.created"
 }
 
+func (e *EventCreatedEvent) SchemaVersion() int {
+	return 1
+}
+
 // func (e *EventCreatedEvent) PartitionKey() string {
 // 	return e.ID
 //   }
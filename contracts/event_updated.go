@@ -0,0 +1,23 @@
+package contracts
+
+import "time"
+
+// EventUpdatedEvent is emitted whenever an organizer changes the name or the times of an event
+type EventUpdatedEvent struct {
+	ID       string    `json:"id" protobuf:"1"`
+	Name     string    `json:"name" protobuf:"2"`
+	Start    time.Time `json:"start_time" protobuf:"3"`
+	End      time.Time `json:"end_time" protobuf:"4"`
+	SeriesID string    `json:"series_id,omitempty" protobuf:"5"`
+	Sequence int       `json:"sequence" protobuf:"6"` //grows with every change of the event
+}
+
+// EventName returns the event's name
+func (c *EventUpdatedEvent) EventName() string {
+	return "event.update"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *EventUpdatedEvent) SchemaVersion() int {
+	return 1
+}
@@ -4,14 +4,22 @@ import "github.com/doublen987/web_dev/MyEvents/lib/persistence"
 
 // LocationCreatedEvent is emitted whenever a location is created
 type LocationCreatedEvent struct {
-	ID      string             `json:"id"`
-	Name    string             `json:"name"`
-	Address string             `json:"address"`
-	Country string             `json:"country"`
-	Halls   []persistence.Hall `json:"halls"`
+	ID        string             `json:"id" protobuf:"1"`
+	Name      string             `json:"name" protobuf:"2"`
+	Address   string             `json:"address" protobuf:"3"`
+	Country   string             `json:"country" protobuf:"4"`
+	TimeZone  string             `json:"time_zone" protobuf:"5"`
+	OpenTime  int                `json:"open_time" protobuf:"6"`
+	CloseTime int                `json:"close_time" protobuf:"7"`
+	Halls     []persistence.Hall `json:"halls" protobuf:"8"`
 }
 
 // EventName returns the event's name
 func (c *LocationCreatedEvent) EventName() string {
 	return "location.created"
 }
+
+// SchemaVersion returns the version of the event's schema
+func (c *LocationCreatedEvent) SchemaVersion() int {
+	return 1
+}
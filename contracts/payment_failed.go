@@ -0,0 +1,23 @@
+package contracts
+
+// PaymentFailedEvent is emitted whenever the payment of a booking was declined
+type PaymentFailedEvent struct {
+	ID        string `json:"id" protobuf:"1"`
+	BookingID string `json:"bookingId" protobuf:"2"`
+	EventID   string `json:"eventId" protobuf:"3"`
+	UserID    string `json:"userId" protobuf:"4"`
+	Amount    int64  `json:"amount" protobuf:"5"` //in minor units of Currency
+	Currency  string `json:"currency" protobuf:"6"`
+	Reason    string `json:"reason" protobuf:"7"`
+	FailedAt  int64  `json:"failedAt" protobuf:"8"`
+}
+
+// EventName returns the event's name
+func (c *PaymentFailedEvent) EventName() string {
+	return "payment.failed"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *PaymentFailedEvent) SchemaVersion() int {
+	return 1
+}
@@ -0,0 +1,22 @@
+package contracts
+
+// PaymentRefundedEvent is emitted whenever (a part of) the payment of a booking was paid back
+type PaymentRefundedEvent struct {
+	ID         string `json:"id" protobuf:"1"`
+	BookingID  string `json:"bookingId" protobuf:"2"`
+	EventID    string `json:"eventId" protobuf:"3"`
+	UserID     string `json:"userId" protobuf:"4"`
+	Amount     int64  `json:"amount" protobuf:"5"` //the refunded amount, in minor units of Currency
+	Currency   string `json:"currency" protobuf:"6"`
+	RefundedAt int64  `json:"refundedAt" protobuf:"7"`
+}
+
+// EventName returns the event's name
+func (c *PaymentRefundedEvent) EventName() string {
+	return "payment.refunded"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *PaymentRefundedEvent) SchemaVersion() int {
+	return 1
+}
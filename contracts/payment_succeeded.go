@@ -0,0 +1,22 @@
+package contracts
+
+// PaymentSucceededEvent is emitted whenever the payment of a booking went through
+type PaymentSucceededEvent struct {
+	ID          string `json:"id" protobuf:"1"`
+	BookingID   string `json:"bookingId" protobuf:"2"`
+	EventID     string `json:"eventId" protobuf:"3"`
+	UserID      string `json:"userId" protobuf:"4"`
+	Amount      int64  `json:"amount" protobuf:"5"` //in minor units of Currency
+	Currency    string `json:"currency" protobuf:"6"`
+	SucceededAt int64  `json:"succeededAt" protobuf:"7"`
+}
+
+// EventName returns the event's name
+func (c *PaymentSucceededEvent) EventName() string {
+	return "payment.succeeded"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *PaymentSucceededEvent) SchemaVersion() int {
+	return 1
+}
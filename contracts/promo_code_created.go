@@ -0,0 +1,26 @@
+package contracts
+
+// PromoCodeCreatedEvent is emitted whenever an organizer creates a promo code for an event
+type PromoCodeCreatedEvent struct {
+	EventID        string   `json:"eventId" protobuf:"1"`
+	Code           string   `json:"code" protobuf:"2"`
+	Kind           string   `json:"kind" protobuf:"3"`
+	Percent        int      `json:"percent,omitempty" protobuf:"4"`
+	Amount         int64    `json:"amount,omitempty" protobuf:"5"`
+	Currency       string   `json:"currency,omitempty" protobuf:"6"`
+	MaxUses        int      `json:"maxUses,omitempty" protobuf:"7"`
+	MaxUsesPerUser int      `json:"maxUsesPerUser,omitempty" protobuf:"8"`
+	ValidFrom      int64    `json:"validFrom,omitempty" protobuf:"9"`
+	ValidUntil     int64    `json:"validUntil,omitempty" protobuf:"10"`
+	TicketTypes    []string `json:"ticketTypes,omitempty" protobuf:"11"`
+}
+
+// EventName returns the event's name
+func (c *PromoCodeCreatedEvent) EventName() string {
+	return "promocode.created"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *PromoCodeCreatedEvent) SchemaVersion() int {
+	return 1
+}
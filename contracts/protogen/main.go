@@ -0,0 +1,30 @@
+//protogen writes contracts.proto, the Protocol Buffers definitions of the contracts. It is run by
+//go generate in the contracts directory whenever a contract changes:
+//
+//	go generate ./contracts
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"log"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue/protobuf"
+)
+
+func main() {
+	out := flag.String("out", "contracts.proto", "path of the generated file")
+	flag.Parse()
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by protogen. DO NOT EDIT.\n\n")
+	err := protobuf.Schema(&buf, "myevents.contracts", "github.com/doublen987/web_dev/MyEvents/contracts", contracts.All()...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,23 @@
+package contracts
+
+import "time"
+
+// ReminderDueEvent is emitted whenever the attendee of an event is due to be reminded of it
+type ReminderDueEvent struct {
+	ID        string    `json:"id" protobuf:"1"`
+	EventID   string    `json:"eventId" protobuf:"2"`
+	UserID    string    `json:"userId" protobuf:"3"`
+	BookingID string    `json:"bookingId" protobuf:"4"`
+	Start     time.Time `json:"start_time" protobuf:"5"`
+	LeadHours int       `json:"lead_hours" protobuf:"6"` //how long before the start of the event the reminder is sent
+}
+
+// EventName returns the event's name
+func (c *ReminderDueEvent) EventName() string {
+	return "reminder.due"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *ReminderDueEvent) SchemaVersion() int {
+	return 1
+}
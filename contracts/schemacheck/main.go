@@ -0,0 +1,164 @@
+//schemacheck compares the JSON Schemas of the contracts with the baseline committed in the schemas
+//directory, and fails if a contract changed in a way that breaks its consumers. It is run in the
+//contracts directory:
+//
+//	go run ./schemacheck
+//
+//The baseline holds every version of every contract, as schemas/<event name>/v<version>.json. A
+//contract whose change is breaking gets a new schema version, and an upcaster from the previous one
+//is registered in msgqueue.ContractUpcasters. Once the check passes, the baseline is brought up to
+//date with
+//
+//	go run ./schemacheck -update
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue/jsonschema"
+)
+
+func main() {
+	dir := flag.String("dir", "schemas", "directory of the baseline")
+	update := flag.Bool("update", false, "write the current schemas to the baseline if they are compatible")
+	flag.Parse()
+
+	problems := []string{}
+	outdated := map[string]*jsonschema.Schema{}
+	names := map[string]bool{}
+
+	for _, contract := range contracts.All() {
+		current, err := jsonschema.Generate(contract)
+		if err != nil {
+			log.Fatal(err)
+		}
+		name := current.EventName
+		names[name] = true
+
+		base, err := latest(filepath.Join(*dir, name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if base == nil {
+			fmt.Printf("%s is new\n", name)
+			outdated[name] = current
+			continue
+		}
+
+		switch {
+		case current.Version < base.Version:
+			problems = append(problems, fmt.Sprintf("%s: schema version %d is older than version %d of the baseline", name, current.Version, base.Version))
+		case current.Version == base.Version:
+			changes := jsonschema.BreakingChanges(base, current)
+			for _, change := range changes {
+				problems = append(problems, fmt.Sprintf("%s: %s", name, change))
+			}
+			if len(changes) > 0 {
+				problems = append(problems, fmt.Sprintf("%s: bump the schema version of %s to %d and register an upcaster from version %d", name, current.Title, base.Version+1, base.Version))
+			} else if !equal(base, current) {
+				fmt.Printf("%s changed compatibly\n", name)
+				outdated[name] = current
+			}
+		default:
+			for version := base.Version; version < current.Version; version++ {
+				if !msgqueue.ContractUpcasters.Has(name, version) {
+					problems = append(problems, fmt.Sprintf("%s: no upcaster from version %d to %d is registered", name, version, version+1))
+				}
+			}
+			fmt.Printf("%s moved from version %d to %d\n", name, base.Version, current.Version)
+			outdated[name] = current
+		}
+	}
+
+	//Consumers may still listen to contracts that are no longer emitted.
+	entries, err := ioutil.ReadDir(*dir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !names[entry.Name()] {
+			problems = append(problems, fmt.Sprintf("%s: the contract was removed", entry.Name()))
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		os.Exit(1)
+	}
+	if len(outdated) == 0 {
+		fmt.Println("the baseline is up to date")
+		return
+	}
+	if !*update {
+		fmt.Println("no breaking changes; run with -update to bring the baseline up to date")
+		return
+	}
+	for name, schema := range outdated {
+		if err := write(filepath.Join(*dir, name), schema); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Printf("updated the baseline of %d contracts\n", len(outdated))
+}
+
+//latest loads the newest version in the baseline directory of a contract, or nil if there is none.
+func latest(dir string) (*jsonschema.Schema, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	versions := []int{}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, "v") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if version, err := strconv.Atoi(strings.TrimSuffix(name[1:], ".json")); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	sort.Ints(versions)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("v%d.json", versions[len(versions)-1])))
+	if err != nil {
+		return nil, err
+	}
+	schema := &jsonschema.Schema{}
+	return schema, json.Unmarshal(data, schema)
+}
+
+func write(dir string, schema *jsonschema.Schema) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("v%d.json", schema.Version)), encode(schema), 0644)
+}
+
+func encode(schema *jsonschema.Schema) []byte {
+	data, _ := json.MarshalIndent(schema, "", "  ")
+	return append(data, '\n')
+}
+
+func equal(a *jsonschema.Schema, b *jsonschema.Schema) bool {
+	return bytes.Equal(encode(a), encode(b))
+}
@@ -0,0 +1,18 @@
+package contracts
+
+// SeatsRejectedEvent is emitted whenever the events service refuses to reserve seats for a booking saga
+type SeatsRejectedEvent struct {
+	SagaID  string `json:"sagaId" protobuf:"1"`
+	EventID string `json:"eventId" protobuf:"2"`
+	Reason  string `json:"reason" protobuf:"3"`
+}
+
+// EventName returns the event's name
+func (c *SeatsRejectedEvent) EventName() string {
+	return "seats.rejected"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *SeatsRejectedEvent) SchemaVersion() int {
+	return 1
+}
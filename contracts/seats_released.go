@@ -0,0 +1,19 @@
+package contracts
+
+// SeatsReleaseRequestedEvent is emitted whenever a booking saga gives back the seats it asked for,
+// because the booking could not be made after all
+type SeatsReleaseRequestedEvent struct {
+	SagaID  string `json:"sagaId" protobuf:"1"`
+	EventID string `json:"eventId" protobuf:"2"`
+	Reason  string `json:"reason" protobuf:"3"`
+}
+
+// EventName returns the event's name
+func (c *SeatsReleaseRequestedEvent) EventName() string {
+	return "seats.release"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *SeatsReleaseRequestedEvent) SchemaVersion() int {
+	return 1
+}
@@ -0,0 +1,19 @@
+package contracts
+
+// SeatsRequestedEvent is emitted whenever a booking saga asks the events service to reserve seats
+type SeatsRequestedEvent struct {
+	SagaID  string `json:"sagaId" protobuf:"1"`
+	EventID string `json:"eventId" protobuf:"2"`
+	UserID  string `json:"userId" protobuf:"3"`
+	Seats   int    `json:"seats" protobuf:"4"`
+}
+
+// EventName returns the event's name
+func (c *SeatsRequestedEvent) EventName() string {
+	return "seats.requested"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *SeatsRequestedEvent) SchemaVersion() int {
+	return 1
+}
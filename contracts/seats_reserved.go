@@ -0,0 +1,18 @@
+package contracts
+
+// SeatsReservedEvent is emitted whenever the events service reserved the seats a booking saga asked for
+type SeatsReservedEvent struct {
+	SagaID  string `json:"sagaId" protobuf:"1"`
+	EventID string `json:"eventId" protobuf:"2"`
+	Seats   int    `json:"seats" protobuf:"3"`
+}
+
+// EventName returns the event's name
+func (c *SeatsReservedEvent) EventName() string {
+	return "seats.reserved"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *SeatsReservedEvent) SchemaVersion() int {
+	return 1
+}
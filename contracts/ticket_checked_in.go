@@ -0,0 +1,21 @@
+package contracts
+
+// TicketCheckedInEvent is emitted whenever a ticket is used to get into an event
+type TicketCheckedInEvent struct {
+	TicketID    string `json:"ticketId" protobuf:"1"`
+	BookingID   string `json:"bookingId" protobuf:"2"`
+	EventID     string `json:"eventId" protobuf:"3"`
+	UserID      string `json:"userId" protobuf:"4"`
+	Seat        string `json:"seat,omitempty" protobuf:"5"`
+	CheckedInAt int64  `json:"checkedInAt" protobuf:"6"`
+}
+
+// EventName returns the event's name
+func (c *TicketCheckedInEvent) EventName() string {
+	return "ticket.checked_in"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *TicketCheckedInEvent) SchemaVersion() int {
+	return 1
+}
@@ -1,12 +1,17 @@
 package contracts
 
 type UserCreatedEvent struct {
-	ID    string `json:"id"`
-	First string `json:"first"`
-	Last  string `json:"last"`
-	Age   int    `json:"age"`
+	ID    string `json:"id" protobuf:"1"`
+	First string `json:"first" protobuf:"2"`
+	Last  string `json:"last" protobuf:"3"`
+	Age   int    `json:"age" protobuf:"4"`
+	Email string `json:"email" protobuf:"5"`
 }
 
 func (e *UserCreatedEvent) EventName() string {
 	return "user.created"
 }
+
+func (e *UserCreatedEvent) SchemaVersion() int {
+	return 1
+}
@@ -0,0 +1,20 @@
+package contracts
+
+// WaitlistOfferedEvent is emitted whenever seats are offered to a user on the waitlist of an event
+type WaitlistOfferedEvent struct {
+	EventID   string `json:"eventId" protobuf:"1"`
+	UserID    string `json:"userId" protobuf:"2"`
+	BookingID string `json:"bookingId" protobuf:"3"`
+	Seats     int    `json:"seats" protobuf:"4"`
+	ExpiresAt int64  `json:"expiresAt" protobuf:"5"`
+}
+
+// EventName returns the event's name
+func (c *WaitlistOfferedEvent) EventName() string {
+	return "waitlist.offered"
+}
+
+// SchemaVersion returns the version of the event's schema
+func (c *WaitlistOfferedEvent) SchemaVersion() int {
+	return 1
+}
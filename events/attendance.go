@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/gorilla/mux"
+)
+
+type attendanceResponse struct {
+	EventID       string `json:"eventId"`
+	CheckedIn     int    `json:"checkedIn"`
+	Capacity      int    `json:"capacity"`
+	LastCheckInAt int64  `json:"lastCheckInAt,omitempty"`
+}
+
+//attendanceHandler shows how many people have been let into an event so far. The check-ins are
+//reported by the bookings service through the ticket.checked_in contract.
+func (eh *eventServiceHandler) attendanceHandler(w http.ResponseWriter, r *http.Request) {
+	eventID := mux.Vars(r)["eventID"]
+	event, ok := eh.findEvent(w, r)
+	if !ok {
+		return
+	}
+	checkIns, err := eh.dbhandler.FindCheckInsByEventId([]byte(eventID))
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+
+	response := attendanceResponse{
+		EventID:   eventID,
+		CheckedIn: len(checkIns),
+		Capacity:  event.Capacity,
+	}
+	if len(checkIns) > 0 {
+		response.LastCheckInAt = checkIns[len(checkIns)-1].CheckedInAt
+	}
+	httpapi.JSON(w, http.StatusOK, &response)
+}
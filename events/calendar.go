@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/ical"
+	"github.com/gorilla/mux"
+)
+
+const calendarProdID = "-//MyEvents//Events//EN"
+
+//eventCalendarHandler serves an event as an iCalendar file that can be imported into calendar apps.
+//The UID of the event stays the same and its sequence grows with every change, so importing the
+//file again updates the entry instead of adding a second one.
+func (eh *eventServiceHandler) eventCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	eventID := mux.Vars(r)["eventID"]
+	event, ok := eh.findEvent(w, r)
+	if !ok {
+		return
+	}
+
+	calendar := ical.Calendar{
+		ProdID: calendarProdID,
+		Events: []ical.Event{{
+			UID:       eventID + "@events.myevents",
+			Sequence:  event.Sequence,
+			Summary:   event.Name,
+			Location:  event.Location.Venue(),
+			Start:     event.Start(),
+			End:       event.End(),
+			Cancelled: event.CancelledAt > 0,
+		}},
+	}
+	w.Header().Set("Content-Type", "text/calendar;charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ics"`, eventID))
+	w.Write(calendar.Encode())
+}
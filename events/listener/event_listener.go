@@ -1,40 +1,66 @@
 package listener
 
 import (
+	"context"
 	"encoding/hex"
-	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/doublen987/web_dev/MyEvents/contracts"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 )
 
 type EventProcessor struct {
 	EventListener msgqueue.EventListener
+	EventEmitter  msgqueue.EventEmitter //answers the seat requests of booking sagas
 	Database      persistence.DatabaseHandler
+	Replay        bool //set while the projections are rebuilt from the event store, skips seat requests
+
+	ctx context.Context //the delivery being handled, see withContext
 }
 
 //Here we listen for newly created events
 func (p *EventProcessor) ProcessEvents() error {
-	log.Println("Listening to events...")
-	received, errors, err := p.EventListener.Listen("user.created", "user.update", "booking.created", "booking.remove")
+	slog.Info("listening to events")
+	deliveries, errors, err := p.EventListener.ListenContext("user.created", "user.update", "booking.created", "booking.remove", "ticket.checked_in",
+		"seats.requested", "seats.release", "booking.cancelled", "booking.expired")
 	if err != nil {
 		return err
 	}
 	for {
-		fmt.Println("Listening for an event...")
+		slog.Debug("waiting for an event")
 		select {
-		case evt := <-received:
-			//Received events will be passed to the handleEvent function
-			p.handleEvent(evt)
+		case delivery := <-deliveries:
+			//Received events will be passed to the HandleEvent function
+			p.process(delivery)
 		case err = <-errors:
-			//log.Printf("received error while processing msg: %s", err)
+			slog.Error("could not receive event", "error", err)
 		}
 	}
 }
 
-func (p *EventProcessor) handleEvent(event msgqueue.Event) {
+//process handles a delivered event in a consumer span, which continues the trace of the service
+//that emitted it.
+func (p *EventProcessor) process(delivery msgqueue.Delivery) {
+	ctx, span := tracing.Start(delivery.Context, "process "+delivery.Event.EventName(), tracing.Consumer)
+	defer span.Finish()
+	p.withContext(ctx).HandleEvent(delivery.Event)
+}
+
+//withContext returns a processor whose database calls and emitted events are part of the trace the
+//context carries.
+func (p *EventProcessor) withContext(ctx context.Context) *EventProcessor {
+	bound := *p
+	bound.ctx = ctx
+	bound.Database = persistence.WithContext(ctx, p.Database)
+	bound.EventEmitter = msgqueue.WithContext(ctx, p.EventEmitter)
+	return &bound
+}
+
+//HandleEvent stores a received event in the projections of the service. It is also used to
+//rebuild the projections from the event store.
+func (p *EventProcessor) HandleEvent(event msgqueue.Event) {
 	//The function uses a type switch to determine the type of the incoming event. Then we store the events
 	//in the local database. In this example, we are using a shared library github.com/doublen987/MyEvents/lib/persistence
 	//for managing database access. This is for convenience only. In real microservice architectures,
@@ -42,33 +68,84 @@ func (p *EventProcessor) handleEvent(event msgqueue.Event) {
 	//built on completely different technology stacks.
 	switch e := event.(type) {
 	case *contracts.UserCreatedEvent:
-		log.Printf("user %s created: %s", e.ID, e)
+		slog.InfoContext(p.ctx, "user created", "user", e.ID)
+		id, err := hex.DecodeString(e.ID)
+		if err != nil {
+			slog.WarnContext(p.ctx, "malformed user id", "user", e.ID, "error", err)
+			return
+		}
 		p.Database.AddUser(persistence.User{
-			ID:       e.ID,
+			ID:       string(id),
 			First:    e.First,
 			Last:     e.Last,
 			Age:      e.Age,
 			Bookings: []persistence.Booking{},
 		})
 	case *contracts.LocationCreatedEvent:
-		log.Printf("location %s created: %s", e.ID, e)
+		slog.InfoContext(p.ctx, "location created", "location", e.ID)
 		//p.Database.AddLocation(persistence.Location{ID: bson.ObjectId(e.ID)})
 	case *contracts.EventBookedEvent:
-		log.Printf("booking %s created: %s", e.ID, e)
+		slog.InfoContext(p.ctx, "booking created", "booking", e.ID, "event", e.EventID)
 		decodedUserID, err := hex.DecodeString(e.UserID)
 		if err != nil {
-			fmt.Printf("Error decoding user id: %s", err)
+			slog.WarnContext(p.ctx, "malformed user id", "user", e.UserID, "error", err)
 		}
 		_, err = p.Database.AddBookingForUser(decodedUserID, persistence.Booking{
-			ID:      e.ID,
-			Date:    e.Date,
-			EventID: e.EventID,
-			Seats:   e.Seats,
+			ID:         e.ID,
+			Date:       e.Date,
+			EventID:    e.EventID,
+			Seats:      e.Seats,
+			SeatIDs:    e.SeatIDs,
+			TicketType: e.TicketType,
+			UnitPrice:  e.UnitPrice,
+			PromoCode:  e.PromoCode,
+			Discount:   e.Discount,
+			Total:      e.Total,
+			Currency:   e.Currency,
 		})
 		if err != nil {
-			fmt.Printf("Error persisting booking: %s", err)
+			slog.ErrorContext(p.ctx, "could not store booking", "booking", e.ID, "error", err)
+		}
+	case *contracts.TicketCheckedInEvent:
+		slog.InfoContext(p.ctx, "ticket checked in", "ticket", e.TicketID, "event", e.EventID)
+		//Redelivered contracts find the check-in already stored, which is fine.
+		err := p.Database.CheckInTicket(persistence.TicketCheckIn{
+			ID:          e.TicketID,
+			EventID:     e.EventID,
+			BookingID:   e.BookingID,
+			UserID:      e.UserID,
+			Seat:        e.Seat,
+			CheckedInAt: e.CheckedInAt,
+		})
+		if err != nil && err != persistence.ErrTicketCheckedIn {
+			slog.ErrorContext(p.ctx, "could not store check-in", "ticket", e.TicketID, "error", err)
+		}
+	case *contracts.SeatsRequestedEvent:
+		//Seat requests are data of the events service rather than a projection, so a replay
+		//leaves them alone.
+		if p.Replay {
+			return
+		}
+		slog.InfoContext(p.ctx, "seats requested", "event", e.EventID, "saga", e.SagaID, "seats", e.Seats)
+		p.reserveSeats(e)
+	case *contracts.SeatsReleaseRequestedEvent:
+		if p.Replay {
+			return
+		}
+		slog.InfoContext(p.ctx, "seats released", "event", e.EventID, "saga", e.SagaID, "reason", e.Reason)
+		p.releaseSeats(e.SagaID, e.EventID, e.Reason)
+	case *contracts.BookingCancelledEvent:
+		//Only bookings that were made through a saga reserved seats with us.
+		if p.Replay || e.SagaID == "" {
+			return
+		}
+		p.releaseSeats(e.SagaID, e.EventID, "booking cancelled")
+	case *contracts.BookingExpiredEvent:
+		if p.Replay || e.SagaID == "" {
+			return
 		}
+		p.releaseSeats(e.SagaID, e.EventID, "booking expired")
 	default:
-		log.Printf("unknown event: %t", e)
+		slog.WarnContext(p.ctx, "unknown event", "event", event.EventName())
 	}
 }
@@ -0,0 +1,133 @@
+package listener
+
+import (
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//reserveSeats answers the seat request of a booking saga. The events service owns the capacity of
+//its events, so this is where seats are counted against it. The answer is stored together with the
+//request, which keeps a request that is delivered twice from reserving its seats twice.
+func (p *EventProcessor) reserveSeats(e *contracts.SeatsRequestedEvent) {
+	req, err := p.Database.FindSeatRequest(e.SagaID)
+	if err == nil {
+		p.answerSeatRequest(req)
+		return
+	}
+
+	req = persistence.SeatRequest{
+		ID:        e.SagaID,
+		EventID:   e.EventID,
+		Seats:     e.Seats,
+		Status:    persistence.SeatsReserved,
+		CreatedAt: time.Now().Unix(),
+	}
+	id, err := hex.DecodeString(e.EventID)
+	var event persistence.Event
+	if err == nil {
+		event, err = p.Database.FindEvent(id)
+	}
+	switch {
+	case err != nil:
+		req.Status, req.Reason = persistence.SeatsRejected, "event could not be loaded"
+	case event.CancelledAt > 0:
+		req.Status, req.Reason = persistence.SeatsRejected, "event has been cancelled"
+	case e.Seats <= 0:
+		req.Status, req.Reason = persistence.SeatsRejected, "seat number must be positive"
+	default:
+		err = p.Database.ReserveCapacity(id, e.Seats, event.Capacity)
+		if err == persistence.ErrCapacityExceeded {
+			req.Status, req.Reason = persistence.SeatsRejected, err.Error()
+		} else if err != nil {
+			//Without an answer the saga times out and the booking fails, which is all we can do.
+			slog.ErrorContext(p.ctx, "could not reserve seats", "saga", e.SagaID, "error", err)
+			return
+		}
+	}
+
+	err = p.Database.AddSeatRequest(req)
+	if err != nil && req.Status == persistence.SeatsReserved {
+		p.Database.ReleaseCapacity(id, req.Seats)
+	}
+	if err == persistence.ErrSeatRequestExists {
+		//The saga gave up and released its seats before its request got here, or the request was
+		//delivered twice at the same time. Either way the stored request has the answer.
+		req, err = p.Database.FindSeatRequest(e.SagaID)
+	}
+	if err != nil {
+		slog.ErrorContext(p.ctx, "could not store the seat request", "saga", e.SagaID, "error", err)
+		return
+	}
+	p.answerSeatRequest(req)
+}
+
+func (p *EventProcessor) answerSeatRequest(req persistence.SeatRequest) {
+	var err error
+	switch req.Status {
+	case persistence.SeatsReserved:
+		err = p.EventEmitter.Emit(&contracts.SeatsReservedEvent{
+			SagaID:  req.ID,
+			EventID: req.EventID,
+			Seats:   req.Seats,
+		})
+	case persistence.SeatsReleased:
+		err = p.EventEmitter.Emit(&contracts.SeatsRejectedEvent{
+			SagaID:  req.ID,
+			EventID: req.EventID,
+			Reason:  "seats have already been released",
+		})
+	default:
+		err = p.EventEmitter.Emit(&contracts.SeatsRejectedEvent{
+			SagaID:  req.ID,
+			EventID: req.EventID,
+			Reason:  req.Reason,
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(p.ctx, "could not answer the seat request", "saga", req.ID, "error", err)
+	}
+}
+
+//releaseSeats gives back the seats a saga reserved. Seats are released once, no matter how often
+//the saga or its booking asks for it.
+func (p *EventProcessor) releaseSeats(sagaID string, eventID string, reason string) {
+	req, err := p.Database.FindSeatRequest(sagaID)
+	if err != nil {
+		//The release overtook the request. We store the request as released already, so that it
+		//is turned down when it arrives.
+		err = p.Database.AddSeatRequest(persistence.SeatRequest{
+			ID:        sagaID,
+			EventID:   eventID,
+			Status:    persistence.SeatsReleased,
+			Reason:    reason,
+			CreatedAt: time.Now().Unix(),
+		})
+		if err == persistence.ErrSeatRequestExists {
+			p.releaseSeats(sagaID, eventID, reason)
+		} else if err != nil {
+			slog.ErrorContext(p.ctx, "could not release the seats", "saga", sagaID, "event", eventID, "error", err)
+		}
+		return
+	}
+	if req.Status != persistence.SeatsReserved {
+		return
+	}
+
+	req.Status = persistence.SeatsReleased
+	req.Reason = reason
+	err = p.Database.UpdateSeatRequest(persistence.SeatsReserved, req)
+	if err == persistence.ErrSeatRequestChanged {
+		return
+	}
+	if err == nil {
+		id, _ := hex.DecodeString(req.EventID)
+		err = p.Database.ReleaseCapacity(id, req.Seats)
+	}
+	if err != nil {
+		slog.ErrorContext(p.ctx, "could not release the seats", "saga", sagaID, "event", eventID, "error", err)
+	}
+}
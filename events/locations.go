@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/gorilla/mux"
+)
+
+//freeBusyDefaultSpan is the time range of a free/busy calendar that is requested without a range.
+const freeBusyDefaultSpan = 7 * 24 * time.Hour
+
+//Halls stay locked for hallLockDuration at most, should the request that locked them never unlock
+//them. A hall that is locked is tried hallLockAttempts times, hallLockWait apart.
+const (
+	hallLockDuration = 30 * time.Second
+	hallLockAttempts = 5
+	hallLockWait     = 100 * time.Millisecond
+)
+
+type locationResponse struct {
+	ID       string               `json:"id"`
+	Location persistence.Location `json:"location"`
+}
+
+//conflictProblem is the problem of an event whose hall is taken. It names the event in the way.
+type conflictProblem struct {
+	httpapi.Problem
+	Conflict eventResponse `json:"conflict"`
+}
+
+type freeBusyResponse struct {
+	LocationID string         `json:"locationId"`
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	FreeHalls  []string       `json:"freeHalls"` //halls that are free for the whole range
+	Halls      []hallSchedule `json:"halls"`
+}
+
+type hallSchedule struct {
+	Name string     `json:"name"`
+	Busy []busySlot `json:"busy"`
+}
+
+type busySlot struct {
+	EventID string `json:"eventId"`
+	Name    string `json:"name"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+}
+
+//locationRequest describes a location, either on its own or as the venue of a new event.
+type locationRequest struct {
+	Name      string
+	Address   string
+	Country   string
+	TimeZone  string `validate:"format=timezone"` //IANA time zone of the location, e.g. Europe/Berlin
+	OpenTime  int    `validate:"min=0,max=24"`    //hour of the day the location opens, in its time zone
+	CloseTime int    `validate:"min=0,max=24"`    //hour of the day the location closes; at or before OpenTime means after midnight
+	Halls     []hallRequest
+}
+
+type hallRequest struct {
+	Name     string               `json:"name" validate:"required"`
+	Location string               `json:"location,omitempty"`
+	Capacity int                  `json:"capacity" validate:"min=0"`
+	SeatMap  *persistence.SeatMap `json:"seatMap,omitempty"`
+}
+
+func (req locationRequest) location() persistence.Location {
+	location := persistence.Location{
+		Name:      req.Name,
+		Address:   req.Address,
+		Country:   req.Country,
+		TimeZone:  req.TimeZone,
+		OpenTime:  req.OpenTime,
+		CloseTime: req.CloseTime,
+	}
+	for _, hall := range req.Halls {
+		location.Halls = append(location.Halls, persistence.Hall{
+			Name:     hall.Name,
+			Location: hall.Location,
+			Capacity: hall.Capacity,
+			SeatMap:  hall.SeatMap,
+		})
+	}
+	return location
+}
+
+func (eh *eventServiceHandler) newLocationHandler(w http.ResponseWriter, r *http.Request) {
+	request := locationRequest{}
+	if err := httpapi.Decode(w, r, &request); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+	location := request.location()
+	if err := validateLocation(location); err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid location: %s", err)
+		return
+	}
+
+	id, err := eh.dbhandler.AddLocation(location)
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	location.ID = string(id)
+	eh.eventEmitter.Emit(&contracts.LocationCreatedEvent{
+		ID:        hex.EncodeToString(id),
+		Name:      location.Name,
+		Address:   location.Address,
+		Country:   location.Country,
+		TimeZone:  location.TimeZone,
+		OpenTime:  location.OpenTime,
+		CloseTime: location.CloseTime,
+		Halls:     location.Halls,
+	})
+
+	httpapi.JSON(w, http.StatusCreated, &locationResponse{hex.EncodeToString(id), location})
+}
+
+//validateLocation makes sure the location has a name and halls with unique names. Its time zone and
+//opening hours are checked by the validate tags of locationRequest. The name is checked here rather
+//than with a tag, since events describe their location with a locationRequest as well, and can leave
+//the name out.
+func validateLocation(location persistence.Location) error {
+	if location.Name == "" {
+		return errors.New("locations need a name")
+	}
+	names := map[string]bool{}
+	for _, hall := range location.Halls {
+		if names[hall.Name] {
+			return fmt.Errorf("hall %s is defined twice", hall.Name)
+		}
+		names[hall.Name] = true
+	}
+	return nil
+}
+
+func (eh *eventServiceHandler) findLocationHandler(w http.ResponseWriter, r *http.Request) {
+	location, ok := eh.findLocation(w, r, mux.Vars(r)["locationID"])
+	if !ok {
+		return
+	}
+	httpapi.JSON(w, http.StatusOK, &locationResponse{mux.Vars(r)["locationID"], location})
+}
+
+//freeBusyHandler shows which halls of a location are taken by which events in a time range, so
+//organizers can pick a free slot. The range is given in unix seconds with the from and to query
+//parameters, and defaults to the coming week.
+func (eh *eventServiceHandler) freeBusyHandler(w http.ResponseWriter, r *http.Request) {
+	locationID := mux.Vars(r)["locationID"]
+	location, ok := eh.findLocation(w, r, locationID)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	from, ok := unixParam(w, r, "from", now.Unix())
+	if !ok {
+		return
+	}
+	to, ok := unixParam(w, r, "to", now.Add(freeBusyDefaultSpan).Unix())
+	if !ok {
+		return
+	}
+	if to <= from {
+		httpapi.Error(w, r, http.StatusBadRequest, "the time range ends before it starts")
+		return
+	}
+
+	events, err := eh.dbhandler.FindEventsAtLocation([]byte(location.ID), from, to)
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+
+	zone, _ := location.Zone()
+	response := freeBusyResponse{
+		LocationID: locationID,
+		From:       time.Unix(from, 0).In(zone).Format(time.RFC3339),
+		To:         time.Unix(to, 0).In(zone).Format(time.RFC3339),
+		FreeHalls:  []string{},
+		Halls:      []hallSchedule{},
+	}
+	for _, hall := range location.FreeHalls(events) {
+		response.FreeHalls = append(response.FreeHalls, hall.Name)
+	}
+	for _, hall := range location.Halls {
+		schedule := hallSchedule{Name: hall.Name, Busy: []busySlot{}}
+		for _, event := range events {
+			if event.Hall != hall.Name {
+				continue
+			}
+			event.Location = location
+			local := localEvent(event)
+			schedule.Busy = append(schedule.Busy, busySlot{
+				EventID: hex.EncodeToString([]byte(event.ID)),
+				Name:    event.Name,
+				Start:   local.LocalStart,
+				End:     local.LocalEnd,
+			})
+		}
+		response.Halls = append(response.Halls, schedule)
+	}
+
+	httpapi.JSON(w, http.StatusOK, &response)
+}
+
+//unixParam parses a query parameter that holds a unix time, and returns the default if the request
+//leaves it out. It writes the response for parameters that are not a unix time.
+func unixParam(w http.ResponseWriter, r *http.Request, name string, def int64) (int64, bool) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return def, true
+	}
+	value, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "%s has to be a unix time", name)
+		return 0, false
+	}
+	return value, true
+}
+
+func (eh *eventServiceHandler) findLocation(w http.ResponseWriter, r *http.Request, locationID string) (persistence.Location, bool) {
+	id, err := hex.DecodeString(locationID)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed location id %s", locationID)
+		return persistence.Location{}, false
+	}
+	location, err := eh.dbhandler.FindLocation(id)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusNotFound, "location %s could not be loaded", locationID)
+		return persistence.Location{}, false
+	}
+	return location, true
+}
+
+//findHallConflict looks for an event that takes place in the hall of one of the given events at an
+//overlapping time, and returns both. Events of locations that were not stored through the
+//locations endpoint can't be told apart from those of other locations, so they are not checked.
+//The check is only safe from organizers that book the same slot at the same moment while the halls
+//are locked, see lockHalls.
+func (eh *eventServiceHandler) findHallConflict(events []persistence.Event) (persistence.Event, persistence.Event, bool, error) {
+	if len(events) == 0 || events[0].Location.ID == "" {
+		return persistence.Event{}, persistence.Event{}, false, nil
+	}
+	from, to := events[0].StartDate, events[0].EndDate
+	for _, event := range events {
+		if event.StartDate < from {
+			from = event.StartDate
+		}
+		if event.EndDate > to {
+			to = event.EndDate
+		}
+	}
+	stored, err := eh.dbhandler.FindEventsAtLocation([]byte(events[0].Location.ID), from, to)
+	if err != nil {
+		return persistence.Event{}, persistence.Event{}, false, err
+	}
+	//Stored events that are being moved give up their old slot, so they can't be in the way.
+	moving := map[string]bool{}
+	for _, event := range events {
+		moving[event.ID] = event.ID != ""
+	}
+	existing := []persistence.Event{}
+	for _, event := range stored {
+		if !moving[event.ID] {
+			existing = append(existing, event)
+		}
+	}
+	for _, event := range events {
+		if conflict, ok := persistence.FindHallConflict(event, existing); ok {
+			return event, conflict, true, nil
+		}
+	}
+	return persistence.Event{}, persistence.Event{}, false, nil
+}
+
+//lockHalls locks the halls of the events, and checks that the halls are free at the times of the
+//events. It writes the response for events that can't take place, and otherwise returns a function
+//that unlocks the halls, which has to be called once the events are stored.
+func (eh *eventServiceHandler) lockHalls(w http.ResponseWriter, r *http.Request, events ...persistence.Event) (func(), bool) {
+	halls := map[string]bool{}
+	for _, event := range events {
+		if event.Location.ID != "" && event.Hall != "" {
+			halls[persistence.HallKey([]byte(event.Location.ID), event.Hall)] = true
+		}
+	}
+	keys := []string{}
+	for key := range halls {
+		keys = append(keys, key)
+	}
+	//Halls are always locked in the same order, so two requests can't each wait for the other.
+	sort.Strings(keys)
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		httpapi.InternalError(w, r, err)
+		return nil, false
+	}
+	token := hex.EncodeToString(random)
+	locked := []string{}
+	unlock := func() {
+		for _, key := range locked {
+			if err := eh.dbhandler.UnlockHall(key, token); err != nil {
+				slog.WarnContext(r.Context(), "could not unlock hall", "hall", key, "error", err)
+			}
+		}
+	}
+	for _, key := range keys {
+		if err := eh.lockHall(key, token); err != nil {
+			unlock()
+			if err == persistence.ErrHallLocked {
+				httpapi.Error(w, r, http.StatusConflict, "the schedule of the hall is being changed by another request, try again")
+			} else {
+				httpapi.InternalError(w, r, err)
+			}
+			return nil, false
+		}
+		locked = append(locked, key)
+	}
+	if !eh.checkHallConflict(w, r, events...) {
+		unlock()
+		return nil, false
+	}
+	return unlock, true
+}
+
+//lockHall takes the lock of a hall, and waits a little for requests that hold it.
+func (eh *eventServiceHandler) lockHall(key string, token string) error {
+	for attempt := 1; ; attempt++ {
+		now := time.Now()
+		err := eh.dbhandler.LockHall(key, token, now.Unix(), now.Add(hallLockDuration).Unix())
+		if err != persistence.ErrHallLocked || attempt == hallLockAttempts {
+			return err
+		}
+		time.Sleep(hallLockWait)
+	}
+}
+
+//checkHallConflict writes the response for events that can't take place because their hall is
+//taken, and reports whether the events are free to go ahead.
+func (eh *eventServiceHandler) checkHallConflict(w http.ResponseWriter, r *http.Request, events ...persistence.Event) bool {
+	event, conflict, found, err := eh.findHallConflict(events)
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return false
+	}
+	if !found {
+		return true
+	}
+	conflict.Location = event.Location
+	local := localEvent(event)
+	problem := httpapi.Problemf(http.StatusConflict, "hall %s is taken by %s from %s to %s, which overlaps %s to %s",
+		event.Hall, conflict.Name, conflict.Start().Format(time.RFC3339), conflict.End().Format(time.RFC3339),
+		local.LocalStart, local.LocalEnd).Bind(r)
+	httpapi.WriteBody(w, problem.Status, &conflictProblem{
+		Problem:  *problem,
+		Conflict: localEvent(conflict),
+	})
+	return false
+}
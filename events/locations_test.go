@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/health"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/memlayer"
+)
+
+type discardEmitter struct{}
+
+func (discardEmitter) Emit(msgqueue.Event) error {
+	return nil
+}
+
+//TestMovedEventsLeaveTheirSlot moves events of a series in a hall. Events that move give up their
+//old slot, so they are only in the way of each other where they end up, not where they were.
+func TestMovedEventsLeaveTheirSlot(t *testing.T) {
+	start := time.Now().Add(7 * 24 * time.Hour).Truncate(time.Hour).Unix()
+	day := int64(24 * 60 * 60)
+	cases := []struct {
+		name   string
+		path   func(series string, first string) string
+		start  int64
+		status int
+	}{
+		{"event moved within its own slot", func(series string, first string) string { return "/events/" + first }, start + 3600, http.StatusOK},
+		{"event moved onto the next occurrence", func(series string, first string) string { return "/events/" + first }, start + day, http.StatusConflict},
+		{"series moved onto its own occurrences", func(series string, first string) string { return "/events/series/" + series }, start + day, http.StatusOK},
+		{"series moved onto another event", func(series string, first string) string { return "/events/series/" + series }, start + 2*day, http.StatusConflict},
+	}
+	for _, c := range cases {
+		db := memlayer.NewMemoryLayer()
+		locationID, err := db.AddLocation(persistence.Location{Name: "Opera", TimeZone: "UTC", Halls: []persistence.Hall{{Name: "A"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		location := persistence.Location{ID: string(locationID), Name: "Opera", TimeZone: "UTC"}
+		seriesID, err := db.AddEventSeries(persistence.EventSeries{Name: "Tosca", Rule: "FREQ=DAILY;COUNT=2", TimeZone: "UTC", StartDate: start, EndDate: start + 7200})
+		if err != nil {
+			t.Fatal(err)
+		}
+		//Two occurrences a day apart, and another event in the same hall a day after them.
+		events := []persistence.Event{
+			{Name: "Tosca", StartDate: start, EndDate: start + 7200, SeriesID: hex.EncodeToString(seriesID)},
+			{Name: "Tosca", StartDate: start + day, EndDate: start + day + 7200, SeriesID: hex.EncodeToString(seriesID)},
+			{Name: "Aida", StartDate: start + 3*day, EndDate: start + 3*day + 7200},
+		}
+		ids := []string{}
+		for _, e := range events {
+			e.Location, e.Hall = location, "A"
+			id, err := db.AddEvent(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, hex.EncodeToString(id))
+		}
+
+		r := newRouter(newEventHandler(db, discardEmitter{}), health.NewChecker())
+		recorder := httptest.NewRecorder()
+		body := strings.NewReader(fmt.Sprintf(`{"StartDate": %d, "EndDate": %d}`, c.start, c.start+7200))
+		r.ServeHTTP(recorder, httptest.NewRequest("PUT", c.path(hex.EncodeToString(seriesID), ids[0]), body))
+		if recorder.Code != c.status {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.status, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+//TestNewLocationIsValidated posts locations, which are checked against the rules of the request
+//before they are stored.
+func TestNewLocationIsValidated(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"valid location", `{"Name": "Opera", "TimeZone": "Europe/Berlin", "Halls": [{"name": "A", "capacity": 100}]}`, http.StatusCreated},
+		{"unknown time zone", `{"Name": "Opera", "TimeZone": "Europe/Atlantis"}`, http.StatusUnprocessableEntity},
+		{"hall without a name", `{"Name": "Opera", "Halls": [{"capacity": 100}]}`, http.StatusUnprocessableEntity},
+		{"location with an ID", `{"ID": "5d1f", "Name": "Opera"}`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		r := newRouter(newEventHandler(memlayer.NewMemoryLayer(), discardEmitter{}), health.NewChecker())
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest("POST", "/locations", strings.NewReader(c.body)))
+		if recorder.Code != c.status {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.status, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+//TestOverlappingEventIsRefused books a hall that already has an event, at times that do and don't
+//overlap it, and while another request holds the lock of the hall.
+func TestOverlappingEventIsRefused(t *testing.T) {
+	start := time.Now().Add(7 * 24 * time.Hour).Truncate(time.Hour).Unix()
+	now := time.Now().Unix()
+	cases := []struct {
+		name   string
+		start  int64
+		lock   func(db *memlayer.MemoryLayer, hall string)
+		status int
+	}{
+		{"free slot", start + 7200, nil, http.StatusCreated},
+		{"overlapping event", start + 3600, nil, http.StatusConflict},
+		{"hall locked by another request", start + 7200, func(db *memlayer.MemoryLayer, hall string) {
+			db.LockHall(hall, "other", now, now+60)
+		}, http.StatusConflict},
+		{"lock of another request expired", start + 7200, func(db *memlayer.MemoryLayer, hall string) {
+			db.LockHall(hall, "other", now-120, now-60)
+		}, http.StatusCreated},
+	}
+	for _, c := range cases {
+		db := memlayer.NewMemoryLayer()
+		locationID, err := db.AddLocation(persistence.Location{Name: "Opera", TimeZone: "UTC", Halls: []persistence.Hall{{Name: "A"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		location := persistence.Location{ID: string(locationID), Name: "Opera", TimeZone: "UTC"}
+		if _, err := db.AddEvent(persistence.Event{Name: "Tosca", StartDate: start, EndDate: start + 7200, Location: location, Hall: "A"}); err != nil {
+			t.Fatal(err)
+		}
+		hall := persistence.HallKey(locationID, "A")
+		if c.lock != nil {
+			c.lock(db, hall)
+		}
+
+		r := newRouter(newEventHandler(db, discardEmitter{}), health.NewChecker())
+		recorder := httptest.NewRecorder()
+		body := strings.NewReader(fmt.Sprintf(`{"Name": "Aida", "StartDate": %d, "EndDate": %d, "Hall": "A", "locationId": "%s"}`, c.start, c.start+7200, hex.EncodeToString(locationID)))
+		r.ServeHTTP(recorder, httptest.NewRequest("POST", "/events", body))
+		if recorder.Code != c.status {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.status, recorder.Code, recorder.Body)
+		}
+		if c.lock == nil && db.LockHall(hall, "next", now, now+60) != nil {
+			t.Errorf("%s: expected the hall to be unlocked after the request", c.name)
+		}
+	}
+}
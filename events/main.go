@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -13,10 +13,17 @@ import (
 	"github.com/doublen987/web_dev/MyEvents/contracts"
 	"github.com/doublen987/web_dev/MyEvents/events/listener"
 	"github.com/doublen987/web_dev/MyEvents/lib/configuration"
+	"github.com/doublen987/web_dev/MyEvents/lib/eventstore"
+	"github.com/doublen987/web_dev/MyEvents/lib/health"
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
 	msgqueue_amqp "github.com/doublen987/web_dev/MyEvents/lib/msgqueue/amqp"
+	"github.com/doublen987/web_dev/MyEvents/lib/openapi"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence/dblayer"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 )
@@ -33,94 +40,342 @@ func newEventHandler(databaseHandler persistence.DatabaseHandler, eventEmitter m
 	}
 }
 
+//withContext returns a handler whose database calls and emitted events are part of the trace
+//the context carries.
+func (eh *eventServiceHandler) withContext(ctx context.Context) *eventServiceHandler {
+	bound := *eh
+	bound.dbhandler = persistence.WithContext(ctx, eh.dbhandler)
+	bound.eventEmitter = msgqueue.WithContext(ctx, eh.eventEmitter)
+	return &bound
+}
+
 //The method takes two arguments: a ResponseWriter, which represents the HTTP response to fill,
 //and a Request, which represents the HTTP request that we recieved.
 func (eh *eventServiceHandler) findEventHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	criteria, ok := vars["SearchCriteria"]
-	if !ok {
-		w.WriteHeader(400)
-		fmt.Fprint(w, `{error: No search criteria found, you can either search by id via /id/4 to 
-			search by name via /name/coldplayconcert}`)
-		return
-	}
-	searchkey, ok := vars["search"]
-	if !ok {
-		w.WriteHeader(400)
-		fmt.Fprint(w, `{error: No search keys found, you can either search by id via /id/4 to
-			search by name via /name/coldplayconcert}`)
-		return
-	}
+	criteria := vars["SearchCriteria"]
+	searchkey := vars["search"]
 	var event persistence.Event
 	var err error
 	switch strings.ToLower(criteria) {
 	case "name":
 		event, err = eh.dbhandler.FindEventByName(searchkey)
 	case "id":
-		id, err := hex.DecodeString(searchkey)
-		if err == nil {
-			event, err = eh.dbhandler.FindEvent(id)
-			fmt.Println(event)
-		} else {
-			fmt.Println(err)
+		id, decodeErr := hex.DecodeString(searchkey)
+		if decodeErr != nil {
+			httpapi.Errorf(w, r, http.StatusBadRequest, "malformed event id %s", searchkey)
+			return
 		}
+		event, err = eh.dbhandler.FindEvent(id)
+	default:
+		httpapi.Errorf(w, r, http.StatusBadRequest, "unknown search criteria %s, you can either search by id via /id/4 or by name via /name/coldplayconcert", criteria)
+		return
 	}
 	if err != nil {
-		fmt.Fprintf(w, "{error %s}", err)
+		httpapi.Errorf(w, r, http.StatusNotFound, "no event with %s %s", strings.ToLower(criteria), searchkey)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json;charset=utf8")
-	json.NewEncoder(w).Encode(&event)
+	httpapi.JSON(w, http.StatusOK, localEvent(event))
 }
 
 func (eh *eventServiceHandler) allEventHandler(w http.ResponseWriter, r *http.Request) {
 	events, err := eh.dbhandler.FindAllAvailableEvents()
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"error": "Error occured while trying to find all available events %s"}`, err)
+		httpapi.InternalError(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json;charset=utf8")
-	err = json.NewEncoder(w).Encode(&events)
-	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"error": "Error occured while trying encode events to JSON %s"}`, err)
+	httpapi.JSON(w, http.StatusOK, localEvents(events))
+}
+
+//eventRequest holds the fields of an event organizers describe when they create it. The rest of an
+//event is up to the service.
+type eventRequest struct {
+	Name        string `validate:"required"`
+	Duration    int
+	StartDate   int64
+	EndDate     int64
+	Capacity    int                  `validate:"min=0"` //0 means the event has no seat limit
+	Hall        string               //name of the hall of the location the event takes place in
+	SeatMap     *persistence.SeatMap //replaced by the seat map of the hall, if it has one
+	TicketTypes []ticketTypeRequest  //an event without ticket types is free
+	Policy      *policyRequest       //without a policy, bookings are refunded in full until the cancel cutoff
+	Location    locationRequest
+}
+
+func (req eventRequest) event() persistence.Event {
+	return persistence.Event{
+		Name:        req.Name,
+		Duration:    req.Duration,
+		StartDate:   req.StartDate,
+		EndDate:     req.EndDate,
+		Capacity:    req.Capacity,
+		Hall:        req.Hall,
+		SeatMap:     req.SeatMap,
+		TicketTypes: ticketTypes(req.TicketTypes),
+		Policy:      req.Policy.policy(),
+		Location:    req.Location.location(),
+	}
+}
+
+type ticketTypeRequest struct {
+	Name       string `json:"name" validate:"required"`
+	Price      int64  `json:"price" validate:"min=0"`                       //in minor units of Currency
+	Currency   string `json:"currency" validate:"required,format=currency"` //ISO 4217 code, for example EUR
+	Quota      int    `json:"quota,omitempty" validate:"min=0"`             //0 means the type is only limited by the capacity of the event
+	SalesStart int64  `json:"salesStart,omitempty"`                         //unix time; 0 means the type is on sale right away
+	SalesEnd   int64  `json:"salesEnd,omitempty"`                           //unix time; 0 means the type stays on sale
+}
+
+func ticketTypes(requests []ticketTypeRequest) []persistence.TicketType {
+	if requests == nil {
+		return nil
+	}
+	types := []persistence.TicketType{}
+	for _, req := range requests {
+		types = append(types, persistence.TicketType{
+			Name:       req.Name,
+			Price:      req.Price,
+			Currency:   req.Currency,
+			Quota:      req.Quota,
+			SalesStart: req.SalesStart,
+			SalesEnd:   req.SalesEnd,
+		})
+	}
+	return types
+}
+
+type policyRequest struct {
+	Tiers []refundTierRequest `json:"tiers"`
+}
+
+type refundTierRequest struct {
+	HoursBefore int `json:"hoursBefore" validate:"min=0"`
+	Percent     int `json:"percent" validate:"min=0,max=100"`
+}
+
+func (req *policyRequest) policy() *persistence.CancellationPolicy {
+	if req == nil {
+		return nil
+	}
+	policy := &persistence.CancellationPolicy{Tiers: []persistence.RefundTier{}}
+	for _, tier := range req.Tiers {
+		policy.Tiers = append(policy.Tiers, persistence.RefundTier{HoursBefore: tier.HoursBefore, Percent: tier.Percent})
 	}
+	return policy
 }
 
 func (eh *eventServiceHandler) newEventHandler(w http.ResponseWriter, r *http.Request) {
-	event := persistence.Event{}
-	err := json.NewDecoder(r.Body).Decode(&event)
-	if nil != err {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"error": "error occured while decoding event data %s"}`, err)
+	request := newEventRequest{}
+	if err := httpapi.Decode(w, r, &request); err != nil {
+		httpapi.WriteError(w, r, err)
 		return
 	}
-	id, err := eh.dbhandler.AddEvent(event)
+	event := request.event()
+
+	//Events can refer to a location that was stored through /locations instead of describing it.
+	if request.LocationID != "" {
+		location, ok := eh.findLocation(w, r, request.LocationID)
+		if !ok {
+			return
+		}
+		event.Location = location
+	}
+
+	//If the event takes place in a hall that has a seat map, we copy the seat map onto the event.
+	//That way the bookings service can assign seats without knowing about locations, and the
+	//capacity of the event is simply the number of seats in the hall.
+	if event.Hall != "" {
+		hall, ok := findHall(event.Location, event.Hall)
+		if !ok {
+			httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "location has no hall named %s", event.Hall)
+			return
+		}
+		if hall.SeatMap != nil {
+			event.SeatMap = hall.SeatMap
+			event.Capacity = hall.SeatMap.Capacity()
+		}
+	}
+
+	if err := validateTicketTypes(event.TicketTypes); err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid ticket types: %s", err)
+		return
+	}
+
+	if err := validatePolicy(event.Policy); err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid cancellation policy: %s", err)
+		return
+	}
+
+	//Event times are stored as instants. Organizers can give them as local times of the venue
+	//instead, which we convert with the time zone of the location.
+	if err := request.localTimes.resolve(&event); err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid event times: %s", err)
+		return
+	}
+
+	//A recurring event is expanded into one event per occurrence.
+	if request.Recurrence != nil {
+		eh.newSeries(w, r, event, *request.Recurrence)
+		return
+	}
+
+	if err := validateTimes(event); err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid event times: %s", err)
+		return
+	}
+
+	unlock, ok := eh.lockHalls(w, r, event)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	id, err := eh.addEvent(event)
 	if nil != err {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"error": "error occured while persisting event %d %s"}`, id, err)
+		httpapi.InternalError(w, r, err)
 		return
 	}
+	event.ID = string(id)
+
+	httpapi.JSON(w, http.StatusCreated, localEvent(event))
+}
+
+//addEvent stores a new event and announces it to the other services.
+func (eh *eventServiceHandler) addEvent(event persistence.Event) ([]byte, error) {
+	id, err := eh.dbhandler.AddEvent(event)
+	if err != nil {
+		return id, err
+	}
 	msg := contracts.EventCreatedEvent{
-		ID:         hex.EncodeToString(id),
-		Name:       event.Name,
-		LocationID: string(event.Location.ID),
-		Start:      time.Unix(event.StartDate, 0),
-		End:        time.Unix(event.EndDate, 0),
+		ID:          hex.EncodeToString(id),
+		Name:        event.Name,
+		LocationID:  string(event.Location.ID),
+		Start:       event.Start(),
+		End:         event.End(),
+		TimeZone:    event.Location.TimeZone,
+		Venue:       event.Location.Venue(),
+		Capacity:    event.Capacity,
+		Hall:        event.Hall,
+		SeatMap:     event.SeatMap,
+		TicketTypes: event.TicketTypes,
+		SeriesID:    event.SeriesID,
 	}
 	eh.eventEmitter.Emit(&msg)
+	return id, nil
+}
+
+//validateTicketTypes makes sure no two ticket types of an event share a name, and that the sales
+//window of every type ends after it starts. The fields of a single type are checked by the validate
+//tags of ticketTypeRequest.
+func validateTicketTypes(types []persistence.TicketType) error {
+	names := map[string]bool{}
+	for _, tt := range types {
+		if names[tt.Name] {
+			return fmt.Errorf("ticket type %s is defined twice", tt.Name)
+		}
+		names[tt.Name] = true
+		if tt.SalesStart > 0 && tt.SalesEnd > 0 && tt.SalesEnd <= tt.SalesStart {
+			return fmt.Errorf("sales of ticket type %s end before they start", tt.Name)
+		}
+	}
+	return nil
+}
+
+//validatePolicy makes sure no two refund tiers of a cancellation policy apply from the same point
+//in time.
+func validatePolicy(policy *persistence.CancellationPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	hours := map[int]bool{}
+	for _, tier := range policy.Tiers {
+		if hours[tier.HoursBefore] {
+			return fmt.Errorf("there are two refund tiers for %d hours before the event", tier.HoursBefore)
+		}
+		hours[tier.HoursBefore] = true
+	}
+	return nil
+}
+
+type cancelEventRequest struct {
+	Reason string `json:"reason" validate:"max=1000"`
+}
+
+//cancelEventHandler cancels an event on behalf of its organizer. The bookings service reacts to the
+//event.cancelled contract by refunding every booking of the event.
+func (eh *eventServiceHandler) cancelEventHandler(w http.ResponseWriter, r *http.Request) {
+	eventID := mux.Vars(r)["eventID"]
+	id, err := hex.DecodeString(eventID)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed event id %s", eventID)
+		return
+	}
+	request := cancelEventRequest{}
+	//The reason is optional, so an empty body is fine.
+	if r.ContentLength != 0 {
+		if err := httpapi.Decode(w, r, &request); err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+	}
 
-	w.Header().Set("Content-Type", "application/json;charset=utf8")
+	cancelledAt := time.Now().Unix()
+	err = eh.dbhandler.CancelEvent(id, cancelledAt)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusNotFound, "event %s could not be cancelled", eventID)
+		return
+	}
+	eh.eventEmitter.Emit(&contracts.EventCancelledEvent{
+		ID:          eventID,
+		Reason:      request.Reason,
+		CancelledAt: cancelledAt,
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+//findEvent loads the event of the eventID route variable, and answers the request if there is none.
+func (eh *eventServiceHandler) findEvent(w http.ResponseWriter, r *http.Request) (persistence.Event, bool) {
+	eventID := mux.Vars(r)["eventID"]
+	id, err := hex.DecodeString(eventID)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed event id %s", eventID)
+		return persistence.Event{}, false
+	}
+	event, err := eh.dbhandler.FindEvent(id)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusNotFound, "event %s could not be loaded", eventID)
+		return persistence.Event{}, false
+	}
+	return event, true
+}
 
-	w.WriteHeader(201)
-	json.NewEncoder(w).Encode(&event)
+func findHall(location persistence.Location, name string) (persistence.Hall, bool) {
+	for _, hall := range location.Halls {
+		if hall.Name == name {
+			return hall, true
+		}
+	}
+	return persistence.Hall{}, false
 }
 
-func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.DatabaseHandler, eventEmitter msgqueue.EventEmitter) (chan error, chan error) {
+//newRouter routes the requests to the service to the handler. Every route is described by apiSpec.
+func newRouter(handler *eventServiceHandler, checker *health.Checker) *mux.Router {
 	//With this we get a router object called r, to help  us define our routes and link them
 	//with actions to execute:
 	r := mux.NewRouter()
+	//Requests get an ID, continue the correlation and the trace of their caller, or start new ones,
+	//and are counted and timed.
+	r.Use(logging.Middleware, tracing.Middleware, metrics.Middleware)
+	//Requests that match no route are answered with a problem as well.
+	r.NotFoundHandler = http.HandlerFunc(httpapi.NotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(httpapi.MethodNotAllowed)
+	//Prometheus scrapes the metrics of the service from here:
+	r.Methods("GET").Path("/metrics").Handler(metrics.Handler())
+	//The orchestrator asks whether the service is alive and ready to take requests here:
+	r.Methods("GET").Path("/healthz").HandlerFunc(checker.LivenessHandler)
+	r.Methods("GET").Path("/readyz").HandlerFunc(checker.ReadinessHandler)
+	//Clients look up the routes of the service, and the bodies they take and return, here:
+	r.Methods("GET").Path("/openapi.json").Handler(openapi.Handler(apiSpec()))
 	//A subrouter is basically an object that will in charge of any incoming HTTP request
 	//directed towards a relative URL that starts with /events. This code makes use of the
 	//router object we created earlier, then calls the PathPrefix method, which is used to
@@ -128,16 +383,50 @@ func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.D
 	//The eventsrouter can be used to define what to do with the rest of the URLs that share
 	//the /events prefix.
 
-	handler := newEventHandler(databaseHandler, eventEmitter)
+	//Every request is handled by a copy of the handler that is bound to the trace of the request.
+	route := func(handle func(*eventServiceHandler, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			handle(handler.withContext(r.Context()), w, r)
+		}
+	}
 
 	eventsrouter := r.PathPrefix("/events").Subrouter()
 
+	//The promo codes of an event are listed through /events/{eventID}/promocodes. The route has to be
+	//registered before the search route, which would otherwise match it as well.
+	eventsrouter.Methods("GET").Path("/{eventID}/promocodes").HandlerFunc(route((*eventServiceHandler).allPromoCodesHandler))
+	//The same goes for the live attendance of an event (/events/{eventID}/attendance):
+	eventsrouter.Methods("GET").Path("/{eventID}/attendance").HandlerFunc(route((*eventServiceHandler).attendanceHandler))
+	//...for the calendar file of an event (/events/id/{eventID}.ics):
+	eventsrouter.Methods("GET").Path("/id/{eventID}.ics").HandlerFunc(route((*eventServiceHandler).eventCalendarHandler))
+	//...and for recurring events, which are looked up by their series (/events/series/{seriesID}):
+	eventsrouter.Methods("GET").Path("/series/{seriesID}").HandlerFunc(route((*eventServiceHandler).findSeriesHandler))
 	//Here we implement the search functionality by id(/events/id/3434) or name(/events/name/jazz_concert).
-	eventsrouter.Methods("GET").Path("/{SearchCriteria}/{search}").HandlerFunc(handler.findEventHandler)
+	eventsrouter.Methods("GET").Path("/{SearchCriteria}/{search}").HandlerFunc(route((*eventServiceHandler).findEventHandler))
 	//Here we implement the retrival of all events at once:
-	eventsrouter.Methods("GET").Path("").HandlerFunc(handler.allEventHandler)
+	eventsrouter.Methods("GET").Path("").HandlerFunc(route((*eventServiceHandler).allEventHandler))
 	//Here we implement the creation of a new event (/events):
-	eventsrouter.Methods("POST").Path("").HandlerFunc(handler.newEventHandler)
+	eventsrouter.Methods("POST").Path("").HandlerFunc(route((*eventServiceHandler).newEventHandler))
+	//Here we implement editing a single event, or every occurrence of a recurring event at once:
+	eventsrouter.Methods("PUT").Path("/series/{seriesID}").HandlerFunc(route((*eventServiceHandler).updateSeriesHandler))
+	eventsrouter.Methods("PUT").Path("/{eventID}").HandlerFunc(route((*eventServiceHandler).updateEventHandler))
+	//Here we implement the cancellation of an event by its organizer (/events/{eventID}/cancel):
+	eventsrouter.Methods("POST").Path("/{eventID}/cancel").HandlerFunc(route((*eventServiceHandler).cancelEventHandler))
+	//Here we implement the creation of promo codes for an event (/events/{eventID}/promocodes):
+	eventsrouter.Methods("POST").Path("/{eventID}/promocodes").HandlerFunc(route((*eventServiceHandler).newPromoCodeHandler))
+
+	//Locations have their own routes. Events refer to a stored location by its id, which lets us
+	//check that no two events take place in the same hall at the same time.
+	locationsrouter := r.PathPrefix("/locations").Subrouter()
+	locationsrouter.Methods("POST").Path("").HandlerFunc(route((*eventServiceHandler).newLocationHandler))
+	locationsrouter.Methods("GET").Path("/{locationID}").HandlerFunc(route((*eventServiceHandler).findLocationHandler))
+	//Here we implement the free/busy calendar of the halls of a location (/locations/{locationID}/freebusy):
+	locationsrouter.Methods("GET").Path("/{locationID}/freebusy").HandlerFunc(route((*eventServiceHandler).freeBusyHandler))
+	return r
+}
+
+func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.DatabaseHandler, eventEmitter msgqueue.EventEmitter, checker *health.Checker) (chan error, chan error) {
+	r := newRouter(newEventHandler(databaseHandler, eventEmitter), checker)
 
 	//We use go channels to handle error correcting
 	httpErrChan := make(chan error)
@@ -152,8 +441,20 @@ func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.D
 	//ListenAndServe() and ListenAndServeTLS, but because they are both blocking functions, one
 	//cannot be listening while the other is listening so we have to make separate goroutins for them.
 	server := handlers.CORS()(r)
-	go func() { httpIsErrChan <- http.ListenAndServeTLS(tlsendpoint, "cert.pem", "key.pem", server) }()
-	go func() { httpErrChan <- http.ListenAndServe(endpoint, server) }()
+	httpServer := &http.Server{Addr: endpoint, Handler: server}
+	httpsServer := &http.Server{Addr: tlsendpoint, Handler: server}
+	go func() {
+		if err := httpsServer.ListenAndServeTLS("cert.pem", "key.pem"); err != http.ErrServerClosed {
+			httpIsErrChan <- err
+		}
+	}()
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			httpErrChan <- err
+		}
+	}()
+	//When the service is asked to stop, it finishes the requests in flight before it exits.
+	go checker.ShutdownOnSignal(httpServer, httpsServer)
 
 	return httpErrChan, httpIsErrChan
 }
@@ -172,17 +473,22 @@ func main() {
 	flag.Parse()
 	//extract configuration
 	config, _ := configuration.ExtractConfiguration(*confPath)
-	fmt.Println("Connecting to the AMQP message broker")
+	if err := logging.Init("events", config.LogFormat, config.LogLevel); err != nil {
+		logging.Fatal("could not set up logging", "error", err)
+	}
+	if err := tracing.Init("events", config.TracingExporter, config.TracingEndpoint); err != nil {
+		logging.Fatal("could not set up tracing", "error", err)
+	}
 
-	fmt.Println("Connecting to database")
+	slog.Info("connecting to the database", "type", config.Databasetype)
 	configMap := make(map[string]interface{})
 	configMap["connection"] = config.DBConnection
 	configMap["region"] = config.AWSRegion
 	dbhandler, err := dblayer.NewPersistenceLayer(config.Databasetype, configMap)
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		slog.Error("could not connect to the database", "error", err)
 	} else {
-		fmt.Println("Connected to the database")
+		slog.Info("connected to the database")
 	}
 
 	// conn, err := amqp.Dial(config.AMQPMessageBroker)
@@ -194,25 +500,49 @@ func main() {
 
 	conn2 := msgqueue_amqp.NewAMQPConnection(config.AMQPMessageBroker)
 
-	eventEmitter, err := msgqueue_amqp.NewAMQPEventEmitter(conn2, "myevents")
+	codec, err := msgqueue.NewCodec(config.MessageCodec)
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		logging.Fatal("could not create the message codec", "error", err)
+	}
+	eventEmitter, err := msgqueue_amqp.NewAMQPEventEmitter(conn2, "myevents", codec)
+	if err != nil {
+		slog.Error("could not create the event emitter", "error", err)
+	}
+	//Every contract we emit is recorded in the event store as well, so that projections can be rebuilt.
+	eventStore, err := eventstore.NewEventStore(config.Databasetype, map[string]interface{}{
+		"connection": config.EventStoreConnection,
+		"region":     config.AWSRegion,
+	})
+	if err != nil {
+		slog.Error("could not connect to the event store", "error", err)
+	} else {
+		eventEmitter = &eventstore.RecordingEmitter{Store: eventStore, Emitter: eventEmitter}
 	}
 	eventListener, err := msgqueue_amqp.NewAMQPEventListener(conn2, "myevents", "events")
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		slog.Error("could not create the event listener", "error", err)
 	}
 
-	processor := &listener.EventProcessor{eventListener, dbhandler}
-	go processor.ProcessEvents()
+	//The orchestrator learns from these checks whether the service is ready to take requests.
+	checker := health.NewChecker()
+	checker.DrainDelay = time.Duration(config.ShutdownDrainSeconds) * time.Second
+	checker.AddReadiness("database", func() error { return persistence.Ping(dbhandler) })
+	checker.AddReadiness("broker", conn2.Check)
+
+	processor := &listener.EventProcessor{EventListener: eventListener, EventEmitter: eventEmitter, Database: dbhandler}
+	//Without its listener, the service would never learn about the events of the others.
+	checker.Go("listener", processor.ProcessEvents)
 
-	httpErrChan, httpIsErrChan := ServeAPI(config.RestfulEndpoint, config.RestfulTLSEndpoint, dbhandler, eventEmitter)
-	fmt.Printf("Started listening for http connections on: %s\n", config.RestfulEndpoint)
+	httpErrChan, httpIsErrChan := ServeAPI(config.RestfulEndpoint, config.RestfulTLSEndpoint, dbhandler, eventEmitter, checker)
+	slog.Info("listening for http connections", "endpoint", config.RestfulEndpoint, "tls_endpoint", config.RestfulTLSEndpoint)
 
 	select {
 	case err := <-httpErrChan:
-		log.Fatal("HTTP Error: ", err)
+		logging.Fatal("http server failed", "error", err)
 	case err := <-httpIsErrChan:
-		log.Fatal("HTTPS Error: ", err)
+		logging.Fatal("https server failed", "error", err)
+	case <-checker.Stopped():
+		tracing.Flush()
+		slog.Info("stopped")
 	}
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/openapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//apiSpec describes the REST API of the events service.
+func apiSpec() *openapi.Document {
+	d := openapi.New("MyEvents events", "1.0.0")
+	d.AddStandard()
+
+	d.Add("GET", "/events/{SearchCriteria}/{search}", "findEvent", "Finds an event by id or by name, like /events/name/jazz_concert").
+		Tag("events").
+		Returns(http.StatusOK, eventResponse{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound)
+	d.Add("GET", "/events", "listEvents", "Lists every event").
+		Tag("events").
+		Returns(http.StatusOK, []eventResponse{})
+	d.Add("POST", "/events", "createEvent", "Creates an event; recurring events answer with their series and its occurrences").
+		Tag("events").
+		Accepts(newEventRequest{}).
+		Returns(http.StatusCreated, eventResponse{}).
+		Fails(http.StatusNotFound, http.StatusConflict)
+	d.Add("PUT", "/events/{eventID}", "updateEvent", "Edits an event, which detaches an occurrence from its series").
+		Tag("events").
+		Accepts(eventUpdate{}).
+		Returns(http.StatusOK, eventResponse{}).
+		Fails(http.StatusNotFound, http.StatusConflict)
+	cancel := d.Add("POST", "/events/{eventID}/cancel", "cancelEvent", "Cancels an event, which refunds its bookings").
+		Tag("events").
+		Accepts(cancelEventRequest{}).
+		Returns(http.StatusAccepted, nil).
+		Fails(http.StatusNotFound)
+	//The reason of a cancellation is optional.
+	cancel.RequestBody.Required = false
+	d.Add("GET", "/events/id/{eventID}.ics", "eventCalendar", "The event as an iCalendar file").
+		Tag("events").
+		ReturnsFile(http.StatusOK, "text/calendar").
+		Fails(http.StatusBadRequest, http.StatusNotFound)
+	d.Add("GET", "/events/{eventID}/attendance", "attendance", "How many people have been let into an event").
+		Tag("events").
+		Returns(http.StatusOK, attendanceResponse{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound)
+
+	d.Add("GET", "/events/series/{seriesID}", "findSeries", "Finds a recurring event and its occurrences").
+		Tag("series").
+		Returns(http.StatusOK, seriesResponse{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound)
+	d.Add("PUT", "/events/series/{seriesID}", "updateSeries", "Edits every occurrence of a recurring event that is still to come").
+		Tag("series").
+		Accepts(eventUpdate{}).
+		Returns(http.StatusOK, seriesResponse{}).
+		Fails(http.StatusNotFound, http.StatusConflict)
+
+	d.Add("GET", "/events/{eventID}/promocodes", "listPromoCodes", "Lists the promo codes of an event").
+		Tag("promo codes").
+		Returns(http.StatusOK, []persistence.PromoCode{})
+	d.Add("POST", "/events/{eventID}/promocodes", "createPromoCode", "Creates a promo code for an event").
+		Tag("promo codes").
+		Accepts(promoCodeRequest{}).
+		Returns(http.StatusCreated, persistence.PromoCode{}).
+		Fails(http.StatusNotFound, http.StatusConflict)
+
+	d.Add("POST", "/locations", "createLocation", "Stores a location that events can refer to").
+		Tag("locations").
+		Accepts(locationRequest{}).
+		Returns(http.StatusCreated, locationResponse{})
+	d.Add("GET", "/locations/{locationID}", "findLocation", "Finds a location by id").
+		Tag("locations").
+		Returns(http.StatusOK, locationResponse{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound)
+	d.Add("GET", "/locations/{locationID}/freebusy", "freeBusy", "Which halls of a location are taken by which events").
+		Tag("locations").
+		Query("from", "start of the time range in unix seconds, defaults to now").
+		Query("to", "end of the time range in unix seconds, defaults to a week from now").
+		Returns(http.StatusOK, freeBusyResponse{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound)
+	return d
+}
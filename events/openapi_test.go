@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/health"
+)
+
+func TestAPISpec(t *testing.T) {
+	r := newRouter(newEventHandler(nil, nil), health.NewChecker())
+	if err := apiSpec().CheckRoutes(r); err != nil {
+		t.Error(err)
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected the document to be served, got %d", recorder.Code)
+	}
+}
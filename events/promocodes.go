@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/gorilla/mux"
+)
+
+//promoCodeRequest holds the fields of a promo code organizers choose. How often a code was used is
+//counted by the bookings service.
+type promoCodeRequest struct {
+	Code           string   `json:"code" validate:"required"`
+	Kind           string   `json:"kind" validate:"required,oneof=percent fixed"`
+	Percent        int      `json:"percent,omitempty" validate:"min=1,max=100"`
+	Amount         int64    `json:"amount,omitempty" validate:"min=1"`             //fixed discounts, in minor units of Currency
+	Currency       string   `json:"currency,omitempty" validate:"format=currency"` //fixed discounts only apply to prices in this currency
+	MaxUses        int      `json:"maxUses,omitempty" validate:"min=0"`            //0 means unlimited
+	MaxUsesPerUser int      `json:"maxUsesPerUser,omitempty" validate:"min=0"`     //0 means unlimited
+	ValidFrom      int64    `json:"validFrom,omitempty"`
+	ValidUntil     int64    `json:"validUntil,omitempty"`
+	TicketTypes    []string `json:"ticketTypes,omitempty"` //empty means the code applies to every ticket type
+}
+
+func (req promoCodeRequest) promoCode() persistence.PromoCode {
+	return persistence.PromoCode{
+		Code:           req.Code,
+		Kind:           req.Kind,
+		Percent:        req.Percent,
+		Amount:         req.Amount,
+		Currency:       req.Currency,
+		MaxUses:        req.MaxUses,
+		MaxUsesPerUser: req.MaxUsesPerUser,
+		ValidFrom:      req.ValidFrom,
+		ValidUntil:     req.ValidUntil,
+		TicketTypes:    req.TicketTypes,
+	}
+}
+
+//newPromoCodeHandler creates a promo code for an event. The bookings service learns about the code
+//through the promocode.created contract and takes care of redeeming it.
+func (eh *eventServiceHandler) newPromoCodeHandler(w http.ResponseWriter, r *http.Request) {
+	eventID := mux.Vars(r)["eventID"]
+	event, ok := eh.findEvent(w, r)
+	if !ok {
+		return
+	}
+
+	request := promoCodeRequest{}
+	if err := httpapi.Decode(w, r, &request); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+	pc := request.promoCode()
+	pc.EventID = eventID
+	pc.Code = persistence.NormalizePromoCode(pc.Code)
+	if err := validatePromoCode(pc, event); err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid promo code: %s", err)
+		return
+	}
+
+	err := eh.dbhandler.AddPromoCode(pc)
+	if err == persistence.ErrPromoCodeExists {
+		httpapi.Error(w, r, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	eh.eventEmitter.Emit(&contracts.PromoCodeCreatedEvent{
+		EventID:        pc.EventID,
+		Code:           pc.Code,
+		Kind:           pc.Kind,
+		Percent:        pc.Percent,
+		Amount:         pc.Amount,
+		Currency:       pc.Currency,
+		MaxUses:        pc.MaxUses,
+		MaxUsesPerUser: pc.MaxUsesPerUser,
+		ValidFrom:      pc.ValidFrom,
+		ValidUntil:     pc.ValidUntil,
+		TicketTypes:    pc.TicketTypes,
+	})
+
+	httpapi.JSON(w, http.StatusCreated, &pc)
+}
+
+func (eh *eventServiceHandler) allPromoCodesHandler(w http.ResponseWriter, r *http.Request) {
+	eventID := mux.Vars(r)["eventID"]
+	codes, err := eh.dbhandler.FindPromoCodesByEventId([]byte(eventID))
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	httpapi.JSON(w, http.StatusOK, &codes)
+}
+
+//validatePromoCode checks what the validate tags of promoCodeRequest can't: the fields its kind of
+//discount needs, its validity window and the ticket types it applies to.
+func validatePromoCode(pc persistence.PromoCode, event persistence.Event) error {
+	switch pc.Kind {
+	case persistence.DiscountPercent:
+		if pc.Percent == 0 {
+			return errors.New("percent discounts need a percent")
+		}
+	case persistence.DiscountFixed:
+		if pc.Amount == 0 {
+			return errors.New("fixed discounts need an amount")
+		}
+		if pc.Currency == "" {
+			return errors.New("fixed discounts need a currency")
+		}
+	}
+	if pc.ValidFrom > 0 && pc.ValidUntil > 0 && pc.ValidUntil <= pc.ValidFrom {
+		return errors.New("the code stops being valid before it starts")
+	}
+	for _, name := range pc.TicketTypes {
+		if _, ok := event.FindTicketType(name); !ok {
+			return fmt.Errorf("event has no ticket type %s", name)
+		}
+	}
+	return nil
+}
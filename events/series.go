@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/rrule"
+	"github.com/gorilla/mux"
+)
+
+//maxOccurrences limits how many events a single recurrence rule can create.
+const maxOccurrences = 500
+
+type newEventRequest struct {
+	eventRequest
+	localTimes
+	LocationID string             `json:"locationId"` //hex id of a location stored through /locations
+	Recurrence *recurrenceRequest `json:"recurrence"`
+}
+
+type recurrenceRequest struct {
+	Rule    string  `json:"rule"`    //RFC 5545 RRULE, e.g. FREQ=WEEKLY;BYDAY=TU;COUNT=10
+	ExDates []int64 `json:"exdates"` //start times of occurrences to leave out
+}
+
+type seriesResponse struct {
+	Series      persistence.EventSeries `json:"series"`
+	Occurrences []eventResponse         `json:"occurrences"`
+}
+
+//eventUpdate holds the fields of an event that can be changed after it was created. Fields that
+//are left out stay as they are. Like on creation, times can be given as local times of the venue.
+type eventUpdate struct {
+	Name       *string
+	StartDate  *int64
+	EndDate    *int64
+	LocalStart *string
+	LocalEnd   *string
+}
+
+//apply changes the event. An event that only gets a new start keeps its length.
+func (u eventUpdate) apply(event *persistence.Event) error {
+	zone, err := event.Location.Zone()
+	if err != nil {
+		return err
+	}
+	if u.LocalStart != nil {
+		start, err := parseLocalTime(*u.LocalStart, zone)
+		if err != nil {
+			return err
+		}
+		u.StartDate = new(int64)
+		*u.StartDate = start.Unix()
+	}
+	if u.LocalEnd != nil {
+		end, err := parseLocalTime(*u.LocalEnd, zone)
+		if err != nil {
+			return err
+		}
+		u.EndDate = new(int64)
+		*u.EndDate = end.Unix()
+	}
+	if u.Name != nil {
+		if *u.Name == "" {
+			return errors.New("events need a name")
+		}
+		event.Name = *u.Name
+	}
+	if u.StartDate != nil {
+		length := event.EndDate - event.StartDate
+		event.StartDate = *u.StartDate
+		event.EndDate = event.StartDate + length
+	}
+	if u.EndDate != nil {
+		event.EndDate = *u.EndDate
+	}
+	if event.EndDate < event.StartDate {
+		return errors.New("event ends before it starts")
+	}
+	return nil
+}
+
+func (u eventUpdate) movesTimes() bool {
+	return u.StartDate != nil || u.EndDate != nil || u.LocalStart != nil || u.LocalEnd != nil
+}
+
+//newSeries creates a recurring event: the series with its rule, and an event for every occurrence
+//of the rule. Each occurrence is announced with event.created like any other event, so the other
+//services don't have to know about recurrence at all.
+func (eh *eventServiceHandler) newSeries(w http.ResponseWriter, r *http.Request, template persistence.Event, recurrence recurrenceRequest) {
+	rule, err := rrule.Parse(recurrence.Rule)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid recurrence rule: %s", err)
+		return
+	}
+	exdates := []time.Time{}
+	for _, exdate := range recurrence.ExDates {
+		exdates = append(exdates, time.Unix(exdate, 0))
+	}
+	//The rule is expanded in the time zone of the venue, so that a weekly event at 19:00 keeps
+	//starting at 19:00 local time after the clocks change.
+	starts, err := rule.Expand(template.Start(), exdates, maxOccurrences)
+	if err == rrule.ErrTooManyOccurrences {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "recurrence rule has to end within %d occurrences, use COUNT or UNTIL", maxOccurrences)
+		return
+	}
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid recurrence rule: %s", err)
+		return
+	}
+
+	length := template.EndDate - template.StartDate
+	occurrences := []persistence.Event{}
+	for _, start := range starts {
+		occurrence := template
+		occurrence.ID = ""
+		occurrence.StartDate = start.Unix()
+		occurrence.EndDate = occurrence.StartDate + length
+		if err := validateTimes(occurrence); err != nil {
+			httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid times of the occurrence on %s: %s", occurrence.Start().Format("2006-01-02"), err)
+			return
+		}
+		occurrences = append(occurrences, occurrence)
+	}
+	unlock, ok := eh.lockHalls(w, r, occurrences...)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	series := persistence.EventSeries{
+		Name:      template.Name,
+		Rule:      rule.String(),
+		ExDates:   recurrence.ExDates,
+		TimeZone:  template.Location.TimeZone,
+		StartDate: template.StartDate,
+		EndDate:   template.EndDate,
+	}
+	seriesID, err := eh.dbhandler.AddEventSeries(series)
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	series.ID = string(seriesID)
+
+	for i := range occurrences {
+		occurrences[i].SeriesID = hex.EncodeToString(seriesID)
+		id, err := eh.addEvent(occurrences[i])
+		if err != nil {
+			httpapi.InternalError(w, r, err)
+			return
+		}
+		occurrences[i].ID = string(id)
+	}
+
+	httpapi.JSON(w, http.StatusCreated, &seriesResponse{series, localEvents(occurrences)})
+}
+
+func (eh *eventServiceHandler) findSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	series, ok := eh.findSeries(w, r)
+	if !ok {
+		return
+	}
+	occurrences, err := eh.dbhandler.FindEventsBySeriesId([]byte(mux.Vars(r)["seriesID"]))
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	httpapi.JSON(w, http.StatusOK, &seriesResponse{series, localEvents(occurrences)})
+}
+
+//updateEventHandler edits a single event. An occurrence of a recurring event that is edited on its
+//own is detached from its series, so later edits of the whole series leave it alone.
+func (eh *eventServiceHandler) updateEventHandler(w http.ResponseWriter, r *http.Request) {
+	event, ok := eh.findEvent(w, r)
+	if !ok {
+		return
+	}
+
+	update := eventUpdate{}
+	if err := httpapi.Decode(w, r, &update); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+	if err := update.apply(&event); err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid event: %s", err)
+		return
+	}
+	if err := validateTimes(event); err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid event times: %s", err)
+		return
+	}
+	if update.movesTimes() {
+		unlock, ok := eh.lockHalls(w, r, event)
+		if !ok {
+			return
+		}
+		defer unlock()
+	}
+	event.Detached = event.SeriesID != ""
+
+	if err := eh.updateEvent(&event); err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	httpapi.JSON(w, http.StatusOK, localEvent(event))
+}
+
+//updateSeriesHandler edits every occurrence of a recurring event. New times are given for the first
+//occurrence of the series, and every other occurrence moves by as much local time as the first one.
+//Occurrences that already started, were cancelled or were edited on their own are left as they are.
+func (eh *eventServiceHandler) updateSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	series, ok := eh.findSeries(w, r)
+	if !ok {
+		return
+	}
+	update := eventUpdate{}
+	if err := httpapi.Decode(w, r, &update); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+	previous := persistence.Event{
+		Name:      series.Name,
+		StartDate: series.StartDate,
+		EndDate:   series.EndDate,
+		Location:  persistence.Location{TimeZone: series.TimeZone},
+	}
+	first := previous
+	if err := update.apply(&first); err != nil {
+		httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid event: %s", err)
+		return
+	}
+	length := first.EndDate - first.StartDate
+
+	occurrences, err := eh.dbhandler.FindEventsBySeriesId([]byte(mux.Vars(r)["seriesID"]))
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	//Every occurrence is checked against the opening hours and the schedule of its hall before any
+	//of them is changed.
+	now := time.Now().Unix()
+	changed := map[int]bool{}
+	for i, occurrence := range occurrences {
+		if occurrence.Detached || occurrence.CancelledAt > 0 || occurrence.StartDate <= now {
+			continue
+		}
+		if update.Name != nil {
+			occurrence.Name = first.Name
+		}
+		if update.movesTimes() {
+			occurrence.StartDate = shiftWallClock(occurrence.Start(), previous.Start(), first.Start()).Unix()
+			occurrence.EndDate = occurrence.StartDate + length
+			if err := validateTimes(occurrence); err != nil {
+				httpapi.Errorf(w, r, http.StatusUnprocessableEntity, "invalid times of the occurrence on %s: %s", occurrence.Start().Format("2006-01-02"), err)
+				return
+			}
+		}
+		occurrences[i] = occurrence
+		changed[i] = true
+	}
+	if update.movesTimes() {
+		moved := []persistence.Event{}
+		for i := range occurrences {
+			if changed[i] {
+				moved = append(moved, occurrences[i])
+			}
+		}
+		unlock, ok := eh.lockHalls(w, r, moved...)
+		if !ok {
+			return
+		}
+		defer unlock()
+	}
+	for i := range occurrences {
+		if !changed[i] {
+			continue
+		}
+		if err := eh.updateEvent(&occurrences[i]); err != nil {
+			httpapi.InternalError(w, r, err)
+			return
+		}
+	}
+
+	series.Name, series.StartDate, series.EndDate = first.Name, first.StartDate, first.EndDate
+	if err := eh.dbhandler.UpdateEventSeries(series); err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	httpapi.JSON(w, http.StatusOK, &seriesResponse{series, localEvents(occurrences)})
+}
+
+func (eh *eventServiceHandler) findSeries(w http.ResponseWriter, r *http.Request) (persistence.EventSeries, bool) {
+	seriesID := mux.Vars(r)["seriesID"]
+	id, err := hex.DecodeString(seriesID)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusBadRequest, "malformed series id %s", seriesID)
+		return persistence.EventSeries{}, false
+	}
+	series, err := eh.dbhandler.FindEventSeries(id)
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusNotFound, "series %s could not be loaded", seriesID)
+		return persistence.EventSeries{}, false
+	}
+	return series, true
+}
+
+//updateEvent stores the changes to an event and announces them to the other services. Every change
+//bumps the sequence of the event.
+func (eh *eventServiceHandler) updateEvent(event *persistence.Event) error {
+	event.Sequence++
+	if err := eh.dbhandler.UpdateEvent(*event); err != nil {
+		return err
+	}
+	eh.eventEmitter.Emit(&contracts.EventUpdatedEvent{
+		ID:       hex.EncodeToString([]byte(event.ID)),
+		Name:     event.Name,
+		Start:    event.Start(),
+		End:      event.End(),
+		SeriesID: event.SeriesID,
+		Sequence: event.Sequence,
+	})
+	return nil
+}
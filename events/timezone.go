@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//localTimeLayouts are the layouts local times are accepted in. Times without an offset are wall
+//clock times in the time zone of the venue.
+var localTimeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04"}
+
+//localTimes are the start and end of an event as wall clock times of its venue, like
+//2026-03-29T19:00. Organizers can give them instead of the unix times of the event.
+type localTimes struct {
+	LocalStart string
+	LocalEnd   string
+}
+
+//eventResponse adds the start and end of an event in the local time of its venue.
+type eventResponse struct {
+	persistence.Event
+	LocalStart string
+	LocalEnd   string
+}
+
+//resolve sets the start and end of the event from the local times, if they were given.
+func (lt localTimes) resolve(event *persistence.Event) error {
+	zone, err := event.Location.Zone()
+	if err != nil {
+		return err
+	}
+	if lt.LocalStart != "" {
+		start, err := parseLocalTime(lt.LocalStart, zone)
+		if err != nil {
+			return err
+		}
+		event.StartDate = start.Unix()
+	}
+	if lt.LocalEnd != "" {
+		end, err := parseLocalTime(lt.LocalEnd, zone)
+		if err != nil {
+			return err
+		}
+		event.EndDate = end.Unix()
+	}
+	return nil
+}
+
+func parseLocalTime(value string, zone *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, zone); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("malformed time %s, expected a time like 2006-01-02T15:04", value)
+}
+
+//validateTimes makes sure the event ends after it starts and takes place while its location is
+//open.
+func validateTimes(event persistence.Event) error {
+	if event.EndDate < event.StartDate {
+		return errors.New("event ends before it starts")
+	}
+	open, err := event.Location.OpenDuring(event.Start(), event.End())
+	if err != nil {
+		return err
+	}
+	if !open {
+		return fmt.Errorf("event does not fall within the opening hours of the location (%d:00 - %d:00 %s)",
+			event.Location.OpenTime, event.Location.CloseTime, event.Start().Location())
+	}
+	return nil
+}
+
+func localEvent(event persistence.Event) eventResponse {
+	return eventResponse{
+		Event:      event,
+		LocalStart: event.Start().Format(time.RFC3339),
+		LocalEnd:   event.End().Format(time.RFC3339),
+	}
+}
+
+func localEvents(events []persistence.Event) []eventResponse {
+	responses := []eventResponse{}
+	for _, event := range events {
+		responses = append(responses, localEvent(event))
+	}
+	return responses
+}
+
+//shiftWallClock moves t by as much wall clock time as lies between from and to. Occurrences of a
+//series that is moved keep their local time of day that way, even when a daylight saving change
+//lies between them and the first occurrence.
+func shiftWallClock(t time.Time, from time.Time, to time.Time) time.Time {
+	naive := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	}
+	shifted := naive(t).Add(naive(to).Sub(naive(from)))
+	return time.Date(shifted.Year(), shifted.Month(), shifted.Day(), shifted.Hour(), shifted.Minute(), shifted.Second(), 0, t.Location())
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/payments"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//Bookings is a client of the bookings service.
+type Bookings struct {
+	*Client
+}
+
+//NewBookings returns a client of the bookings service at the base URL.
+func NewBookings(baseURL string) *Bookings {
+	return &Bookings{NewClient(baseURL)}
+}
+
+//NewBooking is the body of a request to book seats of an event. Seats can be booked by number,
+//or picked from the seat map of the hall by their IDs.
+type NewBooking struct {
+	EventID    string   `json:"eventId"`
+	Seats      int      `json:"seats"`
+	SeatIDs    []string `json:"seatIds,omitempty"`
+	TicketType string   `json:"ticketType,omitempty"`
+	PromoCode  string   `json:"promoCode,omitempty"`
+}
+
+//BookingResult is the outcome of a booking. Booking is set once the booking is held; until then,
+//Saga says how far it got.
+type BookingResult struct {
+	Booking *persistence.Booking
+	Saga    *persistence.BookingSaga
+}
+
+type Payment struct {
+	Booking persistence.Booking `json:"booking"`
+	Payment payments.Intent     `json:"payment"`
+}
+
+type Ticket struct {
+	ID     string `json:"id"`
+	Number int    `json:"number"`
+	Seat   string `json:"seat,omitempty"`
+	Token  string `json:"token"`
+	QRCode string `json:"qrCode"`
+}
+
+type SeatAvailability struct {
+	EventID   string `json:"eventId"`
+	Hall      string `json:"hall"`
+	Available int    `json:"available"`
+	Seats     []Seat `json:"seats"`
+}
+
+type Seat struct {
+	persistence.SeatInfo
+	Available bool `json:"available"`
+}
+
+type WaitlistPosition struct {
+	EventID      string `json:"eventId"`
+	Status       string `json:"status"`
+	Seats        int    `json:"seats"`
+	TicketType   string `json:"ticketType,omitempty"`
+	Position     int    `json:"position"`
+	BookingID    string `json:"bookingId,omitempty"`
+	OfferExpires int64  `json:"offerExpires,omitempty"`
+}
+
+type CalendarLink struct {
+	URL    string `json:"url"`
+	Webcal string `json:"webcal"`
+}
+
+type PublicKey struct {
+	Algorithm string `json:"algorithm"`
+	Key       string `json:"key"`
+}
+
+func bookingsPath(userID string) string {
+	return "/users/" + url.PathEscape(userID) + "/bookings"
+}
+
+//Book books seats of an event for a user. Bookings that take the service longer than a moment come
+//back with only their saga, which FindSaga follows.
+func (c *Bookings) Book(ctx context.Context, userID string, booking NewBooking) (BookingResult, error) {
+	response, err := c.send(ctx, "POST", bookingsPath(userID)+"/", &booking)
+	if err != nil {
+		return BookingResult{}, err
+	}
+	defer response.Body.Close()
+	result := BookingResult{}
+	if response.StatusCode == http.StatusAccepted {
+		result.Saga = &persistence.BookingSaga{}
+		return result, decodeJSON(response, result.Saga)
+	}
+	result.Booking = &persistence.Booking{}
+	return result, decodeJSON(response, result.Booking)
+}
+
+func (c *Bookings) FindSaga(ctx context.Context, userID string, sagaID string) (persistence.BookingSaga, error) {
+	saga := persistence.BookingSaga{}
+	err := c.Do(ctx, "GET", bookingsPath(userID)+"/sagas/"+url.PathEscape(sagaID), nil, &saga)
+	return saga, err
+}
+
+//Find returns the booking of a user with the hex encoded ID.
+func (c *Bookings) Find(ctx context.Context, userID string, bookingID string) (persistence.Booking, error) {
+	bookings := []persistence.Booking{}
+	err := c.Do(ctx, "GET", bookingsPath(userID)+"/id/"+url.PathEscape(bookingID), nil, &bookings)
+	if err != nil || len(bookings) == 0 {
+		return persistence.Booking{}, err
+	}
+	return bookings[0], nil
+}
+
+func (c *Bookings) List(ctx context.Context, userID string) ([]persistence.Booking, error) {
+	bookings := []persistence.Booking{}
+	err := c.Do(ctx, "GET", bookingsPath(userID)+"/userid/"+url.PathEscape(userID), nil, &bookings)
+	return bookings, err
+}
+
+func (c *Bookings) Confirm(ctx context.Context, userID string, bookingID string) (persistence.Booking, error) {
+	booking := persistence.Booking{}
+	err := c.Do(ctx, "POST", bookingsPath(userID)+"/"+url.PathEscape(bookingID)+"/confirm", nil, &booking)
+	return booking, err
+}
+
+func (c *Bookings) Cancel(ctx context.Context, userID string, bookingID string) (persistence.Booking, error) {
+	booking := persistence.Booking{}
+	err := c.Do(ctx, "POST", bookingsPath(userID)+"/"+url.PathEscape(bookingID)+"/cancel", nil, &booking)
+	return booking, err
+}
+
+//Pay pays for a held booking with the payment method. The payment may still be processing when Pay
+//returns; the booking is confirmed once it settles.
+func (c *Bookings) Pay(ctx context.Context, userID string, bookingID string, method string) (Payment, error) {
+	body := struct {
+		Method string `json:"method"`
+	}{method}
+	payment := Payment{}
+	err := c.Do(ctx, "POST", bookingsPath(userID)+"/"+url.PathEscape(bookingID)+"/pay", &body, &payment)
+	return payment, err
+}
+
+func (c *Bookings) Tickets(ctx context.Context, userID string, bookingID string) ([]Ticket, error) {
+	tickets := []Ticket{}
+	err := c.Do(ctx, "GET", bookingsPath(userID)+"/"+url.PathEscape(bookingID)+"/tickets", nil, &tickets)
+	return tickets, err
+}
+
+//TicketQRCode returns the QR code of a ticket as a PNG image.
+func (c *Bookings) TicketQRCode(ctx context.Context, userID string, bookingID string, number int) ([]byte, error) {
+	return c.Get(ctx, bookingsPath(userID)+"/"+url.PathEscape(bookingID)+"/tickets/"+strconv.Itoa(number)+".png")
+}
+
+//CheckIn lets the holder of the ticket with the token in. A ticket that was already used fails with
+//409, and comes back with the check-in that used it.
+func (c *Bookings) CheckIn(ctx context.Context, token string) (persistence.TicketCheckIn, error) {
+	body := struct {
+		Token string `json:"token"`
+	}{token}
+	checkIn := persistence.TicketCheckIn{}
+	err := c.Do(ctx, "POST", "/checkin", &body, &checkIn)
+	if e, ok := err.(*Error); ok && e.Status == http.StatusConflict {
+		problem := struct {
+			CheckIn persistence.TicketCheckIn `json:"checkIn"`
+		}{}
+		if decodeErr := json.Unmarshal(e.Body, &problem); decodeErr == nil {
+			checkIn = problem.CheckIn
+		}
+	}
+	return checkIn, err
+}
+
+func (c *Bookings) TicketPublicKey(ctx context.Context) (PublicKey, error) {
+	key := PublicKey{}
+	err := c.Do(ctx, "GET", "/tickets/publickey", nil, &key)
+	return key, err
+}
+
+func (c *Bookings) CalendarLink(ctx context.Context, userID string) (CalendarLink, error) {
+	link := CalendarLink{}
+	err := c.Do(ctx, "GET", bookingsPath(userID)+"/calendar", nil, &link)
+	return link, err
+}
+
+func (c *Bookings) JoinWaitlist(ctx context.Context, userID string, eventID string, seats int, ticketType string) (WaitlistPosition, error) {
+	body := struct {
+		Seats      int    `json:"seats"`
+		TicketType string `json:"ticketType,omitempty"`
+	}{seats, ticketType}
+	position := WaitlistPosition{}
+	err := c.Do(ctx, "POST", "/users/"+url.PathEscape(userID)+"/waitlist/"+url.PathEscape(eventID), &body, &position)
+	return position, err
+}
+
+func (c *Bookings) WaitlistPosition(ctx context.Context, userID string, eventID string) (WaitlistPosition, error) {
+	position := WaitlistPosition{}
+	err := c.Do(ctx, "GET", "/users/"+url.PathEscape(userID)+"/waitlist/"+url.PathEscape(eventID), nil, &position)
+	return position, err
+}
+
+func (c *Bookings) LeaveWaitlist(ctx context.Context, userID string, eventID string) error {
+	return c.Do(ctx, "DELETE", "/users/"+url.PathEscape(userID)+"/waitlist/"+url.PathEscape(eventID), nil, nil)
+}
+
+func (c *Bookings) Seats(ctx context.Context, eventID string) (SeatAvailability, error) {
+	seats := SeatAvailability{}
+	err := c.Do(ctx, "GET", "/events/"+url.PathEscape(eventID)+"/seats", nil, &seats)
+	return seats, err
+}
+
+//RefundJob returns how far the refunds of a cancelled event have come.
+func (c *Bookings) RefundJob(ctx context.Context, eventID string) (persistence.RefundJob, error) {
+	job := persistence.RefundJob{}
+	err := c.Do(ctx, "GET", "/events/"+url.PathEscape(eventID)+"/refunds", nil, &job)
+	return job, err
+}
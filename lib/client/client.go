@@ -0,0 +1,241 @@
+//Package client is a Go client of the REST APIs of the services, for our tools and integration
+//tests. The APIs are described by the OpenAPI documents the services serve at /openapi.json; the
+//bodies here are the same types the handlers decode and encode, or copies of them where those are
+//private to a service.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
+)
+
+//maxRetryDelay caps the delay between two attempts of a request, also when the service asks for a
+//longer one with Retry-After.
+const maxRetryDelay = 10 * time.Second
+
+//TokenSource returns the token requests are authorized with. It is asked for every attempt, so that
+//it can renew a token that expired.
+type TokenSource func(ctx context.Context) (string, error)
+
+//StaticToken authorizes every request with the same token.
+func StaticToken(token string) TokenSource {
+	return func(context.Context) (string, error) {
+		return token, nil
+	}
+}
+
+//Client sends requests to a service. The typed clients of the services, Users, Events and Bookings,
+//embed it.
+type Client struct {
+	BaseURL     string //like http://localhost:8181, without a trailing slash
+	HTTPClient  *http.Client
+	Token       TokenSource   //nil sends requests without an Authorization header
+	MaxAttempts int           //a request that failed this often is given up
+	RetryDelay  time.Duration //delay after the first failed attempt, doubles with every further one
+}
+
+//NewClient returns a client of the service at the base URL that makes up to three attempts of a
+//request.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:     strings.TrimSuffix(baseURL, "/"),
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 3,
+		RetryDelay:  200 * time.Millisecond,
+	}
+}
+
+//Error is the error of a request the service refused or failed. The problem is the one the service
+//answered with, or one made up from the status for responses that are not problems.
+type Error struct {
+	httpapi.Problem
+	//Body is the response as it came, for problems with extension members, like the event that is in
+	//the way of a new one.
+	Body []byte
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, e.Problem.Error())
+}
+
+//StatusOf returns the status of the response an error stands for, or 0 if the request didn't get
+//one.
+func StatusOf(err error) int {
+	if e, ok := err.(*Error); ok {
+		return e.Status
+	}
+	return 0
+}
+
+//Do sends a request with the JSON of body, if it is not nil, and decodes the JSON response into
+//out, if it is not nil. Requests that couldn't be sent, or that the service couldn't take right
+//now, are tried again, as long as they are idempotent. A POST is sent only once, since the service
+//may have handled it even though the response didn't make it back.
+func (c *Client) Do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	response, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return decodeJSON(response, out)
+}
+
+//Get sends a GET request and returns the body of the response as it came, for responses that are
+//not JSON, like calendar files and images.
+func (c *Client) Get(ctx context.Context, path string) ([]byte, error) {
+	response, err := c.send(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return ioutil.ReadAll(response.Body)
+}
+
+//send sends a request, trying it again as Do describes, and returns the first response that is a
+//success. The caller closes its body.
+func (c *Client) send(ctx context.Context, method string, path string, body interface{}) (*http.Response, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry := idempotent(method)
+	for attempt := 1; ; attempt++ {
+		response, err := c.attempt(ctx, method, path, payload)
+		var delay time.Duration
+		if err == nil {
+			if response.StatusCode < 300 {
+				return response, nil
+			}
+			err = decodeError(response)
+			response.Body.Close()
+			if !retryable(response.StatusCode) {
+				return nil, err
+			}
+			delay = retryAfter(response)
+		}
+		if !retry || attempt >= c.MaxAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+		if delay == 0 {
+			delay = c.retryDelay(attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method string, path string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json, "+httpapi.ContentTypeProblem)
+	if payload != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != nil {
+		token, err := c.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	//The request continues the trace and the correlation of whatever the caller is doing.
+	tracing.Inject(ctx, request.Header)
+	if id := logging.CorrelationID(ctx); id != "" {
+		request.Header.Set(logging.CorrelationIDHeader, id)
+	}
+	return c.HTTPClient.Do(request)
+}
+
+//retryDelay is the delay after the given number of failed attempts.
+func (c *Client) retryDelay(attempts int) time.Duration {
+	delay := c.RetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+//retryable tells whether a response says that the service couldn't take the request right now, as
+//opposed to refusing it.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+//retryAfter returns the delay the service asked for in seconds, or 0 if it didn't ask for one.
+func retryAfter(response *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(response.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	if delay := time.Duration(seconds) * time.Second; delay < maxRetryDelay {
+		return delay
+	}
+	return maxRetryDelay
+}
+
+//decodeJSON decodes the body of a response that is a success. A response without a body, like 204,
+//leaves out as it is.
+func decodeJSON(response *http.Response, out interface{}) error {
+	err := json.NewDecoder(response.Body).Decode(out)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+//decodeError turns a response that is not a success into an Error.
+func decodeError(response *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, httpapi.MaxBodyBytes))
+	e := &Error{Body: body}
+	contentType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if contentType == httpapi.ContentTypeProblem && json.Unmarshal(body, &e.Problem) == nil && e.Status != 0 {
+		return e
+	}
+	//A proxy in front of the service, or a service that panicked, answers with something else.
+	e.Problem = *httpapi.NewProblem(response.StatusCode, strings.TrimSpace(string(body)))
+	return e
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+func newTestClient(handler http.HandlerFunc) (*Client, func()) {
+	server := httptest.NewServer(handler)
+	c := NewClient(server.URL)
+	c.RetryDelay = time.Millisecond
+	return c, server.Close
+}
+
+func TestRetries(t *testing.T) {
+	attempts := 0
+	users, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			httpapi.Write(w, r, httpapi.NewProblem(http.StatusServiceUnavailable, "the database is not ready"))
+			return
+		}
+		httpapi.JSON(w, http.StatusOK, &[]persistence.User{{First: "Jane"}})
+	})
+	defer stop()
+
+	list, err := (&Users{users}).List(context.Background())
+	if err != nil || len(list) != 1 || attempts != 3 {
+		t.Fatalf("expected the third attempt to get the users, got %v and %v after %d attempts", list, err, attempts)
+	}
+
+	//The service may have taken a POST that failed, so it is not sent again.
+	attempts = 0
+	_, err = (&Users{users}).Create(context.Background(), NewUser{First: "Jane"})
+	if StatusOf(err) != http.StatusServiceUnavailable || attempts != 1 {
+		t.Errorf("expected a single attempt that failed with 503, got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	var header http.Header
+	c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	})
+	defer stop()
+	c.Token = StaticToken("s3cret")
+
+	ctx := logging.WithCorrelationID(context.Background(), "c0ffee")
+	if err := (&Bookings{c}).LeaveWaitlist(ctx, "5a5f5c", "6b6f6d"); err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Authorization") != "Bearer s3cret" || header.Get(logging.CorrelationIDHeader) != "c0ffee" {
+		t.Errorf("expected the token and the correlation ID to be sent, got %v", header)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/checkin":
+			problem := httpapi.NewProblem(http.StatusConflict, "the ticket was already checked in").Bind(r)
+			httpapi.WriteBody(w, problem.Status, &struct {
+				httpapi.Problem
+				CheckIn persistence.TicketCheckIn `json:"checkIn"`
+			}{*problem, persistence.TicketCheckIn{Seat: "A12"}})
+		default:
+			//Like a proxy in front of the service.
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		}
+	})
+	defer stop()
+	c.MaxAttempts = 1
+
+	checkIn, err := (&Bookings{c}).CheckIn(context.Background(), "token")
+	e, ok := err.(*Error)
+	if !ok || e.Status != http.StatusConflict || e.Detail != "the ticket was already checked in" || e.Instance != "/checkin" {
+		t.Fatalf("expected the problem of the service, got %v", err)
+	}
+	if checkIn.Seat != "A12" {
+		t.Errorf("expected the check-in that used the ticket, got %+v", checkIn)
+	}
+
+	_, err = (&Events{c}).List(context.Background())
+	if e, ok := err.(*Error); !ok || e.Status != http.StatusBadGateway || e.Title != "Bad Gateway" || e.Detail != "bad gateway" {
+		t.Errorf("expected a problem made up from the response, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//Events is a client of the events service.
+type Events struct {
+	*Client
+}
+
+//NewEvents returns a client of the events service at the base URL.
+func NewEvents(baseURL string) *Events {
+	return &Events{NewClient(baseURL)}
+}
+
+//Event is an event with its start and end in the local time of its venue.
+type Event struct {
+	persistence.Event
+	LocalStart string
+	LocalEnd   string
+}
+
+//NewEvent is the body of a request to create an event. The start and end can be given as local
+//times of the venue, like 2024-05-01T20:00, instead of StartDate and EndDate. An event with a
+//recurrence is created as a series, see CreateSeries.
+type NewEvent struct {
+	persistence.Event
+	LocalStart string      `json:",omitempty"`
+	LocalEnd   string      `json:",omitempty"`
+	LocationID string      `json:"locationId,omitempty"` //hex id of a location stored with CreateLocation
+	Recurrence *Recurrence `json:"recurrence,omitempty"`
+}
+
+type Recurrence struct {
+	Rule    string  `json:"rule"`              //RFC 5545 RRULE, e.g. FREQ=WEEKLY;BYDAY=TU;COUNT=10
+	ExDates []int64 `json:"exdates,omitempty"` //start times of occurrences to leave out
+}
+
+//EventUpdate holds the fields of an event to change. Fields that are nil stay as they are.
+type EventUpdate struct {
+	Name       *string `json:",omitempty"`
+	StartDate  *int64  `json:",omitempty"`
+	EndDate    *int64  `json:",omitempty"`
+	LocalStart *string `json:",omitempty"`
+	LocalEnd   *string `json:",omitempty"`
+}
+
+type Series struct {
+	Series      persistence.EventSeries `json:"series"`
+	Occurrences []Event                 `json:"occurrences"`
+}
+
+type Location struct {
+	ID       string               `json:"id"`
+	Location persistence.Location `json:"location"`
+}
+
+//FreeBusy says which halls of a location are taken by which events in a time range.
+type FreeBusy struct {
+	LocationID string         `json:"locationId"`
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	FreeHalls  []string       `json:"freeHalls"` //halls that are free for the whole range
+	Halls      []HallSchedule `json:"halls"`
+}
+
+type HallSchedule struct {
+	Name string     `json:"name"`
+	Busy []BusySlot `json:"busy"`
+}
+
+type BusySlot struct {
+	EventID string `json:"eventId"`
+	Name    string `json:"name"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+}
+
+type Attendance struct {
+	EventID       string `json:"eventId"`
+	CheckedIn     int    `json:"checkedIn"`
+	Capacity      int    `json:"capacity"`
+	LastCheckInAt int64  `json:"lastCheckInAt,omitempty"`
+}
+
+//Create creates an event that doesn't recur.
+func (c *Events) Create(ctx context.Context, event NewEvent) (Event, error) {
+	created := Event{}
+	err := c.Do(ctx, "POST", "/events", &event, &created)
+	return created, err
+}
+
+//CreateSeries creates a recurring event, with an occurrence for every start of its recurrence.
+func (c *Events) CreateSeries(ctx context.Context, event NewEvent) (Series, error) {
+	created := Series{}
+	err := c.Do(ctx, "POST", "/events", &event, &created)
+	return created, err
+}
+
+//Find returns the event with the hex encoded ID.
+func (c *Events) Find(ctx context.Context, id string) (Event, error) {
+	event := Event{}
+	err := c.Do(ctx, "GET", "/events/id/"+url.PathEscape(id), nil, &event)
+	return event, err
+}
+
+func (c *Events) FindByName(ctx context.Context, name string) (Event, error) {
+	event := Event{}
+	err := c.Do(ctx, "GET", "/events/name/"+url.PathEscape(name), nil, &event)
+	return event, err
+}
+
+func (c *Events) List(ctx context.Context) ([]Event, error) {
+	events := []Event{}
+	err := c.Do(ctx, "GET", "/events", nil, &events)
+	return events, err
+}
+
+func (c *Events) Update(ctx context.Context, id string, update EventUpdate) (Event, error) {
+	event := Event{}
+	err := c.Do(ctx, "PUT", "/events/"+url.PathEscape(id), &update, &event)
+	return event, err
+}
+
+//Cancel cancels an event. The bookings of the event are refunded in the background; see
+//Bookings.RefundJob for how far that got.
+func (c *Events) Cancel(ctx context.Context, id string, reason string) error {
+	body := struct {
+		Reason string `json:"reason"`
+	}{reason}
+	return c.Do(ctx, "POST", "/events/"+url.PathEscape(id)+"/cancel", &body, nil)
+}
+
+//Calendar returns the event as an iCalendar file.
+func (c *Events) Calendar(ctx context.Context, id string) ([]byte, error) {
+	return c.Get(ctx, "/events/id/"+url.PathEscape(id)+".ics")
+}
+
+func (c *Events) Attendance(ctx context.Context, id string) (Attendance, error) {
+	attendance := Attendance{}
+	err := c.Do(ctx, "GET", "/events/"+url.PathEscape(id)+"/attendance", nil, &attendance)
+	return attendance, err
+}
+
+func (c *Events) FindSeries(ctx context.Context, id string) (Series, error) {
+	series := Series{}
+	err := c.Do(ctx, "GET", "/events/series/"+url.PathEscape(id), nil, &series)
+	return series, err
+}
+
+//UpdateSeries changes every occurrence of a series that is still to come.
+func (c *Events) UpdateSeries(ctx context.Context, id string, update EventUpdate) (Series, error) {
+	series := Series{}
+	err := c.Do(ctx, "PUT", "/events/series/"+url.PathEscape(id), &update, &series)
+	return series, err
+}
+
+func (c *Events) PromoCodes(ctx context.Context, eventID string) ([]persistence.PromoCode, error) {
+	codes := []persistence.PromoCode{}
+	err := c.Do(ctx, "GET", "/events/"+url.PathEscape(eventID)+"/promocodes", nil, &codes)
+	return codes, err
+}
+
+func (c *Events) CreatePromoCode(ctx context.Context, eventID string, code persistence.PromoCode) (persistence.PromoCode, error) {
+	created := persistence.PromoCode{}
+	err := c.Do(ctx, "POST", "/events/"+url.PathEscape(eventID)+"/promocodes", &code, &created)
+	return created, err
+}
+
+func (c *Events) CreateLocation(ctx context.Context, location persistence.Location) (Location, error) {
+	created := Location{}
+	err := c.Do(ctx, "POST", "/locations", &location, &created)
+	return created, err
+}
+
+func (c *Events) FindLocation(ctx context.Context, id string) (Location, error) {
+	location := Location{}
+	err := c.Do(ctx, "GET", "/locations/"+url.PathEscape(id), nil, &location)
+	return location, err
+}
+
+func (c *Events) FreeBusy(ctx context.Context, locationID string, from time.Time, to time.Time) (FreeBusy, error) {
+	query := url.Values{}
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("to", strconv.FormatInt(to.Unix(), 10))
+	freeBusy := FreeBusy{}
+	err := c.Do(ctx, "GET", "/locations/"+url.PathEscape(locationID)+"/freebusy?"+query.Encode(), nil, &freeBusy)
+	return freeBusy, err
+}
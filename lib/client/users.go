@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//Users is a client of the users service.
+type Users struct {
+	*Client
+}
+
+//NewUsers returns a client of the users service at the base URL.
+func NewUsers(baseURL string) *Users {
+	return &Users{NewClient(baseURL)}
+}
+
+//NewUser is the body of a request to sign a user up.
+type NewUser struct {
+	First    string `json:"first"`
+	Last     string `json:"last"`
+	Age      int    `json:"age"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+func (c *Users) Create(ctx context.Context, user NewUser) (persistence.User, error) {
+	created := persistence.User{}
+	err := c.Do(ctx, "POST", "/users", &user, &created)
+	return created, err
+}
+
+//Find returns the user with the hex encoded ID.
+func (c *Users) Find(ctx context.Context, id string) (persistence.User, error) {
+	user := persistence.User{}
+	err := c.Do(ctx, "GET", "/users/id/"+url.PathEscape(id), nil, &user)
+	return user, err
+}
+
+func (c *Users) FindByName(ctx context.Context, username string) (persistence.User, error) {
+	user := persistence.User{}
+	err := c.Do(ctx, "GET", "/users/name/"+url.PathEscape(username), nil, &user)
+	return user, err
+}
+
+func (c *Users) List(ctx context.Context) ([]persistence.User, error) {
+	users := []persistence.User{}
+	err := c.Do(ctx, "GET", "/users", nil, &users)
+	return users, err
+}
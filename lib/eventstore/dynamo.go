@@ -0,0 +1,146 @@
+package eventstore
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+)
+
+//TABLE is the DynamoDB table of the event store. Its hash key is the string Stream and its range
+//key the number Sequence.
+const TABLE = "myevents-eventstore"
+
+const (
+	recordStream  = "EVENTS"
+	counterStream = "COUNTER"
+)
+
+//DynamoStore keeps every record in one partition, sorted by sequence number, so that they can be
+//loaded in order with a query. The next sequence number is taken from a counter item.
+type DynamoStore struct {
+	service *dynamodb.DynamoDB
+}
+
+type awsRecord struct {
+	Stream        string
+	Sequence      int64
+	EventName     string
+	SchemaVersion int
+	Payload       string
+	RecordedAt    int64
+}
+
+func NewDynamoStoreByRegion(region string) (Store, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &DynamoStore{service: dynamodb.New(sess)}, nil
+}
+
+func (d *DynamoStore) Append(event msgqueue.Event) (Record, error) {
+	result, err := d.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"Stream": {
+				S: aws.String(counterStream),
+			},
+			"Sequence": {
+				N: aws.String("0"),
+			},
+		},
+		UpdateExpression: aws.String("ADD LastSequence :one"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {
+				N: aws.String("1"),
+			},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueUpdatedNew),
+		TableName:    aws.String(TABLE),
+	})
+	if err != nil {
+		return Record{}, err
+	}
+	sequence, err := strconv.ParseInt(*result.Attributes["LastSequence"].N, 10, 64)
+	if err != nil {
+		return Record{}, err
+	}
+	record, err := newRecord(sequence, event)
+	if err != nil {
+		return record, err
+	}
+	av, err := dynamodbattribute.MarshalMap(awsRecord{
+		Stream:        recordStream,
+		Sequence:      record.Sequence,
+		EventName:     record.EventName,
+		SchemaVersion: record.SchemaVersion,
+		Payload:       record.Payload,
+		RecordedAt:    record.RecordedAt,
+	})
+	if err != nil {
+		return record, err
+	}
+	_, err = d.service.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(TABLE),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(#sequence)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#sequence": aws.String("Sequence"),
+		},
+	})
+	return record, err
+}
+
+func (d *DynamoStore) Load(after int64, limit int) ([]Record, error) {
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression: aws.String("#stream = :stream and #sequence > :after"),
+		ExpressionAttributeNames: map[string]*string{
+			"#stream":   aws.String("Stream"),
+			"#sequence": aws.String("Sequence"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":stream": {
+				S: aws.String(recordStream),
+			},
+			":after": {
+				N: aws.String(strconv.FormatInt(after, 10)),
+			},
+		},
+		Limit:            aws.Int64(int64(limit)),
+		ScanIndexForward: aws.Bool(true),
+		TableName:        aws.String(TABLE),
+	}
+	//A page ends early when it reaches a megabyte, so we keep reading until we have the whole batch.
+	records := []Record{}
+	var unmarshalErr error
+	err := d.service.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		awsrecords := []awsRecord{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsrecords)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, r := range awsrecords {
+			records = append(records, Record{
+				Sequence:      r.Sequence,
+				EventName:     r.EventName,
+				SchemaVersion: r.SchemaVersion,
+				Payload:       r.Payload,
+				RecordedAt:    r.RecordedAt,
+			})
+		}
+		return len(records) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return contiguous(after, records, time.Now()), unmarshalErr
+}
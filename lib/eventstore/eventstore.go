@@ -0,0 +1,137 @@
+//Package eventstore keeps every contract the services emit, in the order they were emitted. The
+//store is append-only: records are never changed or removed, so the projections the services build
+//from the contracts can be thrown away and rebuilt from the store at any time.
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/dblayer"
+)
+
+//A Record is a contract as it was emitted. Sequence numbers start at 1 and have no gaps, except
+//for appends that failed after their number was taken, see Load.
+type Record struct {
+	Sequence      int64  `bson:"_id" json:"sequence"`
+	EventName     string `json:"event"`
+	SchemaVersion int    `json:"schemaVersion,omitempty"` //0 for records made before there were versions
+	Payload       string `json:"payload"`                 //JSON encoded contract
+	RecordedAt    int64  `json:"recordedAt"`
+}
+
+type Store interface {
+	//Append records an event with the next sequence number.
+	Append(msgqueue.Event) (Record, error)
+	//Load returns up to limit records with a sequence number after the given one, in order. An
+	//append takes its number before it stores the record, so a record may show up after one with a
+	//higher number. Load stops at a gap in the numbers, unless the gap is older than gapTimeout and
+	//the append that left it must have failed, so that readers never skip a record that is still
+	//on its way.
+	Load(after int64, limit int) ([]Record, error)
+}
+
+//NewEventStore connects to the event store of the given database type. It takes the same config
+//map as the persistence layer.
+func NewEventStore(options dblayer.DBTYPE, config map[string]interface{}) (Store, error) {
+	switch options {
+	case dblayer.MONGODB:
+		connection, ok := config["connection"].(string)
+		if !ok {
+			return nil, errors.New("No connection string in config map")
+		}
+		return NewMongoStore(connection)
+	case dblayer.DYNAMODB:
+		region, ok := config["region"].(string)
+		if !ok {
+			return nil, errors.New("No region string in config map")
+		}
+		return NewDynamoStoreByRegion(region)
+	}
+	return nil, errors.New("unknown event store type " + string(options))
+}
+
+//gapTimeout is how long a gap in the sequence numbers may be waited for before it is skipped.
+const gapTimeout = time.Minute
+
+//contiguous returns the records, which follow the given sequence number in order, up to the first
+//gap in their numbers that is younger than gapTimeout.
+func contiguous(after int64, records []Record, now time.Time) []Record {
+	for i, record := range records {
+		if record.Sequence != after+1 && now.Sub(time.Unix(record.RecordedAt, 0)) < gapTimeout {
+			return records[:i]
+		}
+		after = record.Sequence
+	}
+	return records
+}
+
+func newRecord(sequence int64, event msgqueue.Event) (Record, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{
+		Sequence:      sequence,
+		EventName:     event.EventName(),
+		SchemaVersion: msgqueue.SchemaVersion(event),
+		Payload:       string(payload),
+		RecordedAt:    time.Now().Unix(),
+	}, nil
+}
+
+//RecordingEmitter records every event in the store before it passes it on to the message broker.
+type RecordingEmitter struct {
+	Store   Store
+	Emitter msgqueue.EventEmitter
+
+	ctx context.Context
+}
+
+//Emit records the event and emits it. An event that could not be recorded is emitted anyway, so
+//that an outage of the store doesn't stop the services from talking to each other. The store misses
+//such events, and so do projections rebuilt from it; they are counted in
+//metrics.EventStoreAppendsFailed.
+func (r *RecordingEmitter) Emit(event msgqueue.Event) error {
+	if _, err := r.Store.Append(event); err != nil {
+		metrics.EventStoreAppendsFailed.Inc(event.EventName())
+		slog.WarnContext(r.ctx, "could not record event in the event store", "event", event.EventName(), "error", err)
+	}
+	return r.Emitter.Emit(event)
+}
+
+//WithContext returns a recording emitter whose events are emitted as part of the trace the context
+//carries.
+func (r *RecordingEmitter) WithContext(ctx context.Context) msgqueue.EventEmitter {
+	return &RecordingEmitter{Store: r.Store, Emitter: msgqueue.WithContext(ctx, r.Emitter), ctx: ctx}
+}
+
+//Replay loads the records after the given sequence number in batches, maps them back to contracts
+//and passes them to handle in order. Records of an older schema version are upcast by the mapper.
+//It returns the sequence number of the last record it handled. Records of events the mapper
+//doesn't know are skipped.
+func Replay(store Store, mapper msgqueue.EventMapper, after int64, batch int, handle func(msgqueue.Event)) (int64, error) {
+	for {
+		records, err := store.Load(after, batch)
+		if err != nil {
+			return after, err
+		}
+		for _, record := range records {
+			event, err := mapper.DecodeEvent(record.EventName, msgqueue.ContentTypeJSON, record.SchemaVersion, []byte(record.Payload))
+			if err != nil {
+				slog.Warn("skipping record", "sequence", record.Sequence, "error", err)
+			} else {
+				handle(event)
+			}
+			after = record.Sequence
+		}
+		if len(records) < batch {
+			return after, nil
+		}
+	}
+}
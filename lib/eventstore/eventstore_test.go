@@ -0,0 +1,132 @@
+package eventstore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+)
+
+//memoryStore numbers records the same way the database stores do.
+type memoryStore struct {
+	records []Record
+}
+
+func (m *memoryStore) Append(event msgqueue.Event) (Record, error) {
+	record, err := newRecord(int64(len(m.records)+1), event)
+	if err == nil {
+		m.records = append(m.records, record)
+	}
+	return record, err
+}
+
+func (m *memoryStore) Load(after int64, limit int) ([]Record, error) {
+	records := []Record{}
+	for _, record := range m.records {
+		if record.Sequence > after && len(records) < limit {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+type nullEmitter struct {
+	emitted int
+}
+
+func (n *nullEmitter) Emit(e msgqueue.Event) error {
+	n.emitted++
+	return nil
+}
+
+func TestReplayReturnsEmittedEventsInOrder(t *testing.T) {
+	store := &memoryStore{}
+	broker := &nullEmitter{}
+	emitter := &RecordingEmitter{Store: store, Emitter: broker}
+	start := time.Date(2026, 5, 4, 17, 0, 0, 0, time.UTC)
+	emitter.Emit(&contracts.EventCreatedEvent{ID: "e1", Name: "Opera", Start: start, End: start.Add(3 * time.Hour)})
+	emitter.Emit(&contracts.EventUpdatedEvent{ID: "e1", Name: "Opera", Start: start.Add(time.Hour), Sequence: 1})
+	emitter.Emit(&contracts.EventBookedEvent{ID: "b1", EventID: "e1"})
+	if broker.emitted != 3 {
+		t.Fatalf("expected every event to reach the broker, got %d", broker.emitted)
+	}
+
+	//A batch of two makes the replay load the store twice.
+	replayed := []msgqueue.Event{}
+	last, err := Replay(store, msgqueue.NewEventMapper(), 0, 2, func(e msgqueue.Event) {
+		replayed = append(replayed, e)
+	})
+	if err != nil || last != 3 || len(replayed) != 3 {
+		t.Fatalf("expected three events up to record 3, got %d up to %d (%v)", len(replayed), last, err)
+	}
+	created, ok := replayed[0].(*contracts.EventCreatedEvent)
+	if !ok || !created.End.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("expected the created event first, got %#v", replayed[0])
+	}
+	if updated, ok := replayed[1].(*contracts.EventUpdatedEvent); !ok || updated.Sequence != 1 {
+		t.Errorf("expected the update second, got %#v", replayed[1])
+	}
+
+	//Replaying from a record on only returns what came after it.
+	replayed = replayed[:0]
+	Replay(store, msgqueue.NewEventMapper(), 2, 10, func(e msgqueue.Event) {
+		replayed = append(replayed, e)
+	})
+	if len(replayed) != 1 || replayed[0].EventName() != "event.booked" {
+		t.Errorf("expected only the booking after record 2, got %v", replayed)
+	}
+}
+
+//TestLoadStopsAtGaps loads records whose numbers have gaps, like appends that are still on their way
+//or that failed leave them.
+func TestLoadStopsAtGaps(t *testing.T) {
+	now := time.Now()
+	fresh, old := now.Unix(), now.Add(-2*gapTimeout).Unix()
+	cases := []struct {
+		name      string
+		after     int64
+		sequences []int64
+		recorded  int64
+		loaded    int
+	}{
+		{"no gap", 0, []int64{1, 2, 3}, fresh, 3},
+		{"append on its way", 0, []int64{1, 3, 4}, fresh, 1},
+		{"append on its way right after", 1, []int64{3, 4}, fresh, 0},
+		{"failed append", 0, []int64{1, 3, 4}, old, 3},
+	}
+	for _, c := range cases {
+		records := []Record{}
+		for _, sequence := range c.sequences {
+			records = append(records, Record{Sequence: sequence, RecordedAt: c.recorded})
+		}
+		if loaded := contiguous(c.after, records, now); len(loaded) != c.loaded {
+			t.Errorf("%s: expected %d records, got %+v", c.name, c.loaded, loaded)
+		}
+	}
+}
+
+type unavailableStore struct {
+	memoryStore
+}
+
+func (unavailableStore) Append(event msgqueue.Event) (Record, error) {
+	return Record{}, errors.New("store unavailable")
+}
+
+func TestUnrecordedEventsAreCounted(t *testing.T) {
+	broker := &nullEmitter{}
+	emitter := &RecordingEmitter{Store: &unavailableStore{}, Emitter: broker}
+	before := metrics.EventStoreAppendsFailed.Value("event.booked")
+	if err := emitter.Emit(&contracts.EventBookedEvent{ID: "b1", EventID: "e1"}); err != nil {
+		t.Fatal(err)
+	}
+	if broker.emitted != 1 {
+		t.Errorf("expected the event to reach the broker, got %d", broker.emitted)
+	}
+	if n := metrics.EventStoreAppendsFailed.Value("event.booked") - before; n != 1 {
+		t.Errorf("expected the event to be counted as not recorded, got %v", n)
+	}
+}
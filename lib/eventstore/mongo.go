@@ -0,0 +1,59 @@
+package eventstore
+
+import (
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	DB       = "myevents"
+	RECORDS  = "eventstore"
+	COUNTERS = "counters"
+)
+
+//MongoStore keeps the records in a collection of their own, with the sequence number as their ID.
+//The next sequence number is taken from a counter document, which every replica increments
+//atomically.
+type MongoStore struct {
+	session *mgo.Session
+}
+
+func NewMongoStore(connection string) (Store, error) {
+	s, err := mgo.Dial(connection)
+	if err != nil {
+		return nil, err
+	}
+	return &MongoStore{session: s}, nil
+}
+
+func (m *MongoStore) Append(event msgqueue.Event) (Record, error) {
+	s := m.session.Copy()
+	defer s.Close()
+	counter := struct {
+		Sequence int64 `bson:"sequence"`
+	}{}
+	_, err := s.DB(DB).C(COUNTERS).FindId(RECORDS).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"sequence": 1}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &counter)
+	if err != nil {
+		return Record{}, err
+	}
+	record, err := newRecord(counter.Sequence, event)
+	if err != nil {
+		return record, err
+	}
+	return record, s.DB(DB).C(RECORDS).Insert(record)
+}
+
+func (m *MongoStore) Load(after int64, limit int) ([]Record, error) {
+	s := m.session.Copy()
+	defer s.Close()
+	records := []Record{}
+	err := s.DB(DB).C(RECORDS).Find(bson.M{"_id": bson.M{"$gt": after}}).Sort("_id").Limit(limit).All(&records)
+	return contiguous(after, records, time.Now()), err
+}
@@ -0,0 +1,226 @@
+//Package health tells an orchestrator whether a service is alive and whether it is ready to take
+//requests. Liveness only covers what a restart would fix, like a goroutine the service can't work
+//without that stopped. Readiness also covers the dependencies of the service, like its database
+//and message broker, and turns false while the service shuts down, so that it gets no new requests
+//while the ones in flight are finished.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//A Check returns an error if what it checks is not healthy.
+type Check func() error
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+//CheckTimeout is how long a check may take before it counts as failed.
+const CheckTimeout = 2 * time.Second
+
+//shutdownTimeout is how long the requests in flight get to finish when the service shuts down.
+const shutdownTimeout = 15 * time.Second
+
+var ErrShuttingDown = errors.New("service is shutting down")
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+//A Checker runs the checks of a service for its /healthz and /readyz routes.
+type Checker struct {
+	//DrainDelay is how long the service keeps serving after it turned not ready while it shuts
+	//down, so that the orchestrator notices and stops sending requests.
+	DrainDelay time.Duration
+
+	mu        sync.Mutex
+	liveness  []namedCheck
+	readiness []namedCheck
+
+	shuttingDown int32
+	stopped      chan struct{}
+}
+
+func NewChecker() *Checker {
+	return &Checker{stopped: make(chan struct{})}
+}
+
+//AddLiveness adds a check that decides whether the service is alive. Every liveness check is a
+//readiness check as well.
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness = append(c.liveness, namedCheck{name: name, check: check})
+}
+
+//AddReadiness adds a check that decides whether the service is ready to take requests.
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness = append(c.readiness, namedCheck{name: name, check: check})
+}
+
+//Go runs a function the service can't work without, like the loop that processes the events of
+//its listener, in a goroutine. Once the function returned, the service is no longer alive.
+func (c *Checker) Go(name string, run func() error) {
+	var result atomic.Value
+	c.AddLiveness(name, func() error {
+		if err, ok := result.Load().(error); ok {
+			return err
+		}
+		return nil
+	})
+	go func() {
+		err := run()
+		if err == nil {
+			err = fmt.Errorf("%s stopped", name)
+		} else {
+			err = fmt.Errorf("%s stopped: %s", name, err)
+		}
+		slog.Error("service is no longer alive", "error", err)
+		result.Store(err)
+	}()
+}
+
+//Result is the result of a single check.
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+//Report is the body of the /healthz and /readyz responses.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+//Live runs the liveness checks.
+func (c *Checker) Live() Report {
+	c.mu.Lock()
+	checks := append([]namedCheck{}, c.liveness...)
+	c.mu.Unlock()
+	return run(checks)
+}
+
+//Ready runs the liveness and readiness checks. A service that shuts down is not ready.
+func (c *Checker) Ready() Report {
+	c.mu.Lock()
+	checks := append(append([]namedCheck{}, c.liveness...), c.readiness...)
+	c.mu.Unlock()
+	checks = append(checks, namedCheck{name: "shutdown", check: func() error {
+		if atomic.LoadInt32(&c.shuttingDown) == 1 {
+			return ErrShuttingDown
+		}
+		return nil
+	}})
+	return run(checks)
+}
+
+//run runs the checks in parallel and reports them in the order they were added.
+func run(checks []namedCheck) Report {
+	report := Report{Status: StatusOK, Checks: make([]Result, len(checks))}
+	wg := sync.WaitGroup{}
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check namedCheck) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(check)
+		}(i, check)
+	}
+	wg.Wait()
+	for _, result := range report.Checks {
+		if result.Status != StatusOK {
+			report.Status = StatusUnavailable
+		}
+	}
+	return report
+}
+
+func runCheck(check namedCheck) Result {
+	result := Result{Name: check.name, Status: StatusOK}
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() { done <- check.check() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(CheckTimeout):
+		err = fmt.Errorf("timed out after %s", CheckTimeout)
+	}
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		result.Status = StatusUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
+
+//LivenessHandler serves the /healthz route.
+func (c *Checker) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, c.Live())
+}
+
+//ReadinessHandler serves the /readyz route.
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, c.Ready())
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json;charset=utf8")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(&report)
+}
+
+//ShutdownOnSignal waits for SIGINT or SIGTERM and then shuts the service down gracefully, see
+//Shutdown.
+func (c *Checker) ShutdownOnSignal(servers ...*http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	slog.Info("shutting down", "signal", sig.String())
+	c.Shutdown(servers...)
+}
+
+//Shutdown turns the service not ready, waits for the drain delay and shuts the servers down, which
+//lets the requests in flight finish. Stopped is closed once the servers are shut down.
+func (c *Checker) Shutdown(servers ...*http.Server) {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+	time.Sleep(c.DrainDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	wg := sync.WaitGroup{}
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Error("could not shut down gracefully", "addr", server.Addr, "error", err)
+			}
+		}(server)
+	}
+	wg.Wait()
+	close(c.stopped)
+}
+
+//Stopped is closed once the servers were shut down.
+func (c *Checker) Stopped() <-chan struct{} {
+	return c.stopped
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func report(t *testing.T, handler http.HandlerFunc) (int, Report) {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/", nil))
+	r := Report{}
+	if err := json.NewDecoder(recorder.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, r
+}
+
+func TestReadiness(t *testing.T) {
+	c := NewChecker()
+	brokerDown := errors.New("connection to the broker is closed, reconnecting")
+	var broker error
+	c.AddReadiness("database", func() error { return nil })
+	c.AddReadiness("broker", func() error { return broker })
+
+	code, r := report(t, c.ReadinessHandler)
+	if code != http.StatusOK || r.Status != StatusOK || len(r.Checks) != 3 {
+		t.Fatalf("expected a ready service, got %d %+v", code, r)
+	}
+
+	broker = brokerDown
+	code, r = report(t, c.ReadinessHandler)
+	if code != http.StatusServiceUnavailable || r.Status != StatusUnavailable {
+		t.Fatalf("expected the service not to be ready, got %d %+v", code, r)
+	}
+	if r.Checks[0].Status != StatusOK || r.Checks[1].Status != StatusUnavailable || r.Checks[1].Error != brokerDown.Error() {
+		t.Errorf("expected the broker check to fail on its own, got %+v", r.Checks)
+	}
+	//The dependencies of a service don't decide whether it is alive.
+	if code, _ := report(t, c.LivenessHandler); code != http.StatusOK {
+		t.Errorf("expected the service to be alive, got %d", code)
+	}
+}
+
+func TestGo(t *testing.T) {
+	c := NewChecker()
+	stop := make(chan struct{})
+	c.Go("listener", func() error {
+		<-stop
+		return errors.New("channel closed")
+	})
+	if r := c.Live(); r.Status != StatusOK {
+		t.Fatalf("expected the service to be alive, got %+v", r)
+	}
+	close(stop)
+	deadline := time.Now().Add(time.Second)
+	for c.Live().Status == StatusOK && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	r := c.Live()
+	if r.Status != StatusUnavailable || r.Checks[0].Error != "listener stopped: channel closed" {
+		t.Errorf("expected the service to be dead once its listener stopped, got %+v", r)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	c := NewChecker()
+	c.DrainDelay = 50 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(c.ReadinessHandler))
+	defer server.Close()
+
+	go c.Shutdown(server.Config)
+	time.Sleep(10 * time.Millisecond)
+	//While the service drains, it still answers, but it is no longer ready.
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the service not to be ready while it shuts down, got %d", resp.StatusCode)
+	}
+	select {
+	case <-c.Stopped():
+	case <-time.After(time.Second):
+		t.Fatal("expected the server to be shut down")
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the check timeout")
+	}
+	c := NewChecker()
+	c.AddReadiness("database", func() error {
+		time.Sleep(CheckTimeout + time.Second)
+		return nil
+	})
+	if r := c.Ready(); r.Status != StatusUnavailable || r.Checks[0].LatencyMs < float64(CheckTimeout/time.Millisecond) {
+		t.Errorf("expected a check that takes too long to fail, got %+v", r)
+	}
+}
@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+//MaxBodyBytes is the largest request body the services read.
+const MaxBodyBytes = 1 << 20
+
+//Decode decodes the JSON body of a request into v and validates it, see Validate. Bodies with fields
+//v doesn't have, and bodies larger than MaxBodyBytes, are refused. The error is always a problem:
+//415 for bodies that are not JSON, 413 for bodies that are too large, 400 for bodies that can't be
+//decoded and 422 for bodies that are not valid.
+func Decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	//Browsers send fetch bodies that are strings as text/plain, which spares them a CORS preflight,
+	//so the web client's JSON arrives as text.
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/json" && mediaType != "text/plain") {
+			return Problemf(http.StatusUnsupportedMediaType, "request body has to be application/json, not %s", contentType)
+		}
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return decodeProblem(err)
+	}
+	//A body holds one value; anything after it is most likely a mistake of the client.
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		if err == nil {
+			return NewProblem(http.StatusBadRequest, "request body must hold a single JSON value")
+		}
+		return decodeProblem(err)
+	}
+	return Validate(v)
+}
+
+//ReadBody reads a request body that is not decoded as JSON, like the payload of a signed webhook,
+//up to MaxBodyBytes.
+func ReadBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	if err != nil {
+		return nil, decodeProblem(err)
+	}
+	return body, nil
+}
+
+const unknownField = "json: unknown field "
+
+func decodeProblem(err error) *Problem {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return Problemf(http.StatusRequestEntityTooLarge, "request body must not be larger than %d bytes", MaxBodyBytes)
+	case err == io.EOF:
+		return NewProblem(http.StatusBadRequest, "request body must not be empty")
+	case err == io.ErrUnexpectedEOF:
+		return NewProblem(http.StatusBadRequest, "request body is not complete JSON")
+	case errors.As(err, &syntaxErr):
+		return Problemf(http.StatusBadRequest, "request body is not valid JSON at byte %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return Problemf(http.StatusBadRequest, "request body has to be a JSON %s", typeErr.Type)
+		}
+		p := NewProblem(http.StatusBadRequest, "request body has fields of the wrong type")
+		p.Errors = []FieldError{{Field: typeErr.Field, Message: fmt.Sprintf("has to be a %s", typeErr.Type)}}
+		return p
+	case strings.HasPrefix(err.Error(), unknownField):
+		//The decoder names unknown fields only in its message.
+		p := NewProblem(http.StatusBadRequest, "request body has fields that are not known")
+		p.Errors = []FieldError{{Field: strings.Trim(strings.TrimPrefix(err.Error(), unknownField), `"`), Message: "is not known"}}
+		return p
+	}
+	return Problemf(http.StatusBadRequest, "request body could not be decoded: %s", err)
+}
@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ticketType struct {
+	Name     string `json:"name" validate:"required"`
+	Price    int64  `json:"price" validate:"min=0"`
+	Currency string `json:"currency" validate:"required,format=currency"`
+}
+
+type eventRequest struct {
+	Name        string       `json:"name" validate:"required,max=10"`
+	Kind        string       `json:"kind" validate:"oneof=concert talk"`
+	Email       string       `json:"email" validate:"format=email"`
+	TicketTypes []ticketType `json:"ticketTypes" validate:"max=2"`
+}
+
+func decode(body string, contentType string) (eventRequest, error) {
+	r := httptest.NewRequest("POST", "/events", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	request := eventRequest{}
+	err := Decode(httptest.NewRecorder(), r, &request)
+	return request, err
+}
+
+func status(t *testing.T, err error) int {
+	p, ok := err.(*Problem)
+	if !ok {
+		t.Fatalf("expected a problem, got %v", err)
+	}
+	return p.Status
+}
+
+func TestDecode(t *testing.T) {
+	request, err := decode(`{"name": "Opera", "kind": "concert", "ticketTypes": [{"name": "VIP", "price": 5000, "currency": "EUR"}]}`, "application/json")
+	if err != nil || request.Name != "Opera" || len(request.TicketTypes) != 1 {
+		t.Fatalf("expected the body to be decoded, got %+v and %v", request, err)
+	}
+
+	for _, c := range []struct {
+		name        string
+		body        string
+		contentType string
+		status      int
+	}{
+		{"empty", ``, "", http.StatusBadRequest},
+		{"syntax", `{"name": }`, "", http.StatusBadRequest},
+		{"incomplete", `{"name": "Opera"`, "", http.StatusBadRequest},
+		{"trailing", `{"name": "Opera"} {}`, "", http.StatusBadRequest},
+		{"unknown field", `{"name": "Opera", "venue": "Main Street 1"}`, "", http.StatusBadRequest},
+		{"wrong type", `{"name": 42}`, "", http.StatusBadRequest},
+		{"too large", `{"name": "` + strings.Repeat("a", MaxBodyBytes) + `"}`, "", http.StatusRequestEntityTooLarge},
+		{"not JSON", `name=Opera`, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"not valid", `{"kind": "party"}`, "", http.StatusUnprocessableEntity},
+	} {
+		_, err := decode(c.body, c.contentType)
+		if code := status(t, err); code != c.status {
+			t.Errorf("%s: expected %d, got %d (%v)", c.name, c.status, code, err)
+		}
+	}
+
+	_, err = decode(`{"name": "Opera", "venue": "Main Street 1"}`, "")
+	if p := err.(*Problem); len(p.Errors) != 1 || p.Errors[0].Field != "venue" {
+		t.Errorf("expected the unknown field to be named, got %+v", p.Errors)
+	}
+	//The web client sends its JSON as text.
+	if _, err := decode(`{"name": "Opera"}`, "text/plain;charset=UTF-8"); err != nil {
+		t.Errorf("expected text/plain bodies to be decoded, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	err := Validate(&eventRequest{
+		Name:  "Coldplay in concert",
+		Kind:  "party",
+		Email: "jane.doe",
+		TicketTypes: []ticketType{
+			{Name: "VIP", Price: 5000, Currency: "EUR"},
+			{Price: -1, Currency: "euro"},
+		},
+	})
+	p, ok := err.(*Problem)
+	if !ok || p.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a 422 problem, got %v", err)
+	}
+	expected := map[string]string{
+		"name":                    "has to be at most 10 characters long",
+		"kind":                    "has to be one of concert, talk",
+		"email":                   "has to be a valid email",
+		"ticketTypes[1].name":     "is required",
+		"ticketTypes[1].price":    "has to be at least 0",
+		"ticketTypes[1].currency": "has to be a valid currency",
+	}
+	if len(p.Errors) != len(expected) {
+		t.Errorf("expected %d field errors, got %+v", len(expected), p.Errors)
+	}
+	for _, e := range p.Errors {
+		if expected[e.Field] != e.Message {
+			t.Errorf("expected %s to %s, got %q", e.Field, expected[e.Field], e.Message)
+		}
+	}
+
+	//Rules other than required don't apply to fields that are left out.
+	if err := Validate(&eventRequest{Name: "Opera"}); err != nil {
+		t.Errorf("expected optional fields to be skipped, got %v", err)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	r := httptest.NewRequest("GET", "/events/id/5a5f5c", nil)
+	recorder := httptest.NewRecorder()
+	WriteError(recorder, r, NewProblem(http.StatusNotFound, "no event with id 5a5f5c"))
+	if recorder.Code != http.StatusNotFound || recorder.Header().Get("Content-Type") != ContentTypeProblem {
+		t.Fatalf("expected a 404 problem, got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	p := Problem{}
+	if err := json.NewDecoder(recorder.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "Not Found" || p.Detail != "no event with id 5a5f5c" || p.Instance != "/events/id/5a5f5c" {
+		t.Errorf("expected the problem to describe the request, got %+v", p)
+	}
+
+	//Errors that are not problems don't make it to the client.
+	recorder = httptest.NewRecorder()
+	WriteError(recorder, r, errors.New("dial tcp 10.0.0.7:27017: connection refused"))
+	p = Problem{}
+	json.NewDecoder(recorder.Body).Decode(&p)
+	if recorder.Code != http.StatusInternalServerError || strings.Contains(p.Detail, "10.0.0.7") {
+		t.Errorf("expected a generic 500, got %d %+v", recorder.Code, p)
+	}
+}
@@ -0,0 +1,126 @@
+//Package httpapi holds what the REST APIs of the services share: errors are answered with RFC 7807
+//problem details, request bodies are decoded strictly and validated against the rules of their
+//structs, see Decode and Validate.
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+//Content types of the responses.
+const (
+	ContentTypeJSON    = "application/json;charset=utf8"
+	ContentTypeProblem = "application/problem+json"
+)
+
+//Problem is the body of an error response, see RFC 7807. A problem is also an error, so that
+//functions that check a request can hand it to the handler, which writes it with WriteError.
+type Problem struct {
+	//Type is a URI that names the kind of problem; about:blank means that the status says it all.
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	//Errors lists the fields of a request body that failed validation.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+//FieldError says why a field of a request body is not valid. The field is named by its JSON path,
+//like ticketTypes[0].price.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+//NewProblem returns a problem that is described by its status and the detail.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+//Problemf returns a problem with a formatted detail.
+func Problemf(status int, format string, args ...interface{}) *Problem {
+	return NewProblem(status, fmt.Sprintf(format, args...))
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Detail
+}
+
+//Bind returns a copy of the problem that refers to the path of the request.
+func (p *Problem) Bind(r *http.Request) *Problem {
+	bound := *p
+	if bound.Instance == "" && r != nil {
+		bound.Instance = r.URL.Path
+	}
+	return &bound
+}
+
+//Write answers a request with a problem. The problem refers to the path of the request.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	p = p.Bind(r)
+	WriteBody(w, p.Status, p)
+}
+
+//WriteBody answers with a problem that has members of its own next to the standard ones, see
+//section 3.2 of RFC 7807. The body is a struct that embeds the bound problem, see Bind.
+func WriteBody(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+//Error answers a request with a problem that is described by its status and the detail.
+func Error(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	Write(w, r, NewProblem(status, detail))
+}
+
+//Errorf answers a request with a problem with a formatted detail.
+func Errorf(w http.ResponseWriter, r *http.Request, status int, format string, args ...interface{}) {
+	Write(w, r, Problemf(status, format, args...))
+}
+
+//WriteError answers a request with the problem an error is. Any other error is internal, see
+//InternalError.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	if p, ok := err.(*Problem); ok {
+		Write(w, r, p)
+		return
+	}
+	InternalError(w, r, err)
+}
+
+//InternalError answers a request that failed for reasons of the service with 500. The error is
+//logged rather than sent, since it may tell more about the service than its clients should know.
+func InternalError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	Error(w, r, http.StatusInternalServerError, "the request could not be handled")
+}
+
+//NotFound answers requests for routes that don't exist.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Errorf(w, r, http.StatusNotFound, "no route for %s", r.URL.Path)
+}
+
+//MethodNotAllowed answers requests for routes that exist with another method.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Errorf(w, r, http.StatusMethodNotAllowed, "%s is not allowed on %s", r.Method, r.URL.Path)
+}
+
+//JSON answers a request with a JSON body and the given status.
+func JSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,219 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//Validate checks a request struct against the rules in the validate tags of its fields, and returns
+//a 422 problem that lists every field that broke one. Rules are separated by commas:
+//
+//   - required: the field has to be set, that is not be zero, empty or null,
+//   - min=N, max=N: bounds of numbers, and of the length of strings, slices and maps,
+//   - oneof=a b c: the value has to be one of the listed ones,
+//   - format=F: strings have to be in the format F, see formats.
+//
+//Apart from required, rules only apply to fields that are set, so optional fields can have rules as
+//well. Structs, and slices of structs, are validated field by field.
+func Validate(v interface{}) error {
+	var errs []FieldError
+	validateValue(reflect.ValueOf(v), "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	p := NewProblem(http.StatusUnprocessableEntity, "request body is not valid")
+	p.Errors = errs
+	return p
+}
+
+var (
+	hexPattern      = regexp.MustCompile(`^([0-9a-fA-F]{2})+$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	datePattern     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+//formats are the formats of the format rule.
+var formats = map[string]func(string) bool{
+	"email": func(s string) bool {
+		address, err := mail.ParseAddress(s)
+		return err == nil && address.Address == s
+	},
+	"hex":      hexPattern.MatchString,
+	"currency": currencyPattern.MatchString,
+	"date": func(s string) bool {
+		_, err := time.Parse("2006-01-02", s)
+		return datePattern.MatchString(s) && err == nil
+	},
+	"url": func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	},
+	"timezone": func(s string) bool {
+		_, err := time.LoadLocation(s)
+		return err == nil
+	},
+}
+
+func validateValue(v reflect.Value, path string, errs *[]FieldError) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name, ok := jsonName(field)
+			if !ok {
+				continue
+			}
+			//Fields of embedded structs are fields of the struct that embeds them in JSON.
+			fieldPath := path
+			if !field.Anonymous {
+				fieldPath = join(path, name)
+			}
+			value := v.Field(i)
+			if tag := field.Tag.Get("validate"); tag != "" {
+				if !checkRules(value, tag, fieldPath, errs) {
+					continue
+				}
+			}
+			validateValue(value, fieldPath, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+//checkRules checks the rules of a field and reports whether it broke none.
+func checkRules(v reflect.Value, tag string, path string, errs *[]FieldError) bool {
+	rules := strings.Split(tag, ",")
+	for _, rule := range rules {
+		if rule == "required" && isZero(v) {
+			*errs = append(*errs, FieldError{Field: path, Message: "is required"})
+			return false
+		}
+	}
+	if isZero(v) {
+		return true
+	}
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	for _, rule := range rules {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		if message := check(v, name, arg); message != "" {
+			*errs = append(*errs, FieldError{Field: path, Message: message})
+			return false
+		}
+	}
+	return true
+}
+
+//check checks a single rule and returns why the value broke it, or "".
+func check(v reflect.Value, rule string, arg string) string {
+	switch rule {
+	case "required", "":
+		return ""
+	case "min", "max":
+		bound, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("httpapi: bound of %s is not a number: %s", rule, arg))
+		}
+		size, format, ok := measure(v)
+		if !ok {
+			panic(fmt.Sprintf("httpapi: %s does not apply to %s", rule, v.Kind()))
+		}
+		if rule == "min" && size < bound {
+			return fmt.Sprintf(format, "at least", arg)
+		}
+		if rule == "max" && size > bound {
+			return fmt.Sprintf(format, "at most", arg)
+		}
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, option := range strings.Fields(arg) {
+			if s == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("has to be one of %s", strings.Join(strings.Fields(arg), ", "))
+	case "format":
+		valid, ok := formats[arg]
+		if !ok || v.Kind() != reflect.String {
+			panic(fmt.Sprintf("httpapi: unknown format %s for %s", arg, v.Kind()))
+		}
+		if !valid(v.String()) {
+			return fmt.Sprintf("has to be a valid %s", arg)
+		}
+	default:
+		panic(fmt.Sprintf("httpapi: unknown validation rule %s", rule))
+	}
+	return ""
+}
+
+//measure returns what min and max compare, the value of numbers and the length of everything else,
+//and the message for values out of bounds.
+func measure(v reflect.Value) (float64, string, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "has to be %s %s", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), "has to be %s %s", true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), "has to be %s %s", true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), "has to be %s %s characters long", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), "has to have %s %s items", true
+	}
+	return 0, "", false
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	}
+	return v.IsZero()
+}
+
+//jsonName returns the name of a field in JSON, and whether it is part of the JSON at all.
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
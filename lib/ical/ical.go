@@ -0,0 +1,217 @@
+//Package ical writes calendars in the iCalendar format of RFC 5545, which calendar apps use both to
+//import single events and to subscribe to feeds. Times in a time zone other than UTC are written
+//with a TZID, together with a VTIMEZONE that describes the zone, so that calendar apps show events
+//at the right local time no matter what zone database they use.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//maxLineLength is the number of octets after which content lines are folded.
+const maxLineLength = 75
+
+type Calendar struct {
+	ProdID string //identifies the product that created the calendar
+	Name   string //shown by calendar apps for subscribed feeds
+	Events []Event
+}
+
+type Event struct {
+	UID         string //stays the same for the lifetime of the event
+	Sequence    int    //has to grow every time the event changes, so that calendar apps update it
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Start       time.Time //written in the time zone the time is in
+	End         time.Time
+	Cancelled   bool
+	Stamp       time.Time //when the calendar entry was created, defaults to now
+}
+
+//Encode writes the calendar in iCalendar format.
+func (c Calendar) Encode() []byte {
+	w := &writer{}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", c.ProdID)
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		w.line("X-WR-CALNAME", escape(c.Name))
+	}
+	for _, zone := range c.zones() {
+		w.timezone(zone.location, zone.from, zone.to)
+	}
+
+	now := time.Now()
+	for _, e := range c.Events {
+		stamp := e.Stamp
+		if stamp.IsZero() {
+			stamp = now
+		}
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", e.UID)
+		w.line("DTSTAMP", stamp.UTC().Format("20060102T150405Z"))
+		w.time("DTSTART", e.Start)
+		w.time("DTEND", e.End)
+		w.line("SEQUENCE", fmt.Sprint(e.Sequence))
+		w.line("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			w.line("DESCRIPTION", escape(e.Description))
+		}
+		if e.Location != "" {
+			w.line("LOCATION", escape(e.Location))
+		}
+		if e.URL != "" {
+			w.line("URL", e.URL)
+		}
+		if e.Cancelled {
+			w.line("STATUS", "CANCELLED")
+		} else {
+			w.line("STATUS", "CONFIRMED")
+		}
+		w.line("END", "VEVENT")
+	}
+	w.line("END", "VCALENDAR")
+	return w.Bytes()
+}
+
+type zoneRange struct {
+	location *time.Location
+	from, to time.Time
+}
+
+//zones returns the time zones the events of the calendar use, with the time span they are used in.
+func (c Calendar) zones() []zoneRange {
+	ranges := map[string]*zoneRange{}
+	for _, e := range c.Events {
+		for _, t := range []time.Time{e.Start, e.End} {
+			if t.Location() == time.UTC {
+				continue
+			}
+			name := t.Location().String()
+			if ranges[name] == nil {
+				ranges[name] = &zoneRange{t.Location(), t, t}
+			}
+			if t.Before(ranges[name].from) {
+				ranges[name].from = t
+			}
+			if t.After(ranges[name].to) {
+				ranges[name].to = t
+			}
+		}
+	}
+	names := []string{}
+	for name := range ranges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	zones := []zoneRange{}
+	for _, name := range names {
+		zones = append(zones, *ranges[name])
+	}
+	return zones
+}
+
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) line(name string, value string) {
+	w.fold(name + ":" + value)
+}
+
+//fold writes a content line, folded so that no line is longer than 75 octets.
+func (w *writer) fold(content string) {
+	length := 0
+	for _, r := range content {
+		size := utf8.RuneLen(r)
+		if length+size > maxLineLength {
+			w.WriteString("\r\n ")
+			length = 1
+		}
+		w.WriteRune(r)
+		length += size
+	}
+	w.WriteString("\r\n")
+}
+
+//time writes a date-time property, in UTC or with the TZID of its time zone.
+func (w *writer) time(name string, t time.Time) {
+	if t.Location() == time.UTC {
+		w.line(name, t.Format("20060102T150405Z"))
+		return
+	}
+	w.fold(name + ";TZID=" + t.Location().String() + ":" + t.Format("20060102T150405"))
+}
+
+//timezone writes the VTIMEZONE of a zone. It lists the offset in effect at the start of the span,
+//and every change of the offset during the span.
+func (w *writer) timezone(zone *time.Location, from time.Time, to time.Time) {
+	from = from.In(zone)
+	w.line("BEGIN", "VTIMEZONE")
+	w.line("TZID", zone.String())
+
+	name, offset := from.Zone()
+	w.observance(from.IsDST(), from, name, offset, offset)
+	for t := from; t.Before(to); {
+		next := t.Add(24 * time.Hour)
+		if _, nextOffset := next.Zone(); nextOffset != offset {
+			//The offset changed some time during the day, we look for the exact second.
+			low, high := t.Unix(), next.Unix()
+			for low < high {
+				mid := low + (high-low)/2
+				if _, midOffset := time.Unix(mid, 0).In(zone).Zone(); midOffset == offset {
+					low = mid + 1
+				} else {
+					high = mid
+				}
+			}
+			transition := time.Unix(low, 0).In(zone)
+			newName, newOffset := transition.Zone()
+			w.observance(transition.IsDST(), transition, newName, offset, newOffset)
+			offset = newOffset
+		}
+		t = next
+	}
+	w.line("END", "VTIMEZONE")
+}
+
+//observance writes a STANDARD or DAYLIGHT component. Its start is given in the local time of the
+//offset that was in effect before it.
+func (w *writer) observance(dst bool, start time.Time, name string, offsetFrom int, offsetTo int) {
+	kind := "STANDARD"
+	if dst {
+		kind = "DAYLIGHT"
+	}
+	w.line("BEGIN", kind)
+	w.line("DTSTART", start.UTC().Add(time.Duration(offsetFrom)*time.Second).Format("20060102T150405"))
+	w.line("TZOFFSETFROM", formatOffset(offsetFrom))
+	w.line("TZOFFSETTO", formatOffset(offsetTo))
+	w.line("TZNAME", escape(name))
+	w.line("END", kind)
+}
+
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	if offset%60 != 0 {
+		return fmt.Sprintf("%s%02d%02d%02d", sign, offset/3600, offset/60%60, offset%60)
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset/60%60)
+}
+
+//escape escapes the characters that have a meaning in TEXT values.
+func escape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
@@ -0,0 +1,87 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	start := time.Date(2026, 5, 4, 17, 0, 0, 0, time.UTC)
+	calendar := Calendar{
+		ProdID: "-//MyEvents//EN",
+		Events: []Event{{
+			UID:      "5d1f@myevents",
+			Sequence: 2,
+			Summary:  "Jazz, Blues; and more",
+			Location: "Opera House\nMain Street 1",
+			Start:    start,
+			End:      start.Add(2 * time.Hour),
+			Stamp:    start,
+		}},
+	}
+	ics := string(calendar.Encode())
+
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:5d1f@myevents\r\n",
+		"DTSTART:20260504T170000Z\r\n",
+		"DTEND:20260504T190000Z\r\n",
+		"SEQUENCE:2\r\n",
+		`SUMMARY:Jazz\, Blues\; and more` + "\r\n",
+		`LOCATION:Opera House\nMain Street 1` + "\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, line) {
+			t.Errorf("expected %q in\n%s", line, ics)
+		}
+	}
+	if strings.Contains(ics, "VTIMEZONE") {
+		t.Error("expected no VTIMEZONE for times in UTC")
+	}
+}
+
+func TestFolding(t *testing.T) {
+	w := &writer{}
+	w.line("DESCRIPTION", strings.Repeat("ä", 60))
+	lines := strings.Split(strings.TrimSuffix(w.String(), "\r\n"), "\r\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected the line to be folded once, got %q", lines)
+	}
+	for _, line := range lines {
+		if len(line) > maxLineLength {
+			t.Errorf("line of %d octets is too long", len(line))
+		}
+	}
+	if !strings.HasPrefix(lines[1], " ") {
+		t.Error("expected the continuation line to start with a space")
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	start := time.Date(2026, 3, 22, 19, 0, 0, 0, berlin)
+	calendar := Calendar{ProdID: "-//MyEvents//EN", Events: []Event{
+		{UID: "1", Start: start, End: start.Add(time.Hour)},
+		{UID: "2", Start: start.AddDate(0, 0, 7), End: start.AddDate(0, 0, 7).Add(time.Hour)},
+	}}
+	ics := string(calendar.Encode())
+
+	for _, line := range []string{
+		"DTSTART;TZID=Europe/Berlin:20260322T190000\r\n",
+		"DTSTART;TZID=Europe/Berlin:20260329T190000\r\n",
+		"TZID:Europe/Berlin\r\n",
+		//The clocks go forward at 2:00 local time on the 29th of March.
+		"BEGIN:DAYLIGHT\r\nDTSTART:20260329T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\nTZNAME:CEST\r\nEND:DAYLIGHT\r\n",
+	} {
+		if !strings.Contains(ics, line) {
+			t.Errorf("expected %q in\n%s", line, ics)
+		}
+	}
+	if strings.Count(ics, "BEGIN:VTIMEZONE") != 1 {
+		t.Errorf("expected a single VTIMEZONE in\n%s", ics)
+	}
+}
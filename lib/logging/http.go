@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+//Headers of the IDs. A client, or the gateway in front of the services, may send its own request
+//and correlation IDs; the request ID is sent back in the response.
+const (
+	RequestIDHeader     = "X-Request-ID"
+	CorrelationIDHeader = "X-Correlation-ID"
+)
+
+//Middleware gives every request of a router an ID, which it binds to the context of the request
+//together with the correlation ID, and logs the request once it is handled. A request that comes
+//without a correlation ID starts a new correlation under its request ID.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = NewID()
+		}
+		correlationID := r.Header.Get(CorrelationIDHeader)
+		if correlationID == "" {
+			correlationID = requestID
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		ctx := WithCorrelationID(WithRequestID(r.Context(), requestID), correlationID)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		slog.DebugContext(ctx, "handled request", "method", r.Method, "path", r.URL.Path,
+			"status", recorder.status, "duration", time.Since(start))
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,144 @@
+//Package logging sets up the structured logger of the services, on top of log/slog. Services and
+//libraries log through slog; the handler Init installs adds what the context of a log line knows
+//about the work it belongs to:
+//
+//   - the request ID of the HTTP request being handled, see Middleware,
+//   - the correlation ID, which follows a request through the events it causes in other services,
+//   - the trace ID of the span the context carries.
+//
+//Values of attributes that hold personal data, like email addresses, are redacted before they are
+//written.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
+)
+
+//Formats of Init.
+const (
+	TEXT = "text"
+	JSON = "json"
+)
+
+//Init makes a logger that writes in the given format at the given level, or above, the default of
+//slog and of the log package. Every line names the service.
+func Init(service string, format string, level string) error {
+	logger, err := New(os.Stderr, format, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger.With("service", service))
+	return nil
+}
+
+//New returns a logger that writes to w in the given format, text or json, at the given level,
+//debug, info, warn or error, or above.
+func New(w io.Writer, format string, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %s", level)
+	}
+	options := &slog.HandlerOptions{Level: l, ReplaceAttr: redact}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case TEXT, "":
+		handler = slog.NewTextHandler(w, options)
+	case JSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %s", format)
+	}
+	return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+//Fatal logs an error and exits, for the errors a service can't start with.
+func Fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+//contextHandler adds the IDs the context of a log line carries to the line.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if id := CorrelationID(ctx); id != "" {
+		r.AddAttrs(slog.String("correlation_id", id))
+	}
+	if sc, ok := tracing.SpanContextFromContext(ctx); ok {
+		r.AddAttrs(slog.String("trace_id", hex.EncodeToString(sc.TraceID[:])))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+type correlationIDKey struct{}
+
+//WithRequestID returns a context that carries the ID of an HTTP request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+//RequestID returns the request ID a context carries, or "".
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+//WithCorrelationID returns a context that carries a correlation ID.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+//CorrelationID returns the correlation ID a context carries, or "".
+func CorrelationID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+//MessageHeader is the header, or the attribute, of a message that carries its correlation ID.
+const MessageHeader = "x-correlation-id"
+
+//ContinueCorrelation returns a context for the handling of a message with the given correlation
+//ID. A message without one, like one sent by a scheduler, starts a new correlation.
+func ContinueCorrelation(ctx context.Context, id string) context.Context {
+	if id == "" {
+		id = NewID()
+	}
+	return WithCorrelationID(ctx, id)
+}
+
+//NewID returns a random ID for requests and correlations.
+func NewID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %s", buf.String(), err)
+	}
+	return line
+}
+
+func TestRedaction(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := New(buf, JSON, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("user created", "user", "5a5f5c", "email", "jane.doe@example.com", "first", "Jane", "password", "hunter2")
+	line := decode(t, buf)
+	if line["user"] != "5a5f5c" {
+		t.Errorf("expected IDs to be logged, got %v", line["user"])
+	}
+	if line["email"] != Redacted+"@example.com" {
+		t.Errorf("expected the address to be redacted, got %v", line["email"])
+	}
+	if line["first"] != Redacted || line["password"] != Redacted {
+		t.Errorf("expected personal data and secrets to be redacted, got %v and %v", line["first"], line["password"])
+	}
+}
+
+func TestLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := New(buf, TEXT, "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("listening to events")
+	if buf.Len() != 0 {
+		t.Errorf("expected lines below the level to be dropped, got %q", buf.String())
+	}
+	if _, err := New(buf, "xml", "info"); err == nil {
+		t.Error("expected an unknown format to be refused")
+	}
+	if _, err := New(buf, JSON, "verbose"); err == nil {
+		t.Error("expected an unknown level to be refused")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := New(buf, JSON, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "booking requested")
+	}))
+
+	req := httptest.NewRequest("POST", "/events/5a5f5c/bookings", nil)
+	req.Header.Set(CorrelationIDHeader, "c0ffee")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	line := decode(t, buf)
+	requestID := recorder.Header().Get(RequestIDHeader)
+	if requestID == "" || line["request_id"] != requestID {
+		t.Errorf("expected the generated request ID in the response and the log line, got %q and %v", requestID, line["request_id"])
+	}
+	if line["correlation_id"] != "c0ffee" {
+		t.Errorf("expected the correlation ID of the caller, got %v", line["correlation_id"])
+	}
+
+	//Without a correlation ID, the request starts a correlation of its own.
+	buf.Reset()
+	req = httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set(RequestIDHeader, "abc123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	line = decode(t, buf)
+	if line["request_id"] != "abc123" || line["correlation_id"] != "abc123" {
+		t.Errorf("expected the request ID to start the correlation, got %v and %v", line["request_id"], line["correlation_id"])
+	}
+}
+
+func TestContinueCorrelation(t *testing.T) {
+	if id := CorrelationID(ContinueCorrelation(context.Background(), "c0ffee")); id != "c0ffee" {
+		t.Errorf("expected the correlation ID of the message, got %q", id)
+	}
+	if id := CorrelationID(ContinueCorrelation(context.Background(), "")); id == "" {
+		t.Error("expected a message without a correlation ID to start a new one")
+	}
+	if CorrelationID(nil) != "" || RequestID(nil) != "" {
+		t.Error("expected no IDs without a context")
+	}
+}
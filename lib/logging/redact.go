@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"log/slog"
+	"strings"
+)
+
+//Redacted replaces the values of attributes that must not be logged.
+const Redacted = "[REDACTED]"
+
+//secretKeys are attributes whose values are left out entirely.
+var secretKeys = map[string]bool{
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+	"authorization": true,
+	"signing_key":   true,
+}
+
+//personalKeys are attributes that hold personal data of users. Email addresses keep their domain,
+//which helps to tell delivery problems of a mail provider apart.
+var personalKeys = map[string]bool{
+	"email": true,
+	"first": true,
+	"last":  true,
+	"phone": true,
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	switch {
+	case secretKeys[key]:
+		return slog.String(a.Key, Redacted)
+	case key == "email":
+		return slog.String(a.Key, RedactEmail(a.Value.String()))
+	case personalKeys[key]:
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+//RedactEmail hides the local part of an email address, for log messages that need to mention one.
+func RedactEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return Redacted
+	}
+	return Redacted + email[at:]
+}
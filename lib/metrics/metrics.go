@@ -0,0 +1,253 @@
+//Package metrics keeps counters and histograms of what the services do and exposes them to
+//Prometheus in its text format, see Handler. The metrics all services share are declared in
+//standard.go, so that they are named the same in every service; Prometheus tells the services apart
+//by the job and instance labels it adds when it scrapes them.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//DefaultBuckets are the upper bounds of histograms of durations in seconds, from 5ms to 10s.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+//A Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+//Default is the registry the metrics of the services are registered with.
+var Default = &Registry{metrics: map[string]metric{}}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: " + name + " is registered twice")
+	}
+	r.metrics[name] = m
+}
+
+//Write writes all metrics, ordered by name.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+//Handler serves the metrics of the default registry, for the /metrics route of the services.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
+
+//series keeps the values of a metric per combination of label values. The key of a series is its
+//label values, joined by a zero byte.
+type series struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+func (s *series) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", s.name, len(s.labels), len(values)))
+	}
+	key := strings.Join(values, "\x00")
+	v, ok := s.values[key]
+	if !ok {
+		v = create()
+		s.values[key] = v
+	}
+	return v
+}
+
+//each calls f for every series in the order of their label values.
+func (s *series) each(f func(labels string, v interface{})) {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pairs := []string{}
+		if len(s.labels) > 0 {
+			for i, value := range strings.Split(key, "\x00") {
+				pairs = append(pairs, s.labels[i]+`="`+escape(value)+`"`)
+			}
+		}
+		f(strings.Join(pairs, ","), s.values[key])
+	}
+}
+
+func (s *series) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+}
+
+//A Counter counts things that only ever go up, like requests. Its values are kept per combination
+//of the values of its labels.
+type Counter struct {
+	series
+}
+
+//NewCounter registers a counter with the default registry.
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{series{name: name, help: help, kind: "counter", labels: labels, values: map[string]interface{}{}}}
+	if len(labels) == 0 {
+		c.values[""] = new(float64)
+	}
+	Default.register(name, c)
+	return c
+}
+
+//Inc adds one to the counter of the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+//Add adds a non-negative value to the counter of the given label values.
+func (c *Counter) Add(v float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(values, func() interface{} { return new(float64) }).(*float64) += v
+}
+
+//Value returns the counter of the given label values.
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.values[strings.Join(values, "\x00")]; ok {
+		return *v.(*float64)
+	}
+	return 0
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	c.each(func(labels string, v interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, braces(labels), format(*v.(*float64)))
+	})
+}
+
+//A Histogram counts observations, like durations, in buckets. Its values are kept per combination
+//of the values of its labels.
+type Histogram struct {
+	series
+	buckets []float64
+}
+
+type histogramValue struct {
+	counts []uint64 //per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+//NewHistogram registers a histogram with the given bucket upper bounds, in ascending order, with
+//the default registry.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		series:  series{name: name, help: help, kind: "histogram", labels: labels, values: map[string]interface{}{}},
+		buckets: buckets,
+	}
+	Default.register(name, h)
+	return h
+}
+
+//Observe records a value in the histogram of the given label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv := h.get(values, func() interface{} { return &histogramValue{counts: make([]uint64, len(h.buckets))} }).(*histogramValue)
+	for i, bound := range h.buckets {
+		if v <= bound {
+			hv.counts[i]++
+			break
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+//Count returns the number of values observed for the given label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hv, ok := h.values[strings.Join(values, "\x00")]; ok {
+		return hv.(*histogramValue).count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	h.each(func(labels string, v interface{}) {
+		hv := v.(*histogramValue)
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			cumulative += hv.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.name, join(labels, `le="`+format(bound)+`"`), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.name, join(labels, `le="+Inf"`), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, braces(labels), format(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, braces(labels), hv.count)
+	})
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func join(labels string, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func format(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//escape escapes a label value the way the text format asks for.
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTextFormat(t *testing.T) {
+	requests := NewCounter("test_requests_total", "Requests.", "route", "status")
+	requests.Inc("/events/{eventID}", "200")
+	requests.Inc("/events/{eventID}", "200")
+	requests.Add(3, `/say/"hi"`, "500")
+	durations := NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "route")
+	durations.Observe(0.05, "/events")
+	durations.Observe(0.5, "/events")
+	durations.Observe(5, "/events")
+	reconnects := NewCounter("test_reconnects_total", "Reconnects.")
+
+	out := &bytes.Buffer{}
+	requests.write(out)
+	durations.write(out)
+	reconnects.write(out)
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/events/{eventID}",status="200"} 2
+test_requests_total{route="/say/\"hi\"",status="500"} 3
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/events",le="0.1"} 1
+test_duration_seconds_bucket{route="/events",le="1"} 2
+test_duration_seconds_bucket{route="/events",le="+Inf"} 3
+test_duration_seconds_sum{route="/events"} 5.55
+test_duration_seconds_count{route="/events"} 3
+# HELP test_reconnects_total Reconnects.
+# TYPE test_reconnects_total counter
+test_reconnects_total 0
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.Methods("GET").Path("/metrics").Handler(Handler())
+	r.Methods("GET").Path("/events/{eventID}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	before := HTTPRequests.Value("GET", "/events/{eventID}", "404")
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events/5a5f5c", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events/5a5f5d", nil))
+	if requests := HTTPRequests.Value("GET", "/events/{eventID}", "404"); requests != before+2 {
+		t.Errorf("expected both requests to be counted for their route, got %v", requests-before)
+	}
+	if HTTPDuration.Count("GET", "/events/{eventID}") < 2 {
+		t.Error("expected both requests to be timed")
+	}
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(recorder.Body.String(), `myevents_http_requests_total{method="GET",route="/events/{eventID}",status="404"}`) {
+		t.Errorf("expected the requests in the metrics, got\n%s", recorder.Body)
+	}
+}
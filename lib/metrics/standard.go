@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+//The metrics every service exposes.
+var (
+	HTTPRequests = NewCounter("myevents_http_requests_total",
+		"HTTP requests handled, by method, route and status code.", "method", "route", "status")
+	HTTPDuration = NewHistogram("myevents_http_request_duration_seconds",
+		"Time taken to answer HTTP requests, by method and route.", DefaultBuckets, "method", "route")
+
+	DatabaseDuration = NewHistogram("myevents_db_call_duration_seconds",
+		"Duration of the calls to the database handler, by method.", DefaultBuckets, "method")
+	DatabaseErrors = NewCounter("myevents_db_call_errors_total",
+		"Calls to the database handler that returned an error, by method. Lookups of records that don't exist count as well.", "method")
+
+	MessagesEmitted = NewCounter("myevents_messages_emitted_total",
+		"Events emitted to the message broker, by event name.", "event")
+	MessagesConsumed = NewCounter("myevents_messages_consumed_total",
+		"Events received from the message broker, by event name.", "event")
+	MessagesFailed = NewCounter("myevents_messages_failed_total",
+		"Events that could not be emitted or decoded, by event name and the step that failed (emit or decode).", "event", "step")
+	ListenerLag = NewHistogram("myevents_listener_lag_seconds",
+		"Time from emitting an event until a listener handles it, by event name.",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}, "event")
+
+	EventStoreAppendsFailed = NewCounter("myevents_eventstore_appends_failed_total",
+		"Events that were emitted without being recorded in the event store, by event name. Projections rebuilt from the store miss them.", "event")
+
+	AMQPReconnects = NewCounter("myevents_amqp_reconnects_total",
+		"Connections to the AMQP broker that were lost and made again.")
+)
+
+//Emitted counts an event that was emitted, or that failed to be emitted with the given error.
+func Emitted(eventName string, err error) {
+	if err != nil {
+		MessagesFailed.Inc(eventName, "emit")
+		return
+	}
+	MessagesEmitted.Inc(eventName)
+}
+
+//Consumed counts an event a listener received, and the time since it was emitted. Events without
+//the time they were emitted at are counted without their lag.
+func Consumed(eventName string, emitted time.Time) {
+	MessagesConsumed.Inc(eventName)
+	if !emitted.IsZero() {
+		ListenerLag.Observe(time.Since(emitted).Seconds(), eventName)
+	}
+}
+
+//Middleware counts the requests to a router and times them, by their route template, like
+//"/events/{eventID}", so that the routes don't get a series for every ID.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+		HTTPDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+		HTTPRequests.Inc(r.Method, route, strconv.Itoa(recorder.status))
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
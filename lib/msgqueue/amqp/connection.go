@@ -2,14 +2,22 @@ package amqp
 
 import (
 	"fmt"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
 	"github.com/streadway/amqp"
 )
 
 type Connection struct {
 	ConnURL string
-	Conn    *amqp.Connection
+	conn    atomic.Pointer[amqp.Connection] //replaced by the goroutine that reconnects, while others use it
+}
+
+//Conn returns the current connection to the broker, or nil while there was none yet.
+func (c *Connection) Conn() *amqp.Connection {
+	return c.conn.Load()
 }
 
 func (c *Connection) Connect() error {
@@ -18,11 +26,11 @@ func (c *Connection) Connect() error {
 	}
 	conn, err := amqp.Dial(c.ConnURL)
 	if err != nil {
-		fmt.Println("failed to connect to the broker")
+		slog.Warn("could not connect to the broker", "error", err)
 		return err
 	}
-	fmt.Println("Connected to the amqp broker")
-	c.Conn = conn
+	slog.Info("connected to the broker")
+	c.conn.Store(conn)
 	return nil
 }
 
@@ -30,7 +38,6 @@ func NewAMQPConnection(connection string) *Connection {
 
 	newConnection := &Connection{
 		ConnURL: connection,
-		Conn:    nil,
 	}
 
 	for err := newConnection.Connect(); err != nil; err = newConnection.Connect() {
@@ -38,7 +45,7 @@ func NewAMQPConnection(connection string) *Connection {
 	}
 
 	chanErr := make(chan *amqp.Error)
-	chanErr = newConnection.Conn.NotifyClose(chanErr)
+	chanErr = newConnection.Conn().NotifyClose(chanErr)
 
 	go func() {
 		for {
@@ -48,11 +55,24 @@ func NewAMQPConnection(connection string) *Connection {
 
 				time.Sleep(time.Duration(5000000000))
 			}
+			metrics.AMQPReconnects.Inc()
 			chanErr = make(chan *amqp.Error)
-			chanErr = newConnection.Conn.NotifyClose(chanErr)
+			chanErr = newConnection.Conn().NotifyClose(chanErr)
 		}
-		fmt.Println("Exited loop")
 	}()
 
 	return newConnection
 }
+
+//Check reports whether the connection to the broker is up. It fails while NewAMQPConnection is
+//still trying to connect, or to reconnect after the connection was lost.
+func (c *Connection) Check() error {
+	conn := c.Conn()
+	if conn == nil {
+		return fmt.Errorf("not connected to the broker yet")
+	}
+	if conn.IsClosed() {
+		return fmt.Errorf("connection to the broker is closed, reconnecting")
+	}
+	return nil
+}
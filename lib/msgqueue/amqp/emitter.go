@@ -1,26 +1,32 @@
 package amqp
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"time"
 
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 	"github.com/streadway/amqp"
 )
 
 type amqpEventEmitter struct {
 	connection *Connection
 	exchange   string
+	codec      msgqueue.Codec
+	ctx        context.Context //the trace the emitted events belong to, see WithContext
 	setupDone  bool
 }
 
 //This is a constructor for the amqpEventEmitter struct and it hides the struct from being instanciated
 //by some other package in other ways.
 func (a *amqpEventEmitter) setup() error {
-	if a.connection.Conn == nil {
+	if a.connection.Conn() == nil {
 		return fmt.Errorf("connection is not established with the broker")
 	}
-	channel, err := a.connection.Conn.Channel()
+	channel, err := a.connection.Conn().Channel()
 	if err != nil {
 		return err
 	}
@@ -37,11 +43,16 @@ func (a *amqpEventEmitter) setup() error {
 	return err
 }
 
-//This is a constructor for building new instances of the struct
-func NewAMQPEventEmitter(conn *Connection, exchange string) (msgqueue.EventEmitter, error) {
+//This is a constructor for building new instances of the struct. Events are encoded with the given
+//codec, or with JSON if it is nil.
+func NewAMQPEventEmitter(conn *Connection, exchange string, codec msgqueue.Codec) (msgqueue.EventEmitter, error) {
+	if codec == nil {
+		codec = msgqueue.JSONCodec
+	}
 	emitter := &amqpEventEmitter{
 		connection: conn,
 		exchange:   exchange,
+		codec:      codec,
 		setupDone:  false,
 	}
 	err := emitter.setup()
@@ -51,39 +62,62 @@ func NewAMQPEventEmitter(conn *Connection, exchange string) (msgqueue.EventEmitt
 	return emitter, nil
 }
 
-func (a *amqpEventEmitter) Emit(event msgqueue.Event) error {
+//WithContext returns an emitter that shares the connection of this one, and emits its events as part
+//of the trace the context carries.
+func (a *amqpEventEmitter) WithContext(ctx context.Context) msgqueue.EventEmitter {
+	bound := *a
+	bound.ctx = ctx
+	return &bound
+}
+
+func (a *amqpEventEmitter) Emit(event msgqueue.Event) (err error) {
+	_, span := tracing.Start(a.ctx, "send "+event.EventName(), tracing.Producer)
+	span.SetAttribute("messaging.system", "rabbitmq")
+	span.SetAttribute("messaging.destination", a.exchange)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+		metrics.Emitted(event.EventName(), err)
+	}()
+
 	//We are creating a new channel for each published message within this code. While in theory it is
 	//possible to reuse the same channel for publishing multiple messages, we need to keep in mind that
 	//a single AMQP channel is not thread-safe. This means that calling the event emitter's Emit() method
 	//from multiple go-routines might lead to strange and unpredictable results. This is exactly the
 	//problem that AMQP channels are there to solve; using multiple channels, multiple threads can use the
 	//same AMQP connection.
-	if a.connection.Conn == nil {
+	if a.connection.Conn() == nil {
 		return fmt.Errorf("connection not established")
 	}
-	if a.connection.Conn.IsClosed() == true {
+	if a.connection.Conn().IsClosed() == true {
 		return fmt.Errorf("connection is closed")
 	}
-	err := a.setup()
+	err = a.setup()
 	if err != nil {
 		return err
 	}
 
-	channel, err := a.connection.Conn.Channel()
+	channel, err := a.connection.Conn().Channel()
 	if err != nil {
 		return err
 	}
 	defer channel.Close()
 
-	jsonDoc, err := json.Marshal(event)
+	body, err := a.codec.Encode(event)
 	if err != nil {
 		return err
 	}
 
 	msg := amqp.Publishing{
-		Headers:     amqp.Table{"x-event-name": event.EventName()},
-		Body:        jsonDoc,
-		ContentType: "application/json",
+		Headers: amqp.Table{
+			"x-event-name":        event.EventName(),
+			"x-schema-version":    int32(msgqueue.SchemaVersion(event)),
+			tracing.Header:        span.Context.Traceparent(),
+			logging.MessageHeader: logging.CorrelationID(a.ctx),
+		},
+		Body:        body,
+		ContentType: a.codec.ContentType(),
+		Timestamp:   time.Now(),
 	}
 	return channel.Publish(
 		a.exchange,
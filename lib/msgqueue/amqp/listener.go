@@ -1,23 +1,27 @@
 package amqp
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"log/slog"
 	"time"
 
-	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 )
 
 type amqpEventListener struct {
 	connection *Connection
 	queue      string //name of the queue to listen to. Put "" for auto generated queue name
 	exchange   string
+	mapper     msgqueue.EventMapper
 	setupDone  bool
 }
 
 func (a *amqpEventListener) setup() error {
-	channel, err := a.connection.Conn.Channel()
+	channel, err := a.connection.Conn().Channel()
 	if err != nil {
 		return nil
 	}
@@ -45,41 +49,48 @@ func (a *amqpEventListener) setup() error {
 
 //Listens to certain events with specified names from the declared queue
 func (a *amqpEventListener) Listen(eventNames ...string) (<-chan msgqueue.Event, <-chan error, error) {
+	deliveries, errors, err := a.ListenContext(eventNames...)
+	return msgqueue.Events(deliveries), errors, err
+}
+
+//ListenContext listens like Listen, and continues the trace named by the traceparent header of every
+//message.
+func (a *amqpEventListener) ListenContext(eventNames ...string) (<-chan msgqueue.Delivery, <-chan error, error) {
 	//The msgs variable now holds a channel of amqp.Delivery structs. However our event listener is supposed
 	//to return a channel of msgqueue.Event. This can be solved by consuming the msgs channel in our own
 	//goroutine, build the respective event structs, and then publish these in another channel that we
 	//return from this function.
-	events := make(chan msgqueue.Event)
+	deliveries := make(chan msgqueue.Delivery)
 	errors := make(chan error)
 	go func() {
 		for {
 			time.Sleep(time.Duration(5000000000))
-			if a.connection.Conn == nil {
-				fmt.Printf("Connection not established\n")
+			if a.connection.Conn() == nil {
+				slog.Warn("connection to the broker not established")
 				continue
 			}
-			if a.connection.Conn.IsClosed() == true {
-				fmt.Printf("Connection is closed\n")
+			if a.connection.Conn().IsClosed() == true {
+				slog.Warn("connection to the broker is closed")
 				continue
 			}
 			err := a.setup()
 			if err != nil {
-				fmt.Printf("Setup failed: %s\n", err)
+				slog.Error("could not set up the queue", "queue", a.queue, "error", err)
 				continue
 			}
-			channel, err := a.connection.Conn.Channel()
+			channel, err := a.connection.Conn().Channel()
 			if err != nil {
-				fmt.Printf("Could not get channel from connection: %s\n", err)
+				slog.Error("could not get a channel from the connection", "error", err)
 			}
 			for _, eventName := range eventNames {
 				if err := channel.QueueBind(a.queue, eventName, a.exchange, false, nil); err != nil {
-					fmt.Printf("Could not bind queue: %s, error: %s\n", eventName, err)
+					slog.Error("could not bind queue", "queue", a.queue, "event", eventName, "error", err)
 				}
 			}
 
 			msgs, err := channel.Consume(a.queue, "", false, false, false, false, nil)
 			if err != nil {
-				fmt.Printf("Could not establish a consumer: %s\n", err)
+				slog.Error("could not establish a consumer", "queue", a.queue, "error", err)
 				continue
 			}
 			for msg := range msgs {
@@ -101,30 +112,39 @@ func (a *amqpEventListener) Listen(eventNames ...string) (<-chan msgqueue.Event,
 					msg.Nack(false, false)
 					continue
 				}
-				var event msgqueue.Event
-				switch eventName {
-				case "event.created":
-					event = new(contracts.EventCreatedEvent)
-				case "booking.created":
-					event = new(contracts.EventBookedEvent)
-				default:
-					errors <- fmt.Errorf("event type %s is unknown", eventName)
-					msg.Nack(false, false)
-					continue
+				//Messages without a schema version were sent before there were versions.
+				version := 1
+				if rawVersion, ok := msg.Headers["x-schema-version"]; ok {
+					version, err = msgqueue.ParseSchemaVersion(fmt.Sprint(rawVersion))
+					if err != nil {
+						metrics.MessagesFailed.Inc(eventName, "decode")
+						errors <- err
+						msg.Nack(false, false)
+						continue
+					}
 				}
-				err := json.Unmarshal(msg.Body, event)
+				//The body is decoded with the codec its producer chose, which is named by the content type.
+				event, err := a.mapper.DecodeEvent(eventName, msg.ContentType, version, msg.Body)
 				if err != nil {
+					metrics.MessagesFailed.Inc(eventName, "decode")
 					errors <- err
 					msg.Nack(false, false)
 					continue
 				}
-				events <- event
+				traceparent, _ := msg.Headers[tracing.Header].(string)
+				correlationID, _ := msg.Headers[logging.MessageHeader].(string)
+				deliveries <- msgqueue.Delivery{
+					Event:   event,
+					Context: logging.ContinueCorrelation(tracing.Extract(context.Background(), traceparent), correlationID),
+				}
+				//The delivery was taken by the processor, so the lag is how long the event waited to be handled.
+				metrics.Consumed(eventName, msg.Timestamp)
 				msg.Ack(false)
 			}
-			fmt.Println("Stoped listening to messages")
+			slog.Warn("stopped listening to messages", "queue", a.queue)
 		}
 	}()
-	return events, errors, nil
+	return deliveries, errors, nil
 }
 
 //Initializes a new Listener struct that we use to listen to new events
@@ -133,6 +153,7 @@ func NewAMQPEventListener(conn *Connection, exchange string, queue string) (msgq
 		connection: conn,
 		queue:      queue,
 		exchange:   exchange,
+		mapper:     msgqueue.NewEventMapper(),
 	}
 	err := listener.setup()
 	if err != nil {
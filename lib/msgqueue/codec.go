@@ -0,0 +1,84 @@
+package msgqueue
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue/protobuf"
+)
+
+//Content types of the codecs. Emitters put the content type of their codec on every message (the
+//ContentType of AMQP, a message attribute on SQS and a header on Kafka), so that listeners decode
+//every message with the codec its producer chose. Producers can thus switch codecs one by one.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+//A Codec serializes events for the message queue.
+type Codec interface {
+	ContentType() string
+	Encode(Event) ([]byte, error)
+	Decode([]byte, Event) error
+}
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+//NewCodec returns the codec with the given name, which is json or protobuf. The JSON codec is the
+//default.
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "", "json":
+		return JSONCodec, nil
+	case "protobuf":
+		return ProtobufCodec, nil
+	}
+	return nil, fmt.Errorf("unknown message codec %s", name)
+}
+
+//CodecFor returns the codec of the given content type. Messages without a content type were sent
+//before there was a choice, so they are JSON.
+func CodecFor(contentType string) (Codec, error) {
+	contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	switch contentType {
+	case "", ContentTypeJSON:
+		return JSONCodec, nil
+	case ContentTypeProtobuf:
+		return ProtobufCodec, nil
+	}
+	return nil, fmt.Errorf("no codec for content type %s", contentType)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Encode(e Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (jsonCodec) Decode(data []byte, e Event) error {
+	return json.Unmarshal(data, e)
+}
+
+//protobufCodec encodes the contracts with the field numbers of their protobuf tags, as described by
+//contracts.proto.
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Encode(e Event) ([]byte, error) {
+	return protobuf.Marshal(e)
+}
+
+func (protobufCodec) Decode(data []byte, e Event) error {
+	return protobuf.Unmarshal(data, e)
+}
@@ -0,0 +1,82 @@
+package msgqueue
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+func events() []Event {
+	start := time.Date(2026, 3, 2, 19, 30, 0, 0, time.UTC)
+	events := []Event{
+		&contracts.EventCreatedEvent{
+			ID:       "5a5f5c",
+			Name:     "Opera night",
+			Start:    start,
+			End:      start.Add(3 * time.Hour),
+			Capacity: 250,
+			SeatMap: &persistence.SeatMap{Sections: []persistence.Section{{
+				Name: "Stalls",
+				Rows: []persistence.Row{{Name: "A", Seats: []persistence.Seat{{Number: 1, Accessible: true}, {Number: 2}}}},
+			}}},
+			TicketTypes: []persistence.TicketType{{Name: "standard", Price: 4500, Currency: "EUR"}},
+		},
+		&contracts.EventBookedEvent{
+			ID:       "b1",
+			EventID:  "5a5f5c",
+			Seats:    2,
+			SeatIDs:  []string{"Stalls-A-1", "Stalls-A-2"},
+			Discount: -100,
+			Total:    8900,
+		},
+		&contracts.LocationCreatedEvent{
+			ID:    "l1",
+			Halls: []persistence.Hall{{Name: "Main", Capacity: 250}},
+		},
+	}
+	for _, e := range contracts.All() {
+		events = append(events, e.(Event))
+	}
+	return events
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	mapper := NewEventMapper()
+	for _, codec := range []Codec{JSONCodec, ProtobufCodec} {
+		for _, event := range events() {
+			body, err := codec.Encode(event)
+			if err != nil {
+				t.Fatalf("%s: could not encode %s: %s", codec.ContentType(), event.EventName(), err)
+			}
+			decoded, err := mapper.DecodeEvent(event.EventName(), codec.ContentType(), SchemaVersion(event), body)
+			if err != nil {
+				t.Fatalf("%s: could not decode %s: %s", codec.ContentType(), event.EventName(), err)
+			}
+			if !reflect.DeepEqual(event, decoded) {
+				t.Errorf("%s: expected %+v, got %+v", codec.ContentType(), event, decoded)
+			}
+		}
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	for contentType, expected := range map[string]Codec{
+		"":                                JSONCodec,
+		"application/json; charset=utf-8": JSONCodec,
+		"application/x-protobuf":          ProtobufCodec,
+	} {
+		codec, err := CodecFor(contentType)
+		if err != nil || codec != expected {
+			t.Errorf("expected %s for %q, got %v (%v)", expected.ContentType(), contentType, codec, err)
+		}
+	}
+	if _, err := CodecFor("text/xml"); err == nil {
+		t.Error("expected an error for an unknown content type")
+	}
+	if _, err := NewCodec("avro"); err == nil {
+		t.Error("expected an error for an unknown codec")
+	}
+}
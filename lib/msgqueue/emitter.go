@@ -1,6 +1,23 @@
 package msgqueue
 
+import "context"
+
 //This interface describes the methods that all event emitter implementations need to fulfil.
 type EventEmitter interface {
 	Emit(e Event) error
-}
\ No newline at end of file
+}
+
+//Emitters that trace what they emit implement ContextEmitter. The emitter WithContext returns emits
+//its events as part of the trace the context carries.
+type ContextEmitter interface {
+	WithContext(ctx context.Context) EventEmitter
+}
+
+//WithContext binds an emitter to a context, if it is a ContextEmitter. Other emitters are returned
+//as they are.
+func WithContext(ctx context.Context, emitter EventEmitter) EventEmitter {
+	if e, ok := emitter.(ContextEmitter); ok {
+		return e.WithContext(ctx)
+	}
+	return emitter
+}
@@ -1,7 +1,39 @@
 package msgqueue
 
+import (
+	"fmt"
+	"strconv"
+)
+
 //We define an interface for the publishers and subscribers to use when handling events:
 type Event interface {
 	EventName() string
 }
 
+//Events whose schema changed in a way that breaks consumers implement Versioned. Emitters send the
+//version along with the event, so that consumers can upcast events of older versions.
+type Versioned interface {
+	SchemaVersion() int
+}
+
+//SchemaVersion returns the schema version of an event. Events that are not versioned, and events
+//that were sent before there were versions, are version 1.
+func SchemaVersion(e Event) int {
+	if v, ok := e.(Versioned); ok && v.SchemaVersion() > 0 {
+		return v.SchemaVersion()
+	}
+	return 1
+}
+
+//ParseSchemaVersion parses a schema version as it is sent along with an event. An empty version
+//is version 1.
+func ParseSchemaVersion(s string) (int, error) {
+	if s == "" {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(s)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("invalid schema version %q", s)
+	}
+	return version, nil
+}
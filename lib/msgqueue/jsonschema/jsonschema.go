@@ -0,0 +1,251 @@
+//Package jsonschema describes events with JSON Schema, generated from their Go types, and tells
+//which changes between two schemas of an event break its consumers.
+//
+//Fields are named after their json tags. Fields without omitempty are required, since they are
+//always sent. Besides the standard keywords, a schema carries the name of its event, its schema
+//version and the protobuf field numbers of the properties, so that changes to the protobuf encoding
+//are caught as well.
+package jsonschema
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Draft is the version of JSON Schema the schemas follow.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+type Schema struct {
+	Schema          string             `json:"$schema,omitempty"`
+	Title           string             `json:"title,omitempty"`
+	EventName       string             `json:"x-event-name,omitempty"`
+	Version         int                `json:"x-schema-version,omitempty"`
+	Type            string             `json:"type"`
+	Format          string             `json:"format,omitempty"`
+	ContentEncoding string             `json:"contentEncoding,omitempty"`
+	ProtobufField   int                `json:"x-protobuf-field,omitempty"`
+	Items           *Schema            `json:"items,omitempty"`
+	Properties      map[string]*Schema `json:"properties,omitempty"`
+	Required        []string           `json:"required,omitempty"`
+	//AdditionalProperties describes the values of maps, whose keys are not known in advance.
+	AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+//Generate returns the schema of an event, which is a struct or a pointer to one. Events that have
+//no EventName or SchemaVersion method are described without them.
+func Generate(event interface{}) (*Schema, error) {
+	t := reflect.TypeOf(event)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("jsonschema: cannot describe %T, only structs", event)
+	}
+	s, err := generate(t)
+	if err != nil {
+		return nil, err
+	}
+	s.Schema = Draft
+	s.Title = t.Name()
+	if e, ok := event.(interface{ EventName() string }); ok {
+		s.EventName = e.EventName()
+	}
+	s.Version = 1
+	if v, ok := event.(interface{ SchemaVersion() int }); ok {
+		s.Version = v.SchemaVersion()
+	}
+	return s, nil
+}
+
+//Describe returns the schema of any value of the type of v, like the body of an HTTP request. Unlike
+//Generate, it doesn't mark the schema as the schema of an event.
+func Describe(v interface{}) (*Schema, error) {
+	if v == nil {
+		return nil, fmt.Errorf("jsonschema: cannot describe nil")
+	}
+	t := reflect.TypeOf(v)
+	s, err := generate(t)
+	if err != nil {
+		return nil, err
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	s.Title = t.Name()
+	return s, nil
+}
+
+func generate(t reflect.Type) (*Schema, error) {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return generate(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", ContentEncoding: "base64"}, nil
+		}
+		items, err := generate(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			break
+		}
+		values, err := generate(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := strings.Split(f.Tag.Get("json"), ",")
+			name := tag[0]
+			//Like encoding/json, the fields of embedded structs are fields of the struct that embeds them.
+			if f.Anonymous && name == "" && indirect(f.Type).Kind() == reflect.Struct {
+				embedded, err := generate(f.Type)
+				if err != nil {
+					return nil, err
+				}
+				for field, property := range embedded.Properties {
+					s.Properties[field] = property
+				}
+				s.Required = append(s.Required, embedded.Required...)
+				continue
+			}
+			if f.PkgPath != "" || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			property, err := generate(f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("jsonschema: field %s of %s: %s", f.Name, t.Name(), err)
+			}
+			if number, err := strconv.Atoi(f.Tag.Get("protobuf")); err == nil {
+				property.ProtobufField = number
+			}
+			s.Properties[name] = property
+			if !hasOption(tag[1:], "omitempty") {
+				s.Required = append(s.Required, name)
+			}
+		}
+		sort.Strings(s.Required)
+		return s, nil
+	}
+	return nil, fmt.Errorf("type %s has no JSON Schema equivalent", t)
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+//BreakingChanges lists the changes from one schema of an event to the next that break its consumers,
+//whether they still read with the old schema or already with the new one:
+//
+//   - a property was removed, or renamed, which removes the old name,
+//   - the type or format of a property changed,
+//   - a required property became optional, so producers may leave out what consumers rely on,
+//   - the protobuf field number of a property changed.
+//
+//Adding properties is compatible: consumers ignore what they don't know, and read properties older
+//producers leave out as zero values.
+func BreakingChanges(before *Schema, after *Schema) []string {
+	changes := []string{}
+	compare("", before, after, &changes)
+	return changes
+}
+
+func compare(path string, before *Schema, after *Schema, changes *[]string) {
+	at := path
+	if at == "" {
+		at = "the event"
+	}
+	if before.Type != after.Type || before.Format != after.Format || before.ContentEncoding != after.ContentEncoding {
+		*changes = append(*changes, fmt.Sprintf("%s changed its type from %s to %s", at, describe(before), describe(after)))
+		return
+	}
+	if before.ProtobufField != 0 && before.ProtobufField != after.ProtobufField {
+		*changes = append(*changes, fmt.Sprintf("%s changed its protobuf field from %d to %d", at, before.ProtobufField, after.ProtobufField))
+	}
+	if before.Items != nil && after.Items != nil {
+		compare(path+"[]", before.Items, after.Items, changes)
+	}
+
+	names := make([]string, 0, len(before.Properties))
+	for name := range before.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property := join(path, name)
+		newProperty, ok := after.Properties[name]
+		if !ok {
+			*changes = append(*changes, fmt.Sprintf("%s was removed", property))
+			continue
+		}
+		compare(property, before.Properties[name], newProperty, changes)
+		if contains(before.Required, name) && !contains(after.Required, name) {
+			*changes = append(*changes, fmt.Sprintf("%s is no longer required", property))
+		}
+	}
+}
+
+func describe(s *Schema) string {
+	switch {
+	case s.Format != "":
+		return s.Type + " (" + s.Format + ")"
+	case s.ContentEncoding != "":
+		return s.Type + " (" + s.ContentEncoding + ")"
+	}
+	return s.Type
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
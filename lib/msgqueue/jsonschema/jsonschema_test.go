@@ -0,0 +1,96 @@
+package jsonschema
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bookedV1 struct {
+	ID      string    `json:"id" protobuf:"1"`
+	Seats   int       `json:"seats" protobuf:"2"`
+	SeatIDs []string  `json:"seatIds,omitempty" protobuf:"3"`
+	Date    time.Time `json:"date" protobuf:"4"`
+	Note    string    `json:"-"`
+}
+
+func (b *bookedV1) EventName() string  { return "event.booked" }
+func (b *bookedV1) SchemaVersion() int { return 1 }
+
+func schemaOf(t *testing.T, event interface{}) *Schema {
+	s, err := Generate(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGenerate(t *testing.T) {
+	s := schemaOf(t, &bookedV1{})
+	if s.EventName != "event.booked" || s.Version != 1 || s.Title != "bookedV1" || s.Type != "object" {
+		t.Errorf("unexpected schema %+v", s)
+	}
+	if len(s.Properties) != 4 {
+		t.Errorf("expected 4 properties, got %v", s.Properties)
+	}
+	if !reflect.DeepEqual(s.Required, []string{"date", "id", "seats"}) {
+		t.Errorf("expected date, id and seats to be required, got %v", s.Required)
+	}
+	if p := s.Properties["date"]; p.Type != "string" || p.Format != "date-time" || p.ProtobufField != 4 {
+		t.Errorf("unexpected date %+v", p)
+	}
+	if p := s.Properties["seatIds"]; p.Type != "array" || p.Items.Type != "string" {
+		t.Errorf("unexpected seatIds %+v", p)
+	}
+}
+
+func TestCompatibleChanges(t *testing.T) {
+	type bookedWithTotal struct {
+		ID      string    `json:"id" protobuf:"1"`
+		Seats   int       `json:"seats" protobuf:"2"`
+		SeatIDs []string  `json:"seatIds,omitempty" protobuf:"3"`
+		Date    time.Time `json:"date" protobuf:"4"`
+		Total   int64     `json:"total" protobuf:"5"`
+	}
+	changes := BreakingChanges(schemaOf(t, &bookedV1{}), schemaOf(t, bookedWithTotal{}))
+	if len(changes) != 0 {
+		t.Errorf("expected no breaking changes, got %v", changes)
+	}
+}
+
+func TestBreakingChanges(t *testing.T) {
+	type booked struct {
+		ID      int      `json:"id" protobuf:"1"`
+		SeatIDs []int    `json:"seatIds,omitempty" protobuf:"3"`
+		Date    string   `json:"date,omitempty" protobuf:"5"`
+		Total   int64    `json:"total" protobuf:"2"`
+		Tags    []string `json:"tags"`
+	}
+	changes := BreakingChanges(schemaOf(t, &bookedV1{}), schemaOf(t, booked{}))
+	for _, expected := range []string{
+		"date changed its type from string (date-time) to string",
+		"id changed its type from string to integer",
+		"seatIds[] changed its type from string to integer",
+		"seats was removed",
+	} {
+		found := false
+		for _, change := range changes {
+			found = found || change == expected
+		}
+		if !found {
+			t.Errorf("expected %q among %v", expected, changes)
+		}
+	}
+
+	type renumbered struct {
+		ID    string    `json:"id" protobuf:"1"`
+		Seats int       `json:"seats" protobuf:"7"`
+		Date  time.Time `json:"date,omitempty" protobuf:"4"`
+	}
+	changes = BreakingChanges(schemaOf(t, &bookedV1{}), schemaOf(t, renumbered{}))
+	joined := strings.Join(changes, "\n")
+	if !strings.Contains(joined, "seats changed its protobuf field from 2 to 7") || !strings.Contains(joined, "date is no longer required") {
+		t.Errorf("unexpected changes %v", changes)
+	}
+}
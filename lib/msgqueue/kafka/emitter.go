@@ -1,44 +1,87 @@
-package kafka 
- 
-//In order to emit messages, you will need to construct an instance of the sarama.ProducerMessage struct. For this, you will need 
-//the topic (which, in our case, is supplied by the msgqueue. Event's EventName() method) and the actual message body. The body needs 
-//to be supplied as an implementation of the sarama.Encoder interface. You can use the sarama.ByteEncoder and sarama.StringEncoder 
+package kafka
+
+//In order to emit messages, you will need to construct an instance of the sarama.ProducerMessage struct. For this, you will need
+//the topic (all events go to the "events" topic the listener reads) and the actual message body. The body needs
+//to be supplied as an implementation of the sarama.Encoder interface. You can use the sarama.ByteEncoder and sarama.StringEncoder
 //types to simply typecast a byte array or a string to an Encoder implementation.
 
-import "github.com/Shopify/sarama" 
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
+)
+
+type kafkaEventEmitter struct {
+	producer sarama.SyncProducer
+	codec    msgqueue.Codec
+	ctx      context.Context
+}
+
+//NewKafkaEventEmitter returns an emitter that encodes events with the given codec, or with JSON if
+//it is nil. The event name and the content type are sent as record headers, which need Kafka 0.11
+//or newer, so the client has to be configured with at least sarama.V0_11_0_0.
+func NewKafkaEventEmitter(client sarama.Client, codec msgqueue.Codec) (msgqueue.EventEmitter, error) {
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	if codec == nil {
+		codec = msgqueue.JSONCodec
+	}
+
+	emitter := &kafkaEventEmitter{
+		producer: producer,
+		codec:    codec,
+	}
 
-type kafkaEventEmitter struct { 
-  producer sarama.SyncProducer 
+	return emitter, nil
 }
 
-func NewKafkaEventEmitter(client sarama.Client) (msgqueue.EventEmitter, error) { 
-	producer, err := sarama.NewSyncProducerFromClient(client) 
-	if err != nil { 
-	  return nil, err 
-	} 
-   
-	emitter := &kafkaEventEmitter{ 
-	  producer: producer, 
-	} 
-   
-	return emitter, nil 
-} 
-
-func (e *kafkaEventEmitter) Emit(event msgqueue.Event) error { 
-	envelope := messageEnvelope{event.EventName(), event} 
-	jsonBody, err := json.Marshal(&envelope) 
-	if err != nil { 
-	  return err 
-	} 
-   
-	msg := &sarama.ProducerMessage{ 
-	  Topic: event.EventName(), 
-	  Value: sarama.ByteEncoder(jsonBody), 
-	} 
-   
-	//The key in this code sample is the producer's SendMessage() method. Note that we are actually ignoring a few of this method's 
-	//return values. The first two return values return the number of the partition that the messages were written in and the offset 
+//WithContext returns an emitter that shares the producer of this one, and emits its events as part of
+//the trace the context carries.
+func (e *kafkaEventEmitter) WithContext(ctx context.Context) msgqueue.EventEmitter {
+	bound := *e
+	bound.ctx = ctx
+	return &bound
+}
+
+func (e *kafkaEventEmitter) Emit(event msgqueue.Event) (err error) {
+	_, span := tracing.Start(e.ctx, "send "+event.EventName(), tracing.Producer)
+	span.SetAttribute("messaging.system", "kafka")
+	span.SetAttribute("messaging.destination", topic)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+		metrics.Emitted(event.EventName(), err)
+	}()
+
+	body, err := e.codec.Encode(event)
+	if err != nil {
+		return err
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(headerEventName), Value: []byte(event.EventName())},
+			{Key: []byte(headerContentType), Value: []byte(e.codec.ContentType())},
+			{Key: []byte(headerVersion), Value: []byte(strconv.Itoa(msgqueue.SchemaVersion(event)))},
+			{Key: []byte(tracing.Header), Value: []byte(span.Context.Traceparent())},
+			{Key: []byte(logging.MessageHeader), Value: []byte(logging.CorrelationID(e.ctx))},
+		},
+		Value:     sarama.ByteEncoder(body),
+		Timestamp: time.Now(),
+	}
+
+	//The key in this code sample is the producer's SendMessage() method. Note that we are actually ignoring a few of this method's
+	//return values. The first two return values return the number of the partition that the messages were written in and the offset
 	//number that the message has in the event log.
-	_, _, err = e.producer.SendMessage(msg) 
-	return err 
-  } 
\ No newline at end of file
+	_, _, err = e.producer.SendMessage(msg)
+	return err
+}
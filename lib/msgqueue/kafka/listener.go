@@ -1,19 +1,22 @@
 package kafka
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/Shopify/sarama"
-	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
-	"github.com/mitchellh/mapstructure"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 )
 
 type kafkaEventListener struct {
 	consumer   sarama.Consumer
 	partitions []int32
+	mapper     msgqueue.EventMapper
 }
 
 func NewKafkaEventListener(client sarama.Client, partitions []int32) (msgqueue.EventListener, error) {
@@ -25,29 +28,42 @@ func NewKafkaEventListener(client sarama.Client, partitions []int32) (msgqueue.E
 	listener := &kafkaEventListener{
 		consumer:   consumer,
 		partitions: partitions,
+		mapper:     msgqueue.NewEventMapper(),
 	}
 
 	return listener, nil
 }
 
 func (k *kafkaEventListener) Listen(events ...string) (<-chan msgqueue.Event, <-chan error, error) {
+	deliveries, errors, err := k.ListenContext(events...)
+	return msgqueue.Events(deliveries), errors, err
+}
+
+//ListenContext listens like Listen, and continues the trace named by the traceparent header of every
+//record.
+func (k *kafkaEventListener) ListenContext(events ...string) (<-chan msgqueue.Delivery, <-chan error, error) {
 	var err error
 
-	topic := "events"
-	results := make(chan msgqueue.Event)
+	results := make(chan msgqueue.Delivery)
 	errors := make(chan error)
 
 	partitions := k.partitions
 	if len(partitions) == 0 {
-		partitions, err = k.consumer.partitions(topic)
+		partitions, err = k.consumer.Partitions(topic)
 		if err != nil {
 			return nil, nil, err
 		}
 	}
 
-	log.Printf("topic %s has partitions: %v", topic, partitions)
+	slog.Debug("listening to partitions", "topic", topic, "partitions", partitions)
 
-	for _, partitions := range partitions {
+	//All events share one topic, so the events nobody listens to are skipped here.
+	wanted := make(map[string]bool)
+	for _, name := range events {
+		wanted[name] = true
+	}
+
+	for _, partition := range partitions {
 		con, err := k.consumer.ConsumePartition(topic, partition, 0)
 		if err != nil {
 			return nil, nil, err
@@ -55,35 +71,78 @@ func (k *kafkaEventListener) Listen(events ...string) (<-chan msgqueue.Event, <-
 
 		go func() {
 			for msg := range con.Messages() {
-				body := messageEnvelope{}
-				err := json.Unmarshal(msg.Value, &body)
+				event, err := k.decode(msg, wanted)
 				if err != nil {
-					errors <- fmt.Errorf("could not JSON-decode message: %s", err)
+					metrics.MessagesFailed.Inc(eventNameOf(msg), "decode")
+					errors <- err
 					continue
 				}
-
-				var event msgqueue.Event
-				switch body.EventName {
-				case "event.created":
-					event = contracts.EventCreatedEvent{}
-				case "location.created":
-					event = contracts.LocationCreatedEvent{}
-				default:
-					errors <- fmt.Errorf("unknown event type: %s", body.EventName)
-					continue
-				}
-
-				cfg := mapstructure.DecoderConfig{
-					Result:  event,
-					TagName: "json",
+				if event != nil {
+					results <- msgqueue.Delivery{
+						Event:   event,
+						Context: logging.ContinueCorrelation(tracing.Extract(context.Background(), traceparentOf(msg)), headerOf(msg, logging.MessageHeader)),
+					}
+					metrics.Consumed(event.EventName(), msg.Timestamp)
 				}
-				err = mapstructure.NewDecoder(&cfg).Decode(body.Payload)
-				if err != nil {
-					errors <- fmt.Errorf("could not map event %s: %s", body.EventName, err)
-				}
-
-				results <- event
 			}
 		}()
 	}
+
+	return results, errors, nil
+}
+
+//decode decodes a record with the codec named by its content type header, or returns nil if the
+//event is not wanted. Records without an event name header are JSON envelopes of older producers.
+func (k *kafkaEventListener) decode(msg *sarama.ConsumerMessage, wanted map[string]bool) (msgqueue.Event, error) {
+	var eventName, contentType, version string
+	for _, header := range msg.Headers {
+		switch string(header.Key) {
+		case headerEventName:
+			eventName = string(header.Value)
+		case headerContentType:
+			contentType = string(header.Value)
+		case headerVersion:
+			version = string(header.Value)
+		}
+	}
+
+	if eventName == "" {
+		body := messageEnvelope{}
+		err := json.Unmarshal(msg.Value, &body)
+		if err != nil {
+			return nil, fmt.Errorf("could not JSON-decode message: %s", err)
+		}
+		if len(wanted) > 0 && !wanted[body.EventName] {
+			return nil, nil
+		}
+		return k.mapper.MapEvent(body.EventName, []byte(body.Payload))
+	}
+
+	if len(wanted) > 0 && !wanted[eventName] {
+		return nil, nil
+	}
+	schemaVersion, err := msgqueue.ParseSchemaVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return k.mapper.DecodeEvent(eventName, contentType, schemaVersion, msg.Value)
+}
+
+//traceparentOf returns the traceparent header of a record, or "" for records without one.
+func traceparentOf(msg *sarama.ConsumerMessage) string {
+	return headerOf(msg, tracing.Header)
+}
+
+//eventNameOf returns the event name header of a record, or "" for the envelopes of older producers.
+func eventNameOf(msg *sarama.ConsumerMessage) string {
+	return headerOf(msg, headerEventName)
+}
+
+func headerOf(msg *sarama.ConsumerMessage, key string) string {
+	for _, header := range msg.Headers {
+		if string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
 }
@@ -1,6 +1,20 @@
 package kafka
 
+import "encoding/json"
+
+//topic is where all events are written to and read from.
+const topic = "events"
+
+//Record headers that describe the event in the value of a record.
+const (
+	headerEventName   = "x-event-name"
+	headerContentType = "content-type"
+	headerVersion     = "x-schema-version"
+)
+
+//messageEnvelope is how events were sent before they had headers: as JSON, wrapped together with
+//their name. Records without headers are still read this way.
 type messageEnvelope struct {
-	EventName 	string		`json:"eventName"`
-	Payload		interface{} `json:"payload"`
-}
\ No newline at end of file
+	EventName string          `json:"eventName"`
+	Payload   json.RawMessage `json:"payload"`
+}
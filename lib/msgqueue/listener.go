@@ -1,10 +1,34 @@
 package msgqueue
 
+import "context"
+
 //An event listener is typically active for a long time and needs to react to incoming messages whenever
 //they may be recieved. This reflects in the design of our Listen() method: ffirst of all, it will accept
 //a list of names for which the event listener should listen. It will  then return two Go channels: the
-//first will be used to stream any events that were recieved by the listener and the second one will 
-//contain any errors that occurred while receiving those events: 
+//first will be used to stream any events that were recieved by the listener and the second one will
+//contain any errors that occurred while receiving those events:
 type EventListener interface {
 	Listen(eventNames ...string) (<-chan Event, <-chan error, error)
-}
\ No newline at end of file
+	//ListenContext works like Listen, but delivers every event together with the context it was
+	//emitted in, which continues the trace of its producer.
+	ListenContext(eventNames ...string) (<-chan Delivery, <-chan error, error)
+}
+
+//A Delivery is an event as a listener received it.
+type Delivery struct {
+	Event   Event
+	Context context.Context
+}
+
+//Events turns a channel of deliveries into a channel of their events, for listeners that implement
+//Listen with ListenContext.
+func Events(deliveries <-chan Delivery) <-chan Event {
+	events := make(chan Event)
+	go func() {
+		for delivery := range deliveries {
+			events <- delivery.Event
+		}
+		close(events)
+	}()
+	return events
+}
@@ -2,6 +2,9 @@ package msgqueue
 
 type EventMapper interface {
 	MapEvent(string, interface{}) (Event, error)
+	//DecodeEvent decodes the body of a message with the codec of its content type. Bodies of an
+	//older schema version are upcast to the current one.
+	DecodeEvent(eventName string, contentType string, version int, body []byte) (Event, error)
 }
 
 func NewEventMapper() EventMapper {
@@ -9,16 +9,19 @@ import (
 )
 
 type DynamicEventMapper struct {
-	typeMap map[string]reflect.Type
+	typeMap   map[string]reflect.Type
+	upcasters Upcasters
 }
 
 func NewDynamicEventMapper() EventMapper {
 	return &DynamicEventMapper{
-		typeMap: make(map[string]reflect.Type),
+		typeMap:   make(map[string]reflect.Type),
+		upcasters: Upcasters{},
 	}
 }
 
-func (e *DynamicEventMapper) MapEvent(eventName string, serialized interface{}) (Event, error) {
+//newEvent returns an empty event of the type registered for the given name.
+func (e *DynamicEventMapper) newEvent(eventName string) (Event, error) {
 	typ, ok := e.typeMap[eventName]
 	if !ok {
 		return nil, fmt.Errorf("no mapping configured for event %s", eventName)
@@ -31,6 +34,14 @@ func (e *DynamicEventMapper) MapEvent(eventName string, serialized interface{})
 	if !ok {
 		return nil, fmt.Errorf("type %T does not implement the Event interface", iface)
 	}
+	return event, nil
+}
+
+func (e *DynamicEventMapper) MapEvent(eventName string, serialized interface{}) (Event, error) {
+	event, err := e.newEvent(eventName)
+	if err != nil {
+		return nil, err
+	}
 
 	switch s := serialized.(type) {
 	case []byte:
@@ -57,6 +68,14 @@ func (e *DynamicEventMapper) MapEvent(eventName string, serialized interface{})
 	return event, nil
 }
 
+func (e *DynamicEventMapper) DecodeEvent(eventName string, contentType string, version int, body []byte) (Event, error) {
+	event, err := e.newEvent(eventName)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEvent(event, contentType, version, body, e.upcasters)
+}
+
 func (e *DynamicEventMapper) RegisterMapping(eventType reflect.Type) error {
 	instance := reflect.New(eventType).Interface()
 	event, ok := instance.(Event)
@@ -67,3 +86,8 @@ func (e *DynamicEventMapper) RegisterMapping(eventType reflect.Type) error {
 	e.typeMap[event.EventName()] = eventType
 	return nil
 }
+
+//RegisterUpcaster registers the upcaster from the given version of an event to the next one.
+func (e *DynamicEventMapper) RegisterUpcaster(eventName string, from int, upcaster Upcaster) {
+	e.upcasters.Register(eventName, from, upcaster)
+}
@@ -10,19 +10,64 @@ import (
 
 type StaticEventMapper struct{}
 
-func (e *StaticEventMapper) MapEvent(eventName string, serialized interface{}) (Event, error) {
+//newEvent returns an empty event of the given name.
+func (e *StaticEventMapper) newEvent(eventName string) (Event, error) {
 	var event Event
 
 	switch eventName {
-	case "eventCreated":
+	case "eventCreated", "event.created":
 		event = &contracts.EventCreatedEvent{}
-	case "locationCreated":
+	case "event.update":
+		event = &contracts.EventUpdatedEvent{}
+	case "event.cancelled":
+		event = &contracts.EventCancelledEvent{}
+	case "promocode.created":
+		event = &contracts.PromoCodeCreatedEvent{}
+	case "locationCreated", "location.created":
 		event = &contracts.LocationCreatedEvent{}
-	case "eventBooked":
+	case "eventBooked", "event.booked", "booking.created":
 		event = &contracts.EventBookedEvent{}
+	case "booking.held":
+		event = &contracts.BookingHeldEvent{}
+	case "booking.confirmed":
+		event = &contracts.BookingConfirmedEvent{}
+	case "booking.cancelled":
+		event = &contracts.BookingCancelledEvent{}
+	case "booking.expired":
+		event = &contracts.BookingExpiredEvent{}
+	case "waitlist.offered":
+		event = &contracts.WaitlistOfferedEvent{}
+	case "ticket.checked_in":
+		event = &contracts.TicketCheckedInEvent{}
+	case "user.created":
+		event = &contracts.UserCreatedEvent{}
+	case "reminder.due":
+		event = &contracts.ReminderDueEvent{}
+	case "seats.requested":
+		event = &contracts.SeatsRequestedEvent{}
+	case "seats.reserved":
+		event = &contracts.SeatsReservedEvent{}
+	case "seats.rejected":
+		event = &contracts.SeatsRejectedEvent{}
+	case "seats.release":
+		event = &contracts.SeatsReleaseRequestedEvent{}
+	case "payment.succeeded":
+		event = &contracts.PaymentSucceededEvent{}
+	case "payment.failed":
+		event = &contracts.PaymentFailedEvent{}
+	case "payment.refunded":
+		event = &contracts.PaymentRefundedEvent{}
 	default:
 		return nil, fmt.Errorf("unknown event type %s", eventName)
 	}
+	return event, nil
+}
+
+func (e *StaticEventMapper) MapEvent(eventName string, serialized interface{}) (Event, error) {
+	event, err := e.newEvent(eventName)
+	if err != nil {
+		return nil, err
+	}
 
 	switch s := serialized.(type) {
 	case []byte:
@@ -48,3 +93,11 @@ func (e *StaticEventMapper) MapEvent(eventName string, serialized interface{}) (
 
 	return event, nil
 }
+
+func (e *StaticEventMapper) DecodeEvent(eventName string, contentType string, version int, body []byte) (Event, error) {
+	event, err := e.newEvent(eventName)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEvent(event, contentType, version, body, ContractUpcasters)
+}
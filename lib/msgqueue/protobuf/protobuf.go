@@ -0,0 +1,359 @@
+//Package protobuf encodes structs in the wire format of Protocol Buffers (proto3). The fields of a
+//struct are numbered with struct tags, the same way encoding/json names them:
+//
+//	type UserCreatedEvent struct {
+//		ID  string `json:"id" protobuf:"1"`
+//		Age int    `json:"age" protobuf:"4"`
+//	}
+//
+//Fields without a number are left out. A number must never be given to another field later on, so
+//that messages of producers that still use an older version of a struct keep decoding. Fields that
+//are removed simply lose their tag, and fields the decoder does not know are skipped.
+//
+//Go types are encoded as these proto3 types:
+//
+//   - string as string, bool as bool, int and int64 as int64, int32 as int32, []byte as bytes,
+//   - time.Time as google.protobuf.Timestamp, which keeps the instant but not the time zone,
+//   - structs and pointers to structs as messages,
+//   - slices as repeated fields.
+//
+//Schema writes the .proto definitions of the same structs, for consumers in other languages.
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Wire types of the fields we encode.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var (
+	errTruncated = errors.New("protobuf: message is truncated")
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+//Marshal encodes a struct, or a pointer to one.
+func Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("protobuf: cannot marshal %T, only structs", v)
+	}
+	return appendMessage(nil, rv)
+}
+
+//Unmarshal decodes a message into the struct v points to.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("protobuf: cannot unmarshal into %T, only pointers to structs", v)
+	}
+	return decodeMessage(data, rv.Elem())
+}
+
+//field is a numbered field of a struct.
+type field struct {
+	number int
+	index  int
+	name   string //taken from the json tag, used in the schema
+}
+
+type message struct {
+	fields   []field
+	byNumber map[int]field
+}
+
+var messages sync.Map //reflect.Type -> *message
+
+func messageOf(t reflect.Type) (*message, error) {
+	if m, ok := messages.Load(t); ok {
+		return m.(*message), nil
+	}
+	m := &message{byNumber: map[int]field{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("protobuf")
+		if tag == "" {
+			continue
+		}
+		number, err := strconv.Atoi(tag)
+		if err != nil || number <= 0 {
+			return nil, fmt.Errorf("protobuf: field %s of %s has an invalid number %q", f.Name, t.Name(), tag)
+		}
+		if other, ok := m.byNumber[number]; ok {
+			return nil, fmt.Errorf("protobuf: fields %s and %s of %s share the number %d", t.Field(other.index).Name, f.Name, t.Name(), number)
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			name = f.Name
+		}
+		fd := field{number: number, index: i, name: name}
+		m.fields = append(m.fields, fd)
+		m.byNumber[number] = fd
+	}
+	messages.Store(t, m)
+	return m, nil
+}
+
+func appendMessage(b []byte, v reflect.Value) ([]byte, error) {
+	m, err := messageOf(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range m.fields {
+		b, err = appendField(b, f.number, v.Field(f.index), true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+//appendField appends a field with the given number. Like proto3 does, fields that hold their zero
+//value are left out, except for the elements of repeated fields.
+func appendField(b []byte, number int, v reflect.Value, omitZero bool) ([]byte, error) {
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if omitZero && t.IsZero() {
+			return b, nil
+		}
+		var ts []byte
+		ts = appendVarintField(ts, 1, uint64(t.Unix()), true)
+		ts = appendVarintField(ts, 2, uint64(t.Nanosecond()), true)
+		return appendBytesField(b, number, ts), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		if omitZero && v.Len() == 0 {
+			return b, nil
+		}
+		return appendBytesField(b, number, []byte(v.String())), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return appendVarintField(b, number, 1, omitZero), nil
+		}
+		return appendVarintField(b, number, 0, omitZero), nil
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return appendVarintField(b, number, uint64(v.Int()), omitZero), nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return b, nil
+		}
+		return appendField(b, number, v.Elem(), false)
+	case reflect.Struct:
+		msg, err := appendMessage(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		return appendBytesField(b, number, msg), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if omitZero && v.Len() == 0 {
+				return b, nil
+			}
+			return appendBytesField(b, number, v.Bytes()), nil
+		}
+		if isVarint(v.Type().Elem().Kind()) {
+			//Repeated numbers are packed, which is the default of proto3.
+			if v.Len() == 0 {
+				return b, nil
+			}
+			var packed []byte
+			for i := 0; i < v.Len(); i++ {
+				packed = appendVarint(packed, varintOf(v.Index(i)))
+			}
+			return appendBytesField(b, number, packed), nil
+		}
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			b, err = appendField(b, number, v.Index(i), false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("protobuf: cannot marshal field %d of type %s", number, v.Type())
+}
+
+func appendVarintField(b []byte, number int, x uint64, omitZero bool) []byte {
+	if omitZero && x == 0 {
+		return b
+	}
+	b = appendVarint(b, uint64(number)<<3|wireVarint)
+	return appendVarint(b, x)
+}
+
+func appendBytesField(b []byte, number int, data []byte) []byte {
+	b = appendVarint(b, uint64(number)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendVarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(b, buf[:n]...)
+}
+
+func isVarint(k reflect.Kind) bool {
+	return k == reflect.Bool || k == reflect.Int || k == reflect.Int64 || k == reflect.Int32
+}
+
+func varintOf(v reflect.Value) uint64 {
+	if v.Kind() == reflect.Bool {
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	}
+	return uint64(v.Int())
+}
+
+func decodeMessage(data []byte, v reflect.Value) error {
+	m, err := messageOf(v.Type())
+	if err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errTruncated
+		}
+		data = data[n:]
+		number, wire := int(key>>3), int(key&7)
+
+		var raw uint64
+		var payload []byte
+		switch wire {
+		case wireVarint:
+			raw, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errTruncated
+			}
+			raw = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return errTruncated
+			}
+			payload = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errTruncated
+			}
+			raw = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d of field %d", wire, number)
+		}
+
+		f, ok := m.byNumber[number]
+		if !ok {
+			continue
+		}
+		if err := decodeField(v.Field(f.index), wire, raw, payload); err != nil {
+			return fmt.Errorf("protobuf: field %d of %s: %s", number, v.Type().Name(), err)
+		}
+	}
+	return nil
+}
+
+func decodeField(v reflect.Value, wire int, raw uint64, payload []byte) error {
+	if v.Type() == timeType {
+		if wire != wireBytes {
+			return wireTypeError(wire)
+		}
+		var ts struct {
+			Seconds int64 `protobuf:"1"`
+			Nanos   int32 `protobuf:"2"`
+		}
+		if err := decodeMessage(payload, reflect.ValueOf(&ts).Elem()); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(time.Unix(ts.Seconds, int64(ts.Nanos)).UTC()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		if wire != wireBytes {
+			return wireTypeError(wire)
+		}
+		v.SetString(string(payload))
+	case reflect.Bool:
+		if wire != wireVarint {
+			return wireTypeError(wire)
+		}
+		v.SetBool(raw != 0)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		if wire != wireVarint {
+			return wireTypeError(wire)
+		}
+		v.SetInt(int64(raw))
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeField(v.Elem(), wire, raw, payload)
+	case reflect.Struct:
+		if wire != wireBytes {
+			return wireTypeError(wire)
+		}
+		return decodeMessage(payload, v)
+	case reflect.Slice:
+		elemType := v.Type().Elem()
+		if elemType.Kind() == reflect.Uint8 {
+			if wire != wireBytes {
+				return wireTypeError(wire)
+			}
+			v.SetBytes(append([]byte(nil), payload...))
+			return nil
+		}
+		//Repeated numbers may come packed or one by one; decoders have to accept both.
+		if wire == wireBytes && isVarint(elemType.Kind()) {
+			for len(payload) > 0 {
+				x, n := binary.Uvarint(payload)
+				if n <= 0 {
+					return errTruncated
+				}
+				payload = payload[n:]
+				elem := reflect.New(elemType).Elem()
+				decodeField(elem, wireVarint, x, nil)
+				v.Set(reflect.Append(v, elem))
+			}
+			return nil
+		}
+		elem := reflect.New(elemType).Elem()
+		if err := decodeField(elem, wire, raw, payload); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, elem))
+	default:
+		return fmt.Errorf("cannot unmarshal into %s", v.Type())
+	}
+	return nil
+}
+
+func wireTypeError(wire int) error {
+	return fmt.Errorf("unexpected wire type %d", wire)
+}
@@ -0,0 +1,166 @@
+package protobuf
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type seat struct {
+	Number int    `json:"number" protobuf:"1"`
+	Label  string `json:"label" protobuf:"2"`
+}
+
+type message1 struct {
+	Name     string    `json:"name" protobuf:"1"`
+	Count    int       `json:"count" protobuf:"2"`
+	Small    int32     `json:"small" protobuf:"3"`
+	Flag     bool      `json:"flag" protobuf:"4"`
+	Start    time.Time `json:"start_time" protobuf:"5"`
+	Tags     []string  `json:"tags" protobuf:"6"`
+	Numbers  []int     `json:"numbers" protobuf:"7"`
+	Best     *seat     `json:"bestSeat" protobuf:"8"`
+	Seats    []seat    `json:"seats" protobuf:"9"`
+	Raw      []byte    `json:"raw" protobuf:"10"`
+	Internal string    `json:"-"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := message1{
+		Name:     "Opera night",
+		Count:    -42,
+		Small:    7,
+		Flag:     true,
+		Start:    time.Date(2026, 3, 2, 19, 30, 0, 500, time.UTC),
+		Tags:     []string{"music", "", "evening"},
+		Numbers:  []int{1, 300, -1},
+		Best:     &seat{Number: 12, Label: "A12"},
+		Seats:    []seat{{Number: 1}, {Number: 2, Label: "B2"}},
+		Raw:      []byte{0, 1, 2},
+		Internal: "left out",
+	}
+	data, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out message1
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Internal = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("expected %+v, got %+v", in, out)
+	}
+}
+
+func TestWireFormat(t *testing.T) {
+	//The examples of the protobuf encoding guide: a string and the varint 150.
+	data, err := Marshal(struct {
+		Name  string `protobuf:"1"`
+		Count int    `protobuf:"2"`
+		Empty string `protobuf:"3"`
+	}{Name: "testing", Count: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x0a, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g', 0x10, 0x96, 0x01}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("expected % x, got % x", expected, data)
+	}
+}
+
+func TestUnknownFieldsAreSkipped(t *testing.T) {
+	data, err := Marshal(message1{Name: "kept", Count: 3, Best: &seat{Number: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var older struct {
+		Count int `protobuf:"2"`
+	}
+	if err := Unmarshal(data, &older); err != nil {
+		t.Fatal(err)
+	}
+	if older.Count != 3 {
+		t.Errorf("expected count 3, got %d", older.Count)
+	}
+}
+
+func TestUnpackedNumbers(t *testing.T) {
+	//Field 7 sent one number at a time, as older proto2 producers do.
+	data := []byte{0x38, 0x01, 0x38, 0x02}
+	var out message1
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Numbers, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", out.Numbers)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data, _ := Marshal(message1{Name: "Opera night"})
+	var out message1
+	if err := Unmarshal(data[:len(data)-2], &out); err == nil {
+		t.Error("expected an error for a truncated message")
+	}
+}
+
+func TestInvalidTags(t *testing.T) {
+	_, err := Marshal(struct {
+		A string `protobuf:"1"`
+		B string `protobuf:"1"`
+	}{})
+	if err == nil {
+		t.Error("expected an error for fields that share a number")
+	}
+	_, err = Marshal(struct {
+		A map[string]string `protobuf:"1"`
+	}{A: map[string]string{"a": "b"}})
+	if err == nil {
+		t.Error("expected an error for a map")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Schema(&buf, "test", "example.com/test", &message1{}); err != nil {
+		t.Fatal(err)
+	}
+	schema := buf.String()
+	for _, line := range []string{
+		`import "google/protobuf/timestamp.proto";`,
+		"message message1 {",
+		"  int64 count = 2;",
+		"  int32 small = 3;",
+		"  google.protobuf.Timestamp start_time = 5;",
+		"  repeated string tags = 6;",
+		"  seat best_seat = 8;",
+		"  repeated seat seats = 9;",
+		"  bytes raw = 10;",
+		"message seat {",
+	} {
+		if !strings.Contains(schema, line) {
+			t.Errorf("schema does not contain %q:\n%s", line, schema)
+		}
+	}
+	if strings.Count(schema, "message seat {") != 1 {
+		t.Errorf("expected seat to be defined once:\n%s", schema)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, expected := range map[string]string{
+		"eventId":     "event_id",
+		"start_time":  "start_time",
+		"ID":          "id",
+		"seatIds":     "seat_ids",
+		"HTTPAddress": "http_address",
+	} {
+		if got := snakeCase(in); got != expected {
+			t.Errorf("snakeCase(%q) is %q, expected %q", in, got, expected)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package protobuf
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+//Schema writes a .proto file with the definitions of the given messages, and of the messages they
+//embed, to w. Messages are named like their Go types and fields like their json names in
+//snake_case, so every json name has to be unique within its message.
+func Schema(w io.Writer, pkg string, goPackage string, messages ...interface{}) error {
+	s := schema{seen: map[reflect.Type]bool{}}
+	for _, m := range messages {
+		t := reflect.TypeOf(m)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if err := s.add(t); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "syntax = \"proto3\";\n\npackage %s;\n\n", pkg)
+	if s.usesTime {
+		fmt.Fprintf(w, "import \"google/protobuf/timestamp.proto\";\n\n")
+	}
+	fmt.Fprintf(w, "option go_package = %q;\n", goPackage)
+	for _, def := range s.defs {
+		if _, err := fmt.Fprintf(w, "\n%s", def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type schema struct {
+	seen     map[reflect.Type]bool
+	defs     []string
+	usesTime bool
+}
+
+func (s *schema) add(t reflect.Type) error {
+	if s.seen[t] {
+		return nil
+	}
+	s.seen[t] = true
+	m, err := messageOf(t)
+	if err != nil {
+		return err
+	}
+
+	var def strings.Builder
+	fmt.Fprintf(&def, "message %s {\n", t.Name())
+	var nested []reflect.Type
+	for _, f := range m.fields {
+		ft := t.Field(f.index).Type
+		repeated := ""
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+			repeated = "repeated "
+			ft = ft.Elem()
+		}
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		typ, err := s.typeName(ft)
+		if err != nil {
+			return fmt.Errorf("protobuf: field %s of %s: %s", t.Field(f.index).Name, t.Name(), err)
+		}
+		if ft.Kind() == reflect.Struct && ft != timeType {
+			nested = append(nested, ft)
+		}
+		fmt.Fprintf(&def, "  %s%s %s = %d;\n", repeated, typ, snakeCase(f.name), f.number)
+	}
+	def.WriteString("}\n")
+	s.defs = append(s.defs, def.String())
+
+	for _, n := range nested {
+		if err := s.add(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *schema) typeName(t reflect.Type) (string, error) {
+	if t == timeType {
+		s.usesTime = true
+		return "google.protobuf.Timestamp", nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string", nil
+	case reflect.Bool:
+		return "bool", nil
+	case reflect.Int, reflect.Int64:
+		return "int64", nil
+	case reflect.Int32:
+		return "int32", nil
+	case reflect.Struct:
+		return t.Name(), nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes", nil
+		}
+	}
+	return "", fmt.Errorf("type %s has no protobuf equivalent", t)
+}
+
+//snakeCase turns json names such as "eventId" into "event_id".
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			//Runs of capitals such as in "ID" stay one word.
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -1,20 +1,29 @@
 package sqs
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/base64"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 )
 
 type SQSEmitter struct {
 	sqsSvc   *sqs.SQS
 	QueueURL *string
+	codec    msgqueue.Codec
+	ctx      context.Context
 }
 
-func NewSQSEventEmitter(s *session.Session, queueName string) (emitter msgqueue.EventEmitter, err error) {
+//NewSQSEventEmitter returns an emitter that encodes events with the given codec, or with JSON if it
+//is nil.
+func NewSQSEventEmitter(s *session.Session, queueName string, codec msgqueue.Codec) (emitter msgqueue.EventEmitter, err error) {
 	if s == nil {
 		s, err = session.NewSession()
 		if err != nil {
@@ -28,27 +37,73 @@ func NewSQSEventEmitter(s *session.Session, queueName string) (emitter msgqueue.
 	if err != nil {
 		return
 	}
+	if codec == nil {
+		codec = msgqueue.JSONCodec
+	}
 	emitter = &SQSEmitter{
 		sqsSvc:   svc,
 		QueueURL: QUResult.QueueUrl,
+		codec:    codec,
 	}
 	return
 }
 
-func (sqsEmit *SQSEmitter) Emit(event msgqueue.Event) error {
-	data, err := json.Marshal(event)
+//WithContext returns an emitter that sends to the same queue, and emits its events as part of the
+//trace the context carries.
+func (sqsEmit *SQSEmitter) WithContext(ctx context.Context) msgqueue.EventEmitter {
+	bound := *sqsEmit
+	bound.ctx = ctx
+	return &bound
+}
+
+func (sqsEmit *SQSEmitter) Emit(event msgqueue.Event) (err error) {
+	_, span := tracing.Start(sqsEmit.ctx, "send "+event.EventName(), tracing.Producer)
+	span.SetAttribute("messaging.system", "aws_sqs")
+	span.SetAttribute("messaging.destination", aws.StringValue(sqsEmit.QueueURL))
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+		metrics.Emitted(event.EventName(), err)
+	}()
+
+	data, err := sqsEmit.codec.Encode(event)
 	if err != nil {
 		return err
 	}
-	_, err = sqsEmit.sqsSvc.SendMessage(&sqs.SendMessageInput{
-		MessageAttributes: map[string]*sqs.MessageAttributeValue{
-			"event_name": &sqs.MessageAttributeValue{
-				DataType:    aws.String("string"),
-				StringValue: aws.String(event.EventName()),
-			},
+	//Message bodies have to be text, so binary encodings are sent in base64.
+	body := string(data)
+	if sqsEmit.codec.ContentType() != msgqueue.ContentTypeJSON {
+		body = base64.StdEncoding.EncodeToString(data)
+	}
+	attributes := map[string]*sqs.MessageAttributeValue{
+		"event_name": &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(event.EventName()),
+		},
+		"content_type": &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(sqsEmit.codec.ContentType()),
 		},
-		MessageBody: aws.String(string(data)),
-		QueueUrl:    sqsEmit.QueueURL,
+		"schema_version": &sqs.MessageAttributeValue{
+			DataType:    aws.String("Number"),
+			StringValue: aws.String(strconv.Itoa(msgqueue.SchemaVersion(event))),
+		},
+		tracing.Header: &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(span.Context.Traceparent()),
+		},
+	}
+	//Attributes can't be empty, so events emitted outside of a correlation go without one.
+	if correlationID := logging.CorrelationID(sqsEmit.ctx); correlationID != "" {
+		attributes[logging.MessageHeader] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(correlationID),
+		}
+	}
+	_, err = sqsEmit.sqsSvc.SendMessage(&sqs.SendMessageInput{
+		MessageAttributes: attributes,
+		MessageBody:       aws.String(body),
+		QueueUrl:          sqsEmit.QueueURL,
 	})
 	return err
 }
@@ -1,13 +1,20 @@
 package sqs
 
 import (
+	"context"
+	"encoding/base64"
 	"errors"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 )
 
 type SQSListener struct {
@@ -45,26 +52,36 @@ func NewSQSListener(s *session.Session, queueName string, maxMsgs, wtTime, visTO
 }
 
 func (sqsListener *SQSListener) Listen(events ...string) (<-chan msgqueue.Event, <-chan error, error) {
+	deliveries, errorCh, err := sqsListener.ListenContext(events...)
+	return msgqueue.Events(deliveries), errorCh, err
+}
+
+//ListenContext listens like Listen, and continues the trace named by the traceparent attribute of
+//every message.
+func (sqsListener *SQSListener) ListenContext(events ...string) (<-chan msgqueue.Delivery, <-chan error, error) {
 	if sqsListener == nil {
 		return nil, nil, errors.New("SQSListener: the Listen() method was called on a nil pointer")
 	}
-	eventCh := make(chan msgqueue.Event)
+	deliveryCh := make(chan msgqueue.Delivery)
 	errorCh := make(chan error)
 	go func() {
 		for {
-			sqsListener.receiveMessage(eventCh, errorCh)
+			sqsListener.receiveMessage(deliveryCh, errorCh, events...)
 		}
 	}()
 
-	return eventCh, errorCh, nil
+	return deliveryCh, errorCh, nil
 }
 
-func (sqsListener *SQSListener) receiveMessage(eventCh chan msgqueue.Event, errorCh chan error, events ...string) {
+func (sqsListener *SQSListener) receiveMessage(deliveryCh chan msgqueue.Delivery, errorCh chan error, events ...string) {
 	//First, we receive messages and pass any errors to a Go error channel:
 	recvMsgResult, err := sqsListener.sqsSvc.ReceiveMessage(&sqs.ReceiveMessageInput{
 		MessageAttributeNames: []*string{
 			aws.String(sqs.QueueAttributeNameAll),
 		},
+		AttributeNames: []*string{
+			aws.String(sqs.MessageSystemAttributeNameSentTimestamp),
+		},
 		QueueUrl:            sqsListener.queueURL,
 		MaxNumberOfMessages: aws.Int64(sqsListener.maxNumberOfMessages),
 		WaitTimeSeconds:     aws.Int64(sqsListener.waitTime),
@@ -72,16 +89,17 @@ func (sqsListener *SQSListener) receiveMessage(eventCh chan msgqueue.Event, erro
 	})
 	if err != nil {
 		errorCh <- err
+		return
 	}
 
 	//We then go through the received messages one by one and check their message attributes, if the event name does not
 	//belong to the list of requested event names, we ignore it by moving to the next message:
-	bContinue := false
 	for _, msg := range recvMsgResult.Messages {
 		value, ok := msg.MessageAttributes["event_name"]
 		if !ok {
 			continue
 		}
+		bContinue := false
 		eventName := aws.StringValue(value.StringValue)
 		for _, event := range events {
 			if strings.EqualFold(eventName, event) {
@@ -99,13 +117,49 @@ func (sqsListener *SQSListener) receiveMessage(eventCh chan msgqueue.Event, erro
 		//then it returns an Event object to us. After that, we obtain the event object and pass it to the events channel. If we
 		//detect errors, we pass the error to the errors channel, then move to the next message:
 
-		message := aws.StringValue(msg.Body)
-		event, err := sqsListener.mapper.MapEvent(eventName, []byte(message))
+		//Messages without a content type were sent as JSON, before other codecs could be chosen.
+		message := []byte(aws.StringValue(msg.Body))
+		contentType := ""
+		if value, ok := msg.MessageAttributes["content_type"]; ok {
+			contentType = aws.StringValue(value.StringValue)
+		}
+		if contentType != "" && contentType != msgqueue.ContentTypeJSON {
+			message, err = base64.StdEncoding.DecodeString(string(message))
+			if err != nil {
+				metrics.MessagesFailed.Inc(eventName, "decode")
+				errorCh <- err
+				continue
+			}
+		}
+		version := ""
+		if value, ok := msg.MessageAttributes["schema_version"]; ok {
+			version = aws.StringValue(value.StringValue)
+		}
+		schemaVersion, err := msgqueue.ParseSchemaVersion(version)
 		if err != nil {
+			metrics.MessagesFailed.Inc(eventName, "decode")
 			errorCh <- err
 			continue
 		}
-		eventCh <- event
+		event, err := sqsListener.mapper.DecodeEvent(eventName, contentType, schemaVersion, message)
+		if err != nil {
+			metrics.MessagesFailed.Inc(eventName, "decode")
+			errorCh <- err
+			continue
+		}
+		traceparent := ""
+		if value, ok := msg.MessageAttributes[tracing.Header]; ok {
+			traceparent = aws.StringValue(value.StringValue)
+		}
+		correlationID := ""
+		if value, ok := msg.MessageAttributes[logging.MessageHeader]; ok {
+			correlationID = aws.StringValue(value.StringValue)
+		}
+		deliveryCh <- msgqueue.Delivery{
+			Event:   event,
+			Context: logging.ContinueCorrelation(tracing.Extract(context.Background(), traceparent), correlationID),
+		}
+		metrics.Consumed(eventName, sentAt(msg))
 
 		//Finally, if we reach to this point without errors, then we know we succeeded in processing the message. So, the next
 		//step will be to delete the message so that it won't be processed by someone else:
@@ -120,3 +174,12 @@ func (sqsListener *SQSListener) receiveMessage(eventCh chan msgqueue.Event, erro
 		}
 	}
 }
+
+//sentAt returns the time SQS received a message from its producer, or the zero time if it is unknown.
+func sentAt(msg *sqs.Message) time.Time {
+	millis, err := strconv.ParseInt(aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
@@ -0,0 +1,112 @@
+package msgqueue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+//An Upcaster turns the JSON document of an event into the document of the next version of its
+//schema, for example by renaming a field or converting a value:
+//
+//	ContractUpcasters.Register("event.booked", 1, func(doc map[string]interface{}) error {
+//		if total, ok := doc["totalPrice"]; ok {
+//			doc["total"] = total
+//			delete(doc, "totalPrice")
+//		}
+//		return nil
+//	})
+//
+//Events encoded with protobuf are first decoded into the current type, since protobuf keeps their
+//fields by number, and upcast from the JSON document of that. Upcasters therefore check what a
+//document holds instead of assuming its layout.
+type Upcaster func(doc map[string]interface{}) error
+
+//Upcasters holds the upcasters of events by event name and by the version they upcast from.
+type Upcasters map[string]map[int]Upcaster
+
+//ContractUpcasters are the upcasters of the contracts. Whenever the version of a contract is bumped,
+//an upcaster from the previous version is registered here; the schema checker of the contracts
+//makes sure none is missing.
+var ContractUpcasters = Upcasters{}
+
+//Register registers the upcaster from the given version of an event to the next one.
+func (u Upcasters) Register(eventName string, from int, upcaster Upcaster) {
+	if u[eventName] == nil {
+		u[eventName] = map[int]Upcaster{}
+	}
+	u[eventName][from] = upcaster
+}
+
+//Has reports whether there is an upcaster from the given version of an event to the next one.
+func (u Upcasters) Has(eventName string, from int) bool {
+	_, ok := u[eventName][from]
+	return ok
+}
+
+//Upcast turns the document of an event from one version into another, one version at a time.
+func (u Upcasters) Upcast(eventName string, from int, to int, doc map[string]interface{}) error {
+	for version := from; version < to; version++ {
+		upcaster, ok := u[eventName][version]
+		if !ok {
+			return fmt.Errorf("cannot upcast event %s from version %d", eventName, version)
+		}
+		if err := upcaster(doc); err != nil {
+			return fmt.Errorf("could not upcast event %s from version %d: %s", eventName, version, err)
+		}
+	}
+	return nil
+}
+
+//decodeEvent decodes the body of a message into the given empty event, with the codec of the content
+//type. Bodies of an older schema version are upcast first.
+func decodeEvent(event Event, contentType string, version int, body []byte, upcasters Upcasters) (Event, error) {
+	codec, err := CodecFor(contentType)
+	if err != nil {
+		return nil, err
+	}
+	current := SchemaVersion(event)
+	if version < 1 {
+		version = 1
+	}
+	if version > current {
+		return nil, fmt.Errorf("event %s has schema version %d, but only versions up to %d are known", event.EventName(), version, current)
+	}
+	if version == current {
+		if err := codec.Decode(body, event); err != nil {
+			return nil, fmt.Errorf("could not decode event %s: %s", event.EventName(), err)
+		}
+		return event, nil
+	}
+
+	if codec.ContentType() != ContentTypeJSON {
+		if err := codec.Decode(body, event); err != nil {
+			return nil, fmt.Errorf("could not decode event %s: %s", event.EventName(), err)
+		}
+		if body, err = json.Marshal(event); err != nil {
+			return nil, err
+		}
+	}
+	doc := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	//Numbers are kept as they were sent, so that large integers survive the round trip.
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("could not decode event %s: %s", event.EventName(), err)
+	}
+	if err := upcasters.Upcast(event.EventName(), version, current, doc); err != nil {
+		return nil, err
+	}
+	body, err = json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	//The event may hold what protobuf decoded, including fields the upcasters removed.
+	reset := reflect.ValueOf(event).Elem()
+	reset.Set(reflect.Zero(reset.Type()))
+	if err := json.Unmarshal(body, event); err != nil {
+		return nil, fmt.Errorf("could not decode upcast event %s: %s", event.EventName(), err)
+	}
+	return event, nil
+}
@@ -0,0 +1,102 @@
+package msgqueue
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//booked is version 2 of a booking: version 1 called the total totalPrice and counted it in whole
+//euros.
+type booked struct {
+	ID    string `json:"id" protobuf:"1"`
+	Total int64  `json:"total" protobuf:"3"`
+}
+
+func (b *booked) EventName() string  { return "test.booked" }
+func (b *booked) SchemaVersion() int { return 2 }
+
+func upcastingMapper() *DynamicEventMapper {
+	mapper := NewDynamicEventMapper().(*DynamicEventMapper)
+	mapper.RegisterMapping(reflect.TypeOf(booked{}))
+	mapper.RegisterUpcaster("test.booked", 1, func(doc map[string]interface{}) error {
+		if total, ok := doc["totalPrice"]; ok {
+			euros, err := total.(interface{ Int64() (int64, error) }).Int64()
+			if err != nil {
+				return err
+			}
+			doc["total"] = euros * 100
+			delete(doc, "totalPrice")
+		}
+		return nil
+	})
+	return mapper
+}
+
+func TestUpcast(t *testing.T) {
+	mapper := upcastingMapper()
+	event, err := mapper.DecodeEvent("test.booked", ContentTypeJSON, 1, []byte(`{"id":"b1","totalPrice":45}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := event.(*booked); b.ID != "b1" || b.Total != 4500 {
+		t.Errorf("expected b1 with a total of 4500, got %+v", b)
+	}
+
+	//Events of the current version are not touched.
+	event, err = mapper.DecodeEvent("test.booked", ContentTypeJSON, 2, []byte(`{"id":"b2","total":45}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := event.(*booked); b.Total != 45 {
+		t.Errorf("expected a total of 45, got %+v", b)
+	}
+}
+
+func TestUpcastProtobuf(t *testing.T) {
+	//Version 1 sent the total in euros as field 3 already.
+	body, err := ProtobufCodec.Encode(&booked{ID: "b1", Total: 45})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapper := upcastingMapper()
+	mapper.RegisterUpcaster("test.booked", 1, func(doc map[string]interface{}) error {
+		euros, _ := doc["total"].(interface{ Int64() (int64, error) }).Int64()
+		doc["total"] = euros * 100
+		return nil
+	})
+	event, err := mapper.DecodeEvent("test.booked", ContentTypeProtobuf, 1, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := event.(*booked); b.ID != "b1" || b.Total != 4500 {
+		t.Errorf("expected b1 with a total of 4500, got %+v", b)
+	}
+}
+
+func TestUnknownVersions(t *testing.T) {
+	mapper := upcastingMapper()
+	_, err := mapper.DecodeEvent("test.booked", ContentTypeJSON, 3, []byte(`{"id":"b1"}`))
+	if err == nil || !strings.Contains(err.Error(), "version 3") {
+		t.Errorf("expected an error for a newer version, got %v", err)
+	}
+
+	mapper = NewDynamicEventMapper().(*DynamicEventMapper)
+	mapper.RegisterMapping(reflect.TypeOf(booked{}))
+	if _, err := mapper.DecodeEvent("test.booked", ContentTypeJSON, 1, []byte(`{"id":"b1"}`)); err == nil {
+		t.Error("expected an error for a version without upcaster")
+	}
+}
+
+func TestParseSchemaVersion(t *testing.T) {
+	for s, expected := range map[string]int{"": 1, "1": 1, "3": 3} {
+		if version, err := ParseSchemaVersion(s); err != nil || version != expected {
+			t.Errorf("expected %d for %q, got %d (%v)", expected, s, version, err)
+		}
+	}
+	for _, s := range []string{"0", "-1", "two"} {
+		if _, err := ParseSchemaVersion(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRenderFallsBackToLanguage(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"en/booking.confirmed.txt":  `{{define "subject"}}Your booking for {{.Event}}{{end}}{{define "body"}}Total: {{money .Total "EUR"}}{{end}}`,
+		"en/booking.confirmed.html": `<p>{{.Event}}</p>`,
+		"de/booking.confirmed.txt":  `{{define "subject"}}Deine Buchung für {{.Event}}{{end}}{{define "body"}}Summe: {{money .Total "EUR"}}{{end}}`,
+	})
+	defer os.RemoveAll(dir)
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{"Event": "Jazz & Blues", "Total": int64(4250)}
+
+	msg, err := templates.Render("booking.confirmed", "de-AT", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Deine Buchung für Jazz & Blues" || msg.Text != "Summe: 42,50 EUR\n" {
+		t.Errorf("expected the German templates, got %+v", msg)
+	}
+	if msg.HTML != "" {
+		t.Errorf("expected no HTML for a locale without an HTML template, got %q", msg.HTML)
+	}
+
+	msg, err = templates.Render("booking.confirmed", "pt", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Text != "Total: 42.50 EUR\n" || msg.HTML != "<p>Jazz &amp; Blues</p>" {
+		t.Errorf("expected the default templates with escaped HTML, got %+v", msg)
+	}
+}
+
+func TestLoadTemplatesNeedsSubjectAndBody(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{"en/welcome.txt": `Hello`})
+	defer os.RemoveAll(dir)
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("expected templates without a subject to be rejected")
+	}
+}
+
+//smtpStandIn accepts a single email the way a local mail catcher would, and hands over the data
+//of the message.
+func smtpStandIn(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		data := &strings.Builder{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestSMTPSender(t *testing.T) {
+	addr, received := smtpStandIn(t)
+	sender := &SMTPSender{Addr: addr, From: "MyEvents <noreply@myevents.example>"}
+	err := sender.Send(Email{
+		To:        "jane@example.com",
+		Subject:   "Deine Buchung für Jazz",
+		Text:      "Summe: 42,50 EUR",
+		HTML:      "<p>Jazz</p>",
+		MessageID: "booking.confirmed/5d1f@notifications.myevents",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := <-received
+	for _, part := range []string{
+		"To: jane@example.com\r\n",
+		"Subject: =?utf-8?q?Deine_Buchung_f=C3=BCr_Jazz?=\r\n",
+		"Message-ID: <booking.confirmed/5d1f@notifications.myevents>\r\n",
+		"Content-Type: multipart/alternative; boundary=",
+		"Summe: 42,50 EUR",
+		"<p>Jazz</p>",
+	} {
+		if !strings.Contains(msg, part) {
+			t.Errorf("expected %q in\n%s", part, msg)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+//Email is a message to a single recipient.
+type Email struct {
+	To        string
+	Subject   string
+	Text      string
+	HTML      string //sent as an alternative to the text if it is set
+	MessageID string //lets mail clients drop an email that was sent twice
+}
+
+type Sender interface {
+	Send(Email) error
+}
+
+//SMTPSender sends emails through an SMTP server. Local stand-ins like MailHog, which accept mail
+//without authentication, work just as well as a real mail server.
+type SMTPSender struct {
+	Addr     string //host:port of the server
+	Username string //leave empty for servers that don't require authentication
+	Password string
+	From     string //like MyEvents <noreply@myevents.example>
+}
+
+func (s *SMTPSender) Send(email Email) error {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return err
+	}
+	msg, err := s.message(email)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := s.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, from.Address, []string{email.To}, msg)
+}
+
+//message builds the email as it goes over the wire: a text part, and an HTML part as an
+//alternative if there is one.
+func (s *SMTPSender) message(email Email) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	header := func(name string, value string) {
+		fmt.Fprintf(buf, "%s: %s\r\n", name, value)
+	}
+	header("From", s.From)
+	header("To", email.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", email.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	if email.MessageID != "" {
+		header("Message-ID", "<"+email.MessageID+">")
+	}
+	header("MIME-Version", "1.0")
+
+	if email.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(buf, email.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(buf)
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
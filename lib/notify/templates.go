@@ -0,0 +1,179 @@
+//Package notify renders notification emails from templates and sends them through SMTP.
+//
+//Templates live in a directory per locale, like templates/en or templates/de-AT. Every kind of
+//notification has a text template, <kind>.txt, which defines a "subject" and a "body" template,
+//and can have an HTML template, <kind>.html, for the HTML part of the email. A locale that has no
+//template for a kind falls back to its language (de-AT to de) and then to the default locale.
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+//DefaultLocale is used for users without a locale, and when no template matches their locale.
+const DefaultLocale = "en"
+
+//dateLayouts are the layouts dates are formatted with in the templates of a language.
+var dateLayouts = map[string]string{
+	"en": "Monday, January 2, 2006 at 3:04 PM MST",
+	"de": "02.01.2006 um 15:04 Uhr MST",
+	"fr": "02/01/2006 à 15h04 MST",
+}
+
+//Message is a rendered notification.
+type Message struct {
+	Subject string
+	Text    string
+	HTML    string //empty if the kind has no HTML template
+}
+
+type localeTemplates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+type Templates struct {
+	locales map[string]localeTemplates
+}
+
+//LoadTemplates reads the templates of every locale below the directory.
+func LoadTemplates(dir string) (*Templates, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	t := &Templates{locales: map[string]localeTemplates{}}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		locale := strings.ToLower(entry.Name())
+		lt, err := loadLocale(filepath.Join(dir, entry.Name()), locale)
+		if err != nil {
+			return nil, err
+		}
+		t.locales[locale] = lt
+	}
+	if _, ok := t.locales[DefaultLocale]; !ok {
+		return nil, fmt.Errorf("no templates for the default locale %s in %s", DefaultLocale, dir)
+	}
+	return t, nil
+}
+
+func loadLocale(dir string, locale string) (localeTemplates, error) {
+	lt := localeTemplates{
+		text: map[string]*texttemplate.Template{},
+		html: map[string]*htmltemplate.Template{},
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return lt, err
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		switch ext := filepath.Ext(file.Name()); ext {
+		case ".txt":
+			tmpl, err := texttemplate.New(file.Name()).Funcs(texttemplate.FuncMap(funcs(locale))).ParseFiles(path)
+			if err != nil {
+				return lt, err
+			}
+			if tmpl.Lookup("subject") == nil || tmpl.Lookup("body") == nil {
+				return lt, fmt.Errorf("%s has to define a subject and a body template", path)
+			}
+			lt.text[strings.TrimSuffix(file.Name(), ext)] = tmpl
+		case ".html":
+			tmpl, err := htmltemplate.New(file.Name()).Funcs(htmltemplate.FuncMap(funcs(locale))).ParseFiles(path)
+			if err != nil {
+				return lt, err
+			}
+			lt.html[strings.TrimSuffix(file.Name(), ext)] = tmpl
+		}
+	}
+	return lt, nil
+}
+
+//Render renders the notification of the given kind in the language of the locale.
+func (t *Templates) Render(kind string, locale string, data interface{}) (Message, error) {
+	lt, ok := t.lookup(kind, locale)
+	if !ok {
+		return Message{}, fmt.Errorf("no template for notifications of kind %s", kind)
+	}
+	subject, body := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := lt.text[kind].ExecuteTemplate(subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := lt.text[kind].ExecuteTemplate(body, "body", data); err != nil {
+		return Message{}, err
+	}
+	msg := Message{
+		//Subjects are a single line, no matter how the template is laid out.
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(body.String()) + "\n",
+	}
+	if html, ok := lt.html[kind]; ok {
+		buf := &bytes.Buffer{}
+		if err := html.Execute(buf, data); err != nil {
+			return Message{}, err
+		}
+		msg.HTML = buf.String()
+	}
+	return msg, nil
+}
+
+//lookup finds the templates of a kind for the locale, its language or the default locale.
+func (t *Templates) lookup(kind string, locale string) (localeTemplates, bool) {
+	locale = strings.ToLower(strings.Replace(locale, "_", "-", -1))
+	candidates := []string{locale}
+	if i := strings.Index(locale, "-"); i > 0 {
+		candidates = append(candidates, locale[:i])
+	}
+	candidates = append(candidates, DefaultLocale)
+	for _, candidate := range candidates {
+		if lt, ok := t.locales[candidate]; ok && lt.text[kind] != nil {
+			return lt, true
+		}
+	}
+	return localeTemplates{}, false
+}
+
+//funcs are the functions the templates of a locale can use.
+func funcs(locale string) map[string]interface{} {
+	layout, ok := dateLayouts[locale]
+	if !ok {
+		if i := strings.Index(locale, "-"); i > 0 {
+			layout, ok = dateLayouts[locale[:i]]
+		}
+	}
+	if !ok {
+		layout = dateLayouts[DefaultLocale]
+	}
+	return map[string]interface{}{
+		"date": func(t time.Time) string {
+			return t.Format(layout)
+		},
+		"money": func(amount int64, currency string) string {
+			return formatMoney(amount, currency, locale)
+		},
+	}
+}
+
+//formatMoney formats an amount in minor units, with the decimal separator of the locale.
+func formatMoney(amount int64, currency string, locale string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	separator := "."
+	if !strings.HasPrefix(locale, "en") {
+		separator = ","
+	}
+	return fmt.Sprintf("%s%d%s%02d %s", sign, amount/100, separator, amount%100, currency)
+}
@@ -0,0 +1,265 @@
+//Package openapi describes the REST APIs of the services with OpenAPI 3.1 documents. The schemas of
+//request and response bodies are generated from the Go types the handlers decode and encode, see
+//jsonschema.Describe, so the document can't drift from the bodies. CheckRoutes keeps it from
+//drifting from the routes.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/health"
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue/jsonschema"
+	"github.com/gorilla/mux"
+)
+
+//Version is the version of OpenAPI the documents follow. 3.1 is the first version whose schemas are
+//JSON Schema, which is what jsonschema generates.
+const Version = "3.1.0"
+
+type Document struct {
+	OpenAPI string               `json:"openapi"`
+	Info    Info                 `json:"info"`
+	Paths   map[string]*PathItem `json:"paths"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+//PathItem holds the operations of a path by their lowercase method, like get or post.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string             `json:"name"`
+	In          string             `json:"in"` //path or query
+	Description string             `json:"description,omitempty"`
+	Required    bool               `json:"required"`
+	Schema      *jsonschema.Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *jsonschema.Schema `json:"schema"`
+}
+
+//New returns an empty document of an API.
+func New(title string, version string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title, Version: version},
+		Paths:   map[string]*PathItem{},
+	}
+}
+
+//pathParameter matches the variables of a path, {eventID}, and of a mux route template, which can
+//have a pattern like {number:[0-9]+}.
+var pathParameter = regexp.MustCompile(`\{([^}:]+)(:[^}]+)?\}`)
+
+//Add adds the operation of a method on a path and returns it, so that it can be described further.
+//The variables of the path become its path parameters. Since any request can fail on the side of
+//the service, every operation can answer with 500.
+func (d *Document) Add(method string, path string, operationID string, summary string) *Operation {
+	item, ok := d.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+	op := &Operation{
+		OperationID: operationID,
+		Summary:     summary,
+		Responses:   map[string]*Response{},
+	}
+	for _, match := range pathParameter.FindAllStringSubmatch(path, -1) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     match[1],
+			In:       "path",
+			Required: true,
+			Schema:   &jsonschema.Schema{Type: "string"},
+		})
+	}
+	(*item)[strings.ToLower(method)] = op
+	return op.Fails(http.StatusInternalServerError)
+}
+
+//AddStandard adds the operations every service has: its metrics, its health checks, and the
+//document itself.
+func (d *Document) AddStandard() {
+	d.Add("GET", "/metrics", "metrics", "Metrics of the service in the Prometheus text format").
+		Tag("operations").
+		ReturnsFile(http.StatusOK, "text/plain")
+	d.Add("GET", "/healthz", "liveness", "Whether the service is alive").
+		Tag("operations").
+		Returns(http.StatusOK, health.Report{}).
+		Returns(http.StatusServiceUnavailable, health.Report{})
+	d.Add("GET", "/readyz", "readiness", "Whether the service and its dependencies are ready to take requests").
+		Tag("operations").
+		Returns(http.StatusOK, health.Report{}).
+		Returns(http.StatusServiceUnavailable, health.Report{})
+	d.Add("GET", "/openapi.json", "openapi", "This document").
+		Tag("operations").
+		ReturnsFile(http.StatusOK, "application/json")
+}
+
+//Tag files the operation under the given tags, which documentation tools group operations by.
+func (op *Operation) Tag(tags ...string) *Operation {
+	op.Tags = append(op.Tags, tags...)
+	return op
+}
+
+//Query adds an optional query parameter.
+func (op *Operation) Query(name string, description string) *Operation {
+	op.Parameters = append(op.Parameters, Parameter{
+		Name:        name,
+		In:          "query",
+		Description: description,
+		Schema:      &jsonschema.Schema{Type: "string"},
+	})
+	return op
+}
+
+//Accepts describes the JSON body of the operation with the type of v. Bodies are decoded with
+//httpapi.Decode, so the operation fails the way Decode does as well.
+func (op *Operation) Accepts(v interface{}) *Operation {
+	op.RequestBody = &RequestBody{
+		Required: true,
+		Content:  map[string]MediaType{"application/json": {Schema: describe(v)}},
+	}
+	return op.Fails(http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity)
+}
+
+//Returns adds a response with a JSON body of the type of v. Responses without a body pass nil.
+func (op *Operation) Returns(status int, v interface{}) *Operation {
+	response := &Response{Description: http.StatusText(status)}
+	if v != nil {
+		response.Content = map[string]MediaType{"application/json": {Schema: describe(v)}}
+	}
+	op.Responses[strconv.Itoa(status)] = response
+	return op
+}
+
+//ReturnsFile adds a response whose body is not JSON, like an image or a calendar file.
+func (op *Operation) ReturnsFile(status int, contentType string) *Operation {
+	schema := &jsonschema.Schema{Type: "string"}
+	if !strings.HasPrefix(contentType, "text/") {
+		schema.ContentEncoding = "binary"
+	}
+	op.Responses[strconv.Itoa(status)] = &Response{
+		Description: http.StatusText(status),
+		Content:     map[string]MediaType{contentType: {Schema: schema}},
+	}
+	return op
+}
+
+//Fails adds error responses, whose bodies are problems, see httpapi.Problem.
+func (op *Operation) Fails(statuses ...int) *Operation {
+	for _, status := range statuses {
+		op.Responses[strconv.Itoa(status)] = &Response{
+			Description: http.StatusText(status),
+			Content:     map[string]MediaType{httpapi.ContentTypeProblem: {Schema: describe(httpapi.Problem{})}},
+		}
+	}
+	return op
+}
+
+//describe returns the schema of the type of v. Documents are built when a service starts, from
+//types that are known to be JSON, so a type that can't be described is a bug.
+func describe(v interface{}) *jsonschema.Schema {
+	schema, err := jsonschema.Describe(v)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: %s", err))
+	}
+	return schema
+}
+
+//Handler serves the document as JSON.
+func Handler(d *Document) http.Handler {
+	body, err := json.MarshalIndent(d, "", "  ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			httpapi.InternalError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", httpapi.ContentTypeJSON)
+		w.Write(body)
+	})
+}
+
+//CheckRoutes compares the operations of the document with the routes of a router, and returns an
+//error that lists the routes the document doesn't describe, and the operations that have no route.
+func (d *Document) CheckRoutes(router *mux.Router) error {
+	routes := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		//Path prefixes of subrouters have no methods; only the routes below them serve requests.
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routes[operationKey(method, template)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	operations := map[string]bool{}
+	for path, item := range d.Paths {
+		for method := range *item {
+			operations[operationKey(method, path)] = true
+		}
+	}
+
+	problems := []string{}
+	for route := range routes {
+		if !operations[route] {
+			problems = append(problems, fmt.Sprintf("route %s is not described", route))
+		}
+	}
+	for operation := range operations {
+		if !routes[operation] {
+			problems = append(problems, fmt.Sprintf("operation %s has no route", operation))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("openapi: the document doesn't match the routes: %s", strings.Join(problems, "; "))
+}
+
+//operationKey names an operation like GET /events/{eventID}, with the patterns of route variables
+//left out.
+func operationKey(method string, path string) string {
+	return strings.ToUpper(method) + " " + pathParameter.ReplaceAllString(path, "{$1}")
+}
@@ -0,0 +1,71 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+type ticket struct {
+	Number int    `json:"number"`
+	Seat   string `json:"seat,omitempty"`
+}
+
+func TestDocument(t *testing.T) {
+	d := New("bookings", "1")
+	d.Add("GET", "/users/{userID}/bookings/{bookingID}/tickets/{number:[0-9]+}.png", "ticketQRCode", "Renders a ticket as a QR code").
+		ReturnsFile(http.StatusOK, "image/png").
+		Fails(http.StatusNotFound)
+	d.Add("GET", "/users/{userID}/bookings/{bookingID}/tickets", "tickets", "Lists the tickets of a booking").
+		Returns(http.StatusOK, []ticket{})
+
+	recorder := httptest.NewRecorder()
+	Handler(d).ServeHTTP(recorder, httptest.NewRequest("GET", "/openapi.json", nil))
+	served := map[string]interface{}{}
+	if err := json.NewDecoder(recorder.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if served["openapi"] != Version {
+		t.Errorf("expected an OpenAPI %s document, got %v", Version, served["openapi"])
+	}
+
+	qr := (*d.Paths["/users/{userID}/bookings/{bookingID}/tickets/{number:[0-9]+}.png"])["get"]
+	if len(qr.Parameters) != 3 || qr.Parameters[2].Name != "number" || !qr.Parameters[2].Required {
+		t.Errorf("expected the route variables to be path parameters, got %+v", qr.Parameters)
+	}
+	if qr.Responses["404"].Content["application/problem+json"].Schema.Properties["detail"] == nil {
+		t.Errorf("expected errors to be described as problems, got %+v", qr.Responses["404"])
+	}
+	tickets := (*d.Paths["/users/{userID}/bookings/{bookingID}/tickets"])["get"]
+	schema := tickets.Responses["200"].Content["application/json"].Schema
+	if schema.Type != "array" || schema.Items.Properties["number"].Type != "integer" || len(schema.Items.Required) != 1 {
+		t.Errorf("expected the schema of the tickets, got %+v", schema)
+	}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	handle := func(w http.ResponseWriter, r *http.Request) {}
+	r := mux.NewRouter()
+	bookings := r.PathPrefix("/users/{userID}/bookings").Subrouter()
+	bookings.Methods("GET").Path("/{bookingID}/tickets/{number:[0-9]+}.png").HandlerFunc(handle)
+	bookings.Methods("POST").Path("/{bookingID}/confirm").HandlerFunc(handle)
+
+	d := New("bookings", "1")
+	d.Add("GET", "/users/{userID}/bookings/{bookingID}/tickets/{number}.png", "ticketQRCode", "")
+	d.Add("POST", "/users/{userID}/bookings/{bookingID}/confirm", "confirmBooking", "")
+	if err := d.CheckRoutes(r); err != nil {
+		t.Fatalf("expected the document to match the routes, got %s", err)
+	}
+
+	bookings.Methods("POST").Path("/{bookingID}/cancel").HandlerFunc(handle)
+	d.Add("POST", "/users/{userID}/bookings/{bookingID}/pay", "payBooking", "")
+	err := d.CheckRoutes(r)
+	if err == nil || !strings.Contains(err.Error(), "route POST /users/{userID}/bookings/{bookingID}/cancel is not described") ||
+		!strings.Contains(err.Error(), "operation POST /users/{userID}/bookings/{bookingID}/pay has no route") {
+		t.Errorf("expected the missing route and operation to be named, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package payments
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//The fake provider decides the outcome of a payment by the payment method, the same way the test
+//cards of real providers do. Any other method succeeds.
+const (
+	FakeMethodSuccess        = "pm_success"
+	FakeMethodDecline        = "pm_decline"
+	FakeMethodDelayed        = "pm_delayed"
+	FakeMethodDelayedDecline = "pm_delayed_decline"
+)
+
+//FakeProvider is a PaymentProvider that keeps its intents in memory, so the services can be run and
+//tried out locally without an account at a real payment provider. Delayed payments settle after
+//Delay, and their outcome is posted to WebhookURL exactly like a real provider would.
+type FakeProvider struct {
+	Secret     string
+	WebhookURL string
+	Delay      time.Duration
+	client     *http.Client
+	mutex      sync.Mutex
+	intents    map[string]*Intent
+	refunds    map[string]bool //keys of the refunds that were made
+}
+
+func NewFakeProvider(secret string, webhookURL string, delay time.Duration) *FakeProvider {
+	return &FakeProvider{
+		Secret:     secret,
+		WebhookURL: webhookURL,
+		Delay:      delay,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		intents:    map[string]*Intent{},
+		refunds:    map[string]bool{},
+	}
+}
+
+func (f *FakeProvider) CreateIntent(req IntentRequest) (Intent, error) {
+	if req.Amount <= 0 {
+		return Intent{}, fmt.Errorf("amount must be positive (was %d)", req.Amount)
+	}
+	intent := &Intent{
+		ID:       "pi_" + uuid.NewV4().String(),
+		Status:   StatusCreated,
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		Method:   req.Method,
+		Metadata: req.Metadata,
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.intents[intent.ID] = intent
+	return *intent, nil
+}
+
+func (f *FakeProvider) Capture(intentID string) (Intent, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	intent, ok := f.intents[intentID]
+	if !ok {
+		return Intent{}, ErrIntentNotFound
+	}
+	if intent.Status != StatusCreated {
+		return Intent{}, ErrNotCapturable
+	}
+
+	switch intent.Method {
+	case FakeMethodDecline:
+		intent.Status = StatusFailed
+		intent.FailureReason = "card_declined"
+	case FakeMethodDelayed, FakeMethodDelayedDecline:
+		intent.Status = StatusProcessing
+		time.AfterFunc(f.Delay, func() { f.settle(intentID) })
+	default:
+		intent.Status = StatusSucceeded
+	}
+	return *intent, nil
+}
+
+func (f *FakeProvider) Refund(intentID string, amount int64, key string) (Intent, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	intent, ok := f.intents[intentID]
+	if !ok {
+		return Intent{}, ErrIntentNotFound
+	}
+	if f.refunds[intentID+"#"+key] {
+		return *intent, nil
+	}
+	if amount <= 0 || intent.Status != StatusSucceeded || intent.Refunded+amount > intent.Amount {
+		return Intent{}, ErrNotRefundable
+	}
+	intent.Refunded += amount
+	f.refunds[intentID+"#"+key] = true
+	if intent.Refunded == intent.Amount {
+		intent.Status = StatusRefunded
+	}
+	return *intent, nil
+}
+
+func (f *FakeProvider) VerifyWebhook(payload []byte, signature string) (WebhookEvent, error) {
+	if !VerifySignature(f.Secret, payload, signature) {
+		return WebhookEvent{}, ErrInvalidSignature
+	}
+	event := WebhookEvent{}
+	err := json.Unmarshal(payload, &event)
+	return event, err
+}
+
+//settle decides the outcome of a delayed payment and reports it through the webhook.
+func (f *FakeProvider) settle(intentID string) {
+	f.mutex.Lock()
+	intent := f.intents[intentID]
+	event := WebhookEvent{Type: WebhookSucceeded}
+	if intent.Method == FakeMethodDelayedDecline {
+		intent.Status = StatusFailed
+		intent.FailureReason = "insufficient_funds"
+		event.Type = WebhookFailed
+	} else {
+		intent.Status = StatusSucceeded
+	}
+	event.Intent = *intent
+	f.mutex.Unlock()
+
+	if f.WebhookURL == "" {
+		return
+	}
+	payload, err := json.Marshal(&event)
+	if err != nil {
+		slog.Error("could not encode webhook", "intent", intentID, "error", err)
+		return
+	}
+	req, err := http.NewRequest("POST", f.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		slog.Error("could not create webhook request", "intent", intentID, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(f.Secret, payload))
+	res, err := f.client.Do(req)
+	if err != nil {
+		slog.Warn("could not deliver webhook", "intent", intentID, "error", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		slog.Warn("webhook was not accepted", "intent", intentID, "status", res.StatusCode)
+	}
+}
@@ -0,0 +1,65 @@
+package payments
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFakeProviderOutcomes(t *testing.T) {
+	provider := NewFakeProvider("secret", "", 0)
+
+	expected := map[string]string{
+		FakeMethodSuccess: StatusSucceeded,
+		FakeMethodDecline: StatusFailed,
+		FakeMethodDelayed: StatusProcessing,
+	}
+	for method, status := range expected {
+		intent, err := provider.CreateIntent(IntentRequest{Amount: 2500, Currency: "EUR", Method: method})
+		if err != nil {
+			t.Fatalf("Error creating intent: %v", err)
+		}
+		intent, err = provider.Capture(intent.ID)
+		if err != nil {
+			t.Fatalf("Error capturing intent: %v", err)
+		}
+		if intent.Status != status {
+			t.Errorf("Capture with %s ended up %s, expected %s", method, intent.Status, status)
+		}
+	}
+}
+
+func TestFakeProviderRefund(t *testing.T) {
+	provider := NewFakeProvider("secret", "", 0)
+	intent, _ := provider.CreateIntent(IntentRequest{Amount: 2500, Currency: "EUR"})
+	provider.Capture(intent.ID)
+
+	intent, err := provider.Refund(intent.ID, 1000, "first")
+	if err != nil || intent.Status != StatusSucceeded || intent.Refunded != 1000 {
+		t.Fatalf("Partial refund failed: %v %v", intent, err)
+	}
+	if _, err := provider.Refund(intent.ID, 2000, "second"); err != ErrNotRefundable {
+		t.Errorf("Refunding more than was left should fail, got %v", err)
+	}
+	//A refund that is asked for again is not made twice.
+	intent, err = provider.Refund(intent.ID, 1000, "first")
+	if err != nil || intent.Refunded != 1000 {
+		t.Fatalf("Repeated refund should be made once: %v %v", intent, err)
+	}
+	intent, err = provider.Refund(intent.ID, 1500, "third")
+	if err != nil || intent.Status != StatusRefunded {
+		t.Fatalf("Refunding the rest failed: %v %v", intent, err)
+	}
+}
+
+func TestFakeProviderWebhookSignature(t *testing.T) {
+	provider := NewFakeProvider("secret", "", 0)
+	payload, _ := json.Marshal(&WebhookEvent{Type: WebhookSucceeded, Intent: Intent{ID: "pi_1"}})
+
+	event, err := provider.VerifyWebhook(payload, Sign("secret", payload))
+	if err != nil || event.Intent.ID != "pi_1" {
+		t.Fatalf("Valid webhook was rejected: %v", err)
+	}
+	if _, err := provider.VerifyWebhook(payload, Sign("other", payload)); err != ErrInvalidSignature {
+		t.Errorf("Webhook signed with another secret should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+//A payment goes through two steps. First an intent is created, which tells the provider how much
+//we are about to collect and in which currency. Capturing the intent then actually moves the money.
+//Some payment methods cannot tell right away whether the money arrived; their intents stay
+//processing and the provider reports the outcome later through a webhook.
+const (
+	StatusCreated    = "created"
+	StatusProcessing = "processing"
+	StatusSucceeded  = "succeeded"
+	StatusFailed     = "failed"
+	StatusRefunded   = "refunded"
+)
+
+//Webhook calls of a provider announce that a processing intent settled.
+const (
+	WebhookSucceeded = "intent.succeeded"
+	WebhookFailed    = "intent.failed"
+)
+
+//SignatureHeader is the HTTP header that carries the signature of a webhook call.
+const SignatureHeader = "X-Payment-Signature"
+
+var (
+	ErrIntentNotFound   = errors.New("payment intent not found")
+	ErrNotCapturable    = errors.New("payment intent cannot be captured in its current status")
+	ErrNotRefundable    = errors.New("payment intent has not succeeded, or less is left to refund")
+	ErrInvalidSignature = errors.New("webhook signature does not match its payload")
+)
+
+type PaymentProvider interface {
+	//CreateIntent registers the intention to collect an amount. No money moves yet.
+	CreateIntent(IntentRequest) (Intent, error)
+	//Capture collects the money of an intent. The returned intent has either succeeded, failed,
+	//or is still processing, in which case the outcome arrives through a webhook.
+	Capture(intentID string) (Intent, error)
+	//Refund pays back the given amount of a succeeded intent. Intents can be refunded partially,
+	//and more than once, as long as the refunds don't add up to more than was captured. The key
+	//identifies the refund: a refund that is asked for again with the same key is only made once.
+	Refund(intentID string, amount int64, key string) (Intent, error)
+	//VerifyWebhook checks the signature of a webhook call and decodes its payload.
+	VerifyWebhook(payload []byte, signature string) (WebhookEvent, error)
+}
+
+type IntentRequest struct {
+	Amount   int64 //in minor units of Currency
+	Currency string
+	Method   string //the payment method picked by the user, for example a card token
+	Metadata map[string]string
+}
+
+type Intent struct {
+	ID            string            `json:"id"`
+	Status        string            `json:"status"`
+	Amount        int64             `json:"amount"`
+	Refunded      int64             `json:"refunded"`
+	Currency      string            `json:"currency"`
+	Method        string            `json:"method"`
+	FailureReason string            `json:"failureReason,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+type WebhookEvent struct {
+	Type   string `json:"type"`
+	Intent Intent `json:"intent"`
+}
+
+//Sign computes the signature of a webhook payload, an HMAC-SHA256 of the payload keyed with the
+//secret that is shared between the provider and us.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//VerifySignature compares the signature of a payload in constant time.
+func VerifySignature(secret string, payload []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+type PROVIDERTYPE string
+
+const (
+	FAKE PROVIDERTYPE = "fake"
+)
+
+func NewPaymentProvider(options PROVIDERTYPE, config map[string]interface{}) (PaymentProvider, error) {
+	switch options {
+	case FAKE:
+		secret, _ := config["webhook_secret"].(string)
+		webhookURL, _ := config["webhook_url"].(string)
+		delay, _ := config["delay"].(time.Duration)
+		return NewFakeProvider(secret, webhookURL, delay), nil
+	}
+	return nil, fmt.Errorf("unknown payment provider %s", options)
+}
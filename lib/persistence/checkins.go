@@ -0,0 +1,12 @@
+package persistence
+
+//A ticket can only be used to get in once. Checking a ticket in stores a TicketCheckIn under the
+//ID of the ticket, and a second check-in of the same ticket fails with ErrTicketCheckedIn.
+type TicketCheckIn struct {
+	ID          string `bson:"_id"` //the ID of the ticket
+	EventID     string
+	BookingID   string
+	UserID      string
+	Seat        string
+	CheckedInAt int64
+}
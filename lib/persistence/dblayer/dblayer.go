@@ -15,6 +15,8 @@ const (
 	DYNAMODB DBTYPE = "dynamodb"
 )
 
+//NewPersistenceLayer connects to the database of the given type. The calls to the handler it returns
+//are timed and counted, see persistence.Instrument.
 func NewPersistenceLayer(options DBTYPE, config map[string]interface{}) (persistence.DatabaseHandler, error) {
 	switch options {
 	case MONGODB:
@@ -24,7 +26,8 @@ func NewPersistenceLayer(options DBTYPE, config map[string]interface{}) (persist
 		}
 
 		if connstring, ok := connection.(string); ok {
-			return mongolayer.NewMongoDBLayer(connstring)
+			db, err := mongolayer.NewMongoDBLayer(connstring)
+			return persistence.Instrument(db), err
 		}
 	case DYNAMODB:
 		region, ok := config["region"]
@@ -33,7 +36,8 @@ func NewPersistenceLayer(options DBTYPE, config map[string]interface{}) (persist
 		}
 
 		if regionstring, ok := region.(string); ok {
-			return dynamolayer.NewDynamoDBLayerByRegion(regionstring)
+			db, err := dynamolayer.NewDynamoDBLayerByRegion(regionstring)
+			return persistence.Instrument(db), err
 		}
 	}
 
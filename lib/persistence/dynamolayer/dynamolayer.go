@@ -1,17 +1,24 @@
 package dynamolayer
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	uuid "github.com/satori/go.uuid"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 )
 
 func SetField(obj interface{}, name string, value interface{}) error {
@@ -81,6 +88,7 @@ func NewDynamoDBLayerBySession(sess *session.Session) persistence.DatabaseHandle
 
 //Done
 func (dynamoLayer *DynamoDBLayer) AddUser(user persistence.User) ([]byte, error) {
+	defer dynamoLayer.trace("AddUser")()
 	u1 := uuid.NewV4()
 	av, err := dynamodbattribute.MarshalMap(AWSUser{
 		PK:       string("USR#" + u1.String()),
@@ -106,6 +114,7 @@ func (dynamoLayer *DynamoDBLayer) AddUser(user persistence.User) ([]byte, error)
 
 //Needs a GSI FIX
 func (dynamoLayer *DynamoDBLayer) FindUserByName(name string) (persistence.User, error) {
+	defer dynamoLayer.trace("FindUserByName")()
 	input := &dynamodb.QueryInput{
 		KeyConditionExpression: aws.String("#pk = :id"),
 		ExpressionAttributeNames: map[string]*string{
@@ -132,7 +141,6 @@ func (dynamoLayer *DynamoDBLayer) FindUserByName(name string) (persistence.User,
 	var awsuser map[string]interface{}
 	if len(result.Items) > 0 {
 		err = dynamodbattribute.UnmarshalMap(result.Items[0], &awsuser)
-		fmt.Println(awsuser)
 	} else {
 		err = errors.New("No results found")
 	}
@@ -144,7 +152,6 @@ func (dynamoLayer *DynamoDBLayer) FindUserByName(name string) (persistence.User,
 		"Surname": "Last",
 	}
 	FillStruct(awsuser, newUser, replaceMap)
-	fmt.Println(newUser)
 
 	if err != nil {
 		return persistence.User{}, err
@@ -155,6 +162,7 @@ func (dynamoLayer *DynamoDBLayer) FindUserByName(name string) (persistence.User,
 
 //Done
 func (dynamoLayer *DynamoDBLayer) FindUserById(id []byte) (persistence.User, error) {
+	defer dynamoLayer.trace("FindUserById")()
 	//Create the QueryInput type with the information we need to execute the query
 	input := &dynamodb.QueryInput{
 		KeyConditionExpression: aws.String("PK = :id"),
@@ -190,6 +198,7 @@ func (dynamoLayer *DynamoDBLayer) FindUserById(id []byte) (persistence.User, err
 
 //Done
 func (dynamoLayer *DynamoDBLayer) FindAllUsers() ([]persistence.User, error) {
+	defer dynamoLayer.trace("FindAllUsers")()
 	//Create the QueryInput type with the information we need to execute the query
 	input := &dynamodb.QueryInput{
 		KeyConditionExpression: aws.String("#pk = :id"),
@@ -213,7 +222,6 @@ func (dynamoLayer *DynamoDBLayer) FindAllUsers() ([]persistence.User, error) {
 	var awsusers []map[string]interface{}
 	if len(result.Items) > 0 {
 		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &awsusers)
-		fmt.Println(awsusers)
 	} else {
 		err = errors.New("No results found")
 	}
@@ -229,7 +237,6 @@ func (dynamoLayer *DynamoDBLayer) FindAllUsers() ([]persistence.User, error) {
 			"Surname": "Last",
 		}
 		FillStruct(element, newUser, replaceMap)
-		fmt.Println(newUser)
 		users = append(users, *newUser)
 	}
 
@@ -238,15 +245,26 @@ func (dynamoLayer *DynamoDBLayer) FindAllUsers() ([]persistence.User, error) {
 
 //Done
 func (dynamoLayer *DynamoDBLayer) AddEvent(event persistence.Event) ([]byte, error) {
+	defer dynamoLayer.trace("AddEvent")()
 	u1 := uuid.NewV4()
 	av, err := dynamodbattribute.MarshalMap(AWSEvent{
-		PK:         string("EV#" + u1.String()),
-		SK:         string("META#" + u1.String()),
-		EventID:    string("EV#" + u1.String()),
-		Name:       event.Name,
-		StartTime:  event.StartDate,
-		EndTime:    event.EndDate,
-		LocationID: event.Location.ID,
+		PK:          string("EV#" + u1.String()),
+		SK:          string("META#" + u1.String()),
+		EventID:     string("EV#" + u1.String()),
+		Name:        event.Name,
+		StartTime:   event.StartDate,
+		EndTime:     event.EndDate,
+		Capacity:    event.Capacity,
+		Hall:        event.Hall,
+		SeatMap:     event.SeatMap,
+		TicketTypes: event.TicketTypes,
+		Policy:      event.Policy,
+		CancelledAt: event.CancelledAt,
+		SeriesID:    event.SeriesID,
+		Detached:    event.Detached,
+		Sequence:    event.Sequence,
+		TimeZone:    event.Location.TimeZone,
+		LocationID:  event.Location.ID,
 	})
 	if err != nil {
 		return nil, err
@@ -263,6 +281,7 @@ func (dynamoLayer *DynamoDBLayer) AddEvent(event persistence.Event) ([]byte, err
 
 //Done
 func (dynamoLayer *DynamoDBLayer) FindEvent(id []byte) (persistence.Event, error) {
+	defer dynamoLayer.trace("FindEvent")()
 	//Create the QueryInput type with the information we need to execute the query
 	input := &dynamodb.QueryInput{
 		KeyConditionExpression: aws.String("PK = :id"),
@@ -285,20 +304,34 @@ func (dynamoLayer *DynamoDBLayer) FindEvent(id []byte) (persistence.Event, error
 	} else {
 		err = errors.New("No results found")
 	}
-	event := persistence.Event{
-		ID:        awsevent.PK,
-		Name:      awsevent.Name,
-		StartDate: awsevent.StartTime,
-		EndDate:   awsevent.StartTime,
+	return eventFromAWS(awsevent), err
+}
+
+func eventFromAWS(awsevent AWSEvent) persistence.Event {
+	return persistence.Event{
+		ID:          awsevent.PK,
+		Name:        awsevent.Name,
+		StartDate:   awsevent.StartTime,
+		EndDate:     awsevent.EndTime,
+		Capacity:    awsevent.Capacity,
+		Hall:        awsevent.Hall,
+		SeatMap:     awsevent.SeatMap,
+		TicketTypes: awsevent.TicketTypes,
+		Policy:      awsevent.Policy,
+		CancelledAt: awsevent.CancelledAt,
+		SeriesID:    awsevent.SeriesID,
+		Detached:    awsevent.Detached,
+		Sequence:    awsevent.Sequence,
 		Location: persistence.Location{
-			ID: awsevent.LocationID,
+			ID:       awsevent.LocationID,
+			TimeZone: awsevent.TimeZone,
 		},
 	}
-	return event, err
 }
 
 //Needs GSI
 func (dynamoLayer *DynamoDBLayer) FindEventByName(name string) (persistence.Event, error) {
+	defer dynamoLayer.trace("FindEventByName")()
 	input := &dynamodb.QueryInput{
 		KeyConditionExpression: aws.String("#pk = :pk and #sk = :sk"),
 		ExpressionAttributeNames: map[string]*string{
@@ -348,6 +381,7 @@ func (dynamoLayer *DynamoDBLayer) FindEventByName(name string) (persistence.Even
 
 //Done
 func (dynamoLayer *DynamoDBLayer) FindAllAvailableEvents() ([]persistence.Event, error) {
+	defer dynamoLayer.trace("FindAllAvailableEvents")()
 	input := &dynamodb.QueryInput{
 		KeyConditionExpression: aws.String("#pk = :id"),
 		ExpressionAttributeNames: map[string]*string{
@@ -385,7 +419,6 @@ func (dynamoLayer *DynamoDBLayer) FindAllAvailableEvents() ([]persistence.Event,
 			"EndTime":   "EndDate",
 		}
 		FillStruct(element, newEvent, replaceMap)
-		fmt.Println(newEvent)
 
 		events = append(events, *newEvent)
 	}
@@ -393,14 +426,194 @@ func (dynamoLayer *DynamoDBLayer) FindAllAvailableEvents() ([]persistence.Event,
 	return events, err
 }
 
+func (dynamoLayer *DynamoDBLayer) CancelEvent(id []byte, cancelledAt int64) error {
+	defer dynamoLayer.trace("CancelEvent")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(string(id)),
+			},
+			"SK": {
+				S: aws.String("META#" + strings.TrimPrefix(string(id), "EV#")),
+			},
+		},
+		UpdateExpression:    aws.String("SET CancelledAt = :cancelledAt ADD Sequence :one"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":cancelledAt": {
+				N: aws.String(strconv.FormatInt(cancelledAt, 10)),
+			},
+			":one": {
+				N: aws.String("1"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) UpdateEvent(event persistence.Event) error {
+	defer dynamoLayer.trace("UpdateEvent")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(event.ID),
+			},
+			"SK": {
+				S: aws.String("META#" + strings.TrimPrefix(event.ID, "EV#")),
+			},
+		},
+		UpdateExpression:    aws.String("SET #name = :name, StartTime = :start, EndTime = :end, Detached = :detached, Sequence = :sequence"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#name": aws.String("Name"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":name": {
+				S: aws.String(event.Name),
+			},
+			":start": {
+				N: aws.String(strconv.FormatInt(event.StartDate, 10)),
+			},
+			":end": {
+				N: aws.String(strconv.FormatInt(event.EndDate, 10)),
+			},
+			":detached": {
+				BOOL: aws.Bool(event.Detached),
+			},
+			":sequence": {
+				N: aws.String(strconv.Itoa(event.Sequence)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) AddEventSeries(series persistence.EventSeries) ([]byte, error) {
+	defer dynamoLayer.trace("AddEventSeries")()
+	if series.ID == "" {
+		series.ID = "SERIES#" + uuid.NewV4().String()
+	}
+	return []byte(series.ID), dynamoLayer.UpdateEventSeries(series)
+}
+
+func (dynamoLayer *DynamoDBLayer) FindEventSeries(id []byte) (persistence.EventSeries, error) {
+	defer dynamoLayer.trace("FindEventSeries")()
+	result, err := dynamoLayer.service.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(string(id)),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.EventSeries{}, err
+	}
+	if result.Item == nil {
+		return persistence.EventSeries{}, errors.New("No results found")
+	}
+	awsseries := AWSEventSeries{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &awsseries)
+	return persistence.EventSeries{
+		ID:        awsseries.PK,
+		Name:      awsseries.Name,
+		Rule:      awsseries.Rule,
+		ExDates:   awsseries.ExDates,
+		TimeZone:  awsseries.TimeZone,
+		StartDate: awsseries.StartTime,
+		EndDate:   awsseries.EndTime,
+	}, err
+}
+
+func (dynamoLayer *DynamoDBLayer) UpdateEventSeries(series persistence.EventSeries) error {
+	defer dynamoLayer.trace("UpdateEventSeries")()
+	av, err := dynamodbattribute.MarshalMap(AWSEventSeries{
+		PK:        series.ID,
+		SK:        "META",
+		Name:      series.Name,
+		Rule:      series.Rule,
+		ExDates:   series.ExDates,
+		TimeZone:  series.TimeZone,
+		StartTime: series.StartDate,
+		EndTime:   series.EndDate,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("myevents"),
+		Item:      av,
+	})
+	return err
+}
+
+//FindEventsBySeriesId returns the occurrences of a series in the order in which they take place.
+//There is no index on the series of an event, so this scans the table.
+func (dynamoLayer *DynamoDBLayer) FindEventsBySeriesId(seriesId []byte) ([]persistence.Event, error) {
+	defer dynamoLayer.trace("FindEventsBySeriesId")()
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("SeriesID = :series AND begins_with(SK, :meta)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":series": {
+				S: aws.String(string(seriesId)),
+			},
+			":meta": {
+				S: aws.String("META#"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	events := []persistence.Event{}
+	var unmarshalErr error
+	err := dynamoLayer.service.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		awsevents := []AWSEvent{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsevents)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awsevent := range awsevents {
+			events = append(events, eventFromAWS(awsevent))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].StartDate < events[j].StartDate
+	})
+	return events, unmarshalErr
+}
+
 //Done
 func (dynamoLayer *DynamoDBLayer) AddBookingForUser(id []byte, bk persistence.Booking) ([]byte, error) {
+	defer dynamoLayer.trace("AddBookingForUser")()
 	u1 := uuid.NewV4()
 	av, err := dynamodbattribute.MarshalMap(AWSBooking{
-		PK:      string(id),
-		SK:      string("BK#" + u1.String()),
-		EventID: string(bk.EventID),
-		Seats:   bk.Seats,
+		PK:          string(id),
+		SK:          string("BK#" + u1.String()),
+		EventID:     string(bk.EventID),
+		Seats:       bk.Seats,
+		SeatIDs:     bk.SeatIDs,
+		TicketType:  bk.TicketType,
+		UnitPrice:   bk.UnitPrice,
+		PromoCode:   bk.PromoCode,
+		Discount:    bk.Discount,
+		Total:       bk.Total,
+		Currency:    bk.Currency,
+		PaymentID:   bk.PaymentID,
+		Refunds:     bk.Refunds,
+		Date:        bk.Date,
+		Status:      bk.Status,
+		HoldExpires: bk.HoldExpires,
+		ConfirmedAt: bk.ConfirmedAt,
+		CancelledAt: bk.CancelledAt,
+		SagaID:      bk.SagaID,
 	})
 	if err != nil {
 		return nil, err
@@ -417,6 +630,7 @@ func (dynamoLayer *DynamoDBLayer) AddBookingForUser(id []byte, bk persistence.Bo
 
 //Done
 func (dynamoLayer *DynamoDBLayer) FindBookingByBookingId(userId []byte, bookingId []byte) (persistence.Booking, error) {
+	defer dynamoLayer.trace("FindBookingByBookingId")()
 	//Create the QueryInput type with the information we need to execute the query
 	input := &dynamodb.QueryInput{
 		KeyConditionExpression: aws.String("PK = :pk and SK = :sk"),
@@ -447,15 +661,12 @@ func (dynamoLayer *DynamoDBLayer) FindBookingByBookingId(userId []byte, bookingI
 		return persistence.Booking{}, err
 	}
 
-	return persistence.Booking{
-		ID:      awsbooking.SK,
-		EventID: awsbooking.EventID,
-		Seats:   awsbooking.Seats,
-	}, err
+	return bookingFromAWS(awsbooking), err
 }
 
 //Fix
 func (dynamoLayer *DynamoDBLayer) FindBookingsByUserId(userId []byte) ([]persistence.Booking, error) {
+	defer dynamoLayer.trace("FindBookingsByUserId")()
 	//Create the QueryInput type with the information we need to execute the query
 	input := &dynamodb.QueryInput{
 		KeyConditionExpression: aws.String("PK = :pk and begins_with(SK, :sk)"),
@@ -492,7 +703,6 @@ func (dynamoLayer *DynamoDBLayer) FindBookingsByUserId(userId []byte) ([]persist
 			"SK": "ID",
 		}
 		FillStruct(element, newBooking, replaceMap)
-		fmt.Println(newBooking)
 
 		bookings = append(bookings, *newBooking)
 	}
@@ -504,3 +714,2284 @@ func (dynamoLayer *DynamoDBLayer) FindBookingsByUserId(userId []byte) ([]persist
 	return bookings, nil
 
 }
+
+func (dynamoLayer *DynamoDBLayer) FindBookingsByEventId(eventId []byte) ([]persistence.Booking, error) {
+	defer dynamoLayer.trace("FindBookingsByEventId")()
+	//Bookings are stored under the partition of the user that made them, so there is no key we
+	//could query by event. Until we add an index for this we scan the table for booking items.
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(SK, :sk) and EventID = :eventId"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {
+				S: aws.String("BK#"),
+			},
+			":eventId": {
+				S: aws.String(string(eventId)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	return dynamoLayer.scanBookings(input)
+}
+
+func (dynamoLayer *DynamoDBLayer) UpdateBookingForUser(userId []byte, status string, bk persistence.Booking) error {
+	defer dynamoLayer.trace("UpdateBookingForUser")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(string(userId)),
+			},
+			"SK": {
+				S: aws.String(bk.ID),
+			},
+		},
+		//Status is a reserved word in DynamoDB, which is why we have to refer to it through an
+		//expression attribute name.
+		UpdateExpression:    aws.String("SET #status = :status, HoldExpires = :holdExpires, ConfirmedAt = :confirmedAt, CancelledAt = :cancelledAt, PaymentID = :paymentID"),
+		ConditionExpression: aws.String("#status = :expected"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {
+				S: aws.String(bk.Status),
+			},
+			":holdExpires": {
+				N: aws.String(strconv.FormatInt(bk.HoldExpires, 10)),
+			},
+			":confirmedAt": {
+				N: aws.String(strconv.FormatInt(bk.ConfirmedAt, 10)),
+			},
+			":cancelledAt": {
+				N: aws.String(strconv.FormatInt(bk.CancelledAt, 10)),
+			},
+			":paymentID": {
+				S: aws.String(bk.PaymentID),
+			},
+			":expected": {
+				S: aws.String(status),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrBookingStatusChanged
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) ExpireBookingHolds(now int64) ([]persistence.Booking, error) {
+	defer dynamoLayer.trace("ExpireBookingHolds")()
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(SK, :sk) and #status = :held and HoldExpires <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {
+				S: aws.String("BK#"),
+			},
+			":held": {
+				S: aws.String(persistence.BookingHeld),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(now, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	holds, err := dynamoLayer.scanBookings(input)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []persistence.Booking{}
+	for _, bk := range holds {
+		userId, err := hex.DecodeString(bk.UserID)
+		if err != nil {
+			return expired, err
+		}
+		bk.Status = persistence.BookingExpired
+		//The conditional update fails if the hold got confirmed or cancelled after our scan, in
+		//which case we leave it alone.
+		err = dynamoLayer.UpdateBookingForUser(userId, persistence.BookingHeld, bk)
+		if err == persistence.ErrBookingStatusChanged {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired = append(expired, bk)
+	}
+	return expired, nil
+}
+
+func (dynamoLayer *DynamoDBLayer) scanBookings(input *dynamodb.ScanInput) ([]persistence.Booking, error) {
+	bookings := []persistence.Booking{}
+	var unmarshalErr error
+	err := dynamoLayer.service.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		awsbookings := []AWSBooking{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsbookings)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awsbooking := range awsbookings {
+			bookings = append(bookings, bookingFromAWS(awsbooking))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bookings, unmarshalErr
+}
+
+//bookingFromAWS converts a booking item into a persistence.Booking. The user ID is hex encoded the
+//same way the services pass user IDs around in their URLs and messages.
+func bookingFromAWS(awsbooking AWSBooking) persistence.Booking {
+	return persistence.Booking{
+		ID:          awsbooking.SK,
+		UserID:      hex.EncodeToString([]byte(awsbooking.PK)),
+		Date:        awsbooking.Date,
+		EventID:     awsbooking.EventID,
+		Seats:       awsbooking.Seats,
+		SeatIDs:     awsbooking.SeatIDs,
+		TicketType:  awsbooking.TicketType,
+		UnitPrice:   awsbooking.UnitPrice,
+		PromoCode:   awsbooking.PromoCode,
+		Discount:    awsbooking.Discount,
+		Total:       awsbooking.Total,
+		Currency:    awsbooking.Currency,
+		PaymentID:   awsbooking.PaymentID,
+		Refunds:     awsbooking.Refunds,
+		Status:      awsbooking.Status,
+		HoldExpires: awsbooking.HoldExpires,
+		ConfirmedAt: awsbooking.ConfirmedAt,
+		CancelledAt: awsbooking.CancelledAt,
+		SagaID:      awsbooking.SagaID,
+	}
+}
+
+func (dynamoLayer *DynamoDBLayer) AddRefundToBooking(userId []byte, bookingId []byte, refund persistence.Refund) error {
+	defer dynamoLayer.trace("AddRefundToBooking")()
+	av, err := dynamodbattribute.Marshal([]persistence.Refund{refund})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(string(userId)),
+			},
+			"SK": {
+				S: aws.String(string(bookingId)),
+			},
+		},
+		//Bookings that were never refunded have no Refunds attribute yet, which list_append
+		//can't handle on its own. The IDs of the refunds are kept in a set next to the list, since
+		//conditions can look into sets, but not into the items of a list. PendingRefunds counts the
+		//refunds that are still pending, for FindBookingsWithPendingRefunds.
+		UpdateExpression:    aws.String("SET Refunds = list_append(if_not_exists(Refunds, :empty), :refund) ADD RefundIDs :ids, PendingRefunds :pending"),
+		ConditionExpression: aws.String("attribute_exists(PK) AND NOT contains(RefundIDs, :id)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":refund": av,
+			":empty": {
+				L: []*dynamodb.AttributeValue{},
+			},
+			":ids": {
+				SS: []*string{aws.String(refund.ID)},
+			},
+			":id": {
+				S: aws.String(refund.ID),
+			},
+			":pending": {
+				N: aws.String(strconv.Itoa(pendingCount(refund))),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	//The condition also fails for bookings that don't exist, but refunds are only ever added to
+	//bookings that were just loaded.
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrRefundExists
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) UpdateRefundOfBooking(userId []byte, bookingId []byte, refund persistence.Refund) error {
+	defer dynamoLayer.trace("UpdateRefundOfBooking")()
+	bk, err := dynamoLayer.FindBookingByBookingId(userId, bookingId)
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, r := range bk.Refunds {
+		if r.ID == refund.ID {
+			index = i
+		}
+	}
+	if index < 0 {
+		return persistence.ErrRefundSettled
+	}
+	//Refunds are only ever appended, so the refund stays at the index we found it at. The
+	//condition makes sure it is still pending.
+	path := fmt.Sprintf("Refunds[%d]", index)
+	_, err = dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(string(userId)),
+			},
+			"SK": {
+				S: aws.String(string(bookingId)),
+			},
+		},
+		UpdateExpression:    aws.String("SET " + path + ".#status = :status, " + path + ".RefundedAt = :at ADD PendingRefunds :pending"),
+		ConditionExpression: aws.String(path + ".ID = :id AND " + path + ".#status = :was"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {
+				S: aws.String(refund.Status),
+			},
+			":at": {
+				N: aws.String(strconv.FormatInt(refund.RefundedAt, 10)),
+			},
+			":pending": {
+				N: aws.String(strconv.Itoa(pendingCount(refund) - 1)),
+			},
+			":id": {
+				S: aws.String(refund.ID),
+			},
+			":was": {
+				S: aws.String(persistence.RefundPending),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrRefundSettled
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindBookingsWithPendingRefunds() ([]persistence.Booking, error) {
+	defer dynamoLayer.trace("FindBookingsWithPendingRefunds")()
+	return dynamoLayer.scanBookings(&dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(SK, :sk) and PendingRefunds > :none"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {
+				S: aws.String("BK#"),
+			},
+			":none": {
+				N: aws.String("0"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+}
+
+//pendingCount is what a refund adds to the PendingRefunds of its booking.
+func pendingCount(refund persistence.Refund) int {
+	if refund.Pending() {
+		return 1
+	}
+	return 0
+}
+
+func (dynamoLayer *DynamoDBLayer) AddWaitlistEntry(wl persistence.WaitlistEntry) error {
+	defer dynamoLayer.trace("AddWaitlistEntry")()
+	//The condition makes sure a user cannot join the waitlist of the same event twice.
+	err := dynamoLayer.putWaitlistEntry(wl, "attribute_not_exists(PK)")
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrAlreadyOnWaitlist
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindWaitlistByEventId(eventId []byte) ([]persistence.WaitlistEntry, error) {
+	defer dynamoLayer.trace("FindWaitlistByEventId")()
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("WL#" + string(eventId)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	entries := []persistence.WaitlistEntry{}
+	var unmarshalErr error
+	err := dynamoLayer.service.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		awsentries := []AWSWaitlistEntry{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsentries)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awsentry := range awsentries {
+			entries = append(entries, persistence.WaitlistEntry{
+				ID:           awsentry.EventID + "#" + awsentry.UserID,
+				EventID:      awsentry.EventID,
+				UserID:       awsentry.UserID,
+				Seats:        awsentry.Seats,
+				TicketType:   awsentry.TicketType,
+				JoinedAt:     awsentry.JoinedAt,
+				Status:       awsentry.Status,
+				SagaID:       awsentry.SagaID,
+				BookingID:    awsentry.BookingID,
+				OfferExpires: awsentry.OfferExpires,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//The sort key is the user ID, so the items come back in the wrong order for a waitlist.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].JoinedAt < entries[j].JoinedAt
+	})
+	return entries, unmarshalErr
+}
+
+func (dynamoLayer *DynamoDBLayer) UpdateWaitlistEntry(wl persistence.WaitlistEntry) error {
+	defer dynamoLayer.trace("UpdateWaitlistEntry")()
+	err := dynamoLayer.putWaitlistEntry(wl, "attribute_exists(PK)")
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrNotOnWaitlist
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) RemoveWaitlistEntry(eventId []byte, userId []byte) error {
+	defer dynamoLayer.trace("RemoveWaitlistEntry")()
+	_, err := dynamoLayer.service.DeleteItem(&dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("WL#" + string(eventId)),
+			},
+			"SK": {
+				S: aws.String("USR#" + string(userId)),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		TableName:           aws.String("myevents"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrNotOnWaitlist
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) putWaitlistEntry(wl persistence.WaitlistEntry, condition string) error {
+	av, err := dynamodbattribute.MarshalMap(AWSWaitlistEntry{
+		PK:           "WL#" + wl.EventID,
+		SK:           "USR#" + wl.UserID,
+		EventID:      wl.EventID,
+		UserID:       wl.UserID,
+		Seats:        wl.Seats,
+		TicketType:   wl.TicketType,
+		JoinedAt:     wl.JoinedAt,
+		Status:       wl.Status,
+		SagaID:       wl.SagaID,
+		BookingID:    wl.BookingID,
+		OfferExpires: wl.OfferExpires,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String("myevents"),
+		Item:                av,
+		ConditionExpression: aws.String(condition),
+	})
+	return err
+}
+
+//A DynamoDB transaction can contain at most 25 items, which limits how many seats a single
+//booking can reserve.
+const maxSeatsPerReservation = 25
+
+func (dynamoLayer *DynamoDBLayer) ReserveSeats(eventId []byte, userId []byte, seatIds []string) error {
+	defer dynamoLayer.trace("ReserveSeats")()
+	if len(seatIds) > maxSeatsPerReservation {
+		return fmt.Errorf("at most %d seats can be reserved at once", maxSeatsPerReservation)
+	}
+
+	//Every seat becomes its own item, and the whole set is written in a single transaction. The
+	//condition on each put fails the transaction if any of the seats has been reserved before.
+	items := []*dynamodb.TransactWriteItem{}
+	for _, seatId := range seatIds {
+		av, err := dynamodbattribute.MarshalMap(AWSSeatReservation{
+			PK:      "SEAT#" + string(eventId),
+			SK:      seatId,
+			EventID: string(eventId),
+			SeatID:  seatId,
+			UserID:  string(userId),
+		})
+		if err != nil {
+			return err
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:           aws.String("myevents"),
+				Item:                av,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			},
+		})
+	}
+	_, err := dynamoLayer.service.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeTransactionCanceledException {
+		return persistence.ErrSeatTaken
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) ReleaseSeats(eventId []byte, seatIds []string) error {
+	defer dynamoLayer.trace("ReleaseSeats")()
+	for _, seatId := range seatIds {
+		_, err := dynamoLayer.service.DeleteItem(&dynamodb.DeleteItemInput{
+			Key: map[string]*dynamodb.AttributeValue{
+				"PK": {
+					S: aws.String("SEAT#" + string(eventId)),
+				},
+				"SK": {
+					S: aws.String(seatId),
+				},
+			},
+			TableName: aws.String("myevents"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dynamoLayer *DynamoDBLayer) FindReservedSeats(eventId []byte) ([]persistence.SeatReservation, error) {
+	defer dynamoLayer.trace("FindReservedSeats")()
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("SEAT#" + string(eventId)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	reservations := []persistence.SeatReservation{}
+	var unmarshalErr error
+	err := dynamoLayer.service.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		awsreservations := []AWSSeatReservation{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsreservations)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, r := range awsreservations {
+			reservations = append(reservations, persistence.SeatReservation{
+				EventID: r.EventID,
+				SeatID:  r.SeatID,
+				UserID:  r.UserID,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservations, unmarshalErr
+}
+
+func (dynamoLayer *DynamoDBLayer) SaveRefundJob(job persistence.RefundJob) error {
+	defer dynamoLayer.trace("SaveRefundJob")()
+	av, err := dynamodbattribute.MarshalMap(AWSRefundJob{
+		PK:         "JOB#REFUND#" + job.EventID,
+		SK:         "META",
+		EventID:    job.EventID,
+		Status:     job.Status,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+		Bookings:   job.Bookings,
+		Processed:  job.Processed,
+		Refunded:   job.Refunded,
+		Failed:     job.Failed,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("myevents"),
+		Item:      av,
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindRefundJob(eventId []byte) (persistence.RefundJob, error) {
+	defer dynamoLayer.trace("FindRefundJob")()
+	result, err := dynamoLayer.service.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("JOB#REFUND#" + string(eventId)),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.RefundJob{}, err
+	}
+	if result.Item == nil {
+		return persistence.RefundJob{}, errors.New("No results found")
+	}
+	awsjob := AWSRefundJob{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &awsjob)
+	return refundJobFromAWS(awsjob), err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindUnfinishedRefundJobs() ([]persistence.RefundJob, error) {
+	defer dynamoLayer.trace("FindUnfinishedRefundJobs")()
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(PK, :pk) and #status = :running"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("JOB#REFUND#"),
+			},
+			":running": {
+				S: aws.String(persistence.RefundJobRunning),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	jobs := []persistence.RefundJob{}
+	var unmarshalErr error
+	err := dynamoLayer.service.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		awsjobs := []AWSRefundJob{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsjobs)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awsjob := range awsjobs {
+			jobs = append(jobs, refundJobFromAWS(awsjob))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, unmarshalErr
+}
+
+func refundJobFromAWS(awsjob AWSRefundJob) persistence.RefundJob {
+	return persistence.RefundJob{
+		ID:         awsjob.EventID,
+		EventID:    awsjob.EventID,
+		Status:     awsjob.Status,
+		StartedAt:  awsjob.StartedAt,
+		FinishedAt: awsjob.FinishedAt,
+		Bookings:   awsjob.Bookings,
+		Processed:  awsjob.Processed,
+		Refunded:   awsjob.Refunded,
+		Failed:     awsjob.Failed,
+	}
+}
+
+func (dynamoLayer *DynamoDBLayer) SaveNotificationPreferences(prefs persistence.NotificationPreferences) error {
+	defer dynamoLayer.trace("SaveNotificationPreferences")()
+	av, err := dynamodbattribute.MarshalMap(AWSNotificationPreferences{
+		PK:       "PREFS#" + prefs.UserID,
+		SK:       "META",
+		UserID:   prefs.UserID,
+		Email:    prefs.Email,
+		Name:     prefs.Name,
+		Locale:   prefs.Locale,
+		Disabled: prefs.Disabled,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("myevents"),
+		Item:      av,
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindNotificationPreferences(userId []byte) (persistence.NotificationPreferences, error) {
+	defer dynamoLayer.trace("FindNotificationPreferences")()
+	result, err := dynamoLayer.service.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("PREFS#" + string(userId)),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.NotificationPreferences{}, err
+	}
+	if result.Item == nil {
+		return persistence.NotificationPreferences{}, errors.New("No results found")
+	}
+	awsprefs := AWSNotificationPreferences{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &awsprefs)
+	return persistence.NotificationPreferences{
+		UserID:   awsprefs.UserID,
+		Email:    awsprefs.Email,
+		Name:     awsprefs.Name,
+		Locale:   awsprefs.Locale,
+		Disabled: awsprefs.Disabled,
+	}, err
+}
+
+func (dynamoLayer *DynamoDBLayer) AddAttendee(attendee persistence.Attendee) error {
+	defer dynamoLayer.trace("AddAttendee")()
+	av, err := dynamodbattribute.MarshalMap(AWSAttendee{
+		PK:        "ATTENDEE#" + attendee.EventID,
+		SK:        attendee.BookingID,
+		EventID:   attendee.EventID,
+		UserID:    attendee.UserID,
+		BookingID: attendee.BookingID,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("myevents"),
+		Item:      av,
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) RemoveAttendee(eventId []byte, bookingId []byte) error {
+	defer dynamoLayer.trace("RemoveAttendee")()
+	_, err := dynamoLayer.service.DeleteItem(&dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("ATTENDEE#" + string(eventId)),
+			},
+			"SK": {
+				S: aws.String(string(bookingId)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindAttendeesByEventId(eventId []byte) ([]persistence.Attendee, error) {
+	defer dynamoLayer.trace("FindAttendeesByEventId")()
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("ATTENDEE#" + string(eventId)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	attendees := []persistence.Attendee{}
+	var unmarshalErr error
+	err := dynamoLayer.service.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		awsattendees := []AWSAttendee{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsattendees)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awsattendee := range awsattendees {
+			attendees = append(attendees, persistence.Attendee{
+				ID:        awsattendee.BookingID,
+				EventID:   awsattendee.EventID,
+				UserID:    awsattendee.UserID,
+				BookingID: awsattendee.BookingID,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attendees, unmarshalErr
+}
+
+func (dynamoLayer *DynamoDBLayer) AddNotification(n persistence.Notification) error {
+	defer dynamoLayer.trace("AddNotification")()
+	av, err := dynamodbattribute.MarshalMap(AWSNotification{
+		PK:          "NOTIFY#" + n.ID,
+		SK:          "META",
+		ID:          n.ID,
+		UserID:      n.UserID,
+		Kind:        n.Kind,
+		Email:       n.Email,
+		Subject:     n.Subject,
+		Text:        n.Text,
+		HTML:        n.HTML,
+		Status:      n.Status,
+		Attempts:    n.Attempts,
+		NextAttempt: n.NextAttempt,
+		LastError:   n.LastError,
+		CreatedAt:   n.CreatedAt,
+		SentAt:      n.SentAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String("myevents"),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrNotificationExists
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindDueNotifications(now int64) ([]persistence.Notification, error) {
+	defer dynamoLayer.trace("FindDueNotifications")()
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(PK, :pk) and #status = :pending and NextAttempt <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("NOTIFY#"),
+			},
+			":pending": {
+				S: aws.String(persistence.NotificationPending),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(now, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	notifications, err := dynamoLayer.scanNotifications(input)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].NextAttempt < notifications[j].NextAttempt
+	})
+	return notifications, nil
+}
+
+func (dynamoLayer *DynamoDBLayer) ClaimNotification(id string, now int64, until int64) error {
+	defer dynamoLayer.trace("ClaimNotification")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("NOTIFY#" + id),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		//Only one sender can move the next attempt of a due notification into the future.
+		UpdateExpression:    aws.String("SET NextAttempt = :until"),
+		ConditionExpression: aws.String("#status = :pending and NextAttempt <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until": {
+				N: aws.String(strconv.FormatInt(until, 10)),
+			},
+			":pending": {
+				S: aws.String(persistence.NotificationPending),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(now, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrNotificationClaimed
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) UpdateNotification(n persistence.Notification) error {
+	defer dynamoLayer.trace("UpdateNotification")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("NOTIFY#" + n.ID),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		UpdateExpression: aws.String("SET #status = :status, Attempts = :attempts, NextAttempt = :nextAttempt, LastError = :lastError, SentAt = :sentAt"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {
+				S: aws.String(n.Status),
+			},
+			":attempts": {
+				N: aws.String(strconv.Itoa(n.Attempts)),
+			},
+			":nextAttempt": {
+				N: aws.String(strconv.FormatInt(n.NextAttempt, 10)),
+			},
+			":lastError": {
+				S: aws.String(n.LastError),
+			},
+			":sentAt": {
+				N: aws.String(strconv.FormatInt(n.SentAt, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindNotificationsByUserId(userId []byte) ([]persistence.Notification, error) {
+	defer dynamoLayer.trace("FindNotificationsByUserId")()
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(PK, :pk) and UserID = :user"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("NOTIFY#"),
+			},
+			":user": {
+				S: aws.String(string(userId)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	notifications, err := dynamoLayer.scanNotifications(input)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].CreatedAt > notifications[j].CreatedAt
+	})
+	return notifications, nil
+}
+
+func (dynamoLayer *DynamoDBLayer) scanNotifications(input *dynamodb.ScanInput) ([]persistence.Notification, error) {
+	notifications := []persistence.Notification{}
+	var unmarshalErr error
+	err := dynamoLayer.service.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		awsnotifications := []AWSNotification{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsnotifications)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, n := range awsnotifications {
+			notifications = append(notifications, persistence.Notification{
+				ID:          n.ID,
+				UserID:      n.UserID,
+				Kind:        n.Kind,
+				Email:       n.Email,
+				Subject:     n.Subject,
+				Text:        n.Text,
+				HTML:        n.HTML,
+				Status:      n.Status,
+				Attempts:    n.Attempts,
+				NextAttempt: n.NextAttempt,
+				LastError:   n.LastError,
+				CreatedAt:   n.CreatedAt,
+				SentAt:      n.SentAt,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return notifications, unmarshalErr
+}
+
+func (dynamoLayer *DynamoDBLayer) SaveJob(job persistence.ScheduledJob) error {
+	defer dynamoLayer.trace("SaveJob")()
+	av, err := dynamodbattribute.MarshalMap(AWSScheduledJob{
+		PK:          "SCHED#" + job.ID,
+		SK:          "META",
+		ID:          job.ID,
+		Kind:        job.Kind,
+		JobGroup:    job.Group,
+		Payload:     job.Payload,
+		RunAt:       job.RunAt,
+		Status:      job.Status,
+		Attempts:    job.Attempts,
+		LastError:   job.LastError,
+		LeasedUntil: job.LeasedUntil,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("myevents"),
+		Item:      av,
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindJob(id string) (persistence.ScheduledJob, error) {
+	defer dynamoLayer.trace("FindJob")()
+	result, err := dynamoLayer.service.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("SCHED#" + id),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.ScheduledJob{}, err
+	}
+	if result.Item == nil {
+		return persistence.ScheduledJob{}, persistence.ErrJobNotFound
+	}
+	awsjob := AWSScheduledJob{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &awsjob)
+	return scheduledJobFromAWS(awsjob), err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindJobsByGroup(group string) ([]persistence.ScheduledJob, error) {
+	defer dynamoLayer.trace("FindJobsByGroup")()
+	return dynamoLayer.scanJobs(&dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(PK, :pk) and JobGroup = :group"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("SCHED#"),
+			},
+			":group": {
+				S: aws.String(group),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+}
+
+func (dynamoLayer *DynamoDBLayer) FindDueJobs(now int64) ([]persistence.ScheduledJob, error) {
+	defer dynamoLayer.trace("FindDueJobs")()
+	return dynamoLayer.scanJobs(&dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(PK, :pk) and #status = :pending and RunAt <= :now and LeasedUntil < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("SCHED#"),
+			},
+			":pending": {
+				S: aws.String(persistence.JobPending),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(now, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+}
+
+func (dynamoLayer *DynamoDBLayer) LeaseJob(id string, now int64, until int64) error {
+	defer dynamoLayer.trace("LeaseJob")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("SCHED#" + id),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		//The lease only moves forward while nobody else holds it, so exactly one replica gets the job.
+		UpdateExpression:    aws.String("SET LeasedUntil = :until"),
+		ConditionExpression: aws.String("#status = :pending and RunAt <= :now and LeasedUntil < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until": {
+				N: aws.String(strconv.FormatInt(until, 10)),
+			},
+			":pending": {
+				S: aws.String(persistence.JobPending),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(now, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrJobLeased
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FinishJob(job persistence.ScheduledJob) error {
+	defer dynamoLayer.trace("FinishJob")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("SCHED#" + job.ID),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		UpdateExpression: aws.String("SET #status = :status, Attempts = :attempts, RunAt = :runAt, LastError = :lastError, LeasedUntil = :zero"),
+		//A replica that ran over its lease must not overwrite the outcome of the one that took over.
+		ConditionExpression: aws.String("LeasedUntil = :lease"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {
+				S: aws.String(job.Status),
+			},
+			":attempts": {
+				N: aws.String(strconv.Itoa(job.Attempts)),
+			},
+			":runAt": {
+				N: aws.String(strconv.FormatInt(job.RunAt, 10)),
+			},
+			":lastError": {
+				S: aws.String(job.LastError),
+			},
+			":zero": {
+				N: aws.String("0"),
+			},
+			":lease": {
+				N: aws.String(strconv.FormatInt(job.LeasedUntil, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrJobLeased
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) scanJobs(input *dynamodb.ScanInput) ([]persistence.ScheduledJob, error) {
+	jobs := []persistence.ScheduledJob{}
+	var unmarshalErr error
+	err := dynamoLayer.service.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		awsjobs := []AWSScheduledJob{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsjobs)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awsjob := range awsjobs {
+			jobs = append(jobs, scheduledJobFromAWS(awsjob))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].RunAt < jobs[j].RunAt
+	})
+	return jobs, unmarshalErr
+}
+
+func scheduledJobFromAWS(awsjob AWSScheduledJob) persistence.ScheduledJob {
+	return persistence.ScheduledJob{
+		ID:          awsjob.ID,
+		Kind:        awsjob.Kind,
+		Group:       awsjob.JobGroup,
+		Payload:     awsjob.Payload,
+		RunAt:       awsjob.RunAt,
+		Status:      awsjob.Status,
+		Attempts:    awsjob.Attempts,
+		LastError:   awsjob.LastError,
+		LeasedUntil: awsjob.LeasedUntil,
+	}
+}
+
+func (dynamoLayer *DynamoDBLayer) SaveWebhookSubscription(sub persistence.WebhookSubscription) error {
+	defer dynamoLayer.trace("SaveWebhookSubscription")()
+	av, err := dynamodbattribute.MarshalMap(AWSWebhookSubscription{
+		PK:        "HOOK#" + sub.ID,
+		SK:        "META",
+		ID:        sub.ID,
+		URL:       sub.URL,
+		Secret:    sub.Secret,
+		Events:    sub.Events,
+		Disabled:  sub.Disabled,
+		Failures:  sub.Failures,
+		CreatedAt: sub.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("myevents"),
+		Item:      av,
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindWebhookSubscription(id []byte) (persistence.WebhookSubscription, error) {
+	defer dynamoLayer.trace("FindWebhookSubscription")()
+	result, err := dynamoLayer.service.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("HOOK#" + string(id)),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.WebhookSubscription{}, err
+	}
+	if result.Item == nil {
+		return persistence.WebhookSubscription{}, errors.New("No results found")
+	}
+	awssub := AWSWebhookSubscription{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &awssub)
+	return webhookSubscriptionFromAWS(awssub), err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindAllWebhookSubscriptions() ([]persistence.WebhookSubscription, error) {
+	defer dynamoLayer.trace("FindAllWebhookSubscriptions")()
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(PK, :pk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("HOOK#"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	subs := []persistence.WebhookSubscription{}
+	var unmarshalErr error
+	err := dynamoLayer.service.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		awssubs := []AWSWebhookSubscription{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awssubs)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awssub := range awssubs {
+			subs = append(subs, webhookSubscriptionFromAWS(awssub))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].CreatedAt < subs[j].CreatedAt
+	})
+	return subs, unmarshalErr
+}
+
+func (dynamoLayer *DynamoDBLayer) RemoveWebhookSubscription(id []byte) error {
+	defer dynamoLayer.trace("RemoveWebhookSubscription")()
+	_, err := dynamoLayer.service.DeleteItem(&dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("HOOK#" + string(id)),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) AddWebhookFailure(id []byte, disableAfter int) (persistence.WebhookSubscription, error) {
+	defer dynamoLayer.trace("AddWebhookFailure")()
+	//Only the counters are touched, so that failures of deliveries that are posted at the same time
+	//all count, and changes the partner makes to the subscription meanwhile are kept.
+	result, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                 webhookSubscriptionKey(id),
+		UpdateExpression:    aws.String("ADD Failures :one"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+		TableName:    aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.WebhookSubscription{}, err
+	}
+	awssub := AWSWebhookSubscription{}
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &awssub); err != nil {
+		return persistence.WebhookSubscription{}, err
+	}
+	sub := webhookSubscriptionFromAWS(awssub)
+	if sub.Disabled || sub.Failures < disableAfter {
+		return sub, nil
+	}
+	sub.Disabled = true
+	_, err = dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                 webhookSubscriptionKey(id),
+		UpdateExpression:    aws.String("SET Disabled = :disabled"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":disabled": {BOOL: aws.Bool(true)},
+		},
+		TableName: aws.String("myevents"),
+	})
+	return sub, err
+}
+
+func (dynamoLayer *DynamoDBLayer) ResetWebhookFailures(id []byte) error {
+	defer dynamoLayer.trace("ResetWebhookFailures")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                 webhookSubscriptionKey(id),
+		UpdateExpression:    aws.String("SET Failures = :zero"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":zero": {N: aws.String("0")},
+		},
+		TableName: aws.String("myevents"),
+	})
+	return err
+}
+
+func webhookSubscriptionKey(id []byte) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PK": {
+			S: aws.String("HOOK#" + string(id)),
+		},
+		"SK": {
+			S: aws.String("META"),
+		},
+	}
+}
+
+func webhookSubscriptionFromAWS(awssub AWSWebhookSubscription) persistence.WebhookSubscription {
+	return persistence.WebhookSubscription{
+		ID:        awssub.ID,
+		URL:       awssub.URL,
+		Secret:    awssub.Secret,
+		Events:    awssub.Events,
+		Disabled:  awssub.Disabled,
+		Failures:  awssub.Failures,
+		CreatedAt: awssub.CreatedAt,
+	}
+}
+
+func (dynamoLayer *DynamoDBLayer) AddWebhookDelivery(d persistence.WebhookDelivery) error {
+	defer dynamoLayer.trace("AddWebhookDelivery")()
+	av, err := dynamodbattribute.MarshalMap(AWSWebhookDelivery{
+		PK:             "DELIVERY#" + d.ID,
+		SK:             "META",
+		ID:             d.ID,
+		SubscriptionID: d.SubscriptionID,
+		EventName:      d.EventName,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		NextAttempt:    d.NextAttempt,
+		ResponseStatus: d.ResponseStatus,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt,
+		DeliveredAt:    d.DeliveredAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String("myevents"),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrDeliveryExists
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindDueWebhookDeliveries(now int64) ([]persistence.WebhookDelivery, error) {
+	defer dynamoLayer.trace("FindDueWebhookDeliveries")()
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(PK, :pk) and #status = :pending and NextAttempt <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("DELIVERY#"),
+			},
+			":pending": {
+				S: aws.String(persistence.DeliveryPending),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(now, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	deliveries, err := dynamoLayer.scanWebhookDeliveries(input)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttempt < deliveries[j].NextAttempt
+	})
+	return deliveries, nil
+}
+
+func (dynamoLayer *DynamoDBLayer) ClaimWebhookDelivery(id string, now int64, until int64) error {
+	defer dynamoLayer.trace("ClaimWebhookDelivery")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("DELIVERY#" + id),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		//Only one dispatcher can move the next attempt of a due delivery into the future.
+		UpdateExpression:    aws.String("SET NextAttempt = :until"),
+		ConditionExpression: aws.String("#status = :pending and NextAttempt <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until": {
+				N: aws.String(strconv.FormatInt(until, 10)),
+			},
+			":pending": {
+				S: aws.String(persistence.DeliveryPending),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(now, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrDeliveryClaimed
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) UpdateWebhookDelivery(d persistence.WebhookDelivery) error {
+	defer dynamoLayer.trace("UpdateWebhookDelivery")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("DELIVERY#" + d.ID),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		UpdateExpression: aws.String("SET #status = :status, Attempts = :attempts, NextAttempt = :nextAttempt, ResponseStatus = :responseStatus, LastError = :lastError, DeliveredAt = :deliveredAt"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {
+				S: aws.String(d.Status),
+			},
+			":attempts": {
+				N: aws.String(strconv.Itoa(d.Attempts)),
+			},
+			":nextAttempt": {
+				N: aws.String(strconv.FormatInt(d.NextAttempt, 10)),
+			},
+			":responseStatus": {
+				N: aws.String(strconv.Itoa(d.ResponseStatus)),
+			},
+			":lastError": {
+				S: aws.String(d.LastError),
+			},
+			":deliveredAt": {
+				N: aws.String(strconv.FormatInt(d.DeliveredAt, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindWebhookDeliveriesBySubscriptionId(subscriptionId []byte) ([]persistence.WebhookDelivery, error) {
+	defer dynamoLayer.trace("FindWebhookDeliveriesBySubscriptionId")()
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(PK, :pk) and SubscriptionID = :subscription"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("DELIVERY#"),
+			},
+			":subscription": {
+				S: aws.String(string(subscriptionId)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	deliveries, err := dynamoLayer.scanWebhookDeliveries(input)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt > deliveries[j].CreatedAt
+	})
+	return deliveries, nil
+}
+
+func (dynamoLayer *DynamoDBLayer) scanWebhookDeliveries(input *dynamodb.ScanInput) ([]persistence.WebhookDelivery, error) {
+	deliveries := []persistence.WebhookDelivery{}
+	var unmarshalErr error
+	err := dynamoLayer.service.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		awsdeliveries := []AWSWebhookDelivery{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsdeliveries)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, d := range awsdeliveries {
+			deliveries = append(deliveries, persistence.WebhookDelivery{
+				ID:             d.ID,
+				SubscriptionID: d.SubscriptionID,
+				EventName:      d.EventName,
+				Payload:        d.Payload,
+				Status:         d.Status,
+				Attempts:       d.Attempts,
+				NextAttempt:    d.NextAttempt,
+				ResponseStatus: d.ResponseStatus,
+				LastError:      d.LastError,
+				CreatedAt:      d.CreatedAt,
+				DeliveredAt:    d.DeliveredAt,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, unmarshalErr
+}
+
+func (dynamoLayer *DynamoDBLayer) ReserveCapacity(eventId []byte, seats int, capacity int) error {
+	defer dynamoLayer.trace("ReserveCapacity")()
+	input := &dynamodb.UpdateItemInput{
+		Key:              capacityKey(eventId),
+		UpdateExpression: aws.String("ADD Reserved :seats"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":seats": {N: aws.String(strconv.Itoa(seats))},
+		},
+		TableName: aws.String("myevents"),
+	}
+	//The seats are only added while they fit into what is left of the capacity.
+	if capacity > 0 {
+		if seats > capacity {
+			return persistence.ErrCapacityExceeded
+		}
+		input.ConditionExpression = aws.String("attribute_not_exists(Reserved) OR Reserved <= :max")
+		input.ExpressionAttributeValues[":max"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(capacity - seats))}
+	}
+	_, err := dynamoLayer.service.UpdateItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrCapacityExceeded
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) ReleaseCapacity(eventId []byte, seats int) error {
+	defer dynamoLayer.trace("ReleaseCapacity")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:              capacityKey(eventId),
+		UpdateExpression: aws.String("ADD Reserved :seats"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":seats": {N: aws.String(strconv.Itoa(-seats))},
+		},
+		TableName: aws.String("myevents"),
+	})
+	return err
+}
+
+func capacityKey(eventId []byte) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PK": {
+			S: aws.String("CAPACITY#" + string(eventId)),
+		},
+		"SK": {
+			S: aws.String("META"),
+		},
+	}
+}
+
+func (dynamoLayer *DynamoDBLayer) ReserveSeatCount(key string, seats int, limit int) error {
+	defer dynamoLayer.trace("ReserveSeatCount")()
+	input := &dynamodb.UpdateItemInput{
+		Key:              seatCountKey(key),
+		UpdateExpression: aws.String("ADD Booked :seats"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":seats": {N: aws.String(strconv.Itoa(seats))},
+		},
+		TableName: aws.String("myevents"),
+	}
+	if limit > 0 {
+		if seats > limit {
+			return persistence.ErrCapacityExceeded
+		}
+		input.ConditionExpression = aws.String("attribute_not_exists(Booked) OR Booked <= :max")
+		input.ExpressionAttributeValues[":max"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(limit - seats))}
+	}
+	_, err := dynamoLayer.service.UpdateItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrCapacityExceeded
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) ReleaseSeatCount(key string, seats int) error {
+	defer dynamoLayer.trace("ReleaseSeatCount")()
+	_, err := dynamoLayer.service.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:              seatCountKey(key),
+		UpdateExpression: aws.String("ADD Booked :seats"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":seats": {N: aws.String(strconv.Itoa(-seats))},
+		},
+		TableName: aws.String("myevents"),
+	})
+	return err
+}
+
+func seatCountKey(key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PK": {
+			S: aws.String("BOOKEDSEATS#" + key),
+		},
+		"SK": {
+			S: aws.String("META"),
+		},
+	}
+}
+
+func (dynamoLayer *DynamoDBLayer) AddSeatRequest(req persistence.SeatRequest) error {
+	defer dynamoLayer.trace("AddSeatRequest")()
+	err := dynamoLayer.putSeatRequest(req, "attribute_not_exists(PK)", nil)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrSeatRequestExists
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindSeatRequest(id string) (persistence.SeatRequest, error) {
+	defer dynamoLayer.trace("FindSeatRequest")()
+	result, err := dynamoLayer.service.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("SEATREQ#" + id),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.SeatRequest{}, err
+	}
+	if result.Item == nil {
+		return persistence.SeatRequest{}, errors.New("No results found")
+	}
+	awsreq := AWSSeatRequest{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &awsreq)
+	return persistence.SeatRequest{
+		ID:        awsreq.ID,
+		EventID:   awsreq.EventID,
+		Seats:     awsreq.Seats,
+		Status:    awsreq.Status,
+		Reason:    awsreq.Reason,
+		CreatedAt: awsreq.CreatedAt,
+	}, err
+}
+
+func (dynamoLayer *DynamoDBLayer) UpdateSeatRequest(status string, req persistence.SeatRequest) error {
+	defer dynamoLayer.trace("UpdateSeatRequest")()
+	err := dynamoLayer.putSeatRequest(req, "#status = :expected", map[string]*dynamodb.AttributeValue{
+		":expected": {S: aws.String(status)},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrSeatRequestChanged
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) putSeatRequest(req persistence.SeatRequest, condition string, values map[string]*dynamodb.AttributeValue) error {
+	av, err := dynamodbattribute.MarshalMap(AWSSeatRequest{
+		PK:        "SEATREQ#" + req.ID,
+		SK:        "META",
+		ID:        req.ID,
+		EventID:   req.EventID,
+		Seats:     req.Seats,
+		Status:    req.Status,
+		Reason:    req.Reason,
+		CreatedAt: req.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		TableName:                 aws.String("myevents"),
+		Item:                      av,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	}
+	if values != nil {
+		input.ExpressionAttributeNames = map[string]*string{
+			"#status": aws.String("Status"),
+		}
+	}
+	_, err = dynamoLayer.service.PutItem(input)
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) AddBookingSaga(saga persistence.BookingSaga) error {
+	defer dynamoLayer.trace("AddBookingSaga")()
+	return dynamoLayer.putBookingSaga(saga, "attribute_not_exists(PK)", nil)
+}
+
+func (dynamoLayer *DynamoDBLayer) FindBookingSaga(id string) (persistence.BookingSaga, error) {
+	defer dynamoLayer.trace("FindBookingSaga")()
+	result, err := dynamoLayer.service.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("SAGA#" + id),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.BookingSaga{}, err
+	}
+	if result.Item == nil {
+		return persistence.BookingSaga{}, errors.New("No results found")
+	}
+	awssaga := AWSBookingSaga{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &awssaga)
+	return bookingSagaFromAWS(awssaga), err
+}
+
+func (dynamoLayer *DynamoDBLayer) UpdateBookingSaga(status string, saga persistence.BookingSaga) error {
+	defer dynamoLayer.trace("UpdateBookingSaga")()
+	err := dynamoLayer.putBookingSaga(saga, "#status = :expected", map[string]*dynamodb.AttributeValue{
+		":expected": {S: aws.String(status)},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrSagaStatusChanged
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindTimedOutBookingSagas(now int64) ([]persistence.BookingSaga, error) {
+	defer dynamoLayer.trace("FindTimedOutBookingSagas")()
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("begins_with(PK, :pk) and (#status = :reserving or #status = :holding) and Deadline < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("SAGA#"),
+			},
+			":reserving": {
+				S: aws.String(persistence.SagaReserving),
+			},
+			":holding": {
+				S: aws.String(persistence.SagaHolding),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(now, 10)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	sagas := []persistence.BookingSaga{}
+	var unmarshalErr error
+	err := dynamoLayer.service.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		awssagas := []AWSBookingSaga{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awssagas)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awssaga := range awssagas {
+			sagas = append(sagas, bookingSagaFromAWS(awssaga))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sagas, func(i, j int) bool {
+		return sagas[i].Deadline < sagas[j].Deadline
+	})
+	return sagas, unmarshalErr
+}
+
+func (dynamoLayer *DynamoDBLayer) putBookingSaga(saga persistence.BookingSaga, condition string, values map[string]*dynamodb.AttributeValue) error {
+	av, err := dynamodbattribute.MarshalMap(AWSBookingSaga{
+		PK:         "SAGA#" + saga.ID,
+		SK:         "META",
+		ID:         saga.ID,
+		UserID:     saga.UserID,
+		EventID:    saga.EventID,
+		Seats:      saga.Seats,
+		SeatIDs:    saga.SeatIDs,
+		TicketType: saga.TicketType,
+		PromoCode:  saga.PromoCode,
+		Offer:      saga.Offer,
+		Status:     saga.Status,
+		BookingID:  saga.BookingID,
+		Reason:     saga.Reason,
+		CreatedAt:  saga.CreatedAt,
+		Deadline:   saga.Deadline,
+	})
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		TableName:                 aws.String("myevents"),
+		Item:                      av,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	}
+	if values != nil {
+		input.ExpressionAttributeNames = map[string]*string{
+			"#status": aws.String("Status"),
+		}
+	}
+	_, err = dynamoLayer.service.PutItem(input)
+	return err
+}
+
+func bookingSagaFromAWS(awssaga AWSBookingSaga) persistence.BookingSaga {
+	return persistence.BookingSaga{
+		ID:         awssaga.ID,
+		UserID:     awssaga.UserID,
+		EventID:    awssaga.EventID,
+		Seats:      awssaga.Seats,
+		SeatIDs:    awssaga.SeatIDs,
+		TicketType: awssaga.TicketType,
+		PromoCode:  awssaga.PromoCode,
+		Offer:      awssaga.Offer,
+		Status:     awssaga.Status,
+		BookingID:  awssaga.BookingID,
+		Reason:     awssaga.Reason,
+		CreatedAt:  awssaga.CreatedAt,
+		Deadline:   awssaga.Deadline,
+	}
+}
+
+func (dynamoLayer *DynamoDBLayer) AddPromoCode(pc persistence.PromoCode) error {
+	defer dynamoLayer.trace("AddPromoCode")()
+	av, err := dynamodbattribute.MarshalMap(AWSPromoCode{
+		PK:             "PROMO#" + pc.EventID,
+		SK:             pc.Code,
+		EventID:        pc.EventID,
+		Code:           pc.Code,
+		Kind:           pc.Kind,
+		Percent:        pc.Percent,
+		Amount:         pc.Amount,
+		Currency:       pc.Currency,
+		MaxUses:        pc.MaxUses,
+		MaxUsesPerUser: pc.MaxUsesPerUser,
+		Uses:           pc.Uses,
+		ValidFrom:      pc.ValidFrom,
+		ValidUntil:     pc.ValidUntil,
+		TicketTypes:    pc.TicketTypes,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String("myevents"),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrPromoCodeExists
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindPromoCode(eventId []byte, code string) (persistence.PromoCode, error) {
+	defer dynamoLayer.trace("FindPromoCode")()
+	result, err := dynamoLayer.service.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("PROMO#" + string(eventId)),
+			},
+			"SK": {
+				S: aws.String(code),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.PromoCode{}, err
+	}
+	if result.Item == nil {
+		return persistence.PromoCode{}, errors.New("No results found")
+	}
+	awscode := AWSPromoCode{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &awscode)
+	return promoCodeFromAWS(awscode), err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindPromoCodesByEventId(eventId []byte) ([]persistence.PromoCode, error) {
+	defer dynamoLayer.trace("FindPromoCodesByEventId")()
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("PROMO#" + string(eventId)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	codes := []persistence.PromoCode{}
+	var unmarshalErr error
+	err := dynamoLayer.service.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		awscodes := []AWSPromoCode{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awscodes)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awscode := range awscodes {
+			codes = append(codes, promoCodeFromAWS(awscode))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, unmarshalErr
+}
+
+func (dynamoLayer *DynamoDBLayer) RedeemPromoCode(eventId []byte, code string, userId []byte) error {
+	defer dynamoLayer.trace("RedeemPromoCode")()
+	pc, err := dynamoLayer.FindPromoCode(eventId, code)
+	if err != nil {
+		return err
+	}
+
+	//The use of the code and the use by the user are counted in a single transaction, so that
+	//neither count can move without the other. Both updates only go through below their limits.
+	codeUpdate := &dynamodb.Update{
+		Key:                 promoCodeKey(eventId, code),
+		UpdateExpression:    aws.String("SET Uses = Uses + :one"),
+		ConditionExpression: aws.String("MaxUses = :zero OR Uses < MaxUses"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":  {N: aws.String("1")},
+			":zero": {N: aws.String("0")},
+		},
+		TableName: aws.String("myevents"),
+	}
+	userUpdate := &dynamodb.Update{
+		Key:              promoRedemptionKey(eventId, code, userId),
+		UpdateExpression: aws.String("ADD Uses :one"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+		},
+		TableName: aws.String("myevents"),
+	}
+	if pc.MaxUsesPerUser > 0 {
+		userUpdate.ConditionExpression = aws.String("attribute_not_exists(Uses) OR Uses < :max")
+		userUpdate.ExpressionAttributeValues[":max"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(pc.MaxUsesPerUser))}
+	}
+
+	_, err = dynamoLayer.service.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{{Update: codeUpdate}, {Update: userUpdate}},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeTransactionCanceledException {
+		//The transaction doesn't tell us which of the conditions failed, so we look at the code
+		//again to find out which limit was hit.
+		pc, err = dynamoLayer.FindPromoCode(eventId, code)
+		if err == nil && pc.MaxUses > 0 && pc.Uses >= pc.MaxUses {
+			return persistence.ErrPromoCodeUsedUp
+		}
+		return persistence.ErrPromoCodeUserLimit
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) ReleasePromoCode(eventId []byte, code string, userId []byte) error {
+	defer dynamoLayer.trace("ReleasePromoCode")()
+	minusOne := map[string]*dynamodb.AttributeValue{
+		":minusOne": {N: aws.String("-1")},
+	}
+	_, err := dynamoLayer.service.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Update: &dynamodb.Update{
+				Key:                       promoCodeKey(eventId, code),
+				UpdateExpression:          aws.String("ADD Uses :minusOne"),
+				ExpressionAttributeValues: minusOne,
+				TableName:                 aws.String("myevents"),
+			}},
+			{Update: &dynamodb.Update{
+				Key:                       promoRedemptionKey(eventId, code, userId),
+				UpdateExpression:          aws.String("ADD Uses :minusOne"),
+				ExpressionAttributeValues: minusOne,
+				TableName:                 aws.String("myevents"),
+			}},
+		},
+	})
+	return err
+}
+
+func promoCodeKey(eventId []byte, code string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PK": {
+			S: aws.String("PROMO#" + string(eventId)),
+		},
+		"SK": {
+			S: aws.String(code),
+		},
+	}
+}
+
+func promoRedemptionKey(eventId []byte, code string, userId []byte) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PK": {
+			S: aws.String("PROMO#" + string(eventId) + "#" + code),
+		},
+		"SK": {
+			S: aws.String("USR#" + string(userId)),
+		},
+	}
+}
+
+func promoCodeFromAWS(awscode AWSPromoCode) persistence.PromoCode {
+	return persistence.PromoCode{
+		ID:             awscode.EventID + "#" + awscode.Code,
+		EventID:        awscode.EventID,
+		Code:           awscode.Code,
+		Kind:           awscode.Kind,
+		Percent:        awscode.Percent,
+		Amount:         awscode.Amount,
+		Currency:       awscode.Currency,
+		MaxUses:        awscode.MaxUses,
+		MaxUsesPerUser: awscode.MaxUsesPerUser,
+		Uses:           awscode.Uses,
+		ValidFrom:      awscode.ValidFrom,
+		ValidUntil:     awscode.ValidUntil,
+		TicketTypes:    awscode.TicketTypes,
+	}
+}
+
+func (dynamoLayer *DynamoDBLayer) CheckInTicket(checkIn persistence.TicketCheckIn) error {
+	defer dynamoLayer.trace("CheckInTicket")()
+	av, err := dynamodbattribute.MarshalMap(AWSCheckIn{
+		PK:          "CHECKIN#" + checkIn.EventID,
+		SK:          checkIn.ID,
+		EventID:     checkIn.EventID,
+		BookingID:   checkIn.BookingID,
+		UserID:      checkIn.UserID,
+		Seat:        checkIn.Seat,
+		CheckedInAt: checkIn.CheckedInAt,
+	})
+	if err != nil {
+		return err
+	}
+	//The condition makes sure a ticket is only used once.
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String("myevents"),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrTicketCheckedIn
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindCheckIn(eventId []byte, ticketId string) (persistence.TicketCheckIn, error) {
+	defer dynamoLayer.trace("FindCheckIn")()
+	result, err := dynamoLayer.service.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("CHECKIN#" + string(eventId)),
+			},
+			"SK": {
+				S: aws.String(ticketId),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.TicketCheckIn{}, err
+	}
+	if result.Item == nil {
+		return persistence.TicketCheckIn{}, errors.New("No results found")
+	}
+	awscheckin := AWSCheckIn{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &awscheckin)
+	return checkInFromAWS(awscheckin), err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindCheckInsByEventId(eventId []byte) ([]persistence.TicketCheckIn, error) {
+	defer dynamoLayer.trace("FindCheckInsByEventId")()
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("CHECKIN#" + string(eventId)),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	checkIns := []persistence.TicketCheckIn{}
+	var unmarshalErr error
+	err := dynamoLayer.service.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		awscheckins := []AWSCheckIn{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awscheckins)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awscheckin := range awscheckins {
+			checkIns = append(checkIns, checkInFromAWS(awscheckin))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(checkIns, func(i, j int) bool {
+		return checkIns[i].CheckedInAt < checkIns[j].CheckedInAt
+	})
+	return checkIns, unmarshalErr
+}
+
+func checkInFromAWS(awscheckin AWSCheckIn) persistence.TicketCheckIn {
+	return persistence.TicketCheckIn{
+		ID:          awscheckin.SK,
+		EventID:     awscheckin.EventID,
+		BookingID:   awscheckin.BookingID,
+		UserID:      awscheckin.UserID,
+		Seat:        awscheckin.Seat,
+		CheckedInAt: awscheckin.CheckedInAt,
+	}
+}
+
+func (dynamoLayer *DynamoDBLayer) AddLocation(l persistence.Location) ([]byte, error) {
+	defer dynamoLayer.trace("AddLocation")()
+	if l.ID == "" {
+		l.ID = "LOC#" + uuid.NewV4().String()
+	}
+	av, err := dynamodbattribute.MarshalMap(AWSLocation{
+		PK:        l.ID,
+		SK:        "META",
+		Name:      l.Name,
+		Address:   l.Address,
+		Country:   l.Country,
+		TimeZone:  l.TimeZone,
+		OpenTime:  l.OpenTime,
+		CloseTime: l.CloseTime,
+		Halls:     l.Halls,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("myevents"),
+		Item:      av,
+	})
+	return []byte(l.ID), err
+}
+
+func (dynamoLayer *DynamoDBLayer) FindLocation(id []byte) (persistence.Location, error) {
+	defer dynamoLayer.trace("FindLocation")()
+	result, err := dynamoLayer.service.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(string(id)),
+			},
+			"SK": {
+				S: aws.String("META"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if err != nil {
+		return persistence.Location{}, err
+	}
+	if result.Item == nil {
+		return persistence.Location{}, errors.New("No results found")
+	}
+	awslocation := AWSLocation{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &awslocation)
+	return persistence.Location{
+		ID:        awslocation.PK,
+		Name:      awslocation.Name,
+		Address:   awslocation.Address,
+		Country:   awslocation.Country,
+		TimeZone:  awslocation.TimeZone,
+		OpenTime:  awslocation.OpenTime,
+		CloseTime: awslocation.CloseTime,
+		Halls:     awslocation.Halls,
+	}, err
+}
+
+//FindEventsAtLocation has no index to work with and scans the table for the events of the location.
+func (dynamoLayer *DynamoDBLayer) FindEventsAtLocation(locationId []byte, from int64, to int64) ([]persistence.Event, error) {
+	defer dynamoLayer.trace("FindEventsAtLocation")()
+	input := &dynamodb.ScanInput{
+		FilterExpression: aws.String("LocationID = :location AND begins_with(SK, :meta) AND StartTime < :to AND EndTime > :from AND (attribute_not_exists(CancelledAt) OR CancelledAt = :zero)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":location": {
+				S: aws.String(string(locationId)),
+			},
+			":meta": {
+				S: aws.String("META#"),
+			},
+			":from": {
+				N: aws.String(strconv.FormatInt(from, 10)),
+			},
+			":to": {
+				N: aws.String(strconv.FormatInt(to, 10)),
+			},
+			":zero": {
+				N: aws.String("0"),
+			},
+		},
+		TableName: aws.String("myevents"),
+	}
+	events := []persistence.Event{}
+	var unmarshalErr error
+	err := dynamoLayer.service.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		awsevents := []AWSEvent{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &awsevents)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, awsevent := range awsevents {
+			events = append(events, eventFromAWS(awsevent))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].StartDate < events[j].StartDate
+	})
+	return events, unmarshalErr
+}
+
+//Ping checks whether the table of the layer can be reached, and is ready to be read from and
+//written to.
+func (dynamoLayer *DynamoDBLayer) Ping() error {
+	result, err := dynamoLayer.service.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String("myevents"),
+	})
+	if err != nil {
+		return err
+	}
+	if status := aws.StringValue(result.Table.TableStatus); status != dynamodb.TableStatusActive {
+		return fmt.Errorf("table myevents is %s", status)
+	}
+	return nil
+}
+
+//WithContext returns a layer that shares the DynamoDB client of this one, and records its calls as
+//part of the trace the context carries.
+func (dynamoLayer *DynamoDBLayer) WithContext(ctx context.Context) persistence.DatabaseHandler {
+	bound := *dynamoLayer
+	bound.ctx = ctx
+	return &bound
+}
+
+//trace starts a client span for a call to DynamoDB, if the layer is bound to a trace. It returns the
+//function that finishes the span, so that calls are traced with a single deferred line.
+func (dynamoLayer *DynamoDBLayer) trace(operation string) func() {
+	_, span := tracing.StartChild(dynamoLayer.ctx, "dynamodb "+operation, tracing.Client)
+	span.SetAttribute("db.system", "dynamodb")
+	span.SetAttribute("db.operation", operation)
+	return span.Finish
+}
+
+func (dynamoLayer *DynamoDBLayer) LockHall(key string, token string, now int64, until int64) error {
+	defer dynamoLayer.trace("LockHall")()
+	av, err := dynamodbattribute.MarshalMap(AWSHallLock{
+		PK:    "HALL#" + key,
+		SK:    "LOCK",
+		Token: token,
+		Until: until,
+	})
+	if err != nil {
+		return err
+	}
+	//The lock can only be taken while nobody else holds it.
+	_, err = dynamoLayer.service.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String("myevents"),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(PK) OR #token = :token OR #until <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#token": aws.String("Token"),
+			"#until": aws.String("Until"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":token": {
+				S: aws.String(token),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(now, 10)),
+			},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return persistence.ErrHallLocked
+	}
+	return err
+}
+
+func (dynamoLayer *DynamoDBLayer) UnlockHall(key string, token string) error {
+	defer dynamoLayer.trace("UnlockHall")()
+	_, err := dynamoLayer.service.DeleteItem(&dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("HALL#" + key),
+			},
+			"SK": {
+				S: aws.String("LOCK"),
+			},
+		},
+		ConditionExpression: aws.String("#token = :token"),
+		ExpressionAttributeNames: map[string]*string{
+			"#token": aws.String("Token"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":token": {
+				S: aws.String(token),
+			},
+		},
+		TableName: aws.String("myevents"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		//The lock expired and was taken by someone else.
+		return nil
+	}
+	return err
+}
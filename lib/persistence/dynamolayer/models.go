@@ -1,27 +1,88 @@
 package dynamolayer
 
-import "github.com/aws/aws-sdk-go/service/dynamodb"
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
 
 type DynamoDBLayer struct {
 	service *dynamodb.DynamoDB
+	ctx     context.Context //the trace the calls belong to, see WithContext
 }
 
 type AWSBooking struct {
-	PK         string //User Id: USR#235
-	SK         string //Booking Id: BK#1
-	LocationID string
-	EventID    string
-	Seats      int
+	PK          string //User Id: USR#235
+	SK          string //Booking Id: BK#1
+	LocationID  string
+	EventID     string
+	Seats       int
+	SeatIDs     []string
+	TicketType  string
+	UnitPrice   int64
+	PromoCode   string
+	Discount    int64
+	Total       int64
+	Currency    string
+	PaymentID   string
+	Refunds     []persistence.Refund
+	Date        int64
+	Status      string
+	HoldExpires int64
+	ConfirmedAt int64
+	CancelledAt int64
+	SagaID      string
 }
 
 type AWSEvent struct {
-	PK         string //Event Id: EV#25
-	SK         string //Booking Id: META#25
-	LocationID string
-	EventID    string
-	Name       string
-	EndTime    int64
-	StartTime  int64
+	PK          string //Event Id: EV#25
+	SK          string //Booking Id: META#25
+	LocationID  string
+	EventID     string
+	Name        string
+	EndTime     int64
+	StartTime   int64
+	Capacity    int
+	Hall        string
+	SeatMap     *persistence.SeatMap
+	TicketTypes []persistence.TicketType
+	Policy      *persistence.CancellationPolicy
+	CancelledAt int64
+	SeriesID    string
+	Detached    bool
+	Sequence    int
+	TimeZone    string
+}
+
+type AWSEventSeries struct {
+	PK        string //Series Id: SERIES#25
+	SK        string //META
+	Name      string
+	Rule      string
+	ExDates   []int64
+	TimeZone  string
+	StartTime int64
+	EndTime   int64
+}
+
+type AWSLocation struct {
+	PK        string //Location Id: LOC#25
+	SK        string //META
+	Name      string
+	Address   string
+	Country   string
+	TimeZone  string
+	OpenTime  int
+	CloseTime int
+	Halls     []persistence.Hall
+}
+
+type AWSHallLock struct {
+	PK    string //Lock of a hall: HALL#5d1f.../A
+	SK    string //LOCK
+	Token string
+	Until int64
 }
 
 type AWSUser struct {
@@ -33,3 +94,185 @@ type AWSUser struct {
 	Email    string
 	Age      int
 }
+
+type AWSWaitlistEntry struct {
+	PK           string //Waitlist of an event: WL#EV#25
+	SK           string //User on the waitlist: USR#235
+	EventID      string
+	UserID       string
+	Seats        int
+	TicketType   string
+	JoinedAt     int64
+	Status       string
+	SagaID       string
+	BookingID    string
+	OfferExpires int64
+}
+
+type AWSPromoCode struct {
+	PK             string //Promo codes of an event: PROMO#EV#25
+	SK             string //The code: SUMMER20
+	EventID        string
+	Code           string
+	Kind           string
+	Percent        int
+	Amount         int64
+	Currency       string
+	MaxUses        int
+	MaxUsesPerUser int
+	Uses           int
+	ValidFrom      int64
+	ValidUntil     int64
+	TicketTypes    []string
+}
+
+//AWSPromoRedemption counts how often a user used a promo code.
+type AWSPromoRedemption struct {
+	PK   string //PROMO#EV#25#SUMMER20
+	SK   string //USR#235
+	Uses int
+}
+
+type AWSCheckIn struct {
+	PK          string //Check-ins of an event: CHECKIN#EV#25
+	SK          string //Ticket Id: 5d1f...-1
+	EventID     string
+	BookingID   string
+	UserID      string
+	Seat        string
+	CheckedInAt int64
+}
+
+type AWSNotificationPreferences struct {
+	PK       string //Preferences of a user: PREFS#5d1f...
+	SK       string //META
+	UserID   string
+	Email    string
+	Name     string
+	Locale   string
+	Disabled []string
+}
+
+type AWSAttendee struct {
+	PK        string //Attendees of an event: ATTENDEE#EV#25
+	SK        string //Booking Id
+	EventID   string
+	UserID    string
+	BookingID string
+}
+
+type AWSNotification struct {
+	PK          string //Notification: NOTIFY#event.booked:5d1f...
+	SK          string //META
+	ID          string
+	UserID      string
+	Kind        string
+	Email       string
+	Subject     string
+	Text        string
+	HTML        string
+	Status      string
+	Attempts    int
+	NextAttempt int64
+	LastError   string
+	CreatedAt   int64
+	SentAt      int64
+}
+
+type AWSScheduledJob struct {
+	PK          string //Scheduled job: SCHED#reminder/5d1f.../24h
+	SK          string //META
+	ID          string
+	Kind        string
+	JobGroup    string //Group is a reserved word in DynamoDB
+	Payload     string
+	RunAt       int64
+	Status      string
+	Attempts    int
+	LastError   string
+	LeasedUntil int64
+}
+
+type AWSWebhookSubscription struct {
+	PK        string //Webhook subscription: HOOK#5d1f...
+	SK        string //META
+	ID        string
+	URL       string
+	Secret    string
+	Events    []string
+	Disabled  bool
+	Failures  int
+	CreatedAt int64
+}
+
+type AWSWebhookDelivery struct {
+	PK             string //Webhook delivery: DELIVERY#5d1f.../9a3c...
+	SK             string //META
+	ID             string
+	SubscriptionID string
+	EventName      string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttempt    int64
+	ResponseStatus int
+	LastError      string
+	CreatedAt      int64
+	DeliveredAt    int64
+}
+
+type AWSRefundJob struct {
+	PK         string //Refund job of an event: JOB#REFUND#EV#25
+	SK         string //META
+	EventID    string
+	Status     string
+	StartedAt  int64
+	FinishedAt int64
+	Bookings   int
+	Processed  int
+	Refunded   int64
+	Failed     []string
+}
+
+type AWSSeatReservation struct {
+	PK      string //Seats of an event: SEAT#EV#25
+	SK      string //Seat Id: Balcony-C-12
+	EventID string
+	SeatID  string
+	UserID  string
+}
+
+type AWSCapacity struct {
+	PK       string //Seats reserved for booking sagas: CAPACITY#EV#25
+	SK       string //META
+	Reserved int
+}
+
+type AWSSeatRequest struct {
+	PK        string //Seat request of a booking saga: SEATREQ#9a3c...
+	SK        string //META
+	ID        string
+	EventID   string
+	Seats     int
+	Status    string
+	Reason    string
+	CreatedAt int64
+}
+
+type AWSBookingSaga struct {
+	PK         string //Booking saga: SAGA#9a3c...
+	SK         string //META
+	ID         string
+	UserID     string
+	EventID    string
+	Seats      int
+	SeatIDs    []string
+	TicketType string
+	PromoCode  string
+	Offer      bool
+	Status     string
+	BookingID  string
+	Reason     string
+	CreatedAt  int64
+	Deadline   int64
+}
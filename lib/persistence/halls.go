@@ -0,0 +1,49 @@
+package persistence
+
+import "encoding/hex"
+
+//HallKey identifies a hall of a location across the locations of the database.
+func HallKey(locationID []byte, hall string) string {
+	return hex.EncodeToString(locationID) + "/" + hall
+}
+
+//Overlaps reports whether the event takes place at some point between from and to. An event that
+//ends exactly when the other one starts doesn't overlap it.
+func (e Event) Overlaps(from int64, to int64) bool {
+	return e.StartDate < to && from < e.EndDate
+}
+
+//FindHallConflict returns the first of the events that takes place in the same hall as the event
+//at an overlapping time. A stored event is skipped when it comes across itself, so that it can be
+//checked again after it moved.
+func FindHallConflict(event Event, events []Event) (Event, bool) {
+	if event.Hall == "" {
+		return Event{}, false
+	}
+	for _, other := range events {
+		if (event.ID != "" && other.ID == event.ID) || other.Hall != event.Hall || other.CancelledAt > 0 {
+			continue
+		}
+		if other.Overlaps(event.StartDate, event.EndDate) {
+			return other, true
+		}
+	}
+	return Event{}, false
+}
+
+//FreeHalls returns the halls of the location that none of the events takes place in.
+func (l Location) FreeHalls(events []Event) []Hall {
+	busy := map[string]bool{}
+	for _, event := range events {
+		if event.CancelledAt == 0 {
+			busy[event.Hall] = true
+		}
+	}
+	free := []Hall{}
+	for _, hall := range l.Halls {
+		if !busy[hall.Name] {
+			free = append(free, hall)
+		}
+	}
+	return free
+}
@@ -0,0 +1,626 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
+)
+
+//Instrument wraps a database handler in one that records the duration of every call, and counts
+//the calls that failed, by method.
+func Instrument(db DatabaseHandler) DatabaseHandler {
+	if db == nil {
+		return nil
+	}
+	return &instrumentedHandler{handler: db}
+}
+
+type instrumentedHandler struct {
+	handler DatabaseHandler
+}
+
+func (h *instrumentedHandler) observe(method string, start time.Time, err error) {
+	metrics.DatabaseDuration.Observe(time.Since(start).Seconds(), method)
+	if err != nil {
+		metrics.DatabaseErrors.Inc(method)
+	}
+}
+
+//Ping pings the database of the wrapped handler.
+func (h *instrumentedHandler) Ping() error {
+	return Ping(h.handler)
+}
+
+//WithContext binds the wrapped handler to the context, and keeps recording its calls.
+func (h *instrumentedHandler) WithContext(ctx context.Context) DatabaseHandler {
+	return &instrumentedHandler{handler: WithContext(ctx, h.handler)}
+}
+
+func (h *instrumentedHandler) AddUser(u User) ([]byte, error) {
+	start := time.Now()
+	result, err := h.handler.AddUser(u)
+	h.observe("AddUser", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindUserByName(name string) (User, error) {
+	start := time.Now()
+	result, err := h.handler.FindUserByName(name)
+	h.observe("FindUserByName", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindUserById(id []byte) (User, error) {
+	start := time.Now()
+	result, err := h.handler.FindUserById(id)
+	h.observe("FindUserById", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindAllUsers() ([]User, error) {
+	start := time.Now()
+	result, err := h.handler.FindAllUsers()
+	h.observe("FindAllUsers", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) AddEvent(e Event) ([]byte, error) {
+	start := time.Now()
+	result, err := h.handler.AddEvent(e)
+	h.observe("AddEvent", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindEvent(id []byte) (Event, error) {
+	start := time.Now()
+	result, err := h.handler.FindEvent(id)
+	h.observe("FindEvent", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindEventByName(name string) (Event, error) {
+	start := time.Now()
+	result, err := h.handler.FindEventByName(name)
+	h.observe("FindEventByName", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindAllAvailableEvents() ([]Event, error) {
+	start := time.Now()
+	result, err := h.handler.FindAllAvailableEvents()
+	h.observe("FindAllAvailableEvents", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) CancelEvent(id []byte, cancelledAt int64) error {
+	start := time.Now()
+	err := h.handler.CancelEvent(id, cancelledAt)
+	h.observe("CancelEvent", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) UpdateEvent(e Event) error {
+	start := time.Now()
+	err := h.handler.UpdateEvent(e)
+	h.observe("UpdateEvent", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) AddEventSeries(series EventSeries) ([]byte, error) {
+	start := time.Now()
+	result, err := h.handler.AddEventSeries(series)
+	h.observe("AddEventSeries", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindEventSeries(id []byte) (EventSeries, error) {
+	start := time.Now()
+	result, err := h.handler.FindEventSeries(id)
+	h.observe("FindEventSeries", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpdateEventSeries(series EventSeries) error {
+	start := time.Now()
+	err := h.handler.UpdateEventSeries(series)
+	h.observe("UpdateEventSeries", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindEventsBySeriesId(seriesId []byte) ([]Event, error) {
+	start := time.Now()
+	result, err := h.handler.FindEventsBySeriesId(seriesId)
+	h.observe("FindEventsBySeriesId", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) AddLocation(l Location) ([]byte, error) {
+	start := time.Now()
+	result, err := h.handler.AddLocation(l)
+	h.observe("AddLocation", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindLocation(id []byte) (Location, error) {
+	start := time.Now()
+	result, err := h.handler.FindLocation(id)
+	h.observe("FindLocation", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindEventsAtLocation(locationId []byte, from int64, to int64) ([]Event, error) {
+	start := time.Now()
+	result, err := h.handler.FindEventsAtLocation(locationId, from, to)
+	h.observe("FindEventsAtLocation", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) LockHall(key string, token string, now int64, until int64) error {
+	start := time.Now()
+	err := h.handler.LockHall(key, token, now, until)
+	h.observe("LockHall", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) UnlockHall(key string, token string) error {
+	start := time.Now()
+	err := h.handler.UnlockHall(key, token)
+	h.observe("UnlockHall", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) AddBookingForUser(id []byte, bk Booking) ([]byte, error) {
+	start := time.Now()
+	result, err := h.handler.AddBookingForUser(id, bk)
+	h.observe("AddBookingForUser", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindBookingByBookingId(userId []byte, bookingId []byte) (Booking, error) {
+	start := time.Now()
+	result, err := h.handler.FindBookingByBookingId(userId, bookingId)
+	h.observe("FindBookingByBookingId", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindBookingsByUserId(userId []byte) ([]Booking, error) {
+	start := time.Now()
+	result, err := h.handler.FindBookingsByUserId(userId)
+	h.observe("FindBookingsByUserId", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindBookingsByEventId(eventId []byte) ([]Booking, error) {
+	start := time.Now()
+	result, err := h.handler.FindBookingsByEventId(eventId)
+	h.observe("FindBookingsByEventId", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpdateBookingForUser(userId []byte, status string, bk Booking) error {
+	start := time.Now()
+	err := h.handler.UpdateBookingForUser(userId, status, bk)
+	h.observe("UpdateBookingForUser", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) ExpireBookingHolds(now int64) ([]Booking, error) {
+	start := time.Now()
+	result, err := h.handler.ExpireBookingHolds(now)
+	h.observe("ExpireBookingHolds", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) AddRefundToBooking(userId []byte, bookingId []byte, refund Refund) error {
+	start := time.Now()
+	err := h.handler.AddRefundToBooking(userId, bookingId, refund)
+	h.observe("AddRefundToBooking", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) UpdateRefundOfBooking(userId []byte, bookingId []byte, refund Refund) error {
+	start := time.Now()
+	err := h.handler.UpdateRefundOfBooking(userId, bookingId, refund)
+	h.observe("UpdateRefundOfBooking", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindBookingsWithPendingRefunds() ([]Booking, error) {
+	start := time.Now()
+	result, err := h.handler.FindBookingsWithPendingRefunds()
+	h.observe("FindBookingsWithPendingRefunds", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) AddWaitlistEntry(wl WaitlistEntry) error {
+	start := time.Now()
+	err := h.handler.AddWaitlistEntry(wl)
+	h.observe("AddWaitlistEntry", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindWaitlistByEventId(eventId []byte) ([]WaitlistEntry, error) {
+	start := time.Now()
+	result, err := h.handler.FindWaitlistByEventId(eventId)
+	h.observe("FindWaitlistByEventId", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpdateWaitlistEntry(wl WaitlistEntry) error {
+	start := time.Now()
+	err := h.handler.UpdateWaitlistEntry(wl)
+	h.observe("UpdateWaitlistEntry", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) RemoveWaitlistEntry(eventId []byte, userId []byte) error {
+	start := time.Now()
+	err := h.handler.RemoveWaitlistEntry(eventId, userId)
+	h.observe("RemoveWaitlistEntry", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) ReserveSeats(eventId []byte, userId []byte, seatIds []string) error {
+	start := time.Now()
+	err := h.handler.ReserveSeats(eventId, userId, seatIds)
+	h.observe("ReserveSeats", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) ReleaseSeats(eventId []byte, seatIds []string) error {
+	start := time.Now()
+	err := h.handler.ReleaseSeats(eventId, seatIds)
+	h.observe("ReleaseSeats", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindReservedSeats(eventId []byte) ([]SeatReservation, error) {
+	start := time.Now()
+	result, err := h.handler.FindReservedSeats(eventId)
+	h.observe("FindReservedSeats", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) AddPromoCode(pc PromoCode) error {
+	start := time.Now()
+	err := h.handler.AddPromoCode(pc)
+	h.observe("AddPromoCode", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindPromoCode(eventId []byte, code string) (PromoCode, error) {
+	start := time.Now()
+	result, err := h.handler.FindPromoCode(eventId, code)
+	h.observe("FindPromoCode", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindPromoCodesByEventId(eventId []byte) ([]PromoCode, error) {
+	start := time.Now()
+	result, err := h.handler.FindPromoCodesByEventId(eventId)
+	h.observe("FindPromoCodesByEventId", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) RedeemPromoCode(eventId []byte, code string, userId []byte) error {
+	start := time.Now()
+	err := h.handler.RedeemPromoCode(eventId, code, userId)
+	h.observe("RedeemPromoCode", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) ReleasePromoCode(eventId []byte, code string, userId []byte) error {
+	start := time.Now()
+	err := h.handler.ReleasePromoCode(eventId, code, userId)
+	h.observe("ReleasePromoCode", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) CheckInTicket(checkIn TicketCheckIn) error {
+	start := time.Now()
+	err := h.handler.CheckInTicket(checkIn)
+	h.observe("CheckInTicket", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindCheckIn(eventId []byte, ticketId string) (TicketCheckIn, error) {
+	start := time.Now()
+	result, err := h.handler.FindCheckIn(eventId, ticketId)
+	h.observe("FindCheckIn", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindCheckInsByEventId(eventId []byte) ([]TicketCheckIn, error) {
+	start := time.Now()
+	result, err := h.handler.FindCheckInsByEventId(eventId)
+	h.observe("FindCheckInsByEventId", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) SaveRefundJob(job RefundJob) error {
+	start := time.Now()
+	err := h.handler.SaveRefundJob(job)
+	h.observe("SaveRefundJob", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindRefundJob(eventId []byte) (RefundJob, error) {
+	start := time.Now()
+	result, err := h.handler.FindRefundJob(eventId)
+	h.observe("FindRefundJob", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindUnfinishedRefundJobs() ([]RefundJob, error) {
+	start := time.Now()
+	result, err := h.handler.FindUnfinishedRefundJobs()
+	h.observe("FindUnfinishedRefundJobs", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) SaveNotificationPreferences(prefs NotificationPreferences) error {
+	start := time.Now()
+	err := h.handler.SaveNotificationPreferences(prefs)
+	h.observe("SaveNotificationPreferences", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindNotificationPreferences(userId []byte) (NotificationPreferences, error) {
+	start := time.Now()
+	result, err := h.handler.FindNotificationPreferences(userId)
+	h.observe("FindNotificationPreferences", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) AddAttendee(attendee Attendee) error {
+	start := time.Now()
+	err := h.handler.AddAttendee(attendee)
+	h.observe("AddAttendee", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) RemoveAttendee(eventId []byte, bookingId []byte) error {
+	start := time.Now()
+	err := h.handler.RemoveAttendee(eventId, bookingId)
+	h.observe("RemoveAttendee", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindAttendeesByEventId(eventId []byte) ([]Attendee, error) {
+	start := time.Now()
+	result, err := h.handler.FindAttendeesByEventId(eventId)
+	h.observe("FindAttendeesByEventId", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) AddNotification(n Notification) error {
+	start := time.Now()
+	err := h.handler.AddNotification(n)
+	h.observe("AddNotification", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindDueNotifications(now int64) ([]Notification, error) {
+	start := time.Now()
+	result, err := h.handler.FindDueNotifications(now)
+	h.observe("FindDueNotifications", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) ClaimNotification(id string, now int64, until int64) error {
+	start := time.Now()
+	err := h.handler.ClaimNotification(id, now, until)
+	h.observe("ClaimNotification", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) UpdateNotification(n Notification) error {
+	start := time.Now()
+	err := h.handler.UpdateNotification(n)
+	h.observe("UpdateNotification", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindNotificationsByUserId(userId []byte) ([]Notification, error) {
+	start := time.Now()
+	result, err := h.handler.FindNotificationsByUserId(userId)
+	h.observe("FindNotificationsByUserId", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) SaveJob(job ScheduledJob) error {
+	start := time.Now()
+	err := h.handler.SaveJob(job)
+	h.observe("SaveJob", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindJob(id string) (ScheduledJob, error) {
+	start := time.Now()
+	result, err := h.handler.FindJob(id)
+	h.observe("FindJob", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindJobsByGroup(group string) ([]ScheduledJob, error) {
+	start := time.Now()
+	result, err := h.handler.FindJobsByGroup(group)
+	h.observe("FindJobsByGroup", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindDueJobs(now int64) ([]ScheduledJob, error) {
+	start := time.Now()
+	result, err := h.handler.FindDueJobs(now)
+	h.observe("FindDueJobs", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) LeaseJob(id string, now int64, until int64) error {
+	start := time.Now()
+	err := h.handler.LeaseJob(id, now, until)
+	h.observe("LeaseJob", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FinishJob(job ScheduledJob) error {
+	start := time.Now()
+	err := h.handler.FinishJob(job)
+	h.observe("FinishJob", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) SaveWebhookSubscription(sub WebhookSubscription) error {
+	start := time.Now()
+	err := h.handler.SaveWebhookSubscription(sub)
+	h.observe("SaveWebhookSubscription", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindWebhookSubscription(id []byte) (WebhookSubscription, error) {
+	start := time.Now()
+	result, err := h.handler.FindWebhookSubscription(id)
+	h.observe("FindWebhookSubscription", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) FindAllWebhookSubscriptions() ([]WebhookSubscription, error) {
+	start := time.Now()
+	result, err := h.handler.FindAllWebhookSubscriptions()
+	h.observe("FindAllWebhookSubscriptions", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) RemoveWebhookSubscription(id []byte) error {
+	start := time.Now()
+	err := h.handler.RemoveWebhookSubscription(id)
+	h.observe("RemoveWebhookSubscription", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) AddWebhookFailure(id []byte, disableAfter int) (WebhookSubscription, error) {
+	start := time.Now()
+	result, err := h.handler.AddWebhookFailure(id, disableAfter)
+	h.observe("AddWebhookFailure", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) ResetWebhookFailures(id []byte) error {
+	start := time.Now()
+	err := h.handler.ResetWebhookFailures(id)
+	h.observe("ResetWebhookFailures", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) AddWebhookDelivery(d WebhookDelivery) error {
+	start := time.Now()
+	err := h.handler.AddWebhookDelivery(d)
+	h.observe("AddWebhookDelivery", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindDueWebhookDeliveries(now int64) ([]WebhookDelivery, error) {
+	start := time.Now()
+	result, err := h.handler.FindDueWebhookDeliveries(now)
+	h.observe("FindDueWebhookDeliveries", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) ClaimWebhookDelivery(id string, now int64, until int64) error {
+	start := time.Now()
+	err := h.handler.ClaimWebhookDelivery(id, now, until)
+	h.observe("ClaimWebhookDelivery", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) UpdateWebhookDelivery(d WebhookDelivery) error {
+	start := time.Now()
+	err := h.handler.UpdateWebhookDelivery(d)
+	h.observe("UpdateWebhookDelivery", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindWebhookDeliveriesBySubscriptionId(subscriptionId []byte) ([]WebhookDelivery, error) {
+	start := time.Now()
+	result, err := h.handler.FindWebhookDeliveriesBySubscriptionId(subscriptionId)
+	h.observe("FindWebhookDeliveriesBySubscriptionId", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) ReserveCapacity(eventId []byte, seats int, capacity int) error {
+	start := time.Now()
+	err := h.handler.ReserveCapacity(eventId, seats, capacity)
+	h.observe("ReserveCapacity", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) ReleaseCapacity(eventId []byte, seats int) error {
+	start := time.Now()
+	err := h.handler.ReleaseCapacity(eventId, seats)
+	h.observe("ReleaseCapacity", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) ReserveSeatCount(key string, seats int, limit int) error {
+	start := time.Now()
+	err := h.handler.ReserveSeatCount(key, seats, limit)
+	h.observe("ReserveSeatCount", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) ReleaseSeatCount(key string, seats int) error {
+	start := time.Now()
+	err := h.handler.ReleaseSeatCount(key, seats)
+	h.observe("ReleaseSeatCount", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) AddSeatRequest(req SeatRequest) error {
+	start := time.Now()
+	err := h.handler.AddSeatRequest(req)
+	h.observe("AddSeatRequest", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindSeatRequest(id string) (SeatRequest, error) {
+	start := time.Now()
+	result, err := h.handler.FindSeatRequest(id)
+	h.observe("FindSeatRequest", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpdateSeatRequest(status string, req SeatRequest) error {
+	start := time.Now()
+	err := h.handler.UpdateSeatRequest(status, req)
+	h.observe("UpdateSeatRequest", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) AddBookingSaga(saga BookingSaga) error {
+	start := time.Now()
+	err := h.handler.AddBookingSaga(saga)
+	h.observe("AddBookingSaga", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindBookingSaga(id string) (BookingSaga, error) {
+	start := time.Now()
+	result, err := h.handler.FindBookingSaga(id)
+	h.observe("FindBookingSaga", start, err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpdateBookingSaga(status string, saga BookingSaga) error {
+	start := time.Now()
+	err := h.handler.UpdateBookingSaga(status, saga)
+	h.observe("UpdateBookingSaga", start, err)
+	return err
+}
+
+func (h *instrumentedHandler) FindTimedOutBookingSagas(now int64) ([]BookingSaga, error) {
+	start := time.Now()
+	result, err := h.handler.FindTimedOutBookingSagas(now)
+	h.observe("FindTimedOutBookingSagas", start, err)
+	return result, err
+}
@@ -0,0 +1,52 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
+)
+
+//findingHandler implements only the calls the test makes.
+type findingHandler struct {
+	DatabaseHandler
+	ctx context.Context
+}
+
+func (f *findingHandler) FindEvent(id []byte) (Event, error) {
+	if string(id) == "missing" {
+		return Event{}, errors.New("not found")
+	}
+	return Event{ID: string(id)}, nil
+}
+
+func (f *findingHandler) WithContext(ctx context.Context) DatabaseHandler {
+	return &findingHandler{ctx: ctx}
+}
+
+func TestInstrument(t *testing.T) {
+	db := Instrument(&findingHandler{})
+	calls := metrics.DatabaseDuration.Count("FindEvent")
+	failures := metrics.DatabaseErrors.Value("FindEvent")
+
+	if event, err := db.FindEvent([]byte("5a5f5c")); err != nil || event.ID != "5a5f5c" {
+		t.Fatalf("expected the event of the wrapped handler, got %+v (%v)", event, err)
+	}
+	if _, err := db.FindEvent([]byte("missing")); err == nil {
+		t.Fatal("expected the error of the wrapped handler")
+	}
+	if metrics.DatabaseDuration.Count("FindEvent") != calls+2 || metrics.DatabaseErrors.Value("FindEvent") != failures+1 {
+		t.Error("expected both calls to be timed and the failed one to be counted")
+	}
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "trace")
+	bound, ok := WithContext(ctx, db).(*instrumentedHandler)
+	if !ok {
+		t.Fatal("expected a bound handler to stay instrumented")
+	}
+	if bound.handler.(*findingHandler).ctx != ctx {
+		t.Error("expected the wrapped handler to be bound to the context")
+	}
+}
@@ -0,0 +1,24 @@
+package persistence
+
+//Statuses of a scheduled job.
+const (
+	JobPending   = "pending"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+//A ScheduledJob is work that has to be done at a certain time, like sending a reminder before an
+//event starts. Jobs are stored so that they survive restarts, and a replica that runs a job leases
+//it first, so that no two replicas run the same job.
+type ScheduledJob struct {
+	ID          string `bson:"_id"`
+	Kind        string //picks the handler that runs the job
+	Group       string //jobs that belong together, like the reminders of an event
+	Payload     string //JSON encoded input of the handler
+	RunAt       int64
+	Status      string
+	Attempts    int
+	LastError   string
+	LeasedUntil int64 //the job is being run by a replica until then
+}
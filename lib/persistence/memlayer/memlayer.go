@@ -0,0 +1,1236 @@
+//Package memlayer keeps the data of a DatabaseHandler in memory. It follows the MongoDB layer: IDs
+//are ObjectIds, lists come back in the same order, and the conditional updates fail with the same
+//errors, so the services can be tested against it without a database.
+package memlayer
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//ErrNotFound is returned when a document that does not exist is looked up or updated, where the
+//MongoDB layer returns mgo.ErrNotFound.
+var ErrNotFound = errors.New("not found")
+
+//errDuplicate is returned where the MongoDB layer fails with a duplicate key error that it does not
+//map to one of the errors of the persistence package.
+var errDuplicate = errors.New("duplicate key")
+
+type MemoryLayer struct {
+	mutex         sync.Mutex
+	users         []persistence.User
+	events        []persistence.Event
+	series        []persistence.EventSeries
+	locations     []persistence.Location
+	hallLocks     map[string]hallLock
+	waitlist      []persistence.WaitlistEntry
+	seats         []persistence.SeatReservation
+	promoCodes    []persistence.PromoCode
+	redemptions   map[string]int
+	checkIns      []persistence.TicketCheckIn
+	refundJobs    []persistence.RefundJob
+	preferences   []persistence.NotificationPreferences
+	attendees     []persistence.Attendee
+	notifications []persistence.Notification
+	jobs          []persistence.ScheduledJob
+	subscriptions []persistence.WebhookSubscription
+	deliveries    []persistence.WebhookDelivery
+	capacity      map[string]int
+	seatCounts    map[string]int
+	seatRequests  []persistence.SeatRequest
+	sagas         []persistence.BookingSaga
+}
+
+func NewMemoryLayer() *MemoryLayer {
+	return &MemoryLayer{
+		redemptions: map[string]int{},
+		hallLocks:   map[string]hallLock{},
+		capacity:    map[string]int{},
+		seatCounts:  map[string]int{},
+	}
+}
+
+func (m *MemoryLayer) AddUser(u persistence.User) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !bson.ObjectId(u.ID).Valid() {
+		u.ID = string(bson.NewObjectId())
+	}
+	if m.findUser(u.ID) >= 0 {
+		return nil, errDuplicate
+	}
+	u.Bookings = copyBookings(u.Bookings)
+	m.users = append(m.users, u)
+	return []byte(u.ID), nil
+}
+
+func (m *MemoryLayer) FindUserByName(name string) (persistence.User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, u := range m.users {
+		if u.First == name {
+			return copyUser(u), nil
+		}
+	}
+	return persistence.User{}, ErrNotFound
+}
+
+func (m *MemoryLayer) FindUserById(id []byte) (persistence.User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findUser(string(id))
+	if i < 0 {
+		return persistence.User{}, ErrNotFound
+	}
+	return copyUser(m.users[i]), nil
+}
+
+func (m *MemoryLayer) FindAllUsers() ([]persistence.User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	users := []persistence.User{}
+	for _, u := range m.users {
+		users = append(users, copyUser(u))
+	}
+	return users, nil
+}
+
+func (m *MemoryLayer) AddEvent(e persistence.Event) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !bson.ObjectId(e.ID).Valid() {
+		e.ID = string(bson.NewObjectId())
+	}
+	if m.findEvent(e.ID) >= 0 {
+		return nil, errDuplicate
+	}
+	m.events = append(m.events, e)
+	return []byte(e.ID), nil
+}
+
+func (m *MemoryLayer) FindEvent(id []byte) (persistence.Event, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findEvent(string(id))
+	if i < 0 {
+		return persistence.Event{}, ErrNotFound
+	}
+	return m.events[i], nil
+}
+
+func (m *MemoryLayer) FindEventByName(name string) (persistence.Event, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, e := range m.events {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	return persistence.Event{}, ErrNotFound
+}
+
+func (m *MemoryLayer) FindAllAvailableEvents() ([]persistence.Event, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]persistence.Event{}, m.events...), nil
+}
+
+func (m *MemoryLayer) CancelEvent(id []byte, cancelledAt int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findEvent(string(id))
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.events[i].CancelledAt = cancelledAt
+	m.events[i].Sequence++
+	return nil
+}
+
+func (m *MemoryLayer) UpdateEvent(e persistence.Event) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findEvent(e.ID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	stored := &m.events[i]
+	stored.Name = e.Name
+	stored.StartDate = e.StartDate
+	stored.EndDate = e.EndDate
+	stored.Duration = e.Duration
+	stored.Detached = e.Detached
+	stored.Sequence = e.Sequence
+	return nil
+}
+
+func (m *MemoryLayer) AddEventSeries(series persistence.EventSeries) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !bson.ObjectId(series.ID).Valid() {
+		series.ID = string(bson.NewObjectId())
+	}
+	m.series = append(m.series, series)
+	return []byte(series.ID), nil
+}
+
+func (m *MemoryLayer) FindEventSeries(id []byte) (persistence.EventSeries, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, series := range m.series {
+		if series.ID == string(id) {
+			return series, nil
+		}
+	}
+	return persistence.EventSeries{}, ErrNotFound
+}
+
+func (m *MemoryLayer) UpdateEventSeries(series persistence.EventSeries) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := range m.series {
+		if m.series[i].ID == series.ID {
+			m.series[i] = series
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+//FindEventsBySeriesId returns the occurrences of a series in the order in which they take place.
+func (m *MemoryLayer) FindEventsBySeriesId(seriesId []byte) ([]persistence.Event, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	events := []persistence.Event{}
+	for _, e := range m.events {
+		if e.SeriesID == string(seriesId) {
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].StartDate < events[j].StartDate })
+	return events, nil
+}
+
+func (m *MemoryLayer) AddLocation(l persistence.Location) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !bson.ObjectId(l.ID).Valid() {
+		l.ID = string(bson.NewObjectId())
+	}
+	m.locations = append(m.locations, l)
+	return []byte(l.ID), nil
+}
+
+func (m *MemoryLayer) FindLocation(id []byte) (persistence.Location, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, l := range m.locations {
+		if l.ID == string(id) {
+			return l, nil
+		}
+	}
+	return persistence.Location{}, ErrNotFound
+}
+
+func (m *MemoryLayer) FindEventsAtLocation(locationId []byte, from int64, to int64) ([]persistence.Event, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	events := []persistence.Event{}
+	for _, e := range m.events {
+		if e.Location.ID == string(locationId) && e.CancelledAt == 0 && e.StartDate < to && e.EndDate > from {
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].StartDate < events[j].StartDate })
+	return events, nil
+}
+
+type hallLock struct {
+	token string
+	until int64
+}
+
+func (m *MemoryLayer) LockHall(key string, token string, now int64, until int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if lock, ok := m.hallLocks[key]; ok && lock.token != token && lock.until > now {
+		return persistence.ErrHallLocked
+	}
+	m.hallLocks[key] = hallLock{token, until}
+	return nil
+}
+
+func (m *MemoryLayer) UnlockHall(key string, token string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.hallLocks[key].token == token {
+		delete(m.hallLocks, key)
+	}
+	return nil
+}
+
+func (m *MemoryLayer) AddBookingForUser(id []byte, bk persistence.Booking) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findUser(string(id))
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	bk.ID = string(bson.NewObjectId())
+	bk.UserID = bson.ObjectId(id).Hex()
+	m.users[i].Bookings = append(m.users[i].Bookings, copyBooking(bk))
+	return []byte(bk.ID), nil
+}
+
+//FindBookingByBookingId looks through the bookings of every user, like the MongoDB layer does.
+func (m *MemoryLayer) FindBookingByBookingId(userId []byte, bookingId []byte) (persistence.Booking, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, u := range m.users {
+		for _, bk := range u.Bookings {
+			if bk.ID == string(bookingId) {
+				return copyBooking(bk), nil
+			}
+		}
+	}
+	return persistence.Booking{}, ErrNotFound
+}
+
+func (m *MemoryLayer) FindBookingsByUserId(userId []byte) ([]persistence.Booking, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findUser(string(userId))
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	return copyBookings(m.users[i].Bookings), nil
+}
+
+func (m *MemoryLayer) FindBookingsByEventId(eventId []byte) ([]persistence.Booking, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	bookings := []persistence.Booking{}
+	for _, u := range m.users {
+		for _, bk := range u.Bookings {
+			if bk.EventID == string(eventId) {
+				bookings = append(bookings, copyBooking(bk))
+			}
+		}
+	}
+	return bookings, nil
+}
+
+func (m *MemoryLayer) UpdateBookingForUser(userId []byte, status string, bk persistence.Booking) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stored := m.findBooking(string(userId), bk.ID)
+	if stored == nil || stored.Status != status {
+		return persistence.ErrBookingStatusChanged
+	}
+	stored.Status = bk.Status
+	stored.HoldExpires = bk.HoldExpires
+	stored.ConfirmedAt = bk.ConfirmedAt
+	stored.CancelledAt = bk.CancelledAt
+	stored.PaymentID = bk.PaymentID
+	return nil
+}
+
+func (m *MemoryLayer) ExpireBookingHolds(now int64) ([]persistence.Booking, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	expired := []persistence.Booking{}
+	for i := range m.users {
+		for j := range m.users[i].Bookings {
+			bk := &m.users[i].Bookings[j]
+			if bk.Status != persistence.BookingHeld || bk.HoldExpires > now {
+				continue
+			}
+			bk.Status = persistence.BookingExpired
+			expired = append(expired, copyBooking(*bk))
+		}
+	}
+	return expired, nil
+}
+
+func (m *MemoryLayer) AddRefundToBooking(userId []byte, bookingId []byte, refund persistence.Refund) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	bk := m.findBooking(string(userId), string(bookingId))
+	if bk == nil {
+		return ErrNotFound
+	}
+	if _, ok := bk.FindRefund(refund.ID); ok {
+		return persistence.ErrRefundExists
+	}
+	bk.Refunds = append(bk.Refunds, refund)
+	return nil
+}
+
+func (m *MemoryLayer) UpdateRefundOfBooking(userId []byte, bookingId []byte, refund persistence.Refund) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	bk := m.findBooking(string(userId), string(bookingId))
+	if bk == nil {
+		return ErrNotFound
+	}
+	for i := range bk.Refunds {
+		if bk.Refunds[i].ID == refund.ID && bk.Refunds[i].Pending() {
+			bk.Refunds[i].Status = refund.Status
+			bk.Refunds[i].RefundedAt = refund.RefundedAt
+			return nil
+		}
+	}
+	return persistence.ErrRefundSettled
+}
+
+func (m *MemoryLayer) FindBookingsWithPendingRefunds() ([]persistence.Booking, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	bookings := []persistence.Booking{}
+	for _, u := range m.users {
+		for _, bk := range u.Bookings {
+			for _, r := range bk.Refunds {
+				if r.Pending() {
+					bookings = append(bookings, copyBooking(bk))
+					break
+				}
+			}
+		}
+	}
+	return bookings, nil
+}
+
+func (m *MemoryLayer) AddWaitlistEntry(wl persistence.WaitlistEntry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	wl.ID = waitlistEntryId(wl.EventID, wl.UserID)
+	if m.findWaitlistEntry(wl.ID) >= 0 {
+		return persistence.ErrAlreadyOnWaitlist
+	}
+	m.waitlist = append(m.waitlist, wl)
+	return nil
+}
+
+func (m *MemoryLayer) FindWaitlistByEventId(eventId []byte) ([]persistence.WaitlistEntry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entries := []persistence.WaitlistEntry{}
+	for _, wl := range m.waitlist {
+		if wl.EventID == string(eventId) {
+			entries = append(entries, wl)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].JoinedAt < entries[j].JoinedAt })
+	return entries, nil
+}
+
+func (m *MemoryLayer) UpdateWaitlistEntry(wl persistence.WaitlistEntry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	wl.ID = waitlistEntryId(wl.EventID, wl.UserID)
+	i := m.findWaitlistEntry(wl.ID)
+	if i < 0 {
+		return persistence.ErrNotOnWaitlist
+	}
+	m.waitlist[i] = wl
+	return nil
+}
+
+func (m *MemoryLayer) RemoveWaitlistEntry(eventId []byte, userId []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findWaitlistEntry(waitlistEntryId(string(eventId), string(userId)))
+	if i < 0 {
+		return persistence.ErrNotOnWaitlist
+	}
+	m.waitlist = append(m.waitlist[:i], m.waitlist[i+1:]...)
+	return nil
+}
+
+func (m *MemoryLayer) ReserveSeats(eventId []byte, userId []byte, seatIds []string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	picked := map[string]bool{}
+	for _, seatId := range seatIds {
+		if picked[seatId] || m.findSeat(string(eventId), seatId) >= 0 {
+			return persistence.ErrSeatTaken
+		}
+		picked[seatId] = true
+	}
+	for _, seatId := range seatIds {
+		m.seats = append(m.seats, persistence.SeatReservation{EventID: string(eventId), SeatID: seatId, UserID: string(userId)})
+	}
+	return nil
+}
+
+func (m *MemoryLayer) ReleaseSeats(eventId []byte, seatIds []string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, seatId := range seatIds {
+		if i := m.findSeat(string(eventId), seatId); i >= 0 {
+			m.seats = append(m.seats[:i], m.seats[i+1:]...)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryLayer) FindReservedSeats(eventId []byte) ([]persistence.SeatReservation, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	reservations := []persistence.SeatReservation{}
+	for _, r := range m.seats {
+		if r.EventID == string(eventId) {
+			reservations = append(reservations, r)
+		}
+	}
+	return reservations, nil
+}
+
+func (m *MemoryLayer) AddPromoCode(pc persistence.PromoCode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	pc.ID = promoCodeId(pc.EventID, pc.Code)
+	if m.findPromoCode(pc.ID) >= 0 {
+		return persistence.ErrPromoCodeExists
+	}
+	m.promoCodes = append(m.promoCodes, pc)
+	return nil
+}
+
+func (m *MemoryLayer) FindPromoCode(eventId []byte, code string) (persistence.PromoCode, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findPromoCode(promoCodeId(string(eventId), code))
+	if i < 0 {
+		return persistence.PromoCode{}, ErrNotFound
+	}
+	return m.promoCodes[i], nil
+}
+
+func (m *MemoryLayer) FindPromoCodesByEventId(eventId []byte) ([]persistence.PromoCode, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	codes := []persistence.PromoCode{}
+	for _, pc := range m.promoCodes {
+		if pc.EventID == string(eventId) {
+			codes = append(codes, pc)
+		}
+	}
+	sort.SliceStable(codes, func(i, j int) bool { return codes[i].Code < codes[j].Code })
+	return codes, nil
+}
+
+func (m *MemoryLayer) RedeemPromoCode(eventId []byte, code string, userId []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	id := promoCodeId(string(eventId), code)
+	i := m.findPromoCode(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	pc := &m.promoCodes[i]
+	redemptionId := id + "#" + string(userId)
+	if pc.MaxUsesPerUser > 0 && m.redemptions[redemptionId] >= pc.MaxUsesPerUser {
+		return persistence.ErrPromoCodeUserLimit
+	}
+	if pc.MaxUses > 0 && pc.Uses >= pc.MaxUses {
+		return persistence.ErrPromoCodeUsedUp
+	}
+	m.redemptions[redemptionId]++
+	pc.Uses++
+	return nil
+}
+
+func (m *MemoryLayer) ReleasePromoCode(eventId []byte, code string, userId []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	id := promoCodeId(string(eventId), code)
+	i := m.findPromoCode(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.promoCodes[i].Uses--
+	m.redemptions[id+"#"+string(userId)]--
+	return nil
+}
+
+func (m *MemoryLayer) CheckInTicket(checkIn persistence.TicketCheckIn) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, c := range m.checkIns {
+		if c.ID == checkIn.ID {
+			return persistence.ErrTicketCheckedIn
+		}
+	}
+	m.checkIns = append(m.checkIns, checkIn)
+	return nil
+}
+
+func (m *MemoryLayer) FindCheckIn(eventId []byte, ticketId string) (persistence.TicketCheckIn, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, c := range m.checkIns {
+		if c.ID == ticketId {
+			return c, nil
+		}
+	}
+	return persistence.TicketCheckIn{}, ErrNotFound
+}
+
+func (m *MemoryLayer) FindCheckInsByEventId(eventId []byte) ([]persistence.TicketCheckIn, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	checkIns := []persistence.TicketCheckIn{}
+	for _, c := range m.checkIns {
+		if c.EventID == string(eventId) {
+			checkIns = append(checkIns, c)
+		}
+	}
+	sort.SliceStable(checkIns, func(i, j int) bool { return checkIns[i].CheckedInAt < checkIns[j].CheckedInAt })
+	return checkIns, nil
+}
+
+func (m *MemoryLayer) SaveRefundJob(job persistence.RefundJob) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job.ID = job.EventID
+	job.Failed = append([]string{}, job.Failed...)
+	for i := range m.refundJobs {
+		if m.refundJobs[i].ID == job.ID {
+			m.refundJobs[i] = job
+			return nil
+		}
+	}
+	m.refundJobs = append(m.refundJobs, job)
+	return nil
+}
+
+func (m *MemoryLayer) FindRefundJob(eventId []byte) (persistence.RefundJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, job := range m.refundJobs {
+		if job.ID == string(eventId) {
+			return job, nil
+		}
+	}
+	return persistence.RefundJob{}, ErrNotFound
+}
+
+func (m *MemoryLayer) FindUnfinishedRefundJobs() ([]persistence.RefundJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	jobs := []persistence.RefundJob{}
+	for _, job := range m.refundJobs {
+		if job.Status == persistence.RefundJobRunning {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *MemoryLayer) SaveNotificationPreferences(prefs persistence.NotificationPreferences) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := range m.preferences {
+		if m.preferences[i].UserID == prefs.UserID {
+			m.preferences[i] = prefs
+			return nil
+		}
+	}
+	m.preferences = append(m.preferences, prefs)
+	return nil
+}
+
+func (m *MemoryLayer) FindNotificationPreferences(userId []byte) (persistence.NotificationPreferences, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, prefs := range m.preferences {
+		if prefs.UserID == string(userId) {
+			return prefs, nil
+		}
+	}
+	return persistence.NotificationPreferences{}, ErrNotFound
+}
+
+func (m *MemoryLayer) AddAttendee(attendee persistence.Attendee) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	attendee.ID = attendee.BookingID
+	for i := range m.attendees {
+		if m.attendees[i].ID == attendee.ID {
+			m.attendees[i] = attendee
+			return nil
+		}
+	}
+	m.attendees = append(m.attendees, attendee)
+	return nil
+}
+
+func (m *MemoryLayer) RemoveAttendee(eventId []byte, bookingId []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := range m.attendees {
+		if m.attendees[i].ID == string(bookingId) {
+			m.attendees = append(m.attendees[:i], m.attendees[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *MemoryLayer) FindAttendeesByEventId(eventId []byte) ([]persistence.Attendee, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	attendees := []persistence.Attendee{}
+	for _, attendee := range m.attendees {
+		if attendee.EventID == string(eventId) {
+			attendees = append(attendees, attendee)
+		}
+	}
+	return attendees, nil
+}
+
+func (m *MemoryLayer) AddNotification(n persistence.Notification) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.findNotification(n.ID) >= 0 {
+		return persistence.ErrNotificationExists
+	}
+	m.notifications = append(m.notifications, n)
+	return nil
+}
+
+func (m *MemoryLayer) FindDueNotifications(now int64) ([]persistence.Notification, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	notifications := []persistence.Notification{}
+	for _, n := range m.notifications {
+		if n.Status == persistence.NotificationPending && n.NextAttempt <= now {
+			notifications = append(notifications, n)
+		}
+	}
+	sort.SliceStable(notifications, func(i, j int) bool { return notifications[i].NextAttempt < notifications[j].NextAttempt })
+	return notifications, nil
+}
+
+func (m *MemoryLayer) ClaimNotification(id string, now int64, until int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findNotification(id)
+	if i < 0 || m.notifications[i].Status != persistence.NotificationPending || m.notifications[i].NextAttempt > now {
+		return persistence.ErrNotificationClaimed
+	}
+	m.notifications[i].NextAttempt = until
+	return nil
+}
+
+func (m *MemoryLayer) UpdateNotification(n persistence.Notification) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findNotification(n.ID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	stored := &m.notifications[i]
+	stored.Status = n.Status
+	stored.Attempts = n.Attempts
+	stored.NextAttempt = n.NextAttempt
+	stored.LastError = n.LastError
+	stored.SentAt = n.SentAt
+	return nil
+}
+
+func (m *MemoryLayer) FindNotificationsByUserId(userId []byte) ([]persistence.Notification, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	notifications := []persistence.Notification{}
+	for _, n := range m.notifications {
+		if n.UserID == string(userId) {
+			notifications = append(notifications, n)
+		}
+	}
+	sort.SliceStable(notifications, func(i, j int) bool { return notifications[i].CreatedAt > notifications[j].CreatedAt })
+	return notifications, nil
+}
+
+func (m *MemoryLayer) SaveJob(job persistence.ScheduledJob) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if i := m.findJob(job.ID); i >= 0 {
+		m.jobs[i] = job
+		return nil
+	}
+	m.jobs = append(m.jobs, job)
+	return nil
+}
+
+func (m *MemoryLayer) FindJob(id string) (persistence.ScheduledJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findJob(id)
+	if i < 0 {
+		return persistence.ScheduledJob{}, persistence.ErrJobNotFound
+	}
+	return m.jobs[i], nil
+}
+
+func (m *MemoryLayer) FindJobsByGroup(group string) ([]persistence.ScheduledJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	jobs := []persistence.ScheduledJob{}
+	for _, job := range m.jobs {
+		if job.Group == group {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].RunAt < jobs[j].RunAt })
+	return jobs, nil
+}
+
+func (m *MemoryLayer) FindDueJobs(now int64) ([]persistence.ScheduledJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	jobs := []persistence.ScheduledJob{}
+	for _, job := range m.jobs {
+		if job.Status == persistence.JobPending && job.RunAt <= now && job.LeasedUntil < now {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].RunAt < jobs[j].RunAt })
+	return jobs, nil
+}
+
+func (m *MemoryLayer) LeaseJob(id string, now int64, until int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findJob(id)
+	if i < 0 {
+		return persistence.ErrJobLeased
+	}
+	job := &m.jobs[i]
+	if job.Status != persistence.JobPending || job.RunAt > now || job.LeasedUntil >= now {
+		return persistence.ErrJobLeased
+	}
+	job.LeasedUntil = until
+	return nil
+}
+
+func (m *MemoryLayer) FinishJob(job persistence.ScheduledJob) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findJob(job.ID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	stored := &m.jobs[i]
+	if stored.LeasedUntil != job.LeasedUntil {
+		return persistence.ErrJobLeased
+	}
+	stored.Status = job.Status
+	stored.Attempts = job.Attempts
+	stored.RunAt = job.RunAt
+	stored.LastError = job.LastError
+	stored.LeasedUntil = 0
+	return nil
+}
+
+func (m *MemoryLayer) SaveWebhookSubscription(sub persistence.WebhookSubscription) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if i := m.findSubscription(sub.ID); i >= 0 {
+		m.subscriptions[i] = sub
+		return nil
+	}
+	m.subscriptions = append(m.subscriptions, sub)
+	return nil
+}
+
+func (m *MemoryLayer) FindWebhookSubscription(id []byte) (persistence.WebhookSubscription, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findSubscription(string(id))
+	if i < 0 {
+		return persistence.WebhookSubscription{}, ErrNotFound
+	}
+	return m.subscriptions[i], nil
+}
+
+func (m *MemoryLayer) FindAllWebhookSubscriptions() ([]persistence.WebhookSubscription, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	subs := append([]persistence.WebhookSubscription{}, m.subscriptions...)
+	sort.SliceStable(subs, func(i, j int) bool { return subs[i].CreatedAt < subs[j].CreatedAt })
+	return subs, nil
+}
+
+func (m *MemoryLayer) RemoveWebhookSubscription(id []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findSubscription(string(id))
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.subscriptions = append(m.subscriptions[:i], m.subscriptions[i+1:]...)
+	return nil
+}
+
+func (m *MemoryLayer) AddWebhookFailure(id []byte, disableAfter int) (persistence.WebhookSubscription, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findSubscription(string(id))
+	if i < 0 {
+		return persistence.WebhookSubscription{}, ErrNotFound
+	}
+	sub := &m.subscriptions[i]
+	sub.Failures++
+	if sub.Failures >= disableAfter {
+		sub.Disabled = true
+	}
+	return *sub, nil
+}
+
+func (m *MemoryLayer) ResetWebhookFailures(id []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findSubscription(string(id))
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.subscriptions[i].Failures = 0
+	return nil
+}
+
+func (m *MemoryLayer) AddWebhookDelivery(d persistence.WebhookDelivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.findDelivery(d.ID) >= 0 {
+		return persistence.ErrDeliveryExists
+	}
+	m.deliveries = append(m.deliveries, d)
+	return nil
+}
+
+func (m *MemoryLayer) FindDueWebhookDeliveries(now int64) ([]persistence.WebhookDelivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	deliveries := []persistence.WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if d.Status == persistence.DeliveryPending && d.NextAttempt <= now {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool { return deliveries[i].NextAttempt < deliveries[j].NextAttempt })
+	return deliveries, nil
+}
+
+func (m *MemoryLayer) ClaimWebhookDelivery(id string, now int64, until int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findDelivery(id)
+	if i < 0 || m.deliveries[i].Status != persistence.DeliveryPending || m.deliveries[i].NextAttempt > now {
+		return persistence.ErrDeliveryClaimed
+	}
+	m.deliveries[i].NextAttempt = until
+	return nil
+}
+
+func (m *MemoryLayer) UpdateWebhookDelivery(d persistence.WebhookDelivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findDelivery(d.ID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	stored := &m.deliveries[i]
+	stored.Status = d.Status
+	stored.Attempts = d.Attempts
+	stored.NextAttempt = d.NextAttempt
+	stored.ResponseStatus = d.ResponseStatus
+	stored.LastError = d.LastError
+	stored.DeliveredAt = d.DeliveredAt
+	return nil
+}
+
+func (m *MemoryLayer) FindWebhookDeliveriesBySubscriptionId(subscriptionId []byte) ([]persistence.WebhookDelivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	deliveries := []persistence.WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if d.SubscriptionID == string(subscriptionId) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt > deliveries[j].CreatedAt })
+	return deliveries, nil
+}
+
+func (m *MemoryLayer) ReserveCapacity(eventId []byte, seats int, capacity int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	reserved := m.capacity[string(eventId)]
+	if capacity > 0 && reserved+seats > capacity {
+		return persistence.ErrCapacityExceeded
+	}
+	m.capacity[string(eventId)] = reserved + seats
+	return nil
+}
+
+func (m *MemoryLayer) ReleaseCapacity(eventId []byte, seats int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.capacity[string(eventId)]; !ok {
+		return ErrNotFound
+	}
+	m.capacity[string(eventId)] -= seats
+	return nil
+}
+
+func (m *MemoryLayer) ReserveSeatCount(key string, seats int, limit int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	booked := m.seatCounts[key]
+	if limit > 0 && booked+seats > limit {
+		return persistence.ErrCapacityExceeded
+	}
+	m.seatCounts[key] = booked + seats
+	return nil
+}
+
+func (m *MemoryLayer) ReleaseSeatCount(key string, seats int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.seatCounts[key]; !ok {
+		return ErrNotFound
+	}
+	m.seatCounts[key] -= seats
+	return nil
+}
+
+func (m *MemoryLayer) AddSeatRequest(req persistence.SeatRequest) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.findSeatRequest(req.ID) >= 0 {
+		return persistence.ErrSeatRequestExists
+	}
+	m.seatRequests = append(m.seatRequests, req)
+	return nil
+}
+
+func (m *MemoryLayer) FindSeatRequest(id string) (persistence.SeatRequest, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findSeatRequest(id)
+	if i < 0 {
+		return persistence.SeatRequest{}, ErrNotFound
+	}
+	return m.seatRequests[i], nil
+}
+
+func (m *MemoryLayer) UpdateSeatRequest(status string, req persistence.SeatRequest) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findSeatRequest(req.ID)
+	if i < 0 || m.seatRequests[i].Status != status {
+		return persistence.ErrSeatRequestChanged
+	}
+	m.seatRequests[i] = req
+	return nil
+}
+
+func (m *MemoryLayer) AddBookingSaga(saga persistence.BookingSaga) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.findSaga(saga.ID) >= 0 {
+		return errDuplicate
+	}
+	m.sagas = append(m.sagas, saga)
+	return nil
+}
+
+func (m *MemoryLayer) FindBookingSaga(id string) (persistence.BookingSaga, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findSaga(id)
+	if i < 0 {
+		return persistence.BookingSaga{}, ErrNotFound
+	}
+	return m.sagas[i], nil
+}
+
+func (m *MemoryLayer) UpdateBookingSaga(status string, saga persistence.BookingSaga) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findSaga(saga.ID)
+	if i < 0 || m.sagas[i].Status != status {
+		return persistence.ErrSagaStatusChanged
+	}
+	m.sagas[i] = saga
+	return nil
+}
+
+func (m *MemoryLayer) FindTimedOutBookingSagas(now int64) ([]persistence.BookingSaga, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	sagas := []persistence.BookingSaga{}
+	for _, saga := range m.sagas {
+		if saga.Pending() && saga.Deadline < now {
+			sagas = append(sagas, saga)
+		}
+	}
+	sort.SliceStable(sagas, func(i, j int) bool { return sagas[i].Deadline < sagas[j].Deadline })
+	return sagas, nil
+}
+
+//The find helpers below return the index of a document, or -1 if there is none. They expect the
+//mutex to be held.
+
+func (m *MemoryLayer) findUser(id string) int {
+	for i := range m.users {
+		if m.users[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryLayer) findEvent(id string) int {
+	for i := range m.events {
+		if m.events[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+//findBooking returns the stored booking of a user, so that it can be changed in place, or nil.
+func (m *MemoryLayer) findBooking(userId string, bookingId string) *persistence.Booking {
+	i := m.findUser(userId)
+	if i < 0 {
+		return nil
+	}
+	for j := range m.users[i].Bookings {
+		if m.users[i].Bookings[j].ID == bookingId {
+			return &m.users[i].Bookings[j]
+		}
+	}
+	return nil
+}
+
+func (m *MemoryLayer) findWaitlistEntry(id string) int {
+	for i := range m.waitlist {
+		if m.waitlist[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryLayer) findSeat(eventId string, seatId string) int {
+	for i := range m.seats {
+		if m.seats[i].EventID == eventId && m.seats[i].SeatID == seatId {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryLayer) findPromoCode(id string) int {
+	for i := range m.promoCodes {
+		if m.promoCodes[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryLayer) findNotification(id string) int {
+	for i := range m.notifications {
+		if m.notifications[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryLayer) findJob(id string) int {
+	for i := range m.jobs {
+		if m.jobs[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryLayer) findSubscription(id string) int {
+	for i := range m.subscriptions {
+		if m.subscriptions[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryLayer) findDelivery(id string) int {
+	for i := range m.deliveries {
+		if m.deliveries[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryLayer) findSeatRequest(id string) int {
+	for i := range m.seatRequests {
+		if m.seatRequests[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryLayer) findSaga(id string) int {
+	for i := range m.sagas {
+		if m.sagas[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+//Bookings are stored inside of their users, and their slices are copied on the way in and out, so
+//that callers never change what is stored by accident.
+
+func copyUser(u persistence.User) persistence.User {
+	u.Bookings = copyBookings(u.Bookings)
+	return u
+}
+
+func copyBookings(bookings []persistence.Booking) []persistence.Booking {
+	copied := []persistence.Booking{}
+	for _, bk := range bookings {
+		copied = append(copied, copyBooking(bk))
+	}
+	return copied
+}
+
+func copyBooking(bk persistence.Booking) persistence.Booking {
+	if bk.SeatIDs != nil {
+		bk.SeatIDs = append([]string{}, bk.SeatIDs...)
+	}
+	if bk.Refunds != nil {
+		bk.Refunds = append([]persistence.Refund{}, bk.Refunds...)
+	}
+	return bk
+}
+
+func promoCodeId(eventId string, code string) string {
+	return eventId + "#" + code
+}
+
+func waitlistEntryId(eventId string, userId string) string {
+	return eventId + "#" + userId
+}
@@ -2,6 +2,7 @@ package persistence
 
 import (
 	"fmt"
+	"strings"
 )
 
 //The bson.ObjectId type is a special type that represents MongoDB document ID. The bson package
@@ -22,20 +23,90 @@ func (u *User) String() string {
 	return fmt.Sprintf("id: %s, first_name: %s, last_name: %s, Age: %d, Bookings: %v", u.ID, u.First, u.Last, u.Age, u.Bookings)
 }
 
+//A booking moves through a small lifecycle. It starts out as a hold, which reserves seats for a
+//limited amount of time. The user then either confirms the hold or cancels it; holds that are never
+//confirmed are released by a background sweeper and end up expired.
+const (
+	BookingHeld      = "held"
+	BookingConfirmed = "confirmed"
+	BookingCancelled = "cancelled"
+	BookingExpired   = "expired"
+)
+
 type Booking struct {
-	ID      string `bson:"_id"`
-	Date    int64
-	EventID string
-	Seats   int
+	ID          string `bson:"_id"`
+	UserID      string
+	Date        int64
+	EventID     string
+	Seats       int
+	SeatIDs     []string //the assigned seats, for events that are held in a hall with a seat map
+	TicketType  string   //the seats are the quantity of tickets bought of this type
+	UnitPrice   int64    //price of a single ticket in minor units of Currency
+	PromoCode   string
+	Discount    int64 //taken off by the promo code, in minor units of Currency
+	Total       int64 //UnitPrice times Seats, minus the discount
+	Currency    string
+	PaymentID   string //the payment intent that paid for the booking
+	Refunds     []Refund
+	Status      string
+	HoldExpires int64
+	ConfirmedAt int64
+	CancelledAt int64
+	SagaID      string //the booking saga that reserved the seats with the events service
+}
+
+//Active reports whether the booking still occupies seats of its event. Only held and confirmed
+//bookings count against the capacity of an event.
+func (bk Booking) Active() bool {
+	return bk.Status == BookingHeld || bk.Status == BookingConfirmed
+}
+
+//Once an event is sold out users can join its waitlist. Entries are served in the order in which
+//the users joined: when seats free up, the first waiting entry is offered a hold on them. The seats
+//of an offer are reserved with the events service by a booking saga first, while the entry is
+//offering. An offer is accepted by confirming the hold, and lapses if the hold expires.
+const (
+	WaitlistWaiting  = "waiting"
+	WaitlistOffering = "offering"
+	WaitlistOffered  = "offered"
+	WaitlistAccepted = "accepted"
+	WaitlistLapsed   = "lapsed"
+)
+
+type WaitlistEntry struct {
+	ID           string `bson:"_id"`
+	EventID      string
+	UserID       string
+	Seats        int
+	TicketType   string
+	JoinedAt     int64 //unix nanoseconds, so that entries joining in the same second keep their order
+	Status       string
+	SagaID       string //the booking saga that reserves the seats of the offer
+	BookingID    string //the hold that was offered to the user
+	OfferExpires int64
+}
+
+//Pending reports whether the entry is still waiting for, or holding, an offer.
+func (wl WaitlistEntry) Pending() bool {
+	return wl.Status == WaitlistWaiting || wl.Status == WaitlistOffering || wl.Status == WaitlistOffered
 }
 
 type Event struct {
-	ID        string `bson:"_id"`
-	Name      string `dynamodbav:"EventName"`
-	Duration  int
-	StartDate int64 //
-	EndDate   int64
-	Location  Location
+	ID          string `bson:"_id"`
+	Name        string `dynamodbav:"EventName"`
+	Duration    int
+	StartDate   int64 //
+	EndDate     int64
+	Capacity    int                 //0 means the event has no seat limit
+	Hall        string              //name of the hall of the location the event takes place in
+	SeatMap     *SeatMap            //copied from the hall, so that seats can be assigned without the location
+	TicketTypes []TicketType        //an event without ticket types is free
+	Policy      *CancellationPolicy //without a policy, bookings are refunded in full until the cancel cutoff
+	CancelledAt int64               //set when the organizer cancels the event
+	SeriesID    string              //set on the occurrences of a recurring event
+	Detached    bool                //set on occurrences that were edited on their own, edits of the series skip them
+	Sequence    int                 //counts the changes to the event, so calendar apps know to update it
+	Location    Location
 }
 
 type Location struct {
@@ -43,13 +114,28 @@ type Location struct {
 	Name      string
 	Address   string
 	Country   string
-	OpenTime  int
-	CloseTime int
+	TimeZone  string //IANA time zone of the location, e.g. Europe/Berlin
+	OpenTime  int    //hour of the day the location opens, in its time zone
+	CloseTime int    //hour of the day the location closes; at or before OpenTime means after midnight
 	Halls     []Hall
 }
 
+//Venue describes the location the way it is shown to attendees, e.g. "Opera House, Main Street 1".
+func (l Location) Venue() string {
+	parts := []string{}
+	for _, part := range []string{l.Name, l.Address, l.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+//Halls travel in the location.created contract, so their protobuf numbers are part of its wire
+//format, like those of seat maps and ticket types. TestProtobufNumbers keeps them from changing.
 type Hall struct {
-	Name     string `json:"name"`
-	Location string `json:"location,omitempty"`
-	Capacity int    `json:"capacity"`
+	Name     string   `json:"name" protobuf:"1"`
+	Location string   `json:"location,omitempty" protobuf:"2"`
+	Capacity int      `json:"capacity" protobuf:"3"`
+	SeatMap  *SeatMap `json:"seatMap,omitempty" protobuf:"4"`
 }
@@ -3,6 +3,7 @@ package mongolayer
 import (
 	"fmt"
 
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -23,19 +24,43 @@ func (u *MongoUser) String() string {
 }
 
 type MongoBooking struct {
-	ID      bson.ObjectId `bson:"_id"`
-	Date    int64
-	EventID string
-	Seats   int
+	ID          bson.ObjectId `bson:"_id"`
+	UserID      string
+	Date        int64
+	EventID     string
+	Seats       int
+	SeatIDs     []string
+	TicketType  string
+	UnitPrice   int64
+	PromoCode   string
+	Discount    int64
+	Total       int64
+	Currency    string
+	PaymentID   string
+	Refunds     []persistence.Refund
+	Status      string
+	HoldExpires int64
+	ConfirmedAt int64
+	CancelledAt int64
+	SagaID      string
 }
 
 type MongoEvent struct {
-	ID        bson.ObjectId `bson:"_id"`
-	Name      string        `dynamodbav:"EventName"`
-	Duration  int
-	StartDate int64 //
-	EndDate   int64
-	Location  MongoLocation
+	ID          bson.ObjectId `bson:"_id"`
+	Name        string        `dynamodbav:"EventName"`
+	Duration    int
+	StartDate   int64 //
+	EndDate     int64
+	Capacity    int
+	Hall        string
+	SeatMap     *persistence.SeatMap
+	TicketTypes []persistence.TicketType
+	Policy      *persistence.CancellationPolicy
+	CancelledAt int64
+	SeriesID    string
+	Detached    bool
+	Sequence    int
+	Location    MongoLocation
 }
 
 type MongoLocation struct {
@@ -43,13 +68,15 @@ type MongoLocation struct {
 	Name      string
 	Address   string
 	Country   string
+	TimeZone  string
 	OpenTime  int
 	CloseTime int
 	Halls     []MongoHall
 }
 
 type MongoHall struct {
-	Name     string `json:"name"`
-	Location string `json:"location,omitempty"`
-	Capacity int    `json:"capacity"`
+	Name     string               `json:"name"`
+	Location string               `json:"location,omitempty"`
+	Capacity int                  `json:"capacity"`
+	SeatMap  *persistence.SeatMap `json:"seatMap,omitempty"`
 }
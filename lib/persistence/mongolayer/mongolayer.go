@@ -1,32 +1,54 @@
 package mongolayer
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
 const (
-	DB        = "myevents"
-	USERS     = "users"
-	EVENTS    = "events"
-	BOOKINGS  = "bookings"
-	LOCATIONS = "locations"
+	DB            = "myevents"
+	USERS         = "users"
+	EVENTS        = "events"
+	BOOKINGS      = "bookings"
+	LOCATIONS     = "locations"
+	HALLLOCKS     = "halllocks"
+	WAITLIST      = "waitlist"
+	SEATS         = "seatreservations"
+	REFUNDS       = "refundjobs"
+	PROMOCODES    = "promocodes"
+	REDEMPTIONS   = "promoredemptions"
+	CHECKINS      = "checkins"
+	SERIES        = "eventseries"
+	PREFERENCES   = "notificationpreferences"
+	ATTENDEES     = "attendees"
+	NOTIFICATIONS = "notifications"
+	JOBS          = "scheduledjobs"
+	WEBHOOKS      = "webhooksubscriptions"
+	DELIVERIES    = "webhookdeliveries"
+	CAPACITY      = "reservedcapacity"
+	SEATCOUNTS    = "bookedseats"
+	SEATREQUESTS  = "seatrequests"
+	SAGAS         = "bookingsagas"
 )
 
 type MongoDBLayer struct {
 	session *mgo.Session
+	ctx     context.Context //the trace the calls belong to, see WithContext
 }
 
 func NewMongoDBLayer(connection string) (persistence.DatabaseHandler, error) {
 	s, err := mgo.Dial(connection)
 	if err == nil {
-		fmt.Println("Connected to the database")
+		slog.Info("connected to the database")
 	} else {
-		log.Fatal(err)
+		logging.Fatal("could not connect to the database", "error", err)
 	}
 	return &MongoDBLayer{
 		session: s,
@@ -34,6 +56,7 @@ func NewMongoDBLayer(connection string) (persistence.DatabaseHandler, error) {
 }
 
 func (mgoLayer *MongoDBLayer) AddUser(u persistence.User) ([]byte, error) {
+	defer mgoLayer.trace("AddUser")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
 	newUser := &MongoUser{
@@ -41,10 +64,15 @@ func (mgoLayer *MongoDBLayer) AddUser(u persistence.User) ([]byte, error) {
 		Last:  u.Last,
 		Age:   u.Age,
 	}
-	newUser.ID = bson.NewObjectId()
-	return []byte(u.ID), s.DB(DB).C(USERS).Insert(newUser)
+	//Projections of other services store users under the ID the users service gave them.
+	newUser.ID = bson.ObjectId(u.ID)
+	if !newUser.ID.Valid() {
+		newUser.ID = bson.NewObjectId()
+	}
+	return []byte(newUser.ID), s.DB(DB).C(USERS).Insert(newUser)
 }
 func (mgoLayer *MongoDBLayer) FindUserByName(name string) (persistence.User, error) {
+	defer mgoLayer.trace("FindUserByName")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
 	u := MongoUser{}
@@ -62,10 +90,10 @@ func (mgoLayer *MongoDBLayer) FindUserByName(name string) (persistence.User, err
 	}
 }
 func (mgoLayer *MongoDBLayer) FindUserById(id []byte) (persistence.User, error) {
+	defer mgoLayer.trace("FindUserById")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
 	u := MongoUser{}
-	fmt.Printf("%d\n", id)
 	err := s.DB(DB).C(USERS).FindId(bson.ObjectId(id)).One(&u)
 
 	if err != nil {
@@ -80,6 +108,7 @@ func (mgoLayer *MongoDBLayer) FindUserById(id []byte) (persistence.User, error)
 	}
 }
 func (mgoLayer *MongoDBLayer) FindAllUsers() ([]persistence.User, error) {
+	defer mgoLayer.trace("FindAllUsers")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
 	users := []persistence.User{}
@@ -88,6 +117,7 @@ func (mgoLayer *MongoDBLayer) FindAllUsers() ([]persistence.User, error) {
 }
 
 func (mgoLayer *MongoDBLayer) AddLocation(l persistence.Location) ([]byte, error) {
+	defer mgoLayer.trace("AddLocation")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
 	newLocation := &MongoLocation{}
@@ -95,35 +125,82 @@ func (mgoLayer *MongoDBLayer) AddLocation(l persistence.Location) ([]byte, error
 	newLocation.Name = l.Name
 	newLocation.Address = l.Address
 	newLocation.Country = l.Country
+	newLocation.TimeZone = l.TimeZone
 	newLocation.OpenTime = l.OpenTime
 	newLocation.CloseTime = l.CloseTime
-	err := s.DB(DB).C(LOCATIONS).Insert(l)
-	return []byte(l.ID), err
+	if !bson.ObjectId(l.ID).Valid() {
+		l.ID = string(newLocation.ID)
+	}
+	doc, err := objectIdDocument(l)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(l.ID), s.DB(DB).C(LOCATIONS).Insert(doc)
 }
 
-func (mgoLayer *MongoDBLayer) AddEvent(e persistence.Event) ([]byte, error) {
+func (mgoLayer *MongoDBLayer) FindLocation(id []byte) (persistence.Location, error) {
+	defer mgoLayer.trace("FindLocation")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
+	l := persistence.Location{}
+	err := s.DB(DB).C(LOCATIONS).FindId(bson.ObjectId(id)).One(&l)
+	return l, err
+}
 
-	newEvent := &MongoEvent{}
-	//We check if the event ID supplied by the Event argument object is valid and whether the
-	//ID field of the Event object is of the bson.ObjectID type. bson.ObjectID supports a
-	//Valid() method, which we can use to detect whether the ID is a valid MongoDB document ID
-	//or not. If the supplied event ID is not valid, we will create one of our own using the
-	//bson.NewObjectID() function call. We will then repeat the same pattern with the location
-	//embedded object inside the event.
-	if !bson.ObjectId(e.ID).Valid() {
-		newEvent.ID = bson.NewObjectId()
+func (mgoLayer *MongoDBLayer) FindEventsAtLocation(locationId []byte, from int64, to int64) ([]persistence.Event, error) {
+	defer mgoLayer.trace("FindEventsAtLocation")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	events := []persistence.Event{}
+	err := s.DB(DB).C(EVENTS).Find(bson.M{
+		"location._id": string(locationId),
+		"cancelledat":  0,
+		"startdate":    bson.M{"$lt": to},
+		"enddate":      bson.M{"$gt": from},
+	}).Sort("startdate").All(&events)
+	return events, err
+}
+
+func (mgoLayer *MongoDBLayer) LockHall(key string, token string, now int64, until int64) error {
+	defer mgoLayer.trace("LockHall")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//A lock that is still held by another token doesn't match, so the upsert inserts a second lock
+	//of the hall, which fails on the _id.
+	selector := bson.M{
+		"_id": key,
+		"$or": []bson.M{{"token": token}, {"until": bson.M{"$lte": now}}},
 	}
+	_, err := s.DB(DB).C(HALLLOCKS).Upsert(selector, bson.M{"$set": bson.M{"token": token, "until": until}})
+	if mgo.IsDup(err) {
+		return persistence.ErrHallLocked
+	}
+	return err
+}
 
-	newEvent.Name = e.Name
-	newEvent.StartDate = e.StartDate
-	newEvent.EndDate = e.EndDate
-	newEvent.Duration = e.Duration
+func (mgoLayer *MongoDBLayer) UnlockHall(key string, token string) error {
+	defer mgoLayer.trace("UnlockHall")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	err := s.DB(DB).C(HALLLOCKS).Remove(bson.M{"_id": key, "token": token})
+	if err == mgo.ErrNotFound {
+		//The lock expired and was taken by someone else.
+		return nil
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) AddEvent(e persistence.Event) ([]byte, error) {
+	defer mgoLayer.trace("AddEvent")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
 
-	//We do the same with the location ID.
-	if !bson.ObjectId(e.Location.ID).Valid() {
-		newEvent.Location.ID = bson.NewObjectId()
+	//We check whether the event ID supplied by the Event argument object is a valid MongoDB
+	//document ID with the Valid() method of bson.ObjectId. Events that come without a valid ID get
+	//one of our own from bson.NewObjectId(), so that adding several events in a row (like the
+	//occurrences of a recurring event) doesn't store them all under the empty ID.
+	if !bson.ObjectId(e.ID).Valid() {
+		e.ID = string(bson.NewObjectId())
 	}
 
 	//We return two results: the first result is the event ID of the added event, and a second
@@ -136,9 +213,14 @@ func (mgoLayer *MongoDBLayer) AddEvent(e persistence.Event) ([]byte, error) {
 	//EVENTS constant, which has the name of our events collection. Finally we call the Insert()
 	//method of the collection object, with the Event object as an argument, which is why the
 	//code ends up like this:
-	return []byte(e.ID), s.DB(DB).C(EVENTS).Insert(e)
+	doc, err := objectIdDocument(e)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(e.ID), s.DB(DB).C(EVENTS).Insert(doc)
 }
 func (mgoLayer *MongoDBLayer) FindEvent(id []byte) (persistence.Event, error) {
+	defer mgoLayer.trace("FindEvent")()
 	//The id is passed in as a slice of bytes instead of a bson.ObjectId. We do this to ensure
 	//that the FindEvent() method in the Database Handler interface stays as generic as possible.
 	//For example we know that in the world of MongoDB, the ID will be of the bson.ObjectId type,
@@ -156,6 +238,7 @@ func (mgoLayer *MongoDBLayer) FindEvent(id []byte) (persistence.Event, error) {
 	return e, err
 }
 func (mgoLayer *MongoDBLayer) FindEventByName(name string) (persistence.Event, error) {
+	defer mgoLayer.trace("FindEventByName")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
 	e := persistence.Event{}
@@ -167,6 +250,7 @@ func (mgoLayer *MongoDBLayer) FindEventByName(name string) (persistence.Event, e
 	return e, err
 }
 func (mgoLayer *MongoDBLayer) FindAllAvailableEvents() ([]persistence.Event, error) {
+	defer mgoLayer.trace("FindAllAvailableEvents")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
 	events := []persistence.Event{}
@@ -174,18 +258,103 @@ func (mgoLayer *MongoDBLayer) FindAllAvailableEvents() ([]persistence.Event, err
 	return events, err
 }
 
+func (mgoLayer *MongoDBLayer) CancelEvent(id []byte, cancelledAt int64) error {
+	defer mgoLayer.trace("CancelEvent")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	return s.DB(DB).C(EVENTS).UpdateId(bson.ObjectId(id), bson.M{
+		"$set": bson.M{"cancelledat": cancelledAt},
+		"$inc": bson.M{"sequence": 1},
+	})
+}
+
+func (mgoLayer *MongoDBLayer) UpdateEvent(e persistence.Event) error {
+	defer mgoLayer.trace("UpdateEvent")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	return s.DB(DB).C(EVENTS).UpdateId(bson.ObjectId(e.ID), bson.M{"$set": bson.M{
+		"name":      e.Name,
+		"startdate": e.StartDate,
+		"enddate":   e.EndDate,
+		"duration":  e.Duration,
+		"detached":  e.Detached,
+		"sequence":  e.Sequence,
+	}})
+}
+
+func (mgoLayer *MongoDBLayer) AddEventSeries(series persistence.EventSeries) ([]byte, error) {
+	defer mgoLayer.trace("AddEventSeries")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	if !bson.ObjectId(series.ID).Valid() {
+		series.ID = string(bson.NewObjectId())
+	}
+	doc, err := objectIdDocument(series)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(series.ID), s.DB(DB).C(SERIES).Insert(doc)
+}
+
+func (mgoLayer *MongoDBLayer) FindEventSeries(id []byte) (persistence.EventSeries, error) {
+	defer mgoLayer.trace("FindEventSeries")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	series := persistence.EventSeries{}
+	err := s.DB(DB).C(SERIES).FindId(bson.ObjectId(id)).One(&series)
+	return series, err
+}
+
+func (mgoLayer *MongoDBLayer) UpdateEventSeries(series persistence.EventSeries) error {
+	defer mgoLayer.trace("UpdateEventSeries")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	doc, err := objectIdDocument(series)
+	if err != nil {
+		return err
+	}
+	return s.DB(DB).C(SERIES).UpdateId(bson.ObjectId(series.ID), doc)
+}
+
+//FindEventsBySeriesId returns the occurrences of a series in the order in which they take place.
+func (mgoLayer *MongoDBLayer) FindEventsBySeriesId(seriesId []byte) ([]persistence.Event, error) {
+	defer mgoLayer.trace("FindEventsBySeriesId")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	events := []persistence.Event{}
+	err := s.DB(DB).C(EVENTS).Find(bson.M{"seriesid": string(seriesId)}).Sort("startdate").All(&events)
+	return events, err
+}
+
 func (mgoLayer *MongoDBLayer) AddBookingForUser(id []byte, bk persistence.Booking) ([]byte, error) {
+	defer mgoLayer.trace("AddBookingForUser")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
 	newBooking := MongoBooking{
-		ID:      bson.NewObjectId(),
-		Date:    bk.Date,
-		EventID: bk.EventID,
-		Seats:   bk.Seats,
+		ID:          bson.NewObjectId(),
+		UserID:      bson.ObjectId(id).Hex(),
+		Date:        bk.Date,
+		EventID:     bk.EventID,
+		Seats:       bk.Seats,
+		SeatIDs:     bk.SeatIDs,
+		TicketType:  bk.TicketType,
+		UnitPrice:   bk.UnitPrice,
+		PromoCode:   bk.PromoCode,
+		Discount:    bk.Discount,
+		Total:       bk.Total,
+		Currency:    bk.Currency,
+		PaymentID:   bk.PaymentID,
+		Refunds:     bk.Refunds,
+		Status:      bk.Status,
+		HoldExpires: bk.HoldExpires,
+		ConfirmedAt: bk.ConfirmedAt,
+		CancelledAt: bk.CancelledAt,
+		SagaID:      bk.SagaID,
 	}
-	return []byte(bk.ID), s.DB(DB).C(USERS).UpdateId(bson.ObjectId(id), bson.M{"$addToSet": bson.M{"bookings": newBooking}})
+	return []byte(newBooking.ID), s.DB(DB).C(USERS).UpdateId(bson.ObjectId(id), bson.M{"$addToSet": bson.M{"bookings": newBooking}})
 }
 func (mgoLayer *MongoDBLayer) FindBookingByBookingId(userId []byte, bookingId []byte) (persistence.Booking, error) {
+	defer mgoLayer.trace("FindBookingByBookingId")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
 	u := []persistence.User{}
@@ -202,6 +371,7 @@ func (mgoLayer *MongoDBLayer) FindBookingByBookingId(userId []byte, bookingId []
 	return persistence.Booking{}, err
 }
 func (mgoLayer *MongoDBLayer) FindBookingsByUserId(userId []byte) ([]persistence.Booking, error) {
+	defer mgoLayer.trace("FindBookingsByUserId")()
 	s := mgoLayer.getFreshSession()
 	defer s.Close()
 	u := persistence.User{}
@@ -209,6 +379,900 @@ func (mgoLayer *MongoDBLayer) FindBookingsByUserId(userId []byte) ([]persistence
 	return u.Bookings, err
 }
 
+func (mgoLayer *MongoDBLayer) FindBookingsByEventId(eventId []byte) ([]persistence.Booking, error) {
+	defer mgoLayer.trace("FindBookingsByEventId")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	u := []persistence.User{}
+	err := s.DB(DB).C(USERS).Find(bson.M{"bookings.eventid": string(eventId)}).All(&u)
+
+	//Bookings are embedded in the user documents, so the query above only tells us which users
+	//booked the event. We still have to pick the matching bookings out of each user.
+	bookings := []persistence.Booking{}
+	for _, v := range u {
+		for _, vb := range v.Bookings {
+			if vb.EventID == string(eventId) {
+				bookings = append(bookings, vb)
+			}
+		}
+	}
+	return bookings, err
+}
+
+func (mgoLayer *MongoDBLayer) UpdateBookingForUser(userId []byte, status string, bk persistence.Booking) error {
+	defer mgoLayer.trace("UpdateBookingForUser")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+
+	//The $elemMatch selector makes sure that the booking is only updated while it is still in the
+	//expected status, and the positional $ operator then points to exactly that booking within the
+	//bookings array of the user.
+	selector := bson.M{
+		"_id": bson.ObjectId(userId),
+		"bookings": bson.M{"$elemMatch": bson.M{
+			"_id":    bson.ObjectId(bk.ID),
+			"status": status,
+		}},
+	}
+	update := bson.M{"$set": bson.M{
+		"bookings.$.status":      bk.Status,
+		"bookings.$.holdexpires": bk.HoldExpires,
+		"bookings.$.confirmedat": bk.ConfirmedAt,
+		"bookings.$.cancelledat": bk.CancelledAt,
+		"bookings.$.paymentid":   bk.PaymentID,
+	}}
+	err := s.DB(DB).C(USERS).Update(selector, update)
+	if err == mgo.ErrNotFound {
+		return persistence.ErrBookingStatusChanged
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) ExpireBookingHolds(now int64) ([]persistence.Booking, error) {
+	defer mgoLayer.trace("ExpireBookingHolds")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	u := []MongoUser{}
+	err := s.DB(DB).C(USERS).Find(bson.M{"bookings": bson.M{"$elemMatch": bson.M{
+		"status":      persistence.BookingHeld,
+		"holdexpires": bson.M{"$lte": now},
+	}}}).All(&u)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []persistence.Booking{}
+	for _, v := range u {
+		for _, vb := range v.Bookings {
+			if vb.Status != persistence.BookingHeld || vb.HoldExpires > now {
+				continue
+			}
+			bk := persistence.Booking{
+				ID:          string(vb.ID),
+				UserID:      v.ID.Hex(),
+				Date:        vb.Date,
+				EventID:     vb.EventID,
+				Seats:       vb.Seats,
+				SeatIDs:     vb.SeatIDs,
+				TicketType:  vb.TicketType,
+				UnitPrice:   vb.UnitPrice,
+				PromoCode:   vb.PromoCode,
+				Discount:    vb.Discount,
+				Total:       vb.Total,
+				Currency:    vb.Currency,
+				PaymentID:   vb.PaymentID,
+				Refunds:     vb.Refunds,
+				Status:      persistence.BookingExpired,
+				HoldExpires: vb.HoldExpires,
+				SagaID:      vb.SagaID,
+			}
+			//Another request may have confirmed or cancelled the hold since we read it, in which
+			//case it is simply no longer ours to expire.
+			err = mgoLayer.UpdateBookingForUser([]byte(v.ID), persistence.BookingHeld, bk)
+			if err == persistence.ErrBookingStatusChanged {
+				continue
+			}
+			if err != nil {
+				return expired, err
+			}
+			expired = append(expired, bk)
+		}
+	}
+	return expired, nil
+}
+
+func (mgoLayer *MongoDBLayer) AddRefundToBooking(userId []byte, bookingId []byte, refund persistence.Refund) error {
+	defer mgoLayer.trace("AddRefundToBooking")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//The refund is only pushed onto a booking that has no refund with the same ID yet.
+	selector := bson.M{
+		"_id": bson.ObjectId(userId),
+		"bookings": bson.M{"$elemMatch": bson.M{
+			"_id":        bson.ObjectId(bookingId),
+			"refunds.id": bson.M{"$ne": refund.ID},
+		}},
+	}
+	err := s.DB(DB).C(USERS).Update(selector, bson.M{"$push": bson.M{"bookings.$.refunds": refund}})
+	if err != mgo.ErrNotFound {
+		return err
+	}
+	n, err := s.DB(DB).C(USERS).Find(bson.M{"_id": bson.ObjectId(userId), "bookings._id": bson.ObjectId(bookingId)}).Count()
+	if err == nil && n > 0 {
+		return persistence.ErrRefundExists
+	}
+	return mgo.ErrNotFound
+}
+
+func (mgoLayer *MongoDBLayer) UpdateRefundOfBooking(userId []byte, bookingId []byte, refund persistence.Refund) error {
+	defer mgoLayer.trace("UpdateRefundOfBooking")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//The refund sits in an array within an array, which only array filters can point to. mgo
+	//predates them, so the update is sent as a command.
+	result := struct {
+		N           int `bson:"n"`
+		NModified   int `bson:"nModified"`
+		WriteErrors []struct {
+			ErrMsg string `bson:"errmsg"`
+		} `bson:"writeErrors"`
+	}{}
+	err := s.DB(DB).Run(bson.D{
+		{Name: "update", Value: USERS},
+		{Name: "updates", Value: []bson.M{{
+			"q": bson.M{"_id": bson.ObjectId(userId), "bookings._id": bson.ObjectId(bookingId)},
+			"u": bson.M{"$set": bson.M{
+				"bookings.$[b].refunds.$[r].status":     refund.Status,
+				"bookings.$[b].refunds.$[r].refundedat": refund.RefundedAt,
+			}},
+			"arrayFilters": []bson.M{
+				{"b._id": bson.ObjectId(bookingId)},
+				{"r.id": refund.ID, "r.status": persistence.RefundPending},
+			},
+		}}},
+	}, &result)
+	if err != nil {
+		return err
+	}
+	if len(result.WriteErrors) > 0 {
+		return errors.New(result.WriteErrors[0].ErrMsg)
+	}
+	if result.N == 0 {
+		return mgo.ErrNotFound
+	}
+	if result.NModified == 0 {
+		return persistence.ErrRefundSettled
+	}
+	return nil
+}
+
+func (mgoLayer *MongoDBLayer) FindBookingsWithPendingRefunds() ([]persistence.Booking, error) {
+	defer mgoLayer.trace("FindBookingsWithPendingRefunds")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	u := []persistence.User{}
+	err := s.DB(DB).C(USERS).Find(bson.M{"bookings.refunds.status": persistence.RefundPending}).All(&u)
+
+	bookings := []persistence.Booking{}
+	for _, v := range u {
+		for _, vb := range v.Bookings {
+			for _, refund := range vb.Refunds {
+				if refund.Pending() {
+					bookings = append(bookings, vb)
+					break
+				}
+			}
+		}
+	}
+	return bookings, err
+}
+
+func (mgoLayer *MongoDBLayer) AddWaitlistEntry(wl persistence.WaitlistEntry) error {
+	defer mgoLayer.trace("AddWaitlistEntry")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//A user can only be on the waitlist of an event once, so we derive the document ID from the
+	//event and the user. Inserting the same user twice then fails with a duplicate key error.
+	wl.ID = waitlistEntryId(wl.EventID, wl.UserID)
+	err := s.DB(DB).C(WAITLIST).Insert(wl)
+	if mgo.IsDup(err) {
+		return persistence.ErrAlreadyOnWaitlist
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindWaitlistByEventId(eventId []byte) ([]persistence.WaitlistEntry, error) {
+	defer mgoLayer.trace("FindWaitlistByEventId")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	entries := []persistence.WaitlistEntry{}
+	err := s.DB(DB).C(WAITLIST).Find(bson.M{"eventid": string(eventId)}).Sort("joinedat").All(&entries)
+	return entries, err
+}
+
+func (mgoLayer *MongoDBLayer) UpdateWaitlistEntry(wl persistence.WaitlistEntry) error {
+	defer mgoLayer.trace("UpdateWaitlistEntry")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	wl.ID = waitlistEntryId(wl.EventID, wl.UserID)
+	err := s.DB(DB).C(WAITLIST).UpdateId(wl.ID, wl)
+	if err == mgo.ErrNotFound {
+		return persistence.ErrNotOnWaitlist
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) RemoveWaitlistEntry(eventId []byte, userId []byte) error {
+	defer mgoLayer.trace("RemoveWaitlistEntry")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	err := s.DB(DB).C(WAITLIST).RemoveId(waitlistEntryId(string(eventId), string(userId)))
+	if err == mgo.ErrNotFound {
+		return persistence.ErrNotOnWaitlist
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) ReserveSeats(eventId []byte, userId []byte, seatIds []string) error {
+	defer mgoLayer.trace("ReserveSeats")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+
+	//MongoDB can't insert several documents atomically for us, but every reservation has the
+	//event and seat as its document ID. If one of the inserts fails because the seat is taken we
+	//remove the reservations we made so far, so that in the end either all seats are ours or none.
+	reserved := []string{}
+	for _, seatId := range seatIds {
+		err := s.DB(DB).C(SEATS).Insert(bson.M{
+			"_id":     seatReservationId(string(eventId), seatId),
+			"eventid": string(eventId),
+			"seatid":  seatId,
+			"userid":  string(userId),
+		})
+		if err != nil {
+			for _, r := range reserved {
+				s.DB(DB).C(SEATS).RemoveId(seatReservationId(string(eventId), r))
+			}
+			if mgo.IsDup(err) {
+				return persistence.ErrSeatTaken
+			}
+			return err
+		}
+		reserved = append(reserved, seatId)
+	}
+	return nil
+}
+
+func (mgoLayer *MongoDBLayer) ReleaseSeats(eventId []byte, seatIds []string) error {
+	defer mgoLayer.trace("ReleaseSeats")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	ids := []string{}
+	for _, seatId := range seatIds {
+		ids = append(ids, seatReservationId(string(eventId), seatId))
+	}
+	_, err := s.DB(DB).C(SEATS).RemoveAll(bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindReservedSeats(eventId []byte) ([]persistence.SeatReservation, error) {
+	defer mgoLayer.trace("FindReservedSeats")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	reservations := []persistence.SeatReservation{}
+	err := s.DB(DB).C(SEATS).Find(bson.M{"eventid": string(eventId)}).All(&reservations)
+	return reservations, err
+}
+
+func (mgoLayer *MongoDBLayer) AddPromoCode(pc persistence.PromoCode) error {
+	defer mgoLayer.trace("AddPromoCode")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	pc.ID = promoCodeId(pc.EventID, pc.Code)
+	err := s.DB(DB).C(PROMOCODES).Insert(pc)
+	if mgo.IsDup(err) {
+		return persistence.ErrPromoCodeExists
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindPromoCode(eventId []byte, code string) (persistence.PromoCode, error) {
+	defer mgoLayer.trace("FindPromoCode")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	pc := persistence.PromoCode{}
+	err := s.DB(DB).C(PROMOCODES).FindId(promoCodeId(string(eventId), code)).One(&pc)
+	return pc, err
+}
+
+func (mgoLayer *MongoDBLayer) FindPromoCodesByEventId(eventId []byte) ([]persistence.PromoCode, error) {
+	defer mgoLayer.trace("FindPromoCodesByEventId")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	codes := []persistence.PromoCode{}
+	err := s.DB(DB).C(PROMOCODES).Find(bson.M{"eventid": string(eventId)}).Sort("code").All(&codes)
+	return codes, err
+}
+
+func (mgoLayer *MongoDBLayer) RedeemPromoCode(eventId []byte, code string, userId []byte) error {
+	defer mgoLayer.trace("RedeemPromoCode")()
+	pc, err := mgoLayer.FindPromoCode(eventId, code)
+	if err != nil {
+		return err
+	}
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	id := promoCodeId(string(eventId), code)
+	redemptionId := id + "#" + string(userId)
+
+	//The uses of every user are counted in their own document. The upsert only matches while the
+	//user is below the limit; once the limit is reached it tries to insert a second document with
+	//the same ID instead, which fails with a duplicate key error.
+	redemption := bson.M{"_id": redemptionId}
+	if pc.MaxUsesPerUser > 0 {
+		redemption["uses"] = bson.M{"$lt": pc.MaxUsesPerUser}
+	}
+	_, err = s.DB(DB).C(REDEMPTIONS).Upsert(redemption, bson.M{"$inc": bson.M{"uses": 1}})
+	if mgo.IsDup(err) {
+		return persistence.ErrPromoCodeUserLimit
+	}
+	if err != nil {
+		return err
+	}
+
+	selector := bson.M{"_id": id}
+	if pc.MaxUses > 0 {
+		selector["uses"] = bson.M{"$lt": pc.MaxUses}
+	}
+	err = s.DB(DB).C(PROMOCODES).Update(selector, bson.M{"$inc": bson.M{"uses": 1}})
+	if err != nil {
+		s.DB(DB).C(REDEMPTIONS).UpdateId(redemptionId, bson.M{"$inc": bson.M{"uses": -1}})
+	}
+	if err == mgo.ErrNotFound {
+		return persistence.ErrPromoCodeUsedUp
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) ReleasePromoCode(eventId []byte, code string, userId []byte) error {
+	defer mgoLayer.trace("ReleasePromoCode")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	id := promoCodeId(string(eventId), code)
+	err := s.DB(DB).C(PROMOCODES).UpdateId(id, bson.M{"$inc": bson.M{"uses": -1}})
+	if err != nil {
+		return err
+	}
+	return s.DB(DB).C(REDEMPTIONS).UpdateId(id+"#"+string(userId), bson.M{"$inc": bson.M{"uses": -1}})
+}
+
+func (mgoLayer *MongoDBLayer) CheckInTicket(checkIn persistence.TicketCheckIn) error {
+	defer mgoLayer.trace("CheckInTicket")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//The ticket ID is the document ID, so the insert itself makes sure a ticket is only used once.
+	err := s.DB(DB).C(CHECKINS).Insert(checkIn)
+	if mgo.IsDup(err) {
+		return persistence.ErrTicketCheckedIn
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindCheckIn(eventId []byte, ticketId string) (persistence.TicketCheckIn, error) {
+	defer mgoLayer.trace("FindCheckIn")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	checkIn := persistence.TicketCheckIn{}
+	err := s.DB(DB).C(CHECKINS).FindId(ticketId).One(&checkIn)
+	return checkIn, err
+}
+
+func (mgoLayer *MongoDBLayer) FindCheckInsByEventId(eventId []byte) ([]persistence.TicketCheckIn, error) {
+	defer mgoLayer.trace("FindCheckInsByEventId")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	checkIns := []persistence.TicketCheckIn{}
+	err := s.DB(DB).C(CHECKINS).Find(bson.M{"eventid": string(eventId)}).Sort("checkedinat").All(&checkIns)
+	return checkIns, err
+}
+
+func (mgoLayer *MongoDBLayer) SaveRefundJob(job persistence.RefundJob) error {
+	defer mgoLayer.trace("SaveRefundJob")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	job.ID = job.EventID
+	_, err := s.DB(DB).C(REFUNDS).UpsertId(job.ID, job)
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindRefundJob(eventId []byte) (persistence.RefundJob, error) {
+	defer mgoLayer.trace("FindRefundJob")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	job := persistence.RefundJob{}
+	err := s.DB(DB).C(REFUNDS).FindId(string(eventId)).One(&job)
+	return job, err
+}
+
+func (mgoLayer *MongoDBLayer) FindUnfinishedRefundJobs() ([]persistence.RefundJob, error) {
+	defer mgoLayer.trace("FindUnfinishedRefundJobs")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	jobs := []persistence.RefundJob{}
+	err := s.DB(DB).C(REFUNDS).Find(bson.M{"status": persistence.RefundJobRunning}).All(&jobs)
+	return jobs, err
+}
+
+func (mgoLayer *MongoDBLayer) SaveNotificationPreferences(prefs persistence.NotificationPreferences) error {
+	defer mgoLayer.trace("SaveNotificationPreferences")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	_, err := s.DB(DB).C(PREFERENCES).UpsertId(prefs.UserID, prefs)
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindNotificationPreferences(userId []byte) (persistence.NotificationPreferences, error) {
+	defer mgoLayer.trace("FindNotificationPreferences")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	prefs := persistence.NotificationPreferences{}
+	err := s.DB(DB).C(PREFERENCES).FindId(string(userId)).One(&prefs)
+	return prefs, err
+}
+
+func (mgoLayer *MongoDBLayer) AddAttendee(attendee persistence.Attendee) error {
+	defer mgoLayer.trace("AddAttendee")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	attendee.ID = attendee.BookingID
+	_, err := s.DB(DB).C(ATTENDEES).UpsertId(attendee.ID, attendee)
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) RemoveAttendee(eventId []byte, bookingId []byte) error {
+	defer mgoLayer.trace("RemoveAttendee")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	err := s.DB(DB).C(ATTENDEES).RemoveId(string(bookingId))
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindAttendeesByEventId(eventId []byte) ([]persistence.Attendee, error) {
+	defer mgoLayer.trace("FindAttendeesByEventId")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	attendees := []persistence.Attendee{}
+	err := s.DB(DB).C(ATTENDEES).Find(bson.M{"eventid": string(eventId)}).All(&attendees)
+	return attendees, err
+}
+
+func (mgoLayer *MongoDBLayer) AddNotification(n persistence.Notification) error {
+	defer mgoLayer.trace("AddNotification")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	err := s.DB(DB).C(NOTIFICATIONS).Insert(n)
+	if mgo.IsDup(err) {
+		return persistence.ErrNotificationExists
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindDueNotifications(now int64) ([]persistence.Notification, error) {
+	defer mgoLayer.trace("FindDueNotifications")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	notifications := []persistence.Notification{}
+	err := s.DB(DB).C(NOTIFICATIONS).Find(bson.M{
+		"status":      persistence.NotificationPending,
+		"nextattempt": bson.M{"$lte": now},
+	}).Sort("nextattempt").All(&notifications)
+	return notifications, err
+}
+
+func (mgoLayer *MongoDBLayer) ClaimNotification(id string, now int64, until int64) error {
+	defer mgoLayer.trace("ClaimNotification")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//Only one sender can move the next attempt of a due notification into the future.
+	selector := bson.M{
+		"_id":         id,
+		"status":      persistence.NotificationPending,
+		"nextattempt": bson.M{"$lte": now},
+	}
+	err := s.DB(DB).C(NOTIFICATIONS).Update(selector, bson.M{"$set": bson.M{"nextattempt": until}})
+	if err == mgo.ErrNotFound {
+		return persistence.ErrNotificationClaimed
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) UpdateNotification(n persistence.Notification) error {
+	defer mgoLayer.trace("UpdateNotification")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	return s.DB(DB).C(NOTIFICATIONS).UpdateId(n.ID, bson.M{"$set": bson.M{
+		"status":      n.Status,
+		"attempts":    n.Attempts,
+		"nextattempt": n.NextAttempt,
+		"lasterror":   n.LastError,
+		"sentat":      n.SentAt,
+	}})
+}
+
+func (mgoLayer *MongoDBLayer) FindNotificationsByUserId(userId []byte) ([]persistence.Notification, error) {
+	defer mgoLayer.trace("FindNotificationsByUserId")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	notifications := []persistence.Notification{}
+	err := s.DB(DB).C(NOTIFICATIONS).Find(bson.M{"userid": string(userId)}).Sort("-createdat").All(&notifications)
+	return notifications, err
+}
+
+func (mgoLayer *MongoDBLayer) SaveJob(job persistence.ScheduledJob) error {
+	defer mgoLayer.trace("SaveJob")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	_, err := s.DB(DB).C(JOBS).UpsertId(job.ID, job)
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindJob(id string) (persistence.ScheduledJob, error) {
+	defer mgoLayer.trace("FindJob")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	job := persistence.ScheduledJob{}
+	err := s.DB(DB).C(JOBS).FindId(id).One(&job)
+	if err == mgo.ErrNotFound {
+		return job, persistence.ErrJobNotFound
+	}
+	return job, err
+}
+
+func (mgoLayer *MongoDBLayer) FindJobsByGroup(group string) ([]persistence.ScheduledJob, error) {
+	defer mgoLayer.trace("FindJobsByGroup")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	jobs := []persistence.ScheduledJob{}
+	err := s.DB(DB).C(JOBS).Find(bson.M{"group": group}).Sort("runat").All(&jobs)
+	return jobs, err
+}
+
+func (mgoLayer *MongoDBLayer) FindDueJobs(now int64) ([]persistence.ScheduledJob, error) {
+	defer mgoLayer.trace("FindDueJobs")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	jobs := []persistence.ScheduledJob{}
+	err := s.DB(DB).C(JOBS).Find(bson.M{
+		"status":      persistence.JobPending,
+		"runat":       bson.M{"$lte": now},
+		"leaseduntil": bson.M{"$lt": now},
+	}).Sort("runat").All(&jobs)
+	return jobs, err
+}
+
+func (mgoLayer *MongoDBLayer) LeaseJob(id string, now int64, until int64) error {
+	defer mgoLayer.trace("LeaseJob")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//The lease only moves forward while nobody else holds it, so exactly one replica gets the job.
+	selector := bson.M{
+		"_id":         id,
+		"status":      persistence.JobPending,
+		"runat":       bson.M{"$lte": now},
+		"leaseduntil": bson.M{"$lt": now},
+	}
+	err := s.DB(DB).C(JOBS).Update(selector, bson.M{"$set": bson.M{"leaseduntil": until}})
+	if err == mgo.ErrNotFound {
+		return persistence.ErrJobLeased
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FinishJob(job persistence.ScheduledJob) error {
+	defer mgoLayer.trace("FinishJob")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//A replica that ran over its lease must not overwrite the outcome of the one that took over.
+	selector := bson.M{"_id": job.ID, "leaseduntil": job.LeasedUntil}
+	err := s.DB(DB).C(JOBS).Update(selector, bson.M{"$set": bson.M{
+		"status":      job.Status,
+		"attempts":    job.Attempts,
+		"runat":       job.RunAt,
+		"lasterror":   job.LastError,
+		"leaseduntil": 0,
+	}})
+	if err == mgo.ErrNotFound {
+		return persistence.ErrJobLeased
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) SaveWebhookSubscription(sub persistence.WebhookSubscription) error {
+	defer mgoLayer.trace("SaveWebhookSubscription")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	_, err := s.DB(DB).C(WEBHOOKS).UpsertId(sub.ID, sub)
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindWebhookSubscription(id []byte) (persistence.WebhookSubscription, error) {
+	defer mgoLayer.trace("FindWebhookSubscription")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	sub := persistence.WebhookSubscription{}
+	err := s.DB(DB).C(WEBHOOKS).FindId(string(id)).One(&sub)
+	return sub, err
+}
+
+func (mgoLayer *MongoDBLayer) FindAllWebhookSubscriptions() ([]persistence.WebhookSubscription, error) {
+	defer mgoLayer.trace("FindAllWebhookSubscriptions")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	subs := []persistence.WebhookSubscription{}
+	err := s.DB(DB).C(WEBHOOKS).Find(nil).Sort("createdat").All(&subs)
+	return subs, err
+}
+
+func (mgoLayer *MongoDBLayer) RemoveWebhookSubscription(id []byte) error {
+	defer mgoLayer.trace("RemoveWebhookSubscription")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	return s.DB(DB).C(WEBHOOKS).RemoveId(string(id))
+}
+
+func (mgoLayer *MongoDBLayer) AddWebhookFailure(id []byte, disableAfter int) (persistence.WebhookSubscription, error) {
+	defer mgoLayer.trace("AddWebhookFailure")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//Only the counters are touched, so that failures of deliveries that are posted at the same time
+	//all count, and changes the partner makes to the subscription meanwhile are kept.
+	sub := persistence.WebhookSubscription{}
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"failures": 1}}, ReturnNew: true}
+	_, err := s.DB(DB).C(WEBHOOKS).FindId(string(id)).Apply(change, &sub)
+	if err != nil || sub.Disabled || sub.Failures < disableAfter {
+		return sub, err
+	}
+	sub.Disabled = true
+	return sub, s.DB(DB).C(WEBHOOKS).UpdateId(string(id), bson.M{"$set": bson.M{"disabled": true}})
+}
+
+func (mgoLayer *MongoDBLayer) ResetWebhookFailures(id []byte) error {
+	defer mgoLayer.trace("ResetWebhookFailures")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	return s.DB(DB).C(WEBHOOKS).UpdateId(string(id), bson.M{"$set": bson.M{"failures": 0}})
+}
+
+func (mgoLayer *MongoDBLayer) AddWebhookDelivery(d persistence.WebhookDelivery) error {
+	defer mgoLayer.trace("AddWebhookDelivery")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	err := s.DB(DB).C(DELIVERIES).Insert(d)
+	if mgo.IsDup(err) {
+		return persistence.ErrDeliveryExists
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindDueWebhookDeliveries(now int64) ([]persistence.WebhookDelivery, error) {
+	defer mgoLayer.trace("FindDueWebhookDeliveries")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	deliveries := []persistence.WebhookDelivery{}
+	err := s.DB(DB).C(DELIVERIES).Find(bson.M{
+		"status":      persistence.DeliveryPending,
+		"nextattempt": bson.M{"$lte": now},
+	}).Sort("nextattempt").All(&deliveries)
+	return deliveries, err
+}
+
+func (mgoLayer *MongoDBLayer) ClaimWebhookDelivery(id string, now int64, until int64) error {
+	defer mgoLayer.trace("ClaimWebhookDelivery")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//Only one dispatcher can move the next attempt of a due delivery into the future.
+	selector := bson.M{
+		"_id":         id,
+		"status":      persistence.DeliveryPending,
+		"nextattempt": bson.M{"$lte": now},
+	}
+	err := s.DB(DB).C(DELIVERIES).Update(selector, bson.M{"$set": bson.M{"nextattempt": until}})
+	if err == mgo.ErrNotFound {
+		return persistence.ErrDeliveryClaimed
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) UpdateWebhookDelivery(d persistence.WebhookDelivery) error {
+	defer mgoLayer.trace("UpdateWebhookDelivery")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	return s.DB(DB).C(DELIVERIES).UpdateId(d.ID, bson.M{"$set": bson.M{
+		"status":         d.Status,
+		"attempts":       d.Attempts,
+		"nextattempt":    d.NextAttempt,
+		"responsestatus": d.ResponseStatus,
+		"lasterror":      d.LastError,
+		"deliveredat":    d.DeliveredAt,
+	}})
+}
+
+func (mgoLayer *MongoDBLayer) FindWebhookDeliveriesBySubscriptionId(subscriptionId []byte) ([]persistence.WebhookDelivery, error) {
+	defer mgoLayer.trace("FindWebhookDeliveriesBySubscriptionId")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	deliveries := []persistence.WebhookDelivery{}
+	err := s.DB(DB).C(DELIVERIES).Find(bson.M{"subscriptionid": string(subscriptionId)}).Sort("-createdat").All(&deliveries)
+	return deliveries, err
+}
+
+func (mgoLayer *MongoDBLayer) ReserveCapacity(eventId []byte, seats int, capacity int) error {
+	defer mgoLayer.trace("ReserveCapacity")()
+	if capacity > 0 && seats > capacity {
+		return persistence.ErrCapacityExceeded
+	}
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//Like the uses of a promo code, the reserved seats only go up while they stay within the
+	//capacity. Once they would not, the upsert tries to insert a second counter with the same ID,
+	//which fails with a duplicate key error.
+	selector := bson.M{"_id": bson.ObjectId(eventId)}
+	if capacity > 0 {
+		selector["reserved"] = bson.M{"$lte": capacity - seats}
+	}
+	return upsertWithinLimit(s.DB(DB).C(CAPACITY), selector, bson.M{"$inc": bson.M{"reserved": seats}})
+}
+
+func (mgoLayer *MongoDBLayer) ReleaseCapacity(eventId []byte, seats int) error {
+	defer mgoLayer.trace("ReleaseCapacity")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	return s.DB(DB).C(CAPACITY).UpdateId(bson.ObjectId(eventId), bson.M{"$inc": bson.M{"reserved": -seats}})
+}
+
+func (mgoLayer *MongoDBLayer) ReserveSeatCount(key string, seats int, limit int) error {
+	defer mgoLayer.trace("ReserveSeatCount")()
+	if limit > 0 && seats > limit {
+		return persistence.ErrCapacityExceeded
+	}
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	//The counts live in their own collection, apart from the capacity the events service reserves,
+	//since the services may share a database.
+	selector := bson.M{"_id": key}
+	if limit > 0 {
+		selector["booked"] = bson.M{"$lte": limit - seats}
+	}
+	return upsertWithinLimit(s.DB(DB).C(SEATCOUNTS), selector, bson.M{"$inc": bson.M{"booked": seats}})
+}
+
+func (mgoLayer *MongoDBLayer) ReleaseSeatCount(key string, seats int) error {
+	defer mgoLayer.trace("ReleaseSeatCount")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	return s.DB(DB).C(SEATCOUNTS).UpdateId(key, bson.M{"$inc": bson.M{"booked": -seats}})
+}
+
+//upsertWithinLimit applies the update to the counter the selector matches while it stays within its
+//limit. Two upserts that both find no counter yet both insert one, and the second fails with a
+//duplicate key error although the limit was not reached. So a duplicate key is retried once: by
+//then the counter exists, and the retry either updates it or really is over the limit.
+func upsertWithinLimit(c *mgo.Collection, selector bson.M, update bson.M) error {
+	_, err := c.Upsert(selector, update)
+	if mgo.IsDup(err) {
+		_, err = c.Upsert(selector, update)
+	}
+	if mgo.IsDup(err) {
+		return persistence.ErrCapacityExceeded
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) AddSeatRequest(req persistence.SeatRequest) error {
+	defer mgoLayer.trace("AddSeatRequest")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	err := s.DB(DB).C(SEATREQUESTS).Insert(req)
+	if mgo.IsDup(err) {
+		return persistence.ErrSeatRequestExists
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindSeatRequest(id string) (persistence.SeatRequest, error) {
+	defer mgoLayer.trace("FindSeatRequest")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	req := persistence.SeatRequest{}
+	err := s.DB(DB).C(SEATREQUESTS).FindId(id).One(&req)
+	return req, err
+}
+
+func (mgoLayer *MongoDBLayer) UpdateSeatRequest(status string, req persistence.SeatRequest) error {
+	defer mgoLayer.trace("UpdateSeatRequest")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	err := s.DB(DB).C(SEATREQUESTS).Update(bson.M{"_id": req.ID, "status": status}, req)
+	if err == mgo.ErrNotFound {
+		return persistence.ErrSeatRequestChanged
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) AddBookingSaga(saga persistence.BookingSaga) error {
+	defer mgoLayer.trace("AddBookingSaga")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	return s.DB(DB).C(SAGAS).Insert(saga)
+}
+
+func (mgoLayer *MongoDBLayer) FindBookingSaga(id string) (persistence.BookingSaga, error) {
+	defer mgoLayer.trace("FindBookingSaga")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	saga := persistence.BookingSaga{}
+	err := s.DB(DB).C(SAGAS).FindId(id).One(&saga)
+	return saga, err
+}
+
+func (mgoLayer *MongoDBLayer) UpdateBookingSaga(status string, saga persistence.BookingSaga) error {
+	defer mgoLayer.trace("UpdateBookingSaga")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	err := s.DB(DB).C(SAGAS).Update(bson.M{"_id": saga.ID, "status": status}, saga)
+	if err == mgo.ErrNotFound {
+		return persistence.ErrSagaStatusChanged
+	}
+	return err
+}
+
+func (mgoLayer *MongoDBLayer) FindTimedOutBookingSagas(now int64) ([]persistence.BookingSaga, error) {
+	defer mgoLayer.trace("FindTimedOutBookingSagas")()
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	sagas := []persistence.BookingSaga{}
+	err := s.DB(DB).C(SAGAS).Find(bson.M{
+		"status":   bson.M{"$in": []string{persistence.SagaReserving, persistence.SagaHolding}},
+		"deadline": bson.M{"$lt": now},
+	}).Sort("deadline").All(&sagas)
+	return sagas, err
+}
+
+//objectIdDocument returns v as a BSON document whose _id is an ObjectId. The persistence models
+//keep their IDs in strings, which bson stores as strings, and documents stored that way are never
+//found by FindId(bson.ObjectId(id)). Reading the document back into the model works either way.
+func objectIdDocument(v interface{}) (bson.D, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.D{}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for i := range doc {
+		if id, ok := doc[i].Value.(string); ok && doc[i].Name == "_id" && bson.ObjectId(id).Valid() {
+			doc[i].Value = bson.ObjectId(id)
+		}
+	}
+	return doc, nil
+}
+
+func promoCodeId(eventId string, code string) string {
+	return eventId + "#" + code
+}
+
+func seatReservationId(eventId string, seatId string) string {
+	return eventId + "#" + seatId
+}
+
+func waitlistEntryId(eventId string, userId string) string {
+	return eventId + "#" + userId
+}
+
 func (mgoLayer *MongoDBLayer) getFreshSession() *mgo.Session {
 	//The session.Copy() is the method that is called whenever we are requesting a new session
 	//from the mgo package conncetion pool. It is idiomatic to call session.Copy() at the
@@ -216,3 +1280,28 @@ func (mgoLayer *MongoDBLayer) getFreshSession() *mgo.Session {
 	//via the mgo package.
 	return mgoLayer.session.Copy()
 }
+
+//Ping checks whether MongoDB can be reached.
+func (mgoLayer *MongoDBLayer) Ping() error {
+	s := mgoLayer.getFreshSession()
+	defer s.Close()
+	return s.Ping()
+}
+
+//WithContext returns a layer that shares the session pool of this one, and records its calls as
+//part of the trace the context carries.
+func (mgoLayer *MongoDBLayer) WithContext(ctx context.Context) persistence.DatabaseHandler {
+	bound := *mgoLayer
+	bound.ctx = ctx
+	return &bound
+}
+
+//trace starts a client span for a call to MongoDB, if the layer is bound to a trace. It returns the
+//function that finishes the span, so that calls are traced with a single deferred line.
+func (mgoLayer *MongoDBLayer) trace(operation string) func() {
+	_, span := tracing.StartChild(mgoLayer.ctx, "mongodb "+operation, tracing.Client)
+	span.SetAttribute("db.system", "mongodb")
+	span.SetAttribute("db.name", DB)
+	span.SetAttribute("db.operation", operation)
+	return span.Finish
+}
@@ -0,0 +1,102 @@
+package mongolayer
+
+import (
+	"os"
+	"testing"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestObjectIdDocument(t *testing.T) {
+	id := bson.NewObjectId()
+	doc, err := objectIdDocument(persistence.Event{
+		ID:       string(id),
+		Name:     "Opera",
+		Location: persistence.Location{ID: "hall-a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Documents are found by FindId(bson.ObjectId(id)), so the _id has to be stored as an ObjectId.
+	raw := bson.RawD{}
+	if err := bson.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw[0].Name != "_id" || raw[0].Value.Kind != 0x07 {
+		t.Errorf("expected the _id to be an ObjectId, got %s of kind %#x", raw[0].Name, raw[0].Value.Kind)
+	}
+	e := persistence.Event{}
+	if err := bson.Unmarshal(data, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != string(id) || e.Name != "Opera" || e.Location.ID != "hall-a" {
+		t.Errorf("expected the event to be read back as it was, got %+v", e)
+	}
+}
+
+//TestEventRoundTrip runs against the MongoDB at MONGO_TEST_URL, and is skipped without one. It
+//writes to the myevents database and removes what it added.
+func TestEventRoundTrip(t *testing.T) {
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+	session, err := mgo.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	db := &MongoDBLayer{session: session}
+
+	locationID, err := db.AddLocation(persistence.Location{Name: "Blue Note", TimeZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.DB(DB).C(LOCATIONS).RemoveId(bson.ObjectId(locationID))
+	if l, err := db.FindLocation(locationID); err != nil || l.Name != "Blue Note" {
+		t.Fatalf("expected the location to be found, got %+v and %v", l, err)
+	}
+
+	seriesID, err := db.AddEventSeries(persistence.EventSeries{Name: "Jazz nights", Rule: "FREQ=WEEKLY;COUNT=2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.DB(DB).C(SERIES).RemoveId(bson.ObjectId(seriesID))
+	series, err := db.FindEventSeries(seriesID)
+	if err != nil || series.Name != "Jazz nights" {
+		t.Fatalf("expected the series to be found, got %+v and %v", series, err)
+	}
+	series.Name = "Jazz evenings"
+	if err := db.UpdateEventSeries(series); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := db.AddEvent(persistence.Event{Name: "Jazz night", StartDate: 100, EndDate: 200, SeriesID: string(seriesID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.DB(DB).C(EVENTS).RemoveId(bson.ObjectId(id))
+	e, err := db.FindEvent(id)
+	if err != nil || e.ID != string(id) {
+		t.Fatalf("expected the event to be found by its ID, got %+v and %v", e, err)
+	}
+	e.Name = "Jazz evening"
+	e.Detached = true
+	if err := db.UpdateEvent(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CancelEvent(id, 300); err != nil {
+		t.Fatal(err)
+	}
+	e, err = db.FindEvent(id)
+	if err != nil || e.Name != "Jazz evening" || !e.Detached || e.CancelledAt != 300 || e.Sequence != 1 {
+		t.Errorf("expected the changes to be stored, got %+v and %v", e, err)
+	}
+}
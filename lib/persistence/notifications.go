@@ -0,0 +1,70 @@
+package persistence
+
+//Kinds of notifications. Users can turn every kind off on its own in their preferences.
+const (
+	NotifyWelcome          = "welcome"
+	NotifyBookingConfirmed = "booking.confirmed"
+	NotifyBookingCancelled = "booking.cancelled"
+	NotifyEventChanged     = "event.changed"
+	NotifyEventCancelled   = "event.cancelled"
+	NotifyEventReminder    = "event.reminder"
+)
+
+//Statuses of a notification. A pending notification is sent, and retried until it was sent or
+//failed too often.
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+//NotificationPreferences are the contact details of a user and the notifications the user wants
+//to get. The notifications service creates them when it learns about a new user.
+type NotificationPreferences struct {
+	UserID   string   `bson:"_id" json:"userId"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	Locale   string   `json:"locale"`   //like en or de-AT, picks the language of the templates
+	Disabled []string `json:"disabled"` //kinds of notifications the user turned off
+}
+
+//Wants reports whether the user wants to get notifications of the given kind.
+func (p NotificationPreferences) Wants(kind string) bool {
+	if p.Email == "" {
+		return false
+	}
+	for _, disabled := range p.Disabled {
+		if disabled == kind {
+			return false
+		}
+	}
+	return true
+}
+
+//A Notification is an email to a user. It is rendered when it is created and stored before it is
+//sent, so that it can be retried as it is. Its ID is derived from the message that caused it,
+//which keeps a message that is delivered twice from sending the email twice.
+type Notification struct {
+	ID          string `bson:"_id" json:"id"`
+	UserID      string `json:"userId"`
+	Kind        string `json:"kind"`
+	Email       string `json:"email"`
+	Subject     string `json:"subject"`
+	Text        string `json:"-"`
+	HTML        string `json:"-"`
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	NextAttempt int64  `json:"nextAttempt"`
+	LastError   string `json:"lastError,omitempty"`
+	CreatedAt   int64  `json:"createdAt"`
+	SentAt      int64  `json:"sentAt,omitempty"`
+}
+
+//The notifications service keeps track of who booked which event, so it knows whom to tell when
+//the event changes.
+type Attendee struct {
+	ID        string `bson:"_id"` //the ID of the booking
+	EventID   string
+	UserID    string
+	BookingID string
+}
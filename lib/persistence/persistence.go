@@ -1,5 +1,10 @@
 package persistence
 
+import (
+	"context"
+	"errors"
+)
+
 //Because we want to create a persistence layer for our event service we need to create an
 //interface with all the functionality we want our persistence layer to have. This is because
 //the implementation of our persistence layer may change over time (DynamoDB, Redis, MySQL...)
@@ -15,10 +20,223 @@ type DatabaseHandler interface {
 	FindEvent([]byte) (Event, error)
 	FindEventByName(string) (Event, error)
 	FindAllAvailableEvents() ([]Event, error)
+	CancelEvent([]byte, int64) error
+	//UpdateEvent stores the name, the times and the sequence of an event. Cancelling an event
+	//counts as a change of the event as well and bumps its sequence.
+	UpdateEvent(Event) error
+
+	//Every occurrence of a recurring event is an event of its own. The series keeps the rule the
+	//occurrences were created from.
+	AddEventSeries(EventSeries) ([]byte, error)
+	FindEventSeries([]byte) (EventSeries, error)
+	UpdateEventSeries(EventSeries) error
+	FindEventsBySeriesId([]byte) ([]Event, error)
 
-	//AddLocation(Location) ([]byte, error)
+	AddLocation(Location) ([]byte, error)
+	FindLocation([]byte) (Location, error)
+	//FindEventsAtLocation returns the events of a location that take place at some point in the
+	//given time range. Cancelled events don't take up their hall and are left out.
+	FindEventsAtLocation([]byte, int64, int64) ([]Event, error)
+	//Halls are locked while the events service checks and changes their schedule, so that two
+	//organizers can't book the same slot at once. LockHall takes the lock of a hall, see HallKey,
+	//for the given token until the second time. It fails with ErrHallLocked if another token holds
+	//the lock past the first time. UnlockHall gives the lock up, if the token still holds it.
+	LockHall(string, string, int64, int64) error
+	UnlockHall(string, string) error
 
 	AddBookingForUser([]byte, Booking) ([]byte, error)
 	FindBookingByBookingId([]byte, []byte) (Booking, error)
 	FindBookingsByUserId([]byte) ([]Booking, error)
+	FindBookingsByEventId([]byte) ([]Booking, error)
+	//UpdateBookingForUser stores the lifecycle fields of a booking, but only if the booking is
+	//still in the given status. This keeps a confirmation from racing with the hold sweeper.
+	UpdateBookingForUser([]byte, string, Booking) error
+	//ExpireBookingHolds marks every hold that expired before the given unix time as expired
+	//and returns the bookings it released.
+	ExpireBookingHolds(int64) ([]Booking, error)
+	//Refunds are identified by their ID within their booking. AddRefundToBooking fails with
+	//ErrRefundExists if the booking already has a refund with the same ID. UpdateRefundOfBooking
+	//stores the status and the time of a pending refund, and fails with ErrRefundSettled if the
+	//refund is no longer pending.
+	AddRefundToBooking([]byte, []byte, Refund) error
+	UpdateRefundOfBooking([]byte, []byte, Refund) error
+	//FindBookingsWithPendingRefunds returns the bookings that have refunds that are still pending.
+	FindBookingsWithPendingRefunds() ([]Booking, error)
+
+	//Waitlist entries are identified by the event and the user that joined the waitlist.
+	AddWaitlistEntry(WaitlistEntry) error
+	FindWaitlistByEventId([]byte) ([]WaitlistEntry, error)
+	UpdateWaitlistEntry(WaitlistEntry) error
+	RemoveWaitlistEntry([]byte, []byte) error
+
+	//ReserveSeats reserves the given seats of an event for a user. The reservation is atomic:
+	//either every seat is reserved, or none is and ErrSeatTaken is returned.
+	ReserveSeats([]byte, []byte, []string) error
+	ReleaseSeats([]byte, []string) error
+	FindReservedSeats([]byte) ([]SeatReservation, error)
+
+	//Promo codes are identified by the event and the code. RedeemPromoCode counts a use of the code
+	//by the given user, but only while neither the overall nor the per user limit is reached.
+	AddPromoCode(PromoCode) error
+	FindPromoCode([]byte, string) (PromoCode, error)
+	FindPromoCodesByEventId([]byte) ([]PromoCode, error)
+	RedeemPromoCode([]byte, string, []byte) error
+	ReleasePromoCode([]byte, string, []byte) error
+
+	//Check-ins are identified by the event and the ticket.
+	CheckInTicket(TicketCheckIn) error
+	FindCheckIn([]byte, string) (TicketCheckIn, error)
+	FindCheckInsByEventId([]byte) ([]TicketCheckIn, error)
+
+	//Refund jobs are identified by the event they refund.
+	SaveRefundJob(RefundJob) error
+	FindRefundJob([]byte) (RefundJob, error)
+	FindUnfinishedRefundJobs() ([]RefundJob, error)
+
+	//Notification preferences are identified by the user, attendees by the event and the booking.
+	SaveNotificationPreferences(NotificationPreferences) error
+	FindNotificationPreferences([]byte) (NotificationPreferences, error)
+	AddAttendee(Attendee) error
+	RemoveAttendee([]byte, []byte) error
+	FindAttendeesByEventId([]byte) ([]Attendee, error)
+
+	//AddNotification fails with ErrNotificationExists if a notification with the same ID was
+	//added before. ClaimNotification reserves a pending notification that is due at the given
+	//time for sending until the second time, so that no two senders pick up the same one.
+	AddNotification(Notification) error
+	FindDueNotifications(int64) ([]Notification, error)
+	ClaimNotification(string, int64, int64) error
+	UpdateNotification(Notification) error
+	FindNotificationsByUserId([]byte) ([]Notification, error)
+
+	//Scheduled jobs are identified by a string of the caller's choice. SaveJob schedules a job, or
+	//schedules it again if it exists. LeaseJob reserves a pending job that is due at the given time
+	//until the second time, and fails with ErrJobLeased if another replica got it first. FindJob
+	//fails with ErrJobNotFound for jobs that don't exist.
+	SaveJob(ScheduledJob) error
+	FindJob(string) (ScheduledJob, error)
+	FindJobsByGroup(string) ([]ScheduledJob, error)
+	FindDueJobs(int64) ([]ScheduledJob, error)
+	LeaseJob(string, int64, int64) error
+	//FinishJob stores the outcome of a job and ends its lease, but only while the job still has the
+	//lease it was read with, see ScheduledJob.LeasedUntil. It fails with ErrJobLeased once the job
+	//was taken over, rescheduled or cancelled meanwhile.
+	FinishJob(ScheduledJob) error
+
+	//Webhook subscriptions are identified by their ID, deliveries by theirs. ClaimWebhookDelivery
+	//reserves a pending delivery that is due at the given time for posting until the second time,
+	//like ClaimNotification does for notifications. AddWebhookDelivery fails with ErrDeliveryExists
+	//if a delivery with the same ID was added before. AddWebhookFailure counts a failed attempt of a
+	//subscription, disables it once it failed the given number of times in a row, and returns it.
+	SaveWebhookSubscription(WebhookSubscription) error
+	FindWebhookSubscription([]byte) (WebhookSubscription, error)
+	FindAllWebhookSubscriptions() ([]WebhookSubscription, error)
+	RemoveWebhookSubscription([]byte) error
+	AddWebhookFailure([]byte, int) (WebhookSubscription, error)
+	ResetWebhookFailures([]byte) error
+	AddWebhookDelivery(WebhookDelivery) error
+	FindDueWebhookDeliveries(int64) ([]WebhookDelivery, error)
+	ClaimWebhookDelivery(string, int64, int64) error
+	UpdateWebhookDelivery(WebhookDelivery) error
+	FindWebhookDeliveriesBySubscriptionId([]byte) ([]WebhookDelivery, error)
+
+	//The events service counts the seats it reserved for booking sagas. ReserveCapacity adds seats
+	//to the count of an event, but only while the count stays within the given capacity, and fails
+	//with ErrCapacityExceeded otherwise. A capacity of 0 means the event has no seat limit.
+	ReserveCapacity([]byte, int, int) error
+	ReleaseCapacity([]byte, int) error
+	//The bookings service counts the seats its held and confirmed bookings take, per event and per
+	//ticket type, under keys of its choice. ReserveSeatCount adds seats to a count like
+	//ReserveCapacity does: only while the count stays within the limit, failing with
+	//ErrCapacityExceeded otherwise. A limit of 0 means the count has no limit.
+	ReserveSeatCount(string, int, int) error
+	ReleaseSeatCount(string, int) error
+	//Seat requests and booking sagas are identified by the ID of the saga. Both are only updated
+	//while they are still in the given status, like bookings are.
+	AddSeatRequest(SeatRequest) error
+	FindSeatRequest(string) (SeatRequest, error)
+	UpdateSeatRequest(string, SeatRequest) error
+	AddBookingSaga(BookingSaga) error
+	FindBookingSaga(string) (BookingSaga, error)
+	UpdateBookingSaga(string, BookingSaga) error
+	//FindTimedOutBookingSagas returns the sagas that are still pending at the given unix time
+	//although their deadline passed.
+	FindTimedOutBookingSagas(int64) ([]BookingSaga, error)
+}
+
+//Handlers that trace their calls implement ContextHandler. The handler WithContext returns records
+//its calls as part of the trace the context carries.
+type ContextHandler interface {
+	WithContext(ctx context.Context) DatabaseHandler
+}
+
+//WithContext binds a database handler to a context, if it is a ContextHandler. Other handlers are
+//returned as they are.
+func WithContext(ctx context.Context, db DatabaseHandler) DatabaseHandler {
+	if h, ok := db.(ContextHandler); ok {
+		return h.WithContext(ctx)
+	}
+	return db
 }
+
+//Handlers that can tell whether their database is reachable implement Pinger.
+type Pinger interface {
+	Ping() error
+}
+
+//Ping checks whether the database of a handler is reachable. Handlers that are not Pingers are
+//taken to be reachable.
+func Ping(db DatabaseHandler) error {
+	if p, ok := db.(Pinger); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
+var (
+	//ErrBookingStatusChanged is returned when a booking is updated while it is no longer in the
+	//status the caller expected it to be in.
+	ErrBookingStatusChanged = errors.New("booking status changed concurrently")
+	//ErrRefundExists is returned when a refund is added to a booking twice.
+	ErrRefundExists = errors.New("refund has already been recorded for this booking")
+	//ErrRefundSettled is returned when a refund that is no longer pending is updated.
+	ErrRefundSettled = errors.New("refund is no longer pending")
+	//ErrAlreadyOnWaitlist is returned when a user joins the waitlist of an event twice.
+	ErrAlreadyOnWaitlist = errors.New("user is already on the waitlist of this event")
+	//ErrNotOnWaitlist is returned when a waitlist entry that does not exist is updated or removed.
+	ErrNotOnWaitlist = errors.New("user is not on the waitlist of this event")
+	//ErrSeatTaken is returned when a seat that is already reserved is reserved again.
+	ErrSeatTaken = errors.New("seat is already taken")
+	//ErrPromoCodeExists is returned when a promo code is created twice for the same event.
+	ErrPromoCodeExists = errors.New("promo code already exists for this event")
+	//ErrPromoCodeUsedUp is returned when a promo code reached its overall usage limit.
+	ErrPromoCodeUsedUp = errors.New("promo code has been used up")
+	//ErrPromoCodeUserLimit is returned when a user reached the usage limit of a promo code.
+	ErrPromoCodeUserLimit = errors.New("promo code has already been used as often as allowed")
+	//ErrTicketCheckedIn is returned when a ticket that has already been used is checked in again.
+	ErrTicketCheckedIn = errors.New("ticket has already been checked in")
+	//ErrNotificationExists is returned when a notification is added twice.
+	ErrNotificationExists = errors.New("notification already exists")
+	//ErrNotificationClaimed is returned when a notification that is no longer due is claimed.
+	ErrNotificationClaimed = errors.New("notification is not due or claimed by another sender")
+	//ErrJobLeased is returned when a job that is not due or leased by another replica is leased.
+	ErrJobLeased = errors.New("job is not due or leased by another replica")
+	//ErrJobNotFound is returned when a job that does not exist is looked up.
+	ErrJobNotFound = errors.New("job not found")
+	//ErrDeliveryExists is returned when a webhook delivery is added twice.
+	ErrDeliveryExists = errors.New("webhook delivery already exists")
+	//ErrDeliveryClaimed is returned when a webhook delivery that is no longer due is claimed.
+	ErrDeliveryClaimed = errors.New("webhook delivery is not due or claimed by another dispatcher")
+	//ErrCapacityExceeded is returned when more seats are reserved than an event has left.
+	ErrCapacityExceeded = errors.New("not enough seats left for this event")
+	//ErrHallLocked is returned when a hall is locked while another request holds its lock.
+	ErrHallLocked = errors.New("hall is locked by another request")
+	//ErrSeatRequestExists is returned when a seat request is added twice.
+	ErrSeatRequestExists = errors.New("seat request already exists")
+	//ErrSeatRequestChanged is returned when a seat request is updated while it is no longer in the
+	//status the caller expected it to be in.
+	ErrSeatRequestChanged = errors.New("seat request status changed concurrently")
+	//ErrSagaStatusChanged is returned when a booking saga is updated while it is no longer in the
+	//status the caller expected it to be in.
+	ErrSagaStatusChanged = errors.New("booking saga status changed concurrently")
+)
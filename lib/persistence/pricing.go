@@ -0,0 +1,35 @@
+package persistence
+
+//Events are sold through ticket types (Standard, VIP, Student...). Every ticket type has its own
+//price, its own quota and its own sales window. Prices are stored as an integer amount of the minor
+//unit of their currency (cents for EUR, yen for JPY), so totals never suffer from the rounding
+//errors of floating point numbers.
+type TicketType struct {
+	Name       string `json:"name" protobuf:"1"`
+	Price      int64  `json:"price" protobuf:"2"`                //in minor units of Currency
+	Currency   string `json:"currency" protobuf:"3"`             //ISO 4217 code, for example EUR
+	Quota      int    `json:"quota,omitempty" protobuf:"4"`      //0 means the type is only limited by the capacity of the event
+	SalesStart int64  `json:"salesStart,omitempty" protobuf:"5"` //unix time; 0 means the type is on sale right away
+	SalesEnd   int64  `json:"salesEnd,omitempty" protobuf:"6"`   //unix time; 0 means the type stays on sale
+}
+
+//OnSale reports whether tickets of the type can be bought at the given unix time.
+func (tt TicketType) OnSale(now int64) bool {
+	if tt.SalesStart > 0 && now < tt.SalesStart {
+		return false
+	}
+	if tt.SalesEnd > 0 && now >= tt.SalesEnd {
+		return false
+	}
+	return true
+}
+
+//FindTicketType looks up a ticket type of the event by its name.
+func (e Event) FindTicketType(name string) (TicketType, bool) {
+	for _, tt := range e.TicketTypes {
+		if tt.Name == name {
+			return tt, true
+		}
+	}
+	return TicketType{}, false
+}
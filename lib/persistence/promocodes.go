@@ -0,0 +1,75 @@
+package persistence
+
+import "strings"
+
+//Organizers hand out promo codes for their events. A code either takes a percentage off the total
+//of a booking or a fixed amount, can be limited in how often it is used overall and per user, is
+//only valid within its validity window, and can be restricted to some of the ticket types.
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+type PromoCode struct {
+	ID             string   `bson:"_id" json:"-"`
+	EventID        string   `json:"eventId"`
+	Code           string   `json:"code"`
+	Kind           string   `json:"kind"`
+	Percent        int      `json:"percent,omitempty"`
+	Amount         int64    `json:"amount,omitempty"`         //fixed discounts, in minor units of Currency
+	Currency       string   `json:"currency,omitempty"`       //fixed discounts only apply to prices in this currency
+	MaxUses        int      `json:"maxUses,omitempty"`        //0 means unlimited
+	MaxUsesPerUser int      `json:"maxUsesPerUser,omitempty"` //0 means unlimited
+	Uses           int      `json:"uses"`
+	ValidFrom      int64    `json:"validFrom,omitempty"`
+	ValidUntil     int64    `json:"validUntil,omitempty"`
+	TicketTypes    []string `json:"ticketTypes,omitempty"` //empty means the code applies to every ticket type
+}
+
+//NormalizePromoCode makes codes case insensitive, users shouldn't have to care how they type them.
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+//ValidAt reports whether the code can be used at the given unix time.
+func (pc PromoCode) ValidAt(now int64) bool {
+	if pc.ValidFrom > 0 && now < pc.ValidFrom {
+		return false
+	}
+	if pc.ValidUntil > 0 && now >= pc.ValidUntil {
+		return false
+	}
+	return true
+}
+
+//AppliesTo reports whether the code can be used for tickets of the given type and currency.
+func (pc PromoCode) AppliesTo(ticketType TicketType) bool {
+	if pc.Kind == DiscountFixed && pc.Currency != ticketType.Currency {
+		return false
+	}
+	if len(pc.TicketTypes) == 0 {
+		return true
+	}
+	for _, name := range pc.TicketTypes {
+		if name == ticketType.Name {
+			return true
+		}
+	}
+	return false
+}
+
+//DiscountOn computes the discount the code gives on a total. The discount never exceeds the total,
+//and percentages are rounded down to whole minor units.
+func (pc PromoCode) DiscountOn(total int64) int64 {
+	discount := int64(0)
+	switch pc.Kind {
+	case DiscountPercent:
+		discount = total * int64(pc.Percent) / 100
+	case DiscountFixed:
+		discount = pc.Amount
+	}
+	if discount > total {
+		return total
+	}
+	return discount
+}
@@ -0,0 +1,24 @@
+package persistence
+
+import "testing"
+
+func TestDiscountOn(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     PromoCode
+		total    int64
+		discount int64
+	}{
+		{"percent", PromoCode{Kind: DiscountPercent, Percent: 10}, 4000, 400},
+		{"percent rounds down", PromoCode{Kind: DiscountPercent, Percent: 15}, 999, 149},
+		{"full percent", PromoCode{Kind: DiscountPercent, Percent: 100}, 4000, 4000},
+		{"fixed", PromoCode{Kind: DiscountFixed, Amount: 500, Currency: "EUR"}, 4000, 500},
+		{"fixed above the price", PromoCode{Kind: DiscountFixed, Amount: 5000, Currency: "EUR"}, 4000, 4000},
+		{"unknown kind", PromoCode{Kind: "bogus", Percent: 10, Amount: 500}, 4000, 0},
+	}
+	for _, c := range cases {
+		if discount := c.code.DiscountOn(c.total); discount != c.discount {
+			t.Errorf("%s: expected a discount of %d on %d, got %d", c.name, c.discount, c.total, discount)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package persistence
+
+import (
+	"reflect"
+	"testing"
+)
+
+//TestProtobufNumbers pins the protobuf numbers of the models that are sent in contracts. Consumers
+//decode the fields by these numbers, so a number must never move to another field. New fields get
+//new numbers, which are added here.
+func TestProtobufNumbers(t *testing.T) {
+	cases := []struct {
+		model   interface{}
+		numbers map[string]string
+	}{
+		{Hall{}, map[string]string{"Name": "1", "Location": "2", "Capacity": "3", "SeatMap": "4"}},
+		{SeatMap{}, map[string]string{"Sections": "1"}},
+		{Section{}, map[string]string{"Name": "1", "Rows": "2"}},
+		{Row{}, map[string]string{"Name": "1", "Seats": "2"}},
+		{Seat{}, map[string]string{"Number": "1", "Category": "2", "Accessible": "3"}},
+		{TicketType{}, map[string]string{"Name": "1", "Price": "2", "Currency": "3", "Quota": "4", "SalesStart": "5", "SalesEnd": "6"}},
+	}
+	for _, c := range cases {
+		model := reflect.TypeOf(c.model)
+		numbered := map[string]string{}
+		for i := 0; i < model.NumField(); i++ {
+			if number := model.Field(i).Tag.Get("protobuf"); number != "" {
+				numbered[model.Field(i).Name] = number
+			}
+		}
+		if !reflect.DeepEqual(numbered, c.numbers) {
+			t.Errorf("%s: expected the protobuf numbers %v, got %v", model.Name(), c.numbers, numbered)
+		}
+	}
+}
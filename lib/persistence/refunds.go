@@ -0,0 +1,113 @@
+package persistence
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+//A cancellation policy decides how much of its price a booking gets back when it is cancelled. It
+//is made up of tiers: a booking cancelled at least HoursBefore hours before the event starts gets
+//Percent of its total refunded. A policy like "full refund until 7 days before, half until a day
+//before, nothing after" has the tiers {168, 100}, {24, 50} and {0, 0}.
+type CancellationPolicy struct {
+	Tiers []RefundTier `json:"tiers"`
+}
+
+type RefundTier struct {
+	HoursBefore int `json:"hoursBefore"`
+	Percent     int `json:"percent"`
+}
+
+//RefundFor computes the refund for a booking of the given total that is cancelled the given time
+//before the event starts. The tier with the longest notice that is still met applies; if no tier
+//applies nothing is refunded. Amounts are rounded down to whole minor units.
+func (p *CancellationPolicy) RefundFor(total int64, beforeStart time.Duration) int64 {
+	best := -1
+	percent := 0
+	for _, tier := range p.Tiers {
+		if beforeStart >= time.Duration(tier.HoursBefore)*time.Hour && tier.HoursBefore > best {
+			best = tier.HoursBefore
+			percent = tier.Percent
+		}
+	}
+	return total * int64(percent) / 100
+}
+
+//Every refund issued for a booking is recorded on the booking.
+const (
+	RefundCancelled      = "booking cancelled"
+	RefundEventCancelled = "event cancelled"
+	RefundLatePayment    = "payment arrived after the hold ended"
+)
+
+//Statuses of a refund. A refund is recorded as pending before the payment provider is asked to
+//make it, so that a refund that was cut short by a crash or an error is retried instead of lost.
+//Refunds recorded before refunds had a status have none, and were issued.
+const (
+	RefundPending = "pending"
+	RefundIssued  = "issued"
+	RefundFailed  = "failed" //the provider refused to make the refund
+)
+
+type Refund struct {
+	ID         string //RefundID of the booking, the payment and the reason
+	PaymentID  string
+	Amount     int64 //in minor units of Currency
+	Currency   string
+	Reason     string
+	Status     string
+	RefundedAt int64
+}
+
+//RefundID identifies the refund of a payment of a booking for a reason. A booking is refunded at
+//most once per payment and reason, and the ID is handed to the payment provider as the key of the
+//refund, so that a refund that is retried is only ever made once.
+func RefundID(bookingID string, paymentID string, reason string) string {
+	return hex.EncodeToString([]byte(bookingID)) + "/" + paymentID + "/" + reason
+}
+
+//Pending reports whether the refund has yet to be made by the payment provider.
+func (r Refund) Pending() bool {
+	return r.Status == RefundPending
+}
+
+//FindRefund looks up a refund of the booking by its ID.
+func (bk Booking) FindRefund(id string) (Refund, bool) {
+	for _, refund := range bk.Refunds {
+		if refund.ID == id {
+			return refund, true
+		}
+	}
+	return Refund{}, false
+}
+
+//Refunded sums up what was refunded of the payment of the booking so far, including refunds that
+//are still pending. Refunds of other payments, like a payment that arrived too late, don't count.
+func (bk Booking) Refunded() int64 {
+	refunded := int64(0)
+	for _, refund := range bk.Refunds {
+		if refund.PaymentID == bk.PaymentID && refund.Status != RefundFailed {
+			refunded += refund.Amount
+		}
+	}
+	return refunded
+}
+
+//When an organizer cancels an event, every booking of the event is refunded by a refund job. The
+//job records its progress, so that a job interrupted by a crash can be resumed where it stopped.
+const (
+	RefundJobRunning  = "running"
+	RefundJobFinished = "finished"
+)
+
+type RefundJob struct {
+	ID         string `bson:"_id"` //the ID of the cancelled event; an event is only refunded once
+	EventID    string
+	Status     string
+	StartedAt  int64
+	FinishedAt int64
+	Bookings   int      //bookings of the event
+	Processed  int      //bookings the current run went through so far
+	Refunded   int64    //sum of all refunds, in minor units
+	Failed     []string //bookings whose refund failed; they are retried when the job is resumed
+}
@@ -0,0 +1,77 @@
+package persistence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+//Statuses of a booking saga. A saga starts out reserving seats with the events service, moves on to
+//holding once the seats were reserved and the booking is being held, and ends in one of the other
+//statuses:
+//
+//   - completed when the booking was held,
+//   - rejected when the events service refused to reserve the seats,
+//   - compensated when the seats were reserved but the booking could not be held, so the seats were
+//     given back,
+//   - timed_out when the saga did not finish in time, for example because the events service did
+//     not answer. The seats are given back as well, should they have been reserved after all.
+const (
+	SagaReserving   = "reserving"
+	SagaHolding     = "holding"
+	SagaCompleted   = "completed"
+	SagaRejected    = "rejected"
+	SagaCompensated = "compensated"
+	SagaTimedOut    = "timed_out"
+)
+
+//A BookingSaga is a booking that is being made across the bookings and the events service. The
+//bookings service stores it before it asks the events service for seats, so that a saga that was
+//interrupted by a restart can still be finished or timed out.
+type BookingSaga struct {
+	ID         string   `bson:"_id" json:"id"`
+	UserID     string   `json:"userId"`
+	EventID    string   `json:"eventId"`
+	Seats      int      `json:"seats"`
+	SeatIDs    []string `json:"seatIds,omitempty"`
+	TicketType string   `json:"ticketType,omitempty"`
+	PromoCode  string   `json:"promoCode,omitempty"`
+	Offer      bool     `json:"offer,omitempty"` //the saga books seats offered to a user on the waitlist
+	Status     string   `json:"status"`
+	BookingID  string   `json:"bookingId,omitempty"` //hex encoded, set once the booking was held
+	Reason     string   `json:"reason,omitempty"`    //why the saga did not complete
+	CreatedAt  int64    `json:"createdAt"`
+	Deadline   int64    `json:"deadline"` //the saga times out if it is still pending by then
+}
+
+//Pending reports whether the saga has yet to complete or fail.
+func (s BookingSaga) Pending() bool {
+	return s.Status == SagaReserving || s.Status == SagaHolding
+}
+
+//NewSagaID returns a random ID for a booking saga.
+func NewSagaID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+//Statuses of a seat request.
+const (
+	SeatsReserved = "reserved"
+	SeatsRejected = "rejected"
+	SeatsReleased = "released"
+)
+
+//A SeatRequest is how the events service remembers its answer to a booking saga. Sagas are
+//identified by their ID, so a request that is delivered twice gets the same answer twice, and the
+//seats of a request are given back only once.
+type SeatRequest struct {
+	ID        string `bson:"_id"` //the ID of the saga
+	EventID   string
+	Seats     int
+	Status    string
+	Reason    string //why the request was rejected
+	CreatedAt int64
+}
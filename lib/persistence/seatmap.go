@@ -0,0 +1,85 @@
+package persistence
+
+import "fmt"
+
+//Theatre style halls come with a seat map. A seat map is made up of sections (stalls, balcony...),
+//every section has rows, and every row has numbered seats. Seats can belong to a category, which
+//lets organizers tell premium seats from standard ones, and can be flagged as accessible.
+type SeatMap struct {
+	Sections []Section `json:"sections" protobuf:"1"`
+}
+
+type Section struct {
+	Name string `json:"name" protobuf:"1"`
+	Rows []Row  `json:"rows" protobuf:"2"`
+}
+
+type Row struct {
+	Name  string `json:"name" protobuf:"1"`
+	Seats []Seat `json:"seats" protobuf:"2"`
+}
+
+type Seat struct {
+	Number     int    `json:"number" protobuf:"1"`
+	Category   string `json:"category,omitempty" protobuf:"2"`
+	Accessible bool   `json:"accessible,omitempty" protobuf:"3"`
+}
+
+//SeatInfo describes a single seat of a seat map together with its position in the hall.
+type SeatInfo struct {
+	ID         string `json:"id"`
+	Section    string `json:"section"`
+	Row        string `json:"row"`
+	Number     int    `json:"number"`
+	Category   string `json:"category,omitempty"`
+	Accessible bool   `json:"accessible,omitempty"`
+}
+
+//SeatReservation records that a seat of an event belongs to a booking of the given user.
+type SeatReservation struct {
+	EventID string
+	SeatID  string
+	UserID  string
+}
+
+//SeatID builds the ID under which a seat is booked, for example "Balcony-C-12".
+func SeatID(section string, row string, number int) string {
+	return fmt.Sprintf("%s-%s-%d", section, row, number)
+}
+
+//Seats lists every seat of the seat map, section by section and row by row.
+func (sm *SeatMap) Seats() []SeatInfo {
+	seats := []SeatInfo{}
+	if sm == nil {
+		return seats
+	}
+	for _, section := range sm.Sections {
+		for _, row := range section.Rows {
+			for _, seat := range row.Seats {
+				seats = append(seats, SeatInfo{
+					ID:         SeatID(section.Name, row.Name, seat.Number),
+					Section:    section.Name,
+					Row:        row.Name,
+					Number:     seat.Number,
+					Category:   seat.Category,
+					Accessible: seat.Accessible,
+				})
+			}
+		}
+	}
+	return seats
+}
+
+//Capacity returns the number of seats on the seat map.
+func (sm *SeatMap) Capacity() int {
+	return len(sm.Seats())
+}
+
+//SeatIDs returns the set of the IDs of every seat on the seat map.
+func (sm *SeatMap) SeatIDs() map[string]bool {
+	ids := map[string]bool{}
+	for _, seat := range sm.Seats() {
+		ids[seat.ID] = true
+	}
+	return ids
+}
@@ -0,0 +1,14 @@
+package persistence
+
+//A recurring event is created from a recurrence rule (RFC 5545 RRULE), which is expanded into one
+//bookable event per occurrence. The series remembers the rule, and the times of its first
+//occurrence, which edits of the whole series are applied relative to.
+type EventSeries struct {
+	ID        string  `bson:"_id" json:"id"`
+	Name      string  `json:"name"`
+	Rule      string  `json:"rule"`               //e.g. FREQ=WEEKLY;BYDAY=TU;COUNT=10
+	ExDates   []int64 `json:"exdates,omitempty"`  //start times of the occurrences left out of the series
+	TimeZone  string  `json:"timeZone,omitempty"` //the rule is expanded in the time zone of the venue
+	StartDate int64   `json:"startDate"`
+	EndDate   int64   `json:"endDate"`
+}
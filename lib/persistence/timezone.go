@@ -0,0 +1,69 @@
+package persistence
+
+import (
+	"fmt"
+	"time"
+)
+
+//Zone returns the time zone of the location. Locations without a time zone are in UTC.
+func (l Location) Zone() (*time.Location, error) {
+	if l.TimeZone == "" {
+		return time.UTC, nil
+	}
+	zone, err := time.LoadLocation(l.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %s", l.TimeZone)
+	}
+	return zone, nil
+}
+
+//HasOpeningHours reports whether the location only opens part of the day.
+func (l Location) HasOpeningHours() bool {
+	return l.OpenTime != 0 || l.CloseTime != 0
+}
+
+//OpenDuring reports whether the location is open from start to end. Opening hours are wall clock
+//hours in the time zone of the location, so a venue that opens at 18:00 opens at 18:00 local time
+//on the days the clocks change as well, even though that day is an hour shorter or longer. A
+//CloseTime that is not after OpenTime means the location closes after midnight.
+func (l Location) OpenDuring(start time.Time, end time.Time) (bool, error) {
+	if !l.HasOpeningHours() {
+		return true, nil
+	}
+	zone, err := l.Zone()
+	if err != nil {
+		return false, err
+	}
+	local := start.In(zone)
+	//The event either falls into the opening hours that began on the day it starts, or, for
+	//locations that close after midnight, into those that began the day before.
+	for _, days := range []int{0, -1} {
+		opens := time.Date(local.Year(), local.Month(), local.Day()+days, l.OpenTime, 0, 0, 0, zone)
+		closes := time.Date(local.Year(), local.Month(), local.Day()+days, l.CloseTime, 0, 0, 0, zone)
+		if l.CloseTime <= l.OpenTime {
+			closes = time.Date(local.Year(), local.Month(), local.Day()+days+1, l.CloseTime, 0, 0, 0, zone)
+		}
+		if !start.Before(opens) && !end.After(closes) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//Start returns the start of the event in the time zone of its location.
+func (e Event) Start() time.Time {
+	return e.local(e.StartDate)
+}
+
+//End returns the end of the event in the time zone of its location.
+func (e Event) End() time.Time {
+	return e.local(e.EndDate)
+}
+
+func (e Event) local(unix int64) time.Time {
+	zone, err := e.Location.Zone()
+	if err != nil {
+		zone = time.UTC
+	}
+	return time.Unix(unix, 0).In(zone)
+}
@@ -0,0 +1,52 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpenDuringAcrossDaylightSaving(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	location := Location{TimeZone: "Europe/Berlin", OpenTime: 18, CloseTime: 2}
+
+	cases := []struct {
+		start, end time.Time
+		open       bool
+	}{
+		//The clocks go forward in the night to the 29th of March 2026, so the night is an hour
+		//shorter, but the venue still closes at 2:00 local time.
+		{time.Date(2026, 3, 28, 20, 0, 0, 0, berlin), time.Date(2026, 3, 29, 1, 30, 0, 0, berlin), true},
+		{time.Date(2026, 3, 28, 20, 0, 0, 0, berlin), time.Date(2026, 3, 29, 3, 30, 0, 0, berlin), false},
+		//They go back in the night to the 25th of October, which makes the night an hour longer.
+		{time.Date(2026, 10, 24, 18, 0, 0, 0, berlin), time.Date(2026, 10, 25, 2, 0, 0, 0, berlin), true},
+		//Events that start after midnight belong to the opening hours of the day before.
+		{time.Date(2026, 10, 25, 0, 30, 0, 0, berlin), time.Date(2026, 10, 25, 1, 30, 0, 0, berlin), true},
+		{time.Date(2026, 10, 24, 17, 0, 0, 0, berlin), time.Date(2026, 10, 24, 19, 0, 0, 0, berlin), false},
+	}
+	for _, c := range cases {
+		open, err := location.OpenDuring(c.start, c.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if open != c.open {
+			t.Errorf("%s - %s: expected open to be %t", c.start, c.end, c.open)
+		}
+	}
+}
+
+func TestOpenDuringDaytime(t *testing.T) {
+	location := Location{OpenTime: 9, CloseTime: 17}
+	start := time.Date(2026, 5, 4, 9, 0, 0, 0, time.UTC)
+	if open, _ := location.OpenDuring(start, start.Add(8*time.Hour)); !open {
+		t.Error("expected an event during the opening hours to fit")
+	}
+	if open, _ := location.OpenDuring(start, start.Add(9*time.Hour)); open {
+		t.Error("expected an event past closing time not to fit")
+	}
+	if _, err := (Location{TimeZone: "Mars/Olympus_Mons", OpenTime: 9}).OpenDuring(start, start); err == nil {
+		t.Error("expected an unknown time zone to fail")
+	}
+}
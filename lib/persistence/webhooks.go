@@ -0,0 +1,53 @@
+package persistence
+
+//Statuses of a webhook delivery. A pending delivery is posted, and retried until the partner took
+//it or it failed too often.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+//A WebhookSubscription tells the webhooks service to post events to a partner. Every request is
+//signed with the secret of the subscription, so the partner can tell it came from us.
+type WebhookSubscription struct {
+	ID        string   `bson:"_id" json:"id"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events"`   //names of the events the partner wants, every event if empty
+	Disabled  bool     `json:"disabled"` //set when the endpoint kept failing, until the partner enables it again
+	Failures  int      `json:"failures"` //failed attempts in a row
+	CreatedAt int64    `json:"createdAt"`
+}
+
+//Wants reports whether the partner wants to get events with the given name.
+func (s WebhookSubscription) Wants(eventName string) bool {
+	if s.Disabled {
+		return false
+	}
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, name := range s.Events {
+		if name == eventName {
+			return true
+		}
+	}
+	return false
+}
+
+//A WebhookDelivery is an event that is posted to the endpoint of a subscription. The deliveries of
+//a subscription are its delivery log.
+type WebhookDelivery struct {
+	ID             string `bson:"_id" json:"id"`
+	SubscriptionID string `json:"subscriptionId"`
+	EventName      string `json:"event"`
+	Payload        string `json:"payload"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	NextAttempt    int64  `json:"nextAttempt"`
+	ResponseStatus int    `json:"responseStatus,omitempty"` //HTTP status of the last attempt
+	LastError      string `json:"lastError,omitempty"`
+	CreatedAt      int64  `json:"createdAt"`
+	DeliveredAt    int64  `json:"deliveredAt,omitempty"`
+}
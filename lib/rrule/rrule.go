@@ -0,0 +1,353 @@
+//Package rrule expands the recurrence rules of RFC 5545 (iCalendar) into the start times of the
+//occurrences they describe. It supports the parts organizers actually use for their events: FREQ
+//(DAILY, WEEKLY, MONTHLY or YEARLY), INTERVAL, BYDAY, COUNT, UNTIL and WKST. Together with a list
+//of excluded dates (EXDATE) that covers "every other Tuesday, ten times, but not on the 24th".
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency int
+
+const (
+	Daily Frequency = iota
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencies = map[string]Frequency{
+	"DAILY":   Daily,
+	"WEEKLY":  Weekly,
+	"MONTHLY": Monthly,
+	"YEARLY":  Yearly,
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+//maxPeriods bounds the number of days, weeks, months or years Expand looks at, so that a rule
+//that hardly ever matches can't keep it busy forever.
+const maxPeriods = 100000
+
+var ErrTooManyOccurrences = errors.New("recurrence rule has too many occurrences")
+
+//WeekdayNum is an entry of BYDAY. N picks the Nth occurrence of the weekday within the month: 1 is
+//the first, -1 the last, and 0 means every occurrence.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+type Rule struct {
+	Freq      Frequency
+	Interval  int //1 unless the rule skips periods
+	ByDay     []WeekdayNum
+	Count     int       //0 unless the rule ends after a number of occurrences
+	Until     time.Time //zero unless the rule ends at a point in time
+	WeekStart time.Weekday
+
+	//untilFloating is set when UNTIL was given without a time zone. It is then read in the time
+	//zone of the first occurrence, not in UTC.
+	untilFloating bool
+}
+
+//Parse reads a rule like FREQ=WEEKLY;INTERVAL=2;BYDAY=TU;COUNT=10. The RRULE: prefix of the
+//iCalendar property is optional.
+func Parse(s string) (Rule, error) {
+	r := Rule{Interval: 1, WeekStart: time.Monday}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return Rule{}, errors.New("recurrence rule is empty")
+	}
+
+	hasFreq := false
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return Rule{}, fmt.Errorf("malformed rule part %q", part)
+		}
+		name, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		var err error
+		switch name {
+		case "FREQ":
+			freq, ok := frequencies[value]
+			if !ok {
+				return Rule{}, fmt.Errorf("unsupported frequency %s", value)
+			}
+			r.Freq = freq
+			hasFreq = true
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+			if err != nil || r.Interval < 1 {
+				return Rule{}, fmt.Errorf("interval %s is not a positive number", value)
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+			if err != nil || r.Count < 1 {
+				return Rule{}, fmt.Errorf("count %s is not a positive number", value)
+			}
+		case "UNTIL":
+			r.Until, r.untilFloating, err = parseUntil(value)
+			if err != nil {
+				return Rule{}, err
+			}
+		case "BYDAY":
+			r.ByDay, err = parseByDay(value)
+			if err != nil {
+				return Rule{}, err
+			}
+		case "WKST":
+			day, ok := weekdays[value]
+			if !ok {
+				return Rule{}, fmt.Errorf("unknown weekday %s", value)
+			}
+			r.WeekStart = day
+		default:
+			return Rule{}, fmt.Errorf("unsupported rule part %s", name)
+		}
+	}
+
+	if !hasFreq {
+		return Rule{}, errors.New("recurrence rule has no FREQ")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return Rule{}, errors.New("recurrence rule can't have both COUNT and UNTIL")
+	}
+	for _, wd := range r.ByDay {
+		if wd.N != 0 && r.Freq != Monthly {
+			return Rule{}, errors.New("numbered weekdays in BYDAY are only supported for monthly rules")
+		}
+	}
+	if len(r.ByDay) > 0 && r.Freq == Yearly {
+		return Rule{}, errors.New("BYDAY is not supported for yearly rules")
+	}
+	return r, nil
+}
+
+func parseUntil(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("20060102T150405", value); err == nil {
+		return t, true, nil
+	}
+	//A date on its own includes the whole day.
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t.Add(24*time.Hour - time.Second), true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("malformed UNTIL %s", value)
+}
+
+func parseByDay(value string) ([]WeekdayNum, error) {
+	days := []WeekdayNum{}
+	for _, entry := range strings.Split(value, ",") {
+		if len(entry) < 2 {
+			return nil, fmt.Errorf("malformed weekday %q", entry)
+		}
+		day, ok := weekdays[entry[len(entry)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %s", entry)
+		}
+		wd := WeekdayNum{Day: day}
+		if prefix := entry[:len(entry)-2]; prefix != "" {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return nil, fmt.Errorf("malformed weekday %s", entry)
+			}
+			wd.N = n
+		}
+		days = append(days, wd)
+	}
+	return days, nil
+}
+
+//String formats the rule the way Parse reads it.
+func (r Rule) String() string {
+	names := map[Frequency]string{Daily: "DAILY", Weekly: "WEEKLY", Monthly: "MONTHLY", Yearly: "YEARLY"}
+	parts := []string{"FREQ=" + names[r.Freq]}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := []string{}
+		for _, wd := range r.ByDay {
+			day := strings.ToUpper(wd.Day.String()[:2])
+			if wd.N != 0 {
+				day = strconv.Itoa(wd.N) + day
+			}
+			days = append(days, day)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+strings.ToUpper(r.WeekStart.String()[:2]))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		if r.untilFloating {
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102T150405"))
+		} else {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+//Expand returns the start times of the occurrences of the rule, beginning with start itself, which
+//always counts as the first occurrence. Occurrences keep the wall clock time of start in its time
+//zone, so a weekly event at 19:00 stays at 19:00 across daylight saving changes. Occurrences that
+//start at one of the exdates are left out, but still count towards COUNT. Rules with more than max
+//occurrences, which includes every rule without COUNT or UNTIL, fail with ErrTooManyOccurrences.
+func (r Rule) Expand(start time.Time, exdates []time.Time, max int) ([]time.Time, error) {
+	until := r.Until
+	if r.untilFloating {
+		until = time.Date(until.Year(), until.Month(), until.Day(), until.Hour(), until.Minute(), until.Second(), 0, start.Location())
+	}
+	excluded := map[int64]bool{}
+	for _, exdate := range exdates {
+		excluded[exdate.Unix()] = true
+	}
+
+	occurrences := []time.Time{}
+	count := 0
+	//add records the next occurrence and reports whether the rule has ended.
+	add := func(t time.Time) (bool, error) {
+		if !until.IsZero() && t.After(until) {
+			return true, nil
+		}
+		count++
+		if !excluded[t.Unix()] {
+			if len(occurrences) == max {
+				return true, ErrTooManyOccurrences
+			}
+			occurrences = append(occurrences, t)
+		}
+		return r.Count > 0 && count == r.Count, nil
+	}
+
+	done, err := add(start)
+	for period := 0; !done && err == nil && period < maxPeriods; period++ {
+		for _, t := range r.candidates(start, period) {
+			if !t.After(start) {
+				continue
+			}
+			if done, err = add(t); done || err != nil {
+				break
+			}
+		}
+	}
+	return occurrences, err
+}
+
+//candidates returns the times the rule matches in the given period after start, in order.
+func (r Rule) candidates(start time.Time, period int) []time.Time {
+	y, m, d := start.Date()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+	n := period * r.Interval
+
+	switch r.Freq {
+	case Daily:
+		t := at(y, m, d+n)
+		if len(r.ByDay) > 0 && !r.onDay(t.Weekday()) {
+			return nil
+		}
+		return []time.Time{t}
+	case Weekly:
+		if len(r.ByDay) == 0 {
+			return []time.Time{at(y, m, d+7*n)}
+		}
+		//The week of start begins on WKST; the listed weekdays are taken from that week.
+		first := d - (int(start.Weekday())-int(r.WeekStart)+7)%7 + 7*n
+		times := []time.Time{}
+		for offset := 0; offset < 7; offset++ {
+			t := at(y, m, first+offset)
+			if r.onDay(t.Weekday()) {
+				times = append(times, t)
+			}
+		}
+		return times
+	case Monthly:
+		month := time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+		if len(r.ByDay) == 0 {
+			//Months without the day of start, like the 31st in April, are skipped.
+			if d > daysIn(month.Year(), month.Month()) {
+				return nil
+			}
+			return []time.Time{at(month.Year(), month.Month(), d)}
+		}
+		return r.monthlyByDay(month.Year(), month.Month(), at)
+	case Yearly:
+		year := y + n
+		if d > daysIn(year, m) {
+			return nil
+		}
+		return []time.Time{at(year, m, d)}
+	}
+	return nil
+}
+
+func (r Rule) monthlyByDay(year int, month time.Month, at func(int, time.Month, int) time.Time) []time.Time {
+	days := map[int]bool{}
+	last := daysIn(year, month)
+	for _, wd := range r.ByDay {
+		//matching holds the days of the month that fall on the weekday, in order.
+		matching := []int{}
+		for day := 1; day <= last; day++ {
+			if time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Weekday() == wd.Day {
+				matching = append(matching, day)
+			}
+		}
+		switch {
+		case wd.N == 0:
+			for _, day := range matching {
+				days[day] = true
+			}
+		case wd.N > 0 && wd.N <= len(matching):
+			days[matching[wd.N-1]] = true
+		case wd.N < 0 && -wd.N <= len(matching):
+			days[matching[len(matching)+wd.N]] = true
+		}
+	}
+
+	sorted := []int{}
+	for day := range days {
+		sorted = append(sorted, day)
+	}
+	sort.Ints(sorted)
+	times := []time.Time{}
+	for _, day := range sorted {
+		times = append(times, at(year, month, day))
+	}
+	return times
+}
+
+func (r Rule) onDay(day time.Weekday) bool {
+	for _, wd := range r.ByDay {
+		if wd.Day == day {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
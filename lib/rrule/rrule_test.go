@@ -0,0 +1,109 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func expand(t *testing.T, rule string, start time.Time, exdates ...time.Time) []time.Time {
+	r, err := Parse(rule)
+	if err != nil {
+		t.Fatalf("%s: %s", rule, err)
+	}
+	occurrences, err := r.Expand(start, exdates, 500)
+	if err != nil {
+		t.Fatalf("%s: %s", rule, err)
+	}
+	return occurrences
+}
+
+func checkDates(t *testing.T, rule string, occurrences []time.Time, expected ...string) {
+	if len(occurrences) != len(expected) {
+		t.Fatalf("%s: expected %d occurrences, got %v", rule, len(expected), occurrences)
+	}
+	for i, occurrence := range occurrences {
+		if occurrence.Format("2006-01-02 15:04") != expected[i] {
+			t.Errorf("%s: occurrence %d is %s, expected %s", rule, i, occurrence.Format("2006-01-02 15:04"), expected[i])
+		}
+	}
+}
+
+func TestWeekly(t *testing.T) {
+	//Monday the 2nd of March 2026, 19:00
+	start := time.Date(2026, 3, 2, 19, 0, 0, 0, time.UTC)
+
+	rule := "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=5"
+	checkDates(t, rule, expand(t, rule, start),
+		"2026-03-02 19:00", "2026-03-05 19:00", "2026-03-09 19:00", "2026-03-12 19:00", "2026-03-16 19:00")
+
+	rule = "RRULE:FREQ=WEEKLY;INTERVAL=2;UNTIL=20260401T000000Z"
+	checkDates(t, rule, expand(t, rule, start),
+		"2026-03-02 19:00", "2026-03-16 19:00", "2026-03-30 19:00")
+}
+
+func TestExcludedDatesCount(t *testing.T) {
+	start := time.Date(2026, 3, 2, 19, 0, 0, 0, time.UTC)
+	rule := "FREQ=DAILY;COUNT=4"
+	checkDates(t, rule, expand(t, rule, start, start.AddDate(0, 0, 1)),
+		"2026-03-02 19:00", "2026-03-04 19:00", "2026-03-05 19:00")
+}
+
+func TestMonthly(t *testing.T) {
+	start := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	rule := "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3"
+	checkDates(t, rule, expand(t, rule, start),
+		"2026-01-30 20:00", "2026-02-27 20:00", "2026-03-27 20:00")
+
+	//Months without a 31st are skipped.
+	start = time.Date(2026, 1, 31, 20, 0, 0, 0, time.UTC)
+	rule = "FREQ=MONTHLY;COUNT=3"
+	checkDates(t, rule, expand(t, rule, start),
+		"2026-01-31 20:00", "2026-03-31 20:00", "2026-05-31 20:00")
+}
+
+func TestWallClockAcrossDaylightSaving(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	start := time.Date(2026, 3, 22, 19, 0, 0, 0, berlin)
+	rule := "FREQ=WEEKLY;COUNT=2"
+	occurrences := expand(t, rule, start)
+	checkDates(t, rule, occurrences, "2026-03-22 19:00", "2026-03-29 19:00")
+	if occurrences[1].Sub(occurrences[0]) != 7*24*time.Hour-time.Hour {
+		t.Errorf("expected the clocks to go forward between the occurrences")
+	}
+}
+
+func TestUnboundedRule(t *testing.T) {
+	r, _ := Parse("FREQ=DAILY")
+	if _, err := r.Expand(time.Now(), nil, 100); err != ErrTooManyOccurrences {
+		t.Errorf("expected ErrTooManyOccurrences, got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	invalid := []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=WEEKLY;COUNT=0",
+		"FREQ=WEEKLY;COUNT=2;UNTIL=20260101",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTH=2",
+	}
+	for _, rule := range invalid {
+		if _, err := Parse(rule); err == nil {
+			t.Errorf("%q: expected an error", rule)
+		}
+	}
+
+	r, err := Parse("freq=monthly;interval=2;byday=1mo,-1fr;until=20261231")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.String() != "FREQ=MONTHLY;INTERVAL=2;BYDAY=1MO,-1FR;UNTIL=20261231T235959" {
+		t.Errorf("unexpected canonical form %s", r.String())
+	}
+}
@@ -0,0 +1,204 @@
+//Package scheduler runs jobs at a given time. Jobs are stored in the database, so they survive
+//restarts, and every replica of a service can run a scheduler against the same database: a replica
+//leases a job before it runs it, and only one replica gets the lease.
+//
+//A job is run at least once. A replica that dies while running a job leaves the lease behind, and
+//the job runs again once the lease ran out, so handlers should be safe to run twice.
+package scheduler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//Store is the part of the persistence layer the scheduler needs.
+type Store interface {
+	SaveJob(persistence.ScheduledJob) error
+	FindJob(string) (persistence.ScheduledJob, error)
+	FindJobsByGroup(string) ([]persistence.ScheduledJob, error)
+	FindDueJobs(int64) ([]persistence.ScheduledJob, error)
+	LeaseJob(string, int64, int64) error
+	FinishJob(persistence.ScheduledJob) error
+}
+
+//A Handler runs a job. A handler that returns an error gets the job again after a delay.
+type Handler func(job persistence.ScheduledJob) error
+
+type Scheduler struct {
+	Store         Store
+	LeaseDuration time.Duration //how long a replica has to run a job before another one may take over
+	RetryDelay    time.Duration //delay after the first failed run, doubles with every further one
+	MaxAttempts   int           //a job that failed this often is given up
+	handlers      map[string]Handler
+}
+
+//New returns a scheduler with a lease of a minute and retries that start after ten seconds.
+func New(store Store) *Scheduler {
+	return &Scheduler{
+		Store:         store,
+		LeaseDuration: time.Minute,
+		RetryDelay:    10 * time.Second,
+		MaxAttempts:   10,
+		handlers:      map[string]Handler{},
+	}
+}
+
+//Handle registers the handler for jobs of the given kind.
+func (s *Scheduler) Handle(kind string, handler Handler) {
+	s.handlers[kind] = handler
+}
+
+//Schedule stores a job that runs the handler of its kind at the given time, with the payload as
+//its input. A job with the same ID is replaced, which makes scheduling a job twice harmless.
+func (s *Scheduler) Schedule(id string, kind string, group string, runAt time.Time, payload interface{}) error {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return s.Store.SaveJob(persistence.ScheduledJob{
+		ID:      id,
+		Kind:    kind,
+		Group:   group,
+		Payload: string(encoded),
+		RunAt:   runAt.Unix(),
+		Status:  persistence.JobPending,
+	})
+}
+
+//Reschedule moves a pending job to a new time.
+func (s *Scheduler) Reschedule(job persistence.ScheduledJob, runAt time.Time) error {
+	job.RunAt = runAt.Unix()
+	job.Status = persistence.JobPending
+	job.Attempts = 0
+	job.LastError = ""
+	job.LeasedUntil = 0
+	return s.Store.SaveJob(job)
+}
+
+//Cancel keeps a pending job from running. Jobs that don't exist or already ran are left alone. A job
+//that a replica leases or finishes while it is cancelled is looked at again.
+func (s *Scheduler) Cancel(id string) error {
+	for {
+		job, err := s.Store.FindJob(id)
+		if err == persistence.ErrJobNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if job.Status != persistence.JobPending {
+			return nil
+		}
+		job.Status = persistence.JobCancelled
+		if err := s.Store.FinishJob(job); err != persistence.ErrJobLeased {
+			return err
+		}
+	}
+}
+
+//Pending returns the jobs of a group that have yet to run.
+func (s *Scheduler) Pending(group string) ([]persistence.ScheduledJob, error) {
+	jobs, err := s.Store.FindJobsByGroup(group)
+	if err != nil {
+		return nil, err
+	}
+	pending := []persistence.ScheduledJob{}
+	for _, job := range jobs {
+		if job.Status == persistence.JobPending {
+			pending = append(pending, job)
+		}
+	}
+	return pending, nil
+}
+
+//RunDue runs every job that is due at the given time and that no other replica leased, and
+//returns how many jobs it ran.
+func (s *Scheduler) RunDue(now time.Time) (int, error) {
+	due, err := s.Store.FindDueJobs(now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	ran := 0
+	for _, job := range due {
+		until := now.Add(s.LeaseDuration).Unix()
+		err := s.Store.LeaseJob(job.ID, now.Unix(), until)
+		if err == persistence.ErrJobLeased {
+			continue
+		}
+		if err != nil {
+			return ran, err
+		}
+		job.LeasedUntil = until
+		s.run(job, now)
+		ran++
+	}
+	return ran, nil
+}
+
+//Run runs RunDue every interval. It never returns, so it should be started in its own goroutine.
+func (s *Scheduler) Run(interval time.Duration) {
+	slog.Info("running scheduled jobs", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if _, err := s.RunDue(now); err != nil {
+			slog.Error("error while running scheduled jobs", "error", err)
+		}
+	}
+}
+
+//run runs a leased job and stores the outcome, unless the job was taken over by another replica or
+//changed while it ran.
+func (s *Scheduler) run(job persistence.ScheduledJob, now time.Time) {
+	job.Attempts++
+	handler, ok := s.handlers[job.Kind]
+	var err error
+	if ok {
+		err = handler(job)
+	} else {
+		err = errUnknownKind(job.Kind)
+	}
+	switch {
+	case err == nil:
+		job.Status = persistence.JobDone
+		job.LastError = ""
+	case job.Attempts >= s.MaxAttempts:
+		job.Status = persistence.JobFailed
+		job.LastError = err.Error()
+		slog.Error("giving up on job", "job", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", err)
+	default:
+		job.LastError = err.Error()
+		job.RunAt = now.Add(s.retryDelay(job.Attempts)).Unix()
+		slog.Warn("job failed, retrying", "job", job.ID, "kind", job.Kind, "retry_at", time.Unix(job.RunAt, 0), "error", err)
+	}
+	err = s.Store.FinishJob(job)
+	if err == persistence.ErrJobLeased {
+		slog.Warn("job was taken over or changed while it ran, dropping its outcome", "job", job.ID, "kind", job.Kind)
+		return
+	}
+	if err != nil {
+		slog.Error("could not store the outcome of job", "job", job.ID, "error", err)
+	}
+}
+
+func (s *Scheduler) retryDelay(attempts int) time.Duration {
+	delay := s.RetryDelay
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+//Decode decodes the payload of a job into v.
+func Decode(job persistence.ScheduledJob, v interface{}) error {
+	return json.Unmarshal([]byte(job.Payload), v)
+}
+
+type errUnknownKind string
+
+func (e errUnknownKind) Error() string {
+	return "no handler for jobs of kind " + string(e)
+}
@@ -0,0 +1,211 @@
+package scheduler
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//memoryStore keeps jobs in memory, with the same lease semantics as the database layers.
+type memoryStore struct {
+	sync.Mutex
+	jobs map[string]persistence.ScheduledJob
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{jobs: map[string]persistence.ScheduledJob{}}
+}
+
+func (m *memoryStore) SaveJob(job persistence.ScheduledJob) error {
+	m.Lock()
+	defer m.Unlock()
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func (m *memoryStore) FindJob(id string) (persistence.ScheduledJob, error) {
+	m.Lock()
+	defer m.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return job, persistence.ErrJobNotFound
+	}
+	return job, nil
+}
+
+func (m *memoryStore) FindJobsByGroup(group string) ([]persistence.ScheduledJob, error) {
+	m.Lock()
+	defer m.Unlock()
+	jobs := []persistence.ScheduledJob{}
+	for _, job := range m.jobs {
+		if job.Group == group {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *memoryStore) FindDueJobs(now int64) ([]persistence.ScheduledJob, error) {
+	m.Lock()
+	defer m.Unlock()
+	jobs := []persistence.ScheduledJob{}
+	for _, job := range m.jobs {
+		if job.Status == persistence.JobPending && job.RunAt <= now && job.LeasedUntil < now {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *memoryStore) LeaseJob(id string, now int64, until int64) error {
+	m.Lock()
+	defer m.Unlock()
+	job := m.jobs[id]
+	if job.Status != persistence.JobPending || job.RunAt > now || job.LeasedUntil >= now {
+		return persistence.ErrJobLeased
+	}
+	job.LeasedUntil = until
+	m.jobs[id] = job
+	return nil
+}
+
+func (m *memoryStore) FinishJob(job persistence.ScheduledJob) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.jobs[job.ID].LeasedUntil != job.LeasedUntil {
+		return persistence.ErrJobLeased
+	}
+	job.LeasedUntil = 0
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func TestJobRunsOnceAcrossReplicas(t *testing.T) {
+	store := newMemoryStore()
+	start := time.Date(2026, 5, 4, 17, 0, 0, 0, time.UTC)
+	runs := 0
+	replicas := []*Scheduler{New(store), New(store)}
+	for _, s := range replicas {
+		s.Handle("reminder", func(job persistence.ScheduledJob) error {
+			runs++
+			//The other replica looks for due jobs while this one is still running the job.
+			if ran, _ := replicas[1].RunDue(start); ran != 0 {
+				t.Errorf("expected the leased job not to run on the other replica")
+			}
+			return nil
+		})
+	}
+	if err := replicas[0].Schedule("reminder/1", "reminder", "event/1", start, map[string]string{"user": "jane"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ran, _ := replicas[0].RunDue(start.Add(-time.Second)); ran != 0 {
+		t.Errorf("expected no job to run before it is due, ran %d", ran)
+	}
+	if ran, _ := replicas[0].RunDue(start); ran != 1 {
+		t.Errorf("expected the due job to run, ran %d", ran)
+	}
+	replicas[1].RunDue(start.Add(time.Hour))
+	if runs != 1 {
+		t.Errorf("expected the job to run once, ran %d times", runs)
+	}
+	if job, _ := store.FindJob("reminder/1"); job.Status != persistence.JobDone {
+		t.Errorf("expected the job to be done, got %s", job.Status)
+	}
+}
+
+func TestFailedJobIsRetried(t *testing.T) {
+	store := newMemoryStore()
+	s := New(store)
+	s.MaxAttempts = 2
+	start := time.Date(2026, 5, 4, 17, 0, 0, 0, time.UTC)
+	s.Handle("reminder", func(job persistence.ScheduledJob) error {
+		var payload struct{ User string }
+		if err := Decode(job, &payload); err != nil || payload.User != "jane" {
+			t.Errorf("expected the payload to be decoded, got %+v (%v)", payload, err)
+		}
+		return errors.New("broker down")
+	})
+	s.Schedule("reminder/1", "reminder", "event/1", start, map[string]string{"user": "jane"})
+
+	s.RunDue(start)
+	job, _ := store.FindJob("reminder/1")
+	if job.Status != persistence.JobPending || job.RunAt != start.Add(s.RetryDelay).Unix() {
+		t.Errorf("expected the job to be retried after the retry delay, got %+v", job)
+	}
+	s.RunDue(start.Add(s.RetryDelay))
+	if job, _ := store.FindJob("reminder/1"); job.Status != persistence.JobFailed || job.LastError != "broker down" {
+		t.Errorf("expected the job to be given up, got %+v", job)
+	}
+}
+
+func TestCancelledJobDoesNotRun(t *testing.T) {
+	store := newMemoryStore()
+	s := New(store)
+	start := time.Date(2026, 5, 4, 17, 0, 0, 0, time.UTC)
+	s.Handle("reminder", func(job persistence.ScheduledJob) error {
+		t.Error("expected the cancelled job not to run")
+		return nil
+	})
+	s.Schedule("reminder/1", "reminder", "event/1", start, nil)
+	s.Schedule("reminder/2", "reminder", "event/1", start.Add(time.Hour), nil)
+	if err := s.Cancel("reminder/1"); err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := s.Pending("event/1")
+	if len(pending) != 1 || pending[0].ID != "reminder/2" {
+		t.Errorf("expected only the second job to be pending, got %+v", pending)
+	}
+	s.RunDue(start)
+}
+
+//TestJobRunOverItsLease runs a job for longer than its lease. Another replica takes the job over and
+//runs it, and the outcome of the first replica comes too late to count.
+func TestJobRunOverItsLease(t *testing.T) {
+	store := newMemoryStore()
+	start := time.Date(2026, 5, 4, 17, 0, 0, 0, time.UTC)
+	replicas := []*Scheduler{New(store), New(store)}
+	replicas[0].Handle("reminder", func(job persistence.ScheduledJob) error {
+		if ran, _ := replicas[1].RunDue(start.Add(2 * replicas[1].LeaseDuration)); ran != 1 {
+			t.Errorf("expected the other replica to take over the job once the lease ran out")
+		}
+		return errors.New("broker down")
+	})
+	replicas[1].Handle("reminder", func(job persistence.ScheduledJob) error {
+		return nil
+	})
+	replicas[0].Schedule("reminder/1", "reminder", "event/1", start, nil)
+
+	replicas[0].RunDue(start)
+	if job, _ := store.FindJob("reminder/1"); job.Status != persistence.JobDone || job.LastError != "" {
+		t.Errorf("expected the job to stay done, got %+v", job)
+	}
+}
+
+//unavailableStore is a store whose database can't be reached.
+type unavailableStore struct {
+	*memoryStore
+}
+
+func (unavailableStore) FindJob(id string) (persistence.ScheduledJob, error) {
+	return persistence.ScheduledJob{}, errors.New("database unavailable")
+}
+
+func TestCancel(t *testing.T) {
+	cases := []struct {
+		name  string
+		store Store
+		err   bool
+	}{
+		{"job that doesn't exist", newMemoryStore(), false},
+		{"database unavailable", unavailableStore{newMemoryStore()}, true},
+	}
+	for _, c := range cases {
+		if err := New(c.store).Cancel("reminder/1"); (err != nil) != c.err {
+			t.Errorf("%s: expected an error to be %v, got %v", c.name, c.err, err)
+		}
+	}
+}
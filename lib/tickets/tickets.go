@@ -0,0 +1,100 @@
+//Package tickets issues the e-tickets attendees show at the door. A ticket is a small signed token:
+//its payload names the booking, the event and the seat, and an Ed25519 signature makes it tamper
+//evident. Door staff only need the public key to tell a genuine ticket from a forged one, so tickets
+//can be verified without a connection to the bookings service.
+package tickets
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//tokenPrefix marks the format of a token, so that the format can change without old tickets being
+//mistaken for new ones.
+const tokenPrefix = "MYEV1"
+
+var (
+	ErrMalformedToken   = errors.New("ticket token is malformed")
+	ErrInvalidSignature = errors.New("ticket signature is invalid")
+)
+
+//Ticket admits one person. The short JSON names keep the token, and with it the QR code, small.
+type Ticket struct {
+	BookingID string `json:"b"`
+	EventID   string `json:"e"`
+	UserID    string `json:"u"`
+	Number    int    `json:"n"`           //tickets of a booking are numbered from 1
+	Seat      string `json:"s,omitempty"` //empty for events without assigned seating
+}
+
+//ID identifies the ticket; it is unique across all bookings.
+func (t Ticket) ID() string {
+	return fmt.Sprintf("%s-%d", t.BookingID, t.Number)
+}
+
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+//NewSigner creates a signer from a 32 byte Ed25519 seed.
+func NewSigner(seed []byte) (*Signer, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("ticket signing key must be %d bytes long", ed25519.SeedSize)
+	}
+	return &Signer{ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+//GenerateSigner creates a signer with a fresh random key.
+func GenerateSigner() (*Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{key}, nil
+}
+
+//PublicKey returns the key that verifies the tickets of the signer.
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+//Sign issues the token of a ticket: the prefix, the payload and the signature over both, each base64
+//encoded and separated by dots.
+func (s *Signer) Sign(t Ticket) (string, error) {
+	payload, err := json.Marshal(&t)
+	if err != nil {
+		return "", err
+	}
+	signed := tokenPrefix + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(s.key, []byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+//Verify checks the signature of a token and returns the ticket it stands for.
+func Verify(publicKey ed25519.PublicKey, token string) (Ticket, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenPrefix {
+		return Ticket{}, ErrMalformedToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Ticket{}, ErrMalformedToken
+	}
+	if !ed25519.Verify(publicKey, []byte(parts[0]+"."+parts[1]), signature) {
+		return Ticket{}, ErrInvalidSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Ticket{}, ErrMalformedToken
+	}
+	t := Ticket{}
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return Ticket{}, ErrMalformedToken
+	}
+	return t, nil
+}
@@ -0,0 +1,55 @@
+package tickets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	signer, err := NewSigner(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket := Ticket{BookingID: "5d1f", EventID: "5d20", UserID: "5d21", Number: 2, Seat: "A-1-3"}
+	token, err := signer.Sign(ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := Verify(signer.PublicKey(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified != ticket {
+		t.Errorf("expected %v, got %v", ticket, verified)
+	}
+	if verified.ID() != "5d1f-2" {
+		t.Errorf("expected ticket id 5d1f-2, got %s", verified.ID())
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	signer, _ := GenerateSigner()
+	token, _ := signer.Sign(Ticket{BookingID: "5d1f", EventID: "5d20", Number: 1})
+	other, _ := GenerateSigner()
+	forged, _ := other.Sign(Ticket{BookingID: "5d1f", EventID: "5d20", Number: 2})
+	parts := strings.Split(token, ".")
+	forgedParts := strings.Split(forged, ".")
+
+	cases := map[string]error{
+		"foreign key":     ErrInvalidSignature,
+		"swapped payload": ErrInvalidSignature,
+		"not a token":     ErrMalformedToken,
+		"unknown format":  ErrMalformedToken,
+	}
+	tokens := map[string]string{
+		"foreign key":     forged,
+		"swapped payload": parts[0] + "." + forgedParts[1] + "." + parts[2],
+		"not a token":     "hello",
+		"unknown format":  "MYEV0." + parts[1] + "." + parts[2],
+	}
+	for name, expected := range cases {
+		if _, err := Verify(signer.PublicKey(), tokens[name]); err != expected {
+			t.Errorf("%s: expected %v, got %v", name, expected, err)
+		}
+	}
+}
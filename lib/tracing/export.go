@@ -0,0 +1,308 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Exporter types of Init.
+const (
+	OTLP = "otlp"
+	FILE = "file"
+)
+
+const (
+	batchSize     = 256
+	queueSize     = 4096
+	flushInterval = 5 * time.Second
+)
+
+//An Exporter sends finished spans somewhere they can be looked at.
+type Exporter interface {
+	Export(service string, spans []*Span) error
+}
+
+var (
+	mu       sync.Mutex
+	exporter *batcher
+)
+
+//Init starts exporting the spans of the given service. The exporter is otlp, which posts the spans
+//to the OTLP/HTTP endpoint of a collector (like http://localhost:4318), or file, which appends them
+//to the file at endpoint in the same JSON encoding, one batch per line. Without an exporter, spans
+//are not exported.
+func Init(service string, exporterType string, endpoint string) error {
+	var e Exporter
+	switch exporterType {
+	case "":
+		return nil
+	case OTLP:
+		e = &OTLPExporter{Endpoint: endpointOf(endpoint), Client: &http.Client{Timeout: 10 * time.Second}}
+	case FILE:
+		file, err := os.OpenFile(endpoint, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		e = &FileExporter{Writer: file}
+	default:
+		return fmt.Errorf("unknown trace exporter %s", exporterType)
+	}
+	SetExporter(service, e)
+	return nil
+}
+
+//SetExporter starts exporting spans with the given exporter, or stops exporting them if it is nil.
+//Spans are queued and exported in batches, so that tracing doesn't slow down the requests it traces.
+//Spans that don't fit into a full queue are dropped.
+func SetExporter(service string, e Exporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	if exporter != nil {
+		exporter.stop()
+	}
+	exporter = nil
+	if e != nil {
+		exporter = newBatcher(service, e)
+	}
+}
+
+//Flush exports the spans that are still queued.
+func Flush() {
+	mu.Lock()
+	b := exporter
+	mu.Unlock()
+	if b != nil {
+		b.flush()
+	}
+}
+
+func export(span *Span) {
+	mu.Lock()
+	b := exporter
+	mu.Unlock()
+	if b == nil {
+		return
+	}
+	select {
+	case b.queue <- span:
+	default:
+	}
+}
+
+type batcher struct {
+	service  string
+	exporter Exporter
+	queue    chan *Span
+	flushes  chan chan struct{}
+	done     chan struct{}
+}
+
+func newBatcher(service string, e Exporter) *batcher {
+	b := &batcher{
+		service:  service,
+		exporter: e,
+		queue:    make(chan *Span, queueSize),
+		flushes:  make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *batcher) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := []*Span{}
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := b.exporter.Export(b.service, batch); err != nil {
+			slog.Warn("could not export spans", "spans", len(batch), "error", err)
+		}
+		batch = []*Span{}
+	}
+	for {
+		select {
+		case span := <-b.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-b.flushes:
+			for len(b.queue) > 0 {
+				batch = append(batch, <-b.queue)
+			}
+			send()
+			close(flushed)
+		case <-b.done:
+			send()
+			return
+		}
+	}
+}
+
+func (b *batcher) flush() {
+	flushed := make(chan struct{})
+	b.flushes <- flushed
+	<-flushed
+}
+
+func (b *batcher) stop() {
+	b.flush()
+	close(b.done)
+}
+
+//OTLPExporter posts spans to an OTLP/HTTP endpoint in the JSON encoding of OTLP.
+type OTLPExporter struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+func (o *OTLPExporter) Export(service string, spans []*Span) error {
+	body, err := encodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	resp, err := o.Client.Post(o.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+//FileExporter writes every batch of spans as one line of OTLP JSON, the format the file exporter of
+//the OpenTelemetry collector writes as well.
+type FileExporter struct {
+	Writer io.Writer
+
+	mu sync.Mutex
+}
+
+func (f *FileExporter) Export(service string, spans []*Span) error {
+	body, err := encodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.Writer.Write(append(body, '\n'))
+	return err
+}
+
+//The JSON encoding of OTLP, as far as we use it. IDs are hex encoded and times are nanoseconds in
+//strings.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` //2 is error, unset otherwise
+	Message string `json:"message,omitempty"`
+}
+
+func encodeOTLP(service string, spans []*Span) ([]byte, error) {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.Context.SpanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.Parent.Valid() {
+			span.ParentSpanID = hex.EncodeToString(s.Parent.SpanID[:])
+		}
+		keys := make([]string, 0, len(s.Attributes))
+		for key := range s.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: s.Attributes[key]}})
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		s.mu.Unlock()
+		encoded = append(encoded, span)
+	}
+	return json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: service}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/doublen987/web_dev/MyEvents/lib/tracing"},
+			Spans: encoded,
+		}},
+	}}})
+}
+
+//endpointOf completes the endpoint of a collector, which is given with or without the path of the
+//traces.
+func endpointOf(endpoint string) string {
+	endpoint = strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	return endpoint
+}
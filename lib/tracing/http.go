@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+//Header is the HTTP header, and the name of the message headers and attributes, that carries the
+//trace context.
+const Header = "traceparent"
+
+//Extract returns a context that continues the trace of the given traceparent. Invalid traceparents
+//are ignored, which starts a new trace.
+func Extract(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemote(ctx, sc)
+}
+
+//Inject sets the traceparent header of an outgoing request to the span the context carries.
+func Inject(ctx context.Context, header http.Header) {
+	if traceparent := Traceparent(ctx); traceparent != "" {
+		header.Set(Header, traceparent)
+	}
+}
+
+//Middleware starts a server span for every request to a router, continuing the trace of the caller
+//if the request has a traceparent. Spans are named after the route template, like
+//"GET /events/{eventID}", so that the requests of a route are found together.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				path = template
+			}
+		}
+		ctx, span := Start(Extract(r.Context(), r.Header.Get(Header)), r.Method+" "+path, Server)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			span.SetAttribute("http.status_code", strconv.Itoa(recorder.status))
+			if recorder.status >= 500 {
+				span.SetError(errors.New(http.StatusText(recorder.status)))
+			}
+			span.Finish()
+		}()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
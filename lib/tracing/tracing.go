@@ -0,0 +1,219 @@
+//Package tracing follows requests across the services. A trace starts with a request to one of the
+//HTTP APIs and continues through the events it causes, in whatever service handles them. Its
+//context travels in the W3C traceparent format: in the traceparent header of HTTP requests, and in
+//the headers or attributes of messages on the broker.
+//
+//Spans are kept in a context.Context. Code that does not take a context, like the persistence layer
+//and the event emitters, is bound to one with persistence.WithContext and msgqueue.WithContext.
+//
+//Spans are exported in batches to an OTLP collector or to a local file, see Init. Without an
+//exporter, trace contexts are still passed on, so that traces stay whole across services that do
+//export their spans.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Kinds of spans, numbered like in OTLP.
+type Kind int
+
+const (
+	Internal Kind = 1
+	Server   Kind = 2
+	Client   Kind = 3
+	Producer Kind = 4
+	Consumer Kind = 5
+)
+
+//A SpanContext identifies a span within its trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+//Valid reports whether the span context identifies a span. All-zero IDs are invalid.
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+//Traceparent formats the span context as a W3C traceparent, for example
+//00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+//ParseTraceparent parses a W3C traceparent. Versions after 00 are read the way the specification
+//asks for, by their first four fields.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	trace, err := hex.DecodeString(parts[1])
+	if err != nil || len(trace) != 16 {
+		return sc, fmt.Errorf("invalid trace ID in traceparent %q", s)
+	}
+	span, err := hex.DecodeString(parts[2])
+	if err != nil || len(span) != 8 {
+		return sc, fmt.Errorf("invalid span ID in traceparent %q", s)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, fmt.Errorf("invalid flags in traceparent %q", s)
+	}
+	copy(sc.TraceID[:], trace)
+	copy(sc.SpanID[:], span)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.Valid() {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+//A Span is an operation within a trace. Its methods may be called on a nil span, which is what the
+//functions that only continue traces return when there is none.
+type Span struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanContext //invalid for the root span of a trace
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Error      string
+
+	mu    sync.Mutex
+	ended bool
+}
+
+//SetAttribute records an attribute of the span, like the table a database call reads from.
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = map[string]string{}
+	}
+	s.Attributes[key] = value
+}
+
+//SetError marks the span as failed. A nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+//Finish ends the span and hands it to the exporter. Spans are finished once; later calls are
+//ignored.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	if s.Context.Sampled {
+		export(s)
+	}
+}
+
+type spanKey struct{}
+
+//ContextWithSpan returns a context that carries the given span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+//SpanFromContext returns the span a context carries, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+type remoteKey struct{}
+
+//ContextWithRemote returns a context that carries the span context of a span in another service,
+//like the one a traceparent names. Spans started from the context become its children.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.Valid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+//SpanContextFromContext returns the span context of the span a context carries, local or remote.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context, true
+	}
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+//Traceparent returns the traceparent of the span a context carries, or "" if it carries none.
+func Traceparent(ctx context.Context) string {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return sc.Traceparent()
+}
+
+//Start starts a span as a child of the span the context carries, or as the root of a new trace.
+//The span is finished with Finish.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	span := &Span{Name: name, Kind: kind, Start: time.Now()}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		span.Parent = parent
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = true
+	}
+	rand.Read(span.Context.SpanID[:])
+	return ContextWithSpan(ctx, span), span
+}
+
+//StartChild starts a span only if the context carries one, so that calls made outside of a trace,
+//like those of the background sweepers, don't each start a trace of their own. Otherwise it returns
+//the context and a nil span.
+func StartChild(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if _, ok := SpanContextFromContext(ctx); !ok {
+		return ctx, nil
+	}
+	return Start(ctx, name, kind)
+}
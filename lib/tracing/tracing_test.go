@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTraceparent(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled {
+		t.Error("expected the span context to be sampled")
+	}
+	if sc.Traceparent() != traceparent {
+		t.Errorf("expected %s, got %s", traceparent, sc.Traceparent())
+	}
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+	//Later versions may append fields.
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStart(t *testing.T) {
+	ctx, root := Start(context.Background(), "root", Server)
+	if !root.Context.Valid() || root.Parent.Valid() {
+		t.Fatalf("expected a root span, got %+v", root)
+	}
+	_, child := Start(ctx, "child", Client)
+	if child.Context.TraceID != root.Context.TraceID || child.Parent != root.Context {
+		t.Errorf("expected a child of %+v, got %+v", root.Context, child)
+	}
+	if child.Context.SpanID == root.Context.SpanID {
+		t.Error("expected the child to have a span ID of its own")
+	}
+
+	remote := Extract(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, continued := Start(remote, "consumer", Consumer)
+	if continued.Parent.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00" || continued.Context.Sampled {
+		t.Errorf("expected the remote trace to be continued, got %+v", continued)
+	}
+}
+
+func TestStartChild(t *testing.T) {
+	ctx, span := StartChild(context.Background(), "query", Client)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("expected no span outside of a trace")
+	}
+	//The methods of spans that were not started are no-ops.
+	span.SetAttribute("db.system", "mongodb")
+	span.SetError(http.ErrHandlerTimeout)
+	span.Finish()
+}
+
+func TestFileExporter(t *testing.T) {
+	out := &bytes.Buffer{}
+	SetExporter("bookings", &FileExporter{Writer: out})
+	defer SetExporter("", nil)
+
+	ctx, parent := Start(context.Background(), "POST /users/{userID}/bookings/", Server)
+	_, span := StartChild(ctx, "mongodb AddBookingForUser", Client)
+	span.SetAttribute("db.system", "mongodb")
+	span.SetError(http.ErrHandlerTimeout)
+	span.Finish()
+	parent.Finish()
+	Flush()
+
+	traces := otlpTraces{}
+	if err := json.Unmarshal(out.Bytes(), &traces); err != nil {
+		t.Fatalf("could not decode %s: %s", out, err)
+	}
+	if len(traces.ResourceSpans) != 1 || traces.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "bookings" {
+		t.Fatalf("expected the spans of the bookings service, got %s", out)
+	}
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	child := spans[0]
+	if child.Name != "mongodb AddBookingForUser" || child.Kind != Client || child.ParentSpanID != spans[1].SpanID || child.TraceID != spans[1].TraceID {
+		t.Errorf("expected the database span as child of the request, got %+v", child)
+	}
+	if child.Status.Code != 2 || child.Attributes[0].Key != "db.system" {
+		t.Errorf("expected a failed span with its attributes, got %+v", child)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var span *Span
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.Methods("GET").Path("/events/{eventID}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span = SpanFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest("GET", "/events/5a5f5c", nil)
+	req.Header.Set(Header, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if span == nil {
+		t.Fatal("expected the handler to run in a span")
+	}
+	if span.Name != "GET /events/{eventID}" || span.Kind != Server {
+		t.Errorf("expected a server span named after the route, got %s", span.Name)
+	}
+	if span.Parent.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("expected the trace of the caller to be continued, got parent %s", span.Parent.Traceparent())
+	}
+	if span.Attributes["http.status_code"] != "500" || span.Error == "" {
+		t.Errorf("expected a failed span, got %+v", span)
+	}
+}
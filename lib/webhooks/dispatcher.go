@@ -0,0 +1,274 @@
+//Package webhooks posts the events of MyEvents to the endpoints of partners that subscribed to
+//them. Every event a subscription wants becomes a delivery, which is stored before it is posted and
+//retried until the partner took it, the same way the notifications service sends emails.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//PublicEvents are the events partners can subscribe to. Events that carry personal or payment
+//details of users, like the bookings and check-ins of a user, stay inside MyEvents.
+var PublicEvents = []string{
+	"event.created",
+	"event.update",
+	"event.cancelled",
+	"location.created",
+}
+
+//IsPublic reports whether partners can subscribe to events with the given name.
+func IsPublic(eventName string) bool {
+	for _, name := range PublicEvents {
+		if name == eventName {
+			return true
+		}
+	}
+	return false
+}
+
+//claimDuration is how long a dispatcher has to post a delivery it claimed.
+const claimDuration = time.Minute
+
+//maxRetryDelay caps the delay between two attempts to post a delivery.
+const maxRetryDelay = time.Hour
+
+//Store is the part of the persistence layer the dispatcher needs.
+type Store interface {
+	FindAllWebhookSubscriptions() ([]persistence.WebhookSubscription, error)
+	FindWebhookSubscription([]byte) (persistence.WebhookSubscription, error)
+	AddWebhookFailure([]byte, int) (persistence.WebhookSubscription, error)
+	ResetWebhookFailures([]byte) error
+	AddWebhookDelivery(persistence.WebhookDelivery) error
+	FindDueWebhookDeliveries(int64) ([]persistence.WebhookDelivery, error)
+	ClaimWebhookDelivery(string, int64, int64) error
+	UpdateWebhookDelivery(persistence.WebhookDelivery) error
+}
+
+//Envelope is the body of every request. Data is the event as it travels through the message broker.
+type Envelope struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt int64           `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type Dispatcher struct {
+	Store        Store
+	Client       *http.Client
+	MaxAttempts  int           //a delivery that failed this often is given up
+	RetryDelay   time.Duration //delay after the first failed attempt, doubles with every further one
+	DisableAfter int           //a subscription whose endpoint failed this often in a row is disabled
+}
+
+//NewDispatcher returns a dispatcher that gives up on a delivery after ten attempts, and disables
+//a subscription after twenty failed attempts in a row.
+func NewDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:  10,
+		RetryDelay:   30 * time.Second,
+		DisableAfter: 20,
+	}
+}
+
+//Publish creates a delivery of the event for every subscription that wants it, and posts them. The
+//broker may hand out an event more than once; its deliveries have the same IDs then, and the ones
+//that were created before are left to the next round of Dispatch.
+func (d *Dispatcher) Publish(event msgqueue.Event) error {
+	//Queues keep their bindings, so events that were public once may still come in.
+	if !IsPublic(event.EventName()) {
+		return nil
+	}
+	subs, err := d.Store.FindAllWebhookSubscriptions()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, sub := range subs {
+		if !sub.Wants(event.EventName()) {
+			continue
+		}
+		id := DeliveryID(sub.ID, event.EventName(), data)
+		body, err := json.Marshal(Envelope{ID: id, Event: event.EventName(), CreatedAt: now.Unix(), Data: data})
+		if err != nil {
+			return err
+		}
+		delivery := persistence.WebhookDelivery{
+			ID:             id,
+			SubscriptionID: sub.ID,
+			EventName:      event.EventName(),
+			Payload:        string(body),
+			Status:         persistence.DeliveryPending,
+			NextAttempt:    now.Unix(),
+			CreatedAt:      now.Unix(),
+		}
+		err = d.Store.AddWebhookDelivery(delivery)
+		if err == persistence.ErrDeliveryExists {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		d.deliver(delivery, now)
+	}
+	return nil
+}
+
+//Dispatch posts every delivery that is due at the given time.
+func (d *Dispatcher) Dispatch(now time.Time) error {
+	due, err := d.Store.FindDueWebhookDeliveries(now.Unix())
+	if err != nil {
+		return err
+	}
+	for _, delivery := range due {
+		d.deliver(delivery, now)
+	}
+	return nil
+}
+
+//Run runs Dispatch every interval. It never returns, so it should be started in its own goroutine.
+func (d *Dispatcher) Run(interval time.Duration) {
+	slog.Info("posting due webhook deliveries", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := d.Dispatch(now); err != nil {
+			slog.Error("error while posting due webhook deliveries", "error", err)
+		}
+	}
+}
+
+//deliver claims a delivery, posts it and records the outcome, for the delivery as well as for
+//the health of its subscription.
+func (d *Dispatcher) deliver(delivery persistence.WebhookDelivery, now time.Time) {
+	err := d.Store.ClaimWebhookDelivery(delivery.ID, now.Unix(), now.Add(claimDuration).Unix())
+	if err == persistence.ErrDeliveryClaimed {
+		return
+	}
+	if err != nil {
+		slog.Error("could not claim webhook delivery", "delivery", delivery.ID, "error", err)
+		return
+	}
+
+	sub, err := d.Store.FindWebhookSubscription([]byte(delivery.SubscriptionID))
+	if err != nil || sub.Disabled {
+		//Deliveries of subscriptions that were removed or disabled are not posted anymore.
+		delivery.Status = persistence.DeliveryFailed
+		delivery.LastError = "subscription was removed or disabled"
+		d.update(delivery)
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseStatus, err = d.post(sub, delivery)
+	if err == nil {
+		delivery.Status = persistence.DeliveryDelivered
+		delivery.DeliveredAt = time.Now().Unix()
+		delivery.LastError = ""
+		if sub.Failures > 0 {
+			if err := d.Store.ResetWebhookFailures([]byte(sub.ID)); err != nil {
+				slog.Error("could not reset the failures of webhook subscription", "subscription", sub.ID, "error", err)
+			}
+		}
+		d.update(delivery)
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if failing, err := d.Store.AddWebhookFailure([]byte(sub.ID), d.DisableAfter); err != nil {
+		slog.Error("could not count the failure of webhook subscription", "subscription", sub.ID, "error", err)
+	} else {
+		sub = failing
+	}
+	if sub.Disabled {
+		slog.Warn("disabling webhook subscription", "subscription", sub.ID, "failures", sub.Failures)
+	}
+	switch {
+	case sub.Disabled:
+		delivery.Status = persistence.DeliveryFailed
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = persistence.DeliveryFailed
+		slog.Error("giving up on webhook delivery", "delivery", delivery.ID, "attempts", delivery.Attempts, "error", err)
+	default:
+		delivery.NextAttempt = now.Add(d.retryDelay(delivery.Attempts)).Unix()
+		slog.Warn("could not post webhook delivery, retrying", "delivery", delivery.ID, "retry_at", time.Unix(delivery.NextAttempt, 0), "error", err)
+	}
+	d.update(delivery)
+}
+
+//post posts a delivery to the endpoint of its subscription. Any status but 2xx counts as a failure.
+func (d *Dispatcher) post(sub persistence.WebhookSubscription, delivery persistence.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.EventName)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, time.Now(), body))
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint responded with %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+func (d *Dispatcher) update(delivery persistence.WebhookDelivery) {
+	if err := d.Store.UpdateWebhookDelivery(delivery); err != nil {
+		slog.Error("could not store the outcome of webhook delivery", "delivery", delivery.ID, "error", err)
+	}
+}
+
+//retryDelay is the delay after the given number of failed attempts.
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.RetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+//DeliveryID derives the ID of the delivery of an event to a subscription from the event, so that
+//the same event makes the same delivery however often it is published.
+func DeliveryID(subscriptionID string, eventName string, data []byte) string {
+	h := sha256.New()
+	h.Write([]byte(subscriptionID + "\x00" + eventName + "\x00"))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+//NewID returns a random hex ID for subscriptions and secrets.
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,76 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrMalformedEndpoint = errors.New("webhook endpoint must be an http or https url")
+	ErrInternalEndpoint  = errors.New("webhook endpoint must not be inside the network of MyEvents")
+)
+
+//lookupIP resolves the host names of endpoints.
+var lookupIP = net.LookupIP
+
+//CheckEndpoint makes sure a partner can't point a subscription at MyEvents itself: the endpoint has
+//to be an http or https url whose host is, or resolves to, public addresses only.
+func CheckEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrMalformedEndpoint
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrInternalEndpoint
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, err = lookupIP(host)
+		if err != nil {
+			return fmt.Errorf("cannot resolve webhook endpoint %s: %v", host, err)
+		}
+	}
+	for _, ip := range ips {
+		if isInternal(ip) {
+			return ErrInternalEndpoint
+		}
+	}
+	return nil
+}
+
+//isInternal reports whether an address can't be reached from the internet, or only reaches the
+//machine or network the dispatcher runs in.
+func isInternal(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+//NewPublicClient returns an http client that refuses to connect to internal addresses. It is checked
+//again when connecting because the host name of an endpoint may resolve to another address than
+//when the subscription was made.
+func NewPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isInternal(ip) {
+				return ErrInternalEndpoint
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil //a proxy would be the address that is checked
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
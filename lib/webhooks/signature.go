@@ -0,0 +1,68 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Headers of every request the dispatcher posts.
+const (
+	SignatureHeader = "X-MyEvents-Signature"
+	EventHeader     = "X-MyEvents-Event"
+	DeliveryHeader  = "X-MyEvents-Delivery" //stays the same across retries, so partners can drop duplicates
+)
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+//Sign returns the signature header of a request body, like t=1714842000,v1=5257a869.... The
+//signature is the hex encoded HMAC-SHA256 of the timestamp, a dot and the body, keyed with the
+//secret of the subscription. Signing the timestamp keeps old requests from being replayed.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), mac(secret, timestamp.Unix(), body))
+}
+
+//Verify checks the signature header of a request body, and that it was signed no longer than
+//tolerance before now. Partners can use it as a reference for their own check.
+func Verify(secret string, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp int64 = -1
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidSignature
+		}
+		switch kv[0] {
+		case "t":
+			t, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			timestamp = t
+		case "v1":
+			signature = kv[1]
+		}
+	}
+	if timestamp < 0 || signature == "" {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(mac(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func mac(secret string, timestamp int64, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%d.", timestamp)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
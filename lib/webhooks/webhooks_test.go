@@ -0,0 +1,365 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//memoryStore keeps subscriptions and deliveries in memory, with the same claim semantics as the
+//database layers.
+type memoryStore struct {
+	sync.Mutex
+	subs       map[string]persistence.WebhookSubscription
+	deliveries map[string]persistence.WebhookDelivery
+}
+
+func newMemoryStore(subs ...persistence.WebhookSubscription) *memoryStore {
+	m := &memoryStore{
+		subs:       map[string]persistence.WebhookSubscription{},
+		deliveries: map[string]persistence.WebhookDelivery{},
+	}
+	for _, sub := range subs {
+		m.subs[sub.ID] = sub
+	}
+	return m
+}
+
+func (m *memoryStore) FindAllWebhookSubscriptions() ([]persistence.WebhookSubscription, error) {
+	m.Lock()
+	defer m.Unlock()
+	subs := []persistence.WebhookSubscription{}
+	for _, sub := range m.subs {
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+func (m *memoryStore) FindWebhookSubscription(id []byte) (persistence.WebhookSubscription, error) {
+	m.Lock()
+	defer m.Unlock()
+	sub, ok := m.subs[string(id)]
+	if !ok {
+		return sub, errors.New("not found")
+	}
+	return sub, nil
+}
+
+func (m *memoryStore) AddWebhookFailure(id []byte, disableAfter int) (persistence.WebhookSubscription, error) {
+	m.Lock()
+	defer m.Unlock()
+	sub, ok := m.subs[string(id)]
+	if !ok {
+		return sub, errors.New("not found")
+	}
+	sub.Failures++
+	sub.Disabled = sub.Disabled || sub.Failures >= disableAfter
+	m.subs[sub.ID] = sub
+	return sub, nil
+}
+
+func (m *memoryStore) ResetWebhookFailures(id []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	sub, ok := m.subs[string(id)]
+	if !ok {
+		return errors.New("not found")
+	}
+	sub.Failures = 0
+	m.subs[sub.ID] = sub
+	return nil
+}
+
+func (m *memoryStore) AddWebhookDelivery(d persistence.WebhookDelivery) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.deliveries[d.ID]; ok {
+		return persistence.ErrDeliveryExists
+	}
+	m.deliveries[d.ID] = d
+	return nil
+}
+
+func (m *memoryStore) FindDueWebhookDeliveries(now int64) ([]persistence.WebhookDelivery, error) {
+	m.Lock()
+	defer m.Unlock()
+	due := []persistence.WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if d.Status == persistence.DeliveryPending && d.NextAttempt <= now {
+			due = append(due, d)
+		}
+	}
+	return due, nil
+}
+
+func (m *memoryStore) ClaimWebhookDelivery(id string, now int64, until int64) error {
+	m.Lock()
+	defer m.Unlock()
+	d := m.deliveries[id]
+	if d.Status != persistence.DeliveryPending || d.NextAttempt > now {
+		return persistence.ErrDeliveryClaimed
+	}
+	d.NextAttempt = until
+	m.deliveries[id] = d
+	return nil
+}
+
+func (m *memoryStore) UpdateWebhookDelivery(d persistence.WebhookDelivery) error {
+	m.Lock()
+	defer m.Unlock()
+	m.deliveries[d.ID] = d
+	return nil
+}
+
+func (m *memoryStore) only(t *testing.T) persistence.WebhookDelivery {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.deliveries) != 1 {
+		t.Fatalf("expected one delivery, got %d", len(m.deliveries))
+	}
+	for _, d := range m.deliveries {
+		return d
+	}
+	return persistence.WebhookDelivery{}
+}
+
+func TestPublishPostsSignedEvent(t *testing.T) {
+	received := make(chan Envelope, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := Verify("s3cret", r.Header.Get(SignatureHeader), body, time.Minute, time.Now()); err != nil {
+			t.Errorf("expected a valid signature, got %s", err)
+		}
+		if r.Header.Get(EventHeader) != "event.cancelled" {
+			t.Errorf("expected the event header to be event.cancelled, got %s", r.Header.Get(EventHeader))
+		}
+		var envelope Envelope
+		json.Unmarshal(body, &envelope)
+		received <- envelope
+	}))
+	defer receiver.Close()
+
+	store := newMemoryStore(
+		persistence.WebhookSubscription{ID: "partner", URL: receiver.URL, Secret: "s3cret", Events: []string{"event.cancelled"}},
+		persistence.WebhookSubscription{ID: "other", URL: receiver.URL, Secret: "s3cret", Events: []string{"event.created"}},
+	)
+	d := NewDispatcher(store)
+	if err := d.Publish(&contracts.EventCancelledEvent{ID: "e1", Reason: "storm"}); err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := <-received
+	var cancelled contracts.EventCancelledEvent
+	json.Unmarshal(envelope.Data, &cancelled)
+	if envelope.Event != "event.cancelled" || cancelled.ID != "e1" {
+		t.Errorf("expected the cancellation to be posted, got %+v", envelope)
+	}
+	if delivery := store.only(t); delivery.Status != persistence.DeliveryDelivered || delivery.ResponseStatus != 200 {
+		t.Errorf("expected the delivery to be logged as delivered, got %+v", delivery)
+	}
+}
+
+func TestFailedDeliveryIsRetriedWithBackoff(t *testing.T) {
+	fail := true
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(503)
+		}
+	}))
+	defer receiver.Close()
+
+	store := newMemoryStore(persistence.WebhookSubscription{ID: "partner", URL: receiver.URL, Secret: "s3cret"})
+	d := NewDispatcher(store)
+	d.Publish(&contracts.EventCancelledEvent{ID: "e1"})
+	first := store.only(t)
+	if first.Status != persistence.DeliveryPending || first.ResponseStatus != 503 || first.Attempts != 1 {
+		t.Fatalf("expected the delivery to be retried, got %+v", first)
+	}
+
+	//The second attempt fails as well and waits twice as long.
+	now := time.Unix(first.NextAttempt, 0)
+	d.Dispatch(now)
+	second := store.only(t)
+	if got := time.Unix(second.NextAttempt, 0).Sub(now); got != 2*d.RetryDelay {
+		t.Errorf("expected the next attempt after %s, got %s", 2*d.RetryDelay, got)
+	}
+
+	fail = false
+	d.Dispatch(time.Unix(second.NextAttempt, 0))
+	if delivery := store.only(t); delivery.Status != persistence.DeliveryDelivered || delivery.Attempts != 3 {
+		t.Errorf("expected the third attempt to deliver, got %+v", delivery)
+	}
+	if sub, _ := store.FindWebhookSubscription([]byte("partner")); sub.Failures != 0 {
+		t.Errorf("expected a delivery to reset the failures of the subscription, got %d", sub.Failures)
+	}
+}
+
+func TestFailingEndpointIsDisabled(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer receiver.Close()
+
+	store := newMemoryStore(persistence.WebhookSubscription{ID: "partner", URL: receiver.URL, Secret: "s3cret"})
+	d := NewDispatcher(store)
+	d.DisableAfter = 2
+	d.Publish(&contracts.EventCancelledEvent{ID: "e1"})
+	d.Dispatch(time.Unix(store.only(t).NextAttempt, 0))
+
+	sub, _ := store.FindWebhookSubscription([]byte("partner"))
+	if !sub.Disabled {
+		t.Fatalf("expected the subscription to be disabled, got %+v", sub)
+	}
+	if delivery := store.only(t); delivery.Status != persistence.DeliveryFailed {
+		t.Errorf("expected the delivery to be given up, got %+v", delivery)
+	}
+	d.Publish(&contracts.EventCancelledEvent{ID: "e2"})
+	store.only(t)
+}
+
+//TestFailuresKeepChangesToTheSubscription fails a delivery while the partner changes the URL of the
+//subscription. Counting the failure must not bring back the old URL.
+func TestFailuresKeepChangesToTheSubscription(t *testing.T) {
+	store := newMemoryStore(persistence.WebhookSubscription{ID: "partner", URL: "http://old.partner.example", Secret: "s3cret"})
+	d := NewDispatcher(store)
+	d.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		//The partner moves the endpoint while the delivery is being posted.
+		store.Lock()
+		sub := store.subs["partner"]
+		sub.URL = "http://new.partner.example"
+		store.subs["partner"] = sub
+		store.Unlock()
+		return &http.Response{StatusCode: 500, Status: "500 Internal Server Error", Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	d.Publish(&contracts.EventCancelledEvent{ID: "e1"})
+
+	sub, _ := store.FindWebhookSubscription([]byte("partner"))
+	if sub.URL != "http://new.partner.example" || sub.Failures != 1 {
+		t.Errorf("expected the new URL and one failure, got %+v", sub)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+//TestRedeliveredEventIsPostedOnce publishes the same event twice, like the broker does when a
+//listener went away before it acknowledged the event.
+func TestRedeliveredEventIsPostedOnce(t *testing.T) {
+	posted := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted++
+	}))
+	defer receiver.Close()
+
+	store := newMemoryStore(persistence.WebhookSubscription{ID: "partner", URL: receiver.URL, Secret: "s3cret"})
+	d := NewDispatcher(store)
+	for i := 0; i < 2; i++ {
+		if err := d.Publish(&contracts.EventCancelledEvent{ID: "e1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if delivery := store.only(t); posted != 1 || delivery.Status != persistence.DeliveryDelivered {
+		t.Errorf("expected the event to be posted once, got %d posts and %+v", posted, delivery)
+	}
+	if DeliveryID("partner", "event.cancelled", []byte("{}")) == DeliveryID("other", "event.cancelled", []byte("{}")) {
+		t.Error("expected deliveries to other subscriptions to have other IDs")
+	}
+}
+
+func TestVerifyRejectsTamperedBody(t *testing.T) {
+	now := time.Now()
+	header := Sign("s3cret", now, []byte(`{"seats":2}`))
+	if err := Verify("s3cret", header, []byte(`{"seats":20}`), time.Minute, now); err != ErrInvalidSignature {
+		t.Errorf("expected a tampered body to be rejected, got %v", err)
+	}
+	if err := Verify("s3cret", header, []byte(`{"seats":2}`), time.Minute, now.Add(time.Hour)); err != ErrInvalidSignature {
+		t.Errorf("expected an old signature to be rejected, got %v", err)
+	}
+}
+
+//TestPrivateEventsAreNotPosted publishes a booking to a subscription that wants every event, like one
+//made while bookings were still public.
+func TestPrivateEventsAreNotPosted(t *testing.T) {
+	posted := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted++
+	}))
+	defer receiver.Close()
+
+	store := newMemoryStore(persistence.WebhookSubscription{ID: "partner", URL: receiver.URL, Secret: "s3cret"})
+	d := NewDispatcher(store)
+	if err := d.Publish(&contracts.EventBookedEvent{ID: "b1", UserID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	if posted != 0 || len(store.deliveries) != 0 {
+		t.Errorf("expected the booking to stay inside, got %d posts", posted)
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	cases := []struct {
+		endpoint string
+		err      error
+	}{
+		{"https://93.184.216.34/hooks", nil},
+		{"http://[2606:2800:220:1:248:1893:25c8:1946]:8080/hooks", nil},
+		{"ftp://93.184.216.34/hooks", ErrMalformedEndpoint},
+		{"https:///hooks", ErrMalformedEndpoint},
+		{"not a url", ErrMalformedEndpoint},
+		{"http://localhost:8181/events", ErrInternalEndpoint},
+		{"http://api.localhost./events", ErrInternalEndpoint},
+		{"http://127.0.0.1:8181/events", ErrInternalEndpoint},
+		{"http://[::1]/events", ErrInternalEndpoint},
+		{"http://10.0.0.7/events", ErrInternalEndpoint},
+		{"http://192.168.1.1/events", ErrInternalEndpoint},
+		{"http://169.254.169.254/latest/meta-data", ErrInternalEndpoint},
+		{"http://0.0.0.0/events", ErrInternalEndpoint},
+	}
+	for _, c := range cases {
+		if err := CheckEndpoint(c.endpoint); err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.endpoint, c.err, err)
+		}
+	}
+
+	//Host names are checked by the addresses they resolve to.
+	defer func(lookup func(string) ([]net.IP, error)) { lookupIP = lookup }(lookupIP)
+	lookupIP = func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.7")}, nil
+	}
+	if err := CheckEndpoint("https://partner.example/hooks"); err != ErrInternalEndpoint {
+		t.Errorf("expected a host name that resolves to an internal address to be refused, got %v", err)
+	}
+}
+
+//TestPublicClientRefusesInternalAddresses posts to an endpoint that was public when the subscription
+//was made, and resolves to the dispatcher's own machine now.
+func TestPublicClientRefusesInternalAddresses(t *testing.T) {
+	posted := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted++
+	}))
+	defer receiver.Close()
+
+	store := newMemoryStore(persistence.WebhookSubscription{ID: "partner", URL: receiver.URL, Secret: "s3cret"})
+	d := NewDispatcher(store)
+	d.Client = NewPublicClient(time.Second)
+	if err := d.Publish(&contracts.EventCancelledEvent{ID: "e1"}); err != nil {
+		t.Fatal(err)
+	}
+	if delivery := store.only(t); posted != 0 || delivery.Status == persistence.DeliveryDelivered {
+		t.Errorf("expected the post to be refused, got %d posts and %+v", posted, delivery)
+	}
+}
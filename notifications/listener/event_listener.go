@@ -0,0 +1,252 @@
+package listener
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/notify"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
+	"github.com/doublen987/web_dev/MyEvents/notifications/notifier"
+	"github.com/doublen987/web_dev/MyEvents/notifications/reminders"
+)
+
+type EventProcessor struct {
+	EventListener msgqueue.EventListener
+	Database      persistence.DatabaseHandler
+	Notifier      *notifier.Notifier
+	Reminders     *reminders.Reminders
+
+	ctx context.Context //the delivery being handled, see withContext
+}
+
+//Here we listen for everything users want to be told about
+func (p *EventProcessor) ProcessEvents() error {
+	slog.Info("listening to events")
+	deliveries, errors, err := p.EventListener.ListenContext("user.created", "event.created", "event.update", "event.cancelled", "event.booked", "booking.cancelled", "reminder.due")
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case delivery := <-deliveries:
+			//Received events will be passed to the handleEvent function
+			p.process(delivery)
+		case err = <-errors:
+			slog.Error("could not receive event", "error", err)
+		}
+	}
+}
+
+//process handles a delivered event in a consumer span, which continues the trace of its producer.
+func (p *EventProcessor) process(delivery msgqueue.Delivery) {
+	ctx, span := tracing.Start(delivery.Context, "process "+delivery.Event.EventName(), tracing.Consumer)
+	defer span.Finish()
+	p.withContext(ctx).handleEvent(delivery.Event)
+}
+
+//withContext returns a processor whose database calls, including those of its notifier, are part
+//of the trace the context carries.
+func (p *EventProcessor) withContext(ctx context.Context) *EventProcessor {
+	bound := *p
+	bound.ctx = ctx
+	bound.Database = persistence.WithContext(ctx, p.Database)
+	if p.Notifier != nil {
+		notifier := *p.Notifier
+		notifier.Database = persistence.WithContext(ctx, p.Notifier.Database)
+		bound.Notifier = &notifier
+	}
+	return &bound
+}
+func (p *EventProcessor) handleEvent(event msgqueue.Event) {
+	//The notifications service keeps the contact details of users, the events and who booked them
+	//in its own database, so that it can render notifications without asking the other services.
+	switch e := event.(type) {
+	case *contracts.UserCreatedEvent:
+		slog.InfoContext(p.ctx, "user created", "user", e.ID)
+		//A user that is announced twice keeps the preferences it already set.
+		if _, err := p.Database.FindNotificationPreferences([]byte(e.ID)); err != nil {
+			err = p.Database.SaveNotificationPreferences(persistence.NotificationPreferences{
+				UserID: e.ID,
+				Email:  e.Email,
+				Name:   e.First,
+				Locale: notify.DefaultLocale,
+			})
+			if err != nil {
+				slog.ErrorContext(p.ctx, "could not store notification preferences", "user", e.ID, "error", err)
+				return
+			}
+		}
+		p.notify("welcome/"+e.ID, e.ID, persistence.NotifyWelcome, notifier.Data{})
+	case *contracts.EventCreatedEvent:
+		slog.InfoContext(p.ctx, "event created", "event", e.ID)
+		id, err := hex.DecodeString(e.ID)
+		if err != nil {
+			slog.WarnContext(p.ctx, "malformed event id", "event", e.ID)
+			return
+		}
+		p.Database.AddEvent(persistence.Event{
+			ID:        string(id),
+			Name:      e.Name,
+			StartDate: e.Start.Unix(),
+			EndDate:   e.End.Unix(),
+			Location:  persistence.Location{ID: e.LocationID, Name: e.Venue, TimeZone: e.TimeZone},
+			SeriesID:  e.SeriesID,
+		})
+	case *contracts.EventUpdatedEvent:
+		slog.InfoContext(p.ctx, "event updated", "event", e.ID, "sequence", e.Sequence)
+		event, ok := p.findEvent(e.ID)
+		if !ok {
+			return
+		}
+		event.Name = e.Name
+		event.StartDate = e.Start.Unix()
+		event.EndDate = e.End.Unix()
+		event.Sequence = e.Sequence
+		if err := p.Database.UpdateEvent(event); err != nil {
+			slog.ErrorContext(p.ctx, "could not update event", "event", e.ID, "error", err)
+		}
+		if err := p.Reminders.EventMoved(e.ID, e.Start); err != nil {
+			slog.ErrorContext(p.ctx, "could not reschedule the reminders of event", "event", e.ID, "error", err)
+		}
+		//Every change of an event has its own sequence, so attendees hear about each of them once.
+		p.notifyAttendees(e.ID, fmt.Sprintf("event.changed/%s/%d", e.ID, e.Sequence), persistence.NotifyEventChanged,
+			notifier.Data{Event: eventData(event)})
+	case *contracts.EventCancelledEvent:
+		slog.InfoContext(p.ctx, "event cancelled", "event", e.ID, "reason", e.Reason)
+		event, ok := p.findEvent(e.ID)
+		if !ok {
+			return
+		}
+		if err := p.Database.CancelEvent([]byte(event.ID), e.CancelledAt); err != nil {
+			slog.ErrorContext(p.ctx, "could not cancel event", "event", e.ID, "error", err)
+		}
+		if err := p.Reminders.CancelEvent(e.ID); err != nil {
+			slog.ErrorContext(p.ctx, "could not cancel the reminders of event", "event", e.ID, "error", err)
+		}
+		p.notifyAttendees(e.ID, "event.cancelled/"+e.ID, persistence.NotifyEventCancelled,
+			notifier.Data{Event: eventData(event), Reason: e.Reason})
+	case *contracts.EventBookedEvent:
+		slog.InfoContext(p.ctx, "booking created", "booking", e.ID, "event", e.EventID)
+		err := p.Database.AddAttendee(persistence.Attendee{EventID: e.EventID, UserID: e.UserID, BookingID: e.ID})
+		if err != nil {
+			slog.ErrorContext(p.ctx, "could not store attendee", "booking", e.ID, "error", err)
+		}
+		event, ok := p.findEvent(e.EventID)
+		if !ok {
+			return
+		}
+		if err := p.Reminders.Schedule(e.ID, e.EventID, e.UserID, event.Start()); err != nil {
+			slog.ErrorContext(p.ctx, "could not schedule the reminders of booking", "booking", e.ID, "error", err)
+		}
+		p.notify("booking.confirmed/"+e.ID, e.UserID, persistence.NotifyBookingConfirmed, notifier.Data{
+			Event: eventData(event),
+			Booking: notifier.Booking{
+				Seats:      e.Seats,
+				SeatIDs:    e.SeatIDs,
+				TicketType: e.TicketType,
+				Total:      e.Total,
+				Currency:   e.Currency,
+			},
+		})
+	case *contracts.BookingCancelledEvent:
+		slog.InfoContext(p.ctx, "booking cancelled", "booking", e.ID, "event", e.EventID)
+		if err := p.Database.RemoveAttendee([]byte(e.EventID), []byte(e.ID)); err != nil {
+			slog.ErrorContext(p.ctx, "could not remove attendee", "booking", e.ID, "error", err)
+		}
+		if err := p.Reminders.CancelBooking(e.ID); err != nil {
+			slog.ErrorContext(p.ctx, "could not cancel the reminders of booking", "booking", e.ID, "error", err)
+		}
+		event, ok := p.findEvent(e.EventID)
+		if !ok {
+			return
+		}
+		//Bookings of a cancelled event are cancelled as well; their users already got told about
+		//the cancellation of the event.
+		if event.CancelledAt > 0 {
+			return
+		}
+		p.notify("booking.cancelled/"+e.ID, e.UserID, persistence.NotifyBookingCancelled, notifier.Data{
+			Event:   eventData(event),
+			Booking: notifier.Booking{Seats: e.Seats},
+		})
+	case *contracts.ReminderDueEvent:
+		slog.InfoContext(p.ctx, "reminder due", "reminder", e.ID, "booking", e.BookingID)
+		event, ok := p.findEvent(e.EventID)
+		if !ok || event.CancelledAt > 0 || !p.attends(e.EventID, e.BookingID) {
+			return
+		}
+		//The start is part of the key, so an attendee of an event that moved is reminded again.
+		key := fmt.Sprintf("reminder/%s/%d/%d", e.BookingID, e.LeadHours, e.Start.Unix())
+		p.notify(key, e.UserID, persistence.NotifyEventReminder, notifier.Data{Event: eventData(event), Hours: e.LeadHours})
+	default:
+		slog.WarnContext(p.ctx, "unknown event", "event", event.EventName())
+	}
+}
+
+func (p *EventProcessor) notify(key string, userID string, kind string, data notifier.Data) {
+	if err := p.Notifier.Notify(key, userID, kind, data); err != nil {
+		slog.ErrorContext(p.ctx, "could not notify user", "user", userID, "key", key, "error", err)
+	}
+}
+
+//notifyAttendees notifies every user that booked the event. Users with several bookings of the
+//event are notified once.
+func (p *EventProcessor) notifyAttendees(eventID string, key string, kind string, data notifier.Data) {
+	attendees, err := p.Database.FindAttendeesByEventId([]byte(eventID))
+	if err != nil {
+		slog.ErrorContext(p.ctx, "could not load the attendees of event", "event", eventID, "error", err)
+		return
+	}
+	notified := map[string]bool{}
+	for _, attendee := range attendees {
+		if notified[attendee.UserID] {
+			continue
+		}
+		notified[attendee.UserID] = true
+		p.notify(key+"/"+attendee.UserID, attendee.UserID, kind, data)
+	}
+}
+
+//attends reports whether the booking of an event is still there, so that reminders that were due
+//while the booking was cancelled are dropped.
+func (p *EventProcessor) attends(eventID string, bookingID string) bool {
+	attendees, err := p.Database.FindAttendeesByEventId([]byte(eventID))
+	if err != nil {
+		slog.ErrorContext(p.ctx, "could not load the attendees of event", "event", eventID, "error", err)
+		return false
+	}
+	for _, attendee := range attendees {
+		if attendee.BookingID == bookingID {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *EventProcessor) findEvent(eventID string) (persistence.Event, bool) {
+	id, err := hex.DecodeString(eventID)
+	if err != nil {
+		slog.WarnContext(p.ctx, "malformed event id", "event", eventID)
+		return persistence.Event{}, false
+	}
+	event, err := p.Database.FindEvent(id)
+	if err != nil {
+		slog.ErrorContext(p.ctx, "could not load event", "event", eventID, "error", err)
+		return persistence.Event{}, false
+	}
+	return event, true
+}
+
+func eventData(event persistence.Event) notifier.Event {
+	return notifier.Event{
+		Name:  event.Name,
+		Venue: event.Location.Venue(),
+		Start: event.Start(),
+		End:   event.End(),
+	}
+}
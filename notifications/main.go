@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/configuration"
+	"github.com/doublen987/web_dev/MyEvents/lib/eventstore"
+	"github.com/doublen987/web_dev/MyEvents/lib/health"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	msgqueue_amqp "github.com/doublen987/web_dev/MyEvents/lib/msgqueue/amqp"
+	"github.com/doublen987/web_dev/MyEvents/lib/notify"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/dblayer"
+	"github.com/doublen987/web_dev/MyEvents/lib/scheduler"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
+	"github.com/doublen987/web_dev/MyEvents/notifications/listener"
+	"github.com/doublen987/web_dev/MyEvents/notifications/notifier"
+	"github.com/doublen987/web_dev/MyEvents/notifications/reminders"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+)
+
+//notificationKinds are the kinds of notifications users can turn off.
+var notificationKinds = map[string]bool{
+	persistence.NotifyWelcome:          true,
+	persistence.NotifyBookingConfirmed: true,
+	persistence.NotifyBookingCancelled: true,
+	persistence.NotifyEventChanged:     true,
+	persistence.NotifyEventCancelled:   true,
+	persistence.NotifyEventReminder:    true,
+}
+
+type notificationServiceHandler struct {
+	dbhandler persistence.DatabaseHandler
+}
+
+//withContext returns a handler whose database calls are part of the trace the context carries.
+func (nh *notificationServiceHandler) withContext(ctx context.Context) *notificationServiceHandler {
+	bound := *nh
+	bound.dbhandler = persistence.WithContext(ctx, nh.dbhandler)
+	return &bound
+}
+
+func (nh *notificationServiceHandler) findPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	prefs, err := nh.dbhandler.FindNotificationPreferences([]byte(userID))
+	if err != nil {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "no notification preferences for user %s"}`, userID)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf8")
+	json.NewEncoder(w).Encode(&prefs)
+}
+
+//updatePreferencesHandler changes the preferences of a user. Fields that are left out of the
+//request stay as they are.
+func (nh *notificationServiceHandler) updatePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	prefs, err := nh.dbhandler.FindNotificationPreferences([]byte(userID))
+	if err != nil {
+		prefs = persistence.NotificationPreferences{Locale: notify.DefaultLocale}
+	}
+	err = json.NewDecoder(r.Body).Decode(&prefs)
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "error occured while decoding preferences %s"}`, err)
+		return
+	}
+	prefs.UserID = userID
+	for _, kind := range prefs.Disabled {
+		if !notificationKinds[kind] {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, `{"error": "unknown kind of notification %s"}`, kind)
+			return
+		}
+	}
+	if prefs.Locale == "" {
+		prefs.Locale = notify.DefaultLocale
+	}
+
+	if err := nh.dbhandler.SaveNotificationPreferences(prefs); err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "error occured while persisting preferences %s"}`, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf8")
+	json.NewEncoder(w).Encode(&prefs)
+}
+
+//notificationsHandler lists the notifications of a user, newest first, with whether they were sent.
+func (nh *notificationServiceHandler) notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	notifications, err := nh.dbhandler.FindNotificationsByUserId([]byte(userID))
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "error occured while loading notifications %s"}`, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf8")
+	json.NewEncoder(w).Encode(&notifications)
+}
+
+func userIDFromRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := mux.Vars(r)["userID"]
+	if _, err := hex.DecodeString(userID); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "malformed user id %s"}`, userID)
+		return "", false
+	}
+	return userID, true
+}
+
+func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.DatabaseHandler, checker *health.Checker) (chan error, chan error) {
+	r := mux.NewRouter()
+	//Requests get an ID, continue the correlation and the trace of their caller, or start new ones,
+	//and are counted and timed.
+	r.Use(logging.Middleware, tracing.Middleware, metrics.Middleware)
+	//Prometheus scrapes the metrics of the service from here:
+	r.Methods("GET").Path("/metrics").Handler(metrics.Handler())
+	//The orchestrator asks whether the service is alive and ready to take requests here:
+	r.Methods("GET").Path("/healthz").HandlerFunc(checker.LivenessHandler)
+	r.Methods("GET").Path("/readyz").HandlerFunc(checker.ReadinessHandler)
+	handler := &notificationServiceHandler{dbhandler: databaseHandler}
+	//Every request is handled by a copy of the handler that is bound to the trace of the request.
+	route := func(handle func(*notificationServiceHandler, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			handle(handler.withContext(r.Context()), w, r)
+		}
+	}
+
+	usersrouter := r.PathPrefix("/users").Subrouter()
+	//Here we implement the notification preferences of a user (/users/{userID}/preferences):
+	usersrouter.Methods("GET").Path("/{userID}/preferences").HandlerFunc(route((*notificationServiceHandler).findPreferencesHandler))
+	usersrouter.Methods("PUT").Path("/{userID}/preferences").HandlerFunc(route((*notificationServiceHandler).updatePreferencesHandler))
+	//Here we implement the record of the notifications of a user (/users/{userID}/notifications):
+	usersrouter.Methods("GET").Path("/{userID}/notifications").HandlerFunc(route((*notificationServiceHandler).notificationsHandler))
+
+	httpErrChan := make(chan error)
+	httpIsErrChan := make(chan error)
+
+	server := handlers.CORS()(r)
+	httpServer := &http.Server{Addr: endpoint, Handler: server}
+	httpsServer := &http.Server{Addr: tlsendpoint, Handler: server}
+	go func() {
+		if err := httpsServer.ListenAndServeTLS("cert.pem", "key.pem"); err != http.ErrServerClosed {
+			httpIsErrChan <- err
+		}
+	}()
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			httpErrChan <- err
+		}
+	}()
+	//When the service is asked to stop, it finishes the requests in flight before it exits.
+	go checker.ShutdownOnSignal(httpServer, httpsServer)
+
+	return httpErrChan, httpIsErrChan
+}
+
+func main() {
+	confPath := flag.String("config", "./notifications-config.json", "path to config file")
+	flag.Parse()
+	config, err := configuration.ExtractConfiguration(*confPath)
+	if err != nil {
+		slog.Warn("could not read the configuration", "error", err)
+	}
+	if err := logging.Init("notifications", config.LogFormat, config.LogLevel); err != nil {
+		logging.Fatal("could not set up logging", "error", err)
+	}
+	if err := tracing.Init("notifications", config.TracingExporter, config.TracingEndpoint); err != nil {
+		logging.Fatal("could not set up tracing", "error", err)
+	}
+
+	configMap := make(map[string]interface{})
+	configMap["connection"] = config.DBConnection
+	configMap["region"] = config.AWSRegion
+	dbhandler, err := dblayer.NewPersistenceLayer(config.Databasetype, configMap)
+	if err != nil {
+		slog.Error("could not connect to the database", "error", err)
+	} else {
+		slog.Info("connected to the database")
+	}
+
+	conn := msgqueue_amqp.NewAMQPConnection(config.AMQPMessageBroker)
+	eventListener, err := msgqueue_amqp.NewAMQPEventListener(conn, "myevents", "notifications")
+	if err != nil {
+		slog.Error("could not create the event listener", "error", err)
+	}
+	codec, err := msgqueue.NewCodec(config.MessageCodec)
+	if err != nil {
+		logging.Fatal("could not create the message codec", "error", err)
+	}
+	eventEmitter, err := msgqueue_amqp.NewAMQPEventEmitter(conn, "myevents", codec)
+	if err != nil {
+		slog.Error("could not create the event emitter", "error", err)
+	}
+	//Every contract we emit is recorded in the event store as well, so that projections can be rebuilt.
+	eventStore, err := eventstore.NewEventStore(config.Databasetype, map[string]interface{}{
+		"connection": config.EventStoreConnection,
+		"region":     config.AWSRegion,
+	})
+	if err != nil {
+		slog.Error("could not connect to the event store", "error", err)
+	} else {
+		eventEmitter = &eventstore.RecordingEmitter{Store: eventStore, Emitter: eventEmitter}
+	}
+
+	templates, err := notify.LoadTemplates(config.NotificationTemplates)
+	if err != nil {
+		logging.Fatal("could not load the notification templates", "error", err)
+	}
+
+	n := &notifier.Notifier{
+		Database:  dbhandler,
+		Templates: templates,
+		Sender: &notify.SMTPSender{
+			Addr:     config.SMTPServer,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.NotificationSender,
+		},
+		MaxAttempts: config.NotificationMaxAttempts,
+		RetryDelay:  time.Duration(config.NotificationRetrySeconds) * time.Second,
+	}
+	//Notifications that could not be sent right away are retried from here.
+	go n.Run(time.Duration(config.NotificationRetrySeconds) * time.Second / 2)
+
+	//Reminders are scheduled jobs in the database. Every replica runs the scheduler; a job is
+	//leased before it runs, so each reminder is emitted by one replica only.
+	sched := scheduler.New(dbhandler)
+	r := reminders.New(sched, eventEmitter, config.ReminderLeadHours)
+	go sched.Run(time.Duration(config.SchedulerSeconds) * time.Second)
+
+	//The orchestrator learns from these checks whether the service is ready to take requests.
+	checker := health.NewChecker()
+	checker.DrainDelay = time.Duration(config.ShutdownDrainSeconds) * time.Second
+	checker.AddReadiness("database", func() error { return persistence.Ping(dbhandler) })
+	checker.AddReadiness("broker", conn.Check)
+
+	processor := &listener.EventProcessor{EventListener: eventListener, Database: dbhandler, Notifier: n, Reminders: r}
+	//Without its listener, the service would never learn about the events of the others.
+	checker.Go("listener", processor.ProcessEvents)
+
+	httpErrChan, httpIsErrChan := ServeAPI(config.RestfulEndpoint, config.RestfulTLSEndpoint, dbhandler, checker)
+	slog.Info("listening for http connections", "endpoint", config.RestfulEndpoint, "tls_endpoint", config.RestfulTLSEndpoint)
+
+	select {
+	case err := <-httpErrChan:
+		logging.Fatal("http server failed", "error", err)
+	case err := <-httpIsErrChan:
+		logging.Fatal("https server failed", "error", err)
+	case <-checker.Stopped():
+		tracing.Flush()
+		slog.Info("stopped")
+	}
+}
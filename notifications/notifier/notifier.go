@@ -0,0 +1,180 @@
+package notifier
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/notify"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//claimDuration is how long a sender has to send a notification it claimed. If the sender dies
+//while sending, the notification is picked up again once the claim runs out.
+const claimDuration = 5 * time.Minute
+
+//maxRetryDelay caps the delay between two attempts to send a notification.
+const maxRetryDelay = time.Hour
+
+var ErrUnknownRecipient = errors.New("no notification preferences for this user")
+
+//Data is what the templates of a notification can use.
+type Data struct {
+	Name    string //of the recipient
+	Event   Event
+	Booking Booking
+	Reason  string //why an event was cancelled, if the organizer gave a reason
+	Hours   int    //how many hours are left until the event starts, for reminders
+}
+
+type Event struct {
+	Name  string
+	Venue string
+	Start time.Time //in the time zone of the venue
+	End   time.Time
+}
+
+type Booking struct {
+	Seats      int
+	SeatIDs    []string
+	TicketType string
+	Total      int64 //in minor units of Currency
+	Currency   string
+}
+
+//Notifier turns what happens to users, bookings and events into emails. Every notification is
+//stored before it is sent and retried until it was sent, so an email is neither lost when the mail
+//server is down nor sent twice when the message that caused it is delivered twice:
+//
+//   - the ID of a notification is derived from the message that caused it, and a notification is
+//     only stored once,
+//   - a sender claims a notification in the database before sending it, so no two senders send the
+//     same notification at the same time,
+//   - a notification is marked as sent right after it was sent.
+//
+//Only a sender that dies between sending and marking the notification as sent can cause a second
+//email. Both carry the same Message-ID, which lets mail clients drop the second one.
+type Notifier struct {
+	Database    persistence.DatabaseHandler
+	Templates   *notify.Templates
+	Sender      notify.Sender
+	MaxAttempts int           //a notification that failed this often is given up
+	RetryDelay  time.Duration //delay after the first failed attempt, doubles with every further one
+}
+
+//Notify renders a notification of the given kind for a user and sends it, unless the user turned
+//notifications of that kind off. The key identifies the notification; a notification with a key
+//that was used before is dropped.
+func (n *Notifier) Notify(key string, userID string, kind string, data Data) error {
+	prefs, err := n.Database.FindNotificationPreferences([]byte(userID))
+	if err != nil {
+		return ErrUnknownRecipient
+	}
+	if !prefs.Wants(kind) {
+		return nil
+	}
+	data.Name = prefs.Name
+	msg, err := n.Templates.Render(kind, prefs.Locale, data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	notification := persistence.Notification{
+		ID:          key,
+		UserID:      userID,
+		Kind:        kind,
+		Email:       prefs.Email,
+		Subject:     msg.Subject,
+		Text:        msg.Text,
+		HTML:        msg.HTML,
+		Status:      persistence.NotificationPending,
+		NextAttempt: now.Unix(),
+		CreatedAt:   now.Unix(),
+	}
+	err = n.Database.AddNotification(notification)
+	if err == persistence.ErrNotificationExists {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	//We don't wait for the next round of the dispatcher to send a new notification. If sending
+	//fails, the dispatcher retries it later.
+	n.deliver(notification, now)
+	return nil
+}
+
+//Dispatch sends every notification that is due at the given time.
+func (n *Notifier) Dispatch(now time.Time) error {
+	due, err := n.Database.FindDueNotifications(now.Unix())
+	if err != nil {
+		return err
+	}
+	for _, notification := range due {
+		n.deliver(notification, now)
+	}
+	return nil
+}
+
+//Run runs Dispatch every interval. It never returns, so it should be started in its own goroutine.
+func (n *Notifier) Run(interval time.Duration) {
+	slog.Info("sending due notifications", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := n.Dispatch(now); err != nil {
+			slog.Error("error while sending due notifications", "error", err)
+		}
+	}
+}
+
+//deliver claims a notification, sends it and records the outcome.
+func (n *Notifier) deliver(notification persistence.Notification, now time.Time) {
+	err := n.Database.ClaimNotification(notification.ID, now.Unix(), now.Add(claimDuration).Unix())
+	if err == persistence.ErrNotificationClaimed {
+		return
+	}
+	if err != nil {
+		slog.Error("could not claim notification", "notification", notification.ID, "error", err)
+		return
+	}
+
+	err = n.Sender.Send(notify.Email{
+		To:        notification.Email,
+		Subject:   notification.Subject,
+		Text:      notification.Text,
+		HTML:      notification.HTML,
+		MessageID: notification.ID + "@notifications.myevents",
+	})
+	notification.Attempts++
+	if err == nil {
+		notification.Status = persistence.NotificationSent
+		notification.SentAt = time.Now().Unix()
+		notification.LastError = ""
+	} else {
+		notification.LastError = err.Error()
+		if notification.Attempts >= n.MaxAttempts {
+			notification.Status = persistence.NotificationFailed
+			slog.Error("giving up on notification", "notification", notification.ID, "attempts", notification.Attempts, "error", err)
+		} else {
+			notification.NextAttempt = now.Add(n.retryDelay(notification.Attempts)).Unix()
+			slog.Warn("could not send notification, retrying", "notification", notification.ID, "retry_at", time.Unix(notification.NextAttempt, 0), "error", err)
+		}
+	}
+	if err := n.Database.UpdateNotification(notification); err != nil {
+		slog.Error("could not store the outcome of notification", "notification", notification.ID, "error", err)
+	}
+}
+
+//retryDelay is the delay after the given number of failed attempts.
+func (n *Notifier) retryDelay(attempts int) time.Duration {
+	delay := n.RetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,145 @@
+//Package reminders schedules the reminders attendees get before an event they booked. Every
+//booking gets one job per lead time; when a job is due, a reminder.due message is emitted, and the
+//notifications service turns it into an email.
+package reminders
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/contracts"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/scheduler"
+)
+
+//JobKind is the kind of the scheduled jobs that send reminders.
+const JobKind = "reminder"
+
+//payload is what a reminder job needs to emit its message.
+type payload struct {
+	EventID   string `json:"eventId"`
+	UserID    string `json:"userId"`
+	BookingID string `json:"bookingId"`
+	Start     int64  `json:"start"`
+	LeadHours int    `json:"leadHours"`
+}
+
+type Reminders struct {
+	Scheduler    *scheduler.Scheduler
+	EventEmitter msgqueue.EventEmitter
+	LeadHours    []int //attendees are reminded this many hours before the event starts
+}
+
+//New returns the reminders and registers their job handler with the scheduler.
+func New(s *scheduler.Scheduler, emitter msgqueue.EventEmitter, leadHours []int) *Reminders {
+	r := &Reminders{Scheduler: s, EventEmitter: emitter, LeadHours: leadHours}
+	s.Handle(JobKind, r.emit)
+	return r
+}
+
+//Schedule schedules the reminders of a booking. Reminders that would be due already, like the
+//24 hour reminder of a booking made on the day of the event, are left out.
+func (r *Reminders) Schedule(bookingID string, eventID string, userID string, start time.Time) error {
+	now := time.Now()
+	for _, hours := range r.LeadHours {
+		runAt := start.Add(-time.Duration(hours) * time.Hour)
+		if !runAt.After(now) {
+			continue
+		}
+		err := r.Scheduler.Schedule(jobID(bookingID, hours), JobKind, eventID, runAt, payload{
+			EventID:   eventID,
+			UserID:    userID,
+			BookingID: bookingID,
+			Start:     start.Unix(),
+			LeadHours: hours,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//EventMoved moves the pending reminders of an event to its new start. Reminders that would be due
+//already at the new start are cancelled.
+func (r *Reminders) EventMoved(eventID string, start time.Time) error {
+	jobs, err := r.Scheduler.Pending(eventID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, job := range jobs {
+		var p payload
+		if err := scheduler.Decode(job, &p); err != nil {
+			return err
+		}
+		if p.Start == start.Unix() {
+			continue
+		}
+		runAt := start.Add(-time.Duration(p.LeadHours) * time.Hour)
+		if !runAt.After(now) {
+			err = r.Scheduler.Cancel(job.ID)
+		} else {
+			p.Start = start.Unix()
+			job.Payload, err = encode(p)
+			if err == nil {
+				err = r.Scheduler.Reschedule(job, runAt)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//CancelBooking cancels the reminders of a booking.
+func (r *Reminders) CancelBooking(bookingID string) error {
+	for _, hours := range r.LeadHours {
+		if err := r.Scheduler.Cancel(jobID(bookingID, hours)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//CancelEvent cancels the reminders of every booking of an event.
+func (r *Reminders) CancelEvent(eventID string) error {
+	jobs, err := r.Scheduler.Pending(eventID)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if err := r.Scheduler.Cancel(job.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//emit is the job handler. It emits the reminder.due message of a due reminder job.
+func (r *Reminders) emit(job persistence.ScheduledJob) error {
+	var p payload
+	if err := scheduler.Decode(job, &p); err != nil {
+		return err
+	}
+	return r.EventEmitter.Emit(&contracts.ReminderDueEvent{
+		ID:        job.ID,
+		EventID:   p.EventID,
+		UserID:    p.UserID,
+		BookingID: p.BookingID,
+		Start:     time.Unix(p.Start, 0).UTC(),
+		LeadHours: p.LeadHours,
+	})
+}
+
+func jobID(bookingID string, hours int) string {
+	return fmt.Sprintf("reminder/%s/%dh", bookingID, hours)
+}
+
+func encode(p payload) (string, error) {
+	encoded, err := json.Marshal(p)
+	return string(encoded), err
+}
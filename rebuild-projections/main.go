@@ -0,0 +1,86 @@
+//rebuild-projections replays the event store through the event handlers of a service into a fresh
+//database. The projections a service builds from the contracts of the other services, like the
+//events the bookings service knows about, can be rebuilt this way after a bug in a handler was
+//fixed. Data a service owns itself, like the bookings of the bookings service, is not a projection
+//and has to be carried over separately.
+//
+//	rebuild-projections -service bookings -config ./bookings/bookings-config.json -target mongodb://127.0.0.1:5757
+//
+//The service is then pointed at the new database.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	bookings_listener "github.com/doublen987/web_dev/MyEvents/bookings/listener"
+	events_listener "github.com/doublen987/web_dev/MyEvents/events/listener"
+	"github.com/doublen987/web_dev/MyEvents/lib/configuration"
+	"github.com/doublen987/web_dev/MyEvents/lib/eventstore"
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/dblayer"
+	users_listener "github.com/doublen987/web_dev/MyEvents/users/listener"
+)
+
+//handlers returns the event handler of a service, writing into the given database.
+func handlers(service string, dbhandler persistence.DatabaseHandler) (func(msgqueue.Event), error) {
+	switch service {
+	case "bookings":
+		processor := &bookings_listener.EventProcessor{Database: dbhandler, Replay: true}
+		return processor.HandleEvent, nil
+	case "events":
+		processor := &events_listener.EventProcessor{Database: dbhandler, Replay: true}
+		return processor.HandleEvent, nil
+	case "users":
+		processor := &users_listener.EventProcessor{Database: dbhandler}
+		return processor.HandleEvent, nil
+	}
+	return nil, fmt.Errorf("cannot rebuild the projections of service %s", service)
+}
+
+func main() {
+	service := flag.String("service", "", "service whose projections are rebuilt: bookings, events or users")
+	confPath := flag.String("config", "", "path to the config file of the service")
+	target := flag.String("target", "", "connection string of the fresh database (region for dynamodb)")
+	batch := flag.Int("batch", 500, "number of records loaded from the event store at once")
+	flag.Parse()
+
+	config, err := configuration.ExtractConfiguration(*confPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *target == "" || *target == config.DBConnection {
+		log.Fatal("the projections have to be rebuilt into a fresh database, pass it with -target")
+	}
+
+	eventStore, err := eventstore.NewEventStore(config.Databasetype, map[string]interface{}{
+		"connection": config.EventStoreConnection,
+		"region":     config.AWSRegion,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	dbhandler, err := dblayer.NewPersistenceLayer(config.Databasetype, map[string]interface{}{
+		"connection": *target,
+		"region":     *target,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	handle, err := handlers(*service, dbhandler)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handled := 0
+	last, err := eventstore.Replay(eventStore, msgqueue.NewEventMapper(), 0, *batch, func(event msgqueue.Event) {
+		handle(event)
+		handled++
+	})
+	if err != nil {
+		log.Fatalf("replay stopped after record %d: %s", last, err)
+	}
+	fmt.Printf("Replayed %d events up to record %d into %s\n", handled, last, *target)
+}
@@ -1,38 +1,59 @@
 package listener
 
 import (
+	"context"
 	"encoding/hex"
-	"log"
+	"log/slog"
 
 	"github.com/doublen987/web_dev/MyEvents/contracts"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 )
 
 type EventProcessor struct {
 	EventListener msgqueue.EventListener
 	Database      persistence.DatabaseHandler
+
+	ctx context.Context //the delivery being handled, see withContext
 }
 
 //Here we listen for newly created events
 func (p *EventProcessor) ProcessEvents() error {
-	log.Println("Listening to events...")
-	received, errors, err := p.EventListener.Listen("event.created", "event.update", "booking.created", "booking.remove")
+	slog.Info("listening to events")
+	deliveries, errors, err := p.EventListener.ListenContext("event.created", "event.update", "booking.created", "event.booked", "booking.remove")
 	if err != nil {
 		return err
 	}
 	for {
 		select {
-		case evt := <-received:
-			//Received events will be passed to the handleEvent function
-			p.handleEvent(evt)
+		case delivery := <-deliveries:
+			//Received events will be passed to the HandleEvent function
+			p.process(delivery)
 		case err = <-errors:
-			log.Printf("received error while processing msg: %s", err)
+			slog.Error("could not receive event", "error", err)
 		}
 	}
 }
 
-func (p *EventProcessor) handleEvent(event msgqueue.Event) {
+//process handles a delivered event in a consumer span, which continues the trace of its producer.
+func (p *EventProcessor) process(delivery msgqueue.Delivery) {
+	ctx, span := tracing.Start(delivery.Context, "process "+delivery.Event.EventName(), tracing.Consumer)
+	defer span.Finish()
+	p.withContext(ctx).HandleEvent(delivery.Event)
+}
+
+//withContext returns a processor whose database calls are part of the trace the context carries.
+func (p *EventProcessor) withContext(ctx context.Context) *EventProcessor {
+	bound := *p
+	bound.ctx = ctx
+	bound.Database = persistence.WithContext(ctx, p.Database)
+	return &bound
+}
+
+//HandleEvent stores a received event in the projections of the service. It is also used to
+//rebuild the projections from the event store.
+func (p *EventProcessor) HandleEvent(event msgqueue.Event) {
 	//The function uses a type switch to determine the type of the incoming event. Then we store the events
 	//in the local database. In this example, we are using a shared library github.com/doublen987/MyEvents/lib/persistence
 	//for managing database access. This is for convenience only. In real microservice architectures,
@@ -40,22 +61,29 @@ func (p *EventProcessor) handleEvent(event msgqueue.Event) {
 	//built on completely different technology stacks.
 	switch e := event.(type) {
 	case *contracts.EventCreatedEvent:
-		log.Printf("event %s created: %s", e.ID, e)
+		slog.InfoContext(p.ctx, "event created", "event", e.ID)
 		p.Database.AddEvent(persistence.Event{ID: e.ID})
 	case *contracts.LocationCreatedEvent:
-		log.Printf("location %s created: %s", e.ID, e)
+		slog.InfoContext(p.ctx, "location created", "location", e.ID)
 		//p.Database.AddLocation(persistence.Location{ID: e.ID})
 	case *contracts.EventBookedEvent:
-		log.Printf("booking %s created: %s", e.ID, e)
+		slog.InfoContext(p.ctx, "booking created", "booking", e.ID, "event", e.EventID)
 		bookingUserID, _ := hex.DecodeString(e.UserID)
 		bookingEventID, _ := hex.DecodeString(e.EventID)
 		p.Database.AddBookingForUser(bookingUserID, persistence.Booking{
-			ID:      e.ID,
-			Date:    e.Date,
-			EventID: string(bookingEventID),
-			Seats:   e.Seats,
+			ID:         e.ID,
+			Date:       e.Date,
+			EventID:    string(bookingEventID),
+			Seats:      e.Seats,
+			SeatIDs:    e.SeatIDs,
+			TicketType: e.TicketType,
+			UnitPrice:  e.UnitPrice,
+			PromoCode:  e.PromoCode,
+			Discount:   e.Discount,
+			Total:      e.Total,
+			Currency:   e.Currency,
 		})
 	default:
-		log.Printf("unknown event: %t", e)
+		slog.WarnContext(p.ctx, "unknown event", "event", event.EventName())
 	}
 }
@@ -1,24 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
-	"encoding/json"
 	"flag"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	"github.com/streadway/amqp"
 
 	"github.com/doublen987/web_dev/MyEvents/contracts"
 	"github.com/doublen987/web_dev/MyEvents/lib/configuration"
+	"github.com/doublen987/web_dev/MyEvents/lib/eventstore"
+	"github.com/doublen987/web_dev/MyEvents/lib/health"
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
 	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
 	msgqueue_amqp "github.com/doublen987/web_dev/MyEvents/lib/msgqueue/amqp"
+	"github.com/doublen987/web_dev/MyEvents/lib/openapi"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
 	"github.com/doublen987/web_dev/MyEvents/lib/persistence/dblayer"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
 	"github.com/doublen987/web_dev/MyEvents/users/listener"
 )
 
@@ -34,93 +40,109 @@ func newUserHandler(databaseHandler persistence.DatabaseHandler, eventEmitter ms
 	}
 }
 
+//withContext returns a handler whose database calls and emitted events are part of the trace
+//the context carries.
+func (eh *userServiceHandler) withContext(ctx context.Context) *userServiceHandler {
+	bound := *eh
+	bound.dbhandler = persistence.WithContext(ctx, eh.dbhandler)
+	bound.eventEmitter = msgqueue.WithContext(ctx, eh.eventEmitter)
+	return &bound
+}
+
 func (eh *userServiceHandler) findUserHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	criteria, ok := vars["SearchCriteria"]
-	if !ok {
-		w.WriteHeader(400)
-		fmt.Fprint(w, `{error: No search criteria found, you can either search by id via /id/4 to 
-			search by name via /name/mycoolusername}`)
-		return
-	}
-	searchkey, ok := vars["search"]
-	if !ok {
-		w.WriteHeader(400)
-		fmt.Fprint(w, `{error: No search keys found, you can either search by id via /id/4 to
-			search by name via /name/mycoolusername}`)
-		return
-	}
+	criteria := vars["SearchCriteria"]
+	searchkey := vars["search"]
 	var user persistence.User
 	var err error
 	switch strings.ToLower(criteria) {
 	case "name":
 		user, err = eh.dbhandler.FindUserByName(searchkey)
-		break
 	case "id":
-		id, err := hex.DecodeString(searchkey)
-		if err == nil {
-			user, err = eh.dbhandler.FindUserById(id)
-			fmt.Println(user)
-		} else {
-			fmt.Println(err)
+		id, decodeErr := hex.DecodeString(searchkey)
+		if decodeErr != nil {
+			httpapi.Errorf(w, r, http.StatusBadRequest, "malformed user id %s", searchkey)
+			return
 		}
+		user, err = eh.dbhandler.FindUserById(id)
+	default:
+		httpapi.Errorf(w, r, http.StatusBadRequest, "unknown search criteria %s, you can either search by id via /id/4 or by name via /name/mycoolusername", criteria)
+		return
 	}
 	if err != nil {
-		fmt.Fprintf(w, "{error %s}", err)
+		httpapi.Errorf(w, r, http.StatusNotFound, "no user with %s %s", strings.ToLower(criteria), searchkey)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json;charset=utf8")
-	json.NewEncoder(w).Encode(&user)
+	httpapi.JSON(w, http.StatusOK, &user)
 }
 
 func (eh *userServiceHandler) findAllUsersHandler(w http.ResponseWriter, r *http.Request) {
 	users, err := eh.dbhandler.FindAllUsers()
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"error": "Error occured while trying to find all available users %s"}`, err)
+		httpapi.InternalError(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json;charset=utf8")
-	err = json.NewEncoder(w).Encode(&users)
-	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"error": "Error occured while trying encode users to JSON %s"}`, err)
-	}
+	httpapi.JSON(w, http.StatusOK, &users)
+}
+
+//newUserRequest is the body of a request to sign up a user.
+type newUserRequest struct {
+	First    string `json:"first" validate:"required,max=100"`
+	Last     string `json:"last" validate:"required,max=100"`
+	Age      int    `json:"age" validate:"min=0,max=150"`
+	Email    string `json:"email" validate:"required,format=email"`
+	Username string `json:"username" validate:"max=100"`
 }
 
 func (eh *userServiceHandler) newUserHandler(w http.ResponseWriter, r *http.Request) {
-	user := persistence.User{}
-	err := json.NewDecoder(r.Body).Decode(&user)
-	if nil != err {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"error": "error occured while decoding user data %s"}`, err)
+	request := newUserRequest{}
+	if err := httpapi.Decode(w, r, &request); err != nil {
+		httpapi.WriteError(w, r, err)
 		return
 	}
+	user := persistence.User{
+		First:    request.First,
+		Last:     request.Last,
+		Age:      request.Age,
+		Email:    request.Email,
+		Username: request.Username,
+	}
 	id, err := eh.dbhandler.AddUser(user)
 	if nil != err {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"error": "error occured while persisting user %d %s"}`, id, err)
+		httpapi.InternalError(w, r, err)
 		return
 	}
 
 	msg := contracts.UserCreatedEvent{
 		ID:    hex.EncodeToString(id),
 		First: user.First,
-		Last:  user.First,
+		Last:  user.Last,
 		Age:   user.Age,
+		Email: user.Email,
 	}
 	eh.eventEmitter.Emit(&msg)
 
-	w.Header().Set("Content-Type", "application/json;charset=utf8")
-
-	w.WriteHeader(201)
-	json.NewEncoder(w).Encode(&user)
+	httpapi.JSON(w, http.StatusCreated, &user)
 }
 
-func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.DatabaseHandler, eventEmitter msgqueue.EventEmitter) (chan error, chan error) {
+//newRouter routes the requests to the service to the handler. Every route is described by apiSpec.
+func newRouter(handler *userServiceHandler, checker *health.Checker) *mux.Router {
 	//With this we get a router object called r, to help  us define our routes and link them
 	//with actions to execute:
 	r := mux.NewRouter()
+	//Requests get an ID, continue the correlation and the trace of their caller, or start new ones,
+	//and are counted and timed.
+	r.Use(logging.Middleware, tracing.Middleware, metrics.Middleware)
+	//Requests the routes don't match are answered with problems, like the errors of the handlers.
+	r.NotFoundHandler = http.HandlerFunc(httpapi.NotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(httpapi.MethodNotAllowed)
+	//Prometheus scrapes the metrics of the service from here:
+	r.Methods("GET").Path("/metrics").Handler(metrics.Handler())
+	//The orchestrator asks whether the service is alive and ready to take requests here:
+	r.Methods("GET").Path("/healthz").HandlerFunc(checker.LivenessHandler)
+	r.Methods("GET").Path("/readyz").HandlerFunc(checker.ReadinessHandler)
+	//Clients look up the routes of the service, and the bodies they take and return, here:
+	r.Methods("GET").Path("/openapi.json").Handler(openapi.Handler(apiSpec()))
 	//A subrouter is basically an object that will in charge of any incoming HTTP request
 	//directed towards a relative URL that starts with /events. This code makes use of the
 	//router object we created earlier, then calls the PathPrefix method, which is used to
@@ -128,12 +150,22 @@ func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.D
 	//The eventsrouter can be used to define what to do with the rest of the URLs that share
 	//the /events prefix.
 
-	handler := newUserHandler(databaseHandler, eventEmitter)
+	//Every request is handled by a copy of the handler that is bound to the trace of the request.
+	route := func(handle func(*userServiceHandler, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			handle(handler.withContext(r.Context()), w, r)
+		}
+	}
 
 	usersrouter := r.PathPrefix("/users").Subrouter()
-	usersrouter.Methods("GET").Path("/{SearchCriteria}/{search}").HandlerFunc(handler.findUserHandler)
-	usersrouter.Methods("GET").Path("").HandlerFunc(handler.findAllUsersHandler)
-	usersrouter.Methods("POST").Path("").HandlerFunc(handler.newUserHandler)
+	usersrouter.Methods("GET").Path("/{SearchCriteria}/{search}").HandlerFunc(route((*userServiceHandler).findUserHandler))
+	usersrouter.Methods("GET").Path("").HandlerFunc(route((*userServiceHandler).findAllUsersHandler))
+	usersrouter.Methods("POST").Path("").HandlerFunc(route((*userServiceHandler).newUserHandler))
+	return r
+}
+
+func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.DatabaseHandler, eventEmitter msgqueue.EventEmitter, checker *health.Checker) (chan error, chan error) {
+	r := newRouter(newUserHandler(databaseHandler, eventEmitter), checker)
 
 	//We use go channels to handle error correcting
 	httpErrChan := make(chan error)
@@ -148,8 +180,20 @@ func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.D
 	//ListenAndServe() and ListenAndServeTLS, but because they are both blocking functions, one
 	//cannot be listening while the other is listening so we have to make separate goroutins for them.
 	server := handlers.CORS()(r)
-	go func() { httpIsErrChan <- http.ListenAndServeTLS(tlsendpoint, "cert.pem", "key.pem", server) }()
-	go func() { httpErrChan <- http.ListenAndServe(endpoint, server) }()
+	httpServer := &http.Server{Addr: endpoint, Handler: server}
+	httpsServer := &http.Server{Addr: tlsendpoint, Handler: server}
+	go func() {
+		if err := httpsServer.ListenAndServeTLS("cert.pem", "key.pem"); err != http.ErrServerClosed {
+			httpIsErrChan <- err
+		}
+	}()
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			httpErrChan <- err
+		}
+	}()
+	//When the service is asked to stop, it finishes the requests in flight before it exits.
+	go checker.ShutdownOnSignal(httpServer, httpsServer)
 
 	return httpErrChan, httpIsErrChan
 }
@@ -162,26 +206,40 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	if err := logging.Init("users", config.LogFormat, config.LogLevel); err != nil {
+		panic(err)
+	}
+	if err := tracing.Init("users", config.TracingExporter, config.TracingEndpoint); err != nil {
+		panic(err)
+	}
 
-	fmt.Printf("Connecting to the AMQP message broker: %s\n", config.AMQPMessageBroker)
-	conn, err := amqp.Dial(config.AMQPMessageBroker)
+	slog.Info("connecting to the AMQP message broker")
+	conn := msgqueue_amqp.NewAMQPConnection(config.AMQPMessageBroker)
+
+	codec, err := msgqueue.NewCodec(config.MessageCodec)
 	if err != nil {
 		panic(err)
-	} else {
-		fmt.Printf("Connected to the AMQP message broker: %s\n", config.AMQPMessageBroker)
 	}
-
-	emitter, err := msgqueue_amqp.NewAMQPEventEmitter(conn, "myevents")
+	emitter, err := msgqueue_amqp.NewAMQPEventEmitter(conn, "myevents", codec)
 	if err != nil {
 		panic(err)
 	}
+	//Every contract we emit is recorded in the event store as well, so that projections can be rebuilt.
+	eventStore, err := eventstore.NewEventStore(config.Databasetype, map[string]interface{}{
+		"connection": config.EventStoreConnection,
+		"region":     config.AWSRegion,
+	})
+	if err != nil {
+		panic(err)
+	}
+	emitter = &eventstore.RecordingEmitter{Store: eventStore, Emitter: emitter}
 
 	eventListener, err := msgqueue_amqp.NewAMQPEventListener(conn, "myevents", "users")
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("Connecting to database: %s\n", config.DBConnection)
+	slog.Info("connecting to the database", "type", config.Databasetype)
 	configMap := make(map[string]interface{})
 	configMap["connection"] = config.DBConnection
 	configMap["region"] = config.AWSRegion
@@ -189,19 +247,29 @@ func main() {
 	if err != nil {
 		panic(err)
 	} else {
-		fmt.Printf("Connected to the database: %s\n", config.DBConnection)
+		slog.Info("connected to the database")
 	}
 
-	processor := &listener.EventProcessor{eventListener, dbhandler}
-	go processor.ProcessEvents()
+	//The orchestrator learns from these checks whether the service is ready to take requests.
+	checker := health.NewChecker()
+	checker.DrainDelay = time.Duration(config.ShutdownDrainSeconds) * time.Second
+	checker.AddReadiness("database", func() error { return persistence.Ping(dbhandler) })
+	checker.AddReadiness("broker", conn.Check)
+
+	processor := &listener.EventProcessor{EventListener: eventListener, Database: dbhandler}
+	//Without its listener, the service would never learn about the events of the others.
+	checker.Go("listener", processor.ProcessEvents)
 
-	httpErrChan, httpIsErrChan := ServeAPI(config.RestfulEndpoint, config.RestfulTLSEndpoint, dbhandler, emitter)
-	fmt.Printf("Started listening for http connections on: %s\n", config.RestfulEndpoint)
+	httpErrChan, httpIsErrChan := ServeAPI(config.RestfulEndpoint, config.RestfulTLSEndpoint, dbhandler, emitter, checker)
+	slog.Info("listening for http connections", "endpoint", config.RestfulEndpoint, "tls_endpoint", config.RestfulTLSEndpoint)
 
 	select {
 	case err := <-httpErrChan:
-		log.Fatal("HTTP Error: ", err)
+		logging.Fatal("http server failed", "error", err)
 	case err := <-httpIsErrChan:
-		log.Fatal("HTTPS Error: ", err)
+		logging.Fatal("https server failed", "error", err)
+	case <-checker.Stopped():
+		tracing.Flush()
+		slog.Info("stopped")
 	}
 }
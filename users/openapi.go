@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/openapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+)
+
+//apiSpec describes the REST API of the users service.
+func apiSpec() *openapi.Document {
+	d := openapi.New("MyEvents users", "1.0.0")
+	d.AddStandard()
+	d.Add("GET", "/users/{SearchCriteria}/{search}", "findUser", "Finds a user by id or by name, like /users/id/5a5f5c").
+		Tag("users").
+		Returns(http.StatusOK, persistence.User{}).
+		Fails(http.StatusBadRequest, http.StatusNotFound)
+	d.Add("GET", "/users", "listUsers", "Lists every user").
+		Tag("users").
+		Returns(http.StatusOK, []persistence.User{})
+	d.Add("POST", "/users", "createUser", "Signs up a user").
+		Tag("users").
+		Accepts(newUserRequest{}).
+		Returns(http.StatusCreated, persistence.User{})
+	return d
+}
@@ -0,0 +1,41 @@
+package listener
+
+import (
+	"log/slog"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/msgqueue"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
+	"github.com/doublen987/web_dev/MyEvents/lib/webhooks"
+)
+
+type EventProcessor struct {
+	EventListener msgqueue.EventListener
+	Dispatcher    *webhooks.Dispatcher
+}
+
+//Here we listen for every event partners can subscribe to
+func (p *EventProcessor) ProcessEvents() error {
+	slog.Info("listening to events")
+	deliveries, errors, err := p.EventListener.ListenContext(webhooks.PublicEvents...)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case delivery := <-deliveries:
+			p.publish(delivery)
+		case err = <-errors:
+			slog.Error("could not receive event", "error", err)
+		}
+	}
+}
+
+//publish queues an event for the subscribed partners in a consumer span of the trace it was emitted in.
+func (p *EventProcessor) publish(delivery msgqueue.Delivery) {
+	ctx, span := tracing.Start(delivery.Context, "process "+delivery.Event.EventName(), tracing.Consumer)
+	defer span.Finish()
+	if err := p.Dispatcher.Publish(delivery.Event); err != nil {
+		span.SetError(err)
+		slog.ErrorContext(ctx, "could not publish event to webhooks", "event", delivery.Event.EventName(), "error", err)
+	}
+}
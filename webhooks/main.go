@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/doublen987/web_dev/MyEvents/lib/configuration"
+	"github.com/doublen987/web_dev/MyEvents/lib/health"
+	"github.com/doublen987/web_dev/MyEvents/lib/httpapi"
+	"github.com/doublen987/web_dev/MyEvents/lib/logging"
+	"github.com/doublen987/web_dev/MyEvents/lib/metrics"
+	msgqueue_amqp "github.com/doublen987/web_dev/MyEvents/lib/msgqueue/amqp"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence"
+	"github.com/doublen987/web_dev/MyEvents/lib/persistence/dblayer"
+	"github.com/doublen987/web_dev/MyEvents/lib/tracing"
+	"github.com/doublen987/web_dev/MyEvents/lib/webhooks"
+	"github.com/doublen987/web_dev/MyEvents/webhooks/listener"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+)
+
+type webhookServiceHandler struct {
+	dbhandler persistence.DatabaseHandler
+}
+
+//withContext returns a handler whose database calls are part of the trace the context carries.
+func (wh *webhookServiceHandler) withContext(ctx context.Context) *webhookServiceHandler {
+	bound := *wh
+	bound.dbhandler = persistence.WithContext(ctx, wh.dbhandler)
+	return &bound
+}
+
+//subscriptionRequest is the body of the requests that create and change subscriptions. Fields that
+//are left out of a change stay as they are.
+type subscriptionRequest struct {
+	URL      *string   `json:"url"`
+	Secret   *string   `json:"secret"`
+	Events   *[]string `json:"events"`
+	Disabled *bool     `json:"disabled"`
+}
+
+//newSubscriptionHandler creates a subscription. Without a secret in the request a random one is
+//generated; the secret is only ever shown in the response to this request.
+func (wh *webhookServiceHandler) newSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	req := subscriptionRequest{}
+	if err := httpapi.Decode(w, r, &req); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+	id, err := webhooks.NewID()
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	sub := persistence.WebhookSubscription{ID: id, CreatedAt: time.Now().Unix()}
+	if req.Secret == nil || *req.Secret == "" {
+		secret, err := webhooks.NewID()
+		if err != nil {
+			httpapi.InternalError(w, r, err)
+			return
+		}
+		req.Secret = &secret
+	}
+	if err := applySubscriptionRequest(&sub, req); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+	if sub.URL == "" {
+		httpapi.Error(w, r, http.StatusUnprocessableEntity, "a subscription needs a url")
+		return
+	}
+	if err := wh.dbhandler.SaveWebhookSubscription(sub); err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	httpapi.JSON(w, http.StatusCreated, &sub)
+}
+
+func (wh *webhookServiceHandler) allSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	subs, err := wh.dbhandler.FindAllWebhookSubscriptions()
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	httpapi.JSON(w, http.StatusOK, &subs)
+}
+
+func (wh *webhookServiceHandler) findSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := wh.findSubscription(w, r)
+	if !ok {
+		return
+	}
+	sub.Secret = ""
+	httpapi.JSON(w, http.StatusOK, &sub)
+}
+
+//updateSubscriptionHandler changes a subscription. Enabling a disabled subscription again starts
+//counting its failures from zero.
+func (wh *webhookServiceHandler) updateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := wh.findSubscription(w, r)
+	if !ok {
+		return
+	}
+	req := subscriptionRequest{}
+	if err := httpapi.Decode(w, r, &req); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+	if err := applySubscriptionRequest(&sub, req); err != nil {
+		httpapi.WriteError(w, r, err)
+		return
+	}
+	if err := wh.dbhandler.SaveWebhookSubscription(sub); err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	sub.Secret = ""
+	httpapi.JSON(w, http.StatusOK, &sub)
+}
+
+func (wh *webhookServiceHandler) deleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := wh.findSubscription(w, r)
+	if !ok {
+		return
+	}
+	if err := wh.dbhandler.RemoveWebhookSubscription([]byte(sub.ID)); err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//deliveriesHandler is the delivery log of a subscription, newest first.
+func (wh *webhookServiceHandler) deliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := wh.findSubscription(w, r)
+	if !ok {
+		return
+	}
+	deliveries, err := wh.dbhandler.FindWebhookDeliveriesBySubscriptionId([]byte(sub.ID))
+	if err != nil {
+		httpapi.InternalError(w, r, err)
+		return
+	}
+	httpapi.JSON(w, http.StatusOK, &deliveries)
+}
+
+func (wh *webhookServiceHandler) findSubscription(w http.ResponseWriter, r *http.Request) (persistence.WebhookSubscription, bool) {
+	id := mux.Vars(r)["subscriptionID"]
+	sub, err := wh.dbhandler.FindWebhookSubscription([]byte(id))
+	if err != nil {
+		httpapi.Errorf(w, r, http.StatusNotFound, "no subscription with id %s", id)
+		return sub, false
+	}
+	return sub, true
+}
+
+//applySubscriptionRequest validates the fields of a request and copies them onto the subscription.
+//Fields that are not valid are returned as a problem.
+func applySubscriptionRequest(sub *persistence.WebhookSubscription, req subscriptionRequest) error {
+	if req.URL != nil {
+		if err := webhooks.CheckEndpoint(*req.URL); err != nil {
+			return httpapi.Problemf(http.StatusUnprocessableEntity, "cannot post to url %s: %v", *req.URL, err)
+		}
+		sub.URL = *req.URL
+	}
+	if req.Events != nil {
+		for _, name := range *req.Events {
+			if !webhooks.IsPublic(name) {
+				return httpapi.Problemf(http.StatusUnprocessableEntity, "cannot subscribe to event %s", name)
+			}
+		}
+		sub.Events = *req.Events
+	}
+	if req.Secret != nil && *req.Secret != "" {
+		sub.Secret = *req.Secret
+	}
+	if req.Disabled != nil {
+		if sub.Disabled && !*req.Disabled {
+			sub.Failures = 0
+		}
+		sub.Disabled = *req.Disabled
+	}
+	return nil
+}
+
+func ServeAPI(endpoint string, tlsendpoint string, databaseHandler persistence.DatabaseHandler, checker *health.Checker) (chan error, chan error) {
+	r := mux.NewRouter()
+	//Requests get an ID, continue the correlation and the trace of their caller, or start new ones,
+	//and are counted and timed.
+	r.Use(logging.Middleware, tracing.Middleware, metrics.Middleware)
+	//Requests that match no route are answered with a problem as well.
+	r.NotFoundHandler = http.HandlerFunc(httpapi.NotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(httpapi.MethodNotAllowed)
+	//Prometheus scrapes the metrics of the service from here:
+	r.Methods("GET").Path("/metrics").Handler(metrics.Handler())
+	//The orchestrator asks whether the service is alive and ready to take requests here:
+	r.Methods("GET").Path("/healthz").HandlerFunc(checker.LivenessHandler)
+	r.Methods("GET").Path("/readyz").HandlerFunc(checker.ReadinessHandler)
+	handler := &webhookServiceHandler{dbhandler: databaseHandler}
+	//Every request is handled by a copy of the handler that is bound to the trace of the request.
+	route := func(handle func(*webhookServiceHandler, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			handle(handler.withContext(r.Context()), w, r)
+		}
+	}
+
+	subsrouter := r.PathPrefix("/subscriptions").Subrouter()
+	//Here we implement the webhook subscriptions of partners (/subscriptions/{subscriptionID}):
+	subsrouter.Methods("POST").Path("").HandlerFunc(route((*webhookServiceHandler).newSubscriptionHandler))
+	subsrouter.Methods("GET").Path("").HandlerFunc(route((*webhookServiceHandler).allSubscriptionsHandler))
+	subsrouter.Methods("GET").Path("/{subscriptionID}").HandlerFunc(route((*webhookServiceHandler).findSubscriptionHandler))
+	subsrouter.Methods("PUT").Path("/{subscriptionID}").HandlerFunc(route((*webhookServiceHandler).updateSubscriptionHandler))
+	subsrouter.Methods("DELETE").Path("/{subscriptionID}").HandlerFunc(route((*webhookServiceHandler).deleteSubscriptionHandler))
+	//Here we implement the delivery log of a subscription (/subscriptions/{subscriptionID}/deliveries):
+	subsrouter.Methods("GET").Path("/{subscriptionID}/deliveries").HandlerFunc(route((*webhookServiceHandler).deliveriesHandler))
+
+	httpErrChan := make(chan error)
+	httpIsErrChan := make(chan error)
+
+	server := handlers.CORS()(r)
+	httpServer := &http.Server{Addr: endpoint, Handler: server}
+	httpsServer := &http.Server{Addr: tlsendpoint, Handler: server}
+	go func() {
+		if err := httpsServer.ListenAndServeTLS("cert.pem", "key.pem"); err != http.ErrServerClosed {
+			httpIsErrChan <- err
+		}
+	}()
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			httpErrChan <- err
+		}
+	}()
+	//When the service is asked to stop, it finishes the requests in flight before it exits.
+	go checker.ShutdownOnSignal(httpServer, httpsServer)
+
+	return httpErrChan, httpIsErrChan
+}
+
+func main() {
+	confPath := flag.String("config", "./webhooks-config.json", "path to config file")
+	flag.Parse()
+	config, err := configuration.ExtractConfiguration(*confPath)
+	if err != nil {
+		slog.Warn("could not read the configuration", "error", err)
+	}
+	if err := logging.Init("webhooks", config.LogFormat, config.LogLevel); err != nil {
+		logging.Fatal("could not set up logging", "error", err)
+	}
+	if err := tracing.Init("webhooks", config.TracingExporter, config.TracingEndpoint); err != nil {
+		logging.Fatal("could not set up tracing", "error", err)
+	}
+
+	configMap := make(map[string]interface{})
+	configMap["connection"] = config.DBConnection
+	configMap["region"] = config.AWSRegion
+	dbhandler, err := dblayer.NewPersistenceLayer(config.Databasetype, configMap)
+	if err != nil {
+		slog.Error("could not connect to the database", "error", err)
+	} else {
+		slog.Info("connected to the database")
+	}
+
+	conn := msgqueue_amqp.NewAMQPConnection(config.AMQPMessageBroker)
+	eventListener, err := msgqueue_amqp.NewAMQPEventListener(conn, "myevents", "webhooks")
+	if err != nil {
+		slog.Error("could not create the event listener", "error", err)
+	}
+
+	dispatcher := webhooks.NewDispatcher(dbhandler)
+	dispatcher.Client = webhooks.NewPublicClient(10 * time.Second)
+	dispatcher.MaxAttempts = config.WebhookMaxAttempts
+	dispatcher.RetryDelay = time.Duration(config.WebhookRetrySeconds) * time.Second
+	dispatcher.DisableAfter = config.WebhookDisableAfter
+	//Deliveries that could not be posted right away are retried from here.
+	go dispatcher.Run(dispatcher.RetryDelay / 2)
+
+	//The orchestrator learns from these checks whether the service is ready to take requests.
+	checker := health.NewChecker()
+	checker.DrainDelay = time.Duration(config.ShutdownDrainSeconds) * time.Second
+	checker.AddReadiness("database", func() error { return persistence.Ping(dbhandler) })
+	checker.AddReadiness("broker", conn.Check)
+
+	processor := &listener.EventProcessor{EventListener: eventListener, Dispatcher: dispatcher}
+	//Without its listener, the service would never learn about the events of the others.
+	checker.Go("listener", processor.ProcessEvents)
+
+	httpErrChan, httpIsErrChan := ServeAPI(config.RestfulEndpoint, config.RestfulTLSEndpoint, dbhandler, checker)
+	slog.Info("listening for http connections", "endpoint", config.RestfulEndpoint, "tls_endpoint", config.RestfulTLSEndpoint)
+
+	select {
+	case err := <-httpErrChan:
+		logging.Fatal("http server failed", "error", err)
+	case err := <-httpIsErrChan:
+		logging.Fatal("https server failed", "error", err)
+	case <-checker.Stopped():
+		tracing.Flush()
+		slog.Info("stopped")
+	}
+}